@@ -51,8 +51,8 @@ func (nv *numValue) Set(s string) error {
 }
 
 func init() {
-	flag.Var(&nsqdHTTPAddrs, "nsqd-http-address", "nsqd HTTP address (may be given multiple times)")
-	flag.Var(&lookupdHTTPAddrs, "lookupd-http-address", "lookupd HTTP address (may be given multiple times)")
+	flag.Var(&nsqdHTTPAddrs, "nsqd-http-address", "nsqd HTTP address, <addr>:<port> or unix:///path/to/socket (may be given multiple times)")
+	flag.Var(&lookupdHTTPAddrs, "lookupd-http-address", "lookupd HTTP address, <addr>:<port> or unix:///path/to/socket (may be given multiple times)")
 	flag.Var(&countNum, "count", "number of reports")
 }
 
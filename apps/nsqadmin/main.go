@@ -40,7 +40,19 @@ func nsqadminFlagSet(opts *nsqadmin.Options) *flag.FlagSet {
 	flagSet.String("statsd-prefix", opts.StatsdPrefix, "prefix used for keys sent to statsd (%s for host replacement, must match nsqd)")
 	flagSet.Duration("statsd-interval", opts.StatsdInterval, "time interval nsqd is configured to push to statsd (must match nsqd)")
 
+	flagSet.String("history-data-path", opts.HistoryDataPath, "directory to keep the built-in topic/channel history in (disabled when empty)")
+	flagSet.Duration("history-interval", opts.HistoryInterval, "time interval of the built-in history samples")
+	flagSet.Duration("history-retention", opts.HistoryRetention, "how long the built-in history is kept")
+	flagSet.String("alert-rules-file", opts.AlertRulesFile, "path to a JSON file of alert rules evaluated against the cluster (disabled when empty)")
+	flagSet.Duration("alert-interval", opts.AlertInterval, "time interval between evaluations of the alert rules")
+
 	flagSet.String("notification-http-endpoint", "", "HTTP endpoint (fully qualified) to which POST notifications of admin actions will be sent")
+	flagSet.Int("notification-max-attempts", opts.NotificationMaxAttempts, "number of attempts to POST a notification before it is dropped")
+	flagSet.Duration("notification-retry-backoff", opts.NotificationRetryBackoff, "time to wait before retrying a notification (doubled on every attempt, up to 1m)")
+
+	flagSet.String("audit-log-path", opts.AuditLogPath, "path of an append-only log (JSON lines) of admin actions, served at /api/audit (disabled when empty)")
+	flagSet.Int64("audit-log-max-size", opts.AuditLogMaxSize, "size in bytes at which the audit log is rotated to <audit-log-path>.<timestamp>")
+	flagSet.Int("audit-log-max-files", opts.AuditLogMaxFiles, "number of rotated audit log files to keep (0 keeps all of them)")
 
 	flagSet.Duration("http-client-connect-timeout", opts.HTTPClientConnectTimeout, "timeout for HTTP connect")
 	flagSet.Duration("http-client-request-timeout", opts.HTTPClientRequestTimeout, "timeout for HTTP request")
@@ -52,11 +64,28 @@ func nsqadminFlagSet(opts *nsqadmin.Options) *flag.FlagSet {
 
 	flagSet.String("allow-config-from-cidr", opts.AllowConfigFromCIDR, "A CIDR from which to allow HTTP requests to the /config endpoint")
 	flagSet.String("acl-http-header", opts.ACLHTTPHeader, "HTTP header to check for authenticated admin users")
+	flagSet.String("htpasswd-file", opts.HTPasswdFile, "path to an htpasswd file ($apr1$ or {SHA} hashes) of users that may log in with basic auth")
+	flagSet.String("oidc-issuer-url", opts.OIDCIssuerURL, "OpenID Connect issuer URL to log users in with (authorization code flow)")
+	flagSet.String("oidc-client-id", opts.OIDCClientID, "OpenID Connect client ID")
+	flagSet.String("oidc-client-secret", opts.OIDCClientSecret, "OpenID Connect client secret")
+	flagSet.String("oidc-redirect-url", opts.OIDCRedirectURL, "OpenID Connect redirect URL (default: <scheme>://<host><base-path>/oauth2/callback of the request)")
+	flagSet.String("oidc-user-claim", opts.OIDCUserClaim, "ID token claim that identifies the user")
+	flagSet.String("session-secret", opts.SessionSecret, "secret that signs session cookies (random when empty, so sessions do not survive a restart)")
+	flagSet.Duration("session-lifetime", opts.SessionLifetime, "how long a session lasts after an OpenID Connect login")
+	flagSet.String("acl-policy-file", opts.ACLPolicyFile, "path to a JSON file granting users viewer, operator or admin roles on topics/channels matching regular expressions (instead of --admin-user)")
 
 	nsqlookupdHTTPAddresses := app.StringArray{}
-	flagSet.Var(&nsqlookupdHTTPAddresses, "lookupd-http-address", "lookupd HTTP address (may be given multiple times)")
+	flagSet.Var(&nsqlookupdHTTPAddresses, "lookupd-http-address", "lookupd HTTP address, <addr>:<port> or unix:///path/to/socket (may be given multiple times)")
 	nsqdHTTPAddresses := app.StringArray{}
-	flagSet.Var(&nsqdHTTPAddresses, "nsqd-http-address", "nsqd HTTP address (may be given multiple times)")
+	flagSet.Var(&nsqdHTTPAddresses, "nsqd-http-address", "nsqd HTTP address, <addr>:<port> or unix:///path/to/socket (may be given multiple times)")
+	flagSet.String("lookupd-http-admin-token", opts.NSQLookupdHTTPAdminToken, "bearer token of lookupd HTTP requests that modify registrations (see nsqlookupd --http-admin-token)")
+	flagSet.String("cluster-name", opts.ClusterName, "name of the cluster of --lookupd-http-address/--nsqd-http-address (the one history and alerts apply to)")
+	clusterNSQLookupdHTTPAddresses := app.StringArray{}
+	flagSet.Var(&clusterNSQLookupdHTTPAddresses, "cluster-lookupd-http-address", "<cluster>=<addr> lookupd HTTP address of another named cluster (may be given multiple times)")
+	clusterNSQDHTTPAddresses := app.StringArray{}
+	flagSet.Var(&clusterNSQDHTTPAddresses, "cluster-nsqd-http-address", "<cluster>=<addr> nsqd HTTP address of another named cluster (may be given multiple times)")
+	clusterNSQLookupdHTTPAdminTokens := app.StringArray{}
+	flagSet.Var(&clusterNSQLookupdHTTPAdminTokens, "cluster-lookupd-http-admin-token", "<cluster>=<token> --lookupd-http-admin-token of the lookupd of another named cluster (may be given multiple times)")
 	adminUsers := app.StringArray{}
 	flagSet.Var(&adminUsers, "admin-user", "admin user (may be given multiple times; if specified, only these users will be able to perform privileged actions; acl-http-header is used to determine the authenticated user)")
 
@@ -14,6 +14,7 @@ import (
 	"github.com/BurntSushi/toml"
 	"github.com/judwhite/go-svc"
 	"github.com/mreiferson/go-options"
+	"github.com/nsqio/nsq/internal/dirlock"
 	"github.com/nsqio/nsq/internal/lg"
 	"github.com/nsqio/nsq/internal/version"
 	"github.com/nsqio/nsq/nsqd"
@@ -57,6 +58,12 @@ func (p *program) Init(env svc.Environment) error {
 
 	options.Resolve(opts, flagSet, cfg)
 
+	checkData := flagSet.Lookup("check-data").Value.(flag.Getter).Get().(bool)
+	repairData := flagSet.Lookup("repair-data").Value.(flag.Getter).Get().(bool)
+	if checkData || repairData {
+		os.Exit(runDataCheck(opts, repairData))
+	}
+
 	p.onClose = func() {}
 	if opts.PIDFile != "" {
 		os.WriteFile(opts.PIDFile, []byte(strconv.Itoa(os.Getpid())), 0644)
@@ -112,6 +119,63 @@ func (p *program) Context() context.Context {
 	return p.nsqd.Context()
 }
 
+// runDataCheck checks (and optionally repairs) the data path, printing a
+// report, and returns the exit status
+func runDataCheck(opts *nsqd.Options, repair bool) int {
+	dataPath := opts.DataPath
+	if dataPath == "" {
+		dataPath, _ = os.Getwd()
+	}
+	dl := dirlock.New(dataPath)
+	err := dl.Lock()
+	if err != nil {
+		logFatal("failed to lock data-path (is nsqd running?) - %s", err)
+	}
+	defer dl.Unlock()
+
+	report, err := nsqd.CheckData(opts, repair)
+	if err != nil {
+		logFatal("failed to check data-path - %s", err)
+	}
+
+	if report.MetadataErr != "" {
+		fmt.Printf("metadata: %s\n", report.MetadataErr)
+	}
+	for _, q := range report.Queues {
+		status := "OK"
+		if !q.OK() {
+			status = "CORRUPT"
+			if q.Repaired {
+				status = "REPAIRED"
+			}
+		}
+		fmt.Printf("%-40s %-8s files: %-4d msgs: %-8d depth: %-8d corrupt: %-4d lost-bytes: %-8d stale-files: %-4d bad-files: %d\n",
+			q.Name, status, q.Files, q.Messages, q.Depth, len(q.Corrupt), q.LostBytes, q.StaleFiles, q.BadFiles)
+		if q.MetadataErr != "" {
+			fmt.Printf("    %s\n", q.MetadataErr)
+		}
+		for _, c := range q.Corrupt {
+			fmt.Printf("    %s offset %d: %s\n", c.File, c.Offset, c.Reason)
+		}
+		if q.RepairErr != "" {
+			fmt.Printf("    repair failed: %s\n", q.RepairErr)
+		}
+	}
+
+	if report.OK() {
+		return 0
+	}
+	if !repair {
+		return 1
+	}
+	for _, q := range report.Queues {
+		if q.RepairErr != "" {
+			return 1
+		}
+	}
+	return 0
+}
+
 func logFatal(f string, args ...interface{}) {
 	lg.LogFatal("[nsqd] ", f, args...)
 }
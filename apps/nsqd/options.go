@@ -141,7 +141,10 @@ func nsqdFlagSet(opts *nsqd.Options) *flag.FlagSet {
 	flagSet.Int("broadcast-tcp-port", opts.BroadcastTCPPort, "TCP port that will be registered with lookupd (defaults to the TCP port that this nsqd is listening on)")
 	flagSet.Int("broadcast-http-port", opts.BroadcastHTTPPort, "HTTP port that will be registered with lookupd (defaults to the HTTP port that this nsqd is listening on)")
 	lookupdTCPAddrs := app.StringArray{}
-	flagSet.Var(&lookupdTCPAddrs, "lookupd-tcp-address", "lookupd TCP address (may be given multiple times)")
+	flagSet.Var(&lookupdTCPAddrs, "lookupd-tcp-address", "lookupd TCP address or unix:///path/to/socket (may be given multiple times)")
+	labels := app.StringArray{}
+	flagSet.Var(&labels, "label", "<key>=<value> label advertised to lookupd, e.g. zone=us-east-1a (may be given multiple times)")
+	flagSet.Bool("provision-from-lookupd", opts.ProvisionFromLookupd, "create the topics and channels created on lookupd (whose selector matches --label)")
 	flagSet.Duration("http-client-connect-timeout", opts.HTTPClientConnectTimeout, "timeout for HTTP connect")
 	flagSet.Duration("http-client-request-timeout", opts.HTTPClientRequestTimeout, "timeout for HTTP request")
 
@@ -152,6 +155,40 @@ func nsqdFlagSet(opts *nsqd.Options) *flag.FlagSet {
 	flagSet.Int64("max-bytes-per-queue", opts.MaxBytesPerQueue, "number of bytes per topic and per channel")
 	flagSet.Int64("sync-every", opts.SyncEvery, "number of messages per diskqueue fsync")
 	flagSet.Duration("sync-timeout", opts.SyncTimeout, "duration of time per diskqueue fsync")
+	flagSet.Bool("check-data", false, "check the metadata and diskqueue files in --data-path for corruption and exit")
+	flagSet.Bool("repair-data", false, "check --data-path and rewrite queues without their corrupted records, then exit")
+	flagSet.Bool("check-data-on-startup", opts.CheckDataOnStartup, "check and repair --data-path before loading topics and channels")
+	flagSet.String("encryption-key-file", opts.EncryptionKeyFile, "path to a file of '<id>:<base64 32 byte key>' lines used to encrypt messages written to disk (highest id encrypts, all decrypt)")
+	flagSet.String("backend-compression", opts.BackendCompression, "compression of messages written to disk: 'none', 'snappy' or 'zstd'")
+	topicBackendCompression := app.StringArray{}
+	flagSet.Var(&topicBackendCompression, "topic-backend-compression", "<topic>:<compression> to override --backend-compression for a topic and its channels (may be given multiple times)")
+
+	// disk space options
+	flagSet.Int64("max-bytes-data-path", opts.MaxBytesDataPath, "number of bytes of diskqueue files in the data path before the disk is considered full (0 = no limit)")
+	flagSet.Int64("min-free-disk-bytes", opts.MinFreeDiskBytes, "number of free bytes on the data path filesystem below which the disk is considered full (0 = disabled)")
+	flagSet.String("disk-full-policy", opts.DiskFullPolicy, "behavior when the disk is full: 'reject' publishes, 'drop-oldest' backend messages (reclaiming the space of a diskqueue file once all of its messages are dropped) or 'memory-only'")
+	flagSet.Duration("disk-check-interval", opts.DiskCheckInterval, "duration between disk space checks of the data path")
+
+	// max depth options
+	flagSet.Int64("max-depth", opts.MaxDepth, "number of messages queued in each topic and channel before --depth-policy applies (0 = no limit)")
+	flagSet.Int64("max-depth-bytes", opts.MaxDepthBytes, "number of bytes of messages in the diskqueue of each topic and channel before --depth-policy applies (0 = no limit)")
+	flagSet.String("depth-policy", opts.DepthPolicy, "behavior when a topic or channel is at max depth: 'reject' publishes, 'drop-oldest' or 'drop-newest' messages")
+	topicMaxDepths := app.StringArray{}
+	flagSet.Var(&topicMaxDepths, "topic-max-depth", "<topic>:<messages>:<bytes>[:<policy>] max depth of a topic overriding --max-depth (may be given multiple times)")
+	channelMaxDepths := app.StringArray{}
+	flagSet.Var(&channelMaxDepths, "channel-max-depth", "<topic>/<channel>:<messages>:<bytes>[:<policy>] max depth of a channel overriding --max-depth (may be given multiple times)")
+
+	// partitioned delivery options
+	channelPartitions := app.StringArray{}
+	flagSet.Var(&channelPartitions, "channel-partitions", "<topic>/<channel>:<partitions> delivers messages of the channel with the same partition key to the same client (may be given multiple times)")
+
+	// publish rate limit options
+	flagSet.Int64("pub-rate-limit", opts.PubRateLimit, "messages per second all publishers together may publish (0 = no limit)")
+	flagSet.Int64("pub-byte-rate-limit", opts.PubByteRateLimit, "message bytes per second all publishers together may publish (0 = no limit)")
+	topicPubRateLimits := app.StringArray{}
+	flagSet.Var(&topicPubRateLimits, "topic-pub-rate-limit", "<topic>:<msgs/sec>:<bytes/sec> limit of a topic across all publishers (may be given multiple times, 0 = no limit)")
+	identityPubRateLimits := app.StringArray{}
+	flagSet.Var(&identityPubRateLimits, "identity-pub-rate-limit", "<identity>:<msgs/sec>:<bytes/sec> limit across all connections of an auth identity (may be given multiple times, 0 = no limit)")
 
 	flagSet.Int("queue-scan-worker-pool-max", opts.QueueScanWorkerPoolMax, "max concurrency for checking in-flight and deferred message timeouts")
 	flagSet.Int("queue-scan-selection-count", opts.QueueScanSelectionCount, "number of channels to check per cycle (every 100ms) for in-flight and deferred timeouts")
@@ -195,10 +232,20 @@ func nsqdFlagSet(opts *nsqd.Options) *flag.FlagSet {
 	flagSet.Var(&tlsRequired, "tls-required", "require TLS for client connections (true, false, tcp-https)")
 	flagSet.Var(&tlsMinVersion, "tls-min-version", "minimum SSL/TLS version acceptable ('ssl3.0', 'tls1.0', 'tls1.1', 'tls1.2' or 'tls1.3')")
 
+	// nsqlookupd connections
+	flagSet.Bool("lookupd-tls", opts.LookupdTLS, "connect to lookupd TCP addresses over TLS")
+	flagSet.String("lookupd-tls-root-ca-file", opts.LookupdTLSRootCAFile, "path to certificate authority file to verify lookupd")
+	flagSet.String("lookupd-tls-cert", opts.LookupdTLSCert, "path to certificate file presented to lookupd")
+	flagSet.String("lookupd-tls-key", opts.LookupdTLSKey, "path to key file presented to lookupd")
+	flagSet.Bool("lookupd-tls-insecure-skip-verify", opts.LookupdTLSInsecureSkipVerify, "skip verification of the lookupd TLS certificate")
+	flagSet.String("lookupd-auth-secret", opts.LookupdAuthSecret, "shared secret to authenticate with lookupd (see nsqlookupd --auth-secret)")
+
 	// compression
 	flagSet.Bool("deflate", opts.DeflateEnabled, "enable deflate feature negotiation (client compression)")
 	flagSet.Int("max-deflate-level", opts.MaxDeflateLevel, "max deflate compression level a client can negotiate (> values == > nsqd CPU usage)")
 	flagSet.Bool("snappy", opts.SnappyEnabled, "enable snappy feature negotiation (client compression)")
+	flagSet.Bool("zstd", opts.ZstdEnabled, "enable zstd feature negotiation (client compression)")
+	flagSet.Int("max-zstd-level", opts.MaxZstdLevel, "max zstd compression level a client can negotiate (> values == > nsqd CPU usage)")
 
 	return flagSet
 }
@@ -11,6 +11,7 @@ import (
 	"github.com/BurntSushi/toml"
 	"github.com/judwhite/go-svc"
 	"github.com/mreiferson/go-options"
+	"github.com/nsqio/nsq/internal/app"
 	"github.com/nsqio/nsq/internal/lg"
 	"github.com/nsqio/nsq/internal/version"
 	"github.com/nsqio/nsq/nsqlookupd"
@@ -30,10 +31,23 @@ func nsqlookupdFlagSet(opts *nsqlookupd.Options) *flag.FlagSet {
 	flagSet.String("tcp-address", opts.TCPAddress, "<addr>:<port> to listen on for TCP clients")
 	flagSet.String("http-address", opts.HTTPAddress, "<addr>:<port> to listen on for HTTP clients")
 	flagSet.String("broadcast-address", opts.BroadcastAddress, "address of this lookupd node, (default to the OS hostname)")
+	flagSet.Bool("use-unix-sockets", opts.UseUnixSockets, "use UNIX sockets instead of IP sockets (--tcp-address and --http-address are socket paths)")
 
 	flagSet.Duration("inactive-producer-timeout", opts.InactiveProducerTimeout, "duration of time a producer will remain in the active list since its last ping")
 	flagSet.Duration("tombstone-lifetime", opts.TombstoneLifetime, "duration of time a producer will remain tombstoned if registration remains")
 
+	flagSet.String("tls-cert", opts.TLSCert, "path to certificate file, the TCP listener only accepts TLS connections when set")
+	flagSet.String("tls-key", opts.TLSKey, "path to key file")
+	flagSet.String("tls-client-auth-policy", opts.TLSClientAuthPolicy, "client certificate auth policy ('require' or 'require-verify')")
+	flagSet.String("tls-root-ca-file", opts.TLSRootCAFile, "path to certificate authority file to verify client certificates")
+	flagSet.String("auth-secret", opts.AuthSecret, "shared secret nsqd must IDENTIFY with to register producers (see nsqd --lookupd-auth-secret)")
+	flagSet.String("http-admin-token", opts.HTTPAdminToken, "bearer token required by the HTTP endpoints that modify registrations and by /watch and /snapshot (also sent to --peer-http-address)")
+
+	flagSet.String("data-path", opts.DataPath, "path to persist registrations and tombstones across restarts (disabled if empty)")
+	flagSet.Duration("snapshot-interval", opts.SnapshotInterval, "duration of time between persisting registrations and syncing with peers")
+	peerHTTPAddresses := app.StringArray{}
+	flagSet.Var(&peerHTTPAddresses, "peer-http-address", "<addr>:<port> of a peer nsqlookupd to sync registrations with (may be given multiple times)")
+
 	return flagSet
 }
 
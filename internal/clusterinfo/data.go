@@ -1,9 +1,12 @@
 package clusterinfo
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"net/http"
 	"net/url"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
@@ -37,6 +40,9 @@ func (l ErrList) Errors() []error {
 type ClusterInfo struct {
 	log    lg.AppLogFunc
 	client *http_api.Client
+
+	// sent with the requests that modify nsqlookupd registrations
+	lookupdHeader http.Header
 }
 
 func New(log lg.AppLogFunc, client *http_api.Client) *ClusterInfo {
@@ -46,6 +52,15 @@ func New(log lg.AppLogFunc, client *http_api.Client) *ClusterInfo {
 	}
 }
 
+// SetLookupdAdminToken sets the bearer token of the requests that modify
+// nsqlookupd registrations (see nsqlookupd --http-admin-token)
+func (c *ClusterInfo) SetLookupdAdminToken(token string) {
+	c.lookupdHeader = nil
+	if token != "" {
+		c.lookupdHeader = http.Header{"Authorization": []string{"Bearer " + token}}
+	}
+}
+
 func (c *ClusterInfo) logf(f string, args ...interface{}) {
 	if c.log != nil {
 		c.log(lg.INFO, f, args...)
@@ -54,7 +69,7 @@ func (c *ClusterInfo) logf(f string, args ...interface{}) {
 
 // GetVersion returns a semver.Version object by querying /info
 func (c *ClusterInfo) GetVersion(addr string) (semver.Version, error) {
-	endpoint := fmt.Sprintf("http://%s/info", addr)
+	endpoint := http_api.Endpoint(addr, "/info")
 	var resp struct {
 		Version string `json:"version"`
 	}
@@ -85,7 +100,7 @@ func (c *ClusterInfo) GetLookupdTopics(lookupdHTTPAddrs []string) ([]string, err
 		go func(addr string) {
 			defer wg.Done()
 
-			endpoint := fmt.Sprintf("http://%s/topics", addr)
+			endpoint := http_api.Endpoint(addr, "/topics")
 			c.logf("CI: querying nsqlookupd %s", endpoint)
 
 			var resp respType
@@ -134,7 +149,7 @@ func (c *ClusterInfo) GetLookupdTopicChannels(topic string, lookupdHTTPAddrs []s
 		go func(addr string) {
 			defer wg.Done()
 
-			endpoint := fmt.Sprintf("http://%s/channels?topic=%s", addr, url.QueryEscape(topic))
+			endpoint := http_api.Endpoint(addr, fmt.Sprintf("/channels?topic=%s", url.QueryEscape(topic)))
 			c.logf("CI: querying nsqlookupd %s", endpoint)
 
 			var resp respType
@@ -185,7 +200,7 @@ func (c *ClusterInfo) GetLookupdProducers(lookupdHTTPAddrs []string) (Producers,
 		go func(addr string) {
 			defer wg.Done()
 
-			endpoint := fmt.Sprintf("http://%s/nodes", addr)
+			endpoint := http_api.Endpoint(addr, "/nodes")
 			c.logf("CI: querying nsqlookupd %s", endpoint)
 
 			var resp respType
@@ -238,6 +253,12 @@ func (c *ClusterInfo) GetLookupdProducers(lookupdHTTPAddrs []string) (Producers,
 // GetLookupdTopicProducers returns Producers of all the nsqd for a given topic by
 // unioning the nodes returned from the given lookupd
 func (c *ClusterInfo) GetLookupdTopicProducers(topic string, lookupdHTTPAddrs []string) (Producers, error) {
+	return c.GetLookupdTopicProducersBySelector(topic, "", lookupdHTTPAddrs)
+}
+
+// GetLookupdTopicProducersBySelector is GetLookupdTopicProducers limited to
+// the nsqd with labels matching the given nsqlookupd label selector
+func (c *ClusterInfo) GetLookupdTopicProducersBySelector(topic string, selector string, lookupdHTTPAddrs []string) (Producers, error) {
 	var producers Producers
 	var lock sync.Mutex
 	var wg sync.WaitGroup
@@ -252,7 +273,10 @@ func (c *ClusterInfo) GetLookupdTopicProducers(topic string, lookupdHTTPAddrs []
 		go func(addr string) {
 			defer wg.Done()
 
-			endpoint := fmt.Sprintf("http://%s/lookup?topic=%s", addr, url.QueryEscape(topic))
+			endpoint := http_api.Endpoint(addr, fmt.Sprintf("/lookup?topic=%s", url.QueryEscape(topic)))
+			if selector != "" {
+				endpoint += "&selector=" + url.QueryEscape(selector)
+			}
 			c.logf("CI: querying nsqlookupd %s", endpoint)
 
 			var resp respType
@@ -306,7 +330,7 @@ func (c *ClusterInfo) GetNSQDTopics(nsqdHTTPAddrs []string) ([]string, error) {
 		go func(addr string) {
 			defer wg.Done()
 
-			endpoint := fmt.Sprintf("http://%s/stats?format=json", addr)
+			endpoint := http_api.Endpoint(addr, "/stats?format=json")
 			c.logf("CI: querying nsqd %s", endpoint)
 
 			var resp respType
@@ -352,6 +376,8 @@ func (c *ClusterInfo) GetNSQDProducers(nsqdHTTPAddrs []string) (Producers, error
 		Hostname         string `json:"hostname"`
 		HTTPPort         int    `json:"http_port"`
 		TCPPort          int    `json:"tcp_port"`
+		HTTPSocket       string `json:"http_socket"`
+		TCPSocket        string `json:"tcp_socket"`
 	}
 
 	type statsRespType struct {
@@ -365,7 +391,7 @@ func (c *ClusterInfo) GetNSQDProducers(nsqdHTTPAddrs []string) (Producers, error
 		go func(addr string) {
 			defer wg.Done()
 
-			endpoint := fmt.Sprintf("http://%s/info", addr)
+			endpoint := http_api.Endpoint(addr, "/info")
 			c.logf("CI: querying nsqd %s", endpoint)
 
 			var infoResp infoRespType
@@ -377,7 +403,7 @@ func (c *ClusterInfo) GetNSQDProducers(nsqdHTTPAddrs []string) (Producers, error
 				return
 			}
 
-			endpoint = fmt.Sprintf("http://%s/stats?format=json&include_clients=false", addr)
+			endpoint = http_api.Endpoint(addr, "/stats?format=json&include_clients=false")
 			c.logf("CI: querying nsqd %s", endpoint)
 
 			var statsResp statsRespType
@@ -408,6 +434,8 @@ func (c *ClusterInfo) GetNSQDProducers(nsqdHTTPAddrs []string) (Producers, error
 				Hostname:         infoResp.Hostname,
 				HTTPPort:         infoResp.HTTPPort,
 				TCPPort:          infoResp.TCPPort,
+				HTTPSocket:       infoResp.HTTPSocket,
+				TCPSocket:        infoResp.TCPSocket,
 				Topics:           producerTopics,
 			})
 		}(addr)
@@ -437,6 +465,8 @@ func (c *ClusterInfo) GetNSQDTopicProducers(topic string, nsqdHTTPAddrs []string
 		Hostname         string `json:"hostname"`
 		HTTPPort         int    `json:"http_port"`
 		TCPPort          int    `json:"tcp_port"`
+		HTTPSocket       string `json:"http_socket"`
+		TCPSocket        string `json:"tcp_socket"`
 	}
 
 	type statsRespType struct {
@@ -450,8 +480,8 @@ func (c *ClusterInfo) GetNSQDTopicProducers(topic string, nsqdHTTPAddrs []string
 		go func(addr string) {
 			defer wg.Done()
 
-			endpoint := fmt.Sprintf("http://%s/stats?format=json&topic=%s&include_clients=false",
-				addr, url.QueryEscape(topic))
+			endpoint := http_api.Endpoint(addr, fmt.Sprintf("/stats?format=json&topic=%s&include_clients=false",
+				url.QueryEscape(topic)))
 			c.logf("CI: querying nsqd %s", endpoint)
 
 			var statsResp statsRespType
@@ -470,7 +500,7 @@ func (c *ClusterInfo) GetNSQDTopicProducers(topic string, nsqdHTTPAddrs []string
 
 			for _, t := range statsResp.Topics {
 				if t.Name == topic {
-					endpoint := fmt.Sprintf("http://%s/info", addr)
+					endpoint := http_api.Endpoint(addr, "/info")
 					c.logf("CI: querying nsqd %s", endpoint)
 
 					var infoResp infoRespType
@@ -490,6 +520,9 @@ func (c *ClusterInfo) GetNSQDTopicProducers(topic string, nsqdHTTPAddrs []string
 					// if BroadcastAddress/HTTPPort are missing, use the values from `addr` for
 					// backwards compatibility
 
+					if infoResp.HTTPSocket == "" && strings.HasPrefix(addr, http_api.UnixSocketPrefix) {
+						infoResp.HTTPSocket = strings.TrimPrefix(addr, http_api.UnixSocketPrefix)
+					}
 					if infoResp.BroadcastAddress == "" {
 						var p string
 						infoResp.BroadcastAddress, p, _ = net.SplitHostPort(addr)
@@ -507,6 +540,8 @@ func (c *ClusterInfo) GetNSQDTopicProducers(topic string, nsqdHTTPAddrs []string
 						Hostname:         infoResp.Hostname,
 						HTTPPort:         infoResp.HTTPPort,
 						TCPPort:          infoResp.TCPPort,
+						HTTPSocket:       infoResp.HTTPSocket,
+						TCPSocket:        infoResp.TCPSocket,
 						Topics:           producerTopics,
 					})
 					lock.Unlock()
@@ -554,7 +589,7 @@ func (c *ClusterInfo) GetNSQDStats(producers Producers,
 
 			addr := p.HTTPAddress()
 
-			endpoint := fmt.Sprintf("http://%s/stats?format=json", addr)
+			endpoint := http_api.Endpoint(addr, "/stats?format=json")
 			if selectedTopic != "" {
 				endpoint += "&topic=" + url.QueryEscape(selectedTopic)
 				if selectedChannel != "" {
@@ -832,6 +867,190 @@ func (c *ClusterInfo) EmptyChannel(topicName string, channelName string, lookupd
 	return c.actionHelper(topicName, lookupdHTTPAddrs, nsqdHTTPAddrs, "channel/empty", qs)
 }
 
+// PublishMessage publishes a message to a topic on the given nsqd
+func (c *ClusterInfo) PublishMessage(topicName string, producer *Producer, body []byte) error {
+	endpoint := http_api.Endpoint(producer.HTTPAddress(), fmt.Sprintf("/pub?topic=%s", url.QueryEscape(topicName)))
+	c.logf("CI: querying nsqd %s", endpoint)
+	return c.client.POSTV1WithBody(endpoint, body)
+}
+
+// PeekChannel returns (at most) count of the messages waiting in a channel on
+// each of the given nsqd, without consuming them, and the number of messages
+// in memory the nsqd left out (they are only peeked while a channel is paused)
+func (c *ClusterInfo) PeekChannel(topicName string, channelName string, producers Producers, count int) ([]*PeekedMessage, int, error) {
+	var messages []*PeekedMessage
+	var inMemory int
+	var errs []error
+	for _, p := range producers {
+		endpoint := http_api.Endpoint(p.HTTPAddress(), fmt.Sprintf("/channel/peek?topic=%s&channel=%s&count=%d",
+			url.QueryEscape(topicName), url.QueryEscape(channelName), count))
+		c.logf("CI: querying nsqd %s", endpoint)
+
+		var resp struct {
+			Messages []*PeekedMessage `json:"messages"`
+			InMemory int              `json:"in_memory"`
+		}
+		err := c.client.GETV1(endpoint, &resp)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, m := range resp.Messages {
+			m.Node = p.HTTPAddress()
+			messages = append(messages, m)
+		}
+		inMemory += resp.InMemory
+	}
+	if len(errs) > 0 && len(errs) == len(producers) {
+		return nil, 0, fmt.Errorf("Failed to query any nsqd: %s", ErrList(errs))
+	}
+	if len(errs) > 0 {
+		return messages, inMemory, ErrList(errs)
+	}
+	return messages, inMemory, nil
+}
+
+// GetBulkTargets returns the topics matching topicRegex, or when channelRegex
+// is not nil their channels matching it, along with the nsqd they exist on
+//
+// A non-empty selector limits the nsqd to those with matching labels, which
+// requires nsqlookupd.
+func (c *ClusterInfo) GetBulkTargets(topicRegex *regexp.Regexp, channelRegex *regexp.Regexp, selector string,
+	lookupdHTTPAddrs []string, nsqdHTTPAddrs []string) ([]*BulkTarget, error) {
+	var errs []error
+
+	if selector != "" && len(lookupdHTTPAddrs) == 0 {
+		return nil, errors.New("label selectors require nsqlookupd")
+	}
+
+	var topics []string
+	var err error
+	if len(lookupdHTTPAddrs) != 0 {
+		topics, err = c.GetLookupdTopics(lookupdHTTPAddrs)
+	} else {
+		topics, err = c.GetNSQDTopics(nsqdHTTPAddrs)
+	}
+	if err != nil {
+		pe, ok := err.(PartialErr)
+		if !ok {
+			return nil, err
+		}
+		errs = append(errs, pe.Errors()...)
+	}
+
+	var targets []*BulkTarget
+	for _, topicName := range topics {
+		if topicRegex != nil && !topicRegex.MatchString(topicName) {
+			continue
+		}
+
+		var producers Producers
+		if len(lookupdHTTPAddrs) != 0 {
+			producers, err = c.GetLookupdTopicProducersBySelector(topicName, selector, lookupdHTTPAddrs)
+		} else {
+			producers, err = c.GetNSQDTopicProducers(topicName, nsqdHTTPAddrs)
+		}
+		if err != nil {
+			pe, ok := err.(PartialErr)
+			if !ok {
+				errs = append(errs, err)
+				continue
+			}
+			errs = append(errs, pe.Errors()...)
+		}
+		if len(producers) == 0 {
+			continue
+		}
+
+		if channelRegex == nil {
+			targets = append(targets, &BulkTarget{
+				Topic:     topicName,
+				Nodes:     producers.HTTPAddrs(),
+				Producers: producers,
+			})
+			continue
+		}
+
+		topicStats, _, err := c.GetNSQDStats(producers, topicName, "", false)
+		if err != nil {
+			pe, ok := err.(PartialErr)
+			if !ok {
+				errs = append(errs, err)
+				continue
+			}
+			errs = append(errs, pe.Errors()...)
+		}
+		channelTargets := make(map[string]*BulkTarget)
+		for _, ts := range topicStats {
+			for _, cs := range ts.Channels {
+				if !channelRegex.MatchString(cs.ChannelName) {
+					continue
+				}
+				t, ok := channelTargets[cs.ChannelName]
+				if !ok {
+					t = &BulkTarget{Topic: topicName, Channel: cs.ChannelName}
+					channelTargets[cs.ChannelName] = t
+					targets = append(targets, t)
+				}
+				t.Nodes = append(t.Nodes, ts.Node)
+				t.Producers = append(t.Producers, producers.Search(ts.Node))
+			}
+		}
+	}
+	sort.Sort(BulkTargets(targets))
+
+	if len(errs) > 0 {
+		return targets, ErrList(errs)
+	}
+	return targets, nil
+}
+
+// BulkAction pauses, unpauses, empties or deletes a target on its nsqd
+//
+// Deleting also removes the topic or channel from the given nsqlookupd, which
+// should be left empty when the target does not cover every nsqd.
+func (c *ClusterInfo) BulkAction(target *BulkTarget, action string, lookupdHTTPAddrs []string) error {
+	var errs []error
+
+	switch action {
+	case "pause", "unpause", "empty", "delete":
+	default:
+		return fmt.Errorf("invalid action %q", action)
+	}
+
+	uri := "topic/" + action
+	qs := fmt.Sprintf("topic=%s", url.QueryEscape(target.Topic))
+	if target.Channel != "" {
+		uri = "channel/" + action
+		qs += "&channel=" + url.QueryEscape(target.Channel)
+	}
+
+	if action == "delete" {
+		err := c.nsqlookupdPOST(lookupdHTTPAddrs, uri, qs)
+		if err != nil {
+			pe, ok := err.(PartialErr)
+			if !ok {
+				return err
+			}
+			errs = append(errs, pe.Errors()...)
+		}
+	}
+
+	err := c.producersPOST(target.Producers, uri, qs)
+	if err != nil {
+		pe, ok := err.(PartialErr)
+		if !ok {
+			return err
+		}
+		errs = append(errs, pe.Errors()...)
+	}
+
+	if len(errs) > 0 {
+		return ErrList(errs)
+	}
+	return nil
+}
+
 func (c *ClusterInfo) actionHelper(topicName string, lookupdHTTPAddrs []string, nsqdHTTPAddrs []string, uri string, qs string) error {
 	var errs []error
 
@@ -876,9 +1095,9 @@ func (c *ClusterInfo) GetTopicProducers(topicName string, lookupdHTTPAddrs []str
 func (c *ClusterInfo) nsqlookupdPOST(addrs []string, uri string, qs string) error {
 	var errs []error
 	for _, addr := range addrs {
-		endpoint := fmt.Sprintf("http://%s/%s?%s", addr, uri, qs)
+		endpoint := http_api.Endpoint(addr, fmt.Sprintf("/%s?%s", uri, qs))
 		c.logf("CI: querying nsqlookupd %s", endpoint)
-		err := c.client.POSTV1(endpoint)
+		err := c.client.POSTV1WithHeader(endpoint, c.lookupdHeader)
 		if err != nil {
 			errs = append(errs, err)
 		}
@@ -892,7 +1111,7 @@ func (c *ClusterInfo) nsqlookupdPOST(addrs []string, uri string, qs string) erro
 func (c *ClusterInfo) producersPOST(pl Producers, uri string, qs string) error {
 	var errs []error
 	for _, p := range pl {
-		endpoint := fmt.Sprintf("http://%s/%s?%s", p.HTTPAddress(), uri, qs)
+		endpoint := http_api.Endpoint(p.HTTPAddress(), fmt.Sprintf("/%s?%s", uri, qs))
 		c.logf("CI: querying nsqd %s", endpoint)
 		err := c.client.POSTV1(endpoint)
 		if err != nil {
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/blang/semver"
+	"github.com/nsqio/nsq/internal/http_api"
 	"github.com/nsqio/nsq/internal/quantile"
 )
 
@@ -29,6 +30,8 @@ type Producer struct {
 	BroadcastAddress string         `json:"broadcast_address"`
 	TCPPort          int            `json:"tcp_port"`
 	HTTPPort         int            `json:"http_port"`
+	TCPSocket        string         `json:"tcp_socket,omitempty"`
+	HTTPSocket       string         `json:"http_socket,omitempty"`
 	Version          string         `json:"version"`
 	VersionObj       semver.Version `json:"-"`
 	Topics           ProducerTopics `json:"topics"`
@@ -43,6 +46,8 @@ func (p *Producer) UnmarshalJSON(b []byte) error {
 		BroadcastAddress string   `json:"broadcast_address"`
 		TCPPort          int      `json:"tcp_port"`
 		HTTPPort         int      `json:"http_port"`
+		TCPSocket        string   `json:"tcp_socket"`
+		HTTPSocket       string   `json:"http_socket"`
 		Version          string   `json:"version"`
 		Topics           []string `json:"topics"`
 		Tombstoned       []bool   `json:"tombstones"`
@@ -56,6 +61,8 @@ func (p *Producer) UnmarshalJSON(b []byte) error {
 		BroadcastAddress: r.BroadcastAddress,
 		TCPPort:          r.TCPPort,
 		HTTPPort:         r.HTTPPort,
+		TCPSocket:        r.TCPSocket,
+		HTTPSocket:       r.HTTPSocket,
 		Version:          r.Version,
 	}
 	for i, t := range r.Topics {
@@ -76,11 +83,19 @@ func (p *Producer) Address() string {
 	return p.RemoteAddress
 }
 
+// HTTPAddress returns unix:///path/to/socket for an nsqd listening on a UNIX
+// socket
 func (p *Producer) HTTPAddress() string {
+	if p.HTTPSocket != "" {
+		return http_api.UnixSocketPrefix + p.HTTPSocket
+	}
 	return net.JoinHostPort(p.BroadcastAddress, strconv.Itoa(p.HTTPPort))
 }
 
 func (p *Producer) TCPAddress() string {
+	if p.TCPSocket != "" {
+		return http_api.UnixSocketPrefix + p.TCPSocket
+	}
 	return net.JoinHostPort(p.BroadcastAddress, strconv.Itoa(p.TCPPort))
 }
 
@@ -103,6 +118,14 @@ type TopicStats struct {
 	Channels     []*ChannelStats `json:"channels"`
 	Paused       bool            `json:"paused"`
 
+	// max depth of a node (0 = no limit), the aggregate only sums the counts
+	MaxDepth         int64  `json:"max_depth"`
+	MaxDepthBytes    int64  `json:"max_depth_bytes"`
+	DepthPolicy      string `json:"depth_policy"`
+	BackendBytes     int64  `json:"backend_bytes"`
+	DepthRejectCount int64  `json:"depth_reject_count"`
+	DepthDropCount   int64  `json:"depth_drop_count"`
+
 	E2eProcessingLatency *quantile.E2eProcessingLatencyAggregate `json:"e2e_processing_latency"`
 }
 
@@ -112,6 +135,9 @@ func (t *TopicStats) Add(a *TopicStats) {
 	t.MemoryDepth += a.MemoryDepth
 	t.BackendDepth += a.BackendDepth
 	t.MessageCount += a.MessageCount
+	t.BackendBytes += a.BackendBytes
+	t.DepthRejectCount += a.DepthRejectCount
+	t.DepthDropCount += a.DepthDropCount
 	if a.Paused {
 		t.Paused = a.Paused
 	}
@@ -157,9 +183,39 @@ type ChannelStats struct {
 	Clients       []*ClientStats  `json:"clients"`
 	Paused        bool            `json:"paused"`
 
+	// max depth of a node (0 = no limit), the aggregate only sums the counts
+	MaxDepth         int64  `json:"max_depth"`
+	MaxDepthBytes    int64  `json:"max_depth_bytes"`
+	DepthPolicy      string `json:"depth_policy"`
+	BackendBytes     int64  `json:"backend_bytes"`
+	DepthRejectCount int64  `json:"depth_reject_count"`
+	DepthDropCount   int64  `json:"depth_drop_count"`
+
+	// the oldest of the nodes (0 when they are empty)
+	OldestMessageTimestamp int64 `json:"oldest_message_timestamp"`
+
+	// set by nsqadmin once it has successive samples of the channel
+	Lag *ChannelLag `json:"lag,omitempty"`
+
 	E2eProcessingLatency *quantile.E2eProcessingLatencyAggregate `json:"e2e_processing_latency"`
 }
 
+// ChannelLag is how far behind the consumers of a channel are
+//
+// Rates are messages per second over Window seconds (0 when there is only one
+// sample yet), durations are in nanoseconds and DrainETA is -1 when the depth
+// is not going down.
+type ChannelLag struct {
+	TopicName        string  `json:"topic_name"`
+	ChannelName      string  `json:"channel_name"`
+	Depth            int64   `json:"depth"`
+	Window           float64 `json:"window"`
+	PublishRate      float64 `json:"publish_rate"`
+	ConsumeRate      float64 `json:"consume_rate"`
+	OldestMessageAge int64   `json:"oldest_message_age"`
+	DrainETA         int64   `json:"drain_eta"`
+}
+
 func (c *ChannelStats) Add(a *ChannelStats) {
 	c.Node = "*"
 	c.Depth += a.Depth
@@ -171,6 +227,13 @@ func (c *ChannelStats) Add(a *ChannelStats) {
 	c.TimeoutCount += a.TimeoutCount
 	c.MessageCount += a.MessageCount
 	c.ClientCount += a.ClientCount
+	c.BackendBytes += a.BackendBytes
+	c.DepthRejectCount += a.DepthRejectCount
+	c.DepthDropCount += a.DepthDropCount
+	if a.OldestMessageTimestamp > 0 &&
+		(c.OldestMessageTimestamp == 0 || a.OldestMessageTimestamp < c.OldestMessageTimestamp) {
+		c.OldestMessageTimestamp = a.OldestMessageTimestamp
+	}
 	if a.Paused {
 		c.Paused = a.Paused
 	}
@@ -205,6 +268,7 @@ type ClientStats struct {
 	SampleRate        int32         `json:"sample_rate"`
 	Deflate           bool          `json:"deflate"`
 	Snappy            bool          `json:"snappy"`
+	Zstd              bool          `json:"zstd"`
 	Authed            bool          `json:"authed"`
 	AuthIdentity      string        `json:"auth_identity"`
 	AuthIdentityURL   string        `json:"auth_identity_url"`
@@ -275,6 +339,39 @@ func (c TopicStatsByHost) Less(i, j int) bool {
 	return c.TopicStatsList[i].Hostname < c.TopicStatsList[j].Hostname
 }
 
+// PeekedMessage is a message waiting in a channel returned by nsqd's
+// /channel/peek
+type PeekedMessage struct {
+	Node          string `json:"node"`
+	ID            string `json:"id"`
+	Body          []byte `json:"body"`
+	Timestamp     int64  `json:"timestamp"`
+	Attempts      uint16 `json:"attempts"`
+	PartitionKey  string `json:"partition_key,omitempty"`
+	ReplyTo       string `json:"reply_to,omitempty"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+// BulkTarget is a topic, or a channel when Channel is set, a bulk action
+// applies to and the nsqd it applies on
+type BulkTarget struct {
+	Topic     string    `json:"topic"`
+	Channel   string    `json:"channel,omitempty"`
+	Nodes     []string  `json:"nodes"`
+	Producers Producers `json:"-"`
+}
+
+type BulkTargets []*BulkTarget
+
+func (t BulkTargets) Len() int      { return len(t) }
+func (t BulkTargets) Swap(i, j int) { t[i], t[j] = t[j], t[i] }
+func (t BulkTargets) Less(i, j int) bool {
+	if t[i].Topic == t[j].Topic {
+		return t[i].Channel < t[j].Channel
+	}
+	return t[i].Topic < t[j].Topic
+}
+
 type Producers []*Producer
 
 func (t Producers) Len() int      { return len(t) }
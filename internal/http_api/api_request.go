@@ -1,7 +1,10 @@
 package http_api
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -12,15 +15,57 @@ import (
 	"time"
 )
 
+// UnixSocketPrefix marks an HTTP address as the path of a UNIX socket
+const UnixSocketPrefix = "unix://"
+
+// the host of the URLs of a UNIX socket is the hex encoded path with this
+// suffix, as a path is not a valid URL host
+const unixSocketHostSuffix = ".unix-socket"
+
+// Endpoint returns the URL of uri (a path and query string) on an HTTP
+// address, which may be a UNIX socket given as unix:///path/to/socket
+func Endpoint(addr string, uri string) string {
+	if strings.HasPrefix(addr, UnixSocketPrefix) {
+		path := strings.TrimPrefix(addr, UnixSocketPrefix)
+		addr = hex.EncodeToString([]byte(path)) + unixSocketHostSuffix
+	}
+	return "http://" + addr + uri
+}
+
+// unixSocketPath returns the path of the UNIX socket a host:port (of an
+// Endpoint URL) stands for
+func unixSocketPath(addr string) (string, bool) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if !strings.HasSuffix(host, unixSocketHostSuffix) {
+		return "", false
+	}
+	path, err := hex.DecodeString(strings.TrimSuffix(host, unixSocketHostSuffix))
+	if err != nil {
+		return "", false
+	}
+	return string(path), true
+}
+
 // A custom http.Transport with support for deadline timeouts
+//
+// It dials the UNIX socket of the URLs Endpoint returns for unix:// addresses.
 func NewDeadlineTransport(connectTimeout time.Duration, requestTimeout time.Duration) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   connectTimeout,
+		KeepAlive: 30 * time.Second,
+		DualStack: true,
+	}
 	// arbitrary values copied from http.DefaultTransport
 	transport := &http.Transport{
-		DialContext: (&net.Dialer{
-			Timeout:   connectTimeout,
-			KeepAlive: 30 * time.Second,
-			DualStack: true,
-		}).DialContext,
+		DialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			if path, ok := unixSocketPath(addr); ok {
+				return dialer.DialContext(ctx, "unix", path)
+			}
+			return dialer.DialContext(ctx, network, addr)
+		},
 		ResponseHeaderTimeout: requestTimeout,
 		MaxIdleConns:          100,
 		IdleConnTimeout:       90 * time.Second,
@@ -47,12 +92,20 @@ func NewClient(tlsConfig *tls.Config, connectTimeout time.Duration, requestTimeo
 // GETV1 is a helper function to perform a V1 HTTP request
 // and parse our NSQ daemon's expected response format, with deadlines.
 func (c *Client) GETV1(endpoint string, v interface{}) error {
+	return c.GETV1WithHeader(endpoint, nil, v)
+}
+
+// GETV1WithHeader is GETV1 with additional request headers
+func (c *Client) GETV1WithHeader(endpoint string, header http.Header, v interface{}) error {
 retry:
 	req, err := http.NewRequest("GET", endpoint, nil)
 	if err != nil {
 		return err
 	}
 
+	for k, v := range header {
+		req.Header[k] = v
+	}
 	req.Header.Add("Accept", "application/vnd.nsq; version=1.0")
 
 	resp, err := c.c.Do(req)
@@ -66,7 +119,7 @@ retry:
 		return err
 	}
 	if resp.StatusCode != 200 {
-		if resp.StatusCode == 403 && !strings.HasPrefix(endpoint, "https") {
+		if resp.StatusCode == 403 && !strings.HasPrefix(endpoint, "https") && !isUnixSocketEndpoint(endpoint) {
 			endpoint, err = httpsEndpoint(endpoint, body)
 			if err != nil {
 				return err
@@ -86,12 +139,29 @@ retry:
 // PostV1 is a helper function to perform a V1 HTTP request
 // and parse our NSQ daemon's expected response format, with deadlines.
 func (c *Client) POSTV1(endpoint string) error {
+	return c.POSTV1WithHeader(endpoint, nil)
+}
+
+// POSTV1WithHeader is POSTV1 with additional request headers
+func (c *Client) POSTV1WithHeader(endpoint string, header http.Header) error {
+	return c.postV1(endpoint, header, nil)
+}
+
+// POSTV1WithBody is POSTV1 with a request body
+func (c *Client) POSTV1WithBody(endpoint string, body []byte) error {
+	return c.postV1(endpoint, nil, body)
+}
+
+func (c *Client) postV1(endpoint string, header http.Header, reqBody []byte) error {
 retry:
-	req, err := http.NewRequest("POST", endpoint, nil)
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(reqBody))
 	if err != nil {
 		return err
 	}
 
+	for k, v := range header {
+		req.Header[k] = v
+	}
 	req.Header.Add("Accept", "application/vnd.nsq; version=1.0")
 
 	resp, err := c.c.Do(req)
@@ -105,7 +175,7 @@ retry:
 		return err
 	}
 	if resp.StatusCode != 200 {
-		if resp.StatusCode == 403 && !strings.HasPrefix(endpoint, "https") {
+		if resp.StatusCode == 403 && !strings.HasPrefix(endpoint, "https") && !isUnixSocketEndpoint(endpoint) {
 			endpoint, err = httpsEndpoint(endpoint, body)
 			if err != nil {
 				return err
@@ -118,6 +188,15 @@ retry:
 	return nil
 }
 
+func isUnixSocketEndpoint(endpoint string) bool {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return false
+	}
+	_, ok := unixSocketPath(u.Host)
+	return ok
+}
+
 func httpsEndpoint(endpoint string, body []byte) (string, error) {
 	var forbiddenResp struct {
 		HTTPSAddr string `json:"https_addr"`
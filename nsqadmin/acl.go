@@ -0,0 +1,146 @@
+package nsqadmin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+// viewers may only look, operators may also pause, unpause and empty, admins
+// may also create, delete and tombstone
+var roleLevels = map[string]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// ACLGrant is an entry of the JSON list in --acl-policy-file
+//
+// Users are the values of --acl-http-header the grant applies to ("*" matches
+// every request). Topic and Channel are regular expressions restricting the
+// grant to matching topics and channels (empty matches all of them), they are
+// anchored so that they have to match the whole name; a grant with a Channel
+// scope does not cover actions on whole topics. Cluster restricts the grant to
+// the cluster of that name (empty matches all of them).
+type ACLGrant struct {
+	Users   []string `json:"users"`
+	Role    string   `json:"role"`
+	Cluster string   `json:"cluster,omitempty"`
+	Topic   string   `json:"topic,omitempty"`
+	Channel string   `json:"channel,omitempty"`
+
+	topicRegex   *regexp.Regexp
+	channelRegex *regexp.Regexp
+}
+
+type aclPolicy struct {
+	grants []*ACLGrant
+}
+
+func loadACLPolicy(fn string) (*aclPolicy, error) {
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	var grants []*ACLGrant
+	if err := json.Unmarshal(data, &grants); err != nil {
+		return nil, err
+	}
+	for i, g := range grants {
+		if err := g.validate(); err != nil {
+			return nil, fmt.Errorf("grant %d - %s", i, err)
+		}
+	}
+	return &aclPolicy{grants: grants}, nil
+}
+
+func (g *ACLGrant) validate() error {
+	if len(g.Users) == 0 {
+		return errors.New("users is required")
+	}
+	if g.Cluster != "" && !validClusterNameRegex.MatchString(g.Cluster) {
+		return fmt.Errorf("invalid cluster %q", g.Cluster)
+	}
+	if _, ok := roleLevels[g.Role]; !ok {
+		return fmt.Errorf("invalid role %q", g.Role)
+	}
+	var err error
+	if g.Topic != "" {
+		g.topicRegex, err = compileAnchored(g.Topic)
+		if err != nil {
+			return fmt.Errorf("invalid topic %q - %s", g.Topic, err)
+		}
+	}
+	if g.Channel != "" {
+		g.channelRegex, err = compileAnchored(g.Channel)
+		if err != nil {
+			return fmt.Errorf("invalid channel %q - %s", g.Channel, err)
+		}
+	}
+	return nil
+}
+
+// compileAnchored compiles a regular expression that matches whole strings,
+// so that a grant on "orders" does not also cover "orders_archive"
+func compileAnchored(expr string) (*regexp.Regexp, error) {
+	// on its own first, so that an expression like "a)|(b" cannot escape the
+	// anchoring group
+	if _, err := regexp.Compile(expr); err != nil {
+		return nil, err
+	}
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+func (g *ACLGrant) hasUser(user string) bool {
+	for _, u := range g.Users {
+		if u == "*" || u == user {
+			return true
+		}
+	}
+	return false
+}
+
+func (g *ACLGrant) covers(cluster string, topic string, channel string) bool {
+	if g.Cluster != "" && g.Cluster != cluster {
+		return false
+	}
+	if g.topicRegex != nil && !g.topicRegex.MatchString(topic) {
+		return false
+	}
+	if channel == "" {
+		return g.channelRegex == nil
+	}
+	return g.channelRegex == nil || g.channelRegex.MatchString(channel)
+}
+
+// allows returns whether user has (at least) role on the given topic, or the
+// given channel when it is not empty, of a cluster
+func (p *aclPolicy) allows(user string, role string, cluster string, topic string, channel string) bool {
+	for _, g := range p.grants {
+		if !g.hasUser(user) || roleLevels[g.Role] < roleLevels[role] {
+			continue
+		}
+		if g.covers(cluster, topic, channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// canModify returns whether user has a role above viewer on anything
+func (p *aclPolicy) canModify(user string) bool {
+	for _, g := range p.grants {
+		if g.hasUser(user) && roleLevels[g.Role] > roleLevels[RoleViewer] {
+			return true
+		}
+	}
+	return false
+}
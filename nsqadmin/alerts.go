@@ -0,0 +1,467 @@
+package nsqadmin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nsqio/nsq/internal/clusterinfo"
+	"github.com/nsqio/nsq/internal/http_api"
+)
+
+const (
+	AlertChannelDepth = "channel_depth"
+	AlertNoConsumers  = "no_consumers"
+	AlertRequeueRate  = "requeue_rate"
+	AlertNodeMissing  = "node_missing"
+	AlertE2ELatency   = "e2e_latency"
+)
+
+const (
+	alertPending  = "pending"
+	alertFiring   = "firing"
+	alertResolved = "resolved"
+
+	// how many resolved alerts /api/alerts returns
+	maxResolvedAlerts = 50
+
+	// how long a node that is gone is watched by node_missing rules for "*",
+	// so that the alert of a decommissioned node resolves eventually
+	knownNodeTTL = 24 * time.Hour
+)
+
+// AlertRule is an entry of the JSON list in --alert-rules-file
+//
+// Topic and Channel select the channels a rule applies to ("" or "*" matches
+// all of them), Node is the nsqd a node_missing rule watches (its HTTP address,
+// hostname or TCP address) or "*" for every node seen in the last 24 hours.
+// Threshold is a depth for channel_depth, requeues/sec for requeue_rate and
+// milliseconds for e2e_latency. The condition must hold for For (a duration
+// string, default 0) before the alert fires.
+type AlertRule struct {
+	Name      string  `json:"name"`
+	Type      string  `json:"type"`
+	Topic     string  `json:"topic,omitempty"`
+	Channel   string  `json:"channel,omitempty"`
+	Node      string  `json:"node,omitempty"`
+	Threshold float64 `json:"threshold,omitempty"`
+	Quantile  float64 `json:"quantile,omitempty"`
+	For       string  `json:"for,omitempty"`
+
+	forDuration time.Duration
+}
+
+// Alert is a rule whose condition holds for a channel or node
+type Alert struct {
+	Rule       string  `json:"rule"`
+	Type       string  `json:"type"`
+	Topic      string  `json:"topic,omitempty"`
+	Channel    string  `json:"channel,omitempty"`
+	Node       string  `json:"node,omitempty"`
+	Value      float64 `json:"value"`
+	Threshold  float64 `json:"threshold"`
+	State      string  `json:"state"`
+	Since      int64   `json:"since"`
+	FiredAt    int64   `json:"fired_at,omitempty"`
+	ResolvedAt int64   `json:"resolved_at,omitempty"`
+}
+
+func (a *Alert) subject() string {
+	if a.Node != "" {
+		return a.Node
+	}
+	return a.Topic + ":" + a.Channel
+}
+
+func loadAlertRules(fn string) ([]*AlertRule, error) {
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	var rules []*AlertRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool, len(rules))
+	for i, r := range rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("rule %d has no name", i)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("duplicate rule name %q", r.Name)
+		}
+		names[r.Name] = true
+		if err := r.validate(); err != nil {
+			return nil, fmt.Errorf("rule %q - %s", r.Name, err)
+		}
+	}
+	return rules, nil
+}
+
+func (r *AlertRule) validate() error {
+	switch r.Type {
+	case AlertChannelDepth, AlertRequeueRate:
+		if r.Threshold <= 0 {
+			return errors.New("threshold must be > 0")
+		}
+	case AlertNoConsumers:
+	case AlertNodeMissing:
+		if r.Node == "" {
+			return errors.New("node is required")
+		}
+	case AlertE2ELatency:
+		if r.Threshold <= 0 {
+			return errors.New("threshold must be > 0")
+		}
+		if r.Quantile == 0 {
+			r.Quantile = 0.99
+		}
+		if r.Quantile < 0 || r.Quantile > 1 {
+			return errors.New("quantile must be between 0 and 1")
+		}
+	default:
+		return fmt.Errorf("invalid type %q", r.Type)
+	}
+	if r.For != "" {
+		d, err := time.ParseDuration(r.For)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid for %q", r.For)
+		}
+		r.forDuration = d
+	}
+	return nil
+}
+
+func matchName(pattern string, name string) bool {
+	return pattern == "" || pattern == "*" || pattern == name
+}
+
+// alertData is the cluster state the rules are evaluated against
+type alertData struct {
+	channels     map[string]*clusterinfo.ChannelStats // by <topic>:<channel>
+	requeueRates map[string]float64                   // by <topic>:<channel>, nil on the first evaluation
+	nodes        map[string]bool                      // HTTP address, hostname and TCP address of every node
+	missingNodes []string                             // HTTP address of the nodes seen before but not now
+}
+
+// alertSubject is a channel or node for which the condition of a rule holds
+type alertSubject struct {
+	topic   string
+	channel string
+	node    string
+	value   float64
+}
+
+func (s alertSubject) key() string {
+	if s.node != "" {
+		return s.node
+	}
+	return s.topic + ":" + s.channel
+}
+
+func (r *AlertRule) match(d *alertData) []alertSubject {
+	var subjects []alertSubject
+
+	if r.Type == AlertNodeMissing {
+		if r.Node == "*" {
+			for _, node := range d.missingNodes {
+				subjects = append(subjects, alertSubject{node: node, value: 1})
+			}
+		} else if !d.nodes[r.Node] {
+			subjects = append(subjects, alertSubject{node: r.Node, value: 1})
+		}
+		return subjects
+	}
+
+	for _, c := range d.channels {
+		if !matchName(r.Topic, c.TopicName) || !matchName(r.Channel, c.ChannelName) {
+			continue
+		}
+		s := alertSubject{topic: c.TopicName, channel: c.ChannelName}
+		switch r.Type {
+		case AlertChannelDepth:
+			s.value = float64(c.Depth)
+			if s.value <= r.Threshold {
+				continue
+			}
+		case AlertNoConsumers:
+			if c.ClientCount > 0 {
+				continue
+			}
+		case AlertRequeueRate:
+			rate, ok := d.requeueRates[s.key()]
+			if !ok || rate <= r.Threshold {
+				continue
+			}
+			s.value = rate
+		case AlertE2ELatency:
+			latency, ok := e2eLatency(c, r.Quantile)
+			if !ok || latency <= r.Threshold {
+				continue
+			}
+			s.value = latency
+		}
+		subjects = append(subjects, s)
+	}
+	return subjects
+}
+
+// e2eLatency returns the worst latency (in ms) of all nodes for the given
+// quantile, which nsqd must be configured to track
+func e2eLatency(c *clusterinfo.ChannelStats, quantile float64) (float64, bool) {
+	if c.E2eProcessingLatency == nil {
+		return 0, false
+	}
+	for _, p := range c.E2eProcessingLatency.Percentiles {
+		if p["quantile"] == quantile {
+			return p["max"] / float64(time.Millisecond), true
+		}
+	}
+	return 0, false
+}
+
+// alerter keeps the state of the alert rules between evaluations
+type alerter struct {
+	sync.RWMutex
+
+	rules    []*AlertRule
+	alerts   map[string]*Alert // by <rule>/<subject>
+	resolved []*Alert          // newest first
+
+	knownNodes   map[string]time.Time // when each node was last seen
+	lastRequeues map[string]int64
+	lastEval     time.Time
+}
+
+func newAlerter(rules []*AlertRule) *alerter {
+	return &alerter{
+		rules:      rules,
+		alerts:     make(map[string]*Alert),
+		knownNodes: make(map[string]time.Time),
+	}
+}
+
+// update turns the cluster stats into alertData, tracking the nodes and
+// requeue counters seen so far
+func (a *alerter) update(now time.Time, producers clusterinfo.Producers,
+	channels map[string]*clusterinfo.ChannelStats) *alertData {
+	d := &alertData{
+		channels: channels,
+		nodes:    make(map[string]bool, 3*len(producers)),
+	}
+
+	present := make(map[string]bool, len(producers))
+	for _, p := range producers {
+		addr := p.HTTPAddress()
+		present[addr] = true
+		a.knownNodes[addr] = now
+		d.nodes[addr] = true
+		d.nodes[p.Hostname] = true
+		d.nodes[p.TCPAddress()] = true
+	}
+	for addr, lastSeen := range a.knownNodes {
+		if present[addr] {
+			continue
+		}
+		if now.Sub(lastSeen) > knownNodeTTL {
+			delete(a.knownNodes, addr)
+			continue
+		}
+		d.missingNodes = append(d.missingNodes, addr)
+	}
+	sort.Strings(d.missingNodes)
+
+	requeues := make(map[string]int64, len(channels))
+	for key, c := range channels {
+		requeues[key] = c.RequeueCount
+	}
+	if a.lastRequeues != nil {
+		elapsed := now.Sub(a.lastEval).Seconds()
+		d.requeueRates = make(map[string]float64, len(requeues))
+		for k, cur := range requeues {
+			prev, ok := a.lastRequeues[k]
+			if ok && elapsed > 0 {
+				d.requeueRates[k] = counterRate(prev, cur, elapsed)
+			}
+		}
+	}
+	a.lastRequeues = requeues
+	a.lastEval = now
+	return d
+}
+
+// evaluate applies every rule to the given state and returns (copies of) the
+// alerts that fired or resolved
+func (a *alerter) evaluate(now time.Time, producers clusterinfo.Producers,
+	channels map[string]*clusterinfo.ChannelStats) []Alert {
+	a.Lock()
+	defer a.Unlock()
+
+	d := a.update(now, producers, channels)
+
+	var changed []Alert
+	for _, r := range a.rules {
+		matched := make(map[string]bool)
+		for _, s := range r.match(d) {
+			key := r.Name + "/" + s.key()
+			matched[key] = true
+			alert, ok := a.alerts[key]
+			if !ok {
+				alert = &Alert{
+					Rule:      r.Name,
+					Type:      r.Type,
+					Topic:     s.topic,
+					Channel:   s.channel,
+					Node:      s.node,
+					Threshold: r.Threshold,
+					State:     alertPending,
+					Since:     now.Unix(),
+				}
+				a.alerts[key] = alert
+			}
+			alert.Value = s.value
+			if alert.State == alertPending && now.Sub(time.Unix(alert.Since, 0)) >= r.forDuration {
+				alert.State = alertFiring
+				alert.FiredAt = now.Unix()
+				changed = append(changed, *alert)
+			}
+		}
+
+		for key, alert := range a.alerts {
+			if alert.Rule != r.Name || matched[key] {
+				continue
+			}
+			delete(a.alerts, key)
+			if alert.State != alertFiring {
+				continue
+			}
+			alert.State = alertResolved
+			alert.ResolvedAt = now.Unix()
+			changed = append(changed, *alert)
+			a.resolved = append([]*Alert{alert}, a.resolved...)
+			if len(a.resolved) > maxResolvedAlerts {
+				a.resolved = a.resolved[:maxResolvedAlerts]
+			}
+		}
+	}
+	return changed
+}
+
+// active returns the pending and firing alerts, firing ones first
+func (a *alerter) active() []*Alert {
+	a.RLock()
+	defer a.RUnlock()
+	alerts := make([]*Alert, 0, len(a.alerts))
+	for _, alert := range a.alerts {
+		c := *alert
+		alerts = append(alerts, &c)
+	}
+	sort.Slice(alerts, func(i, j int) bool {
+		if alerts[i].State != alerts[j].State {
+			return alerts[i].State == alertFiring
+		}
+		if alerts[i].Since != alerts[j].Since {
+			return alerts[i].Since < alerts[j].Since
+		}
+		return alerts[i].Rule < alerts[j].Rule
+	})
+	return alerts
+}
+
+func (a *alerter) recentlyResolved() []*Alert {
+	a.RLock()
+	defer a.RUnlock()
+	return append([]*Alert{}, a.resolved...)
+}
+
+// evaluateAlerts applies the alert rules to the cluster every --alert-interval
+// and notifies --notification-http-endpoint of the alerts that fire or resolve
+func (n *NSQAdmin) evaluateAlerts() {
+	opts := n.getOpts()
+	client := http_api.NewClient(n.httpClientTLSConfig, opts.HTTPClientConnectTimeout,
+		opts.HTTPClientRequestTimeout)
+	ci := clusterinfo.New(n.logf, client)
+	ci.SetLookupdAdminToken(opts.NSQLookupdHTTPAdminToken)
+
+	ticker := time.NewTicker(opts.AlertInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-n.exitChan:
+			return
+		}
+
+		producers, channels, err := getAlertStats(ci, opts.NSQLookupdHTTPAddresses, opts.NSQDHTTPAddresses)
+		if err != nil {
+			pe, ok := err.(clusterinfo.PartialErr)
+			if !ok {
+				// without any data every alert would resolve (and fire again)
+				n.logf(LOG_ERROR, "ALERTS: failed to get cluster stats - %s", err)
+				continue
+			}
+			n.logf(LOG_WARN, "ALERTS: %s", pe)
+		}
+		n.lag.update(opts.ClusterName, channelStatsList(channels), time.Now())
+
+		for _, alert := range n.alerter.evaluate(time.Now(), producers, channels) {
+			n.logf(LOG_WARN, "ALERTS: %s %s (%s) value %g threshold %g",
+				alert.Rule, alert.State, alert.subject(), alert.Value, alert.Threshold)
+			n.notifyAlert(alert)
+		}
+	}
+}
+
+// getAlertStats returns the producers and channel stats of the cluster, the
+// error is a PartialErr when some nodes could not be queried
+func getAlertStats(ci *clusterinfo.ClusterInfo, lookupdHTTPAddrs []string,
+	nsqdHTTPAddrs []string) (clusterinfo.Producers, map[string]*clusterinfo.ChannelStats, error) {
+	var errs []error
+	producers, err := ci.GetProducers(lookupdHTTPAddrs, nsqdHTTPAddrs)
+	if err != nil {
+		pe, ok := err.(clusterinfo.PartialErr)
+		if !ok {
+			return nil, nil, err
+		}
+		errs = append(errs, pe.Errors()...)
+	}
+	var channelStats map[string]*clusterinfo.ChannelStats
+	// with no nodes at all (which node_missing rules are about) there are no stats
+	// to get either
+	if len(producers) > 0 {
+		_, channelStats, err = ci.GetNSQDStats(producers, "", "", false)
+		if err != nil {
+			pe, ok := err.(clusterinfo.PartialErr)
+			if !ok {
+				return nil, nil, err
+			}
+			errs = append(errs, pe.Errors()...)
+		}
+	}
+	if len(errs) > 0 {
+		return producers, channelStats, clusterinfo.ErrList(errs)
+	}
+	return producers, channelStats, nil
+}
+
+func (n *NSQAdmin) notifyAlert(alert Alert) {
+	if n.getOpts().NotificationHTTPEndpoint == "" {
+		return
+	}
+	via, _ := os.Hostname()
+	n.queueNotification(&AdminAction{
+		Action:    "alert_" + alert.State,
+		Topic:     alert.Topic,
+		Channel:   alert.Channel,
+		Node:      alert.Node,
+		Cluster:   n.getOpts().ClusterName,
+		Timestamp: time.Now().Unix(),
+		Via:       via,
+		Alert:     &alert,
+	})
+}
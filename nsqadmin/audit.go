@@ -0,0 +1,191 @@
+package nsqadmin
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// auditLog is an append-only file of admin actions, one JSON object per line,
+// which is rotated to <path>.<timestamp> when it grows beyond maxSize
+type auditLog struct {
+	sync.Mutex
+
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	f    *os.File
+	size int64
+}
+
+// auditFilter selects the actions /api/audit returns, empty fields match all
+type auditFilter struct {
+	User    string
+	Action  string
+	Topic   string
+	Channel string
+	Since   int64
+	Until   int64
+}
+
+func newAuditLog(path string, maxSize int64, maxFiles int) (*auditLog, error) {
+	l := &auditLog{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+	}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *auditLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f = f
+	l.size = fi.Size()
+	return nil
+}
+
+// append writes (and syncs) an action to the log
+func (l *auditLog) append(a *AdminAction) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.Lock()
+	defer l.Unlock()
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(data)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.f.Write(data)
+	l.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return l.f.Sync()
+}
+
+func (l *auditLog) rotate() error {
+	l.f.Close()
+	rotated := l.path + "." + time.Now().UTC().Format("20060102T150405.000000000")
+	if err := os.Rename(l.path, rotated); err != nil {
+		// keep appending to the current file
+		l.open()
+		return err
+	}
+	if err := l.open(); err != nil {
+		return err
+	}
+
+	if l.maxFiles <= 0 {
+		return nil
+	}
+	old := l.rotatedFiles()
+	for len(old) > l.maxFiles {
+		os.Remove(old[0])
+		old = old[1:]
+	}
+	return nil
+}
+
+// rotatedFiles returns the rotated files, oldest first
+func (l *auditLog) rotatedFiles() []string {
+	files, _ := filepath.Glob(l.path + ".*")
+	sort.Strings(files)
+	return files
+}
+
+// query returns the (at most limit) newest actions matching the filter,
+// newest first
+//
+// The files are only opened under the lock, so that appends do not wait for
+// them to be parsed; an open file can still be read once it is rotated or
+// removed, and the current one is read up to its size at that point.
+func (l *auditLog) query(filter auditFilter, limit int) ([]*AdminAction, error) {
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	l.Lock()
+	for _, fn := range append(l.rotatedFiles(), l.path) {
+		f, err := os.Open(fn)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			l.Unlock()
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	current, size := l.f.Name(), l.size
+	l.Unlock()
+
+	var actions []*AdminAction
+	for _, f := range files {
+		var r io.Reader = f
+		if f.Name() == current {
+			r = io.LimitReader(f, size)
+		}
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			var a AdminAction
+			if err := json.Unmarshal(scanner.Bytes(), &a); err != nil {
+				// a torn write
+				continue
+			}
+			if !filter.match(&a) {
+				continue
+			}
+			actions = append(actions, &a)
+			if limit > 0 && len(actions) > limit {
+				actions = actions[1:]
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, j := 0, len(actions)-1; i < j; i, j = i+1, j-1 {
+		actions[i], actions[j] = actions[j], actions[i]
+	}
+	return actions, nil
+}
+
+func (f auditFilter) match(a *AdminAction) bool {
+	return (f.User == "" || f.User == a.User) &&
+		(f.Action == "" || f.Action == a.Action) &&
+		(f.Topic == "" || f.Topic == a.Topic) &&
+		(f.Channel == "" || f.Channel == a.Channel) &&
+		(f.Since == 0 || a.Timestamp >= f.Since) &&
+		(f.Until == 0 || a.Timestamp <= f.Until)
+}
+
+func (l *auditLog) close() {
+	l.Lock()
+	defer l.Unlock()
+	l.f.Close()
+}
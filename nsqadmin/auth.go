@@ -0,0 +1,256 @@
+package nsqadmin
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nsqio/nsq/internal/http_api"
+)
+
+const sessionCookieName = "nsqadmin_session"
+
+type userContextKey struct{}
+
+// htpasswd maps users to the password hashes of an htpasswd file
+//
+// Only the hash schemes that need nothing outside the standard library are
+// supported: $apr1$ (htpasswd -m, the default) and {SHA} (htpasswd -s).
+type htpasswd map[string]string
+
+func loadHTPasswd(fn string) (htpasswd, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := make(htpasswd)
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("line %d is not <user>:<hash>", lineNum)
+		}
+		hash := parts[1]
+		if !strings.HasPrefix(hash, "$apr1$") && !strings.HasPrefix(hash, "{SHA}") {
+			return nil, fmt.Errorf("line %d has an unsupported hash (use htpasswd -m or -s)", lineNum)
+		}
+		h[parts[0]] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func (h htpasswd) authenticate(user string, password string) bool {
+	hash, ok := h[user]
+	if !ok {
+		return false
+	}
+	var computed string
+	switch {
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		computed = "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	case strings.HasPrefix(hash, "$apr1$"):
+		salt := strings.SplitN(hash[len("$apr1$"):], "$", 2)[0]
+		computed = apr1Crypt(password, salt)
+	}
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1
+}
+
+// apr1Crypt is Apache's variant of the MD5 based crypt(3)
+func apr1Crypt(password string, salt string) string {
+	const magic = "$apr1$"
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+
+	alt := md5.New()
+	alt.Write(pw)
+	alt.Write([]byte(salt))
+	alt.Write(pw)
+	altSum := alt.Sum(nil)
+
+	d := md5.New()
+	d.Write(pw)
+	d.Write([]byte(magic))
+	d.Write([]byte(salt))
+	for i := len(pw); i > 0; i -= 16 {
+		if i > 16 {
+			d.Write(altSum)
+		} else {
+			d.Write(altSum[:i])
+		}
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			d.Write([]byte{0})
+		} else {
+			d.Write(pw[:1])
+		}
+	}
+	sum := d.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		d := md5.New()
+		if i&1 != 0 {
+			d.Write(pw)
+		} else {
+			d.Write(sum)
+		}
+		if i%3 != 0 {
+			d.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			d.Write(pw)
+		}
+		if i&1 != 0 {
+			d.Write(sum)
+		} else {
+			d.Write(pw)
+		}
+		sum = d.Sum(nil)
+	}
+
+	out := make([]byte, 0, 22)
+	to64 := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			out = append(out, itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, g := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		to64(uint32(sum[g[0]])<<16|uint32(sum[g[1]])<<8|uint32(sum[g[2]]), 4)
+	}
+	to64(uint32(sum[11]), 2)
+	return magic + salt + "$" + string(out)
+}
+
+// session is the content of the (signed) session cookie set after an OIDC login
+type session struct {
+	User    string `json:"user"`
+	Expires int64  `json:"exp"`
+}
+
+// signValue serializes v and appends an HMAC of it keyed by --session-secret
+func (n *NSQAdmin) signValue(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	mac := hmac.New(sha256.New, n.sessionKey)
+	mac.Write([]byte(payload))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// verifyValue is the inverse of signValue
+func (n *NSQAdmin) verifyValue(s string, v interface{}) error {
+	parts := strings.SplitN(s, ".", 2)
+	if len(parts) != 2 {
+		return errors.New("malformed value")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, n.sessionKey)
+	mac.Write([]byte(parts[0]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return errors.New("invalid signature")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (n *NSQAdmin) authEnabled() bool {
+	return n.htpasswd != nil || n.oidc != nil
+}
+
+// isPublicPath returns whether the path is served without authentication
+func (s *httpServer) isPublicPath(p string) bool {
+	return p == path.Join(s.basePath, "/ping") ||
+		strings.HasPrefix(p, path.Join(s.basePath, "/static")+"/") ||
+		strings.HasPrefix(p, path.Join(s.basePath, "/fonts")+"/") ||
+		strings.HasPrefix(p, path.Join(s.basePath, "/oauth2")+"/")
+}
+
+// authenticate returns the user of a valid session cookie or of valid basic
+// auth credentials, or "" when the request is not authenticated
+func (s *httpServer) authenticate(req *http.Request) string {
+	if c, err := req.Cookie(sessionCookieName); err == nil {
+		var sess session
+		err := s.nsqadmin.verifyValue(c.Value, &sess)
+		if err == nil && sess.User != "" && time.Now().Unix() < sess.Expires {
+			return sess.User
+		}
+	}
+	if s.nsqadmin.htpasswd != nil {
+		user, password, ok := req.BasicAuth()
+		if ok && s.nsqadmin.htpasswd.authenticate(user, password) {
+			return user
+		}
+	}
+	return ""
+}
+
+// unauthenticated sends pages to the OIDC login (when enabled) and answers
+// everything else with a 401, asking for basic auth when it is enabled
+func (s *httpServer) unauthenticated(w http.ResponseWriter, req *http.Request) {
+	isPage := req.Method == "GET" &&
+		!strings.HasPrefix(req.URL.Path, path.Join(s.basePath, "/api")+"/") &&
+		!strings.HasPrefix(req.URL.Path, path.Join(s.basePath, "/config")+"/")
+	if s.nsqadmin.oidc != nil && isPage {
+		loginURL := path.Join(s.basePath, "/oauth2/login") + "?rd=" + url.QueryEscape(req.URL.RequestURI())
+		http.Redirect(w, req, loginURL, http.StatusFound)
+		return
+	}
+	if s.nsqadmin.htpasswd != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="nsqadmin"`)
+	}
+	http_api.Decorate(func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+		return nil, http_api.Err{401, "UNAUTHORIZED"}
+	}, http_api.Log(s.nsqadmin.logf), http_api.V1)(w, req, nil)
+}
+
+func withUser(req *http.Request, user string) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), userContextKey{}, user))
+}
+
+// requestUser returns the identity admin checks apply to: the authenticated
+// user when --htpasswd-file or OIDC is enabled and otherwise the (trusted)
+// value of --acl-http-header
+func (s *httpServer) requestUser(req *http.Request) string {
+	if s.nsqadmin.authEnabled() {
+		user, _ := req.Context().Value(userContextKey{}).(string)
+		return user
+	}
+	return req.Header.Get(s.nsqadmin.getOpts().ACLHTTPHeader)
+}
@@ -0,0 +1,247 @@
+package nsqadmin
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/nsqio/nsq/internal/clusterinfo"
+	"github.com/nsqio/nsq/internal/http_api"
+)
+
+var validClusterNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// cluster is a named set of nsqlookupd (or nsqd) managed by nsqadmin
+type cluster struct {
+	Name                    string
+	NSQLookupdHTTPAddresses []string
+	NSQDHTTPAddresses       []string
+
+	// NSQLookupdHTTPAdminToken is sent to the nsqlookupd of the cluster on
+	// tombstone and delete requests
+	NSQLookupdHTTPAdminToken string
+}
+
+// parseClusters returns the cluster of --lookupd-http-address and
+// --nsqd-http-address (named --cluster-name) followed by those of
+// --cluster-lookupd-http-address and --cluster-nsqd-http-address, in the order
+// they are first given
+func parseClusters(opts *Options) ([]*cluster, error) {
+	if !validClusterNameRegex.MatchString(opts.ClusterName) {
+		return nil, fmt.Errorf("invalid --cluster-name (%s)", opts.ClusterName)
+	}
+	clusters := []*cluster{{
+		Name:                    opts.ClusterName,
+		NSQLookupdHTTPAddresses: opts.NSQLookupdHTTPAddresses,
+		NSQDHTTPAddresses:       opts.NSQDHTTPAddresses,
+
+		NSQLookupdHTTPAdminToken: opts.NSQLookupdHTTPAdminToken,
+	}}
+	byName := make(map[string]*cluster)
+
+	add := func(flag string, v string, lookupd bool) error {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return fmt.Errorf("invalid --%s (%s) - should be <cluster>=<addr>", flag, v)
+		}
+		name, address := parts[0], parts[1]
+		if !validClusterNameRegex.MatchString(name) {
+			return fmt.Errorf("invalid --%s (%s) - invalid cluster name", flag, v)
+		}
+		if name == opts.ClusterName {
+			return fmt.Errorf("invalid --%s (%s) - %s is the --cluster-name of --lookupd-http-address/--nsqd-http-address",
+				flag, v, name)
+		}
+		if err := resolveHTTPAddr(address); err != nil {
+			return fmt.Errorf("failed to resolve --%s (%s) - %s", flag, v, err)
+		}
+		c, ok := byName[name]
+		if !ok {
+			c = &cluster{Name: name}
+			byName[name] = c
+			clusters = append(clusters, c)
+		}
+		if lookupd {
+			c.NSQLookupdHTTPAddresses = append(c.NSQLookupdHTTPAddresses, address)
+		} else {
+			c.NSQDHTTPAddresses = append(c.NSQDHTTPAddresses, address)
+		}
+		if len(c.NSQLookupdHTTPAddresses) != 0 && len(c.NSQDHTTPAddresses) != 0 {
+			return fmt.Errorf("cluster %s has both --cluster-lookupd-http-address and --cluster-nsqd-http-address", name)
+		}
+		return nil
+	}
+
+	for _, v := range opts.ClusterNSQLookupdHTTPAddresses {
+		if err := add("cluster-lookupd-http-address", v, true); err != nil {
+			return nil, err
+		}
+	}
+	for _, v := range opts.ClusterNSQDHTTPAddresses {
+		if err := add("cluster-nsqd-http-address", v, false); err != nil {
+			return nil, err
+		}
+	}
+	for _, v := range opts.ClusterNSQLookupdHTTPAdminTokens {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("invalid --cluster-lookupd-http-admin-token - should be <cluster>=<token>")
+		}
+		c, ok := byName[parts[0]]
+		if !ok || len(c.NSQLookupdHTTPAddresses) == 0 {
+			return nil, fmt.Errorf("invalid --cluster-lookupd-http-admin-token - %s is not a --cluster-lookupd-http-address cluster",
+				parts[0])
+		}
+		c.NSQLookupdHTTPAdminToken = parts[1]
+	}
+	return clusters, nil
+}
+
+// clusters returns the configured clusters, the default one first
+func (n *NSQAdmin) clusters() []*cluster {
+	// the options were validated by New
+	clusters, _ := parseClusters(n.getOpts())
+	return clusters
+}
+
+// getCluster returns the named cluster or nil
+func (n *NSQAdmin) getCluster(name string) *cluster {
+	for _, c := range n.clusters() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+type clusterContextKey struct{}
+
+func withCluster(req *http.Request, c *cluster) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), clusterContextKey{}, c))
+}
+
+// cluster returns the cluster of a /api/clusters/:cluster/... request and the
+// default cluster otherwise
+func (s *httpServer) cluster(req *http.Request) *cluster {
+	if c, ok := req.Context().Value(clusterContextKey{}).(*cluster); ok {
+		return c
+	}
+	return s.nsqadmin.clusters()[0]
+}
+
+// clusterInfo returns the ClusterInfo of a cluster, which sends the lookupd
+// admin token of that cluster
+func (s *httpServer) clusterInfo(c *cluster) *clusterinfo.ClusterInfo {
+	ci := clusterinfo.New(s.nsqadmin.logf, s.client)
+	ci.SetLookupdAdminToken(c.NSQLookupdHTTPAdminToken)
+	return ci
+}
+
+// isDefaultCluster returns whether a request is about the cluster that the
+// history and the alerter apply to
+func (s *httpServer) isDefaultCluster(req *http.Request) bool {
+	return s.cluster(req).Name == s.nsqadmin.getOpts().ClusterName
+}
+
+// scopeToCluster rewrites a /api/clusters/:cluster/... request to its /api/...
+// route with the cluster in the request context, or responds 404 when there is
+// no such cluster
+func (s *httpServer) scopeToCluster(w http.ResponseWriter, req *http.Request) (*http.Request, bool) {
+	prefix := path.Join(s.basePath, "/api/clusters") + "/"
+	if !strings.HasPrefix(req.URL.Path, prefix) {
+		return req, true
+	}
+	parts := strings.SplitN(strings.TrimPrefix(req.URL.Path, prefix), "/", 2)
+	if len(parts) != 2 {
+		return req, true
+	}
+	c := s.nsqadmin.getCluster(parts[0])
+	if c == nil {
+		http_api.RespondV1(w, 404, "CLUSTER_NOT_FOUND")
+		return nil, false
+	}
+	req = withCluster(req, c)
+	u := *req.URL
+	// not path.Join, which would clean the // of a unix:// node
+	u.Path = path.Join(s.basePath, "/api") + "/" + parts[1]
+	u.RawPath = ""
+	req.URL = &u
+	return req, true
+}
+
+// clusterOverview is the health and aggregate depth of a cluster
+type clusterOverview struct {
+	Name    string `json:"name"`
+	Default bool   `json:"default"`
+	// ok, degraded (some nsqlookupd or nsqd failed) or unreachable
+	Health        string `json:"health"`
+	Nodes         int    `json:"nodes"`
+	Topics        int    `json:"topics"`
+	Channels      int    `json:"channels"`
+	Depth         int64  `json:"depth"`
+	InFlightCount int64  `json:"in_flight_count"`
+	DeferredCount int64  `json:"deferred_count"`
+	MessageCount  int64  `json:"message_count"`
+	Message       string `json:"message"`
+}
+
+func (s *httpServer) getClusterOverview(c *cluster) *clusterOverview {
+	var messages []string
+
+	o := &clusterOverview{
+		Name:    c.Name,
+		Default: c.Name == s.nsqadmin.getOpts().ClusterName,
+		Health:  "ok",
+	}
+
+	producers, err := s.clusterInfo(c).GetProducers(c.NSQLookupdHTTPAddresses, c.NSQDHTTPAddresses)
+	if err != nil {
+		pe, ok := err.(clusterinfo.PartialErr)
+		if !ok {
+			s.nsqadmin.logf(LOG_ERROR, "failed to get producers of cluster %s - %s", c.Name, err)
+			o.Health = "unreachable"
+			o.Message = err.Error()
+			return o
+		}
+		s.nsqadmin.logf(LOG_WARN, "cluster %s: %s", c.Name, err)
+		o.Health = "degraded"
+		messages = append(messages, pe.Error())
+	}
+	o.Nodes = len(producers)
+
+	if len(producers) > 0 {
+		topicStats, channelStats, err := s.clusterInfo(c).GetNSQDStats(producers, "", "", false)
+		if err != nil {
+			pe, ok := err.(clusterinfo.PartialErr)
+			if !ok {
+				s.nsqadmin.logf(LOG_ERROR, "failed to get nsqd stats of cluster %s - %s", c.Name, err)
+				o.Health = "unreachable"
+				o.Message = err.Error()
+				return o
+			}
+			s.nsqadmin.logf(LOG_WARN, "cluster %s: %s", c.Name, err)
+			o.Health = "degraded"
+			messages = append(messages, pe.Error())
+		}
+
+		topics := make(map[string]bool)
+		for _, ts := range topicStats {
+			topics[ts.TopicName] = true
+			o.Depth += ts.Depth
+			o.MessageCount += ts.MessageCount
+			for _, cs := range ts.Channels {
+				o.Depth += cs.Depth
+				o.InFlightCount += cs.InFlightCount
+				o.DeferredCount += cs.DeferredCount
+			}
+		}
+		o.Topics = len(topics)
+		o.Channels = len(channelStats)
+	}
+
+	o.Message = maybeWarnMsg(messages)
+	return o
+}
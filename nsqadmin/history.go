@@ -0,0 +1,390 @@
+package nsqadmin
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nsqio/nsq/internal/clusterinfo"
+	"github.com/nsqio/nsq/internal/http_api"
+)
+
+// the history ring is split into this many segment files, the oldest of which
+// is truncated and rewritten once the newest one is full
+const historySegments = 24
+
+// the number of points kept in memory per topic and channel, beyond which the
+// older half is merged pairwise so that recent points keep the full resolution
+const historyMaxPoints = 1024
+
+// historyPoint is the state of a topic or channel summed over all nodes
+type historyPoint struct {
+	Depth        int64 `json:"depth"`
+	MessageCount int64 `json:"message_count"`
+	RequeueCount int64 `json:"requeue_count,omitempty"`
+	TimeoutCount int64 `json:"timeout_count,omitempty"`
+}
+
+// historySample is one line of a segment file, channels are keyed by
+// <topic>:<channel>. A partial sample lacks the nodes that could not be
+// queried.
+type historySample struct {
+	Timestamp int64                   `json:"ts"`
+	Partial   bool                    `json:"partial,omitempty"`
+	Topics    map[string]historyPoint `json:"topics"`
+	Channels  map[string]historyPoint `json:"channels"`
+}
+
+// seriesPoint is a point of the in-memory series of a topic or channel
+type seriesPoint struct {
+	historyPoint
+	timestamp int64
+	partial   bool
+}
+
+// HistoryRate is a point of the /api/history series, rates are per second
+// since the previous complete sample (they are 0 for a partial one)
+type HistoryRate struct {
+	Timestamp   int64   `json:"ts"`
+	Partial     bool    `json:"partial,omitempty"`
+	Depth       int64   `json:"depth"`
+	MessageRate float64 `json:"message_rate"`
+	RequeueRate float64 `json:"requeue_rate"`
+	TimeoutRate float64 `json:"timeout_rate"`
+}
+
+// history is a bounded ring of cluster samples kept in historySegments files
+// under --history-data-path, and in memory as a series of at most
+// historyMaxPoints per topic and channel
+type history struct {
+	sync.RWMutex
+
+	dir            string
+	retention      time.Duration
+	segmentSamples int
+
+	topics   map[string][]seriesPoint // oldest first
+	channels map[string][]seriesPoint // keyed by <topic>:<channel>
+
+	segment    int
+	segmentLen int
+	f          *os.File
+}
+
+func segmentFileName(dir string, segment int) string {
+	return filepath.Join(dir, fmt.Sprintf("history.%03d.json", segment))
+}
+
+// newHistory loads the samples within retention from dir and opens the segment
+// following the newest one for writing
+func newHistory(dir string, interval time.Duration, retention time.Duration) (*history, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	segmentSamples := int(retention/interval)/historySegments + 1
+	h := &history{
+		dir:            dir,
+		retention:      retention,
+		segmentSamples: segmentSamples,
+		topics:         make(map[string][]seriesPoint),
+		channels:       make(map[string][]seriesPoint),
+		segment:        -1,
+	}
+
+	// the segments are replayed oldest first, one at a time
+	type segmentStart struct {
+		segment   int
+		timestamp int64
+	}
+	var starts []segmentStart
+	for i := 0; i < historySegments; i++ {
+		samples, err := readHistorySegment(segmentFileName(dir, i))
+		if err != nil {
+			return nil, err
+		}
+		if len(samples) > 0 {
+			starts = append(starts, segmentStart{i, samples[0].Timestamp})
+		}
+	}
+	sort.Slice(starts, func(i, j int) bool {
+		return starts[i].timestamp < starts[j].timestamp
+	})
+	for _, start := range starts {
+		samples, err := readHistorySegment(segmentFileName(dir, start.segment))
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range samples {
+			h.addPoints(s)
+		}
+		h.segment = start.segment
+	}
+	h.prune(time.Now())
+
+	if err := h.rotate(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func readHistorySegment(fn string) ([]historySample, error) {
+	f, err := os.Open(fn)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var samples []historySample
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		var s historySample
+		// skip a line torn by a crash mid-write
+		if json.Unmarshal(scanner.Bytes(), &s) == nil {
+			samples = append(samples, s)
+		}
+	}
+	return samples, scanner.Err()
+}
+
+// rotate truncates the oldest segment and makes it the current one
+//
+// this expects the caller to hold the lock (or to be newHistory)
+func (h *history) rotate() error {
+	if h.f != nil {
+		h.f.Close()
+	}
+	h.segment = (h.segment + 1) % historySegments
+	h.segmentLen = 0
+	f, err := os.OpenFile(segmentFileName(h.dir, h.segment), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	h.f = f
+	return nil
+}
+
+// addPoints appends a sample to the series of its topics and channels
+//
+// this expects the caller to hold the lock (or to be newHistory)
+func (h *history) addPoints(s historySample) {
+	for name, p := range s.Topics {
+		h.topics[name] = appendSeriesPoint(h.topics[name], seriesPoint{p, s.Timestamp, s.Partial})
+	}
+	for name, p := range s.Channels {
+		h.channels[name] = appendSeriesPoint(h.channels[name], seriesPoint{p, s.Timestamp, s.Partial})
+	}
+}
+
+func appendSeriesPoint(points []seriesPoint, p seriesPoint) []seriesPoint {
+	if len(points) > 0 && points[len(points)-1].timestamp > p.timestamp {
+		return points
+	}
+	points = append(points, p)
+	if len(points) <= historyMaxPoints {
+		return points
+	}
+	// merge the pairs of the older half, the later point of a pair stands for
+	// both (the counters are cumulative) unless it is partial
+	half := len(points) / 2 &^ 1
+	n := 0
+	for i := 0; i < half; i += 2 {
+		points[n] = points[i+1]
+		if points[i+1].partial && !points[i].partial {
+			points[n] = points[i]
+		}
+		n++
+	}
+	n += copy(points[n:], points[half:])
+	return points[:n]
+}
+
+// prune drops the points older than retention
+//
+// this expects the caller to hold the lock (or to be newHistory)
+func (h *history) prune(now time.Time) {
+	oldest := now.Add(-h.retention).Unix()
+	for _, m := range []map[string][]seriesPoint{h.topics, h.channels} {
+		for name, points := range m {
+			i := sort.Search(len(points), func(i int) bool {
+				return points[i].timestamp >= oldest
+			})
+			switch {
+			case i == len(points):
+				delete(m, name)
+			case i > 0:
+				m[name] = append([]seriesPoint(nil), points[i:]...)
+			}
+		}
+	}
+}
+
+func (h *history) add(s historySample) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	h.Lock()
+	defer h.Unlock()
+	if h.segmentLen >= h.segmentSamples {
+		if err := h.rotate(); err != nil {
+			return err
+		}
+	}
+	h.segmentLen++
+	h.addPoints(s)
+	h.prune(time.Unix(s.Timestamp, 0))
+	_, err = h.f.Write(append(b, '\n'))
+	return err
+}
+
+// series returns the points of a topic (channel == "") or channel since the
+// given time
+func (h *history) series(topic string, channel string, since time.Time) []HistoryRate {
+	h.RLock()
+	defer h.RUnlock()
+
+	var points []seriesPoint
+	if channel == "" {
+		points = h.topics[topic]
+	} else {
+		points = h.channels[topic+":"+channel]
+	}
+	i := sort.Search(len(points), func(i int) bool {
+		return points[i].timestamp >= since.Unix()
+	})
+
+	// rates are only computed between complete points, a partial one lacks the
+	// counters of some nodes
+	var prev *seriesPoint
+	for j := i - 1; j >= 0; j-- {
+		if !points[j].partial {
+			prev = &points[j]
+			break
+		}
+	}
+	rates := make([]HistoryRate, 0, len(points)-i)
+	for ; i < len(points); i++ {
+		p := &points[i]
+		r := HistoryRate{Timestamp: p.timestamp, Partial: p.partial, Depth: p.Depth}
+		if !p.partial {
+			if prev != nil && p.timestamp > prev.timestamp {
+				elapsed := float64(p.timestamp - prev.timestamp)
+				r.MessageRate = counterRate(prev.MessageCount, p.MessageCount, elapsed)
+				r.RequeueRate = counterRate(prev.RequeueCount, p.RequeueCount, elapsed)
+				r.TimeoutRate = counterRate(prev.TimeoutCount, p.TimeoutCount, elapsed)
+			}
+			prev = p
+		}
+		rates = append(rates, r)
+	}
+	return rates
+}
+
+// counterRate returns the per second rate of a counter, which restarts from 0
+// when an nsqd restarts
+func counterRate(prev int64, cur int64, elapsed float64) float64 {
+	delta := cur - prev
+	if delta < 0 {
+		delta = cur
+	}
+	return float64(delta) / elapsed
+}
+
+func (h *history) close() {
+	h.Lock()
+	defer h.Unlock()
+	if h.f != nil {
+		h.f.Close()
+		h.f = nil
+	}
+}
+
+// sampleHistory stores the depth and counters of every topic and channel of the
+// cluster every --history-interval
+func (n *NSQAdmin) sampleHistory() {
+	opts := n.getOpts()
+	client := http_api.NewClient(n.httpClientTLSConfig, opts.HTTPClientConnectTimeout,
+		opts.HTTPClientRequestTimeout)
+	ci := clusterinfo.New(n.logf, client)
+	ci.SetLookupdAdminToken(opts.NSQLookupdHTTPAdminToken)
+
+	ticker := time.NewTicker(opts.HistoryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-n.exitChan:
+			n.history.close()
+			return
+		}
+
+		s, err := takeHistorySample(ci, opts.NSQLookupdHTTPAddresses, opts.NSQDHTTPAddresses)
+		if err != nil {
+			n.logf(LOG_WARN, "HISTORY: %s", err)
+		}
+		if s == nil {
+			continue
+		}
+		if err := n.history.add(*s); err != nil {
+			n.logf(LOG_ERROR, "HISTORY: failed to write sample - %s", err)
+		}
+	}
+}
+
+// takeHistorySample returns a sample (possibly partial, along with the error)
+// of the cluster or nil when no nsqd could be queried
+func takeHistorySample(ci *clusterinfo.ClusterInfo, lookupdHTTPAddrs []string,
+	nsqdHTTPAddrs []string) (*historySample, error) {
+	var errs []error
+	producers, err := ci.GetProducers(lookupdHTTPAddrs, nsqdHTTPAddrs)
+	if err != nil {
+		pe, ok := err.(clusterinfo.PartialErr)
+		if !ok {
+			return nil, err
+		}
+		errs = append(errs, pe.Errors()...)
+	}
+	topicStats, channelStats, err := ci.GetNSQDStats(producers, "", "", false)
+	if err != nil {
+		pe, ok := err.(clusterinfo.PartialErr)
+		if !ok {
+			return nil, err
+		}
+		errs = append(errs, pe.Errors()...)
+	}
+
+	s := &historySample{
+		Timestamp: time.Now().Unix(),
+		Partial:   len(errs) > 0,
+		Topics:    make(map[string]historyPoint),
+		Channels:  make(map[string]historyPoint, len(channelStats)),
+	}
+	for _, t := range topicStats {
+		p := s.Topics[t.TopicName]
+		p.Depth += t.Depth
+		p.MessageCount += t.MessageCount
+		s.Topics[t.TopicName] = p
+	}
+	for _, c := range channelStats {
+		s.Channels[c.TopicName+":"+c.ChannelName] = historyPoint{
+			Depth:        c.Depth,
+			MessageCount: c.MessageCount,
+			RequeueCount: c.RequeueCount,
+			TimeoutCount: c.TimeoutCount,
+		}
+	}
+	if len(errs) > 0 {
+		return s, clusterinfo.ErrList(errs)
+	}
+	return s, nil
+}
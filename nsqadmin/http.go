@@ -13,7 +13,11 @@ import (
 	"os"
 	"path"
 	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/julienschmidt/httprouter"
@@ -51,7 +55,6 @@ type httpServer struct {
 	nsqadmin     *NSQAdmin
 	router       http.Handler
 	client       *http_api.Client
-	ci           *clusterinfo.ClusterInfo
 	basePath     string
 	devStaticDir string
 }
@@ -72,7 +75,6 @@ func NewHTTPServer(nsqadmin *NSQAdmin) *httpServer {
 		nsqadmin: nsqadmin,
 		router:   router,
 		client:   client,
-		ci:       clusterinfo.New(nsqadmin.logf, client),
 
 		basePath:     nsqadmin.getOpts().BasePath,
 		devStaticDir: nsqadmin.getOpts().DevStaticDir,
@@ -89,9 +91,16 @@ func NewHTTPServer(nsqadmin *NSQAdmin) *httpServer {
 	router.Handle("GET", bp("/topics/:topic"), http_api.Decorate(s.indexHandler, log))
 	router.Handle("GET", bp("/topics/:topic/:channel"), http_api.Decorate(s.indexHandler, log))
 	router.Handle("GET", bp("/nodes"), http_api.Decorate(s.indexHandler, log))
-	router.Handle("GET", bp("/nodes/:node"), http_api.Decorate(s.indexHandler, log))
+	router.Handle("GET", bp("/nodes/*node"), http_api.Decorate(s.indexHandler, log))
 	router.Handle("GET", bp("/counter"), http_api.Decorate(s.indexHandler, log))
 	router.Handle("GET", bp("/lookup"), http_api.Decorate(s.indexHandler, log))
+	router.Handle("GET", bp("/overview"), http_api.Decorate(s.indexHandler, log))
+
+	if s.nsqadmin.oidc != nil {
+		router.Handle("GET", bp("/oauth2/login"), http_api.Decorate(s.oidcLoginHandler, log, oidcErrors))
+		router.Handle("GET", bp("/oauth2/callback"), http_api.Decorate(s.oidcCallbackHandler, log, oidcErrors))
+		router.Handle("GET", bp("/oauth2/logout"), http_api.Decorate(s.logoutHandler, log))
+	}
 
 	router.Handle("GET", bp("/static/:asset"), http_api.Decorate(s.staticAssetHandler, log, http_api.PlainText))
 	router.Handle("GET", bp("/fonts/:asset"), http_api.Decorate(s.staticAssetHandler, log, http_api.PlainText))
@@ -106,15 +115,25 @@ func NewHTTPServer(nsqadmin *NSQAdmin) *httpServer {
 	router.Handle("GET", bp("/api/topics/:topic"), http_api.Decorate(s.topicHandler, log, http_api.V1))
 	router.Handle("GET", bp("/api/topics/:topic/:channel"), http_api.Decorate(s.channelHandler, log, http_api.V1))
 	router.Handle("GET", bp("/api/nodes"), http_api.Decorate(s.nodesHandler, log, http_api.V1))
-	router.Handle("GET", bp("/api/nodes/:node"), http_api.Decorate(s.nodeHandler, log, http_api.V1))
+	router.Handle("GET", bp("/api/nodes/*node"), http_api.Decorate(s.nodeHandler, log, http_api.V1))
 	router.Handle("POST", bp("/api/topics"), http_api.Decorate(s.createTopicChannelHandler, log, http_api.V1))
 	router.Handle("POST", bp("/api/topics/:topic"), http_api.Decorate(s.topicActionHandler, log, http_api.V1))
 	router.Handle("POST", bp("/api/topics/:topic/:channel"), http_api.Decorate(s.channelActionHandler, log, http_api.V1))
-	router.Handle("DELETE", bp("/api/nodes/:node"), http_api.Decorate(s.tombstoneNodeForTopicHandler, log, http_api.V1))
+	router.Handle("DELETE", bp("/api/nodes/*node"), http_api.Decorate(s.tombstoneNodeForTopicHandler, log, http_api.V1))
 	router.Handle("DELETE", bp("/api/topics/:topic"), http_api.Decorate(s.deleteTopicHandler, log, http_api.V1))
 	router.Handle("DELETE", bp("/api/topics/:topic/:channel"), http_api.Decorate(s.deleteChannelHandler, log, http_api.V1))
+	router.Handle("POST", bp("/api/bulk"), http_api.Decorate(s.bulkActionHandler, log, http_api.V1))
+	router.Handle("POST", bp("/api/messages/:topic"), http_api.Decorate(s.publishMessageHandler, log, http_api.V1))
+	router.Handle("GET", bp("/api/messages/:topic/:channel"), http_api.Decorate(s.peekChannelHandler, log, http_api.V1))
 	router.Handle("GET", bp("/api/counter"), http_api.Decorate(s.counterHandler, log, http_api.V1))
 	router.Handle("GET", bp("/api/graphite"), http_api.Decorate(s.graphiteHandler, log, http_api.V1))
+	router.Handle("GET", bp("/api/history"), http_api.Decorate(s.historyHandler, log, http_api.V1))
+	router.Handle("GET", bp("/api/history/topics/:topic"), http_api.Decorate(s.historyHandler, log, http_api.V1))
+	router.Handle("GET", bp("/api/history/topics/:topic/:channel"), http_api.Decorate(s.historyHandler, log, http_api.V1))
+	router.Handle("GET", bp("/api/lag"), http_api.Decorate(s.lagHandler, log, http_api.V1))
+	router.Handle("GET", bp("/api/clusters"), http_api.Decorate(s.clustersHandler, log, http_api.V1))
+	router.Handle("GET", bp("/api/alerts"), http_api.Decorate(s.alertsHandler, log, http_api.V1))
+	router.Handle("GET", bp("/api/audit"), http_api.Decorate(s.auditHandler, log, http_api.V1))
 	router.Handle("GET", bp("/config/:opt"), http_api.Decorate(s.doConfig, log, http_api.V1))
 	router.Handle("PUT", bp("/config/:opt"), http_api.Decorate(s.doConfig, log, http_api.V1))
 
@@ -122,6 +141,18 @@ func NewHTTPServer(nsqadmin *NSQAdmin) *httpServer {
 }
 
 func (s *httpServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if s.nsqadmin.authEnabled() && !s.isPublicPath(req.URL.Path) {
+		user := s.authenticate(req)
+		if user == "" {
+			s.unauthenticated(w, req)
+			return
+		}
+		req = withUser(req, user)
+	}
+	req, ok := s.scopeToCluster(w, req)
+	if !ok {
+		return
+	}
 	s.router.ServeHTTP(w, req)
 }
 
@@ -137,6 +168,15 @@ func (s *httpServer) indexHandler(w http.ResponseWriter, req *http.Request, ps h
 		},
 	}).Parse(string(asset))
 
+	type uiCluster struct {
+		Name       string   `json:"name"`
+		NSQLookupd []string `json:"nsqlookupd"`
+	}
+	var clusters []uiCluster
+	for _, c := range s.nsqadmin.clusters() {
+		clusters = append(clusters, uiCluster{c.Name, c.NSQLookupdHTTPAddresses})
+	}
+
 	w.Header().Set("Content-Type", "text/html")
 	t.Execute(w, struct {
 		Version             string
@@ -148,7 +188,13 @@ func (s *httpServer) indexHandler(w http.ResponseWriter, req *http.Request, ps h
 		StatsdGaugeFormat   string
 		StatsdPrefix        string
 		NSQLookupd          []string
+		ClusterName         string
+		Clusters            []uiCluster
 		IsAdmin             bool
+		HistoryEnabled      bool
+		AlertsEnabled       bool
+		User                string
+		LogoutEnabled       bool
 	}{
 		Version:             version.Binary,
 		ProxyGraphite:       s.nsqadmin.getOpts().ProxyGraphite,
@@ -159,7 +205,13 @@ func (s *httpServer) indexHandler(w http.ResponseWriter, req *http.Request, ps h
 		StatsdGaugeFormat:   s.nsqadmin.getOpts().StatsdGaugeFormat,
 		StatsdPrefix:        s.nsqadmin.getOpts().StatsdPrefix,
 		NSQLookupd:          s.nsqadmin.getOpts().NSQLookupdHTTPAddresses,
+		ClusterName:         s.nsqadmin.getOpts().ClusterName,
+		Clusters:            clusters,
 		IsAdmin:             s.isAuthorizedAdminRequest(req),
+		HistoryEnabled:      s.nsqadmin.history != nil,
+		AlertsEnabled:       s.nsqadmin.alerter != nil,
+		User:                s.requestUser(req),
+		LogoutEnabled:       s.nsqadmin.oidc != nil,
 	})
 
 	return nil, nil
@@ -209,6 +261,7 @@ func (s *httpServer) staticAssetHandler(w http.ResponseWriter, req *http.Request
 }
 
 func (s *httpServer) topicsHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	cluster := s.cluster(req)
 	var messages []string
 
 	reqParams, err := http_api.NewReqParams(req)
@@ -217,10 +270,10 @@ func (s *httpServer) topicsHandler(w http.ResponseWriter, req *http.Request, ps
 	}
 
 	var topics []string
-	if len(s.nsqadmin.getOpts().NSQLookupdHTTPAddresses) != 0 {
-		topics, err = s.ci.GetLookupdTopics(s.nsqadmin.getOpts().NSQLookupdHTTPAddresses)
+	if len(cluster.NSQLookupdHTTPAddresses) != 0 {
+		topics, err = s.clusterInfo(cluster).GetLookupdTopics(cluster.NSQLookupdHTTPAddresses)
 	} else {
-		topics, err = s.ci.GetNSQDTopics(s.nsqadmin.getOpts().NSQDHTTPAddresses)
+		topics, err = s.clusterInfo(cluster).GetNSQDTopics(cluster.NSQDHTTPAddresses)
 	}
 	if err != nil {
 		pe, ok := err.(clusterinfo.PartialErr)
@@ -235,15 +288,15 @@ func (s *httpServer) topicsHandler(w http.ResponseWriter, req *http.Request, ps
 	inactive, _ := reqParams.Get("inactive")
 	if inactive == "true" {
 		topicChannelMap := make(map[string][]string)
-		if len(s.nsqadmin.getOpts().NSQLookupdHTTPAddresses) == 0 {
+		if len(cluster.NSQLookupdHTTPAddresses) == 0 {
 			goto respond
 		}
 		for _, topicName := range topics {
-			producers, _ := s.ci.GetLookupdTopicProducers(
-				topicName, s.nsqadmin.getOpts().NSQLookupdHTTPAddresses)
+			producers, _ := s.clusterInfo(cluster).GetLookupdTopicProducers(
+				topicName, cluster.NSQLookupdHTTPAddresses)
 			if len(producers) == 0 {
-				topicChannels, _ := s.ci.GetLookupdTopicChannels(
-					topicName, s.nsqadmin.getOpts().NSQLookupdHTTPAddresses)
+				topicChannels, _ := s.clusterInfo(cluster).GetLookupdTopicChannels(
+					topicName, cluster.NSQLookupdHTTPAddresses)
 				topicChannelMap[topicName] = topicChannels
 			}
 		}
@@ -261,13 +314,14 @@ func (s *httpServer) topicsHandler(w http.ResponseWriter, req *http.Request, ps
 }
 
 func (s *httpServer) topicHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	cluster := s.cluster(req)
 	var messages []string
 
 	topicName := ps.ByName("topic")
 
-	producers, err := s.ci.GetTopicProducers(topicName,
-		s.nsqadmin.getOpts().NSQLookupdHTTPAddresses,
-		s.nsqadmin.getOpts().NSQDHTTPAddresses)
+	producers, err := s.clusterInfo(cluster).GetTopicProducers(topicName,
+		cluster.NSQLookupdHTTPAddresses,
+		cluster.NSQDHTTPAddresses)
 	if err != nil {
 		pe, ok := err.(clusterinfo.PartialErr)
 		if !ok {
@@ -277,7 +331,7 @@ func (s *httpServer) topicHandler(w http.ResponseWriter, req *http.Request, ps h
 		s.nsqadmin.logf(LOG_WARN, "%s", err)
 		messages = append(messages, pe.Error())
 	}
-	topicStats, _, err := s.ci.GetNSQDStats(producers, topicName, "", false)
+	topicStats, _, err := s.clusterInfo(cluster).GetNSQDStats(producers, topicName, "", false)
 	if err != nil {
 		pe, ok := err.(clusterinfo.PartialErr)
 		if !ok {
@@ -292,6 +346,7 @@ func (s *httpServer) topicHandler(w http.ResponseWriter, req *http.Request, ps h
 	for _, t := range topicStats {
 		allNodesTopicStats.Add(t)
 	}
+	s.nsqadmin.lag.update(cluster.Name, allNodesTopicStats.Channels, time.Now())
 
 	return struct {
 		*clusterinfo.TopicStats
@@ -300,14 +355,15 @@ func (s *httpServer) topicHandler(w http.ResponseWriter, req *http.Request, ps h
 }
 
 func (s *httpServer) channelHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	cluster := s.cluster(req)
 	var messages []string
 
 	topicName := ps.ByName("topic")
 	channelName := ps.ByName("channel")
 
-	producers, err := s.ci.GetTopicProducers(topicName,
-		s.nsqadmin.getOpts().NSQLookupdHTTPAddresses,
-		s.nsqadmin.getOpts().NSQDHTTPAddresses)
+	producers, err := s.clusterInfo(cluster).GetTopicProducers(topicName,
+		cluster.NSQLookupdHTTPAddresses,
+		cluster.NSQDHTTPAddresses)
 	if err != nil {
 		pe, ok := err.(clusterinfo.PartialErr)
 		if !ok {
@@ -317,7 +373,7 @@ func (s *httpServer) channelHandler(w http.ResponseWriter, req *http.Request, ps
 		s.nsqadmin.logf(LOG_WARN, "%s", err)
 		messages = append(messages, pe.Error())
 	}
-	_, channelStats, err := s.ci.GetNSQDStats(producers, topicName, channelName, true)
+	_, channelStats, err := s.clusterInfo(cluster).GetNSQDStats(producers, topicName, channelName, true)
 	if err != nil {
 		pe, ok := err.(clusterinfo.PartialErr)
 		if !ok {
@@ -327,6 +383,7 @@ func (s *httpServer) channelHandler(w http.ResponseWriter, req *http.Request, ps
 		s.nsqadmin.logf(LOG_WARN, "%s", err)
 		messages = append(messages, pe.Error())
 	}
+	s.nsqadmin.lag.update(cluster.Name, channelStatsList(channelStats), time.Now())
 
 	return struct {
 		*clusterinfo.ChannelStats
@@ -335,9 +392,10 @@ func (s *httpServer) channelHandler(w http.ResponseWriter, req *http.Request, ps
 }
 
 func (s *httpServer) nodesHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	cluster := s.cluster(req)
 	var messages []string
 
-	producers, err := s.ci.GetProducers(s.nsqadmin.getOpts().NSQLookupdHTTPAddresses, s.nsqadmin.getOpts().NSQDHTTPAddresses)
+	producers, err := s.clusterInfo(cluster).GetProducers(cluster.NSQLookupdHTTPAddresses, cluster.NSQDHTTPAddresses)
 	if err != nil {
 		pe, ok := err.(clusterinfo.PartialErr)
 		if !ok {
@@ -355,11 +413,13 @@ func (s *httpServer) nodesHandler(w http.ResponseWriter, req *http.Request, ps h
 }
 
 func (s *httpServer) nodeHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	cluster := s.cluster(req)
 	var messages []string
 
-	node := ps.ByName("node")
+	// node is a catch-all so that it can be a unix:///path/to/socket
+	node := strings.TrimPrefix(ps.ByName("node"), "/")
 
-	producers, err := s.ci.GetProducers(s.nsqadmin.getOpts().NSQLookupdHTTPAddresses, s.nsqadmin.getOpts().NSQDHTTPAddresses)
+	producers, err := s.clusterInfo(cluster).GetProducers(cluster.NSQLookupdHTTPAddresses, cluster.NSQDHTTPAddresses)
 	if err != nil {
 		pe, ok := err.(clusterinfo.PartialErr)
 		if !ok {
@@ -375,7 +435,7 @@ func (s *httpServer) nodeHandler(w http.ResponseWriter, req *http.Request, ps ht
 		return nil, http_api.Err{404, "NODE_NOT_FOUND"}
 	}
 
-	topicStats, _, err := s.ci.GetNSQDStats(clusterinfo.Producers{producer}, "", "", true)
+	topicStats, _, err := s.clusterInfo(cluster).GetNSQDStats(clusterinfo.Producers{producer}, "", "", true)
 	if err != nil {
 		s.nsqadmin.logf(LOG_ERROR, "failed to get nsqd stats - %s", err)
 		return nil, http_api.Err{502, fmt.Sprintf("UPSTREAM_ERROR: %s", err)}
@@ -406,9 +466,10 @@ func (s *httpServer) nodeHandler(w http.ResponseWriter, req *http.Request, ps ht
 }
 
 func (s *httpServer) tombstoneNodeForTopicHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	cluster := s.cluster(req)
 	var messages []string
 
-	node := ps.ByName("node")
+	node := strings.TrimPrefix(ps.ByName("node"), "/")
 
 	var body struct {
 		Topic string `json:"topic"`
@@ -422,8 +483,12 @@ func (s *httpServer) tombstoneNodeForTopicHandler(w http.ResponseWriter, req *ht
 		return nil, http_api.Err{400, "INVALID_TOPIC"}
 	}
 
-	err = s.ci.TombstoneNodeForTopic(body.Topic, node,
-		s.nsqadmin.getOpts().NSQLookupdHTTPAddresses)
+	if !s.isAuthorizedRequest(req, RoleAdmin, body.Topic, "") {
+		return nil, http_api.Err{403, "FORBIDDEN"}
+	}
+
+	err = s.clusterInfo(cluster).TombstoneNodeForTopic(body.Topic, node,
+		cluster.NSQLookupdHTTPAddresses)
 	if err != nil {
 		pe, ok := err.(clusterinfo.PartialErr)
 		if !ok {
@@ -442,6 +507,7 @@ func (s *httpServer) tombstoneNodeForTopicHandler(w http.ResponseWriter, req *ht
 }
 
 func (s *httpServer) createTopicChannelHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	cluster := s.cluster(req)
 	var messages []string
 
 	var body struct {
@@ -449,10 +515,6 @@ func (s *httpServer) createTopicChannelHandler(w http.ResponseWriter, req *http.
 		Channel string `json:"channel"`
 	}
 
-	if !s.isAuthorizedAdminRequest(req) {
-		return nil, http_api.Err{403, "FORBIDDEN"}
-	}
-
 	err := json.NewDecoder(req.Body).Decode(&body)
 	if err != nil {
 		return nil, http_api.Err{400, err.Error()}
@@ -466,8 +528,12 @@ func (s *httpServer) createTopicChannelHandler(w http.ResponseWriter, req *http.
 		return nil, http_api.Err{400, "INVALID_CHANNEL"}
 	}
 
-	err = s.ci.CreateTopicChannel(body.Topic, body.Channel,
-		s.nsqadmin.getOpts().NSQLookupdHTTPAddresses)
+	if !s.isAuthorizedRequest(req, RoleAdmin, body.Topic, body.Channel) {
+		return nil, http_api.Err{403, "FORBIDDEN"}
+	}
+
+	err = s.clusterInfo(cluster).CreateTopicChannel(body.Topic, body.Channel,
+		cluster.NSQLookupdHTTPAddresses)
 	if err != nil {
 		pe, ok := err.(clusterinfo.PartialErr)
 		if !ok {
@@ -489,17 +555,18 @@ func (s *httpServer) createTopicChannelHandler(w http.ResponseWriter, req *http.
 }
 
 func (s *httpServer) deleteTopicHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	cluster := s.cluster(req)
 	var messages []string
 
-	if !s.isAuthorizedAdminRequest(req) {
+	topicName := ps.ByName("topic")
+
+	if !s.isAuthorizedRequest(req, RoleAdmin, topicName, "") {
 		return nil, http_api.Err{403, "FORBIDDEN"}
 	}
 
-	topicName := ps.ByName("topic")
-
-	err := s.ci.DeleteTopic(topicName,
-		s.nsqadmin.getOpts().NSQLookupdHTTPAddresses,
-		s.nsqadmin.getOpts().NSQDHTTPAddresses)
+	err := s.clusterInfo(cluster).DeleteTopic(topicName,
+		cluster.NSQLookupdHTTPAddresses,
+		cluster.NSQDHTTPAddresses)
 	if err != nil {
 		pe, ok := err.(clusterinfo.PartialErr)
 		if !ok {
@@ -518,18 +585,19 @@ func (s *httpServer) deleteTopicHandler(w http.ResponseWriter, req *http.Request
 }
 
 func (s *httpServer) deleteChannelHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	cluster := s.cluster(req)
 	var messages []string
 
-	if !s.isAuthorizedAdminRequest(req) {
-		return nil, http_api.Err{403, "FORBIDDEN"}
-	}
-
 	topicName := ps.ByName("topic")
 	channelName := ps.ByName("channel")
 
-	err := s.ci.DeleteChannel(topicName, channelName,
-		s.nsqadmin.getOpts().NSQLookupdHTTPAddresses,
-		s.nsqadmin.getOpts().NSQDHTTPAddresses)
+	if !s.isAuthorizedRequest(req, RoleAdmin, topicName, channelName) {
+		return nil, http_api.Err{403, "FORBIDDEN"}
+	}
+
+	err := s.clusterInfo(cluster).DeleteChannel(topicName, channelName,
+		cluster.NSQLookupdHTTPAddresses,
+		cluster.NSQDHTTPAddresses)
 	if err != nil {
 		pe, ok := err.(clusterinfo.PartialErr)
 		if !ok {
@@ -559,13 +627,14 @@ func (s *httpServer) channelActionHandler(w http.ResponseWriter, req *http.Reque
 }
 
 func (s *httpServer) topicChannelAction(req *http.Request, topicName string, channelName string) (interface{}, error) {
+	cluster := s.cluster(req)
 	var messages []string
 
 	var body struct {
 		Action string `json:"action"`
 	}
 
-	if !s.isAuthorizedAdminRequest(req) {
+	if !s.isAuthorizedRequest(req, RoleOperator, topicName, channelName) {
 		return nil, http_api.Err{403, "FORBIDDEN"}
 	}
 
@@ -577,43 +646,43 @@ func (s *httpServer) topicChannelAction(req *http.Request, topicName string, cha
 	switch body.Action {
 	case "pause":
 		if channelName != "" {
-			err = s.ci.PauseChannel(topicName, channelName,
-				s.nsqadmin.getOpts().NSQLookupdHTTPAddresses,
-				s.nsqadmin.getOpts().NSQDHTTPAddresses)
+			err = s.clusterInfo(cluster).PauseChannel(topicName, channelName,
+				cluster.NSQLookupdHTTPAddresses,
+				cluster.NSQDHTTPAddresses)
 
 			s.notifyAdminAction("pause_channel", topicName, channelName, "", req)
 		} else {
-			err = s.ci.PauseTopic(topicName,
-				s.nsqadmin.getOpts().NSQLookupdHTTPAddresses,
-				s.nsqadmin.getOpts().NSQDHTTPAddresses)
+			err = s.clusterInfo(cluster).PauseTopic(topicName,
+				cluster.NSQLookupdHTTPAddresses,
+				cluster.NSQDHTTPAddresses)
 
 			s.notifyAdminAction("pause_topic", topicName, "", "", req)
 		}
 	case "unpause":
 		if channelName != "" {
-			err = s.ci.UnPauseChannel(topicName, channelName,
-				s.nsqadmin.getOpts().NSQLookupdHTTPAddresses,
-				s.nsqadmin.getOpts().NSQDHTTPAddresses)
+			err = s.clusterInfo(cluster).UnPauseChannel(topicName, channelName,
+				cluster.NSQLookupdHTTPAddresses,
+				cluster.NSQDHTTPAddresses)
 
 			s.notifyAdminAction("unpause_channel", topicName, channelName, "", req)
 		} else {
-			err = s.ci.UnPauseTopic(topicName,
-				s.nsqadmin.getOpts().NSQLookupdHTTPAddresses,
-				s.nsqadmin.getOpts().NSQDHTTPAddresses)
+			err = s.clusterInfo(cluster).UnPauseTopic(topicName,
+				cluster.NSQLookupdHTTPAddresses,
+				cluster.NSQDHTTPAddresses)
 
 			s.notifyAdminAction("unpause_topic", topicName, "", "", req)
 		}
 	case "empty":
 		if channelName != "" {
-			err = s.ci.EmptyChannel(topicName, channelName,
-				s.nsqadmin.getOpts().NSQLookupdHTTPAddresses,
-				s.nsqadmin.getOpts().NSQDHTTPAddresses)
+			err = s.clusterInfo(cluster).EmptyChannel(topicName, channelName,
+				cluster.NSQLookupdHTTPAddresses,
+				cluster.NSQDHTTPAddresses)
 
 			s.notifyAdminAction("empty_channel", topicName, channelName, "", req)
 		} else {
-			err = s.ci.EmptyTopic(topicName,
-				s.nsqadmin.getOpts().NSQLookupdHTTPAddresses,
-				s.nsqadmin.getOpts().NSQDHTTPAddresses)
+			err = s.clusterInfo(cluster).EmptyTopic(topicName,
+				cluster.NSQLookupdHTTPAddresses,
+				cluster.NSQDHTTPAddresses)
 
 			s.notifyAdminAction("empty_topic", topicName, "", "", req)
 		}
@@ -636,6 +705,254 @@ func (s *httpServer) topicChannelAction(req *http.Request, topicName string, cha
 	}{maybeWarnMsg(messages)}, nil
 }
 
+// bulkActionHandler pauses, unpauses, empties or deletes the topics (or
+// channels) matching a regex and/or on the nsqd matching a label selector, or
+// only lists them on a dry run
+func (s *httpServer) bulkActionHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	cluster := s.cluster(req)
+	var messages []string
+
+	var body struct {
+		Action   string `json:"action"`
+		Topic    string `json:"topic"`
+		Channel  string `json:"channel"`
+		Selector string `json:"selector"`
+		DryRun   bool   `json:"dry_run"`
+	}
+
+	err := json.NewDecoder(req.Body).Decode(&body)
+	if err != nil {
+		return nil, http_api.Err{400, err.Error()}
+	}
+
+	role := RoleOperator
+	switch body.Action {
+	case "pause", "unpause", "empty":
+	case "delete":
+		role = RoleAdmin
+	default:
+		return nil, http_api.Err{400, "INVALID_ACTION"}
+	}
+
+	// never select everything by omission
+	if body.Topic == "" && body.Selector == "" {
+		return nil, http_api.Err{400, "MISSING_ARG_TOPIC"}
+	}
+	var topicRegex, channelRegex *regexp.Regexp
+	if body.Topic != "" {
+		topicRegex, err = regexp.Compile(body.Topic)
+		if err != nil {
+			return nil, http_api.Err{400, "INVALID_ARG_TOPIC"}
+		}
+	}
+	if body.Channel != "" {
+		channelRegex, err = regexp.Compile(body.Channel)
+		if err != nil {
+			return nil, http_api.Err{400, "INVALID_ARG_CHANNEL"}
+		}
+	}
+	if body.Selector != "" && len(cluster.NSQLookupdHTTPAddresses) == 0 {
+		return nil, http_api.Err{400, "SELECTOR_REQUIRES_LOOKUPD"}
+	}
+
+	targets, err := s.clusterInfo(cluster).GetBulkTargets(topicRegex, channelRegex, body.Selector,
+		cluster.NSQLookupdHTTPAddresses,
+		cluster.NSQDHTTPAddresses)
+	if err != nil {
+		pe, ok := err.(clusterinfo.PartialErr)
+		if !ok {
+			s.nsqadmin.logf(LOG_ERROR, "failed to get bulk targets - %s", err)
+			return nil, http_api.Err{502, fmt.Sprintf("UPSTREAM_ERROR: %s", err)}
+		}
+		s.nsqadmin.logf(LOG_WARN, "%s", err)
+		messages = append(messages, pe.Error())
+	}
+	if targets == nil {
+		targets = []*clusterinfo.BulkTarget{}
+	}
+
+	for _, t := range targets {
+		if !s.isAuthorizedRequest(req, role, t.Topic, t.Channel) {
+			return nil, http_api.Err{403, "FORBIDDEN"}
+		}
+	}
+
+	if !body.DryRun {
+		// with a selector other nsqd may still have the topic or channel, so
+		// it stays registered on nsqlookupd
+		lookupdHTTPAddrs := cluster.NSQLookupdHTTPAddresses
+		if body.Selector != "" {
+			lookupdHTTPAddrs = nil
+		}
+		for _, t := range targets {
+			err := s.clusterInfo(cluster).BulkAction(t, body.Action, lookupdHTTPAddrs)
+			if err != nil {
+				s.nsqadmin.logf(LOG_WARN, "failed to %s topic/channel - %s", body.Action, err)
+				messages = append(messages, err.Error())
+			}
+
+			action := body.Action + "_topic"
+			if t.Channel != "" {
+				action = body.Action + "_channel"
+			}
+			if body.Selector == "" {
+				s.notifyAdminAction(action, t.Topic, t.Channel, "", req)
+				continue
+			}
+			for _, node := range t.Nodes {
+				s.notifyAdminAction(action, t.Topic, t.Channel, node, req)
+			}
+		}
+	}
+
+	return struct {
+		Targets []*clusterinfo.BulkTarget `json:"targets"`
+		DryRun  bool                      `json:"dry_run"`
+		Message string                    `json:"message"`
+	}{targets, body.DryRun, maybeWarnMsg(messages)}, nil
+}
+
+// topicProducer returns the producer of a topic in a cluster with the given
+// HTTP address, or the first one when node is empty
+func (s *httpServer) topicProducer(cluster *cluster, topicName string, node string) (*clusterinfo.Producer, error) {
+	producers, err := s.clusterInfo(cluster).GetTopicProducers(topicName,
+		cluster.NSQLookupdHTTPAddresses,
+		cluster.NSQDHTTPAddresses)
+	if err != nil {
+		if _, ok := err.(clusterinfo.PartialErr); !ok {
+			s.nsqadmin.logf(LOG_ERROR, "failed to get topic producers - %s", err)
+			return nil, http_api.Err{502, fmt.Sprintf("UPSTREAM_ERROR: %s", err)}
+		}
+		s.nsqadmin.logf(LOG_WARN, "%s", err)
+	}
+	if len(producers) == 0 {
+		return nil, http_api.Err{404, "TOPIC_NOT_FOUND"}
+	}
+	if node == "" {
+		return producers[0], nil
+	}
+	// only nodes producing the topic, nsqadmin must not POST to arbitrary addresses
+	producer := producers.Search(node)
+	if producer == nil {
+		return nil, http_api.Err{400, "INVALID_NODE"}
+	}
+	return producer, nil
+}
+
+// publishMessageHandler publishes a (test) message to a topic on one of its
+// nodes
+func (s *httpServer) publishMessageHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	topicName := ps.ByName("topic")
+
+	var body struct {
+		Node string `json:"node"`
+		Body string `json:"body"`
+	}
+
+	if !s.isAuthorizedRequest(req, RoleOperator, topicName, "") {
+		return nil, http_api.Err{403, "FORBIDDEN"}
+	}
+
+	err := json.NewDecoder(req.Body).Decode(&body)
+	if err != nil {
+		return nil, http_api.Err{400, err.Error()}
+	}
+	if body.Body == "" {
+		return nil, http_api.Err{400, "MSG_EMPTY"}
+	}
+
+	cluster := s.cluster(req)
+	producer, err := s.topicProducer(cluster, topicName, body.Node)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.clusterInfo(cluster).PublishMessage(topicName, producer, []byte(body.Body))
+	if err != nil {
+		s.nsqadmin.logf(LOG_ERROR, "failed to publish message - %s", err)
+		return nil, http_api.Err{502, fmt.Sprintf("UPSTREAM_ERROR: %s", err)}
+	}
+
+	s.notifyAdminAction("publish_message", topicName, "", producer.HTTPAddress(), req)
+
+	return struct {
+		Node    string `json:"node"`
+		Message string `json:"message"`
+	}{producer.HTTPAddress(), ""}, nil
+}
+
+// peekChannelHandler serves a sample of the messages waiting in a channel on
+// one node (or all of them) without consuming them
+func (s *httpServer) peekChannelHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	cluster := s.cluster(req)
+	var messages []string
+
+	topicName := ps.ByName("topic")
+	channelName := ps.ByName("channel")
+
+	// message bodies may be more sensitive than the stats viewers see
+	if !s.isAuthorizedRequest(req, RoleOperator, topicName, channelName) {
+		return nil, http_api.Err{403, "FORBIDDEN"}
+	}
+
+	reqParams, err := http_api.NewReqParams(req)
+	if err != nil {
+		return nil, http_api.Err{400, "INVALID_REQUEST"}
+	}
+	count := 10
+	if cs, err := reqParams.Get("count"); err == nil {
+		count, err = strconv.Atoi(cs)
+		if err != nil || count <= 0 || count > 100 {
+			return nil, http_api.Err{400, "INVALID_ARG_COUNT"}
+		}
+	}
+
+	var producers clusterinfo.Producers
+	node, _ := reqParams.Get("node")
+	if node != "" {
+		producer, err := s.topicProducer(cluster, topicName, node)
+		if err != nil {
+			return nil, err
+		}
+		producers = clusterinfo.Producers{producer}
+	} else {
+		producers, err = s.clusterInfo(cluster).GetTopicProducers(topicName,
+			cluster.NSQLookupdHTTPAddresses,
+			cluster.NSQDHTTPAddresses)
+		if err != nil {
+			pe, ok := err.(clusterinfo.PartialErr)
+			if !ok {
+				s.nsqadmin.logf(LOG_ERROR, "failed to get topic producers - %s", err)
+				return nil, http_api.Err{502, fmt.Sprintf("UPSTREAM_ERROR: %s", err)}
+			}
+			s.nsqadmin.logf(LOG_WARN, "%s", err)
+			messages = append(messages, pe.Error())
+		}
+	}
+
+	peeked, inMemory, err := s.clusterInfo(cluster).PeekChannel(topicName, channelName, producers, count)
+	if err != nil {
+		pe, ok := err.(clusterinfo.PartialErr)
+		if !ok {
+			s.nsqadmin.logf(LOG_ERROR, "failed to peek channel - %s", err)
+			return nil, http_api.Err{502, fmt.Sprintf("UPSTREAM_ERROR: %s", err)}
+		}
+		s.nsqadmin.logf(LOG_WARN, "%s", err)
+		messages = append(messages, pe.Error())
+	}
+	if peeked == nil {
+		peeked = []*clusterinfo.PeekedMessage{}
+	}
+
+	s.notifyAdminAction("peek_channel", topicName, channelName, node, req)
+
+	return struct {
+		Messages []*clusterinfo.PeekedMessage `json:"messages"`
+		InMemory int                          `json:"in_memory"`
+		Message  string                       `json:"message"`
+	}{peeked, inMemory, maybeWarnMsg(messages)}, nil
+}
+
 type counterStats struct {
 	Node         string `json:"node"`
 	TopicName    string `json:"topic_name"`
@@ -644,10 +961,11 @@ type counterStats struct {
 }
 
 func (s *httpServer) counterHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	cluster := s.cluster(req)
 	var messages []string
 	stats := make(map[string]*counterStats)
 
-	producers, err := s.ci.GetProducers(s.nsqadmin.getOpts().NSQLookupdHTTPAddresses, s.nsqadmin.getOpts().NSQDHTTPAddresses)
+	producers, err := s.clusterInfo(cluster).GetProducers(cluster.NSQLookupdHTTPAddresses, cluster.NSQDHTTPAddresses)
 	if err != nil {
 		pe, ok := err.(clusterinfo.PartialErr)
 		if !ok {
@@ -657,7 +975,7 @@ func (s *httpServer) counterHandler(w http.ResponseWriter, req *http.Request, ps
 		s.nsqadmin.logf(LOG_WARN, "%s", err)
 		messages = append(messages, pe.Error())
 	}
-	_, channelStats, err := s.ci.GetNSQDStats(producers, "", "", false)
+	_, channelStats, err := s.clusterInfo(cluster).GetNSQDStats(producers, "", "", false)
 	if err != nil {
 		pe, ok := err.(clusterinfo.PartialErr)
 		if !ok {
@@ -667,6 +985,7 @@ func (s *httpServer) counterHandler(w http.ResponseWriter, req *http.Request, ps
 		s.nsqadmin.logf(LOG_WARN, "%s", err)
 		messages = append(messages, pe.Error())
 	}
+	s.nsqadmin.lag.update(cluster.Name, channelStatsList(channelStats), time.Now())
 
 	for _, channelStats := range channelStats {
 		for _, hostChannelStats := range channelStats.NodeStats {
@@ -739,6 +1058,219 @@ func (s *httpServer) graphiteHandler(w http.ResponseWriter, req *http.Request, p
 	}{rateStr}, nil
 }
 
+// historyHandler serves the built-in history of a topic or channel over the
+// last window (default 2h), or the history settings when neither is given
+func (s *httpServer) historyHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	h := s.nsqadmin.history
+	// the history is only sampled from the default cluster
+	if h == nil || !s.isDefaultCluster(req) {
+		return nil, http_api.Err{404, "HISTORY_NOT_ENABLED"}
+	}
+
+	reqParams, err := http_api.NewReqParams(req)
+	if err != nil {
+		return nil, http_api.Err{400, "INVALID_REQUEST"}
+	}
+
+	opts := s.nsqadmin.getOpts()
+	topicName := ps.ByName("topic")
+	if topicName == "" {
+		return struct {
+			Interval  int `json:"interval"`
+			Retention int `json:"retention"`
+		}{
+			Interval:  int(opts.HistoryInterval / time.Second),
+			Retention: int(opts.HistoryRetention / time.Second),
+		}, nil
+	}
+
+	window := 2 * time.Hour
+	if ws, err := reqParams.Get("window"); err == nil {
+		window, err = time.ParseDuration(ws)
+		if err != nil || window <= 0 {
+			return nil, http_api.Err{400, "INVALID_ARG_WINDOW"}
+		}
+	}
+
+	return struct {
+		Interval int           `json:"interval"`
+		Points   []HistoryRate `json:"points"`
+	}{
+		Interval: int(opts.HistoryInterval / time.Second),
+		Points:   h.series(topicName, ps.ByName("channel"), time.Now().Add(-window)),
+	}, nil
+}
+
+// lagHandler serves the lag of every channel, of a topic's channels or of a
+// single channel (given the topic and channel params)
+func (s *httpServer) lagHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	cluster := s.cluster(req)
+	var messages []string
+
+	reqParams, err := http_api.NewReqParams(req)
+	if err != nil {
+		return nil, http_api.Err{400, "INVALID_REQUEST"}
+	}
+	topicName, _ := reqParams.Get("topic")
+	channelName, _ := reqParams.Get("channel")
+	if channelName != "" && topicName == "" {
+		return nil, http_api.Err{400, "MISSING_ARG_TOPIC"}
+	}
+
+	var producers clusterinfo.Producers
+	if topicName != "" {
+		producers, err = s.clusterInfo(cluster).GetTopicProducers(topicName,
+			cluster.NSQLookupdHTTPAddresses,
+			cluster.NSQDHTTPAddresses)
+	} else {
+		producers, err = s.clusterInfo(cluster).GetProducers(cluster.NSQLookupdHTTPAddresses,
+			cluster.NSQDHTTPAddresses)
+	}
+	if err != nil {
+		pe, ok := err.(clusterinfo.PartialErr)
+		if !ok {
+			s.nsqadmin.logf(LOG_ERROR, "failed to get producers - %s", err)
+			return nil, http_api.Err{502, fmt.Sprintf("UPSTREAM_ERROR: %s", err)}
+		}
+		s.nsqadmin.logf(LOG_WARN, "%s", err)
+		messages = append(messages, pe.Error())
+	}
+	var channelStats map[string]*clusterinfo.ChannelStats
+	if len(producers) > 0 {
+		_, channelStats, err = s.clusterInfo(cluster).GetNSQDStats(producers, topicName, channelName, false)
+		if err != nil {
+			pe, ok := err.(clusterinfo.PartialErr)
+			if !ok {
+				s.nsqadmin.logf(LOG_ERROR, "failed to get nsqd stats - %s", err)
+				return nil, http_api.Err{502, fmt.Sprintf("UPSTREAM_ERROR: %s", err)}
+			}
+			s.nsqadmin.logf(LOG_WARN, "%s", err)
+			messages = append(messages, pe.Error())
+		}
+	}
+
+	channels := channelStatsList(channelStats)
+	s.nsqadmin.lag.update(cluster.Name, channels, time.Now())
+	lags := make([]*clusterinfo.ChannelLag, 0, len(channels))
+	for _, c := range channels {
+		lags = append(lags, c.Lag)
+	}
+	sort.Slice(lags, func(i, j int) bool {
+		if lags[i].TopicName == lags[j].TopicName {
+			return lags[i].ChannelName < lags[j].ChannelName
+		}
+		return lags[i].TopicName < lags[j].TopicName
+	})
+
+	return struct {
+		Channels []*clusterinfo.ChannelLag `json:"channels"`
+		Message  string                    `json:"message"`
+	}{lags, maybeWarnMsg(messages)}, nil
+}
+
+// alertsHandler serves the alert rules, the pending and firing alerts and the
+// most recently resolved ones
+func (s *httpServer) clustersHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	clusters := s.nsqadmin.clusters()
+	overviews := make([]*clusterOverview, len(clusters))
+	var wg sync.WaitGroup
+	for i, c := range clusters {
+		wg.Add(1)
+		go func(i int, c *cluster) {
+			defer wg.Done()
+			overviews[i] = s.getClusterOverview(c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	return struct {
+		Clusters []*clusterOverview `json:"clusters"`
+	}{overviews}, nil
+}
+
+func (s *httpServer) alertsHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	a := s.nsqadmin.alerter
+	// the alerter only watches the default cluster
+	if a == nil || !s.isDefaultCluster(req) {
+		return nil, http_api.Err{404, "ALERTS_NOT_ENABLED"}
+	}
+
+	return struct {
+		Interval int          `json:"interval"`
+		Rules    []*AlertRule `json:"rules"`
+		Alerts   []*Alert     `json:"alerts"`
+		Resolved []*Alert     `json:"resolved"`
+	}{
+		Interval: int(s.nsqadmin.getOpts().AlertInterval / time.Second),
+		Rules:    a.rules,
+		Alerts:   a.active(),
+		Resolved: a.recentlyResolved(),
+	}, nil
+}
+
+// auditHandler serves the newest admin actions of the audit log matching the
+// user, action, topic, channel, since and until (unix seconds or RFC3339) params
+func (s *httpServer) auditHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	if s.nsqadmin.audit == nil {
+		return nil, http_api.Err{404, "AUDIT_LOG_NOT_ENABLED"}
+	}
+
+	// the audit log covers every topic and channel
+	if !s.isAuthorizedRequest(req, RoleAdmin, "", "") {
+		return nil, http_api.Err{403, "FORBIDDEN"}
+	}
+
+	reqParams, err := http_api.NewReqParams(req)
+	if err != nil {
+		return nil, http_api.Err{400, "INVALID_REQUEST"}
+	}
+
+	var filter auditFilter
+	filter.User, _ = reqParams.Get("user")
+	filter.Action, _ = reqParams.Get("action")
+	filter.Topic, _ = reqParams.Get("topic")
+	filter.Channel, _ = reqParams.Get("channel")
+	if since, err := reqParams.Get("since"); err == nil {
+		filter.Since, err = parseAuditTime(since)
+		if err != nil {
+			return nil, http_api.Err{400, "INVALID_ARG_SINCE"}
+		}
+	}
+	if until, err := reqParams.Get("until"); err == nil {
+		filter.Until, err = parseAuditTime(until)
+		if err != nil {
+			return nil, http_api.Err{400, "INVALID_ARG_UNTIL"}
+		}
+	}
+	limit := 100
+	if ls, err := reqParams.Get("limit"); err == nil {
+		limit, err = strconv.Atoi(ls)
+		if err != nil || limit <= 0 {
+			return nil, http_api.Err{400, "INVALID_ARG_LIMIT"}
+		}
+	}
+
+	actions, err := s.nsqadmin.audit.query(filter, limit)
+	if err != nil {
+		s.nsqadmin.logf(LOG_ERROR, "failed to read audit log - %s", err)
+		return nil, http_api.Err{500, "INTERNAL_ERROR"}
+	}
+	return struct {
+		Actions []*AdminAction `json:"actions"`
+	}{actions}, nil
+}
+
+func parseAuditTime(s string) (int64, error) {
+	if ts, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return ts, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Unix(), nil
+}
+
 func (s *httpServer) doConfig(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
 	opt := ps.ByName("opt")
 
@@ -800,13 +1332,17 @@ func (s *httpServer) doConfig(w http.ResponseWriter, req *http.Request, ps httpr
 	return v, nil
 }
 
+// isAuthorizedAdminRequest returns whether the request may perform any
+// privileged action (which the UI offers when it does)
 func (s *httpServer) isAuthorizedAdminRequest(req *http.Request) bool {
+	if s.nsqadmin.aclPolicy != nil {
+		return s.nsqadmin.aclPolicy.canModify(s.requestUser(req))
+	}
 	adminUsers := s.nsqadmin.getOpts().AdminUsers
 	if len(adminUsers) == 0 {
 		return true
 	}
-	aclHTTPHeader := s.nsqadmin.getOpts().ACLHTTPHeader
-	user := req.Header.Get(aclHTTPHeader)
+	user := s.requestUser(req)
 	for _, v := range adminUsers {
 		if v == user {
 			return true
@@ -815,6 +1351,16 @@ func (s *httpServer) isAuthorizedAdminRequest(req *http.Request) bool {
 	return false
 }
 
+// isAuthorizedRequest returns whether the request has (at least) role on the
+// given topic, or channel when not empty, of the request's cluster under
+// --acl-policy-file and otherwise whether it is from an admin user
+func (s *httpServer) isAuthorizedRequest(req *http.Request, role string, topic string, channel string) bool {
+	if s.nsqadmin.aclPolicy == nil {
+		return s.isAuthorizedAdminRequest(req)
+	}
+	return s.nsqadmin.aclPolicy.allows(s.requestUser(req), role, s.cluster(req).Name, topic, channel)
+}
+
 func getOptByCfgName(opts interface{}, name string) (interface{}, bool) {
 	val := reflect.ValueOf(opts).Elem()
 	typ := val.Type()
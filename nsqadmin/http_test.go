@@ -2,13 +2,26 @@ package nsqadmin
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math/big"
 	"net"
 	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -59,7 +72,7 @@ func mustStartNSQLookupd(opts *nsqlookupd.Options) (*net.TCPAddr, *net.TCPAddr,
 			panic(err)
 		}
 	}()
-	return lookupd.RealTCPAddr(), lookupd.RealHTTPAddr(), lookupd
+	return lookupd.RealTCPAddr().(*net.TCPAddr), lookupd.RealHTTPAddr().(*net.TCPAddr), lookupd
 }
 
 func bootstrapNSQCluster(t *testing.T) (string, []*nsqd.NSQD, []*nsqlookupd.NSQLookupd, *NSQAdmin) {
@@ -67,6 +80,14 @@ func bootstrapNSQCluster(t *testing.T) (string, []*nsqd.NSQD, []*nsqlookupd.NSQL
 }
 
 func bootstrapNSQClusterWithAuth(t *testing.T, withAuth bool) (string, []*nsqd.NSQD, []*nsqlookupd.NSQLookupd, *NSQAdmin) {
+	return bootstrapNSQClusterWithOpts(t, func(opts *Options) {
+		if withAuth {
+			opts.AdminUsers = []string{"matt"}
+		}
+	})
+}
+
+func bootstrapNSQClusterWithOpts(t *testing.T, setOpts func(*Options)) (string, []*nsqd.NSQD, []*nsqlookupd.NSQLookupd, *NSQAdmin) {
 	lgr := test.NewTestLogger(t)
 
 	nsqlookupdOpts := nsqlookupd.NewOptions()
@@ -113,9 +134,7 @@ func bootstrapNSQClusterWithAuth(t *testing.T, withAuth bool) (string, []*nsqd.N
 	nsqadminOpts.HTTPAddress = "127.0.0.1:0"
 	nsqadminOpts.NSQLookupdHTTPAddresses = []string{nsqlookupd1.RealHTTPAddr().String()}
 	nsqadminOpts.Logger = lgr
-	if withAuth {
-		nsqadminOpts.AdminUsers = []string{"matt"}
-	}
+	setOpts(nsqadminOpts)
 	nsqadmin1, err := New(nsqadminOpts)
 	if err != nil {
 		panic(err)
@@ -642,3 +661,719 @@ func TestHTTPconfigCIDR(t *testing.T) {
 	_, _ = io.ReadAll(resp.Body)
 	test.Equal(t, 403, resp.StatusCode)
 }
+
+func TestHTTPHistoryGET(t *testing.T) {
+	historyPath, err := os.MkdirTemp("", "nsq-history-")
+	test.Nil(t, err)
+	dataPath, nsqds, nsqlookupds, nsqadmin1 := bootstrapNSQClusterWithOpts(t, func(opts *Options) {
+		opts.HistoryDataPath = historyPath
+		opts.HistoryInterval = 50 * time.Millisecond
+	})
+	defer os.RemoveAll(dataPath)
+	defer os.RemoveAll(historyPath)
+	defer nsqds[0].Exit()
+	defer nsqlookupds[0].Exit()
+	defer nsqadmin1.Exit()
+
+	topicName := "test_history_get" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqds[0].GetTopic(topicName)
+	topic.GetChannel("ch")
+	for i := 0; i < 10; i++ {
+		topic.PutMessage(nsqd.NewMessage(topic.GenerateID(), []byte("test body")))
+	}
+	time.Sleep(300 * time.Millisecond)
+
+	var doc struct {
+		Interval int           `json:"interval"`
+		Points   []HistoryRate `json:"points"`
+	}
+	url := fmt.Sprintf("http://%s/api/history/topics/%s/ch?window=1h", nsqadmin1.RealHTTPAddr(), topicName)
+	resp, err := http.Get(url)
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	err = json.Unmarshal(body, &doc)
+	test.Nil(t, err)
+	test.Equal(t, true, len(doc.Points) > 1)
+	test.Equal(t, int64(10), doc.Points[len(doc.Points)-1].Depth)
+
+	url = fmt.Sprintf("http://%s/api/history/topics/%s?window=forever", nsqadmin1.RealHTTPAddr(), topicName)
+	resp, err = http.Get(url)
+	test.Nil(t, err)
+	resp.Body.Close()
+	test.Equal(t, 400, resp.StatusCode)
+
+	// samples are persisted
+	h, err := newHistory(historyPath, 50*time.Millisecond, time.Hour)
+	test.Nil(t, err)
+	defer h.close()
+	test.Equal(t, true, len(h.series(topicName, "ch", time.Time{})) >= len(doc.Points))
+}
+
+func TestHTTPACLPolicy(t *testing.T) {
+	policyPath, err := os.MkdirTemp("", "nsq-acl-")
+	test.Nil(t, err)
+	defer os.RemoveAll(policyPath)
+	policyFile := filepath.Join(policyPath, "policy.json")
+	err = os.WriteFile(policyFile, []byte(`[
+		{"users": ["team"], "role": "operator", "topic": "test_acl_policy.*", "channel": "mine"}
+	]`), 0600)
+	test.Nil(t, err)
+
+	dataPath, nsqds, nsqlookupds, nsqadmin1 := bootstrapNSQClusterWithOpts(t, func(opts *Options) {
+		opts.ACLPolicyFile = policyFile
+	})
+	defer os.RemoveAll(dataPath)
+	defer nsqds[0].Exit()
+	defer nsqlookupds[0].Exit()
+	defer nsqadmin1.Exit()
+
+	topicName := "test_acl_policy" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqds[0].GetTopic(topicName)
+	mine := topic.GetChannel("mine")
+	mine.PutMessage(nsqd.NewMessage(nsqd.MessageID{}, []byte("1234")))
+	theirs := topic.GetChannel("theirs")
+	theirs.PutMessage(nsqd.NewMessage(nsqd.MessageID{}, []byte("1234")))
+
+	time.Sleep(100 * time.Millisecond)
+
+	client := http.Client{}
+	doAction := func(method string, path string, action string, user string) int {
+		url := fmt.Sprintf("http://%s%s", nsqadmin1.RealHTTPAddr(), path)
+		body, _ := json.Marshal(map[string]interface{}{
+			"action": action,
+		})
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(body))
+		req.Header.Set("X-Forwarded-User", user)
+		resp, err := client.Do(req)
+		test.Nil(t, err)
+		_, _ = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	test.Equal(t, 403, doAction("POST", "/api/topics/"+topicName+"/mine", "empty", "other"))
+	test.Equal(t, 403, doAction("POST", "/api/topics/"+topicName+"/theirs", "empty", "team"))
+	test.Equal(t, 403, doAction("POST", "/api/topics/"+topicName, "empty", "team"))
+	test.Equal(t, 403, doAction("DELETE", "/api/topics/"+topicName+"/mine", "", "team"))
+	test.Equal(t, 200, doAction("POST", "/api/topics/"+topicName+"/mine", "empty", "team"))
+
+	test.Equal(t, int64(0), mine.Depth())
+	test.Equal(t, int64(1), theirs.Depth())
+}
+
+func TestHTTPBasicAuth(t *testing.T) {
+	htpasswdPath, err := os.MkdirTemp("", "nsq-htpasswd-")
+	test.Nil(t, err)
+	defer os.RemoveAll(htpasswdPath)
+	htpasswdFile := filepath.Join(htpasswdPath, "htpasswd")
+	// both with password "password"
+	err = os.WriteFile(htpasswdFile, []byte("matt:$apr1$abcdefgh$FBwExRW4dCc8aL.OvjpIE1\n"+
+		"jehiah:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"), 0600)
+	test.Nil(t, err)
+
+	dataPath, nsqds, nsqlookupds, nsqadmin1 := bootstrapNSQClusterWithOpts(t, func(opts *Options) {
+		opts.HTPasswdFile = htpasswdFile
+		opts.AdminUsers = []string{"matt"}
+	})
+	defer os.RemoveAll(dataPath)
+	defer nsqds[0].Exit()
+	defer nsqlookupds[0].Exit()
+	defer nsqadmin1.Exit()
+
+	topicName := "test_basic_auth" + strconv.Itoa(int(time.Now().Unix()))
+	nsqds[0].GetTopic(topicName).GetChannel("ch")
+	time.Sleep(100 * time.Millisecond)
+
+	client := http.Client{}
+	doRequest := func(method string, path string, user string, password string) *http.Response {
+		url := fmt.Sprintf("http://%s%s", nsqadmin1.RealHTTPAddr(), path)
+		body, _ := json.Marshal(map[string]interface{}{
+			"action": "pause",
+		})
+		req, _ := http.NewRequest(method, url, bytes.NewBuffer(body))
+		if user != "" {
+			req.SetBasicAuth(user, password)
+		}
+		// not trusted when nsqadmin authenticates users itself
+		req.Header.Set("X-Forwarded-User", "matt")
+		resp, err := client.Do(req)
+		test.Nil(t, err)
+		_, _ = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := doRequest("GET", "/api/topics", "", "")
+	test.Equal(t, 401, resp.StatusCode)
+	test.Equal(t, `Basic realm="nsqadmin"`, resp.Header.Get("WWW-Authenticate"))
+	test.Equal(t, 401, doRequest("GET", "/", "matt", "wrong").StatusCode)
+	test.Equal(t, 200, doRequest("GET", "/ping", "", "").StatusCode)
+	test.Equal(t, 200, doRequest("GET", "/api/topics", "jehiah", "password").StatusCode)
+
+	test.Equal(t, 403, doRequest("POST", "/api/topics/"+topicName+"/ch", "jehiah", "password").StatusCode)
+	test.Equal(t, 200, doRequest("POST", "/api/topics/"+topicName+"/ch", "matt", "password").StatusCode)
+}
+
+// newTestOIDCProvider is a minimal OpenID Connect provider that logs every
+// authorization request in as user
+func newTestOIDCProvider(t *testing.T, clientID string, clientSecret string, user string) *httptest.Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	test.Nil(t, err)
+
+	var srv *httptest.Server
+	nonces := make(map[string]string)
+	var mtx sync.Mutex
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 srv.URL,
+			"authorization_endpoint": srv.URL + "/authorize",
+			"token_endpoint":         srv.URL + "/token",
+			"jwks_uri":               srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		if q.Get("client_id") != clientID || q.Get("response_type") != "code" {
+			http.Error(w, "invalid_request", 400)
+			return
+		}
+		code := strconv.Itoa(int(time.Now().UnixNano()))
+		mtx.Lock()
+		nonces[code] = q.Get("nonce")
+		mtx.Unlock()
+		redirectURI, _ := url.Parse(q.Get("redirect_uri"))
+		redirectURI.RawQuery = url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+		http.Redirect(w, req, redirectURI.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, req *http.Request) {
+		id, secret, _ := req.BasicAuth()
+		mtx.Lock()
+		nonce, ok := nonces[req.PostFormValue("code")]
+		delete(nonces, req.PostFormValue("code"))
+		mtx.Unlock()
+		if id != clientID || secret != clientSecret || !ok {
+			w.WriteHeader(400)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test"})
+		claims, _ := json.Marshal(map[string]interface{}{
+			"iss":   srv.URL,
+			"aud":   clientID,
+			"exp":   time.Now().Add(time.Minute).Unix(),
+			"nonce": nonce,
+			"email": user,
+		})
+		signed := base64.RawURLEncoding.EncodeToString(header) + "." +
+			base64.RawURLEncoding.EncodeToString(claims)
+		digest := sha256.Sum256([]byte(signed))
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		json.NewEncoder(w).Encode(map[string]string{
+			"id_token": signed + "." + base64.RawURLEncoding.EncodeToString(sig),
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "test",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	srv = httptest.NewServer(mux)
+	return srv
+}
+
+func TestHTTPOIDCLogin(t *testing.T) {
+	provider := newTestOIDCProvider(t, "nsqadmin", "s3cret", "matt@example.com")
+	defer provider.Close()
+
+	dataPath, nsqds, nsqlookupds, nsqadmin1 := bootstrapNSQClusterWithOpts(t, func(opts *Options) {
+		opts.OIDCIssuerURL = provider.URL
+		opts.OIDCClientID = "nsqadmin"
+		opts.OIDCClientSecret = "s3cret"
+		opts.AdminUsers = []string{"matt@example.com"}
+	})
+	defer os.RemoveAll(dataPath)
+	defer nsqds[0].Exit()
+	defer nsqlookupds[0].Exit()
+	defer nsqadmin1.Exit()
+
+	topicName := "test_oidc_login" + strconv.Itoa(int(time.Now().Unix()))
+	nsqds[0].GetTopic(topicName).GetChannel("ch")
+	time.Sleep(100 * time.Millisecond)
+
+	jar, _ := cookiejar.New(nil)
+	client := http.Client{Jar: jar}
+
+	resp, err := client.Get(fmt.Sprintf("http://%s/api/topics", nsqadmin1.RealHTTPAddr()))
+	test.Nil(t, err)
+	resp.Body.Close()
+	test.Equal(t, 401, resp.StatusCode)
+
+	// the page redirects through the provider and back
+	resp, err = client.Get(fmt.Sprintf("http://%s/topics", nsqadmin1.RealHTTPAddr()))
+	test.Nil(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	test.Equal(t, 200, resp.StatusCode)
+	test.Equal(t, "/topics", resp.Request.URL.Path)
+	test.Equal(t, true, strings.Contains(string(body), `var USER = "matt@example.com";`))
+
+	resp, err = client.Get(fmt.Sprintf("http://%s/api/topics", nsqadmin1.RealHTTPAddr()))
+	test.Nil(t, err)
+	resp.Body.Close()
+	test.Equal(t, 200, resp.StatusCode)
+
+	// the session identifies an admin user
+	url := fmt.Sprintf("http://%s/api/topics/%s/ch", nsqadmin1.RealHTTPAddr(), topicName)
+	resp, err = client.Post(url, "application/json", strings.NewReader(`{"action": "pause"}`))
+	test.Nil(t, err)
+	resp.Body.Close()
+	test.Equal(t, 200, resp.StatusCode)
+
+	// a forged session is rejected
+	req, _ := http.NewRequest("GET", fmt.Sprintf("http://%s/api/topics", nsqadmin1.RealHTTPAddr()), nil)
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"user":"matt@example.com","exp":9999999999}`))
+	req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: forged + ".AAAA"})
+	resp, err = http.DefaultClient.Do(req)
+	test.Nil(t, err)
+	resp.Body.Close()
+	test.Equal(t, 401, resp.StatusCode)
+
+	// (not following the redirect, which would log in again)
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err = client.Get(fmt.Sprintf("http://%s/oauth2/logout", nsqadmin1.RealHTTPAddr()))
+	test.Nil(t, err)
+	resp.Body.Close()
+	test.Equal(t, 302, resp.StatusCode)
+	resp, err = client.Get(fmt.Sprintf("http://%s/api/topics", nsqadmin1.RealHTTPAddr()))
+	test.Nil(t, err)
+	resp.Body.Close()
+	test.Equal(t, 401, resp.StatusCode)
+}
+
+func TestHTTPAuditLog(t *testing.T) {
+	auditPath, err := os.MkdirTemp("", "nsq-audit-")
+	test.Nil(t, err)
+	defer os.RemoveAll(auditPath)
+
+	// the notification endpoint fails twice before accepting it
+	var attempts int32
+	notifications := make(chan AdminAction, 1)
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&attempts, 1) <= 2 {
+			w.WriteHeader(503)
+			return
+		}
+		var a AdminAction
+		json.NewDecoder(req.Body).Decode(&a)
+		notifications <- a
+	}))
+	defer endpoint.Close()
+
+	dataPath, nsqds, nsqlookupds, nsqadmin1 := bootstrapNSQClusterWithOpts(t, func(opts *Options) {
+		opts.AuditLogPath = filepath.Join(auditPath, "audit.log")
+		opts.NotificationHTTPEndpoint = endpoint.URL
+		opts.NotificationRetryBackoff = 10 * time.Millisecond
+	})
+	defer os.RemoveAll(dataPath)
+	defer nsqds[0].Exit()
+	defer nsqlookupds[0].Exit()
+	defer nsqadmin1.Exit()
+
+	topicName := "test_audit_log" + strconv.Itoa(int(time.Now().Unix()))
+	nsqds[0].GetTopic(topicName).GetChannel("ch")
+	time.Sleep(100 * time.Millisecond)
+
+	client := http.Client{}
+	url := fmt.Sprintf("http://%s/api/topics/%s/ch", nsqadmin1.RealHTTPAddr(), topicName)
+	req, _ := http.NewRequest("POST", url, strings.NewReader(`{"action": "empty"}`))
+	req.SetBasicAuth("matt", "")
+	resp, err := client.Do(req)
+	test.Nil(t, err)
+	resp.Body.Close()
+	test.Equal(t, 200, resp.StatusCode)
+
+	select {
+	case a := <-notifications:
+		test.Equal(t, "empty_channel", a.Action)
+		test.Equal(t, int32(3), atomic.LoadInt32(&attempts))
+	case <-time.After(5 * time.Second):
+		t.Fatal("notification was not retried")
+	}
+
+	var doc struct {
+		Actions []AdminAction `json:"actions"`
+	}
+	url = fmt.Sprintf("http://%s/api/audit?user=matt&topic=%s&since=%d",
+		nsqadmin1.RealHTTPAddr(), topicName, time.Now().Add(-time.Minute).Unix())
+	resp, err = http.Get(url)
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	err = json.Unmarshal(body, &doc)
+	test.Nil(t, err)
+	test.Equal(t, 1, len(doc.Actions))
+	test.Equal(t, "empty_channel", doc.Actions[0].Action)
+	test.Equal(t, "ch", doc.Actions[0].Channel)
+
+	resp, err = http.Get(fmt.Sprintf("http://%s/api/audit?user=jehiah", nsqadmin1.RealHTTPAddr()))
+	test.Nil(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	err = json.Unmarshal(body, &doc)
+	test.Nil(t, err)
+	test.Equal(t, 0, len(doc.Actions))
+}
+
+func TestHTTPPublishAndPeekMessages(t *testing.T) {
+	dataPath, nsqds, nsqlookupds, nsqadmin1 := bootstrapNSQCluster(t)
+	defer os.RemoveAll(dataPath)
+	defer nsqds[0].Exit()
+	defer nsqlookupds[0].Exit()
+	defer nsqadmin1.Exit()
+
+	topicName := "test_publish_peek" + strconv.Itoa(int(time.Now().Unix()))
+	nsqds[0].GetTopic(topicName).GetChannel("ch")
+	time.Sleep(100 * time.Millisecond)
+
+	client := http.Client{}
+	url := fmt.Sprintf("http://%s/api/messages/%s", nsqadmin1.RealHTTPAddr(), topicName)
+	req, _ := http.NewRequest("POST", url, strings.NewReader(`{"body": "{\"a\": 1}"}`))
+	resp, err := client.Do(req)
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	resp.Body.Close()
+
+	// only the nodes producing the topic can be published to
+	req, _ = http.NewRequest("POST", url, strings.NewReader(`{"node": "127.0.0.1:1", "body": "x"}`))
+	resp, err = client.Do(req)
+	test.Nil(t, err)
+	test.Equal(t, 400, resp.StatusCode)
+	resp.Body.Close()
+
+	var doc struct {
+		Messages []struct {
+			Node string `json:"node"`
+			Body []byte `json:"body"`
+		} `json:"messages"`
+		InMemory int `json:"in_memory"`
+	}
+	url = fmt.Sprintf("http://%s/api/messages/%s/ch?count=5", nsqadmin1.RealHTTPAddr(), topicName)
+
+	// the message is in memory, which is only peeked while the channel is paused
+	resp, err = http.Get(url)
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	err = json.Unmarshal(body, &doc)
+	test.Nil(t, err)
+	test.Equal(t, 0, len(doc.Messages))
+	test.Equal(t, 1, doc.InMemory)
+
+	nsqds[0].GetTopic(topicName).GetChannel("ch").Pause()
+	for i := 0; i < 2; i++ {
+		resp, err = http.Get(url)
+		test.Nil(t, err)
+		test.Equal(t, 200, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		err = json.Unmarshal(body, &doc)
+		test.Nil(t, err)
+		test.Equal(t, 1, len(doc.Messages))
+		test.Equal(t, 0, doc.InMemory)
+		test.Equal(t, `{"a": 1}`, string(doc.Messages[0].Body))
+		test.Equal(t, nsqds[0].RealHTTPAddr().String(), doc.Messages[0].Node)
+	}
+
+	resp, err = http.Get(url + "&node=127.0.0.1:1")
+	test.Nil(t, err)
+	test.Equal(t, 400, resp.StatusCode)
+	resp.Body.Close()
+}
+
+func TestHTTPBulkAction(t *testing.T) {
+	dataPath, nsqds, nsqlookupds, nsqadmin1 := bootstrapNSQCluster(t)
+	defer os.RemoveAll(dataPath)
+	defer nsqds[0].Exit()
+	defer nsqlookupds[0].Exit()
+	defer nsqadmin1.Exit()
+
+	suffix := strconv.Itoa(int(time.Now().Unix()))
+	nsqds[0].GetTopic("test_bulk_a" + suffix).GetChannel("ch")
+	nsqds[0].GetTopic("test_bulk_b" + suffix).GetChannel("ch")
+	nsqds[0].GetTopic("test_bulk_b" + suffix).GetChannel("other")
+	nsqds[0].GetTopic("test_unbulk" + suffix).GetChannel("ch")
+	time.Sleep(100 * time.Millisecond)
+
+	var doc struct {
+		Targets []struct {
+			Topic   string   `json:"topic"`
+			Channel string   `json:"channel"`
+			Nodes   []string `json:"nodes"`
+		} `json:"targets"`
+	}
+	client := http.Client{}
+	url := fmt.Sprintf("http://%s/api/bulk", nsqadmin1.RealHTTPAddr())
+	for _, dryRun := range []bool{true, false} {
+		reqBody := fmt.Sprintf(`{"action": "pause", "topic": "^test_bulk_.%s$", "channel": "^ch$", "dry_run": %v}`,
+			suffix, dryRun)
+		req, _ := http.NewRequest("POST", url, strings.NewReader(reqBody))
+		resp, err := client.Do(req)
+		test.Nil(t, err)
+		test.Equal(t, 200, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		err = json.Unmarshal(body, &doc)
+		test.Nil(t, err)
+		test.Equal(t, 2, len(doc.Targets))
+		test.Equal(t, "test_bulk_a"+suffix, doc.Targets[0].Topic)
+		test.Equal(t, "ch", doc.Targets[1].Channel)
+		test.Equal(t, []string{nsqds[0].RealHTTPAddr().String()}, doc.Targets[1].Nodes)
+
+		topic, _ := nsqds[0].GetExistingTopic("test_bulk_a" + suffix)
+		channel, _ := topic.GetExistingChannel("ch")
+		test.Equal(t, !dryRun, channel.IsPaused())
+	}
+
+	topic, _ := nsqds[0].GetExistingTopic("test_bulk_b" + suffix)
+	channel, _ := topic.GetExistingChannel("other")
+	test.Equal(t, false, channel.IsPaused())
+	topic, _ = nsqds[0].GetExistingTopic("test_unbulk" + suffix)
+	channel, _ = topic.GetExistingChannel("ch")
+	test.Equal(t, false, channel.IsPaused())
+
+	req, _ := http.NewRequest("POST", url, strings.NewReader(`{"action": "pause"}`))
+	resp, err := client.Do(req)
+	test.Nil(t, err)
+	test.Equal(t, 400, resp.StatusCode)
+	resp.Body.Close()
+}
+
+func TestHTTPLag(t *testing.T) {
+	dataPath, nsqds, nsqlookupds, nsqadmin1 := bootstrapNSQCluster(t)
+	defer os.RemoveAll(dataPath)
+	defer nsqds[0].Exit()
+	defer nsqlookupds[0].Exit()
+	defer nsqadmin1.Exit()
+
+	topicName := "test_lag" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqds[0].GetTopic(topicName)
+	topic.GetChannel("ch")
+	msg := nsqd.NewMessage(topic.GenerateID(), []byte("1234"))
+	msg.Timestamp = time.Now().Add(-time.Minute).UnixNano()
+	topic.PutMessage(msg)
+	time.Sleep(100 * time.Millisecond)
+
+	var doc struct {
+		Channels []*clusterinfo.ChannelLag `json:"channels"`
+	}
+	url := fmt.Sprintf("http://%s/api/lag?topic=%s&channel=ch", nsqadmin1.RealHTTPAddr(), topicName)
+	resp, err := http.Get(url)
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	err = json.Unmarshal(body, &doc)
+	test.Nil(t, err)
+	test.Equal(t, 1, len(doc.Channels))
+	test.Equal(t, "ch", doc.Channels[0].ChannelName)
+	test.Equal(t, int64(1), doc.Channels[0].Depth)
+	test.Equal(t, true, doc.Channels[0].OldestMessageAge >= int64(time.Minute))
+	test.Equal(t, int64(-1), doc.Channels[0].DrainETA)
+}
+
+func TestHTTPUnixSockets(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "nsq-test-")
+	test.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	lgr := test.NewTestLogger(t)
+
+	nsqlookupdOpts := nsqlookupd.NewOptions()
+	nsqlookupdOpts.Logger = lgr
+	nsqlookupdOpts.UseUnixSockets = true
+	nsqlookupdOpts.TCPAddress = filepath.Join(tmpDir, "nsqlookupd.tcp.sock")
+	nsqlookupdOpts.HTTPAddress = filepath.Join(tmpDir, "nsqlookupd.http.sock")
+	nsqlookupd1, err := nsqlookupd.New(nsqlookupdOpts)
+	test.Nil(t, err)
+	go nsqlookupd1.Main()
+	defer nsqlookupd1.Exit()
+
+	nsqdOpts := nsqd.NewOptions()
+	nsqdOpts.Logger = lgr
+	nsqdOpts.UseUnixSockets = true
+	nsqdOpts.TCPAddress = filepath.Join(tmpDir, "nsqd.tcp.sock")
+	nsqdOpts.HTTPAddress = filepath.Join(tmpDir, "nsqd.http.sock")
+	nsqdOpts.NSQLookupdTCPAddresses = []string{"unix://" + nsqlookupdOpts.TCPAddress}
+	nsqdOpts.DataPath = tmpDir
+	nsqd1, err := nsqd.New(nsqdOpts)
+	test.Nil(t, err)
+	go nsqd1.Main()
+	defer nsqd1.Exit()
+
+	nsqadminOpts := NewOptions()
+	nsqadminOpts.HTTPAddress = "127.0.0.1:0"
+	nsqadminOpts.NSQLookupdHTTPAddresses = []string{"unix://" + nsqlookupdOpts.HTTPAddress}
+	nsqadminOpts.Logger = lgr
+	nsqadmin1, err := New(nsqadminOpts)
+	test.Nil(t, err)
+	go nsqadmin1.Main()
+	defer nsqadmin1.Exit()
+
+	topicName := "test_unix_sockets" + strconv.Itoa(int(time.Now().Unix()))
+	nsqd1.GetTopic(topicName).GetChannel("ch")
+	time.Sleep(100 * time.Millisecond)
+
+	node := "unix://" + nsqdOpts.HTTPAddress
+
+	var nodesDoc struct {
+		Nodes []struct {
+			HTTPSocket string `json:"http_socket"`
+			TCPSocket  string `json:"tcp_socket"`
+		} `json:"nodes"`
+	}
+	resp, err := http.Get(fmt.Sprintf("http://%s/api/nodes", nsqadmin1.RealHTTPAddr()))
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	err = json.NewDecoder(resp.Body).Decode(&nodesDoc)
+	resp.Body.Close()
+	test.Nil(t, err)
+	test.Equal(t, 1, len(nodesDoc.Nodes))
+	test.Equal(t, nsqdOpts.HTTPAddress, nodesDoc.Nodes[0].HTTPSocket)
+	test.Equal(t, nsqdOpts.TCPAddress, nodesDoc.Nodes[0].TCPSocket)
+
+	var channelDoc struct {
+		Depth int64 `json:"depth"`
+		Nodes []struct {
+			Node string `json:"node"`
+		} `json:"nodes"`
+	}
+	resp, err = http.Get(fmt.Sprintf("http://%s/api/topics/%s/ch", nsqadmin1.RealHTTPAddr(), topicName))
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	err = json.NewDecoder(resp.Body).Decode(&channelDoc)
+	resp.Body.Close()
+	test.Nil(t, err)
+	test.Equal(t, 1, len(channelDoc.Nodes))
+	test.Equal(t, node, channelDoc.Nodes[0].Node)
+
+	var nodeDoc struct {
+		Node       string                    `json:"node"`
+		TopicStats []*clusterinfo.TopicStats `json:"topics"`
+	}
+	resp, err = http.Get(fmt.Sprintf("http://%s/api/nodes/%s", nsqadmin1.RealHTTPAddr(), url.PathEscape(node)))
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	err = json.NewDecoder(resp.Body).Decode(&nodeDoc)
+	resp.Body.Close()
+	test.Nil(t, err)
+	test.Equal(t, node, nodeDoc.Node)
+	test.Equal(t, 1, len(nodeDoc.TopicStats))
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"node": node,
+		"body": "over a unix socket",
+	})
+	resp, err = http.Post(fmt.Sprintf("http://%s/api/messages/%s", nsqadmin1.RealHTTPAddr(), topicName),
+		"application/json", bytes.NewBuffer(body))
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	resp.Body.Close()
+	test.Equal(t, int64(1), nsqd1.GetTopic(topicName).Depth()+nsqd1.GetTopic(topicName).GetChannel("ch").Depth())
+}
+
+func TestHTTPClusters(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "nsq-test-")
+	test.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	nsqdOpts := nsqd.NewOptions()
+	nsqdOpts.TCPAddress = "127.0.0.1:0"
+	nsqdOpts.HTTPAddress = "127.0.0.1:0"
+	nsqdOpts.BroadcastAddress = "127.0.0.1"
+	nsqdOpts.Logger = test.NewTestLogger(t)
+	nsqdOpts.DataPath = tmpDir
+	nsqd2, err := nsqd.New(nsqdOpts)
+	test.Nil(t, err)
+	go nsqd2.Main()
+	defer nsqd2.Exit()
+
+	dataPath, nsqds, nsqlookupds, nsqadmin1 := bootstrapNSQClusterWithOpts(t, func(opts *Options) {
+		opts.ClusterNSQDHTTPAddresses = []string{"dc2=" + nsqd2.RealHTTPAddr().String()}
+	})
+	defer os.RemoveAll(dataPath)
+	defer nsqds[0].Exit()
+	defer nsqlookupds[0].Exit()
+	defer nsqadmin1.Exit()
+
+	topicName := "test_clusters" + strconv.Itoa(int(time.Now().Unix()))
+	nsqds[0].GetTopic(topicName + "_default")
+	topic := nsqd2.GetTopic(topicName)
+	topic.GetChannel("ch")
+	topic.PutMessage(nsqd.NewMessage(topic.GenerateID(), []byte("1234")))
+	time.Sleep(100 * time.Millisecond)
+
+	get := func(uri string, v interface{}) int {
+		resp, err := http.Get(fmt.Sprintf("http://%s%s", nsqadmin1.RealHTTPAddr(), uri))
+		test.Nil(t, err)
+		defer resp.Body.Close()
+		if v != nil {
+			err = json.NewDecoder(resp.Body).Decode(v)
+			test.Nil(t, err)
+		}
+		return resp.StatusCode
+	}
+
+	var overview struct {
+		Clusters []*clusterOverview `json:"clusters"`
+	}
+	test.Equal(t, 200, get("/api/clusters", &overview))
+	test.Equal(t, 2, len(overview.Clusters))
+	test.Equal(t, "default", overview.Clusters[0].Name)
+	test.Equal(t, true, overview.Clusters[0].Default)
+	test.Equal(t, "ok", overview.Clusters[0].Health)
+	test.Equal(t, "dc2", overview.Clusters[1].Name)
+	test.Equal(t, false, overview.Clusters[1].Default)
+	test.Equal(t, "ok", overview.Clusters[1].Health)
+	test.Equal(t, 1, overview.Clusters[1].Nodes)
+	test.Equal(t, 1, overview.Clusters[1].Topics)
+	test.Equal(t, 1, overview.Clusters[1].Channels)
+	test.Equal(t, int64(1), overview.Clusters[1].Depth)
+
+	var topics TopicsDoc
+	test.Equal(t, 200, get("/api/clusters/dc2/topics", &topics))
+	test.Equal(t, []interface{}{topicName}, topics.Topics)
+	test.Equal(t, 200, get("/api/topics", &topics))
+	test.Equal(t, []interface{}{topicName + "_default"}, topics.Topics)
+	test.Equal(t, 200, get("/api/clusters/default/topics", &topics))
+	test.Equal(t, []interface{}{topicName + "_default"}, topics.Topics)
+
+	var channel ChannelStatsDoc
+	test.Equal(t, 200, get(fmt.Sprintf("/api/clusters/dc2/topics/%s/ch", topicName), &channel))
+	test.Equal(t, int64(1), channel.Depth)
+
+	test.Equal(t, 404, get("/api/clusters/dc3/topics", nil))
+	test.Equal(t, 404, get("/api/clusters/dc2/history", nil))
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"action": "pause",
+	})
+	url := fmt.Sprintf("http://%s/api/clusters/dc2/topics/%s", nsqadmin1.RealHTTPAddr(), topicName)
+	resp, err := http.Post(url, "application/json", bytes.NewBuffer(body))
+	test.Nil(t, err)
+	test.Equal(t, 200, resp.StatusCode)
+	resp.Body.Close()
+	test.Equal(t, true, topic.IsPaused())
+}
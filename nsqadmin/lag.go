@@ -0,0 +1,111 @@
+package nsqadmin
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nsqio/nsq/internal/clusterinfo"
+)
+
+const (
+	// samples closer together than this are not kept
+	lagSampleInterval = time.Second
+	// rates are computed over the samples of (at most) this long
+	lagWindow = 5 * time.Minute
+)
+
+// lagSample is the state of a channel summed over all nodes
+type lagSample struct {
+	ts           time.Time
+	messageCount int64
+	// depth, in-flight and deferred messages
+	backlog int64
+}
+
+// lagTracker computes the publish and consume rates of channels from the
+// successive stats nsqadmin fetches, whether for a page, a history or alert
+// sample or /api/lag
+type lagTracker struct {
+	sync.Mutex
+	samples map[string][]lagSample // oldest first
+}
+
+func newLagTracker() *lagTracker {
+	return &lagTracker{samples: make(map[string][]lagSample)}
+}
+
+// update records a sample of each channel of a cluster (summed over all
+// nodes) and sets their Lag
+func (l *lagTracker) update(cluster string, channels []*clusterinfo.ChannelStats, now time.Time) {
+	l.Lock()
+	defer l.Unlock()
+	for _, c := range channels {
+		key := cluster + "/" + c.TopicName + ":" + c.ChannelName
+		s := lagSample{
+			ts:           now,
+			messageCount: c.MessageCount,
+			backlog:      c.Depth + c.InFlightCount + c.DeferredCount,
+		}
+		samples := l.samples[key]
+		if len(samples) == 0 || now.Sub(samples[len(samples)-1].ts) >= lagSampleInterval {
+			samples = append(samples, s)
+		}
+		i := sort.Search(len(samples), func(i int) bool {
+			return now.Sub(samples[i].ts) <= lagWindow
+		})
+		samples = samples[i:]
+		l.samples[key] = samples
+		c.Lag = computeLag(c, samples[0], s, now)
+	}
+
+	// forget deleted channels
+	for key, samples := range l.samples {
+		if now.Sub(samples[len(samples)-1].ts) > lagWindow {
+			delete(l.samples, key)
+		}
+	}
+}
+
+func channelStatsList(m map[string]*clusterinfo.ChannelStats) []*clusterinfo.ChannelStats {
+	channels := make([]*clusterinfo.ChannelStats, 0, len(m))
+	for _, c := range m {
+		channels = append(channels, c)
+	}
+	return channels
+}
+
+func computeLag(c *clusterinfo.ChannelStats, first lagSample, last lagSample, now time.Time) *clusterinfo.ChannelLag {
+	lag := &clusterinfo.ChannelLag{
+		TopicName:   c.TopicName,
+		ChannelName: c.ChannelName,
+		Depth:       c.Depth,
+		DrainETA:    -1,
+	}
+	if c.OldestMessageTimestamp > 0 {
+		lag.OldestMessageAge = now.UnixNano() - c.OldestMessageTimestamp
+		if lag.OldestMessageAge < 0 {
+			lag.OldestMessageAge = 0
+		}
+	}
+	if c.Depth == 0 {
+		lag.DrainETA = 0
+	}
+
+	elapsed := last.ts.Sub(first.ts).Seconds()
+	if elapsed <= 0 {
+		return lag
+	}
+	lag.Window = elapsed
+	lag.PublishRate = counterRate(first.messageCount, last.messageCount, elapsed)
+	// whatever came in and is not in the backlog anymore was consumed
+	lag.ConsumeRate = lag.PublishRate - float64(last.backlog-first.backlog)/elapsed
+	if lag.ConsumeRate < 0 {
+		lag.ConsumeRate = 0
+	}
+	if c.Depth > 0 && lag.ConsumeRate > lag.PublishRate {
+		seconds := float64(c.Depth) / (lag.ConsumeRate - lag.PublishRate)
+		lag.DrainETA = int64(seconds * float64(time.Second))
+	}
+	return lag
+}
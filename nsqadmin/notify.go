@@ -14,12 +14,14 @@ type AdminAction struct {
 	Topic     string `json:"topic"`
 	Channel   string `json:"channel,omitempty"`
 	Node      string `json:"node,omitempty"`
+	Cluster   string `json:"cluster,omitempty"`
 	Timestamp int64  `json:"timestamp"`
 	User      string `json:"user,omitempty"`
 	RemoteIP  string `json:"remote_ip"`
 	UserAgent string `json:"user_agent"`
 	URL       string `json:"url"` // The URL of the HTTP request that triggered this action
 	Via       string `json:"via"` // the Hostname of the nsqadmin performing this action
+	Alert     *Alert `json:"alert,omitempty"`
 }
 
 func basicAuthUser(req *http.Request) string {
@@ -38,8 +40,10 @@ func basicAuthUser(req *http.Request) string {
 	return pair[0]
 }
 
+// notifyAdminAction records an admin action in --audit-log-path and sends it to
+// --notification-http-endpoint
 func (s *httpServer) notifyAdminAction(action, topic, channel, node string, req *http.Request) {
-	if s.nsqadmin.getOpts().NotificationHTTPEndpoint == "" {
+	if s.nsqadmin.audit == nil && s.nsqadmin.getOpts().NotificationHTTPEndpoint == "" {
 		return
 	}
 	via, _ := os.Hostname()
@@ -54,18 +58,32 @@ func (s *httpServer) notifyAdminAction(action, topic, channel, node string, req
 		u.Scheme = "https"
 	}
 
+	user := basicAuthUser(req)
+	if s.nsqadmin.authEnabled() {
+		user = s.requestUser(req)
+	}
+
 	a := &AdminAction{
 		Action:    action,
 		Topic:     topic,
 		Channel:   channel,
 		Node:      node,
+		Cluster:   s.cluster(req).Name,
 		Timestamp: time.Now().Unix(),
-		User:      basicAuthUser(req),
+		User:      user,
 		RemoteIP:  req.RemoteAddr,
 		UserAgent: req.UserAgent(),
 		URL:       u.String(),
 		Via:       via,
 	}
-	// Perform all work in a new goroutine so this never blocks
-	go func() { s.nsqadmin.notifications <- a }()
+	if s.nsqadmin.audit != nil {
+		err := s.nsqadmin.audit.append(a)
+		if err != nil {
+			s.nsqadmin.logf(LOG_ERROR, "failed to write admin action %s to audit log - %s", action, err)
+		}
+	}
+	if s.nsqadmin.getOpts().NotificationHTTPEndpoint == "" {
+		return
+	}
+	s.nsqadmin.queueNotification(a)
 }
@@ -2,25 +2,36 @@ package nsqadmin
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/nsqio/nsq/internal/http_api"
 	"github.com/nsqio/nsq/internal/util"
 	"github.com/nsqio/nsq/internal/version"
 )
 
+// the longest wait between attempts to POST a notification
+const maxNotificationRetryBackoff = time.Minute
+
+// the max number of notifications waiting to be POSTed, more are dropped
+const maxQueuedNotifications = 1024
+
 type NSQAdmin struct {
 	sync.RWMutex
 	opts                atomic.Value
@@ -29,6 +40,15 @@ type NSQAdmin struct {
 	notifications       chan *AdminAction
 	graphiteURL         *url.URL
 	httpClientTLSConfig *tls.Config
+	history             *history
+	alerter             *alerter
+	lag                 *lagTracker
+	aclPolicy           *aclPolicy
+	audit               *auditLog
+	htpasswd            htpasswd
+	oidc                *oidcProvider
+	sessionKey          []byte
+	exitChan            chan int
 }
 
 func New(opts *Options) (*NSQAdmin, error) {
@@ -37,7 +57,9 @@ func New(opts *Options) (*NSQAdmin, error) {
 	}
 
 	n := &NSQAdmin{
-		notifications: make(chan *AdminAction),
+		notifications: make(chan *AdminAction, maxQueuedNotifications),
+		lag:           newLagTracker(),
+		exitChan:      make(chan int),
 	}
 	n.swapOpts(opts)
 
@@ -82,19 +104,23 @@ func New(opts *Options) (*NSQAdmin, error) {
 	}
 
 	for _, address := range opts.NSQLookupdHTTPAddresses {
-		_, err := net.ResolveTCPAddr("tcp", address)
+		err := resolveHTTPAddr(address)
 		if err != nil {
 			return nil, fmt.Errorf("failed to resolve --lookupd-http-address (%s) - %s", address, err)
 		}
 	}
 
 	for _, address := range opts.NSQDHTTPAddresses {
-		_, err := net.ResolveTCPAddr("tcp", address)
+		err := resolveHTTPAddr(address)
 		if err != nil {
 			return nil, fmt.Errorf("failed to resolve --nsqd-http-address (%s) - %s", address, err)
 		}
 	}
 
+	if _, err := parseClusters(opts); err != nil {
+		return nil, err
+	}
+
 	if opts.ProxyGraphite {
 		url, err := url.Parse(opts.GraphiteURL)
 		if err != nil {
@@ -110,6 +136,81 @@ func New(opts *Options) (*NSQAdmin, error) {
 		}
 	}
 
+	if opts.HistoryDataPath != "" {
+		if opts.HistoryInterval <= 0 || opts.HistoryRetention < opts.HistoryInterval {
+			return nil, errors.New("--history-interval must be > 0 and <= --history-retention")
+		}
+		h, err := newHistory(opts.HistoryDataPath, opts.HistoryInterval, opts.HistoryRetention)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load history from --history-data-path (%s) - %s",
+				opts.HistoryDataPath, err)
+		}
+		n.history = h
+	}
+
+	if opts.AlertRulesFile != "" {
+		if opts.AlertInterval <= 0 {
+			return nil, errors.New("--alert-interval must be > 0")
+		}
+		rules, err := loadAlertRules(opts.AlertRulesFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load --alert-rules-file (%s) - %s", opts.AlertRulesFile, err)
+		}
+		n.alerter = newAlerter(rules)
+	}
+
+	if opts.ACLPolicyFile != "" {
+		if len(opts.AdminUsers) != 0 {
+			return nil, errors.New("use --admin-user or --acl-policy-file not both")
+		}
+		policy, err := loadACLPolicy(opts.ACLPolicyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load --acl-policy-file (%s) - %s", opts.ACLPolicyFile, err)
+		}
+		n.aclPolicy = policy
+	}
+
+	if opts.NotificationMaxAttempts < 1 {
+		return nil, errors.New("--notification-max-attempts must be >= 1")
+	}
+
+	if opts.AuditLogPath != "" {
+		l, err := newAuditLog(opts.AuditLogPath, opts.AuditLogMaxSize, opts.AuditLogMaxFiles)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open --audit-log-path (%s) - %s", opts.AuditLogPath, err)
+		}
+		n.audit = l
+	}
+
+	if opts.HTPasswdFile != "" {
+		h, err := loadHTPasswd(opts.HTPasswdFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load --htpasswd-file (%s) - %s", opts.HTPasswdFile, err)
+		}
+		n.htpasswd = h
+	}
+
+	if opts.OIDCIssuerURL != "" {
+		if opts.OIDCClientID == "" {
+			return nil, errors.New("--oidc-client-id must be specified with --oidc-issuer-url")
+		}
+		if opts.SessionLifetime <= 0 {
+			return nil, errors.New("--session-lifetime must be > 0")
+		}
+		n.oidc = newOIDCProvider(opts, n.httpClientTLSConfig)
+	}
+
+	if opts.SessionSecret != "" {
+		key := sha256.Sum256([]byte(opts.SessionSecret))
+		n.sessionKey = key[:]
+	} else {
+		// sessions do not survive a restart (or span several nsqadmin)
+		n.sessionKey = make([]byte, 32)
+		if _, err := rand.Read(n.sessionKey); err != nil {
+			return nil, err
+		}
+	}
+
 	opts.BasePath = normalizeBasePath(opts.BasePath)
 
 	n.logf(LOG_INFO, version.String("nsqadmin"))
@@ -123,6 +224,19 @@ func New(opts *Options) (*NSQAdmin, error) {
 	return n, nil
 }
 
+// resolveHTTPAddr checks an nsqd or nsqlookupd HTTP address, either host:port
+// or unix:///path/to/socket
+func resolveHTTPAddr(address string) error {
+	if strings.HasPrefix(address, http_api.UnixSocketPrefix) {
+		if len(address) == len(http_api.UnixSocketPrefix) {
+			return errors.New("missing socket path")
+		}
+		return nil
+	}
+	_, err := net.ResolveTCPAddr("tcp", address)
+	return err
+}
+
 func normalizeBasePath(p string) string {
 	if len(p) == 0 {
 		return "/"
@@ -147,24 +261,80 @@ func (n *NSQAdmin) RealHTTPAddr() *net.TCPAddr {
 }
 
 func (n *NSQAdmin) handleAdminActions() {
-	for action := range n.notifications {
-		content, err := json.Marshal(action)
-		if err != nil {
-			n.logf(LOG_ERROR, "failed to serialize admin action - %s", err)
+	for {
+		select {
+		case action := <-n.notifications:
+			n.sendNotification(action)
+		case <-n.exitChan:
+			return
 		}
-		httpclient := &http.Client{
-			Transport: http_api.NewDeadlineTransport(n.getOpts().HTTPClientConnectTimeout, n.getOpts().HTTPClientRequestTimeout),
+	}
+}
+
+// queueNotification hands an admin action (or alert) to handleAdminActions
+// without blocking the caller, it is dropped when maxQueuedNotifications are
+// already waiting (i.e. the endpoint is down)
+func (n *NSQAdmin) queueNotification(action *AdminAction) {
+	select {
+	case n.notifications <- action:
+	default:
+		n.logf(LOG_ERROR, "dropping notification %s (%d queued)", action.Action, maxQueuedNotifications)
+	}
+}
+
+// sendNotification POSTs an admin action (or alert) to
+// --notification-http-endpoint, retrying up to --notification-max-attempts
+// times with an exponential backoff
+func (n *NSQAdmin) sendNotification(action *AdminAction) {
+	content, err := json.Marshal(action)
+	if err != nil {
+		n.logf(LOG_ERROR, "failed to serialize admin action - %s", err)
+		return
+	}
+
+	backoff := n.getOpts().NotificationRetryBackoff
+	for attempt := 1; ; attempt++ {
+		err := n.postNotification(content)
+		if err == nil {
+			return
 		}
-		n.logf(LOG_INFO, "POSTing notification to %s", n.getOpts().NotificationHTTPEndpoint)
-		resp, err := httpclient.Post(n.getOpts().NotificationHTTPEndpoint,
-			"application/json", bytes.NewBuffer(content))
-		if err != nil {
-			n.logf(LOG_ERROR, "failed to POST notification - %s", err)
+		if attempt >= n.getOpts().NotificationMaxAttempts {
+			n.logf(LOG_ERROR, "failed to POST notification %s (giving up after %d attempts) - %s",
+				action.Action, attempt, err)
+			return
+		}
+		n.logf(LOG_WARN, "failed to POST notification %s (attempt %d, retrying in %s) - %s",
+			action.Action, attempt, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-n.exitChan:
+			return
+		}
+		backoff *= 2
+		if backoff > maxNotificationRetryBackoff {
+			backoff = maxNotificationRetryBackoff
 		}
-		resp.Body.Close()
 	}
 }
 
+func (n *NSQAdmin) postNotification(content []byte) error {
+	httpclient := &http.Client{
+		Transport: http_api.NewDeadlineTransport(n.getOpts().HTTPClientConnectTimeout, n.getOpts().HTTPClientRequestTimeout),
+	}
+	n.logf(LOG_INFO, "POSTing notification to %s", n.getOpts().NotificationHTTPEndpoint)
+	resp, err := httpclient.Post(n.getOpts().NotificationHTTPEndpoint,
+		"application/json", bytes.NewBuffer(content))
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("got response %s", resp.Status)
+	}
+	return nil
+}
+
 func (n *NSQAdmin) Main() error {
 	exitCh := make(chan error)
 	var once sync.Once
@@ -182,6 +352,12 @@ func (n *NSQAdmin) Main() error {
 		exitFunc(http_api.Serve(n.httpListener, http_api.CompressHandler(httpServer), "HTTP", n.logf))
 	})
 	n.waitGroup.Wrap(n.handleAdminActions)
+	if n.history != nil {
+		n.waitGroup.Wrap(n.sampleHistory)
+	}
+	if n.alerter != nil {
+		n.waitGroup.Wrap(n.evaluateAlerts)
+	}
 
 	err := <-exitCh
 	return err
@@ -191,6 +367,9 @@ func (n *NSQAdmin) Exit() {
 	if n.httpListener != nil {
 		n.httpListener.Close()
 	}
-	close(n.notifications)
+	close(n.exitChan)
 	n.waitGroup.Wait()
+	if n.audit != nil {
+		n.audit.close()
+	}
 }
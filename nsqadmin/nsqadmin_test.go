@@ -6,8 +6,12 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/nsqio/nsq/internal/clusterinfo"
 	"github.com/nsqio/nsq/internal/lg"
 	"github.com/nsqio/nsq/internal/test"
 	"github.com/nsqio/nsq/nsqd"
@@ -33,6 +37,357 @@ func TestBothNSQDAndNSQLookup(t *testing.T) {
 	test.Equal(t, "use --nsqd-http-address or --lookupd-http-address not both", fmt.Sprintf("%s", err))
 }
 
+func TestParseClusters(t *testing.T) {
+	opts := NewOptions()
+	opts.NSQLookupdHTTPAddresses = []string{"127.0.0.1:4161"}
+	opts.ClusterNSQLookupdHTTPAddresses = []string{"dc2=127.0.0.2:4161", "dc3=127.0.0.3:4161", "dc2=127.0.0.4:4161"}
+	opts.ClusterNSQDHTTPAddresses = []string{"dc4=unix:///var/run/nsqd.sock"}
+	opts.NSQLookupdHTTPAdminToken = "secret1"
+	opts.ClusterNSQLookupdHTTPAdminTokens = []string{"dc2=secret2"}
+	clusters, err := parseClusters(opts)
+	test.Nil(t, err)
+	test.Equal(t, 4, len(clusters))
+	test.Equal(t, "default", clusters[0].Name)
+	test.Equal(t, []string{"127.0.0.1:4161"}, clusters[0].NSQLookupdHTTPAddresses)
+	test.Equal(t, "dc2", clusters[1].Name)
+	test.Equal(t, []string{"127.0.0.2:4161", "127.0.0.4:4161"}, clusters[1].NSQLookupdHTTPAddresses)
+	test.Equal(t, "dc3", clusters[2].Name)
+	test.Equal(t, "dc4", clusters[3].Name)
+	test.Equal(t, []string{"unix:///var/run/nsqd.sock"}, clusters[3].NSQDHTTPAddresses)
+	// each cluster has its own lookupd admin token
+	test.Equal(t, "secret1", clusters[0].NSQLookupdHTTPAdminToken)
+	test.Equal(t, "secret2", clusters[1].NSQLookupdHTTPAdminToken)
+	test.Equal(t, "", clusters[2].NSQLookupdHTTPAdminToken)
+
+	for _, v := range []string{"dc2", "dc4=secret4", "dc5=secret5"} {
+		opts.ClusterNSQLookupdHTTPAdminTokens = []string{v}
+		_, err := parseClusters(opts)
+		test.NotNil(t, err)
+	}
+	opts.ClusterNSQLookupdHTTPAdminTokens = nil
+
+	for _, tc := range []struct {
+		lookupd []string
+		nsqd    []string
+		err     string
+	}{
+		{[]string{"127.0.0.2:4161"}, nil,
+			"invalid --cluster-lookupd-http-address (127.0.0.2:4161) - should be <cluster>=<addr>"},
+		{[]string{"dc/2=127.0.0.2:4161"}, nil,
+			"invalid --cluster-lookupd-http-address (dc/2=127.0.0.2:4161) - invalid cluster name"},
+		{nil, []string{"default=127.0.0.2:4151"},
+			"invalid --cluster-nsqd-http-address (default=127.0.0.2:4151) - default is the --cluster-name of --lookupd-http-address/--nsqd-http-address"},
+		{[]string{"dc2=127.0.0.2:4161"}, []string{"dc2=127.0.0.2:4151"},
+			"cluster dc2 has both --cluster-lookupd-http-address and --cluster-nsqd-http-address"},
+	} {
+		opts.ClusterNSQLookupdHTTPAddresses = tc.lookupd
+		opts.ClusterNSQDHTTPAddresses = tc.nsqd
+		_, err := parseClusters(opts)
+		test.NotNil(t, err)
+		test.Equal(t, tc.err, err.Error())
+	}
+}
+
+func TestHistoryRing(t *testing.T) {
+	dir, err := os.MkdirTemp("", "nsq-history-")
+	test.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// 48 samples are kept, 3 per segment
+	h, err := newHistory(dir, time.Second, 48*time.Second)
+	test.Nil(t, err)
+	now := time.Now().Unix()
+	for i := int64(0); i < 100; i++ {
+		err := h.add(historySample{
+			Timestamp: now - 99 + i,
+			Topics:    map[string]historyPoint{"t": {Depth: i, MessageCount: i * 10}},
+			Channels:  map[string]historyPoint{"t:ch": {RequeueCount: i}},
+		})
+		test.Nil(t, err)
+	}
+	h.close()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "history.*.json"))
+	test.Equal(t, historySegments, len(files))
+
+	h, err = newHistory(dir, time.Second, 48*time.Second)
+	test.Nil(t, err)
+	defer h.close()
+	points := h.series("t", "", time.Time{})
+	test.Equal(t, 49, len(points))
+	test.Equal(t, int64(99), points[48].Depth)
+	test.Equal(t, 10.0, points[48].MessageRate)
+	points = h.series("t", "ch", time.Unix(now-9, 0))
+	test.Equal(t, 10, len(points))
+	test.Equal(t, 1.0, points[0].RequeueRate)
+	test.Equal(t, 0, len(h.series("other", "", time.Time{})))
+
+	test.Equal(t, 5.0, counterRate(100, 110, 2))
+	// nsqd restarted
+	test.Equal(t, 3.0, counterRate(100, 6, 2))
+
+	// rates skip partial samples, which lack the counters of some nodes
+	for i, c := range []int64{100, 110, 30, 130} {
+		err := h.add(historySample{
+			Timestamp: now + 1 + int64(i),
+			Partial:   i == 2,
+			Topics:    map[string]historyPoint{"partial": {MessageCount: c}},
+		})
+		test.Nil(t, err)
+	}
+	points = h.series("partial", "", time.Time{})
+	test.Equal(t, 4, len(points))
+	test.Equal(t, 10.0, points[1].MessageRate)
+	test.Equal(t, true, points[2].Partial)
+	test.Equal(t, 0.0, points[2].MessageRate)
+	test.Equal(t, 10.0, points[3].MessageRate)
+}
+
+func TestHistoryDownsample(t *testing.T) {
+	var points []seriesPoint
+	for i := int64(0); i < 5000; i++ {
+		points = appendSeriesPoint(points, seriesPoint{
+			historyPoint: historyPoint{Depth: i, MessageCount: i * 10},
+			timestamp:    i,
+		})
+	}
+	test.Equal(t, true, len(points) <= historyMaxPoints)
+	// the recent points keep their resolution
+	test.Equal(t, int64(4999), points[len(points)-1].timestamp)
+	test.Equal(t, int64(4998), points[len(points)-2].timestamp)
+	for i := 1; i < len(points); i++ {
+		p, prev := points[i], points[i-1]
+		test.Equal(t, true, p.timestamp > prev.timestamp)
+		test.Equal(t, 10.0, counterRate(prev.MessageCount, p.MessageCount, float64(p.timestamp-prev.timestamp)))
+	}
+}
+
+func TestAlertRules(t *testing.T) {
+	dir, err := os.MkdirTemp("", "nsq-alerts-")
+	test.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	rulesFile := filepath.Join(dir, "rules.json")
+	err = os.WriteFile(rulesFile, []byte(`[
+		{"name": "deep", "type": "channel_depth", "topic": "t", "threshold": 100, "for": "10s"},
+		{"name": "idle", "type": "no_consumers", "channel": "ch"},
+		{"name": "requeues", "type": "requeue_rate", "threshold": 5},
+		{"name": "gone", "type": "node_missing", "node": "*"}
+	]`), 0600)
+	test.Nil(t, err)
+	rules, err := loadAlertRules(rulesFile)
+	test.Nil(t, err)
+	a := newAlerter(rules)
+
+	producer := &clusterinfo.Producer{Hostname: "h1", BroadcastAddress: "10.0.0.1", HTTPPort: 4151, TCPPort: 4150}
+	producers := clusterinfo.Producers{producer}
+	channel := &clusterinfo.ChannelStats{TopicName: "t", ChannelName: "ch", Depth: 500, ClientCount: 1}
+	channels := map[string]*clusterinfo.ChannelStats{"t:ch": channel}
+
+	now := time.Now()
+	test.Equal(t, 0, len(a.evaluate(now, producers, channels)))
+	// pending until the depth stays above the threshold for 10s
+	test.Equal(t, 1, len(a.active()))
+	test.Equal(t, alertPending, a.active()[0].State)
+
+	channel.RequeueCount = 100
+	channel.ClientCount = 0
+	changed := a.evaluate(now.Add(10*time.Second), producers, channels)
+	test.Equal(t, 3, len(changed))
+	for _, alert := range changed {
+		test.Equal(t, alertFiring, alert.State)
+	}
+
+	// the node disappears, and with it every channel
+	changed = a.evaluate(now.Add(20*time.Second), nil, nil)
+	test.Equal(t, 4, len(changed))
+	test.Equal(t, "gone", changed[3].Rule)
+	test.Equal(t, alertFiring, changed[3].State)
+	test.Equal(t, "10.0.0.1:4151", changed[3].Node)
+	test.Equal(t, 3, len(a.recentlyResolved()))
+	test.Equal(t, 1, len(a.active()))
+
+	// a node that stays gone is forgotten eventually
+	changed = a.evaluate(now.Add(knownNodeTTL+20*time.Second), nil, nil)
+	test.Equal(t, 1, len(changed))
+	test.Equal(t, "gone", changed[0].Rule)
+	test.Equal(t, alertResolved, changed[0].State)
+	test.Equal(t, 0, len(a.active()))
+
+	err = os.WriteFile(rulesFile, []byte(`[{"name": "x", "type": "bogus"}]`), 0600)
+	test.Nil(t, err)
+	_, err = loadAlertRules(rulesFile)
+	test.NotNil(t, err)
+}
+
+func TestACLPolicy(t *testing.T) {
+	dir, err := os.MkdirTemp("", "nsq-acl-")
+	test.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	policyFile := filepath.Join(dir, "policy.json")
+	err = os.WriteFile(policyFile, []byte(`[
+		{"users": ["root"], "role": "admin"},
+		{"users": ["team"], "role": "admin", "topic": "team\\..*"},
+		{"users": ["team"], "role": "operator", "topic": "shared", "channel": "team.*"},
+		{"users": ["dc2ops"], "role": "operator", "cluster": "dc2"},
+		{"users": ["*"], "role": "viewer"}
+	]`), 0600)
+	test.Nil(t, err)
+	p, err := loadACLPolicy(policyFile)
+	test.Nil(t, err)
+
+	test.Equal(t, true, p.allows("root", RoleAdmin, "default", "anything", ""))
+	test.Equal(t, true, p.allows("team", RoleAdmin, "default", "team.orders", ""))
+	test.Equal(t, true, p.allows("team", RoleOperator, "default", "team.orders", "ch"))
+	test.Equal(t, false, p.allows("team", RoleOperator, "default", "orders", ""))
+	test.Equal(t, true, p.allows("team", RoleOperator, "default", "shared", "team_ch"))
+	// the expressions match whole names
+	test.Equal(t, false, p.allows("team", RoleAdmin, "default", "myteam.orders", ""))
+	test.Equal(t, false, p.allows("team", RoleOperator, "default", "shared_archive", "team_ch"))
+	test.Equal(t, false, p.allows("team", RoleAdmin, "default", "shared", "team_ch"))
+	test.Equal(t, false, p.allows("team", RoleOperator, "default", "shared", "other"))
+	// a channel scope does not cover the whole topic
+	test.Equal(t, false, p.allows("team", RoleOperator, "default", "shared", ""))
+	test.Equal(t, false, p.allows("anyone", RoleOperator, "default", "team.orders", "ch"))
+	// a cluster scope covers that cluster only
+	test.Equal(t, true, p.allows("dc2ops", RoleOperator, "dc2", "orders", ""))
+	test.Equal(t, false, p.allows("dc2ops", RoleOperator, "default", "orders", ""))
+	test.Equal(t, true, p.allows("dc2ops", RoleViewer, "default", "orders", ""))
+
+	test.Equal(t, true, p.canModify("team"))
+	test.Equal(t, false, p.canModify("anyone"))
+
+	err = os.WriteFile(policyFile, []byte(`[{"users": ["x"], "role": "superuser"}]`), 0600)
+	test.Nil(t, err)
+	_, err = loadACLPolicy(policyFile)
+	test.NotNil(t, err)
+
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.NSQLookupdHTTPAddresses = []string{"127.0.0.1:4161"}
+	opts.AdminUsers = []string{"root"}
+	opts.ACLPolicyFile = policyFile
+	_, err = New(opts)
+	test.Equal(t, "use --admin-user or --acl-policy-file not both", fmt.Sprintf("%s", err))
+}
+
+func TestHTPasswd(t *testing.T) {
+	dir, err := os.MkdirTemp("", "nsq-htpasswd-")
+	test.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	test.Equal(t, "$apr1$abcdefgh$FBwExRW4dCc8aL.OvjpIE1", apr1Crypt("password", "abcdefgh"))
+	test.Equal(t, "$apr1$xy$43..WIhbfuznGvwoCyUek/", apr1Crypt("", "xy"))
+
+	fn := filepath.Join(dir, "htpasswd")
+	err = os.WriteFile(fn, []byte("# users\n"+
+		"matt:$apr1$abcdefgh$FBwExRW4dCc8aL.OvjpIE1\n"+
+		"jehiah:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"), 0600)
+	test.Nil(t, err)
+	h, err := loadHTPasswd(fn)
+	test.Nil(t, err)
+	test.Equal(t, true, h.authenticate("matt", "password"))
+	test.Equal(t, false, h.authenticate("matt", "Password"))
+	test.Equal(t, true, h.authenticate("jehiah", "password"))
+	test.Equal(t, false, h.authenticate("nobody", "password"))
+
+	err = os.WriteFile(fn, []byte("matt:$2y$05$c4WoMPo3SXsafkva.HHa6uXQZWr7oboPiC2bT/r7q1BB8I2s0BRqC\n"), 0600)
+	test.Nil(t, err)
+	_, err = loadHTPasswd(fn)
+	test.NotNil(t, err)
+}
+
+func TestAuditLog(t *testing.T) {
+	dir, err := os.MkdirTemp("", "nsq-audit-")
+	test.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	// roughly 2 actions per file
+	l, err := newAuditLog(path, 300, 2)
+	test.Nil(t, err)
+	for i := int64(0); i < 10; i++ {
+		user := "matt"
+		if i%2 == 1 {
+			user = "jehiah"
+		}
+		err := l.append(&AdminAction{
+			Action:    "empty_channel",
+			Topic:     "t",
+			Channel:   "ch" + strconv.Itoa(int(i)),
+			Timestamp: 1000 + i,
+			User:      user,
+		})
+		test.Nil(t, err)
+	}
+	l.close()
+
+	rotated, _ := filepath.Glob(path + ".*")
+	test.Equal(t, 2, len(rotated))
+
+	l, err = newAuditLog(path, 300, 2)
+	test.Nil(t, err)
+	defer l.close()
+	actions, err := l.query(auditFilter{}, 0)
+	test.Nil(t, err)
+	test.Equal(t, true, len(actions) >= 4 && len(actions) < 10)
+	test.Equal(t, int64(1009), actions[0].Timestamp)
+
+	actions, err = l.query(auditFilter{User: "matt", Since: 1005}, 1)
+	test.Nil(t, err)
+	test.Equal(t, 1, len(actions))
+	test.Equal(t, "ch8", actions[0].Channel)
+
+	actions, err = l.query(auditFilter{Until: 1001}, 0)
+	test.Nil(t, err)
+	test.Equal(t, 0, len(actions))
+}
+
+func TestLagTracker(t *testing.T) {
+	l := newLagTracker()
+	now := time.Now()
+	channel := func(depth int64, messageCount int64) *clusterinfo.ChannelStats {
+		return &clusterinfo.ChannelStats{
+			TopicName:              "t",
+			ChannelName:            "c",
+			Depth:                  depth,
+			MessageCount:           messageCount,
+			OldestMessageTimestamp: now.Add(-time.Minute).UnixNano(),
+		}
+	}
+
+	c := channel(1000, 0)
+	l.update("default", []*clusterinfo.ChannelStats{c}, now)
+	test.Equal(t, 0.0, c.Lag.Window)
+	test.Equal(t, int64(-1), c.Lag.DrainETA)
+	test.Equal(t, time.Minute, time.Duration(c.Lag.OldestMessageAge))
+
+	// too soon for another sample
+	c = channel(900, 100)
+	l.update("default", []*clusterinfo.ChannelStats{c}, now.Add(500*time.Millisecond))
+	test.Equal(t, 0.5, c.Lag.Window)
+
+	// 100 in and 200 out over 10s
+	c = channel(900, 100)
+	l.update("default", []*clusterinfo.ChannelStats{c}, now.Add(10*time.Second))
+	test.Equal(t, 10.0, c.Lag.Window)
+	test.Equal(t, 10.0, c.Lag.PublishRate)
+	test.Equal(t, 20.0, c.Lag.ConsumeRate)
+	test.Equal(t, 90*time.Second, time.Duration(c.Lag.DrainETA))
+
+	// falling behind
+	c = channel(2000, 200)
+	l.update("default", []*clusterinfo.ChannelStats{c}, now.Add(20*time.Second))
+	test.Equal(t, int64(-1), c.Lag.DrainETA)
+
+	// samples older than the window are dropped
+	c = channel(0, 200)
+	l.update("default", []*clusterinfo.ChannelStats{c}, now.Add(20*time.Second+lagWindow))
+	test.Equal(t, lagWindow.Seconds(), c.Lag.Window)
+	test.Equal(t, int64(0), c.Lag.DrainETA)
+}
+
 func TestTLSHTTPClient(t *testing.T) {
 	lgr := test.NewTestLogger(t)
 
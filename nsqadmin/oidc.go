@@ -0,0 +1,409 @@
+package nsqadmin
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/nsqio/nsq/internal/http_api"
+)
+
+const (
+	oidcStateCookieName = "nsqadmin_oidc_state"
+	oidcStateLifetime   = 10 * time.Minute
+)
+
+// oidcConfig is the part of the provider's discovery document nsqadmin uses
+type oidcConfig struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcProvider performs the authorization code flow against an OpenID Connect
+// provider, whose discovery document and keys are fetched on first use
+type oidcProvider struct {
+	sync.Mutex
+
+	issuer       string
+	clientID     string
+	clientSecret string
+	userClaim    string
+	client       *http.Client
+
+	config *oidcConfig
+	keys   map[string]*rsa.PublicKey
+}
+
+func newOIDCProvider(opts *Options, tlsConfig *tls.Config) *oidcProvider {
+	transport := http_api.NewDeadlineTransport(opts.HTTPClientConnectTimeout, opts.HTTPClientRequestTimeout)
+	transport.TLSClientConfig = tlsConfig
+	return &oidcProvider{
+		issuer:       strings.TrimSuffix(opts.OIDCIssuerURL, "/"),
+		clientID:     opts.OIDCClientID,
+		clientSecret: opts.OIDCClientSecret,
+		userClaim:    opts.OIDCUserClaim,
+		client: &http.Client{
+			Transport: transport,
+			Timeout:   opts.HTTPClientRequestTimeout,
+		},
+	}
+}
+
+func (p *oidcProvider) getJSON(endpoint string, v interface{}) error {
+	resp, err := p.client.Get(endpoint)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("got response %s from %s", resp.Status, endpoint)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (p *oidcProvider) discover() (*oidcConfig, error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.config != nil {
+		return p.config, nil
+	}
+	var config oidcConfig
+	err := p.getJSON(p.issuer+"/.well-known/openid-configuration", &config)
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(config.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("discovery document is for issuer %q", config.Issuer)
+	}
+	if config.AuthorizationEndpoint == "" || config.TokenEndpoint == "" || config.JWKSURI == "" {
+		return nil, errors.New("discovery document is incomplete")
+	}
+	p.config = &config
+	return p.config, nil
+}
+
+// key returns the RSA key with the given id, refetching the provider's keys
+// when it is not known (yet)
+func (p *oidcProvider) key(config *oidcConfig, kid string) (*rsa.PublicKey, error) {
+	p.Lock()
+	defer p.Unlock()
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	err := p.getJSON(config.JWKSURI, &jwks)
+	if err != nil {
+		return nil, err
+	}
+	p.keys = make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) > 4 {
+			continue
+		}
+		p.keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	k, ok := p.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return k, nil
+}
+
+// exchange redeems an authorization code for the ID token
+func (p *oidcProvider) exchange(config *oidcConfig, code string, redirectURI string) (string, error) {
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {redirectURI},
+	}
+	req, err := http.NewRequest("POST", config.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return "", fmt.Errorf("got response %s from token endpoint", resp.Status)
+	}
+	if token.Error != "" {
+		return "", fmt.Errorf("%s %s", token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return "", errors.New("no id_token in response")
+	}
+	return token.IDToken, nil
+}
+
+// verify checks the signature (RS256 only) and claims of an ID token and
+// returns the user it identifies
+func (p *oidcProvider) verify(config *oidcConfig, idToken string, nonce string) (string, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return "", errors.New("malformed id_token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return "", err
+	}
+	if header.Alg != "RS256" {
+		return "", fmt.Errorf("unsupported id_token alg %q", header.Alg)
+	}
+	key, err := p.key(config, header.Kid)
+	if err != nil {
+		return "", err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return "", errors.New("invalid id_token signature")
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return "", err
+	}
+	if claims["iss"] != config.Issuer {
+		return "", fmt.Errorf("id_token issued by %v", claims["iss"])
+	}
+	if !audienceContains(claims["aud"], p.clientID) {
+		return "", errors.New("id_token is for another client")
+	}
+	if exp, _ := claims["exp"].(float64); time.Now().Unix() >= int64(exp) {
+		return "", errors.New("id_token expired")
+	}
+	if claims["nonce"] != nonce {
+		return "", errors.New("id_token nonce mismatch")
+	}
+	user, _ := claims[p.userClaim].(string)
+	if user == "" {
+		return "", fmt.Errorf("id_token has no %q claim", p.userClaim)
+	}
+	return user, nil
+}
+
+func decodeJWTPart(s string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func audienceContains(aud interface{}, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func randomToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// oidcState is the content of the (signed) cookie that carries the login
+// request across the redirect to the provider
+type oidcState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Redirect string `json:"rd"`
+	Expires  int64  `json:"exp"`
+}
+
+// oidcErrors responds with the errors of handlers that otherwise write their
+// own (redirect) response
+func oidcErrors(f http_api.APIHandler) http_api.APIHandler {
+	return func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+		data, err := f(w, req, ps)
+		if err != nil {
+			http_api.RespondV1(w, err.(http_api.Err).Code, err)
+			return nil, nil
+		}
+		return data, nil
+	}
+}
+
+func isSecureRequest(req *http.Request) bool {
+	return req.TLS != nil || req.Header.Get("X-Scheme") == "https"
+}
+
+func (s *httpServer) oidcRedirectURI(req *http.Request) string {
+	if s.nsqadmin.getOpts().OIDCRedirectURL != "" {
+		return s.nsqadmin.getOpts().OIDCRedirectURL
+	}
+	u := url.URL{
+		Scheme: "http",
+		Host:   req.Host,
+		Path:   path.Join(s.basePath, "/oauth2/callback"),
+	}
+	if isSecureRequest(req) {
+		u.Scheme = "https"
+	}
+	return u.String()
+}
+
+func (s *httpServer) setCookie(w http.ResponseWriter, req *http.Request, name string, value string,
+	cookiePath string, expires time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     cookiePath,
+		Expires:  expires,
+		Secure:   isSecureRequest(req),
+		HttpOnly: true,
+		// Lax keeps the session cookie off cross-site POST and DELETE requests
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (s *httpServer) oidcLoginHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	config, err := s.nsqadmin.oidc.discover()
+	if err != nil {
+		s.nsqadmin.logf(LOG_ERROR, "failed to discover OIDC provider - %s", err)
+		return nil, http_api.Err{502, fmt.Sprintf("UPSTREAM_ERROR: %s", err)}
+	}
+
+	// only redirect back to nsqadmin itself
+	rd := req.URL.Query().Get("rd")
+	if !strings.HasPrefix(rd, "/") || strings.HasPrefix(rd, "//") || strings.HasPrefix(rd, "/\\") {
+		rd = s.basePath
+	}
+	st := oidcState{
+		State:    randomToken(),
+		Nonce:    randomToken(),
+		Redirect: rd,
+		Expires:  time.Now().Add(oidcStateLifetime).Unix(),
+	}
+	value, err := s.nsqadmin.signValue(st)
+	if err != nil {
+		return nil, http_api.Err{500, "INTERNAL_ERROR"}
+	}
+	s.setCookie(w, req, oidcStateCookieName, value, path.Join(s.basePath, "/oauth2"),
+		time.Unix(st.Expires, 0))
+
+	authURL, err := url.Parse(config.AuthorizationEndpoint)
+	if err != nil {
+		return nil, http_api.Err{502, "UPSTREAM_ERROR: invalid authorization_endpoint"}
+	}
+	q := authURL.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", s.nsqadmin.oidc.clientID)
+	q.Set("redirect_uri", s.oidcRedirectURI(req))
+	q.Set("scope", "openid email profile")
+	q.Set("state", st.State)
+	q.Set("nonce", st.Nonce)
+	authURL.RawQuery = q.Encode()
+
+	http.Redirect(w, req, authURL.String(), http.StatusFound)
+	return nil, nil
+}
+
+func (s *httpServer) oidcCallbackHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	var st oidcState
+	c, err := req.Cookie(oidcStateCookieName)
+	if err != nil || s.nsqadmin.verifyValue(c.Value, &st) != nil || time.Now().Unix() >= st.Expires {
+		return nil, http_api.Err{400, "INVALID_STATE"}
+	}
+	q := req.URL.Query()
+	if q.Get("state") != st.State {
+		return nil, http_api.Err{400, "INVALID_STATE"}
+	}
+	if e := q.Get("error"); e != "" {
+		return nil, http_api.Err{401, fmt.Sprintf("UNAUTHORIZED: %s", e)}
+	}
+
+	config, err := s.nsqadmin.oidc.discover()
+	if err != nil {
+		s.nsqadmin.logf(LOG_ERROR, "failed to discover OIDC provider - %s", err)
+		return nil, http_api.Err{502, fmt.Sprintf("UPSTREAM_ERROR: %s", err)}
+	}
+	idToken, err := s.nsqadmin.oidc.exchange(config, q.Get("code"), s.oidcRedirectURI(req))
+	if err != nil {
+		s.nsqadmin.logf(LOG_WARN, "failed to exchange OIDC code - %s", err)
+		return nil, http_api.Err{401, "UNAUTHORIZED"}
+	}
+	user, err := s.nsqadmin.oidc.verify(config, idToken, st.Nonce)
+	if err != nil {
+		s.nsqadmin.logf(LOG_WARN, "failed to verify OIDC id_token - %s", err)
+		return nil, http_api.Err{401, "UNAUTHORIZED"}
+	}
+
+	expires := time.Now().Add(s.nsqadmin.getOpts().SessionLifetime)
+	value, err := s.nsqadmin.signValue(session{User: user, Expires: expires.Unix()})
+	if err != nil {
+		return nil, http_api.Err{500, "INTERNAL_ERROR"}
+	}
+	s.setCookie(w, req, sessionCookieName, value, s.basePath, expires)
+	s.setCookie(w, req, oidcStateCookieName, "", path.Join(s.basePath, "/oauth2"), time.Unix(1, 0))
+	s.nsqadmin.logf(LOG_INFO, "OIDC: %s logged in", user)
+
+	http.Redirect(w, req, st.Redirect, http.StatusFound)
+	return nil, nil
+}
+
+func (s *httpServer) logoutHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	s.setCookie(w, req, sessionCookieName, "", s.basePath, time.Unix(1, 0))
+	http.Redirect(w, req, s.basePath, http.StatusFound)
+	return nil, nil
+}
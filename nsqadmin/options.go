@@ -25,8 +25,22 @@ type Options struct {
 
 	StatsdInterval time.Duration `flag:"statsd-interval"`
 
-	NSQLookupdHTTPAddresses []string `flag:"lookupd-http-address" cfg:"nsqlookupd_http_addresses"`
-	NSQDHTTPAddresses       []string `flag:"nsqd-http-address" cfg:"nsqd_http_addresses"`
+	HistoryDataPath  string        `flag:"history-data-path"`
+	HistoryInterval  time.Duration `flag:"history-interval"`
+	HistoryRetention time.Duration `flag:"history-retention"`
+
+	AlertRulesFile string        `flag:"alert-rules-file"`
+	AlertInterval  time.Duration `flag:"alert-interval"`
+
+	NSQLookupdHTTPAddresses  []string `flag:"lookupd-http-address" cfg:"nsqlookupd_http_addresses"`
+	NSQDHTTPAddresses        []string `flag:"nsqd-http-address" cfg:"nsqd_http_addresses"`
+	NSQLookupdHTTPAdminToken string   `flag:"lookupd-http-admin-token"`
+
+	ClusterName                    string   `flag:"cluster-name"`
+	ClusterNSQLookupdHTTPAddresses []string `flag:"cluster-lookupd-http-address" cfg:"cluster_lookupd_http_addresses"`
+	ClusterNSQDHTTPAddresses       []string `flag:"cluster-nsqd-http-address" cfg:"cluster_nsqd_http_addresses"`
+
+	ClusterNSQLookupdHTTPAdminTokens []string `flag:"cluster-lookupd-http-admin-token" cfg:"cluster_lookupd_http_admin_tokens"`
 
 	HTTPClientConnectTimeout time.Duration `flag:"http-client-connect-timeout"`
 	HTTPClientRequestTimeout time.Duration `flag:"http-client-request-timeout"`
@@ -38,10 +52,26 @@ type Options struct {
 
 	AllowConfigFromCIDR string `flag:"allow-config-from-cidr"`
 
-	NotificationHTTPEndpoint string `flag:"notification-http-endpoint"`
+	NotificationHTTPEndpoint string        `flag:"notification-http-endpoint"`
+	NotificationMaxAttempts  int           `flag:"notification-max-attempts"`
+	NotificationRetryBackoff time.Duration `flag:"notification-retry-backoff"`
+
+	AuditLogPath     string `flag:"audit-log-path"`
+	AuditLogMaxSize  int64  `flag:"audit-log-max-size"`
+	AuditLogMaxFiles int    `flag:"audit-log-max-files"`
 
 	ACLHTTPHeader string   `flag:"acl-http-header"`
 	AdminUsers    []string `flag:"admin-user" cfg:"admin_users"`
+	ACLPolicyFile string   `flag:"acl-policy-file"`
+
+	HTPasswdFile     string        `flag:"htpasswd-file"`
+	OIDCIssuerURL    string        `flag:"oidc-issuer-url"`
+	OIDCClientID     string        `flag:"oidc-client-id"`
+	OIDCClientSecret string        `flag:"oidc-client-secret"`
+	OIDCRedirectURL  string        `flag:"oidc-redirect-url"`
+	OIDCUserClaim    string        `flag:"oidc-user-claim"`
+	SessionSecret    string        `flag:"session-secret"`
+	SessionLifetime  time.Duration `flag:"session-lifetime"`
 }
 
 func NewOptions() *Options {
@@ -54,10 +84,20 @@ func NewOptions() *Options {
 		StatsdCounterFormat:      "stats.counters.%s.count",
 		StatsdGaugeFormat:        "stats.gauges.%s",
 		StatsdInterval:           60 * time.Second,
+		HistoryInterval:          30 * time.Second,
+		HistoryRetention:         7 * 24 * time.Hour,
+		AlertInterval:            30 * time.Second,
+		ClusterName:              "default",
 		HTTPClientConnectTimeout: 2 * time.Second,
 		HTTPClientRequestTimeout: 5 * time.Second,
 		AllowConfigFromCIDR:      "127.0.0.1/8",
+		NotificationMaxAttempts:  5,
+		NotificationRetryBackoff: time.Second,
+		AuditLogMaxSize:          100 * 1024 * 1024,
+		AuditLogMaxFiles:         10,
 		ACLHTTPHeader:            "X-Forwarded-User",
 		AdminUsers:               []string{},
+		OIDCUserClaim:            "email",
+		SessionLifetime:          12 * time.Hour,
 	}
 }
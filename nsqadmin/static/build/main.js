@@ -1,2 +1,4982 @@
-!function l(a,o,r){function i(e,n){if(!o[e]){if(!a[e]){var t="function"==typeof require&&require;if(!n&&t)return t(e,!0);if(s)return s(e,!0);throw(t=new Error("Cannot find module '"+e+"'")).code="MODULE_NOT_FOUND",t}t=o[e]={exports:{}},a[e][0].call(t.exports,function(n){return i(a[e][1][n]||n)},t,t.exports,l,a,o,r)}return o[e].exports}for(var s="function"==typeof require&&require,n=0;n<r.length;n++)i(r[n]);return i}({1:[function(n,e,t){n("../../js/transition.js"),n("../../js/alert.js"),n("../../js/button.js"),n("../../js/carousel.js"),n("../../js/collapse.js"),n("../../js/dropdown.js"),n("../../js/modal.js"),n("../../js/tooltip.js"),n("../../js/popover.js"),n("../../js/scrollspy.js"),n("../../js/tab.js"),n("../../js/affix.js")},{"../../js/affix.js":2,"../../js/alert.js":3,"../../js/button.js":4,"../../js/carousel.js":5,"../../js/collapse.js":6,"../../js/dropdown.js":7,"../../js/modal.js":8,"../../js/popover.js":9,"../../js/scrollspy.js":10,"../../js/tab.js":11,"../../js/tooltip.js":12,"../../js/transition.js":13}],2:[function(n,e,t){!function(r){"use strict";var i=function(n,e){this.options=r.extend({},i.DEFAULTS,e);e=this.options.target===i.DEFAULTS.target?r(this.options.target):r(document).find(this.options.target);this.$target=e.on("scroll.bs.affix.data-api",r.proxy(this.checkPosition,this)).on("click.bs.affix.data-api",r.proxy(this.checkPositionWithEventLoop,this)),this.$element=r(n),this.affixed=null,this.unpin=null,this.pinnedOffset=null,this.checkPosition()};function t(t){return this.each(function(){var n=r(this),e=n.data("bs.affix");e||n.data("bs.affix",e=new i(this,"object"==typeof t&&t)),"string"==typeof t&&e[t]()})}i.VERSION="3.4.1",i.RESET="affix affix-top affix-bottom",i.DEFAULTS={offset:0,target:window},i.prototype.getState=function(n,e,t,l){var a=this.$target.scrollTop(),o=this.$element.offset(),r=this.$target.height();if(null!=t&&"top"==this.affixed)return a<t&&"top";if("bottom"==this.affixed)return null!=t?!(a+this.unpin<=o.top)&&"bottom":!(a+r<=n-l)&&"bottom";var i=null==this.affixed,o=i?a:o.top;return null!=t&&a<=t?"top":null!=l&&n-l<=o+(i?r:e)&&"bottom"},i.prototype.getPinnedOffset=function(){if(this.pinnedOffset)return this.pinnedOffset;this.$element.removeClass(i.RESET).addClass("affix");var n=this.$target.scrollTop(),e=this.$element.offset();return this.pinnedOffset=e.top-n},i.prototype.checkPositionWithEventLoop=function(){setTimeout(r.proxy(this.checkPosition,this),1)},i.prototype.checkPosition=function(){if(this.$element.is(":visible")){var n=this.$element.height(),e=this.options.offset,t=e.top,l=e.bottom,a=Math.max(r(document).height(),r(document.body).height());"object"!=typeof e&&(l=t=e),"function"==typeof t&&(t=e.top(this.$element)),"function"==typeof l&&(l=e.bottom(this.$element));var o=this.getState(a,n,t,l);if(this.affixed!=o){null!=this.unpin&&this.$element.css("top","");e="affix"+(o?"-"+o:""),t=r.Event(e+".bs.affix");if(this.$element.trigger(t),t.isDefaultPrevented())return;this.affixed=o,this.unpin="bottom"==o?this.getPinnedOffset():null,this.$element.removeClass(i.RESET).addClass(e).trigger(e.replace("affix","affixed")+".bs.affix")}"bottom"==o&&this.$element.offset({top:a-n-l})}};var n=r.fn.affix;r.fn.affix=t,r.fn.affix.Constructor=i,r.fn.affix.noConflict=function(){return r.fn.affix=n,this},r(window).on("load",function(){r('[data-spy="affix"]').each(function(){var n=r(this),e=n.data();e.offset=e.offset||{},null!=e.offsetBottom&&(e.offset.bottom=e.offsetBottom),null!=e.offsetTop&&(e.offset.top=e.offsetTop),t.call(n,e)})})}(jQuery)},{}],3:[function(n,e,t){!function(o){"use strict";function r(n){o(n).on("click",e,this.close)}var e='[data-dismiss="alert"]';r.VERSION="3.4.1",r.TRANSITION_DURATION=150,r.prototype.close=function(n){var e=o(this),t=e.attr("data-target");t="#"===(t=t||(t=e.attr("href"))&&t.replace(/.*(?=#[^\s]*$)/,""))?[]:t;var l=o(document).find(t);function a(){l.detach().trigger("closed.bs.alert").remove()}n&&n.preventDefault(),(l=!l.length?e.closest(".alert"):l).trigger(n=o.Event("close.bs.alert")),n.isDefaultPrevented()||(l.removeClass("in"),o.support.transition&&l.hasClass("fade")?l.one("bsTransitionEnd",a).emulateTransitionEnd(r.TRANSITION_DURATION):a())};var n=o.fn.alert;o.fn.alert=function(t){return this.each(function(){var n=o(this),e=n.data("bs.alert");e||n.data("bs.alert",e=new r(this)),"string"==typeof t&&e[t].call(n)})},o.fn.alert.Constructor=r,o.fn.alert.noConflict=function(){return o.fn.alert=n,this},o(document).on("click.bs.alert.data-api",e,r.prototype.close)}(jQuery)},{}],4:[function(n,e,t){!function(o){"use strict";var l=function(n,e){this.$element=o(n),this.options=o.extend({},l.DEFAULTS,e),this.isLoading=!1};function t(t){return this.each(function(){var n=o(this),e=n.data("bs.button");e||n.data("bs.button",e=new l(this,"object"==typeof t&&t)),"toggle"==t?e.toggle():t&&e.setState(t)})}l.VERSION="3.4.1",l.DEFAULTS={loadingText:"loading..."},l.prototype.setState=function(n){var e="disabled",t=this.$element,l=t.is("input")?"val":"html",a=t.data();n+="Text",null==a.resetText&&t.data("resetText",t[l]()),setTimeout(o.proxy(function(){t[l]((null==a[n]?this.options:a)[n]),"loadingText"==n?(this.isLoading=!0,t.addClass(e).attr(e,e).prop(e,!0)):this.isLoading&&(this.isLoading=!1,t.removeClass(e).removeAttr(e).prop(e,!1))},this),0)},l.prototype.toggle=function(){var n,e=!0,t=this.$element.closest('[data-toggle="buttons"]');t.length?("radio"==(n=this.$element.find("input")).prop("type")?(n.prop("checked")&&(e=!1),t.find(".active").removeClass("active"),this.$element.addClass("active")):"checkbox"==n.prop("type")&&(n.prop("checked")!==this.$element.hasClass("active")&&(e=!1),this.$element.toggleClass("active")),n.prop("checked",this.$element.hasClass("active")),e&&n.trigger("change")):(this.$element.attr("aria-pressed",!this.$element.hasClass("active")),this.$element.toggleClass("active"))};var n=o.fn.button;o.fn.button=t,o.fn.button.Constructor=l,o.fn.button.noConflict=function(){return o.fn.button=n,this},o(document).on("click.bs.button.data-api",'[data-toggle^="button"]',function(n){var e=o(n.target).closest(".btn");t.call(e,"toggle"),o(n.target).is('input[type="radio"], input[type="checkbox"]')||(n.preventDefault(),(e.is("input,button")?e:e.find("input:visible,button:visible").first()).trigger("focus"))}).on("focus.bs.button.data-api blur.bs.button.data-api",'[data-toggle^="button"]',function(n){o(n.target).closest(".btn").toggleClass("focus",/^focus(in)?$/.test(n.type))})}(jQuery)},{}],5:[function(n,e,t){!function(c){"use strict";function u(n,e){this.$element=c(n),this.$indicators=this.$element.find(".carousel-indicators"),this.options=e,this.paused=null,this.sliding=null,this.interval=null,this.$active=null,this.$items=null,this.options.keyboard&&this.$element.on("keydown.bs.carousel",c.proxy(this.keydown,this)),"hover"!=this.options.pause||"ontouchstart"in document.documentElement||this.$element.on("mouseenter.bs.carousel",c.proxy(this.pause,this)).on("mouseleave.bs.carousel",c.proxy(this.cycle,this))}function a(a){return this.each(function(){var n=c(this),e=n.data("bs.carousel"),t=c.extend({},u.DEFAULTS,n.data(),"object"==typeof a&&a),l="string"==typeof a?a:t.slide;e||n.data("bs.carousel",e=new u(this,t)),"number"==typeof a?e.to(a):l?e[l]():t.interval&&e.pause().cycle()})}u.VERSION="3.4.1",u.TRANSITION_DURATION=600,u.DEFAULTS={interval:5e3,pause:"hover",wrap:!0,keyboard:!0},u.prototype.keydown=function(n){if(!/input|textarea/i.test(n.target.tagName)){switch(n.which){case 37:this.prev();break;case 39:this.next();break;default:return}n.preventDefault()}},u.prototype.cycle=function(n){return n||(this.paused=!1),this.interval&&clearInterval(this.interval),this.options.interval&&!this.paused&&(this.interval=setInterval(c.proxy(this.next,this),this.options.interval)),this},u.prototype.getItemIndex=function(n){return this.$items=n.parent().children(".item"),this.$items.index(n||this.$active)},u.prototype.getItemForDirection=function(n,e){var t=this.getItemIndex(e);if(("prev"==n&&0===t||"next"==n&&t==this.$items.length-1)&&!this.options.wrap)return e;n=(t+("prev"==n?-1:1))%this.$items.length;return this.$items.eq(n)},u.prototype.to=function(n){var e=this,t=this.getItemIndex(this.$active=this.$element.find(".item.active"));if(!(n>this.$items.length-1||n<0))return this.sliding?this.$element.one("slid.bs.carousel",function(){e.to(n)}):t==n?this.pause().cycle():this.slide(t<n?"next":"prev",this.$items.eq(n))},u.prototype.pause=function(n){return n||(this.paused=!0),this.$element.find(".next, .prev").length&&c.support.transition&&(this.$element.trigger(c.support.transition.end),this.cycle(!0)),this.interval=clearInterval(this.interval),this},u.prototype.next=function(){if(!this.sliding)return this.slide("next")},u.prototype.prev=function(){if(!this.sliding)return this.slide("prev")},u.prototype.slide=function(n,e){var t=this.$element.find(".item.active"),l=e||this.getItemForDirection(n,t),a=this.interval,o="next"==n?"left":"right",r=this;if(l.hasClass("active"))return this.sliding=!1;var i=l[0],e=c.Event("slide.bs.carousel",{relatedTarget:i,direction:o});if(this.$element.trigger(e),!e.isDefaultPrevented()){this.sliding=!0,a&&this.pause(),this.$indicators.length&&(this.$indicators.find(".active").removeClass("active"),(e=c(this.$indicators.children()[this.getItemIndex(l)]))&&e.addClass("active"));var s=c.Event("slid.bs.carousel",{relatedTarget:i,direction:o});return c.support.transition&&this.$element.hasClass("slide")?(l.addClass(n),"object"==typeof l&&l.length&&l[0].offsetWidth,t.addClass(o),l.addClass(o),t.one("bsTransitionEnd",function(){l.removeClass([n,o].join(" ")).addClass("active"),t.removeClass(["active",o].join(" ")),r.sliding=!1,setTimeout(function(){r.$element.trigger(s)},0)}).emulateTransitionEnd(u.TRANSITION_DURATION)):(t.removeClass("active"),l.addClass("active"),this.sliding=!1,this.$element.trigger(s)),a&&this.cycle(),this}};var n=c.fn.carousel;c.fn.carousel=a,c.fn.carousel.Constructor=u,c.fn.carousel.noConflict=function(){return c.fn.carousel=n,this};function e(n){var e=c(this),t=(t=e.attr("href"))&&t.replace(/.*(?=#[^\s]+$)/,""),l=e.attr("data-target")||t;(t=c(document).find(l)).hasClass("carousel")&&(l=c.extend({},t.data(),e.data()),(e=e.attr("data-slide-to"))&&(l.interval=!1),a.call(t,l),e&&t.data("bs.carousel").to(e),n.preventDefault())}c(document).on("click.bs.carousel.data-api","[data-slide]",e).on("click.bs.carousel.data-api","[data-slide-to]",e),c(window).on("load",function(){c('[data-ride="carousel"]').each(function(){var n=c(this);a.call(n,n.data())})})}(jQuery)},{}],6:[function(n,e,t){!function(a){"use strict";var o=function(n,e){this.$element=a(n),this.options=a.extend({},o.DEFAULTS,e),this.$trigger=a('[data-toggle="collapse"][href="#'+n.id+'"],[data-toggle="collapse"][data-target="#'+n.id+'"]'),this.transitioning=null,this.options.parent?this.$parent=this.getParent():this.addAriaAndCollapsedClass(this.$element,this.$trigger),this.options.toggle&&this.toggle()};function t(n){var e=n.attr("data-target")||(e=n.attr("href"))&&e.replace(/.*(?=#[^\s]+$)/,"");return a(document).find(e)}function r(l){return this.each(function(){var n=a(this),e=n.data("bs.collapse"),t=a.extend({},o.DEFAULTS,n.data(),"object"==typeof l&&l);!e&&t.toggle&&/show|hide/.test(l)&&(t.toggle=!1),e||n.data("bs.collapse",e=new o(this,t)),"string"==typeof l&&e[l]()})}o.VERSION="3.4.1",o.TRANSITION_DURATION=350,o.DEFAULTS={toggle:!0},o.prototype.dimension=function(){return this.$element.hasClass("width")?"width":"height"},o.prototype.show=function(){if(!this.transitioning&&!this.$element.hasClass("in")){var n=this.$parent&&this.$parent.children(".panel").children(".in, .collapsing");if(!(n&&n.length&&(l=n.data("bs.collapse"))&&l.transitioning)){var e=a.Event("show.bs.collapse");if(this.$element.trigger(e),!e.isDefaultPrevented()){n&&n.length&&(r.call(n,"hide"),l||n.data("bs.collapse",null));var t=this.dimension();this.$element.removeClass("collapse").addClass("collapsing")[t](0).attr("aria-expanded",!0),this.$trigger.removeClass("collapsed").attr("aria-expanded",!0),this.transitioning=1;var l=function(){this.$element.removeClass("collapsing").addClass("collapse in")[t](""),this.transitioning=0,this.$element.trigger("shown.bs.collapse")};if(!a.support.transition)return l.call(this);n=a.camelCase(["scroll",t].join("-"));this.$element.one("bsTransitionEnd",a.proxy(l,this)).emulateTransitionEnd(o.TRANSITION_DURATION)[t](this.$element[0][n])}}}},o.prototype.hide=function(){if(!this.transitioning&&this.$element.hasClass("in")){var n=a.Event("hide.bs.collapse");if(this.$element.trigger(n),!n.isDefaultPrevented()){var e=this.dimension();this.$element[e](this.$element[e]())[0].offsetHeight,this.$element.addClass("collapsing").removeClass("collapse in").attr("aria-expanded",!1),this.$trigger.addClass("collapsed").attr("aria-expanded",!1),this.transitioning=1;n=function(){this.transitioning=0,this.$element.removeClass("collapsing").addClass("collapse").trigger("hidden.bs.collapse")};if(!a.support.transition)return n.call(this);this.$element[e](0).one("bsTransitionEnd",a.proxy(n,this)).emulateTransitionEnd(o.TRANSITION_DURATION)}}},o.prototype.toggle=function(){this[this.$element.hasClass("in")?"hide":"show"]()},o.prototype.getParent=function(){return a(document).find(this.options.parent).find('[data-toggle="collapse"][data-parent="'+this.options.parent+'"]').each(a.proxy(function(n,e){e=a(e);this.addAriaAndCollapsedClass(t(e),e)},this)).end()},o.prototype.addAriaAndCollapsedClass=function(n,e){var t=n.hasClass("in");n.attr("aria-expanded",t),e.toggleClass("collapsed",!t).attr("aria-expanded",t)};var n=a.fn.collapse;a.fn.collapse=r,a.fn.collapse.Constructor=o,a.fn.collapse.noConflict=function(){return a.fn.collapse=n,this},a(document).on("click.bs.collapse.data-api",'[data-toggle="collapse"]',function(n){var e=a(this);e.attr("data-target")||n.preventDefault();n=t(e),e=n.data("bs.collapse")?"toggle":e.data();r.call(n,e)})}(jQuery)},{}],7:[function(n,e,t){!function(a){"use strict";function l(n){a(n).on("click.bs.dropdown",this.toggle)}var o='[data-toggle="dropdown"]';function r(n){var e=n.attr("data-target"),e="#"!==(e=e||(e=n.attr("href"))&&/#[A-Za-z]/.test(e)&&e.replace(/.*(?=#[^\s]*$)/,""))?a(document).find(e):null;return e&&e.length?e:n.parent()}function i(l){l&&3===l.which||(a(".dropdown-backdrop").remove(),a(o).each(function(){var n=a(this),e=r(n),t={relatedTarget:this};e.hasClass("open")&&(l&&"click"==l.type&&/input|textarea/i.test(l.target.tagName)&&a.contains(e[0],l.target)||(e.trigger(l=a.Event("hide.bs.dropdown",t)),l.isDefaultPrevented()||(n.attr("aria-expanded","false"),e.removeClass("open").trigger(a.Event("hidden.bs.dropdown",t)))))}))}l.VERSION="3.4.1",l.prototype.toggle=function(n){var e=a(this);if(!e.is(".disabled, :disabled")){var t=r(e),l=t.hasClass("open");if(i(),!l){"ontouchstart"in document.documentElement&&!t.closest(".navbar-nav").length&&a(document.createElement("div")).addClass("dropdown-backdrop").insertAfter(a(this)).on("click",i);l={relatedTarget:this};if(t.trigger(n=a.Event("show.bs.dropdown",l)),n.isDefaultPrevented())return;e.trigger("focus").attr("aria-expanded","true"),t.toggleClass("open").trigger(a.Event("shown.bs.dropdown",l))}return!1}},l.prototype.keydown=function(n){if(/(38|40|27|32)/.test(n.which)&&!/input|textarea/i.test(n.target.tagName)){var e=a(this);if(n.preventDefault(),n.stopPropagation(),!e.is(".disabled, :disabled")){var t=r(e),l=t.hasClass("open");if(!l&&27!=n.which||l&&27==n.which)return 27==n.which&&t.find(o).trigger("focus"),e.trigger("click");e=t.find(".dropdown-menu li:not(.disabled):visible a");e.length&&(t=e.index(n.target),38==n.which&&0<t&&t--,40==n.which&&t<e.length-1&&t++,e.eq(t=!~t?0:t).trigger("focus"))}}};var n=a.fn.dropdown;a.fn.dropdown=function(t){return this.each(function(){var n=a(this),e=n.data("bs.dropdown");e||n.data("bs.dropdown",e=new l(this)),"string"==typeof t&&e[t].call(n)})},a.fn.dropdown.Constructor=l,a.fn.dropdown.noConflict=function(){return a.fn.dropdown=n,this},a(document).on("click.bs.dropdown.data-api",i).on("click.bs.dropdown.data-api",".dropdown form",function(n){n.stopPropagation()}).on("click.bs.dropdown.data-api",o,l.prototype.toggle).on("keydown.bs.dropdown.data-api",o,l.prototype.keydown).on("keydown.bs.dropdown.data-api",".dropdown-menu",l.prototype.keydown)}(jQuery)},{}],8:[function(n,e,t){!function(o){"use strict";function r(n,e){this.options=e,this.$body=o(document.body),this.$element=o(n),this.$dialog=this.$element.find(".modal-dialog"),this.$backdrop=null,this.isShown=null,this.originalBodyPad=null,this.scrollbarWidth=0,this.ignoreBackdropClick=!1,this.fixedContent=".navbar-fixed-top, .navbar-fixed-bottom",this.options.remote&&this.$element.find(".modal-content").load(this.options.remote,o.proxy(function(){this.$element.trigger("loaded.bs.modal")},this))}function i(l,a){return this.each(function(){var n=o(this),e=n.data("bs.modal"),t=o.extend({},r.DEFAULTS,n.data(),"object"==typeof l&&l);e||n.data("bs.modal",e=new r(this,t)),"string"==typeof l?e[l](a):t.show&&e.show(a)})}r.VERSION="3.4.1",r.TRANSITION_DURATION=300,r.BACKDROP_TRANSITION_DURATION=150,r.DEFAULTS={backdrop:!0,keyboard:!0,show:!0},r.prototype.toggle=function(n){return this.isShown?this.hide():this.show(n)},r.prototype.show=function(t){var l=this,n=o.Event("show.bs.modal",{relatedTarget:t});this.$element.trigger(n),this.isShown||n.isDefaultPrevented()||(this.isShown=!0,this.checkScrollbar(),this.setScrollbar(),this.$body.addClass("modal-open"),this.escape(),this.resize(),this.$element.on("click.dismiss.bs.modal",'[data-dismiss="modal"]',o.proxy(this.hide,this)),this.$dialog.on("mousedown.dismiss.bs.modal",function(){l.$element.one("mouseup.dismiss.bs.modal",function(n){o(n.target).is(l.$element)&&(l.ignoreBackdropClick=!0)})}),this.backdrop(function(){var n=o.support.transition&&l.$element.hasClass("fade");l.$element.parent().length||l.$element.appendTo(l.$body),l.$element.show().scrollTop(0),l.adjustDialog(),n&&l.$element[0].offsetWidth,l.$element.addClass("in"),l.enforceFocus();var e=o.Event("shown.bs.modal",{relatedTarget:t});n?l.$dialog.one("bsTransitionEnd",function(){l.$element.trigger("focus").trigger(e)}).emulateTransitionEnd(r.TRANSITION_DURATION):l.$element.trigger("focus").trigger(e)}))},r.prototype.hide=function(n){n&&n.preventDefault(),n=o.Event("hide.bs.modal"),this.$element.trigger(n),this.isShown&&!n.isDefaultPrevented()&&(this.isShown=!1,this.escape(),this.resize(),o(document).off("focusin.bs.modal"),this.$element.removeClass("in").off("click.dismiss.bs.modal").off("mouseup.dismiss.bs.modal"),this.$dialog.off("mousedown.dismiss.bs.modal"),o.support.transition&&this.$element.hasClass("fade")?this.$element.one("bsTransitionEnd",o.proxy(this.hideModal,this)).emulateTransitionEnd(r.TRANSITION_DURATION):this.hideModal())},r.prototype.enforceFocus=function(){o(document).off("focusin.bs.modal").on("focusin.bs.modal",o.proxy(function(n){document===n.target||this.$element[0]===n.target||this.$element.has(n.target).length||this.$element.trigger("focus")},this))},r.prototype.escape=function(){this.isShown&&this.options.keyboard?this.$element.on("keydown.dismiss.bs.modal",o.proxy(function(n){27==n.which&&this.hide()},this)):this.isShown||this.$element.off("keydown.dismiss.bs.modal")},r.prototype.resize=function(){this.isShown?o(window).on("resize.bs.modal",o.proxy(this.handleUpdate,this)):o(window).off("resize.bs.modal")},r.prototype.hideModal=function(){var n=this;this.$element.hide(),this.backdrop(function(){n.$body.removeClass("modal-open"),n.resetAdjustments(),n.resetScrollbar(),n.$element.trigger("hidden.bs.modal")})},r.prototype.removeBackdrop=function(){this.$backdrop&&this.$backdrop.remove(),this.$backdrop=null},r.prototype.backdrop=function(n){var e,t=this,l=this.$element.hasClass("fade")?"fade":"";this.isShown&&this.options.backdrop?(e=o.support.transition&&l,this.$backdrop=o(document.createElement("div")).addClass("modal-backdrop "+l).appendTo(this.$body),this.$element.on("click.dismiss.bs.modal",o.proxy(function(n){this.ignoreBackdropClick?this.ignoreBackdropClick=!1:n.target===n.currentTarget&&("static"==this.options.backdrop?this.$element[0].focus():this.hide())},this)),e&&this.$backdrop[0].offsetWidth,this.$backdrop.addClass("in"),n&&(e?this.$backdrop.one("bsTransitionEnd",n).emulateTransitionEnd(r.BACKDROP_TRANSITION_DURATION):n())):!this.isShown&&this.$backdrop?(this.$backdrop.removeClass("in"),e=function(){t.removeBackdrop(),n&&n()},o.support.transition&&this.$element.hasClass("fade")?this.$backdrop.one("bsTransitionEnd",e).emulateTransitionEnd(r.BACKDROP_TRANSITION_DURATION):e()):n&&n()},r.prototype.handleUpdate=function(){this.adjustDialog()},r.prototype.adjustDialog=function(){var n=this.$element[0].scrollHeight>document.documentElement.clientHeight;this.$element.css({paddingLeft:!this.bodyIsOverflowing&&n?this.scrollbarWidth:"",paddingRight:this.bodyIsOverflowing&&!n?this.scrollbarWidth:""})},r.prototype.resetAdjustments=function(){this.$element.css({paddingLeft:"",paddingRight:""})},r.prototype.checkScrollbar=function(){var n,e=window.innerWidth;e||(e=(n=document.documentElement.getBoundingClientRect()).right-Math.abs(n.left)),this.bodyIsOverflowing=document.body.clientWidth<e,this.scrollbarWidth=this.measureScrollbar()},r.prototype.setScrollbar=function(){var n=parseInt(this.$body.css("padding-right")||0,10);this.originalBodyPad=document.body.style.paddingRight||"";var a=this.scrollbarWidth;this.bodyIsOverflowing&&(this.$body.css("padding-right",n+a),o(this.fixedContent).each(function(n,e){var t=e.style.paddingRight,l=o(e).css("padding-right");o(e).data("padding-right",t).css("padding-right",parseFloat(l)+a+"px")}))},r.prototype.resetScrollbar=function(){this.$body.css("padding-right",this.originalBodyPad),o(this.fixedContent).each(function(n,e){var t=o(e).data("padding-right");o(e).removeData("padding-right"),e.style.paddingRight=t||""})},r.prototype.measureScrollbar=function(){var n=document.createElement("div");n.className="modal-scrollbar-measure",this.$body.append(n);var e=n.offsetWidth-n.clientWidth;return this.$body[0].removeChild(n),e};var n=o.fn.modal;o.fn.modal=i,o.fn.modal.Constructor=r,o.fn.modal.noConflict=function(){return o.fn.modal=n,this},o(document).on("click.bs.modal.data-api",'[data-toggle="modal"]',function(n){var e=o(this),t=e.attr("href"),l=e.attr("data-target")||t&&t.replace(/.*(?=#[^\s]+$)/,""),a=o(document).find(l),t=a.data("bs.modal")?"toggle":o.extend({remote:!/#/.test(t)&&t},a.data(),e.data());e.is("a")&&n.preventDefault(),a.one("show.bs.modal",function(n){n.isDefaultPrevented()||a.one("hidden.bs.modal",function(){e.is(":visible")&&e.trigger("focus")})}),i.call(a,t,this)})}(jQuery)},{}],9:[function(n,e,t){!function(a){"use strict";function o(n,e){this.init("popover",n,e)}if(!a.fn.tooltip)throw new Error("Popover requires tooltip.js");o.VERSION="3.4.1",o.DEFAULTS=a.extend({},a.fn.tooltip.Constructor.DEFAULTS,{placement:"right",trigger:"click",content:"",template:'<div class="popover" role="tooltip"><div class="arrow"></div><h3 class="popover-title"></h3><div class="popover-content"></div></div>'}),((o.prototype=a.extend({},a.fn.tooltip.Constructor.prototype)).constructor=o).prototype.getDefaults=function(){return o.DEFAULTS},o.prototype.setContent=function(){var n,e=this.tip(),t=this.getTitle(),l=this.getContent();this.options.html?(n=typeof l,this.options.sanitize&&(t=this.sanitizeHtml(t),"string"==n&&(l=this.sanitizeHtml(l))),e.find(".popover-title").html(t),e.find(".popover-content").children().detach().end()["string"==n?"html":"append"](l)):(e.find(".popover-title").text(t),e.find(".popover-content").children().detach().end().text(l)),e.removeClass("fade top bottom left right in"),e.find(".popover-title").html()||e.find(".popover-title").hide()},o.prototype.hasContent=function(){return this.getTitle()||this.getContent()},o.prototype.getContent=function(){var n=this.$element,e=this.options;return n.attr("data-content")||("function"==typeof e.content?e.content.call(n[0]):e.content)},o.prototype.arrow=function(){return this.$arrow=this.$arrow||this.tip().find(".arrow")};var n=a.fn.popover;a.fn.popover=function(l){return this.each(function(){var n=a(this),e=n.data("bs.popover"),t="object"==typeof l&&l;!e&&/destroy|hide/.test(l)||(e||n.data("bs.popover",e=new o(this,t)),"string"==typeof l&&e[l]())})},a.fn.popover.Constructor=o,a.fn.popover.noConflict=function(){return a.fn.popover=n,this}}(jQuery)},{}],10:[function(n,e,t){!function(a){"use strict";function l(n,e){this.$body=a(document.body),this.$scrollElement=a(n).is(document.body)?a(window):a(n),this.options=a.extend({},l.DEFAULTS,e),this.selector=(this.options.target||"")+" .nav li > a",this.offsets=[],this.targets=[],this.activeTarget=null,this.scrollHeight=0,this.$scrollElement.on("scroll.bs.scrollspy",a.proxy(this.process,this)),this.refresh(),this.process()}function e(t){return this.each(function(){var n=a(this),e=n.data("bs.scrollspy");e||n.data("bs.scrollspy",e=new l(this,"object"==typeof t&&t)),"string"==typeof t&&e[t]()})}l.VERSION="3.4.1",l.DEFAULTS={offset:10},l.prototype.getScrollHeight=function(){return this.$scrollElement[0].scrollHeight||Math.max(this.$body[0].scrollHeight,document.documentElement.scrollHeight)},l.prototype.refresh=function(){var n=this,t="offset",l=0;this.offsets=[],this.targets=[],this.scrollHeight=this.getScrollHeight(),a.isWindow(this.$scrollElement[0])||(t="position",l=this.$scrollElement.scrollTop()),this.$body.find(this.selector).map(function(){var n=a(this),e=n.data("target")||n.attr("href"),n=/^#./.test(e)&&a(e);return n&&n.length&&n.is(":visible")?[[n[t]().top+l,e]]:null}).sort(function(n,e){return n[0]-e[0]}).each(function(){n.offsets.push(this[0]),n.targets.push(this[1])})},l.prototype.process=function(){var n,e=this.$scrollElement.scrollTop()+this.options.offset,t=this.getScrollHeight(),l=this.options.offset+t-this.$scrollElement.height(),a=this.offsets,o=this.targets,r=this.activeTarget;if(this.scrollHeight!=t&&this.refresh(),l<=e)return r!=(n=o[o.length-1])&&this.activate(n);if(r&&e<a[0])return this.activeTarget=null,this.clear();for(n=a.length;n--;)r!=o[n]&&e>=a[n]&&(void 0===a[n+1]||e<a[n+1])&&this.activate(o[n])},l.prototype.activate=function(n){this.activeTarget=n,this.clear();n=this.selector+'[data-target="'+n+'"],'+this.selector+'[href="'+n+'"]',n=a(n).parents("li").addClass("active");(n=n.parent(".dropdown-menu").length?n.closest("li.dropdown").addClass("active"):n).trigger("activate.bs.scrollspy")},l.prototype.clear=function(){a(this.selector).parentsUntil(this.options.target,".active").removeClass("active")};var n=a.fn.scrollspy;a.fn.scrollspy=e,a.fn.scrollspy.Constructor=l,a.fn.scrollspy.noConflict=function(){return a.fn.scrollspy=n,this},a(window).on("load.bs.scrollspy.data-api",function(){a('[data-spy="scroll"]').each(function(){var n=a(this);e.call(n,n.data())})})}(jQuery)},{}],11:[function(n,e,t){!function(r){"use strict";function i(n){this.element=r(n)}function e(t){return this.each(function(){var n=r(this),e=n.data("bs.tab");e||n.data("bs.tab",e=new i(this)),"string"==typeof t&&e[t]()})}i.VERSION="3.4.1",i.TRANSITION_DURATION=150,i.prototype.show=function(){var n,e,t,l=this.element,a=l.closest("ul:not(.dropdown-menu)"),o=(o=l.data("target"))||(o=l.attr("href"))&&o.replace(/.*(?=#[^\s]*$)/,"");l.parent("li").hasClass("active")||(n=a.find(".active:last a"),e=r.Event("hide.bs.tab",{relatedTarget:l[0]}),t=r.Event("show.bs.tab",{relatedTarget:n[0]}),n.trigger(e),l.trigger(t),t.isDefaultPrevented()||e.isDefaultPrevented()||(o=r(document).find(o),this.activate(l.closest("li"),a),this.activate(o,o.parent(),function(){n.trigger({type:"hidden.bs.tab",relatedTarget:l[0]}),l.trigger({type:"shown.bs.tab",relatedTarget:n[0]})})))},i.prototype.activate=function(n,e,t){var l=e.find("> .active"),a=t&&r.support.transition&&(l.length&&l.hasClass("fade")||!!e.find("> .fade").length);function o(){l.removeClass("active").find("> .dropdown-menu > .active").removeClass("active").end().find('[data-toggle="tab"]').attr("aria-expanded",!1),n.addClass("active").find('[data-toggle="tab"]').attr("aria-expanded",!0),a?(n[0].offsetWidth,n.addClass("in")):n.removeClass("fade"),n.parent(".dropdown-menu").length&&n.closest("li.dropdown").addClass("active").end().find('[data-toggle="tab"]').attr("aria-expanded",!0),t&&t()}l.length&&a?l.one("bsTransitionEnd",o).emulateTransitionEnd(i.TRANSITION_DURATION):o(),l.removeClass("in")};var n=r.fn.tab;r.fn.tab=e,r.fn.tab.Constructor=i,r.fn.tab.noConflict=function(){return r.fn.tab=n,this};function t(n){n.preventDefault(),e.call(r(this),"show")}r(document).on("click.bs.tab.data-api",'[data-toggle="tab"]',t).on("click.bs.tab.data-api",'[data-toggle="pill"]',t)}(jQuery)},{}],12:[function(n,e,t){!function(d){"use strict";var l=["sanitize","whiteList","sanitizeFn"],m=["background","cite","href","itemtype","longdesc","poster","src","xlink:href"],n={"*":["class","dir","id","lang","role",/^aria-[\w-]*$/i],a:["target","href","title","rel"],area:[],b:[],br:[],col:[],code:[],div:[],em:[],hr:[],h1:[],h2:[],h3:[],h4:[],h5:[],h6:[],i:[],img:["src","alt","title","width","height"],li:[],ol:[],p:[],pre:[],s:[],small:[],span:[],sub:[],sup:[],strong:[],u:[],ul:[]},f=/^(?:(?:https?|mailto|ftp|tel|file):|[^&:/?#]*(?:[/?#]|$))/gi,g=/^data:(?:image\/(?:bmp|gif|jpeg|jpg|png|tiff|webp)|video\/(?:mpeg|mp4|ogg|webm)|audio\/(?:mp3|oga|ogg|opus));base64,[a-z0-9+/]+=*$/i;function a(n,e,t){if(0===n.length)return n;if(t&&"function"==typeof t)return t(n);if(!document.implementation||!document.implementation.createHTMLDocument)return n;t=document.implementation.createHTMLDocument("sanitization");t.body.innerHTML=n;for(var l=d.map(e,function(n,e){return e}),a=d(t.body).find("*"),o=0,r=a.length;o<r;o++){var i=a[o],s=i.nodeName.toLowerCase();if(-1!==d.inArray(s,l))for(var c=d.map(i.attributes,function(n){return n}),u=[].concat(e["*"]||[],e[s]||[]),h=0,p=c.length;h<p;h++)!function(n,e){var t=n.nodeName.toLowerCase();if(-1!==d.inArray(t,e))return-1===d.inArray(t,m)||Boolean(n.nodeValue.match(f)||n.nodeValue.match(g));for(var l=d(e).filter(function(n,e){return e instanceof RegExp}),a=0,o=l.length;a<o;a++)if(t.match(l[a]))return 1}(c[h],u)&&i.removeAttribute(c[h].nodeName);else i.parentNode.removeChild(i)}return t.body.innerHTML}function s(n,e){this.type=null,this.options=null,this.enabled=null,this.timeout=null,this.hoverState=null,this.$element=null,this.inState=null,this.init("tooltip",n,e)}s.VERSION="3.4.1",s.TRANSITION_DURATION=150,s.DEFAULTS={animation:!0,placement:"top",selector:!1,template:'<div class="tooltip" role="tooltip"><div class="tooltip-arrow"></div><div class="tooltip-inner"></div></div>',trigger:"hover focus",title:"",delay:0,html:!1,container:!1,viewport:{selector:"body",padding:0},sanitize:!0,sanitizeFn:null,whiteList:n},s.prototype.init=function(n,e,t){if(this.enabled=!0,this.type=n,this.$element=d(e),this.options=this.getOptions(t),this.$viewport=this.options.viewport&&d(document).find(d.isFunction(this.options.viewport)?this.options.viewport.call(this,this.$element):this.options.viewport.selector||this.options.viewport),this.inState={click:!1,hover:!1,focus:!1},this.$element[0]instanceof document.constructor&&!this.options.selector)throw new Error("`selector` option must be specified when initializing "+this.type+" on the window.document object!");for(var l=this.options.trigger.split(" "),a=l.length;a--;){var o,r=l[a];"click"==r?this.$element.on("click."+this.type,this.options.selector,d.proxy(this.toggle,this)):"manual"!=r&&(o="hover"==r?"mouseleave":"focusout",this.$element.on(("hover"==r?"mouseenter":"focusin")+"."+this.type,this.options.selector,d.proxy(this.enter,this)),this.$element.on(o+"."+this.type,this.options.selector,d.proxy(this.leave,this)))}this.options.selector?this._options=d.extend({},this.options,{trigger:"manual",selector:""}):this.fixTitle()},s.prototype.getDefaults=function(){return s.DEFAULTS},s.prototype.getOptions=function(n){var e,t=this.$element.data();for(e in t)t.hasOwnProperty(e)&&-1!==d.inArray(e,l)&&delete t[e];return(n=d.extend({},this.getDefaults(),t,n)).delay&&"number"==typeof n.delay&&(n.delay={show:n.delay,hide:n.delay}),n.sanitize&&(n.template=a(n.template,n.whiteList,n.sanitizeFn)),n},s.prototype.getDelegateOptions=function(){var t={},l=this.getDefaults();return this._options&&d.each(this._options,function(n,e){l[n]!=e&&(t[n]=e)}),t},s.prototype.enter=function(n){var e=n instanceof this.constructor?n:d(n.currentTarget).data("bs."+this.type);if(e||(e=new this.constructor(n.currentTarget,this.getDelegateOptions()),d(n.currentTarget).data("bs."+this.type,e)),n instanceof d.Event&&(e.inState["focusin"==n.type?"focus":"hover"]=!0),e.tip().hasClass("in")||"in"==e.hoverState)e.hoverState="in";else{if(clearTimeout(e.timeout),e.hoverState="in",!e.options.delay||!e.options.delay.show)return e.show();e.timeout=setTimeout(function(){"in"==e.hoverState&&e.show()},e.options.delay.show)}},s.prototype.isInStateTrue=function(){for(var n in this.inState)if(this.inState[n])return!0;return!1},s.prototype.leave=function(n){var e=n instanceof this.constructor?n:d(n.currentTarget).data("bs."+this.type);if(e||(e=new this.constructor(n.currentTarget,this.getDelegateOptions()),d(n.currentTarget).data("bs."+this.type,e)),n instanceof d.Event&&(e.inState["focusout"==n.type?"focus":"hover"]=!1),!e.isInStateTrue()){if(clearTimeout(e.timeout),e.hoverState="out",!e.options.delay||!e.options.delay.hide)return e.hide();e.timeout=setTimeout(function(){"out"==e.hoverState&&e.hide()},e.options.delay.hide)}},s.prototype.show=function(){var e,n,t,l,a,o,r,i=d.Event("show.bs."+this.type);this.hasContent()&&this.enabled&&(this.$element.trigger(i),t=d.contains(this.$element[0].ownerDocument.documentElement,this.$element[0]),!i.isDefaultPrevented()&&t&&(n=(e=this).tip(),o=this.getUID(this.type),this.setContent(),n.attr("id",o),this.$element.attr("aria-describedby",o),this.options.animation&&n.addClass("fade"),r="function"==typeof this.options.placement?this.options.placement.call(this,n[0],this.$element[0]):this.options.placement,(a=(l=/\s?auto?\s?/i).test(r))&&(r=r.replace(l,"")||"top"),n.detach().css({top:0,left:0,display:"block"}).addClass(r).data("bs."+this.type,this),this.options.container?n.appendTo(d(document).find(this.options.container)):n.insertAfter(this.$element),this.$element.trigger("inserted.bs."+this.type),i=this.getPosition(),t=n[0].offsetWidth,o=n[0].offsetHeight,a&&(l=r,a=this.getPosition(this.$viewport),r="bottom"==r&&i.bottom+o>a.bottom?"top":"top"==r&&i.top-o<a.top?"bottom":"right"==r&&i.right+t>a.width?"left":"left"==r&&i.left-t<a.left?"right":r,n.removeClass(l).addClass(r)),o=this.getCalculatedOffset(r,i,t,o),this.applyPlacement(o,r),r=function(){var n=e.hoverState;e.$element.trigger("shown.bs."+e.type),e.hoverState=null,"out"==n&&e.leave(e)},d.support.transition&&this.$tip.hasClass("fade")?n.one("bsTransitionEnd",r).emulateTransitionEnd(s.TRANSITION_DURATION):r()))},s.prototype.applyPlacement=function(n,e){var t=this.tip(),l=t[0].offsetWidth,a=t[0].offsetHeight,o=parseInt(t.css("margin-top"),10),r=parseInt(t.css("margin-left"),10);isNaN(o)&&(o=0),isNaN(r)&&(r=0),n.top+=o,n.left+=r,d.offset.setOffset(t[0],d.extend({using:function(n){t.css({top:Math.round(n.top),left:Math.round(n.left)})}},n),0),t.addClass("in");var i=t[0].offsetWidth,o=t[0].offsetHeight;"top"==e&&o!=a&&(n.top=n.top+a-o);r=this.getViewportAdjustedDelta(e,n,i,o);r.left?n.left+=r.left:n.top+=r.top;e=/top|bottom/.test(e),a=e?2*r.left-l+i:2*r.top-a+o,o=e?"offsetWidth":"offsetHeight";t.offset(n),this.replaceArrow(a,t[0][o],e)},s.prototype.replaceArrow=function(n,e,t){this.arrow().css(t?"left":"top",50*(1-n/e)+"%").css(t?"top":"left","")},s.prototype.setContent=function(){var n=this.tip(),e=this.getTitle();this.options.html?(this.options.sanitize&&(e=a(e,this.options.whiteList,this.options.sanitizeFn)),n.find(".tooltip-inner").html(e)):n.find(".tooltip-inner").text(e),n.removeClass("fade in top bottom left right")},s.prototype.hide=function(n){var e=this,t=d(this.$tip),l=d.Event("hide.bs."+this.type);function a(){"in"!=e.hoverState&&t.detach(),e.$element&&e.$element.removeAttr("aria-describedby").trigger("hidden.bs."+e.type),n&&n()}if(this.$element.trigger(l),!l.isDefaultPrevented())return t.removeClass("in"),d.support.transition&&t.hasClass("fade")?t.one("bsTransitionEnd",a).emulateTransitionEnd(s.TRANSITION_DURATION):a(),this.hoverState=null,this},s.prototype.fixTitle=function(){var n=this.$element;!n.attr("title")&&"string"==typeof n.attr("data-original-title")||n.attr("data-original-title",n.attr("title")||"").attr("title","")},s.prototype.hasContent=function(){return this.getTitle()},s.prototype.getPosition=function(n){var e=(n=n||this.$element)[0],t="BODY"==e.tagName,l=e.getBoundingClientRect();null==l.width&&(l=d.extend({},l,{width:l.right-l.left,height:l.bottom-l.top}));e=window.SVGElement&&e instanceof window.SVGElement,e=t?{top:0,left:0}:e?null:n.offset(),n={scroll:t?document.documentElement.scrollTop||document.body.scrollTop:n.scrollTop()},t=t?{width:d(window).width(),height:d(window).height()}:null;return d.extend({},l,n,t,e)},s.prototype.getCalculatedOffset=function(n,e,t,l){return"bottom"==n?{top:e.top+e.height,left:e.left+e.width/2-t/2}:"top"==n?{top:e.top-l,left:e.left+e.width/2-t/2}:"left"==n?{top:e.top+e.height/2-l/2,left:e.left-t}:{top:e.top+e.height/2-l/2,left:e.left+e.width}},s.prototype.getViewportAdjustedDelta=function(n,e,t,l){var a={top:0,left:0};if(!this.$viewport)return a;var o,r=this.options.viewport&&this.options.viewport.padding||0,i=this.getPosition(this.$viewport);return/right|left/.test(n)?(n=e.top-r-i.scroll,o=e.top+r-i.scroll+l,n<i.top?a.top=i.top-n:o>i.top+i.height&&(a.top=i.top+i.height-o)):(o=e.left-r,t=e.left+r+t,o<i.left?a.left=i.left-o:t>i.right&&(a.left=i.left+i.width-t)),a},s.prototype.getTitle=function(){var n=this.$element,e=this.options;return n.attr("data-original-title")||("function"==typeof e.title?e.title.call(n[0]):e.title)},s.prototype.getUID=function(n){for(;n+=~~(1e6*Math.random()),document.getElementById(n););return n},s.prototype.tip=function(){if(!this.$tip&&(this.$tip=d(this.options.template),1!=this.$tip.length))throw new Error(this.type+" `template` option must consist of exactly 1 top-level element!");return this.$tip},s.prototype.arrow=function(){return this.$arrow=this.$arrow||this.tip().find(".tooltip-arrow")},s.prototype.enable=function(){this.enabled=!0},s.prototype.disable=function(){this.enabled=!1},s.prototype.toggleEnabled=function(){this.enabled=!this.enabled},s.prototype.toggle=function(n){var e=this;n&&((e=d(n.currentTarget).data("bs."+this.type))||(e=new this.constructor(n.currentTarget,this.getDelegateOptions()),d(n.currentTarget).data("bs."+this.type,e))),n?(e.inState.click=!e.inState.click,e.isInStateTrue()?e.enter(e):e.leave(e)):e.tip().hasClass("in")?e.leave(e):e.enter(e)},s.prototype.destroy=function(){var n=this;clearTimeout(this.timeout),this.hide(function(){n.$element.off("."+n.type).removeData("bs."+n.type),n.$tip&&n.$tip.detach(),n.$tip=null,n.$arrow=null,n.$viewport=null,n.$element=null})},s.prototype.sanitizeHtml=function(n){return a(n,this.options.whiteList,this.options.sanitizeFn)};var e=d.fn.tooltip;d.fn.tooltip=function(l){return this.each(function(){var n=d(this),e=n.data("bs.tooltip"),t="object"==typeof l&&l;!e&&/destroy|hide/.test(l)||(e||n.data("bs.tooltip",e=new s(this,t)),"string"==typeof l&&e[l]())})},d.fn.tooltip.Constructor=s,d.fn.tooltip.noConflict=function(){return d.fn.tooltip=e,this}}(jQuery)},{}],13:[function(n,e,t){!function(l){"use strict";l.fn.emulateTransitionEnd=function(n){var e=!1,t=this;l(this).one("bsTransitionEnd",function(){e=!0});return setTimeout(function(){e||l(t).trigger(l.support.transition.end)},n),this},l(function(){l.support.transition=function(){var n,e=document.createElement("bootstrap"),t={WebkitTransition:"webkitTransitionEnd",MozTransition:"transitionend",OTransition:"oTransitionEnd otransitionend",transition:"transitionend"};for(n in t)if(void 0!==e.style[n])return{end:t[n]};return!1}(),l.support.transition&&(l.event.special.bsTransitionEnd={bindType:l.support.transition.end,delegateType:l.support.transition.end,handle:function(n){if(l(n.target).is(this))return n.handleObj.handler.apply(this,arguments)}})})}(jQuery)},{}],14:[function(n,e,t){"use strict";function l(n){return n&&n.__esModule?n:{default:n}}function a(n){if(n&&n.__esModule)return n;var e={};if(null!=n)for(var t in n)Object.prototype.hasOwnProperty.call(n,t)&&(e[t]=n[t]);return e.default=n,e}t.__esModule=!0;var o=a(n("./handlebars/base")),r=l(n("./handlebars/safe-string")),i=l(n("./handlebars/exception")),s=a(n("./handlebars/utils")),c=a(n("./handlebars/runtime")),u=l(n("./handlebars/no-conflict"));function h(){var e=new o.HandlebarsEnvironment;return s.extend(e,o),e.SafeString=r.default,e.Exception=i.default,e.Utils=s,e.escapeExpression=s.escapeExpression,e.VM=c,e.template=function(n){return c.template(n,e)},e}n=h();n.create=h,u.default(n),n.default=n,t.default=n,e.exports=t.default},{"./handlebars/base":15,"./handlebars/exception":18,"./handlebars/no-conflict":31,"./handlebars/runtime":32,"./handlebars/safe-string":33,"./handlebars/utils":34}],15:[function(n,e,t){"use strict";function l(n){return n&&n.__esModule?n:{default:n}}t.__esModule=!0,t.HandlebarsEnvironment=h;var a=n("./utils"),o=l(n("./exception")),r=n("./helpers"),i=n("./decorators"),s=l(n("./logger")),c=n("./internal/proto-access");t.VERSION="4.7.7";t.COMPILER_REVISION=8;t.LAST_COMPATIBLE_COMPILER_REVISION=7;t.REVISION_CHANGES={1:"<= 1.0.rc.2",2:"== 1.0.0-rc.3",3:"== 1.0.0-rc.4",4:"== 1.x.x",5:"== 2.0.0-alpha.x",6:">= 2.0.0-beta.1",7:">= 4.0.0 <4.3.0",8:">= 4.3.0"};var u="[object Object]";function h(n,e,t){this.helpers=n||{},this.partials=e||{},this.decorators=t||{},r.registerDefaultHelpers(this),i.registerDefaultDecorators(this)}h.prototype={constructor:h,logger:s.default,log:s.default.log,registerHelper:function(n,e){if(a.toString.call(n)===u){if(e)throw new o.default("Arg not supported with multiple helpers");a.extend(this.helpers,n)}else this.helpers[n]=e},unregisterHelper:function(n){delete this.helpers[n]},registerPartial:function(n,e){if(a.toString.call(n)===u)a.extend(this.partials,n);else{if(void 0===e)throw new o.default('Attempting to register a partial called "'+n+'" as undefined');this.partials[n]=e}},unregisterPartial:function(n){delete this.partials[n]},registerDecorator:function(n,e){if(a.toString.call(n)===u){if(e)throw new o.default("Arg not supported with multiple decorators");a.extend(this.decorators,n)}else this.decorators[n]=e},unregisterDecorator:function(n){delete this.decorators[n]},resetLoggedPropertyAccesses:function(){c.resetLoggedProperties()}};n=s.default.log;t.log=n,t.createFrame=a.createFrame,t.logger=s.default},{"./decorators":16,"./exception":18,"./helpers":19,"./internal/proto-access":28,"./logger":30,"./utils":34}],16:[function(n,e,t){"use strict";t.__esModule=!0,t.registerDefaultDecorators=function(n){o.default(n)};var l,a=n("./decorators/inline"),o=(l=a)&&l.__esModule?l:{default:l}},{"./decorators/inline":17}],17:[function(n,e,t){"use strict";t.__esModule=!0;var r=n("../utils");t.default=function(n){n.registerDecorator("inline",function(l,a,o,n){var e=l;return a.partials||(a.partials={},e=function(n,e){var t=o.partials;o.partials=r.extend({},t,a.partials);e=l(n,e);return o.partials=t,e}),a.partials[n.args[0]]=n.fn,e})},e.exports=t.default},{"../utils":34}],18:[function(n,e,t){"use strict";t.__esModule=!0;var s=["description","fileName","lineNumber","endLineNumber","message","name","number","stack"];function c(n,e){var t=e&&e.loc,l=void 0,a=void 0,o=void 0,e=void 0;t&&(l=t.start.line,a=t.end.line,o=t.start.column,e=t.end.column,n+=" - "+l+":"+o);for(var r=Error.prototype.constructor.call(this,n),i=0;i<s.length;i++)this[s[i]]=r[s[i]];Error.captureStackTrace&&Error.captureStackTrace(this,c);try{t&&(this.lineNumber=l,this.endLineNumber=a,Object.defineProperty?(Object.defineProperty(this,"column",{value:o,enumerable:!0}),Object.defineProperty(this,"endColumn",{value:e,enumerable:!0})):(this.column=o,this.endColumn=e))}catch(n){}}c.prototype=new Error,t.default=c,e.exports=t.default},{}],19:[function(n,e,t){"use strict";function l(n){return n&&n.__esModule?n:{default:n}}t.__esModule=!0,t.registerDefaultHelpers=function(n){a.default(n),o.default(n),r.default(n),i.default(n),s.default(n),c.default(n),u.default(n)},t.moveHelperToHooks=function(n,e,t){n.helpers[e]&&(n.hooks[e]=n.helpers[e],t||delete n.helpers[e])};var a=l(n("./helpers/block-helper-missing")),o=l(n("./helpers/each")),r=l(n("./helpers/helper-missing")),i=l(n("./helpers/if")),s=l(n("./helpers/log")),c=l(n("./helpers/lookup")),u=l(n("./helpers/with"))},{"./helpers/block-helper-missing":20,"./helpers/each":21,"./helpers/helper-missing":22,"./helpers/if":23,"./helpers/log":24,"./helpers/lookup":25,"./helpers/with":26}],20:[function(n,e,t){"use strict";t.__esModule=!0;var o=n("../utils");t.default=function(a){a.registerHelper("blockHelperMissing",function(n,e){var t=e.inverse,l=e.fn;return!0===n?l(this):!1===n||null==n?t(this):o.isArray(n)?0<n.length?(e.ids&&(e.ids=[e.name]),a.helpers.each(n,e)):t(this):(e.data&&e.ids&&((t=o.createFrame(e.data)).contextPath=o.appendContextPath(e.data.contextPath,e.name),e={data:t}),l(n,e))})},e.exports=t.default},{"../utils":34}],21:[function(t,l,a){!function(g){!function(){"use strict";a.__esModule=!0;var n,m=t("../utils"),e=t("../exception"),f=(n=e)&&n.__esModule?n:{default:n};a.default=function(n){n.registerHelper("each",function(l,n){if(!n)throw new f.default("Must pass iterator to #each");var e,a=n.fn,t=n.inverse,o=0,r="",i=void 0,s=void 0;function c(n,e,t){i&&(i.key=n,i.index=e,i.first=0===e,i.last=!!t,s&&(i.contextPath=s+n)),r+=a(l[n],{data:i,blockParams:m.blockParams([l[n],n],[s+n,null])})}if(n.data&&n.ids&&(s=m.appendContextPath(n.data.contextPath,n.ids[0])+"."),m.isFunction(l)&&(l=l.call(this)),n.data&&(i=m.createFrame(n.data)),l&&"object"==typeof l)if(m.isArray(l))for(var u=l.length;o<u;o++)o in l&&c(o,o,o===l.length-1);else if(g.Symbol&&l[g.Symbol.iterator]){for(var h=[],p=l[g.Symbol.iterator](),d=p.next();!d.done;d=p.next())h.push(d.value);for(u=(l=h).length;o<u;o++)c(o,o,o===l.length-1)}else e=void 0,Object.keys(l).forEach(function(n){void 0!==e&&c(e,o-1),e=n,o++}),void 0!==e&&c(e,o-1,!0);return r=0===o?t(this):r})},l.exports=a.default}.call(this)}.call(this,"undefined"!=typeof global?global:"undefined"!=typeof self?self:"undefined"!=typeof window?window:{})},{"../exception":18,"../utils":34}],22:[function(n,e,t){"use strict";t.__esModule=!0;var l,a=n("../exception"),o=(l=a)&&l.__esModule?l:{default:l};t.default=function(n){n.registerHelper("helperMissing",function(){if(1!==arguments.length)throw new o.default('Missing helper: "'+arguments[arguments.length-1].name+'"')})},e.exports=t.default},{"../exception":18}],23:[function(n,e,t){"use strict";t.__esModule=!0;var l,a=n("../utils"),o=n("../exception"),r=(l=o)&&l.__esModule?l:{default:l};t.default=function(t){t.registerHelper("if",function(n,e){if(2!=arguments.length)throw new r.default("#if requires exactly one argument");return a.isFunction(n)&&(n=n.call(this)),!e.hash.includeZero&&!n||a.isEmpty(n)?e.inverse(this):e.fn(this)}),t.registerHelper("unless",function(n,e){if(2!=arguments.length)throw new r.default("#unless requires exactly one argument");return t.helpers.if.call(this,n,{fn:e.inverse,inverse:e.fn,hash:e.hash})})},e.exports=t.default},{"../exception":18,"../utils":34}],24:[function(n,e,t){"use strict";t.__esModule=!0,t.default=function(a){a.registerHelper("log",function(){for(var n=[void 0],e=arguments[arguments.length-1],t=0;t<arguments.length-1;t++)n.push(arguments[t]);var l=1;null!=e.hash.level?l=e.hash.level:e.data&&null!=e.data.level&&(l=e.data.level),n[0]=l,a.log.apply(a,n)})},e.exports=t.default},{}],25:[function(n,e,t){"use strict";t.__esModule=!0,t.default=function(n){n.registerHelper("lookup",function(n,e,t){return n&&t.lookupProperty(n,e)})},e.exports=t.default},{}],26:[function(n,e,t){"use strict";t.__esModule=!0;var l,a=n("../utils"),o=n("../exception"),r=(l=o)&&l.__esModule?l:{default:l};t.default=function(n){n.registerHelper("with",function(n,e){if(2!=arguments.length)throw new r.default("#with requires exactly one argument");a.isFunction(n)&&(n=n.call(this));var t=e.fn;if(a.isEmpty(n))return e.inverse(this);var l=e.data;return e.data&&e.ids&&((l=a.createFrame(e.data)).contextPath=a.appendContextPath(e.data.contextPath,e.ids[0])),t(n,{data:l,blockParams:a.blockParams([n],[l&&l.contextPath])})})},e.exports=t.default},{"../exception":18,"../utils":34}],27:[function(n,e,t){"use strict";t.__esModule=!0,t.createNewLookupObject=function(){for(var n=arguments.length,e=Array(n),t=0;t<n;t++)e[t]=arguments[t];return l.extend.apply(void 0,[Object.create(null)].concat(e))};var l=n("../utils")},{"../utils":34}],28:[function(n,e,t){"use strict";t.__esModule=!0,t.createProtoAccessControl=function(n){var e=Object.create(null);e.constructor=!1,e.__defineGetter__=!1,e.__defineSetter__=!1,e.__lookupGetter__=!1;var t=Object.create(null);return t.__proto__=!1,{properties:{whitelist:l.createNewLookupObject(t,n.allowedProtoProperties),defaultValue:n.allowProtoPropertiesByDefault},methods:{whitelist:l.createNewLookupObject(e,n.allowedProtoMethods),defaultValue:n.allowProtoMethodsByDefault}}},t.resultIsAllowed=function(n,e,t){return r("function"==typeof n?e.methods:e.properties,t)},t.resetLoggedProperties=function(){Object.keys(o).forEach(function(n){delete o[n]})};var l=n("./create-new-lookup-object"),a=function(n){if(n&&n.__esModule)return n;var e={};if(null!=n)for(var t in n)Object.prototype.hasOwnProperty.call(n,t)&&(e[t]=n[t]);return e.default=n,e}(n("../logger")),o=Object.create(null);function r(n,e){return void 0!==n.whitelist[e]?!0===n.whitelist[e]:void 0!==n.defaultValue?n.defaultValue:(!0!==o[e=e]&&(o[e]=!0,a.log("error",'Handlebars: Access has been denied to resolve the property "'+e+'" because it is not an "own property" of its parent.\nYou can add a runtime option to disable the check or this warning:\nSee https://handlebarsjs.com/api-reference/runtime-options.html#options-to-control-prototype-access for details')),!1)}},{"../logger":30,"./create-new-lookup-object":27}],29:[function(n,e,t){"use strict";t.__esModule=!0,t.wrapHelper=function(e,t){return"function"==typeof e?function(){var n=arguments[arguments.length-1];return arguments[arguments.length-1]=t(n),e.apply(this,arguments)}:e}},{}],30:[function(n,e,t){"use strict";t.__esModule=!0;var l=n("./utils"),a={methodMap:["debug","info","warn","error"],level:"info",lookupLevel:function(n){var e;return n="string"==typeof n?0<=(e=l.indexOf(a.methodMap,n.toLowerCase()))?e:parseInt(n,10):n},log:function(n){if(n=a.lookupLevel(n),"undefined"!=typeof console&&a.lookupLevel(a.level)<=n){n=a.methodMap[n];console[n]||(n="log");for(var e=arguments.length,t=Array(1<e?e-1:0),l=1;l<e;l++)t[l-1]=arguments[l];console[n].apply(console,t)}}};t.default=a,e.exports=t.default},{"./utils":34}],31:[function(n,e,t){!function(l){!function(){"use strict";t.__esModule=!0,t.default=function(n){var e=void 0!==l?l:window,t=e.Handlebars;n.noConflict=function(){return e.Handlebars===n&&(e.Handlebars=t),n}},e.exports=t.default}.call(this)}.call(this,"undefined"!=typeof global?global:"undefined"!=typeof self?self:"undefined"!=typeof window?window:{})},{}],32:[function(n,e,t){"use strict";t.__esModule=!0,t.checkRevision=function(n){var e=n&&n[0]||1,t=p.COMPILER_REVISION;if(!(e>=p.LAST_COMPATIBLE_COMPILER_REVISION&&e<=p.COMPILER_REVISION)){if(e<p.LAST_COMPATIBLE_COMPILER_REVISION){t=p.REVISION_CHANGES[t],e=p.REVISION_CHANGES[e];throw new h.default("Template was precompiled with an older version of Handlebars than the current runtime. Please update your precompiler to a newer version ("+t+") or downgrade your runtime to an older version ("+e+").")}throw new h.default("Template was precompiled with a newer version of Handlebars than the current runtime. Please update your runtime to a newer version ("+n[1]+").")}},t.template=function(s,c){if(!c)throw new h.default("No environment passed to template");if(!s||!s.main)throw new h.default("Unknown template object: "+typeof s);s.main.decorator=s.main_d,c.VM.checkRevision(s.compiler);var t=s.compiler&&7===s.compiler[0];var r={strict:function(n,e,t){if(!(n&&e in n))throw new h.default('"'+e+'" not defined in '+n,{loc:t});return r.lookupProperty(n,e)},lookupProperty:function(n,e){var t=n[e];return null==t||Object.prototype.hasOwnProperty.call(n,e)||m.resultIsAllowed(t,r.protoAccessControl,e)?t:void 0},lookup:function(n,e){for(var t=n.length,l=0;l<t;l++)if(null!=(n[l]&&r.lookupProperty(n[l],e)))return n[l][e]},lambda:function(n,e){return"function"==typeof n?n.call(e):n},escapeExpression:u.escapeExpression,invokePartial:function(n,e,t){t.hash&&(e=u.extend({},e,t.hash),t.ids&&(t.ids[0]=!0)),n=c.VM.resolvePartial.call(this,n,e,t);var l=u.extend({},t,{hooks:this.hooks,protoAccessControl:this.protoAccessControl}),a=c.VM.invokePartial.call(this,n,e,l);if(null==a&&c.compile&&(t.partials[t.name]=c.compile(n,s.compilerOptions,c),a=t.partials[t.name](e,l)),null==a)throw new h.default("The partial "+t.name+" could not be compiled when running in runtime-only mode");if(t.indent){for(var o=a.split("\n"),r=0,i=o.length;r<i&&(o[r]||r+1!==i);r++)o[r]=t.indent+o[r];a=o.join("\n")}return a},fn:function(n){var e=s[n];return e.decorator=s[n+"_d"],e},programs:[],program:function(n,e,t,l,a){var o=this.programs[n],r=this.fn(n);return o=e||a||l||t?f(this,n,r,e,t,l,a):o||(this.programs[n]=f(this,n,r))},data:function(n,e){for(;n&&e--;)n=n._parent;return n},mergeIfNeeded:function(n,e){var t=n||e;return t=n&&e&&n!==e?u.extend({},e,n):t},nullContext:Object.seal({}),noop:c.VM.noop,compilerInfo:s.compiler};function i(n){var e=arguments.length<=1||void 0===arguments[1]?{}:arguments[1],t=e.data;i._setup(e),!e.partial&&s.useData&&(t=function(n,e){e&&"root"in e||((e=e?p.createFrame(e):{}).root=n);return e}(n,t));var l=void 0,a=s.useBlockParams?[]:void 0;function o(n){return""+s.main(r,n,r.helpers,r.partials,t,a,l)}return s.useDepths&&(l=e.depths?n!=e.depths[0]?[n].concat(e.depths):e.depths:[n]),(o=g(s.main,o,r,e.depths||[],t,a))(n,e)}return i.isTop=!0,i._setup=function(n){var e,l,a;n.partial?(r.protoAccessControl=n.protoAccessControl,r.helpers=n.helpers,r.partials=n.partials,r.decorators=n.decorators,r.hooks=n.hooks):(e=u.extend({},c.helpers,n.helpers),l=e,a=r,Object.keys(l).forEach(function(n){var e,t=l[n];l[n]=(e=a.lookupProperty,d.wrapHelper(t,function(n){return u.extend({lookupProperty:e},n)}))}),r.helpers=e,s.usePartial&&(r.partials=r.mergeIfNeeded(n.partials,c.partials)),(s.usePartial||s.useDecorators)&&(r.decorators=u.extend({},c.decorators,n.decorators)),r.hooks={},r.protoAccessControl=m.createProtoAccessControl(n),n=n.allowCallsToHelperMissing||t,o.moveHelperToHooks(r,"helperMissing",n),o.moveHelperToHooks(r,"blockHelperMissing",n))},i._child=function(n,e,t,l){if(s.useBlockParams&&!t)throw new h.default("must pass block params");if(s.useDepths&&!l)throw new h.default("must pass parent depths");return f(r,n,s[n],e,0,t,l)},i},t.wrapProgram=f,t.resolvePartial=function(n,e,t){n?n.call||t.name||(t.name=n,n=t.partials[n]):n="@partial-block"===t.name?t.data["partial-block"]:t.partials[t.name];return n},t.invokePartial=function(n,e,l){var a=l.data&&l.data["partial-block"];l.partial=!0,l.ids&&(l.data.contextPath=l.ids[0]||l.data.contextPath);var o=void 0;l.fn&&l.fn!==r&&function(){l.data=p.createFrame(l.data);var t=l.fn;o=l.data["partial-block"]=function(n){var e=arguments.length<=1||void 0===arguments[1]?{}:arguments[1];return e.data=p.createFrame(e.data),e.data["partial-block"]=a,t(n,e)},t.partials&&(l.partials=u.extend({},l.partials,t.partials))}();void 0===n&&o&&(n=o);{if(void 0===n)throw new h.default("The partial "+l.name+" could not be found");if(n instanceof Function)return n(e,l)}},t.noop=r;var l,u=function(n){if(n&&n.__esModule)return n;var e={};if(null!=n)for(var t in n)Object.prototype.hasOwnProperty.call(n,t)&&(e[t]=n[t]);return e.default=n,e}(n("./utils")),a=n("./exception"),h=(l=a)&&l.__esModule?l:{default:l},p=n("./base"),o=n("./helpers"),d=n("./internal/wrapHelper"),m=n("./internal/proto-access");function f(l,n,a,o,e,r,i){function t(n){var e=arguments.length<=1||void 0===arguments[1]?{}:arguments[1],t=i;return!i||n==i[0]||n===l.nullContext&&null===i[0]||(t=[n].concat(i)),a(l,n,l.helpers,l.partials,e.data||o,r&&[e.blockParams].concat(r),t)}return(t=g(a,t,l,i,o,r)).program=n,t.depth=i?i.length:0,t.blockParams=e||0,t}function r(){return""}function g(n,e,t,l,a,o){return n.decorator&&(e=n.decorator(e,n={},t,l&&l[0],a,o,l),u.extend(e,n)),e}},{"./base":15,"./exception":18,"./helpers":19,"./internal/proto-access":28,"./internal/wrapHelper":29,"./utils":34}],33:[function(n,e,t){"use strict";function l(n){this.string=n}t.__esModule=!0,l.prototype.toString=l.prototype.toHTML=function(){return""+this.string},t.default=l,e.exports=t.default},{}],34:[function(n,e,t){"use strict";t.__esModule=!0,t.extend=i,t.indexOf=function(n,e){for(var t=0,l=n.length;t<l;t++)if(n[t]===e)return t;return-1},t.escapeExpression=function(n){if("string"!=typeof n){if(n&&n.toHTML)return n.toHTML();if(null==n)return"";if(!n)return n+"";n=""+n}return o.test(n)?n.replace(a,r):n},t.isEmpty=function(n){return!n&&0!==n||!(!u(n)||0!==n.length)},t.createFrame=function(n){var e=i({},n);return e._parent=n,e},t.blockParams=function(n,e){return n.path=e,n},t.appendContextPath=function(n,e){return(n?n+".":"")+e};var l={"&":"&amp;","<":"&lt;",">":"&gt;",'"':"&quot;","'":"&#x27;","`":"&#x60;","=":"&#x3D;"},a=/[&<>"'`=]/g,o=/[&<>"'`=]/;function r(n){return l[n]}function i(n){for(var e=1;e<arguments.length;e++)for(var t in arguments[e])Object.prototype.hasOwnProperty.call(arguments[e],t)&&(n[t]=arguments[e][t]);return n}var s=Object.prototype.toString;t.toString=s;var c=function(n){return"function"==typeof n};c(/x/)&&(t.isFunction=c=function(n){return"function"==typeof n&&"[object Function]"===s.call(n)}),t.isFunction=c;var u=Array.isArray||function(n){return!(!n||"object"!=typeof n)&&"[object Array]"===s.call(n)};t.isArray=u},{}],35:[function(n,e,t){e.exports=n("handlebars/runtime").default},{"handlebars/runtime":14}],36:[function(n,e,t){var l=n("backbone"),a=n("underscore"),l=new(l.Model.extend({defaults:function(){return{VERSION:VERSION,GRAPHITE_URL:GRAPHITE_URL,GRAPH_ENABLED:GRAPH_ENABLED,STATSD_INTERVAL:STATSD_INTERVAL,STATSD_COUNTER_FORMAT:STATSD_COUNTER_FORMAT,STATSD_GAUGE_FORMAT:STATSD_GAUGE_FORMAT,STATSD_PREFIX:STATSD_PREFIX,NSQLOOKUPD:NSQLOOKUPD,graph_interval:"2h",IS_ADMIN:IS_ADMIN,BASE_PATH:BASE_PATH}},initialize:function(){this.on("change:graph_interval",function(n,e){localStorage.setItem("graph_interval",e)});var n=a.object(a.compact(a.map(window.location.search.slice(1).split("&"),function(n){return!!n&&n.split("=")}))),e=this.get("GRAPH_ENABLED")?"2h":"off",e=n.t||localStorage.getItem("graph_interval")||e;this.set("graph_interval",e)},basePath:function(n){return(("/"===this.get("BASE_PATH")?"":this.get("BASE_PATH"))+n).replace(/\/$/,"")||"/"},apiPath:function(n){return this.basePath("/api"+n)}}));window.AppState=l,e.exports=l},{backbone:void 0,underscore:void 0}],37:[function(n,e,t){var l=n("backbone"),a=n("../app_state"),n=n("../models/node"),n=l.Collection.extend({model:n,comparator:"id",constructor:function(){l.Collection.prototype.constructor.apply(this,arguments)},url:function(){return a.apiPath("/nodes")},parse:function(n){return n.nodes.forEach(function(n){var e=n.broadcast_address;e.includes(":")&&(e="["+e+"]"),n.broadcast_address_http=e+":"+n.http_port}),n.nodes}});e.exports=n},{"../app_state":36,"../models/node":44,backbone:void 0}],38:[function(n,e,t){var l=n("underscore"),a=n("backbone"),o=n("../app_state"),n=n("../models/topic"),n=a.Collection.extend({model:n,comparator:"id",constructor:function(){a.Collection.prototype.constructor.apply(this,arguments)},url:function(){return o.apiPath("/topics")},parse:function(n){return l.map(n.topics,function(n){return{name:n}})}});e.exports=n},{"../app_state":36,"../models/topic":45,backbone:void 0,underscore:void 0}],39:[function(n,e,t){var l=n("jquery"),a=n("underscore");l.ajaxPrefilter(function(n){n.headers=a.defaults(n.headers||{},{"X-UserAgent":USER_AGENT,Accept:"application/vnd.nsq; version=1.0"}),n.timeout=2e4,n.contentType="application/json"})},{jquery:void 0,underscore:void 0}],40:[function(n,e,t){function u(n,e){var t=e;return"counter"===n?t=m.get("STATSD_COUNTER_FORMAT").replace(/%s/g,e):"gauge"===n&&(t=m.get("STATSD_GAUGE_FORMAT").replace(/%s/g,e)),t}function h(n){return{depth:"gauge",in_flight_count:"gauge",deferred_count:"gauge",requeue_count:"counter",timeout_count:"counter",message_count:"counter",clients:"gauge","*_bytes":"gauge","gc_pause_*":"gauge",gc_runs:"counter",heap_objects:"gauge",e2e_processing_latency:"gauge"}[n]}function i(n,e){if("topic"===n||"channel"===n){if("depth"===e||"deferred_count"===e)return"red"}else{if("node"===n)return"red,green,blue,purple";if("counter"===n)return"green"}return"blue"}function p(n){return n.replaceAll(" ","_").replaceAll("/","-").replaceAll(/[^a-zA-Z0-9-_.]/g,"")}function s(n,e,t,l,a){var o,r,i,s=[],c=(o=e||"*",r=m.get("STATSD_PREFIX"),o=o.replace(/[\.:]/g,"_"),"."!==(r=r.replace(/%s/g,o)).substring(r.length,1)&&(r+="."),r);return"topic"===n?(i=u(h(a),c+"topic."+p(t)+"."+a),s.push("sumSeries("+i+")")):"channel"===n?(i=u(h(a),c+"topic."+p(t)+".channel."+p(l)+"."+a),s.push("sumSeries("+i+")")):"node"===n?(l=c+"mem."+a,s.push(u(h(a),l="gc_runs"===a?"movingAverage("+l+",45)":l))):"e2e"===n?s=d.map(t.percentiles,function(n){n=""!==t.channel?c+"topic."+t.topic+".channel."+t.channel+"."+a+"_"+100*n.quantile:c+"topic."+t.topic+"."+a+"_"+100*n.quantile;return"scale("+u(h(a),n="*"===e?"averageSeries("+n+")":n)+",0.000001)"}):"counter"===n&&(i=u(h(a),c+"topic.*.channel.*."+a),s.push("sumSeries("+i+")")),s}var c=n("jquery"),d=n("underscore"),l=n("hbsfy/runtime"),m=n("../app_state");function a(n,e){e=Math.pow(10,e);return Math.round(n*e)/e}l.registerHelper("default",function(n,e){return n||e}),l.registerHelper("ifeq",function(n,e,t){return n===e?t.fn(this):t.inverse(this)}),l.registerHelper("unlesseq",function(n,e,t){return n!==e?t.fn(this):t.inverse(this)}),l.registerHelper("ifgteq",function(n,e,t){return e<=n?t.fn(this):t.inverse(this)}),l.registerHelper("iflteq",function(n,e,t){return n<=e?t.fn(this):t.inverse(this)}),l.registerHelper("length",function(n){return n.length}),l.registerHelper("lowercase",function(n){return n.toLowerCase()}),l.registerHelper("uppercase",function(n){return n.toUpperCase()}),l.registerHelper("for",function(n,e,t,l){for(var a="",o=n;o<=e;o+=t)a+=l.fn(o);return a}),l.registerHelper("and",function(){return d.all(d.initial(arguments))}),l.registerHelper("or",function(){return d.any(d.initial(arguments))}),l.registerHelper("eq",function(n,e){return n===e}),l.registerHelper("neq",function(n,e){return n!==e}),l.registerHelper("urlencode",function(n){return encodeURIComponent(n)}),l.registerHelper("floatToPercent",function(n){return Math.floor(100*n)}),l.registerHelper("percSuffix",function(n){n=Math.floor(100*n)%10;return 1==n?"st":2==n?"nd":3==n?"rd":"th"}),l.registerHelper("commafy",function(n){return(n=n||0).toString().replace(/\B(?=(\d{3})+(?!\d))/g,",")}),l.registerHelper("nanotohuman",function(n){var e,t="";return 36e11<=n&&(e=Math.floor(n/36e11),n%=36e11,t=e+"h"),6e10<=n&&(e=Math.floor(n/6e10),n%=6e10,t+=e+"m"),1e9<=n?t+=(n=a(n/1e9,2))+"s":1e6<=n?t+=(n=a(n/1e6,2))+"ms":1e3<=n?t+=(n=a(n/1e3,2))+"us":t=n+"ns",t}),l.registerHelper("sparkline",function(n,e,t,l,a){var o={colorList:i(n,a),height:"20",width:"120",hideGrid:"true",hideLegend:"true",hideAxes:"true",bgcolor:"ff000000",fgcolor:"black",margin:"0",yMin:"0",lineMode:"connected",drawNullAsZero:"false",from:"-"+m.get("graph_interval"),until:"-1min"},r=m.get("STATSD_INTERVAL")+"sec";return o.target=d.map(s(n,e,t,l,a),function(n){return"summarize("+n+',"'+r+'","avg")'}),m.get("GRAPHITE_URL")+"/render?"+c.param(o)}),l.registerHelper("large_graph",function(n,e,t,l,a){var o={colorList:i(n,a),height:"450",width:"800",bgcolor:"ff000000",fgcolor:"999999",yMin:"0",lineMode:"connected",drawNullAsZero:"false",from:"-"+m.get("graph_interval"),until:"-1min"},r=m.get("STATSD_INTERVAL")+"sec";return o.target=d.map(s(n,e,t,l,a),function(n){return"summarize("+(n="counter"===h(a)?"scale("+n+","+1/m.get("STATSD_INTERVAL")+")":n)+',"'+r+'","avg")'}),m.get("GRAPHITE_URL")+"/render?"+c.param(o)}),l.registerHelper("rate",function(n,e,t,l){return s(n,e,t,l,"message_count")[0]}),l.registerPartial("error",n("../views/error.hbs")),l.registerPartial("warning",n("../views/warning.hbs")),l.registerHelper("basePath",function(n){return m.basePath(n)})},{"../app_state":36,"../views/error.hbs":53,"../views/warning.hbs":67,"hbsfy/runtime":35,jquery:void 0,underscore:void 0}],41:[function(n,e,t){var l=n("underscore"),n=n("backbone"),n=l.clone(n.Events);window.Pubsub=n,e.exports=n},{backbone:void 0,underscore:void 0}],42:[function(n,e,t){var l=n("jquery"),a=n("backbone"),o=n("./router"),r=n("./views/app");a.$=l,n("./lib/ajax_setup"),n("./lib/handlebars_helpers");new r,o.start()},{"./lib/ajax_setup":39,"./lib/handlebars_helpers":40,"./router":46,"./views/app":47,backbone:void 0,jquery:void 0}],43:[function(n,e,t){var l=n("underscore"),a=n("../app_state"),o=n("backbone"),n=o.Model.extend({idAttribute:"name",constructor:function(){o.Model.prototype.constructor.apply(this,arguments)},url:function(){return a.apiPath("/topics/"+encodeURIComponent(this.get("topic"))+"/"+encodeURIComponent(this.get("name")))},parse:function(n){return n.nodes=l.map(n.nodes||[],function(n){var e=n.node.split(":"),t=e.pop(),l=e.join(":"),e=n.hostname;return n.show_broadcast_address=e.toLowerCase()!==l.toLowerCase(),n.hostname_port=e+":"+t,n}),n.clients=l.map(n.clients||[],function(n){var e=n.client_id,t=n.hostname,l=t.split(".")[0];n.show_client_id=e.toLowerCase()!==l.toLowerCase()&&e.toLowerCase()!==t.toLowerCase();e=n.remote_address.split(":").pop();return n.hostname_port=t+":"+e,n}),n}});e.exports=n},{"../app_state":36,backbone:void 0,underscore:void 0}],44:[function(n,e,t){var l=n("../app_state"),a=n("backbone"),n=a.Model.extend({idAttribute:"name",constructor:function(){a.Model.prototype.constructor.apply(this,arguments)},urlRoot:function(){return l.apiPath("/nodes")},tombstoneTopic:function(n){return this.destroy({data:JSON.stringify({topic:n}),dataType:"text"})}});e.exports=n},{"../app_state":36,backbone:void 0}],45:[function(n,e,t){var l=n("underscore"),a=n("../app_state"),o=n("backbone"),n=o.Model.extend({idAttribute:"name",constructor:function(){o.Model.prototype.constructor.apply(this,arguments)},url:function(){return a.apiPath("/topics/"+encodeURIComponent(this.get("name")))},parse:function(n){return n.nodes=l.map(n.nodes||[],function(n){var e=n.node.split(":"),t=e.pop(),l=e.join(":"),e=n.hostname;return n.show_broadcast_address=e.toLowerCase()!==l.toLowerCase(),n.hostname_port=e+":"+t,n}),n}});e.exports=n},{"../app_state":36,backbone:void 0,underscore:void 0}],46:[function(n,e,t){var l=n("backbone"),a=n("./app_state"),o=n("./lib/pubsub"),n=l.Router.extend({initialize:function(){function n(n){return a.basePath(n).substring(1)}this.route(n("/"),"topics"),this.route(n("/topics/(:topic)(/:channel)"),"topic"),this.route(n("/lookup"),"lookup"),this.route(n("/nodes(/:node)"),"nodes"),this.route(n("/counter"),"counter")},start:function(){l.history.start({pushState:!0})},topics:function(){o.trigger("topics:show")},topic:function(n,e){null===e?o.trigger("topic:show",n):o.trigger("channel:show",n,e)},lookup:function(){o.trigger("lookup:show")},nodes:function(n){null===n?o.trigger("nodes:show"):o.trigger("node:show",n)},counter:function(){o.trigger("counter:show")}});e.exports=new n},{"./app_state":36,"./lib/pubsub":41,backbone:void 0}],47:[function(n,e,t){var a=n("jquery");window.jQuery=a;n("bootstrap");var l=n("bootbox"),o=n("../app_state"),r=n("../lib/pubsub"),i=n("../router"),s=n("./base"),c=n("./header"),u=n("./topics"),h=n("./topic"),p=n("./channel"),d=n("./lookup"),m=n("./nodes"),f=n("./node"),g=n("./counter"),b=n("../models/node"),y=n("../models/topic"),v=n("../models/channel"),n=s.extend({el:"#container",events:{"click .link":"onLinkClick","click .tombstone-link":"onTombstoneClick"},initialize:function(){s.prototype.initialize.apply(this,arguments),this.listenTo(r,"topics:show",this.showTopics),this.listenTo(r,"topic:show",this.showTopic),this.listenTo(r,"channel:show",this.showChannel),this.listenTo(r,"lookup:show",this.showLookup),this.listenTo(r,"nodes:show",this.showNodes),this.listenTo(r,"node:show",this.showNode),this.listenTo(r,"counter:show",this.showCounter),this.listenTo(r,"view:ready",function(){a(".rate").each(function(n,e){var t=a(e),l=o.get("STATSD_INTERVAL"),e={target:t.attr("target"),from:"-"+2*l+"sec",until:"-"+l+"sec",format:"json"};a.ajax({url:o.get("GRAPHITE_URL")+"/render",data:e,dataType:"jsonp",jsonp:"jsonp"}).done(function(n){t.html(null===(n=n)[0]||null===n[0].datapoints[0]||n[0].datapoints[0][0]<0?"N/A":(n[0].datapoints[0][0]/l).toFixed(2))}).fail(function(){t.html("ERROR")})})}),this.render()},postRender:function(){this.appendSubview(new c)},showView:function(n){window.scrollTo(0,0),this.currentView&&this.currentView.remove(),this.currentView=n(),this.appendSubview(this.currentView)},showTopics:function(){this.showView(function(){return new u})},showTopic:function(e){this.showView(function(){var n=new y({name:e,isAdmin:o.get("IS_ADMIN")});return new h({model:n})})},showChannel:function(e,t){this.showView(function(){var n=new v({topic:e,name:t,isAdmin:o.get("IS_ADMIN")});return new p({model:n})})},showLookup:function(){this.showView(function(){return new d({isAdmin:o.get("IS_ADMIN")})})},showNodes:function(){this.showView(function(){return new m})},showNode:function(e){this.showView(function(){var n=new b({name:e});return new f({model:n})})},showCounter:function(){this.showView(function(){return new g})},onLinkClick:function(n){n.ctrlKey||n.metaKey||(n.preventDefault(),n.stopPropagation(),i.navigate(a(n.currentTarget).attr("href"),{trigger:!0}))},onTombstoneClick:function(n){n.preventDefault(),n.stopPropagation();var e=a(n.target).data("node"),t=a(n.target).data("topic");l.confirm("Are you sure you want to <strong>tombstone</strong> <em>"+e+"</em>?",function(n){!0===n&&new b({name:e}).tombstoneTopic(t).done(function(){window.location.reload(!0)}).fail(this.handleAJAXError.bind(this))}.bind(this))}});e.exports=n},{"../app_state":36,"../lib/pubsub":41,"../models/channel":43,"../models/node":44,"../models/topic":45,"../router":46,"./base":48,"./channel":50,"./counter":52,"./header":55,"./lookup":57,"./node":59,"./nodes":61,"./topic":64,"./topics":66,bootbox:void 0,bootstrap:1,jquery:void 0}],48:[function(n,e,t){var l=n("jquery"),a=n("underscore"),o=n("backbone"),r=n("../app_state"),i=n("./error.hbs"),n=o.View.extend({constructor:function(n){return this.options=n||{},o.View.prototype.constructor.apply(this,arguments)},initialize:function(){this.subviews=[],this.rendered=!1},template:function(){},skippedRender:function(){},render:function(n){if(this.renderOnce&&this.rendered)return this.skippedRender(),this;this.removeSubviews();var e=this.getRenderCtx(n),n=this.template(e);return this.removed||(this.$el.empty(),this.$el.append(n),this.postRender(e)),this.rendered=!0,this},getRenderCtx:function(n){var e={graph_enabled:r.get("GRAPH_ENABLED"),graph_interval:r.get("graph_interval"),graph_active:r.get("GRAPH_ENABLED")&&"off"!==r.get("graph_interval"),nsqlookupd:r.get("NSQLOOKUPD"),version:r.get("VERSION")};return this.model?e=a.extend(e,this.model.toJSON()):this.collection&&(e=a.extend(e,{collection:this.collection.toJSON()})),e=n?a.extend(e,n):e},postRender:function(){},appendSubview:function(n,e){return this.appendSubviews([n],e)},appendSubviews:function(n,e){this.subviews.push.apply(this.subviews,n),(e?this.$(e):this.$el).append(n.map(function(n){return n.render().delegateEvents().el}))},removeSubviews:function(){for(;this.subviews.length;)this.subviews.pop().remove()},remove:function(){this.removed=!0,this.removeSubviews(),o.View.prototype.remove.apply(this,arguments)},parseErrorMessage:function(n){var e="ERROR: failed to connect to nsqadmin";if(4===n.readyState)try{e=JSON.parse(n.responseText).message}catch(n){e="ERROR: failed to decode JSON - "+n.message}return e},handleAJAXError:function(n){l("#warning, #error").hide(),l("#error .alert").text(this.parseErrorMessage(n)),l("#error").show()},handleViewError:function(n){this.removeSubviews(),this.$el.html(i({message:this.parseErrorMessage(n)}))}});e.exports=n},{"../app_state":36,"./error.hbs":53,backbone:void 0,jquery:void 0,underscore:void 0}],49:[function(n,e,t){n=n("hbsfy/runtime");e.exports=n.template({1:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'<div class="row">\n    <div class="col-md-6">\n        <div class="alert alert-warning">\n            <h4>Notice</h4> No producers exist for this topic/channel.\n            <p>See <a class="link" href="'+n.escapeExpression((o(t,"basePath")||e&&o(e,"basePath")||n.hooks.helperMissing).call(null!=e?e:n.nullContext||{},"/lookup",{name:"basePath",hash:{},data:a,loc:{start:{line:24,column:41},end:{line:24,column:63}}}))+'">Lookup</a> for more information.\n        </div>\n    </div>\n</div>\n'},3:function(n,e,t,l,a,o,r){var i,s=null!=e?e:n.nullContext||{},c=n.hooks.helperMissing,u=n.escapeExpression,h=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return(null!=(i=h(t,"if").call(s,null!=e?h(e,"isAdmin"):e,{name:"if",hash:{},fn:n.program(4,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:29,column:0},end:{line:45,column:7}}}))?i:"")+'\n<div class="row">\n    <div class="col-md-12">\n    <h4>Channel</h4>\n    <table class="table table-bordered table-condensed">\n        <tr>\n            <th>&nbsp;</th>\n            <th colspan="4" class="text-center">Message Queues</th>\n            <th colspan="'+(null!=(i=h(t,"if").call(s,null!=e?h(e,"graph_active"):e,{name:"if",hash:{},fn:n.program(9,a,0,o,r),inverse:n.program(11,a,0,o,r),data:a,loc:{start:{line:54,column:25},end:{line:54,column:62}}}))?i:"")+'" class="text-center">Statistics</th>\n'+(null!=(i=h(t,"if").call(s,null!=(i=null!=(i=null!=e?h(e,"e2e_processing_latency"):e)?h(i,"percentiles"):i)?h(i,"length"):i,{name:"if",hash:{},fn:n.program(13,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:55,column:12},end:{line:57,column:19}}}))?i:"")+"        </tr>\n        <tr>\n            <th>NSQd Host</th>\n            <th>Depth</th>\n            <th>Memory + Disk</th>\n            <th>In-Flight</th>\n            <th>Deferred</th>\n            <th>Requeued</th>\n            <th>Timed Out</th>\n            <th>Messages</th>\n            "+(null!=(i=h(t,"if").call(s,null!=e?h(e,"graph_active"):e,{name:"if",hash:{},fn:n.program(15,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:68,column:12},end:{line:68,column:52}}}))?i:"")+"\n            <th>Connections</th>\n"+(null!=(i=h(t,"each").call(s,null!=(i=null!=e?h(e,"e2e_processing_latency"):e)?h(i,"percentiles"):i,{name:"each",hash:{},fn:n.program(17,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:70,column:12},end:{line:72,column:21}}}))?i:"")+"        </tr>\n"+(null!=(i=h(t,"each").call(s,null!=e?h(e,"nodes"):e,{name:"each",hash:{},fn:n.program(19,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:74,column:8},end:{line:122,column:17}}}))?i:"")+'        <tr class="info">\n            <td>Total:</td>\n            <td>'+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"depth"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:125,column:16},end:{line:125,column:33}}}))+"</td>\n            <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"memory_depth"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:126,column:16},end:{line:126,column:40}}}))+" + "+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"backend_depth"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:126,column:43},end:{line:126,column:68}}}))+"</td>\n            <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"in_flight_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:127,column:16},end:{line:127,column:43}}}))+"</td>\n            <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"deferred_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:128,column:16},end:{line:128,column:42}}}))+"</td>\n            <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"requeue_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:129,column:16},end:{line:129,column:41}}}))+"</td>\n            <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"timeout_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:130,column:16},end:{line:130,column:41}}}))+"</td>\n            <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"message_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:131,column:16},end:{line:131,column:41}}}))+"</td>\n"+(null!=(i=h(t,"if").call(s,null!=e?h(e,"graph_active"):e,{name:"if",hash:{},fn:n.program(26,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:132,column:12},end:{line:134,column:19}}}))?i:"")+"            <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"client_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:135,column:16},end:{line:135,column:40}}}))+"</td>\n"+(null!=(i=h(t,"if").call(s,null!=(i=null!=(i=null!=e?h(e,"e2e_processing_latency"):e)?h(i,"percentiles"):i)?h(i,"length"):i,{name:"if",hash:{},fn:n.program(28,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:136,column:12},end:{line:142,column:19}}}))?i:"")+"        </tr>\n"+(null!=(i=h(t,"if").call(s,null!=e?h(e,"graph_active"):e,{name:"if",hash:{},fn:n.program(34,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:144,column:8},end:{line:162,column:15}}}))?i:"")+"    </table>\n    </div>\n</div>\n"},4:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'<div class="row channel-actions">\n    <div class="col-md-2">\n        <button class="btn btn-medium btn-warning" data-action="empty">Empty Queue</button>\n    </div>\n    <div class="col-md-2">\n        <button class="btn btn-medium btn-danger" data-action="delete">Delete Channel</button>\n    </div>\n    <div class="col-md-2">\n'+(null!=(a=o(t,"if").call(null!=e?e:n.nullContext||{},null!=e?o(e,"paused"):e,{name:"if",hash:{},fn:n.program(5,a,0),inverse:n.program(7,a,0),data:a,loc:{start:{line:38,column:8},end:{line:42,column:15}}}))?a:"")+"    </div>\n</div>\n"},5:function(n,e,t,l,a){return'        <button class="btn btn-medium btn-success" data-action="unpause">UnPause Channel</button>\n'},7:function(n,e,t,l,a){return'        <button class="btn btn-medium btn-primary" data-action="pause">Pause Channel</button>\n'},9:function(n,e,t,l,a){return"5"},11:function(n,e,t,l,a){return"4"},13:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'            <th colspan="'+n.escapeExpression(n.lambda(null!=(n=null!=(n=null!=e?o(e,"e2e_processing_latency"):e)?o(n,"percentiles"):n)?o(n,"length"):n,e))+'">E2E Processing Latency</th>\n'},15:function(n,e,t,l,a){return"<th>Rate</th>"},17:function(n,e,t,l,a){var o=null!=e?e:n.nullContext||{},r=n.hooks.helperMissing,i=n.escapeExpression,n=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return"                <th>"+i((n(t,"floatToPercent")||e&&n(e,"floatToPercent")||r).call(o,null!=e?n(e,"quantile"):e,{name:"floatToPercent",hash:{},data:a,loc:{start:{line:71,column:20},end:{line:71,column:47}}}))+"<sup>"+i((n(t,"percSuffix")||e&&n(e,"percSuffix")||r).call(o,null!=e?n(e,"quantile"):e,{name:"percSuffix",hash:{},data:a,loc:{start:{line:71,column:52},end:{line:71,column:75}}}))+"</sup></th>\n"},19:function(n,e,t,l,a,o,r){var i,s=null!=e?e:n.nullContext||{},c=n.hooks.helperMissing,u=n.escapeExpression,h=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return"        <tr>\n            <td>\n"+(null!=(i=h(t,"if").call(s,null!=e?h(e,"show_broadcast_address"):e,{name:"if",hash:{},fn:n.program(20,a,0,o,r),inverse:n.program(22,a,0,o,r),data:a,loc:{start:{line:77,column:16},end:{line:81,column:23}}}))?i:"")+"                "+(null!=(i=h(t,"if").call(s,null!=e?h(e,"paused"):e,{name:"if",hash:{},fn:n.program(24,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:82,column:16},end:{line:82,column:85}}}))?i:"")+"\n            </td>\n            <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"depth"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:84,column:16},end:{line:84,column:33}}}))+"</td>\n            <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"memory_depth"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:85,column:16},end:{line:85,column:40}}}))+" + "+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"backend_depth"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:85,column:43},end:{line:85,column:68}}}))+"</td>\n            <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"in_flight_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:86,column:16},end:{line:86,column:43}}}))+"</td>\n            <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"deferred_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:87,column:16},end:{line:87,column:42}}}))+"</td>\n            <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"requeue_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:88,column:16},end:{line:88,column:41}}}))+"</td>\n            <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"timeout_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:89,column:16},end:{line:89,column:41}}}))+"</td>\n            <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"message_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:90,column:16},end:{line:90,column:41}}}))+"</td>\n"+(null!=(i=h(t,"if").call(s,null!=r[1]?h(r[1],"graph_active"):r[1],{name:"if",hash:{},fn:n.program(26,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:91,column:12},end:{line:93,column:19}}}))?i:"")+"            <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"client_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:94,column:16},end:{line:94,column:40}}}))+"</td>\n"+(null!=(i=h(t,"if").call(s,null!=(i=null!=(i=null!=e?h(e,"e2e_processing_latency"):e)?h(i,"percentiles"):i)?h(i,"length"):i,{name:"if",hash:{},fn:n.program(28,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:95,column:12},end:{line:101,column:19}}}))?i:"")+"        </tr>\n"+(null!=(i=h(t,"if").call(s,null!=r[1]?h(r[1],"graph_active"):r[1],{name:"if",hash:{},fn:n.program(31,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:103,column:8},end:{line:121,column:15}}}))?i:"")},20:function(n,e,t,l,a){var o=null!=e?e:n.nullContext||{},r=n.hooks.helperMissing,i="function",s=n.escapeExpression,c=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return"                "+s(typeof(n=null!=(n=c(t,"hostname_port")||(null!=e?c(e,"hostname_port"):e))?n:r)==i?n.call(o,{name:"hostname_port",hash:{},data:a,loc:{start:{line:78,column:16},end:{line:78,column:33}}}):n)+' (<a class="link" href="'+s((c(t,"basePath")||e&&c(e,"basePath")||r).call(o,"/nodes",{name:"basePath",hash:{},data:a,loc:{start:{line:78,column:57},end:{line:78,column:78}}}))+"/"+s(typeof(n=null!=(n=c(t,"node")||(null!=e?c(e,"node"):e))?n:r)==i?n.call(o,{name:"node",hash:{},data:a,loc:{start:{line:78,column:79},end:{line:78,column:87}}}):n)+'">'+s(typeof(n=null!=(n=c(t,"node")||(null!=e?c(e,"node"):e))?n:r)==i?n.call(o,{name:"node",hash:{},data:a,loc:{start:{line:78,column:89},end:{line:78,column:97}}}):n)+"</a>)\n"},22:function(n,e,t,l,a){var o=null!=e?e:n.nullContext||{},r=n.hooks.helperMissing,i=n.escapeExpression,s="function",c=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'                <a class="link" href="'+i((c(t,"basePath")||e&&c(e,"basePath")||r).call(o,"/nodes",{name:"basePath",hash:{},data:a,loc:{start:{line:80,column:38},end:{line:80,column:59}}}))+"/"+i(typeof(n=null!=(n=c(t,"node")||(null!=e?c(e,"node"):e))?n:r)==s?n.call(o,{name:"node",hash:{},data:a,loc:{start:{line:80,column:60},end:{line:80,column:68}}}):n)+'">'+i(typeof(n=null!=(n=c(t,"hostname_port")||(null!=e?c(e,"hostname_port"):e))?n:r)==s?n.call(o,{name:"hostname_port",hash:{},data:a,loc:{start:{line:80,column:70},end:{line:80,column:87}}}):n)+"</a>\n"},24:function(n,e,t,l,a){return' <span class="label label-primary">paused</span>'},26:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'                <td class="bold rate" target="'+n.escapeExpression((o(t,"rate")||e&&o(e,"rate")||n.hooks.helperMissing).call(null!=e?e:n.nullContext||{},"topic",null!=e?o(e,"node"):e,null!=e?o(e,"topic_name"):e,"",{name:"rate",hash:{},data:a,loc:{start:{line:92,column:46},end:{line:92,column:81}}}))+'"></td>\n'},28:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return null!=(e=o(t,"each").call(null!=e?e:n.nullContext||{},null!=(e=null!=e?o(e,"e2e_processing_latency"):e)?o(e,"percentiles"):e,{name:"each",hash:{},fn:n.program(29,a,0),inverse:n.noop,data:a,loc:{start:{line:96,column:16},end:{line:100,column:25}}}))?e:""},29:function(n,e,t,l,a){var o=null!=e?e:n.nullContext||{},r=n.hooks.helperMissing,i=n.escapeExpression,n=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'                <td>\n                    <span title="'+i((n(t,"floatToPercent")||e&&n(e,"floatToPercent")||r).call(o,null!=e?n(e,"quantile"):e,{name:"floatToPercent",hash:{},data:a,loc:{start:{line:98,column:33},end:{line:98,column:60}}}))+": min = "+i((n(t,"nanotohuman")||e&&n(e,"nanotohuman")||r).call(o,null!=e?n(e,"min"):e,{name:"nanotohuman",hash:{},data:a,loc:{start:{line:98,column:68},end:{line:98,column:87}}}))+", max = "+i((n(t,"nanotohuman")||e&&n(e,"nanotohuman")||r).call(o,null!=e?n(e,"max"):e,{name:"nanotohuman",hash:{},data:a,loc:{start:{line:98,column:95},end:{line:98,column:114}}}))+'">'+i((n(t,"nanotohuman")||e&&n(e,"nanotohuman")||r).call(o,null!=e?n(e,"average"):e,{name:"nanotohuman",hash:{},data:a,loc:{start:{line:98,column:116},end:{line:98,column:139}}}))+"</span>\n                </td>\n"},31:function(n,e,t,l,a){var o=null!=e?e:n.nullContext||{},r=n.hooks.helperMissing,i=n.escapeExpression,s=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'        <tr class="graph-row">\n            <td></td>\n            <td><a href="'+i((s(t,"large_graph")||e&&s(e,"large_graph")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"depth",{name:"large_graph",hash:{},data:a,loc:{start:{line:106,column:25},end:{line:106,column:87}}}))+'"><img width="120" height="20"  src="'+i((s(t,"sparkline")||e&&s(e,"sparkline")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"depth",{name:"sparkline",hash:{},data:a,loc:{start:{line:106,column:124},end:{line:106,column:184}}}))+'"></a></td>\n            <td></td>\n            <td><a href="'+i((s(t,"large_graph")||e&&s(e,"large_graph")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"in_flight_count",{name:"large_graph",hash:{},data:a,loc:{start:{line:108,column:25},end:{line:108,column:97}}}))+'"><img width="120" height="20"  src="'+i((s(t,"sparkline")||e&&s(e,"sparkline")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"in_flight_count",{name:"sparkline",hash:{},data:a,loc:{start:{line:108,column:134},end:{line:108,column:204}}}))+'"></a></td>\n            <td><a href="'+i((s(t,"large_graph")||e&&s(e,"large_graph")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"deferred_count",{name:"large_graph",hash:{},data:a,loc:{start:{line:109,column:25},end:{line:109,column:96}}}))+'"><img width="120" height="20"  src="'+i((s(t,"sparkline")||e&&s(e,"sparkline")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"deferred_count",{name:"sparkline",hash:{},data:a,loc:{start:{line:109,column:133},end:{line:109,column:202}}}))+'"></a></td>\n            <td><a href="'+i((s(t,"large_graph")||e&&s(e,"large_graph")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"requeue_count",{name:"large_graph",hash:{},data:a,loc:{start:{line:110,column:25},end:{line:110,column:95}}}))+'"><img width="120" height="20"  src="'+i((s(t,"sparkline")||e&&s(e,"sparkline")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"requeue_count",{name:"sparkline",hash:{},data:a,loc:{start:{line:110,column:132},end:{line:110,column:200}}}))+'"></a></td>\n            <td><a href="'+i((s(t,"large_graph")||e&&s(e,"large_graph")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"timeout_count",{name:"large_graph",hash:{},data:a,loc:{start:{line:111,column:25},end:{line:111,column:95}}}))+'"><img width="120" height="20"  src="'+i((s(t,"sparkline")||e&&s(e,"sparkline")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"timeout_count",{name:"sparkline",hash:{},data:a,loc:{start:{line:111,column:132},end:{line:111,column:200}}}))+'"></a></td>\n            <td><a href="'+i((s(t,"large_graph")||e&&s(e,"large_graph")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"message_count",{name:"large_graph",hash:{},data:a,loc:{start:{line:112,column:25},end:{line:112,column:95}}}))+'"><img width="120" height="20"  src="'+i((s(t,"sparkline")||e&&s(e,"sparkline")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"message_count",{name:"sparkline",hash:{},data:a,loc:{start:{line:112,column:132},end:{line:112,column:200}}}))+'"></a></td>\n            <td></td>\n            <td><a href="'+i((s(t,"large_graph")||e&&s(e,"large_graph")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"clients",{name:"large_graph",hash:{},data:a,loc:{start:{line:114,column:25},end:{line:114,column:89}}}))+'"><img width="120" height="20"  src="'+i((s(t,"sparkline")||e&&s(e,"sparkline")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"clients",{name:"sparkline",hash:{},data:a,loc:{start:{line:114,column:126},end:{line:114,column:188}}}))+'"></a></td>\n'+(null!=(e=s(t,"if").call(o,null!=(e=null!=(e=null!=e?s(e,"e2e_processing_latency"):e)?s(e,"percentiles"):e)?s(e,"length"):e,{name:"if",hash:{},fn:n.program(32,a,0),inverse:n.noop,data:a,loc:{start:{line:115,column:12},end:{line:119,column:19}}}))?e:"")+"        </tr>\n"},32:function(n,e,t,l,a){var o=n.escapeExpression,r=null!=e?e:n.nullContext||{},i=n.hooks.helperMissing,s=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'            <td colspan="'+o(n.lambda(null!=(n=null!=(n=null!=e?s(e,"e2e_processing_latency"):e)?s(n,"percentiles"):n)?s(n,"length"):n,e))+'">\n                <a href="'+o((s(t,"large_graph")||e&&s(e,"large_graph")||i).call(r,"e2e",null!=e?s(e,"node"):e,null!=e?s(e,"e2e_processing_latency"):e,"","e2e_processing_latency",{name:"large_graph",hash:{},data:a,loc:{start:{line:117,column:25},end:{line:117,column:102}}}))+'"><img width="120" height="20" src="'+o((s(t,"sparkline")||e&&s(e,"sparkline")||i).call(r,"e2e",null!=e?s(e,"node"):e,null!=e?s(e,"e2e_processing_latency"):e,"","e2e_processing_latency",{name:"sparkline",hash:{},data:a,loc:{start:{line:117,column:138},end:{line:117,column:213}}}))+'"></a>\n            </td>\n'},34:function(n,e,t,l,a){var o=null!=e?e:n.nullContext||{},r=n.hooks.helperMissing,i=n.escapeExpression,s=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'        <tr class="graph-row">\n            <td></td>\n            <td><a href="'+i((s(t,"large_graph")||e&&s(e,"large_graph")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"depth",{name:"large_graph",hash:{},data:a,loc:{start:{line:147,column:25},end:{line:147,column:87}}}))+'"><img width="120" height="20"  src="'+i((s(t,"sparkline")||e&&s(e,"sparkline")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"depth",{name:"sparkline",hash:{},data:a,loc:{start:{line:147,column:124},end:{line:147,column:184}}}))+'"></a></td>\n            <td></td>\n            <td><a href="'+i((s(t,"large_graph")||e&&s(e,"large_graph")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"in_flight_count",{name:"large_graph",hash:{},data:a,loc:{start:{line:149,column:25},end:{line:149,column:97}}}))+'"><img width="120" height="20"  src="'+i((s(t,"sparkline")||e&&s(e,"sparkline")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"in_flight_count",{name:"sparkline",hash:{},data:a,loc:{start:{line:149,column:134},end:{line:149,column:204}}}))+'"></a></td>\n            <td><a href="'+i((s(t,"large_graph")||e&&s(e,"large_graph")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"deferred_count",{name:"large_graph",hash:{},data:a,loc:{start:{line:150,column:25},end:{line:150,column:96}}}))+'"><img width="120" height="20"  src="'+i((s(t,"sparkline")||e&&s(e,"sparkline")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"deferred_count",{name:"sparkline",hash:{},data:a,loc:{start:{line:150,column:133},end:{line:150,column:202}}}))+'"></a></td>\n            <td><a href="'+i((s(t,"large_graph")||e&&s(e,"large_graph")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"requeue_count",{name:"large_graph",hash:{},data:a,loc:{start:{line:151,column:25},end:{line:151,column:95}}}))+'"><img width="120" height="20"  src="'+i((s(t,"sparkline")||e&&s(e,"sparkline")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"requeue_count",{name:"sparkline",hash:{},data:a,loc:{start:{line:151,column:132},end:{line:151,column:200}}}))+'"></a></td>\n            <td><a href="'+i((s(t,"large_graph")||e&&s(e,"large_graph")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"timeout_count",{name:"large_graph",hash:{},data:a,loc:{start:{line:152,column:25},end:{line:152,column:95}}}))+'"><img width="120" height="20"  src="'+i((s(t,"sparkline")||e&&s(e,"sparkline")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"timeout_count",{name:"sparkline",hash:{},data:a,loc:{start:{line:152,column:132},end:{line:152,column:200}}}))+'"></a></td>\n            <td><a href="'+i((s(t,"large_graph")||e&&s(e,"large_graph")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"message_count",{name:"large_graph",hash:{},data:a,loc:{start:{line:153,column:25},end:{line:153,column:95}}}))+'"><img width="120" height="20"  src="'+i((s(t,"sparkline")||e&&s(e,"sparkline")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"message_count",{name:"sparkline",hash:{},data:a,loc:{start:{line:153,column:132},end:{line:153,column:200}}}))+'"></a></td>\n            <td></td>\n            <td><a href="'+i((s(t,"large_graph")||e&&s(e,"large_graph")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"clients",{name:"large_graph",hash:{},data:a,loc:{start:{line:155,column:25},end:{line:155,column:89}}}))+'"><img width="120" height="20"  src="'+i((s(t,"sparkline")||e&&s(e,"sparkline")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"clients",{name:"sparkline",hash:{},data:a,loc:{start:{line:155,column:126},end:{line:155,column:188}}}))+'"></a></td>\n'+(null!=(e=s(t,"if").call(o,null!=(e=null!=(e=null!=e?s(e,"e2e_processing_latency"):e)?s(e,"percentiles"):e)?s(e,"length"):e,{name:"if",hash:{},fn:n.program(35,a,0),inverse:n.noop,data:a,loc:{start:{line:156,column:12},end:{line:160,column:19}}}))?e:"")+"        </tr>\n"},35:function(n,e,t,l,a){var o=n.escapeExpression,r=null!=e?e:n.nullContext||{},i=n.hooks.helperMissing,s=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'            <td colspan="'+o(n.lambda(null!=(n=null!=(n=null!=e?s(e,"e2e_processing_latency"):e)?s(n,"percentiles"):n)?s(n,"length"):n,e))+'">\n                <a href="'+o((s(t,"large_graph")||e&&s(e,"large_graph")||i).call(r,"e2e",null!=e?s(e,"node"):e,null!=e?s(e,"e2e_processing_latency"):e,"","e2e_processing_latency",{name:"large_graph",hash:{},data:a,loc:{start:{line:158,column:25},end:{line:158,column:102}}}))+'"><img width="120" height="20"  src="'+o((s(t,"sparkline")||e&&s(e,"sparkline")||i).call(r,"e2e",null!=e?s(e,"node"):e,null!=e?s(e,"e2e_processing_latency"):e,"","e2e_processing_latency",{name:"sparkline",hash:{},data:a,loc:{start:{line:158,column:139},end:{line:158,column:214}}}))+'"></a>\n            </td>\n'},37:function(n,e,t,l,a){return'            <div class="alert alert-warning"><h4>Notice</h4>No clients connected to this channel</div>\n'},39:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'        <table class="table table-bordered table-condensed">\n            <tr>\n                <th>Client Host</th>\n                <th>User-Agent</th>\n                <th>Attributes</th>\n                <th>NSQd Host</th>\n                <th>In-Flight</th>\n                <th>Ready Count</th>\n                <th>Finished</th>\n                <th>Requeued</th>\n                <th>Messages</th>\n                <th>Connected</th>\n            </tr>\n'+(null!=(a=o(t,"each").call(null!=e?e:n.nullContext||{},null!=e?o(e,"clients"):e,{name:"each",hash:{},fn:n.program(40,a,0),inverse:n.noop,data:a,loc:{start:{line:188,column:12},end:{line:221,column:21}}}))?a:"")+"        </table>\n"},40:function(n,e,t,l,a){var o,r,i=null!=e?e:n.nullContext||{},s=n.hooks.helperMissing,c="function",u=n.escapeExpression,h=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'            <tr>\n                <td title="'+u(typeof(r=null!=(r=h(t,"remote_address")||(null!=e?h(e,"remote_address"):e))?r:s)==c?r.call(i,{name:"remote_address",hash:{},data:a,loc:{start:{line:190,column:27},end:{line:190,column:45}}}):r)+'">'+u(typeof(r=null!=(r=h(t,"hostname_port")||(null!=e?h(e,"hostname_port"):e))?r:s)==c?r.call(i,{name:"hostname_port",hash:{},data:a,loc:{start:{line:190,column:47},end:{line:190,column:64}}}):r)+(null!=(o=h(t,"if").call(i,null!=e?h(e,"show_client_id"):e,{name:"if",hash:{},fn:n.program(41,a,0),inverse:n.noop,data:a,loc:{start:{line:190,column:64},end:{line:190,column:109}}}))?o:"")+"</td>\n                <td>"+(null!=(o=h(t,"if").call(i,null!=(o=null!=e?h(e,"user_agent"):e)?h(o,"length"):o,{name:"if",hash:{},fn:n.program(43,a,0),inverse:n.noop,data:a,loc:{start:{line:191,column:20},end:{line:191,column:81}}}))?o:"")+"</td>\n                <td>\n"+(null!=(o=h(t,"if").call(i,null!=e?h(e,"sample_rate"):e,{name:"if",hash:{},fn:n.program(45,a,0),inverse:n.noop,data:a,loc:{start:{line:193,column:20},end:{line:195,column:27}}}))?o:"")+(null!=(o=h(t,"if").call(i,null!=e?h(e,"tls"):e,{name:"if",hash:{},fn:n.program(47,a,0),inverse:n.noop,data:a,loc:{start:{line:196,column:20},end:{line:198,column:27}}}))?o:"")+(null!=(o=h(t,"if").call(i,null!=e?h(e,"deflate"):e,{name:"if",hash:{},fn:n.program(50,a,0),inverse:n.noop,data:a,loc:{start:{line:199,column:20},end:{line:201,column:27}}}))?o:"")+(null!=(o=h(t,"if").call(i,null!=e?h(e,"snappy"):e,{name:"if",hash:{},fn:n.program(52,a,0),inverse:n.noop,data:a,loc:{start:{line:202,column:20},end:{line:204,column:27}}}))?o:"")+(null!=(o=h(t,"if").call(i,null!=e?h(e,"authed"):e,{name:"if",hash:{},fn:n.program(54,a,0),inverse:n.noop,data:a,loc:{start:{line:205,column:20},end:{line:211,column:27}}}))?o:"")+'                </td>\n                <td><a class="link" href="'+u((h(t,"basePath")||e&&h(e,"basePath")||s).call(i,"/nodes",{name:"basePath",hash:{},data:a,loc:{start:{line:213,column:42},end:{line:213,column:63}}}))+"/"+u(typeof(r=null!=(r=h(t,"node")||(null!=e?h(e,"node"):e))?r:s)==c?r.call(i,{name:"node",hash:{},data:a,loc:{start:{line:213,column:64},end:{line:213,column:72}}}):r)+'">'+u(typeof(r=null!=(r=h(t,"node")||(null!=e?h(e,"node"):e))?r:s)==c?r.call(i,{name:"node",hash:{},data:a,loc:{start:{line:213,column:74},end:{line:213,column:82}}}):r)+"</a></td>\n                <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||s).call(i,null!=e?h(e,"in_flight_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:214,column:20},end:{line:214,column:47}}}))+"</td>\n                <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||s).call(i,null!=e?h(e,"ready_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:215,column:20},end:{line:215,column:43}}}))+"</td>\n                <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||s).call(i,null!=e?h(e,"finish_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:216,column:20},end:{line:216,column:44}}}))+"</td>\n                <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||s).call(i,null!=e?h(e,"requeue_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:217,column:20},end:{line:217,column:45}}}))+"</td>\n                <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||s).call(i,null!=e?h(e,"message_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:218,column:20},end:{line:218,column:45}}}))+"</td>\n                <td>"+u((h(t,"nanotohuman")||e&&h(e,"nanotohuman")||s).call(i,null!=e?h(e,"connected"):e,{name:"nanotohuman",hash:{},data:a,loc:{start:{line:219,column:20},end:{line:219,column:45}}}))+"</td>\n            </tr>\n"},41:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return" ("+n.escapeExpression("function"==typeof(o=null!=(o=o(t,"client_id")||(null!=e?o(e,"client_id"):e))?o:n.hooks.helperMissing)?o.call(null!=e?e:n.nullContext||{},{name:"client_id",hash:{},data:a,loc:{start:{line:190,column:88},end:{line:190,column:101}}}):o)+")"},43:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return"<small>"+n.escapeExpression("function"==typeof(o=null!=(o=o(t,"user_agent")||(null!=e?o(e,"user_agent"):e))?o:n.hooks.helperMissing)?o.call(null!=e?e:n.nullContext||{},{name:"user_agent",hash:{},data:a,loc:{start:{line:191,column:52},end:{line:191,column:66}}}):o)+"</small>"},45:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'                        <span class="label label-info">Sampled '+n.escapeExpression("function"==typeof(o=null!=(o=o(t,"sample_rate")||(null!=e?o(e,"sample_rate"):e))?o:n.hooks.helperMissing)?o.call(null!=e?e:n.nullContext||{},{name:"sample_rate",hash:{},data:a,loc:{start:{line:194,column:63},end:{line:194,column:78}}}):o)+"%</span>\n"},47:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'                        <span class="label label-warning" '+(null!=(a=o(t,"if").call(null!=e?e:n.nullContext||{},null!=e?o(e,"tls_version"):e,{name:"if",hash:{},fn:n.program(48,a,0),inverse:n.noop,data:a,loc:{start:{line:197,column:58},end:{line:197,column:201}}}))?a:"")+">TLS</span>\n"},48:function(n,e,t,l,a){var o=null!=e?e:n.nullContext||{},r=n.hooks.helperMissing,i="function",s=n.escapeExpression,c=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'title="'+s(typeof(n=null!=(n=c(t,"tls_version")||(null!=e?c(e,"tls_version"):e))?n:r)==i?n.call(o,{name:"tls_version",hash:{},data:a,loc:{start:{line:197,column:84},end:{line:197,column:99}}}):n)+" "+s(typeof(n=null!=(n=c(t,"tls_cipher_suite")||(null!=e?c(e,"tls_cipher_suite"):e))?n:r)==i?n.call(o,{name:"tls_cipher_suite",hash:{},data:a,loc:{start:{line:197,column:100},end:{line:197,column:120}}}):n)+" "+s(typeof(n=null!=(n=c(t,"tls_negotiated_protocol")||(null!=e?c(e,"tls_negotiated_protocol"):e))?n:r)==i?n.call(o,{name:"tls_negotiated_protocol",hash:{},data:a,loc:{start:{line:197,column:121},end:{line:197,column:148}}}):n)+" mutual:"+s(typeof(n=null!=(n=c(t,"tls_negotiated_protocol_is_mutual")||(null!=e?c(e,"tls_negotiated_protocol_is_mutual"):e))?n:r)==i?n.call(o,{name:"tls_negotiated_protocol_is_mutual",hash:{},data:a,loc:{start:{line:197,column:156},end:{line:197,column:193}}}):n)+'"'},50:function(n,e,t,l,a){return'                        <span class="label label-default">Deflate</span>\n'},52:function(n,e,t,l,a){return'                        <span class="label label-primary">Snappy</span>\n'},54:function(n,e,t,l,a){var o,r=null!=e?e:n.nullContext||{},i=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'                        <span class="label label-success">\n                        '+(null!=(o=i(t,"if").call(r,null!=e?i(e,"auth_identity_url"):e,{name:"if",hash:{},fn:n.program(55,a,0),inverse:n.noop,data:a,loc:{start:{line:207,column:24},end:{line:207,column:88}}}))?o:"")+'\n                        <span class="glyphicon glyphicon-user white" title="Authed'+(null!=(o=i(t,"if").call(r,null!=e?i(e,"auth_identity"):e,{name:"if",hash:{},fn:n.program(57,a,0),inverse:n.noop,data:a,loc:{start:{line:208,column:82},end:{line:208,column:137}}}))?o:"")+'"></span>\n                        '+(null!=(o=i(t,"if").call(r,null!=e?i(e,"auth_identity_url"):e,{name:"if",hash:{},fn:n.program(59,a,0),inverse:n.noop,data:a,loc:{start:{line:209,column:24},end:{line:209,column:60}}}))?o:"")+"\n                        </span>\n"},55:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'<a href="'+n.escapeExpression("function"==typeof(o=null!=(o=o(t,"auth_identity_url")||(null!=e?o(e,"auth_identity_url"):e))?o:n.hooks.helperMissing)?o.call(null!=e?e:n.nullContext||{},{name:"auth_identity_url",hash:{},data:a,loc:{start:{line:207,column:58},end:{line:207,column:79}}}):o)+'">'},57:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return" Identity:"+n.escapeExpression("function"==typeof(o=null!=(o=o(t,"auth_identity")||(null!=e?o(e,"auth_identity"):e))?o:n.hooks.helperMissing)?o.call(null!=e?e:n.nullContext||{},{name:"auth_identity",hash:{},data:a,loc:{start:{line:208,column:113},end:{line:208,column:130}}}):o)},59:function(n,e,t,l,a){return"</a>"},compiler:[8,">= 4.3.0"],main:function(n,e,t,l,a,o,r){var i,s=null!=e?e:n.nullContext||{},c=n.hooks.helperMissing,u=n.escapeExpression,h="function",p=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return(null!=(i=n.invokePartial(p(l,"warning"),e,{name:"warning",data:a,helpers:t,partials:l,decorators:n.decorators}))?i:"")+(null!=(i=n.invokePartial(p(l,"error"),e,{name:"error",data:a,helpers:t,partials:l,decorators:n.decorators}))?i:"")+'\n<ol class="breadcrumb">\n  <li><a class="link" href="'+u((p(t,"basePath")||e&&p(e,"basePath")||c).call(s,"/",{name:"basePath",hash:{},data:a,loc:{start:{line:5,column:28},end:{line:5,column:44}}}))+'">Streams</a>\n  <li><a class="link" href="'+u((p(t,"basePath")||e&&p(e,"basePath")||c).call(s,"/topics",{name:"basePath",hash:{},data:a,loc:{start:{line:6,column:28},end:{line:6,column:50}}}))+"/"+u((p(t,"urlencode")||e&&p(e,"urlencode")||c).call(s,null!=e?p(e,"topic"):e,{name:"urlencode",hash:{},data:a,loc:{start:{line:6,column:51},end:{line:6,column:70}}}))+'">'+u(typeof(l=null!=(l=p(t,"topic")||(null!=e?p(e,"topic"):e))?l:c)==h?l.call(s,{name:"topic",hash:{},data:a,loc:{start:{line:6,column:72},end:{line:6,column:81}}}):l)+'</a>\n  <li class="active">'+u(typeof(l=null!=(l=p(t,"name")||(null!=e?p(e,"name"):e))?l:c)==h?l.call(s,{name:"name",hash:{},data:a,loc:{start:{line:7,column:21},end:{line:7,column:29}}}):l)+'</li>\n</ol>\n\n<div class="row">\n    <div class="col-md-6">\n        <blockquote>\n            <p>Topic: <strong>'+u(typeof(l=null!=(l=p(t,"topic")||(null!=e?p(e,"topic"):e))?l:c)==h?l.call(s,{name:"topic",hash:{},data:a,loc:{start:{line:13,column:30},end:{line:13,column:39}}}):l)+"</strong>\n            <p>Channel: <strong>"+u(typeof(l=null!=(l=p(t,"name")||(null!=e?p(e,"name"):e))?l:c)==h?l.call(s,{name:"name",hash:{},data:a,loc:{start:{line:14,column:32},end:{line:14,column:40}}}):l)+"</strong>\n        </blockquote>\n    </div>\n</div>\n\n"+(null!=(i=p(t,"unless").call(s,null!=(i=null!=e?p(e,"nodes"):e)?p(i,"length"):i,{name:"unless",hash:{},fn:n.program(1,a,0,o,r),inverse:n.program(3,a,0,o,r),data:a,loc:{start:{line:19,column:0},end:{line:166,column:11}}}))?i:"")+'\n<h4>Client Connections</h4>\n\n<div class="row">\n    <div class="col-md-12">\n'+(null!=(i=p(t,"unless").call(s,null!=(i=null!=e?p(e,"clients"):e)?p(i,"length"):i,{name:"unless",hash:{},fn:n.program(37,a,0,o,r),inverse:n.program(39,a,0,o,r),data:a,loc:{start:{line:172,column:8},end:{line:223,column:19}}}))?i:"")+"    </div>\n</div>\n"},usePartial:!0,useData:!0,useDepths:!0})},{"hbsfy/runtime":35}],50:[function(t,n,e){var l=t("jquery");window.jQuery=l;t("bootstrap");var a=t("bootbox"),o=t("../lib/pubsub"),r=t("../app_state"),i=t("./base"),s=i.extend({className:"channel container-fluid",template:t("./spinner.hbs"),events:{"click .channel-actions button":"channelAction"},initialize:function(){i.prototype.initialize.apply(this,arguments),this.listenTo(r,"change:graph_interval",this.render);var e=this.model.get("isAdmin");this.model.fetch().done(function(n){this.template=t("./channel.hbs"),this.render({message:n.message,isAdmin:e})}.bind(this)).fail(this.handleViewError.bind(this)).always(o.trigger.bind(o,"view:ready"))},channelAction:function(n){n.preventDefault(),n.stopPropagation();var t=l(n.currentTarget).data("action"),n="Are you sure you want to <strong>"+t+"</strong> <em>"+this.model.get("topic")+"/"+this.model.get("name")+"</em>?";a.confirm(n,function(n){var e;!0===n&&("delete"===t?(e=this.model.get("topic"),l.ajax(this.model.url(),{method:"DELETE"}).done(function(){window.location=r.basePath("/topics/"+encodeURIComponent(e))}).fail(this.handleAJAXError.bind(this))):l.post(this.model.url(),JSON.stringify({action:t})).done(function(){window.location.reload(!0)}).fail(this.handleAJAXError.bind(this)))}.bind(this))}});n.exports=s},{"../app_state":36,"../lib/pubsub":41,"./base":48,"./channel.hbs":49,"./spinner.hbs":62,bootbox:void 0,bootstrap:1,jquery:void 0}],51:[function(n,e,t){n=n("hbsfy/runtime");e.exports=n.template({1:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'    <img id="big_graph" height="500" src="'+n.escapeExpression((o(t,"large_graph")||e&&o(e,"large_graph")||n.hooks.helperMissing).call(null!=e?e:n.nullContext||{},"counter","*","","","message_count",{name:"large_graph",hash:{},data:a,loc:{start:{line:206,column:42},end:{line:206,column:93}}}))+'"/>\n'},compiler:[8,">= 4.3.0"],main:function(n,e,t,l,a){var o,r=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return"<style>\nbody {\n    background-color:#000;\n}\n/*\n * .numbers : The container for .number\n */\n\n#numbers {\n    text-align:center;\n    margin-top:100px;\n}\n\n.numbers {\n  font-family: 'Francois One', sans-serif;\n  font-size: 70px;\n  color: white;\n  white-space: nowrap;\n  position: relative;\n  direction: ltr;\n  vertical-align: middle;\n  height: 70px;\n}\n\n\n/*\n * .number : The container for each number\n */\n\n.number {\n  width: 50px;\n  height: 70px;\n  position: relative;\n  display: inline-block;\n  margin: 2px;\n  border-radius: 5px;\n  box-shadow: #999 0 -1px 0px 0px, #444 0 1px 0px 0px;\n}\n\n\n/*\n * Little white stuffs that link the top and the bottom\n */\n\n.number:before {\n  content: '';\n  display: block;\n  width: 3px;\n  height: 6px;\n  background: white;\n  position: absolute;\n  left: 0;\n  top: 30px;\n  z-index: 2;\n  box-shadow: inset rgb(130, 130, 130) 0 0 0px 1px;\n  border-right: 1px solid black;\n  border-top: 1px solid black;\n  border-bottom: 1px solid black;\n}\n\n.number:after {\n  content: '';\n  display: block;\n  width: 3px;\n  height: 6px;\n  background: rgb(200, 200, 200);\n  position: absolute;\n  right: 0;\n  top: 30px;\n  z-index: 2;\n  box-shadow: inset rgb(130, 130, 130) 0 0 0px 1px;\n  border-left: 1px solid black;\n  border-top: 1px solid black;\n  border-bottom: 1px solid black;  \n}\n\n/*\n * The panels\n */\n\n.number .top, .number .bottom {\n  display: block;\n  height: 35px;\n  width: 50px;\n  text-align: center;\n  overflow: hidden;\n  border-radius: 3px;\n  background: -webkit-linear-gradient(90deg, rgb(30, 30, 30), rgb(90, 90, 90));\n  background: -o-linear-gradient(90deg, rgb(30, 30, 30), rgb(90, 90, 90));\n  background: linear-gradient(0deg, rgb(30, 30, 30), rgb(90, 90, 90));\n  background-size: 50px 70px;\n}\n\n.number .top {\n  -moz-box-sizing: border-box;\n  -webkit-box-sizing: border-box;\n  -o-box-sizing: border-box;\n  box-sizing: border-box;\n  line-height: 70px;\n  border-top-left-radius: 5px; \n  border-top-right-radius: 5px;\n  background-position: 0px 0px;\n  border-bottom: 1px solid black;\n}\n\n.number .bottom {\n  line-height: 0px;\n  border-bottom-left-radius: 5px; \n  border-bottom-right-radius: 5px;\n  background-position: 0px 35px; \n}\n\n/*\n * Panel animations\n */\n\n/* The new top panel */\n.number .top:nth-last-of-type(4) {\n  position: absolute;\n  z-index: 0;\n}\n\n/* The old top panel */\n.number .top:nth-last-of-type(3) {\n  animation-duration: 0.2s;\n  animation-name: top;\n  animation-fill-mode: forwards;\n  animation-timing-function: ease-in;  \n  z-index: 1;\n\n  -moz-transform-origin: 0 100%;\n  -webkit-transform-origin: 0 100%;\n  -o-transform-origin: 0 100%;\n  transform-origin: 0 100%;\n}\n\n@keyframes top {\n  from {\n    -moz-transform: scaleY(1);\n    -webkit-transform: scaleY(1);\n    -o-transform: scaleY(1);\n    transform: scaleY(1);\n  }\n \n  to {\n    -moz-transform: scaleY(0);\n    -webkit-transform: scaleY(0);\n    -o-transform: scaleY(0);\n    transform: scaleY(0);\n  }\n}\n\n/* The new bottom panel */\n.number .bottom:nth-last-of-type(2) {\n  position: absolute;\n  z-index: 1;\n\n  animation-duration: 0.2s;\n  animation-name: bottom;\n  animation-delay: 0.2s;\n  animation-fill-mode: forwards;\n  animation-timing-function: ease-out;\n\n  -moz-transform: scaleY(0);\n  -moz-transform-origin: 0 0;\n}\n\n@keyframes bottom {\n  from {\n    -moz-transform: scaleY(0);\n  }\n \n  to {\n    -moz-transform: scaleY(1);\n  }\n}\n\n/* The old bottom panel */\n.number .top:nth-last-of-type(1) {\n  z-index: -1;\n}\n\n.processed {\n    font-color:#bbb;\n    margin:50px;\n    font-size:24pt;\n    font-family:Helvetica;\n}\n.messagerate {\n    font-color:#bbb;\n    margin:25px;\n    font-size:20pt;\n    font-family:Helvetica;\n}\n</style>\n\n"+(null!=(o=n.invokePartial(r(l,"warning"),e,{name:"warning",data:a,helpers:t,partials:l,decorators:n.decorators}))?o:"")+(null!=(o=n.invokePartial(r(l,"error"),e,{name:"error",data:a,helpers:t,partials:l,decorators:n.decorators}))?o:"")+'\n<div id="numbers">\n<div class="numbers">\n</div>\n<p class="processed">Messages Processed</p>\n<p class="messagerate"></p>\n'+(null!=(o=r(t,"if").call(null!=e?e:n.nullContext||{},null!=e?r(e,"graph_active"):e,{name:"if",hash:{},fn:n.program(1,a,0),inverse:n.noop,data:a,loc:{start:{line:205,column:0},end:{line:207,column:7}}}))?o:"")+"</div>\n"},usePartial:!0,useData:!0})},{"hbsfy/runtime":35}],52:[function(n,e,t){var l=n("underscore"),i=n("jquery"),a=n("../app_state"),o=n("./base"),n=o.extend({className:"counter container-fluid",template:n("./counter.hbs"),initialize:function(){o.prototype.initialize.apply(this,arguments),this.listenTo(a,"change:graph_interval",this.render),this.start()},remove:function(){clearTimeout(this.poller),clearTimeout(this.animator),o.prototype.remove.apply(this,arguments)},start:function(){this.poller=null,this.animator=null,this.delta=0,this.looping=!1,this.targetPollInterval=1e4,this.currentNum=-1,this.interval=100,this.graphUrl=null,this.updateStats()},startLoop:function(n){this.interval=n,this.poller=setTimeout(this.updateStats.bind(this),n)},updateStats:function(){var n;i.get(a.apiPath("/counter")).done(function(n){var e,t;this.removed||(t=l.reduce(n.stats,function(n,e){return n+e.message_count},0),-1===this.currentNum?(this.currentNum=t,this.writeCounts(this.currentNum)):t>this.lastNum&&(e=t-this.lastNum,this.delta=e/(this.interval/1e3)/50,this.animator||this.displayFrame()),this.currentNum=this.lastNum,this.lastNum=t,(t=this.interval)<this.targetPollInterval&&(t=this.interval+1e3),this.startLoop(t),i("#warning, #error").hide(),""!==n.message&&(i("#warning .alert").text(n.message),i("#warning").show()))}.bind(this)).fail(function(n){this.removed||(clearTimeout(this.animator),this.animator=null,this.startLoop(1e4),this.handleAJAXError(n))}.bind(this)),i("#big_graph").length&&(this.graphUrl||(this.graphUrl=i("#big_graph").attr("src")),n=Math.floor(1e6*Math.random()),i("#big_graph").attr("src",this.graphUrl+"&_uniq="+n))},displayFrame:function(){this.currentNum+=this.delta,this.writeCounts(this.currentNum),this.animator=setTimeout(this.displayFrame.bind(this),1e3/60)},writeCounts:function(n){for(var t=parseInt(n,10).toString(),e=i(".numbers")[0],l=i(".numbers .number"),a=0;a<t.length;a++){var o,r=t.charAt(a);l.length>a?((o=i(l[a])).show(),o.find(".top").text(r),o.find(".bottom").text(r)):i(e).append('<span class="number"><span class="top">'+r+'</span><span class="bottom">'+r+"</span></span>\n")}i(".numbers .number").each(function(n,e){n>=t.length&&i(e).hide()})}});e.exports=n},{"../app_state":36,"./base":48,"./counter.hbs":51,jquery:void 0,underscore:void 0}],53:[function(n,e,t){n=n("hbsfy/runtime");e.exports=n.template({1:function(n,e,t,l,a){return'style="display: none;"'},compiler:[8,">= 4.3.0"],main:function(n,e,t,l,a){var o,r=null!=e?e:n.nullContext||{},i=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'<div class="row" id="error" '+(null!=(o=i(t,"unless").call(r,null!=e?i(e,"message"):e,{name:"unless",hash:{},fn:n.program(1,a,0),inverse:n.noop,data:a,loc:{start:{line:1,column:28},end:{line:1,column:80}}}))?o:"")+'>\n    <div class="col-md-12">\n        <div class="alert alert-danger">\n            '+n.escapeExpression("function"==typeof(e=null!=(e=i(t,"message")||(null!=e?i(e,"message"):e))?e:n.hooks.helperMissing)?e.call(r,{name:"message",hash:{},data:a,loc:{start:{line:4,column:12},end:{line:4,column:23}}}):e)+"\n        </div>\n    </div>\n</div>\n"},useData:!0})},{"hbsfy/runtime":35}],54:[function(n,e,t){n=n("hbsfy/runtime");e.exports=n.template({1:function(n,e,t,l,a){var o,r=null!=e?e:n.nullContext||{},i=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'                <li class="dropdown">\n                    <a href="#" class="dropdown-toggle" data-toggle="dropdown" role="button" aria-expanded="false"><span class="glyphicon glyphicon-picture white"></span> '+n.escapeExpression("function"==typeof(o=null!=(o=i(t,"graph_interval")||(null!=e?i(e,"graph_interval"):e))?o:n.hooks.helperMissing)?o.call(r,{name:"graph_interval",hash:{},data:a,loc:{start:{line:20,column:171},end:{line:20,column:189}}}):o)+' <span class="caret"></span></a>\n                    <ul class="dropdown-menu">\n                      <li class="dropdown-header">Graph Timeframe</li>\n'+(null!=(a=i(t,"each").call(r,null!=e?i(e,"graph_intervals"):e,{name:"each",hash:{},fn:n.program(2,a,0),inverse:n.noop,data:a,loc:{start:{line:23,column:20},end:{line:25,column:29}}}))?a:"")+"                    </ul>\n                </li>\n"},2:function(n,e,t,l,a){return'                        <li><a href="javascript:;">'+n.escapeExpression(n.lambda(e,e))+"</a></li>\n"},compiler:[8,">= 4.3.0"],main:function(n,e,t,l,a){var o=null!=e?e:n.nullContext||{},r=n.hooks.helperMissing,i=n.escapeExpression,s=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'<nav class="navbar navbar-inverse navbar-static-top">\n    <div class="container-fluid">\n        <div class="navbar-header">\n            <button type="button" class="navbar-toggle collapsed" data-toggle="collapse" data-target="#navbar">\n                <span class="sr-only">Toggle navigation</span>\n                <span class="icon-bar"></span>\n                <span class="icon-bar"></span>\n                <span class="icon-bar"></span>\n            </button>\n            <a class="navbar-brand" href="'+i((s(t,"basePath")||e&&s(e,"basePath")||r).call(o,"/",{name:"basePath",hash:{},data:a,loc:{start:{line:10,column:42},end:{line:10,column:58}}}))+'"><img src="'+i((s(t,"basePath")||e&&s(e,"basePath")||r).call(o,"/static/nsq_blue.png",{name:"basePath",hash:{},data:a,loc:{start:{line:10,column:70},end:{line:10,column:105}}}))+'" width="30" height="30">NSQ</a>\n        </div>\n        <div class="collapse navbar-collapse" id="navbar">\n            <ul class="nav navbar-nav">\n                <li><a class="link" href="'+i((s(t,"basePath")||e&&s(e,"basePath")||r).call(o,"/",{name:"basePath",hash:{},data:a,loc:{start:{line:14,column:42},end:{line:14,column:58}}}))+'">Streams</a></li>\n                <li><a class="link" href="'+i((s(t,"basePath")||e&&s(e,"basePath")||r).call(o,"/nodes",{name:"basePath",hash:{},data:a,loc:{start:{line:15,column:42},end:{line:15,column:63}}}))+'">Nodes</a></li>\n                <li><a class="link" href="'+i((s(t,"basePath")||e&&s(e,"basePath")||r).call(o,"/counter",{name:"basePath",hash:{},data:a,loc:{start:{line:16,column:42},end:{line:16,column:65}}}))+'">Counter</a></li>\n                <li><a class="link" href="'+i((s(t,"basePath")||e&&s(e,"basePath")||r).call(o,"/lookup",{name:"basePath",hash:{},data:a,loc:{start:{line:17,column:42},end:{line:17,column:64}}}))+'">Lookup</a></li>\n'+(null!=(n=s(t,"if").call(o,null!=e?s(e,"graph_enabled"):e,{name:"if",hash:{},fn:n.program(1,a,0),inverse:n.noop,data:a,loc:{start:{line:18,column:16},end:{line:28,column:23}}}))?n:"")+'            </ul>\n            <ul class="nav navbar-nav navbar-right">\n                <li><a href="https://nsq.io/">Documentation</a></li>\n                <li><a href="https://github.com/nsqio/nsq">GitHub</a></li>\n                <li class="hidden-xs"><p class="navbar-text"><span class="label label-success">v'+i("function"==typeof(e=null!=(e=s(t,"version")||(null!=e?s(e,"version"):e))?e:r)?e.call(o,{name:"version",hash:{},data:a,loc:{start:{line:33,column:96},end:{line:33,column:107}}}):e)+"</span></p></li>\n                </ul>\n            </ul>\n        </div>\n    </div>\n</nav>\n"},useData:!0})},{"hbsfy/runtime":35}],55:[function(n,e,t){var l=n("underscore"),a=n("jquery"),o=n("../app_state"),r=n("./base"),n=r.extend({className:"header",template:n("./header.hbs"),events:{"click .dropdown-menu li":"onGraphIntervalClick"},initialize:function(){r.prototype.initialize.apply(this,arguments),this.listenTo(o,"change:graph_interval",this.render)},getRenderCtx:function(){return l.extend(r.prototype.getRenderCtx.apply(this,arguments),{graph_intervals:["1h","2h","12h","24h","48h","168h","off"],graph_interval:o.get("graph_interval")})},onReset:function(){this.render(),this.$(".dropdown-toggle").dropdown()},onGraphIntervalClick:function(n){n.stopPropagation(),o.set("graph_interval",a(n.target).text())}});e.exports=n},{"../app_state":36,"./base":48,"./header.hbs":54,jquery:void 0,underscore:void 0}],56:[function(n,e,t){n=n("hbsfy/runtime");e.exports=n.template({1:function(n,e,t,l,a){return'<div class="alert alert-warning">\n    <h4>Notice</h4> nsqadmin is not configured with nsqlookupd hosts\n</div>\n'},3:function(n,e,t,l,a,o,r){var i,s=null!=e?e:n.nullContext||{},c=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'<div class="row">\n    <div class="col-md-4">\n        <table class="table table-bordered table-condensed">\n            <tr>\n                <th>nsqlookupd Host</th>\n            </tr>\n'+(null!=(i=c(t,"each").call(s,null!=e?c(e,"nsqlookupd"):e,{name:"each",hash:{},fn:n.program(4,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:21,column:12},end:{line:23,column:21}}}))?i:"")+'        </table>\n    </div>\n</div>\n\n<div class="row">\n    <div class="col-md-4">\n'+(null!=(i=c(t,"if").call(s,null!=e?c(e,"topics"):e,{name:"if",hash:{},fn:n.program(6,a,0,o,r),inverse:n.program(10,a,0,o,r),data:a,loc:{start:{line:30,column:8},end:{line:50,column:15}}}))?i:"")+"    </div>\n</div>\n\n"+(null!=(i=c(t,"if").call(s,null!=e?c(e,"isAdmin"):e,{name:"if",hash:{},fn:n.program(12,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:54,column:0},end:{line:72,column:7}}}))?i:"")},4:function(n,e,t,l,a){return"            <tr><td>"+n.escapeExpression(n.lambda(e,e))+"</td></tr>\n"},6:function(n,e,t,l,a,o,r){var i=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'        <div class="alert alert-info">\n            Below is a tree of Topics/Channels that are currently inactive (i.e. not produced on any nsqd in the cluster but are present in the lookup data)\n        </div>\n        <ul>\n'+(null!=(a=i(t,"each").call(null!=e?e:n.nullContext||{},null!=e?i(e,"topics"):e,{name:"each",hash:{},fn:n.program(7,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:35,column:12},end:{line:46,column:21}}}))?a:"")+"        </ul>\n"},7:function(n,e,t,l,a,o,r){var i,s=null!=e?e:n.nullContext||{},c=n.hooks.helperMissing,u="function",h=n.escapeExpression,p=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'            <li>\n                <button class="btn-link red delete-topic-link" data-topic="'+h(typeof(i=null!=(i=p(t,"name")||(null!=e?p(e,"name"):e))?i:c)==u?i.call(s,{name:"name",hash:{},data:a,loc:{start:{line:37,column:75},end:{line:37,column:83}}}):i)+'" style="padding: 0 6px; border: 0;">✘</button> <a class="link" href="'+h((p(t,"basePath")||e&&p(e,"basePath")||c).call(s,"/topics",{name:"basePath",hash:{},data:a,loc:{start:{line:37,column:153},end:{line:37,column:175}}}))+"/"+h(typeof(i=null!=(i=p(t,"name")||(null!=e?p(e,"name"):e))?i:c)==u?i.call(s,{name:"name",hash:{},data:a,loc:{start:{line:37,column:176},end:{line:37,column:184}}}):i)+'">'+h(typeof(i=null!=(i=p(t,"name")||(null!=e?p(e,"name"):e))?i:c)==u?i.call(s,{name:"name",hash:{},data:a,loc:{start:{line:37,column:186},end:{line:37,column:194}}}):i)+"</a>\n                <ul>\n"+(null!=(a=p(t,"each").call(s,null!=e?p(e,"channels"):e,{name:"each",hash:{},fn:n.program(8,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:39,column:20},end:{line:43,column:29}}}))?a:"")+"                </ul>\n            </li>\n"},8:function(n,e,t,l,a,o,r){var i=n.lambda,s=n.escapeExpression,c=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'                    <li>\n                        <button class="btn-link red delete-channel-link" data-topic="'+s(i(null!=r[1]?c(r[1],"name"):r[1],e))+'" data-channel="'+s(i(e,e))+'" style="padding: 0 6px; border: 0;">✘</button> <a class="link" href="'+s((c(t,"basePath")||e&&c(e,"basePath")||n.hooks.helperMissing).call(null!=e?e:n.nullContext||{},"/topics",{name:"basePath",hash:{},data:a,loc:{start:{line:41,column:190},end:{line:41,column:212}}}))+"/"+s(i(null!=r[1]?c(r[1],"name"):r[1],e))+"/"+s(i(e,e))+'">'+s(i(e,e))+"</a>\n                    </li>\n"},10:function(n,e,t,l,a){return'        <div class="alert alert-warning"><h4>Notice</h4>No inactive Topics</div>\n'},12:function(n,e,t,l,a){return'<div class="row">\n    <div class="col-md-4">\n        <form class="hierarchy">\n            <legend>Create Topic/Channel</legend>\n            <div class="alert alert-info">\n                <p>This provides a way to setup a stream hierarchy\n                before services are deployed to production.\n                <p>If <em>Channel Name</em> is empty, just the topic is created.\n            </div>\n            <div class="form-group">\n                <input type="text" name="topic" placeholder="Topic Name">\n                <input type="text" name="channel" placeholder="Channel Name">\n            </div>\n            <button class="btn btn-default" type="submit">Create</button>\n        </form>\n    </div>\n</div>\n'},compiler:[8,">= 4.3.0"],main:function(n,e,t,l,a,o,r){var i,s=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return(null!=(i=n.invokePartial(s(l,"warning"),e,{name:"warning",data:a,helpers:t,partials:l,decorators:n.decorators}))?i:"")+(null!=(i=n.invokePartial(s(l,"error"),e,{name:"error",data:a,helpers:t,partials:l,decorators:n.decorators}))?i:"")+'\n<div class="row">\n    <div class="col-md-12">\n        <h2>Lookup</h2>\n    </div>\n</div>\n\n'+(null!=(i=s(t,"unless").call(null!=e?e:n.nullContext||{},null!=(i=null!=e?s(e,"nsqlookupd"):e)?s(i,"length"):i,{name:"unless",hash:{},fn:n.program(1,a,0,o,r),inverse:n.program(3,a,0,o,r),data:a,loc:{start:{line:10,column:0},end:{line:73,column:11}}}))?i:"")},usePartial:!0,useData:!0,useDepths:!0})},{"hbsfy/runtime":35}],57:[function(t,n,e){var l=t("underscore"),a=t("jquery"),o=t("../app_state"),r=t("../lib/pubsub"),i=t("./base"),s=t("../models/topic"),c=t("../models/channel"),u=i.extend({className:"lookup container-fluid",template:t("./spinner.hbs"),events:{"click .hierarchy button":"onCreateTopicChannel","click .delete-topic-link":"onDeleteTopic","click .delete-channel-link":"onDeleteChannel"},initialize:function(){i.prototype.initialize.apply(this,arguments);var e=arguments[0].isAdmin;a.ajax(o.apiPath("/topics?inactive=true")).done(function(n){this.template=t("./lookup.hbs"),this.render({topics:l.map(n.topics,function(n,e){return{name:e,channels:n}}),message:n.message,isAdmin:e})}.bind(this)).fail(this.handleViewError.bind(this)).always(r.trigger.bind(r,"view:ready"))},onCreateTopicChannel:function(n){n.preventDefault(),n.stopPropagation();var e=a(n.target.form.elements.topic).val(),n=a(n.target.form.elements.channel).val();""===e&&""===n||a.post(o.apiPath("/topics"),JSON.stringify({topic:e,channel:n})).done(function(){window.location.reload(!0)}).fail(this.handleAJAXError.bind(this))},onDeleteTopic:function(n){n.preventDefault(),n.stopPropagation(),new s({name:a(n.target).data("topic")}).destroy({dataType:"text"}).done(function(){window.location.reload(!0)}).fail(this.handleAJAXError.bind(this))},onDeleteChannel:function(n){n.preventDefault(),n.stopPropagation(),new c({topic:a(n.target).data("topic"),name:a(n.target).data("channel")}).destroy({dataType:"text"}).done(function(){window.location.reload(!0)}).fail(this.handleAJAXError.bind(this))}});n.exports=u},{"../app_state":36,"../lib/pubsub":41,"../models/channel":43,"../models/topic":45,"./base":48,"./lookup.hbs":56,"./spinner.hbs":62,jquery:void 0,underscore:void 0}],58:[function(n,e,t){n=n("hbsfy/runtime");e.exports=n.template({1:function(n,e,t,l,a){var o=null!=e?e:n.nullContext||{},r=n.hooks.helperMissing,i=n.escapeExpression,n=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'<div class="row">\n  <div class="col-md-8 col-md-offset-2">\n    <table class="table muted">\n      <tr>\n        <td>\n          <a href="'+i((n(t,"large_graph")||e&&n(e,"large_graph")||r).call(o,"node",null!=e?n(e,"node"):e,"","","*_bytes",{name:"large_graph",hash:{},data:a,loc:{start:{line:15,column:19},end:{line:15,column:62}}}))+'"><img class="img-polaroid" width="200" src="'+i((n(t,"large_graph")||e&&n(e,"large_graph")||r).call(o,"node",null!=e?n(e,"node"):e,"","","*_bytes",{name:"large_graph",hash:{},data:a,loc:{start:{line:15,column:107},end:{line:15,column:150}}}))+'"/></a>\n          <h5 style="text-align: center;">GC Pressure</h5>\n        </td>\n        <td>\n          <a href="'+i((n(t,"large_graph")||e&&n(e,"large_graph")||r).call(o,"node",null!=e?n(e,"node"):e,"","","gc_pause_*",{name:"large_graph",hash:{},data:a,loc:{start:{line:19,column:19},end:{line:19,column:65}}}))+'"><img class="img-polaroid" width="200" src="'+i((n(t,"large_graph")||e&&n(e,"large_graph")||r).call(o,"node",null!=e?n(e,"node"):e,"","","gc_pause_*",{name:"large_graph",hash:{},data:a,loc:{start:{line:19,column:110},end:{line:19,column:156}}}))+'"/></a>\n          <h5 style="text-align: center;">GC Pause Percentiles</h5>\n        </td>\n        <td>\n          <a href="'+i((n(t,"large_graph")||e&&n(e,"large_graph")||r).call(o,"node",null!=e?n(e,"node"):e,"","","gc_runs",{name:"large_graph",hash:{},data:a,loc:{start:{line:23,column:19},end:{line:23,column:62}}}))+'"><img class="img-polaroid" width="200" src="'+i((n(t,"large_graph")||e&&n(e,"large_graph")||r).call(o,"node",null!=e?n(e,"node"):e,"","","gc_runs",{name:"large_graph",hash:{},data:a,loc:{start:{line:23,column:107},end:{line:23,column:150}}}))+'"/></a>\n          <h5 style="text-align: center;">GC Runs</h5>\n        </td>\n        <td>\n          <a href="'+i((n(t,"large_graph")||e&&n(e,"large_graph")||r).call(o,"node",null!=e?n(e,"node"):e,"","","heap_objects",{name:"large_graph",hash:{},data:a,loc:{start:{line:27,column:19},end:{line:27,column:67}}}))+'"><img class="img-polaroid" width="200" src="'+i((n(t,"large_graph")||e&&n(e,"large_graph")||r).call(o,"node",null!=e?n(e,"node"):e,"","","heap_objects",{name:"large_graph",hash:{},data:a,loc:{start:{line:27,column:112},end:{line:27,column:160}}}))+'"/></a>\n          <h5 style="text-align: center;">Heap Objects In-Use</h5>\n        </td>\n      </tr>\n    </table>\n  </div>\n</div>\n'},3:function(n,e,t,l,a){return'        <div class="alert alert-warning">\n            <h4>Notice</h4> No topics exist on this node.\n        </div>\n'},5:function(n,e,t,l,a,o,r){var i,s=n.escapeExpression,c=null!=e?e:n.nullContext||{},u=n.hooks.helperMissing,h=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'    <table class="table table-condensed">\n        <tr>\n            <td colspan="2"><strong>'+s(n.lambda(null!=(i=null!=r[1]?h(r[1],"topics"):r[1])?h(i,"length"):i,e))+'</strong> Topics</td>\n            <td colspan="7"></td>\n            <td><strong>'+s((h(t,"commafy")||e&&h(e,"commafy")||u).call(c,null!=e?h(e,"total_messages"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:47,column:24},end:{line:47,column:50}}}))+"</strong> Messages</td>\n            <td><strong>"+s((h(t,"commafy")||e&&h(e,"commafy")||u).call(c,null!=e?h(e,"total_clients"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:48,column:24},end:{line:48,column:49}}}))+"</strong> Clients</td>\n        </tr>\n"+(null!=(i=h(t,"each").call(c,null!=e?h(e,"topics"):e,{name:"each",hash:{},fn:n.program(6,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:50,column:8},end:{line:174,column:17}}}))?i:"")+"    </table>\n"},6:function(n,e,t,l,a,o,r){var i,s=n.escapeExpression,c=null!=e?e:n.nullContext||{},u=n.hooks.helperMissing,h="function",p=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'        <tr>\n            <th colspan="3">Topic</th>\n            <th>Depth</th>\n            <th>Memory + Disk</th>\n            <th colspan="4"></th>\n            <th>Messages</th>\n            <th>Channels</th>\n        </tr>\n        <tr class="info">\n            <td colspan="3">\n                <button class="btn-link red tombstone-link" data-node="'+s(n.lambda(null!=r[1]?p(r[1],"name"):r[1],e))+'" data-topic="'+s(typeof(i=null!=(i=p(t,"topic_name")||(null!=e?p(e,"topic_name"):e))?i:u)==h?i.call(c,{name:"topic_name",hash:{},data:a,loc:{start:{line:61,column:96},end:{line:61,column:110}}}):i)+'" style="padding: 0 6px; border: 0;">✘</button> '+s(typeof(i=null!=(i=p(t,"topic_name")||(null!=e?p(e,"topic_name"):e))?i:u)==h?i.call(c,{name:"topic_name",hash:{},data:a,loc:{start:{line:61,column:158},end:{line:61,column:172}}}):i)+"\n            </td>\n            <td>\n                "+(null!=(i=p(t,"if").call(c,null!=r[1]?p(r[1],"graph_active"):r[1],{name:"if",hash:{},fn:n.program(7,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:64,column:16},end:{line:64,column:177}}}))?i:"")+"\n                "+s((p(t,"commafy")||e&&p(e,"commafy")||u).call(c,null!=e?p(e,"depth"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:65,column:16},end:{line:65,column:33}}}))+"\n            </td>\n            <td>"+s((p(t,"commafy")||e&&p(e,"commafy")||u).call(c,null!=e?p(e,"memory_depth"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:67,column:16},end:{line:67,column:40}}}))+" + "+s((p(t,"commafy")||e&&p(e,"commafy")||u).call(c,null!=e?p(e,"backend_depth"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:67,column:43},end:{line:67,column:68}}}))+'</td>\n            <td colspan="4"></td>\n            <td>\n                '+(null!=(i=p(t,"if").call(c,null!=r[1]?p(r[1],"graph_active"):r[1],{name:"if",hash:{},fn:n.program(9,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:70,column:16},end:{line:70,column:199}}}))?i:"")+"\n                "+s((p(t,"commafy")||e&&p(e,"commafy")||u).call(c,null!=e?p(e,"message_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:71,column:16},end:{line:71,column:41}}}))+"\n            </td>\n            <td>"+s((p(t,"commafy")||e&&p(e,"commafy")||u).call(c,null!=(i=null!=e?p(e,"channels"):e)?p(i,"length"):i,{name:"commafy",hash:{},data:a,loc:{start:{line:73,column:16},end:{line:73,column:43}}}))+"</td>\n        </tr>\n"+(null!=(i=p(t,"unless").call(c,null!=(i=null!=e?p(e,"channels"):e)?p(i,"length"):i,{name:"unless",hash:{},fn:n.program(11,a,0,o,r),inverse:n.program(13,a,0,o,r),data:a,loc:{start:{line:75,column:8},end:{line:173,column:19}}}))?i:"")},7:function(n,e,t,l,a){var o=null!=e?e:n.nullContext||{},r=n.hooks.helperMissing,i=n.escapeExpression,n=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'<a href="'+i((n(t,"large_graph")||e&&n(e,"large_graph")||r).call(o,"topic",null!=e?n(e,"node"):e,null!=e?n(e,"name"):e,"","depth",{name:"large_graph",hash:{},data:a,loc:{start:{line:64,column:48},end:{line:64,column:92}}}))+'"><img width="120" src="'+i((n(t,"sparkline")||e&&n(e,"sparkline")||r).call(o,"topic",null!=e?n(e,"node"):e,null!=e?n(e,"topic_name"):e,"","depth",{name:"sparkline",hash:{},data:a,loc:{start:{line:64,column:116},end:{line:64,column:164}}}))+'"></a>'},9:function(n,e,t,l,a){var o=null!=e?e:n.nullContext||{},r=n.hooks.helperMissing,i=n.escapeExpression,n=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'<a href="'+i((n(t,"large_graph")||e&&n(e,"large_graph")||r).call(o,"topic",null!=e?n(e,"node"):e,null!=e?n(e,"topic_name"):e,"","message_count",{name:"large_graph",hash:{},data:a,loc:{start:{line:70,column:48},end:{line:70,column:106}}}))+'"><img width="120" src="'+i((n(t,"sparkline")||e&&n(e,"sparkline")||r).call(o,"topic",null!=e?n(e,"node"):e,null!=e?n(e,"topic_name"):e,"","message_count",{name:"sparkline",hash:{},data:a,loc:{start:{line:70,column:130},end:{line:70,column:186}}}))+'"></a>'},11:function(n,e,t,l,a){return'        <tr>\n            <td colspan="11">\n              <div class="alert alert-warning"><h4>Notice</h4> No channels exist for this topic.</div>\n            </td>\n        </tr>\n'},13:function(n,e,t,l,a,o,r){var i=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return null!=(a=i(t,"each").call(null!=e?e:n.nullContext||{},null!=e?i(e,"channels"):e,{name:"each",hash:{},fn:n.program(14,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:82,column:8},end:{line:172,column:17}}}))?a:""},14:function(n,e,t,l,a,o,r){var i,s=null!=e?e:n.nullContext||{},c=n.hooks.helperMissing,u=n.escapeExpression,h=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'        <tr>\n            <th width="25"></th>\n            <th colspan="2">Channel</th>\n            <th>Depth</th>\n            <th>Memory + Disk</th>\n            <th>In-Flight</th>\n            <th>Deferred</th>\n            <th>Requeued</th>\n            <th>Timed Out</th>\n            <th>Messages</th>\n            <th>Connections</th>\n        </tr>\n        <tr class="warning">\n            <td></td>\n            <td colspan="2">\n                '+u("function"==typeof(i=null!=(i=h(t,"channel_name")||(null!=e?h(e,"channel_name"):e))?i:c)?i.call(s,{name:"channel_name",hash:{},data:a,loc:{start:{line:98,column:16},end:{line:98,column:32}}}):i)+"\n                "+(null!=(i=h(t,"if").call(s,null!=e?h(e,"paused"):e,{name:"if",hash:{},fn:n.program(15,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:99,column:16},end:{line:99,column:84}}}))?i:"")+"\n            </td>\n            <td>\n                "+(null!=(i=h(t,"if").call(s,null!=r[3]?h(r[3],"graph_active"):r[3],{name:"if",hash:{},fn:n.program(17,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:102,column:16},end:{line:102,column:225}}}))?i:"")+"\n                "+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"depth"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:103,column:16},end:{line:103,column:33}}}))+"\n            </td>\n            <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"memory_depth"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:105,column:16},end:{line:105,column:40}}}))+" + "+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"backend_depth"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:105,column:43},end:{line:105,column:68}}}))+"</td>\n            <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"in_flight_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:106,column:16},end:{line:106,column:43}}}))+"</td>\n            <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"deferred_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:107,column:16},end:{line:107,column:42}}}))+"</td>\n            <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"requeue_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:108,column:16},end:{line:108,column:41}}}))+"</td>\n            <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"timeout_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:109,column:16},end:{line:109,column:41}}}))+"</td>\n            <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"message_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:110,column:16},end:{line:110,column:41}}}))+"</td>\n            <td>\n                "+(null!=(i=h(t,"if").call(s,null!=r[3]?h(r[3],"graph_active"):r[3],{name:"if",hash:{},fn:n.program(19,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:112,column:16},end:{line:112,column:229}}}))?i:"")+"\n                "+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=(i=null!=e?h(e,"clients"):e)?h(i,"length"):i,{name:"commafy",hash:{},data:a,loc:{start:{line:113,column:16},end:{line:113,column:42}}}))+"\n            </td>\n        </tr>\n"+(null!=(i=h(t,"unless").call(s,null!=(i=null!=e?h(e,"clients"):e)?h(i,"length"):i,{name:"unless",hash:{},fn:n.program(21,a,0,o,r),inverse:n.program(23,a,0,o,r),data:a,loc:{start:{line:116,column:8},end:{line:171,column:19}}}))?i:"")},15:function(n,e,t,l,a){return'<span class="label label-primary">paused</span>'},17:function(n,e,t,l,a){var o=null!=e?e:n.nullContext||{},r=n.hooks.helperMissing,i=n.escapeExpression,n=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'<a href="'+i((n(t,"large_graph")||e&&n(e,"large_graph")||r).call(o,"channel",null!=e?n(e,"node"):e,null!=e?n(e,"topic_name"):e,null!=e?n(e,"channel_name"):e,"depth",{name:"large_graph",hash:{},data:a,loc:{start:{line:102,column:54},end:{line:102,column:116}}}))+'"><img width="120" height="20" src="'+i((n(t,"sparkline")||e&&n(e,"sparkline")||r).call(o,"channel",null!=e?n(e,"node"):e,null!=e?n(e,"topic_name"):e,null!=e?n(e,"channel_name"):e,"depth",{name:"sparkline",hash:{},data:a,loc:{start:{line:102,column:152},end:{line:102,column:212}}}))+'"></a>'},19:function(n,e,t,l,a){var o=null!=e?e:n.nullContext||{},r=n.hooks.helperMissing,i=n.escapeExpression,n=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'<a href="'+i((n(t,"large_graph")||e&&n(e,"large_graph")||r).call(o,"channel",null!=e?n(e,"node"):e,null!=e?n(e,"topic_name"):e,null!=e?n(e,"channel_name"):e,"clients",{name:"large_graph",hash:{},data:a,loc:{start:{line:112,column:54},end:{line:112,column:118}}}))+'"><img width="120" height="20" src="'+i((n(t,"sparkline")||e&&n(e,"sparkline")||r).call(o,"channel",null!=e?n(e,"node"):e,null!=e?n(e,"topic_name"):e,null!=e?n(e,"channel_name"):e,"clients",{name:"sparkline",hash:{},data:a,loc:{start:{line:112,column:154},end:{line:112,column:216}}}))+'"></a>'},21:function(n,e,t,l,a){return'        <tr>\n            <td colspan="11">\n                <div class="alert alert-warning"><h4>Notice</h4>No clients connected to this channel.</div>\n            </td>\n        </tr>\n'},23:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return"        <tr>\n            <th></th>\n            <th>Client Host</th>\n            <th>User-Agent</th>\n            <th></th>\n            <th>Attributes</th>\n            <th>In-Flight</th>\n            <th>Ready Count</th>\n            <th>Requeued</th>\n            <th>Finished</th>\n            <th>Messages</th>\n            <th>Connected</th>\n        </tr>\n"+(null!=(a=o(t,"each").call(null!=e?e:n.nullContext||{},null!=e?o(e,"clients"):e,{name:"each",hash:{},fn:n.program(24,a,0),inverse:n.noop,data:a,loc:{start:{line:136,column:8},end:{line:170,column:17}}}))?a:"")},24:function(n,e,t,l,a){var o,r=null!=e?e:n.nullContext||{},i=n.hooks.helperMissing,s="function",c=n.escapeExpression,u=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'        <tr>\n            <td></td>\n            <td title="'+c(typeof(o=null!=(o=u(t,"remote_address")||(null!=e?u(e,"remote_address"):e))?o:i)==s?o.call(r,{name:"remote_address",hash:{},data:a,loc:{start:{line:139,column:23},end:{line:139,column:41}}}):o)+'">'+c(typeof(o=null!=(o=u(t,"hostname")||(null!=e?u(e,"hostname"):e))?o:i)==s?o.call(r,{name:"hostname",hash:{},data:a,loc:{start:{line:139,column:43},end:{line:139,column:55}}}):o)+(null!=(o=u(t,"if").call(r,null!=e?u(e,"show_client_id"):e,{name:"if",hash:{},fn:n.program(25,a,0),inverse:n.noop,data:a,loc:{start:{line:139,column:55},end:{line:139,column:100}}}))?o:"")+"</td>\n            <td>"+(null!=(o=u(t,"if").call(r,null!=(o=null!=e?u(e,"user_agent"):e)?u(o,"length"):o,{name:"if",hash:{},fn:n.program(27,a,0),inverse:n.noop,data:a,loc:{start:{line:140,column:16},end:{line:140,column:77}}}))?o:"")+"</td>\n            <td></td>\n            <td>\n"+(null!=(o=u(t,"if").call(r,null!=e?u(e,"sample_rate"):e,{name:"if",hash:{},fn:n.program(29,a,0),inverse:n.noop,data:a,loc:{start:{line:143,column:16},end:{line:145,column:23}}}))?o:"")+(null!=(o=u(t,"if").call(r,null!=e?u(e,"tls"):e,{name:"if",hash:{},fn:n.program(31,a,0),inverse:n.noop,data:a,loc:{start:{line:146,column:16},end:{line:148,column:23}}}))?o:"")+(null!=(o=u(t,"if").call(r,null!=e?u(e,"deflate"):e,{name:"if",hash:{},fn:n.program(34,a,0),inverse:n.noop,data:a,loc:{start:{line:149,column:16},end:{line:151,column:23}}}))?o:"")+(null!=(o=u(t,"if").call(r,null!=e?u(e,"snappy"):e,{name:"if",hash:{},fn:n.program(36,a,0),inverse:n.noop,data:a,loc:{start:{line:152,column:16},end:{line:154,column:23}}}))?o:"")+(null!=(o=u(t,"if").call(r,null!=e?u(e,"authed"):e,{name:"if",hash:{},fn:n.program(38,a,0),inverse:n.noop,data:a,loc:{start:{line:155,column:16},end:{line:161,column:23}}}))?o:"")+"            </td>\n            <td>"+c((u(t,"commafy")||e&&u(e,"commafy")||i).call(r,null!=e?u(e,"in_flight_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:163,column:16},end:{line:163,column:43}}}))+"</td>\n            <td>"+c((u(t,"commafy")||e&&u(e,"commafy")||i).call(r,null!=e?u(e,"ready_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:164,column:16},end:{line:164,column:39}}}))+"</td>\n            <td>"+c((u(t,"commafy")||e&&u(e,"commafy")||i).call(r,null!=e?u(e,"requeue_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:165,column:16},end:{line:165,column:41}}}))+"</td>\n            <td>"+c((u(t,"commafy")||e&&u(e,"commafy")||i).call(r,null!=e?u(e,"finish_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:166,column:16},end:{line:166,column:40}}}))+"</td>\n            <td>"+c((u(t,"commafy")||e&&u(e,"commafy")||i).call(r,null!=e?u(e,"message_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:167,column:16},end:{line:167,column:41}}}))+"</td>\n            <td>"+c((u(t,"nanotohuman")||e&&u(e,"nanotohuman")||i).call(r,null!=e?u(e,"connected"):e,{name:"nanotohuman",hash:{},data:a,loc:{start:{line:168,column:16},end:{line:168,column:41}}}))+"</td>\n        </tr>\n"},25:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return" ("+n.escapeExpression("function"==typeof(o=null!=(o=o(t,"client_id")||(null!=e?o(e,"client_id"):e))?o:n.hooks.helperMissing)?o.call(null!=e?e:n.nullContext||{},{name:"client_id",hash:{},data:a,loc:{start:{line:139,column:79},end:{line:139,column:92}}}):o)+")"},27:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return"<small>"+n.escapeExpression("function"==typeof(o=null!=(o=o(t,"user_agent")||(null!=e?o(e,"user_agent"):e))?o:n.hooks.helperMissing)?o.call(null!=e?e:n.nullContext||{},{name:"user_agent",hash:{},data:a,loc:{start:{line:140,column:48},end:{line:140,column:62}}}):o)+"</small>"},29:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'                    <span class="label label-info">Sampled '+n.escapeExpression("function"==typeof(o=null!=(o=o(t,"sample_rate")||(null!=e?o(e,"sample_rate"):e))?o:n.hooks.helperMissing)?o.call(null!=e?e:n.nullContext||{},{name:"sample_rate",hash:{},data:a,loc:{start:{line:144,column:59},end:{line:144,column:74}}}):o)+"%</span>\n"},31:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'                    <span class="label label-warning" '+(null!=(a=o(t,"if").call(null!=e?e:n.nullContext||{},null!=e?o(e,"tls_version"):e,{name:"if",hash:{},fn:n.program(32,a,0),inverse:n.noop,data:a,loc:{start:{line:147,column:54},end:{line:147,column:197}}}))?a:"")+">TLS</span>\n"},32:function(n,e,t,l,a){var o=null!=e?e:n.nullContext||{},r=n.hooks.helperMissing,i="function",s=n.escapeExpression,c=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'title="'+s(typeof(n=null!=(n=c(t,"tls_version")||(null!=e?c(e,"tls_version"):e))?n:r)==i?n.call(o,{name:"tls_version",hash:{},data:a,loc:{start:{line:147,column:80},end:{line:147,column:95}}}):n)+" "+s(typeof(n=null!=(n=c(t,"tls_cipher_suite")||(null!=e?c(e,"tls_cipher_suite"):e))?n:r)==i?n.call(o,{name:"tls_cipher_suite",hash:{},data:a,loc:{start:{line:147,column:96},end:{line:147,column:116}}}):n)+" "+s(typeof(n=null!=(n=c(t,"tls_negotiated_protocol")||(null!=e?c(e,"tls_negotiated_protocol"):e))?n:r)==i?n.call(o,{name:"tls_negotiated_protocol",hash:{},data:a,loc:{start:{line:147,column:117},end:{line:147,column:144}}}):n)+" mutual:"+s(typeof(n=null!=(n=c(t,"tls_negotiated_protocol_is_mutual")||(null!=e?c(e,"tls_negotiated_protocol_is_mutual"):e))?n:r)==i?n.call(o,{name:"tls_negotiated_protocol_is_mutual",hash:{},data:a,loc:{start:{line:147,column:152},end:{line:147,column:189}}}):n)+'"'},34:function(n,e,t,l,a){return'                    <span class="label label-default">Deflate</span>\n'},36:function(n,e,t,l,a){return'                    <span class="label label-primary">Snappy</span>\n'},38:function(n,e,t,l,a){var o,r=null!=e?e:n.nullContext||{},i=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'                    <span class="label label-success">\n                    '+(null!=(o=i(t,"if").call(r,null!=e?i(e,"auth_identity_url"):e,{name:"if",hash:{},fn:n.program(39,a,0),inverse:n.noop,data:a,loc:{start:{line:157,column:20},end:{line:157,column:84}}}))?o:"")+'\n                    <span class="glyphicon glyphicon-user white" title="Authed'+(null!=(o=i(t,"if").call(r,null!=e?i(e,"auth_identity"):e,{name:"if",hash:{},fn:n.program(41,a,0),inverse:n.noop,data:a,loc:{start:{line:158,column:78},end:{line:158,column:133}}}))?o:"")+'"></span>\n                    '+(null!=(o=i(t,"if").call(r,null!=e?i(e,"auth_identity_url"):e,{name:"if",hash:{},fn:n.program(43,a,0),inverse:n.noop,data:a,loc:{start:{line:159,column:20},end:{line:159,column:56}}}))?o:"")+"\n                    </span>\n"},39:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'<a href="'+n.escapeExpression("function"==typeof(o=null!=(o=o(t,"auth_identity_url")||(null!=e?o(e,"auth_identity_url"):e))?o:n.hooks.helperMissing)?o.call(null!=e?e:n.nullContext||{},{name:"auth_identity_url",hash:{},data:a,loc:{start:{line:157,column:54},end:{line:157,column:75}}}):o)+'">'},41:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return" Identity:"+n.escapeExpression("function"==typeof(o=null!=(o=o(t,"auth_identity")||(null!=e?o(e,"auth_identity"):e))?o:n.hooks.helperMissing)?o.call(null!=e?e:n.nullContext||{},{name:"auth_identity",hash:{},data:a,loc:{start:{line:158,column:109},end:{line:158,column:126}}}):o)},43:function(n,e,t,l,a){return"</a>"},compiler:[8,">= 4.3.0"],main:function(n,e,t,l,a,o,r){var i,s=null!=e?e:n.nullContext||{},c=n.hooks.helperMissing,u=n.escapeExpression,h=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return(null!=(i=n.invokePartial(h(l,"warning"),e,{name:"warning",data:a,helpers:t,partials:l,decorators:n.decorators}))?i:"")+(null!=(i=n.invokePartial(h(l,"error"),e,{name:"error",data:a,helpers:t,partials:l,decorators:n.decorators}))?i:"")+'\n<ol class="breadcrumb">\n    <li><a class="link" href="'+u((h(t,"basePath")||e&&h(e,"basePath")||c).call(s,"/nodes",{name:"basePath",hash:{},data:a,loc:{start:{line:5,column:30},end:{line:5,column:51}}}))+'">Nodes</a>\n    <li class="active">'+u("function"==typeof(u=null!=(u=h(t,"name")||(null!=e?h(e,"name"):e))?u:c)?u.call(s,{name:"name",hash:{},data:a,loc:{start:{line:6,column:23},end:{line:6,column:31}}}):u)+"</li>\n</ol>\n\n"+(null!=(i=h(t,"if").call(s,null!=e?h(e,"graph_active"):e,{name:"if",hash:{},fn:n.program(1,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:9,column:0},end:{line:34,column:7}}}))?i:"")+'\n<div class="row">\n    <div class="col-md-12">\n'+(null!=(i=h(t,"unless").call(s,null!=(i=null!=e?h(e,"topics"):e)?h(i,"length"):i,{name:"unless",hash:{},fn:n.program(3,a,0,o,r),inverse:n.program(5,a,0,o,r),data:a,loc:{start:{line:38,column:4},end:{line:176,column:15}}}))?i:"")+"</div>\n"},usePartial:!0,useData:!0,useDepths:!0})},{"hbsfy/runtime":35}],59:[function(e,n,t){var l=e("../lib/pubsub"),a=e("../app_state"),o=e("./base"),r=o.extend({className:"node container-fluid",template:e("./spinner.hbs"),initialize:function(){o.prototype.initialize.apply(this,arguments),this.listenTo(a,"change:graph_interval",this.render),this.model.fetch().done(function(n){this.template=e("./node.hbs"),this.render({message:n.message})}.bind(this)).fail(this.handleViewError.bind(this)).always(l.trigger.bind(l,"view:ready"))}});n.exports=r},{"../app_state":36,"../lib/pubsub":41,"./base":48,"./node.hbs":58,"./spinner.hbs":62}],60:[function(n,e,t){n=n("hbsfy/runtime");e.exports=n.template({1:function(n,e,t,l,a){return"                <th>Lookupd Conns.</th>\n"},3:function(n,e,t,l,a,o,r){var i,s,c=null!=e?e:n.nullContext||{},u=n.hooks.helperMissing,h="function",p=n.escapeExpression,d=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return"            <tr "+(null!=(i=d(t,"if").call(c,null!=e?d(e,"out_of_date"):e,{name:"if",hash:{},fn:n.program(4,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:25,column:16},end:{line:25,column:57}}}))?i:"")+">\n                <td>"+p(typeof(s=null!=(s=d(t,"hostname")||(null!=e?d(e,"hostname"):e))?s:u)==h?s.call(c,{name:"hostname",hash:{},data:a,loc:{start:{line:26,column:20},end:{line:26,column:32}}}):s)+'</td>\n                <td><a class="link" href="'+p((d(t,"basePath")||e&&d(e,"basePath")||u).call(c,"/nodes",{name:"basePath",hash:{},data:a,loc:{start:{line:27,column:42},end:{line:27,column:63}}}))+"/"+p(typeof(s=null!=(s=d(t,"broadcast_address_http")||(null!=e?d(e,"broadcast_address_http"):e))?s:u)==h?s.call(c,{name:"broadcast_address_http",hash:{},data:a,loc:{start:{line:27,column:64},end:{line:27,column:90}}}):s)+'">'+p(typeof(s=null!=(s=d(t,"broadcast_address")||(null!=e?d(e,"broadcast_address"):e))?s:u)==h?s.call(c,{name:"broadcast_address",hash:{},data:a,loc:{start:{line:27,column:92},end:{line:27,column:113}}}):s)+"</a></td>\n                <td>"+p(typeof(s=null!=(s=d(t,"tcp_port")||(null!=e?d(e,"tcp_port"):e))?s:u)==h?s.call(c,{name:"tcp_port",hash:{},data:a,loc:{start:{line:28,column:20},end:{line:28,column:32}}}):s)+"</td>\n                <td>"+p(typeof(s=null!=(s=d(t,"http_port")||(null!=e?d(e,"http_port"):e))?s:u)==h?s.call(c,{name:"http_port",hash:{},data:a,loc:{start:{line:29,column:20},end:{line:29,column:33}}}):s)+"</td>\n                <td>"+p(typeof(s=null!=(s=d(t,"version")||(null!=e?d(e,"version"):e))?s:u)==h?s.call(c,{name:"version",hash:{},data:a,loc:{start:{line:30,column:20},end:{line:30,column:31}}}):s)+"</td>\n"+(null!=(i=d(t,"if").call(c,null!=(i=null!=r[1]?d(r[1],"nsqlookupd"):r[1])?d(i,"length"):i,{name:"if",hash:{},fn:n.program(6,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:31,column:16},end:{line:38,column:23}}}))?i:"")+"                <td>\n"+(null!=(i=d(t,"if").call(c,null!=(i=null!=e?d(e,"topics"):e)?d(i,"length"):i,{name:"if",hash:{},fn:n.program(11,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:40,column:16},end:{line:45,column:23}}}))?i:"")+"                </td>\n            </tr>\n"},4:function(n,e,t,l,a){return'class="warning"'},6:function(n,e,t,l,a,o,r){var i,s=null!=e?e:n.nullContext||{},c=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'                <td>\n                    <a class="conn-count btn btn-default btn-xs '+(null!=(i=(c(t,"unlesseq")||e&&c(e,"unlesseq")||n.hooks.helperMissing).call(s,null!=(i=null!=r[2]?c(r[2],"nsqlookupd"):r[2])?c(i,"length"):i,null!=(i=null!=e?c(e,"remote_addresses"):e)?c(i,"length"):i,{name:"unlesseq",hash:{},fn:n.program(7,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:33,column:64},end:{line:33,column:149}}}))?i:"")+'">'+n.escapeExpression(n.lambda(null!=(i=null!=e?c(e,"remote_addresses"):e)?c(i,"length"):i,e))+'</a>\n                    <div style="display: none;">\n                        '+(null!=(i=c(t,"each").call(s,null!=e?c(e,"remote_addresses"):e,{name:"each",hash:{},fn:n.program(9,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:35,column:24},end:{line:35,column:72}}}))?i:"")+"\n                    </div>\n                </td>\n"},7:function(n,e,t,l,a){return"btn-warning"},9:function(n,e,t,l,a){return n.escapeExpression(n.lambda(e,e))+"<br/>"},11:function(n,e,t,l,a){var o,r=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'                    <span class="badge">'+n.escapeExpression(n.lambda(null!=(o=null!=e?r(e,"topics"):e)?r(o,"length"):o,e))+"</span>\n"+(null!=(o=r(t,"each").call(null!=e?e:n.nullContext||{},null!=e?r(e,"topics"):e,{name:"each",hash:{},fn:n.program(12,a,0),inverse:n.noop,data:a,loc:{start:{line:42,column:20},end:{line:44,column:29}}}))?o:"")},12:function(n,e,t,l,a){var o,r,i=null!=e?e:n.nullContext||{},s=n.hooks.helperMissing,c=n.escapeExpression,u="function",h=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'                    <a href="'+c((h(t,"basePath")||e&&h(e,"basePath")||s).call(i,"/topics",{name:"basePath",hash:{},data:a,loc:{start:{line:43,column:29},end:{line:43,column:51}}}))+"/"+c(typeof(r=null!=(r=h(t,"topic")||(null!=e?h(e,"topic"):e))?r:s)==u?r.call(i,{name:"topic",hash:{},data:a,loc:{start:{line:43,column:52},end:{line:43,column:61}}}):r)+'" class="link label '+(null!=(o=h(t,"if").call(i,null!=e?h(e,"tombstoned"):e,{name:"if",hash:{},fn:n.program(13,a,0),inverse:n.program(15,a,0),data:a,loc:{start:{line:43,column:81},end:{line:43,column:140}}}))?o:"")+'" '+(null!=(o=h(t,"if").call(i,null!=e?h(e,"tombstoned"):e,{name:"if",hash:{},fn:n.program(17,a,0),inverse:n.noop,data:a,loc:{start:{line:43,column:142},end:{line:43,column:222}}}))?o:"")+">"+c(typeof(r=null!=(r=h(t,"topic")||(null!=e?h(e,"topic"):e))?r:s)==u?r.call(i,{name:"topic",hash:{},data:a,loc:{start:{line:43,column:223},end:{line:43,column:232}}}):r)+"</a>\n"},13:function(n,e,t,l,a){return"label-warning"},15:function(n,e,t,l,a){return"label-primary"},17:function(n,e,t,l,a){return'title="this topic is currently tombstoned on this node"'},compiler:[8,">= 4.3.0"],main:function(n,e,t,l,a,o,r){var i,s=null!=e?e:n.nullContext||{},c=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return(null!=(i=n.invokePartial(c(l,"warning"),e,{name:"warning",data:a,helpers:t,partials:l,decorators:n.decorators}))?i:"")+(null!=(i=n.invokePartial(c(l,"error"),e,{name:"error",data:a,helpers:t,partials:l,decorators:n.decorators}))?i:"")+'\n<div class="row">\n    <div class="col-md-12">\n        <h2>NSQd Nodes ('+n.escapeExpression(n.lambda(null!=(i=null!=e?c(e,"collection"):e)?c(i,"length"):i,e))+')</h2>\n    </div>\n</div>\n\n<div class="row">\n    <div class="col-md-12">\n        <table class="table table-condensed table-bordered">\n            <tr>\n                <th>Hostname</th>\n                <th>Broadcast Address</th>\n                <th>TCP Port</th>\n                <th>HTTP Port</th>\n                <th>Version</th>\n'+(null!=(i=c(t,"if").call(s,null!=(i=null!=e?c(e,"nsqlookupd"):e)?c(i,"length"):i,{name:"if",hash:{},fn:n.program(1,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:19,column:16},end:{line:21,column:23}}}))?i:"")+"                <th>Topics</th>\n            </tr>\n"+(null!=(i=c(t,"each").call(s,null!=e?c(e,"collection"):e,{name:"each",hash:{},fn:n.program(3,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:24,column:12},end:{line:48,column:21}}}))?i:"")+"        </table>\n    </div>\n</div>\n"},usePartial:!0,useData:!0,useDepths:!0})},{"hbsfy/runtime":35}],61:[function(e,n,t){var l=e("jquery"),a=e("../lib/pubsub"),o=e("../app_state"),r=e("./base"),i=e("../collections/nodes"),s=r.extend({className:"nodes container-fluid",template:e("./spinner.hbs"),events:{"click .conn-count":"onClickConnCount"},initialize:function(){r.prototype.initialize.apply(this,arguments),this.listenTo(o,"change:graph_interval",this.render),this.collection=new i,this.collection.fetch().done(function(n){this.template=e("./nodes.hbs"),this.render({message:n.message})}.bind(this)).fail(this.handleViewError.bind(this)).always(a.trigger.bind(a,"view:ready"))},onClickConnCount:function(n){n.preventDefault(),l(n.target).next().toggle()}});n.exports=s},{"../app_state":36,"../collections/nodes":37,"../lib/pubsub":41,"./base":48,"./nodes.hbs":60,"./spinner.hbs":62,jquery:void 0}],62:[function(n,e,t){n=n("hbsfy/runtime");e.exports=n.template({compiler:[8,">= 4.3.0"],main:function(n,e,t,l,a){return'<div class="bubblingG">\n    <span id="bubblingG_1"></span>\n    <span id="bubblingG_2"></span>\n    <span id="bubblingG_3"></span>\n</div>\n'},useData:!0})},{"hbsfy/runtime":35}],63:[function(n,e,t){n=n("hbsfy/runtime");e.exports=n.template({1:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'<div class="row">\n    <div class="col-md-6">\n        <h4>Topic Message Queue</h4>\n        <div class="alert alert-warning">\n            <h4>Notice</h4> No producers exist for this topic.\n            <p>See <a href="'+n.escapeExpression((o(t,"basePath")||e&&o(e,"basePath")||n.hooks.helperMissing).call(null!=e?e:n.nullContext||{},"/lookup",{name:"basePath",hash:{},data:a,loc:{start:{line:23,column:28},end:{line:23,column:50}}}))+'">Lookup</a> for more information.\n        </div>\n    </div>\n</div>\n'},3:function(n,e,t,l,a,o,r){var i,s=null!=e?e:n.nullContext||{},c=n.hooks.helperMissing,u=n.escapeExpression,h=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return(null!=(i=h(t,"if").call(s,null!=e?h(e,"isAdmin"):e,{name:"if",hash:{},fn:n.program(4,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:28,column:0},end:{line:44,column:7}}}))?i:"")+'\n<div class="row">\n    <div class="col-md-12">\n    <h4>Topic Message Queue</h4>\n    <table class="table table-bordered table-condensed">\n'+(null!=(i=h(t,"if").call(s,null!=(i=null!=(i=null!=e?h(e,"e2e_processing_latency"):e)?h(i,"percentiles"):i)?h(i,"length"):i,{name:"if",hash:{},fn:n.program(9,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:50,column:8},end:{line:55,column:15}}}))?i:"")+"        <tr>\n            <th>NSQd Host</th>\n            <th>Depth</th>\n            <th>Memory + Disk</th>\n            <th>Messages</th>\n            "+(null!=(i=h(t,"if").call(s,null!=e?h(e,"graph_active"):e,{name:"if",hash:{},fn:n.program(14,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:61,column:12},end:{line:61,column:52}}}))?i:"")+"\n            <th>Channels</th>\n"+(null!=(i=h(t,"each").call(s,null!=(i=null!=e?h(e,"e2e_processing_latency"):e)?h(i,"percentiles"):i,{name:"each",hash:{},fn:n.program(16,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:63,column:12},end:{line:65,column:21}}}))?i:"")+"        </tr>\n"+(null!=(i=h(t,"each").call(s,null!=e?h(e,"nodes"):e,{name:"each",hash:{},fn:n.program(18,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:67,column:8},end:{line:108,column:17}}}))?i:"")+'        <tr class="info">\n            <td>Total:</td>\n            <td>'+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"depth"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:111,column:16},end:{line:111,column:33}}}))+"</td>\n            <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"memory_depth"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:112,column:16},end:{line:112,column:40}}}))+" + "+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"backend_depth"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:112,column:43},end:{line:112,column:68}}}))+"</td>\n            <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"message_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:113,column:16},end:{line:113,column:41}}}))+"</td>\n            "+(null!=(i=h(t,"if").call(s,null!=e?h(e,"graph_active"):e,{name:"if",hash:{},fn:n.program(33,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:114,column:12},end:{line:114,column:110}}}))?i:"")+"\n            <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=(i=null!=e?h(e,"channels"):e)?h(i,"length"):i,{name:"commafy",hash:{},data:a,loc:{start:{line:115,column:16},end:{line:115,column:43}}}))+"</td>\n"+(null!=(i=h(t,"if").call(s,null!=(i=null!=(i=null!=e?h(e,"e2e_processing_latency"):e)?h(i,"percentiles"):i)?h(i,"length"):i,{name:"if",hash:{},fn:n.program(27,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:116,column:12},end:{line:122,column:19}}}))?i:"")+"        </tr>\n"+(null!=(i=h(t,"if").call(s,null!=e?h(e,"graph_active"):e,{name:"if",hash:{},fn:n.program(35,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:124,column:8},end:{line:138,column:15}}}))?i:"")+"    </table>\n    </div>\n</div>\n"},4:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'<div class="row topic-actions">\n    <div class="col-md-2">\n        <button class="btn btn-medium btn-warning" data-action="empty">Empty Queue</button>\n    </div>\n    <div class="col-md-2">\n        <button class="btn btn-medium btn-danger" data-action="delete">Delete Topic</button>\n    </div>\n    <div class="col-md-2">\n'+(null!=(a=o(t,"if").call(null!=e?e:n.nullContext||{},null!=e?o(e,"paused"):e,{name:"if",hash:{},fn:n.program(5,a,0),inverse:n.program(7,a,0),data:a,loc:{start:{line:37,column:8},end:{line:41,column:15}}}))?a:"")+"    </div>\n</div>\n"},5:function(n,e,t,l,a){return'        <button class="btn btn-medium btn-success" data-action="unpause">UnPause Topic</button>\n'},7:function(n,e,t,l,a){return'        <button class="btn btn-medium btn-primary" data-action="pause">Pause Topic</button>\n'},9:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'        <tr>\n            <th colspan="'+(null!=(a=o(t,"if").call(null!=e?e:n.nullContext||{},null!=e?o(e,"graph_active"):e,{name:"if",hash:{},fn:n.program(10,a,0),inverse:n.program(12,a,0),data:a,loc:{start:{line:52,column:25},end:{line:52,column:62}}}))?a:"")+'"></th>\n            <th colspan="'+n.escapeExpression(n.lambda(null!=(a=null!=(a=null!=e?o(e,"e2e_processing_latency"):e)?o(a,"percentiles"):a)?o(a,"length"):a,e))+'">E2E Processing Latency</th>\n        </tr>\n'},10:function(n,e,t,l,a){return"6"},12:function(n,e,t,l,a){return"5"},14:function(n,e,t,l,a){return"<th>Rate</th>"},16:function(n,e,t,l,a){var o=null!=e?e:n.nullContext||{},r=n.hooks.helperMissing,i=n.escapeExpression,n=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return"                <th>"+i((n(t,"floatToPercent")||e&&n(e,"floatToPercent")||r).call(o,null!=e?n(e,"quantile"):e,{name:"floatToPercent",hash:{},data:a,loc:{start:{line:64,column:20},end:{line:64,column:47}}}))+"<sup>"+i((n(t,"percSuffix")||e&&n(e,"percSuffix")||r).call(o,null!=e?n(e,"quantile"):e,{name:"percSuffix",hash:{},data:a,loc:{start:{line:64,column:52},end:{line:64,column:75}}}))+"</sup></th>\n"},18:function(n,e,t,l,a,o,r){var i,s=null!=e?e:n.nullContext||{},c=n.hooks.helperMissing,u=n.escapeExpression,h=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'        <tr>\n            <td>\n                <button class="btn-link red tombstone-link" data-node="'+u("function"==typeof(i=null!=(i=h(t,"node")||(null!=e?h(e,"node"):e))?i:c)?i.call(s,{name:"node",hash:{},data:a,loc:{start:{line:70,column:71},end:{line:70,column:79}}}):i)+'" data-topic="'+u(n.lambda(null!=r[1]?h(r[1],"name"):r[1],e))+'" style="padding: 0 6px; border: 0;">✘</button>\n'+(null!=(i=h(t,"if").call(s,null!=e?h(e,"show_broadcast_address"):e,{name:"if",hash:{},fn:n.program(19,a,0,o,r),inverse:n.program(21,a,0,o,r),data:a,loc:{start:{line:71,column:16},end:{line:75,column:23}}}))?i:"")+"                "+(null!=(i=h(t,"if").call(s,null!=e?h(e,"paused"):e,{name:"if",hash:{},fn:n.program(23,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:76,column:16},end:{line:76,column:85}}}))?i:"")+"\n            </td>\n            <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"depth"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:78,column:16},end:{line:78,column:33}}}))+"</td>\n            <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"memory_depth"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:79,column:16},end:{line:79,column:40}}}))+" + "+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"backend_depth"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:79,column:43},end:{line:79,column:68}}}))+"</td>\n            <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"message_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:80,column:16},end:{line:80,column:41}}}))+"</td>\n"+(null!=(i=h(t,"if").call(s,null!=r[1]?h(r[1],"graph_active"):r[1],{name:"if",hash:{},fn:n.program(25,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:81,column:12},end:{line:83,column:19}}}))?i:"")+"            <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=(i=null!=e?h(e,"channels"):e)?h(i,"length"):i,{name:"commafy",hash:{},data:a,loc:{start:{line:84,column:16},end:{line:84,column:48}}}))+"</td>\n"+(null!=(i=h(t,"if").call(s,null!=(i=null!=(i=null!=e?h(e,"e2e_processing_latency"):e)?h(i,"percentiles"):i)?h(i,"length"):i,{name:"if",hash:{},fn:n.program(27,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:85,column:12},end:{line:91,column:19}}}))?i:"")+"        </tr>\n"+(null!=(i=h(t,"if").call(s,null!=r[1]?h(r[1],"graph_active"):r[1],{name:"if",hash:{},fn:n.program(30,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:93,column:8},end:{line:107,column:15}}}))?i:"")},19:function(n,e,t,l,a){var o=null!=e?e:n.nullContext||{},r=n.hooks.helperMissing,i="function",s=n.escapeExpression,c=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return"                "+s(typeof(n=null!=(n=c(t,"hostname_port")||(null!=e?c(e,"hostname_port"):e))?n:r)==i?n.call(o,{name:"hostname_port",hash:{},data:a,loc:{start:{line:72,column:16},end:{line:72,column:33}}}):n)+' (<a class="link" href="'+s((c(t,"basePath")||e&&c(e,"basePath")||r).call(o,"/nodes",{name:"basePath",hash:{},data:a,loc:{start:{line:72,column:57},end:{line:72,column:78}}}))+"/"+s(typeof(n=null!=(n=c(t,"node")||(null!=e?c(e,"node"):e))?n:r)==i?n.call(o,{name:"node",hash:{},data:a,loc:{start:{line:72,column:79},end:{line:72,column:87}}}):n)+'">'+s(typeof(n=null!=(n=c(t,"node")||(null!=e?c(e,"node"):e))?n:r)==i?n.call(o,{name:"node",hash:{},data:a,loc:{start:{line:72,column:89},end:{line:72,column:97}}}):n)+"</a>)\n"},21:function(n,e,t,l,a){var o=null!=e?e:n.nullContext||{},r=n.hooks.helperMissing,i=n.escapeExpression,s="function",c=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'                <a class="link" href="'+i((c(t,"basePath")||e&&c(e,"basePath")||r).call(o,"/nodes",{name:"basePath",hash:{},data:a,loc:{start:{line:74,column:38},end:{line:74,column:59}}}))+"/"+i(typeof(n=null!=(n=c(t,"node")||(null!=e?c(e,"node"):e))?n:r)==s?n.call(o,{name:"node",hash:{},data:a,loc:{start:{line:74,column:60},end:{line:74,column:68}}}):n)+'">'+i(typeof(n=null!=(n=c(t,"hostname_port")||(null!=e?c(e,"hostname_port"):e))?n:r)==s?n.call(o,{name:"hostname_port",hash:{},data:a,loc:{start:{line:74,column:70},end:{line:74,column:87}}}):n)+"</a>\n"},23:function(n,e,t,l,a){return' <span class="label label-primary">paused</span>'},25:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'                <td class="bold rate" target="'+n.escapeExpression((o(t,"rate")||e&&o(e,"rate")||n.hooks.helperMissing).call(null!=e?e:n.nullContext||{},"topic",null!=e?o(e,"node"):e,null!=e?o(e,"topic_name"):e,"",{name:"rate",hash:{},data:a,loc:{start:{line:82,column:46},end:{line:82,column:81}}}))+'"></td>\n'},27:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return null!=(e=o(t,"each").call(null!=e?e:n.nullContext||{},null!=(e=null!=e?o(e,"e2e_processing_latency"):e)?o(e,"percentiles"):e,{name:"each",hash:{},fn:n.program(28,a,0),inverse:n.noop,data:a,loc:{start:{line:86,column:16},end:{line:90,column:25}}}))?e:""},28:function(n,e,t,l,a){var o=null!=e?e:n.nullContext||{},r=n.hooks.helperMissing,i=n.escapeExpression,n=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'                <td>\n                    <span title="'+i((n(t,"floatToPercent")||e&&n(e,"floatToPercent")||r).call(o,null!=e?n(e,"quantile"):e,{name:"floatToPercent",hash:{},data:a,loc:{start:{line:88,column:33},end:{line:88,column:60}}}))+": min = "+i((n(t,"nanotohuman")||e&&n(e,"nanotohuman")||r).call(o,null!=e?n(e,"min"):e,{name:"nanotohuman",hash:{},data:a,loc:{start:{line:88,column:68},end:{line:88,column:87}}}))+", max = "+i((n(t,"nanotohuman")||e&&n(e,"nanotohuman")||r).call(o,null!=e?n(e,"max"):e,{name:"nanotohuman",hash:{},data:a,loc:{start:{line:88,column:95},end:{line:88,column:114}}}))+'">'+i((n(t,"nanotohuman")||e&&n(e,"nanotohuman")||r).call(o,null!=e?n(e,"average"):e,{name:"nanotohuman",hash:{},data:a,loc:{start:{line:88,column:116},end:{line:88,column:139}}}))+"</span>\n                </td>\n"},30:function(n,e,t,l,a){var o=null!=e?e:n.nullContext||{},r=n.hooks.helperMissing,i=n.escapeExpression,s=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'            <tr class="graph-row">\n                <td></td>\n                <td><a href="'+i((s(t,"large_graph")||e&&s(e,"large_graph")||r).call(o,"topic",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,"","depth",{name:"large_graph",hash:{},data:a,loc:{start:{line:96,column:29},end:{line:96,column:79}}}))+'"><img width="120" src="'+i((s(t,"sparkline")||e&&s(e,"sparkline")||r).call(o,"topic",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,"","depth",{name:"sparkline",hash:{},data:a,loc:{start:{line:96,column:103},end:{line:96,column:151}}}))+'"></a></td>\n                <td></td>\n                <td><a href="'+i((s(t,"large_graph")||e&&s(e,"large_graph")||r).call(o,"topic",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,"","message_count",{name:"large_graph",hash:{},data:a,loc:{start:{line:98,column:29},end:{line:98,column:87}}}))+'"><img width="120" src="'+i((s(t,"sparkline")||e&&s(e,"sparkline")||r).call(o,"topic",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,"","message_count",{name:"sparkline",hash:{},data:a,loc:{start:{line:98,column:111},end:{line:98,column:167}}}))+'"></a></td>\n                <td></td>\n                <td></td>\n'+(null!=(e=s(t,"if").call(o,null!=(e=null!=(e=null!=e?s(e,"e2e_processing_latency"):e)?s(e,"percentiles"):e)?s(e,"length"):e,{name:"if",hash:{},fn:n.program(31,a,0),inverse:n.noop,data:a,loc:{start:{line:101,column:16},end:{line:105,column:23}}}))?e:"")+"            </tr>\n"},31:function(n,e,t,l,a){var o=n.escapeExpression,r=null!=e?e:n.nullContext||{},i=n.hooks.helperMissing,s=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'                <td colspan="'+o(n.lambda(null!=(n=null!=(n=null!=e?s(e,"e2e_processing_latency"):e)?s(n,"percentiles"):n)?s(n,"length"):n,e))+'">\n                    <a href="'+o((s(t,"large_graph")||e&&s(e,"large_graph")||i).call(r,"e2e",null!=e?s(e,"node"):e,null!=e?s(e,"e2e_processing_latency"):e,"","e2e_processing_latency",{name:"large_graph",hash:{},data:a,loc:{start:{line:103,column:29},end:{line:103,column:106}}}))+'"><img width="120" height="20"  src="'+o((s(t,"sparkline")||e&&s(e,"sparkline")||i).call(r,"e2e",null!=e?s(e,"node"):e,null!=e?s(e,"e2e_processing_latency"):e,"","e2e_processing_latency",{name:"sparkline",hash:{},data:a,loc:{start:{line:103,column:143},end:{line:103,column:218}}}))+'"></a>\n                </td>\n'},33:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'<td class="bold rate" target="'+n.escapeExpression((o(t,"rate")||e&&o(e,"rate")||n.hooks.helperMissing).call(null!=e?e:n.nullContext||{},"topic","*",null!=e?o(e,"topic_name"):e,"",{name:"rate",hash:{},data:a,loc:{start:{line:114,column:62},end:{line:114,column:96}}}))+'"></td>'},35:function(n,e,t,l,a){var o=null!=e?e:n.nullContext||{},r=n.hooks.helperMissing,i=n.escapeExpression,s=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'            <tr class="graph-row">\n                <td></td>\n                <td><a href="'+i((s(t,"large_graph")||e&&s(e,"large_graph")||r).call(o,"topic","*",null!=e?s(e,"topic_name"):e,"","depth",{name:"large_graph",hash:{},data:a,loc:{start:{line:127,column:29},end:{line:127,column:78}}}))+'"><img width="120" src="'+i((s(t,"sparkline")||e&&s(e,"sparkline")||r).call(o,"topic",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,"","depth",{name:"sparkline",hash:{},data:a,loc:{start:{line:127,column:102},end:{line:127,column:150}}}))+'"></a></td>\n                <td></td>\n                <td><a href="'+i((s(t,"large_graph")||e&&s(e,"large_graph")||r).call(o,"topic","*",null!=e?s(e,"topic_name"):e,"","message_count",{name:"large_graph",hash:{},data:a,loc:{start:{line:129,column:29},end:{line:129,column:86}}}))+'"><img width="120" src="'+i((s(t,"sparkline")||e&&s(e,"sparkline")||r).call(o,"topic",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,"","message_count",{name:"sparkline",hash:{},data:a,loc:{start:{line:129,column:110},end:{line:129,column:166}}}))+'"></a></td>\n                <td></td>\n                <td></td>\n'+(null!=(e=s(t,"if").call(o,null!=(e=null!=(e=null!=e?s(e,"e2e_processing_latency"):e)?s(e,"percentiles"):e)?s(e,"length"):e,{name:"if",hash:{},fn:n.program(36,a,0),inverse:n.noop,data:a,loc:{start:{line:132,column:16},end:{line:136,column:23}}}))?e:"")+"            </tr>\n"},36:function(n,e,t,l,a){var o=n.escapeExpression,r=null!=e?e:n.nullContext||{},i=n.hooks.helperMissing,s=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'                <td colspan="'+o(n.lambda(null!=(n=null!=(n=null!=e?s(e,"e2e_processing_latency"):e)?s(n,"percentiles"):n)?s(n,"length"):n,e))+'">\n                    <a href="'+o((s(t,"large_graph")||e&&s(e,"large_graph")||i).call(r,"e2e","*",null!=e?s(e,"e2e_processing_latency"):e,"","e2e_processing_latency",{name:"large_graph",hash:{},data:a,loc:{start:{line:134,column:29},end:{line:134,column:105}}}))+'"><img width="120" height="20"  src="'+o((s(t,"sparkline")||e&&s(e,"sparkline")||i).call(r,"e2e",null!=e?s(e,"node"):e,null!=e?s(e,"e2e_processing_latency"):e,"","e2e_processing_latency",{name:"sparkline",hash:{},data:a,loc:{start:{line:134,column:142},end:{line:134,column:217}}}))+'"></a>\n                </td>\n'},38:function(n,e,t,l,a){return'    <div class="col-md-6">\n        <h4>Channel Message Queues</h4>\n        <div class="alert alert-warning">\n            <h4>Notice</h4> No channels exist for this topic.\n            <p>Messages will queue at the topic until a channel is created.\n        </div>\n'},40:function(n,e,t,l,a,o,r){var i,s=null!=e?e:n.nullContext||{},c=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'    <div class="col-md-12">\n        <h4>Channel Message Queues</h4>\n        <table class="table table-bordered table-condensed">\n'+(null!=(i=c(t,"if").call(s,null!=(i=null!=(i=null!=e?c(e,"e2e_processing_latency"):e)?c(i,"percentiles"):i)?c(i,"length"):i,{name:"if",hash:{},fn:n.program(41,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:157,column:12},end:{line:162,column:19}}}))?i:"")+"            <tr>\n                <th>Channel</th>\n                <th>Depth</th>\n                <th>Memory + Disk</th>\n                <th>In-Flight</th>\n                <th>Deferred</th>\n                <th>Requeued</th>\n                <th>Timed Out</th>\n                <th>Messages</th>\n                <th>Connections</th>\n"+(null!=(i=c(t,"each").call(s,null!=(i=null!=e?c(e,"e2e_processing_latency"):e)?c(i,"percentiles"):i,{name:"each",hash:{},fn:n.program(16,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:173,column:16},end:{line:175,column:25}}}))?i:"")+"            </tr>\n\n"+(null!=(i=c(t,"each").call(s,null!=e?c(e,"channels"):e,{name:"each",hash:{},fn:n.program(46,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:178,column:12},end:{line:218,column:21}}}))?i:"")+"        </table>\n"},41:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'            <tr>\n                <th colspan="'+(null!=(a=o(t,"if").call(null!=e?e:n.nullContext||{},null!=e?o(e,"graph_active"):e,{name:"if",hash:{},fn:n.program(42,a,0),inverse:n.program(44,a,0),data:a,loc:{start:{line:159,column:29},end:{line:159,column:67}}}))?a:"")+'"></th>\n                <th colspan="'+n.escapeExpression(n.lambda(null!=(a=null!=(a=null!=e?o(e,"e2e_processing_latency"):e)?o(a,"percentiles"):a)?o(a,"length"):a,e))+'">E2E Processing Latency</th>\n            </tr>\n'},42:function(n,e,t,l,a){return"10"},44:function(n,e,t,l,a){return"9"},46:function(n,e,t,l,a,o,r){var i,s=null!=e?e:n.nullContext||{},c=n.hooks.helperMissing,u=n.escapeExpression,h=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'            <tr>\n                <th>\n                    <a class="link" href="'+u((h(t,"basePath")||e&&h(e,"basePath")||c).call(s,"/topics",{name:"basePath",hash:{},data:a,loc:{start:{line:181,column:42},end:{line:181,column:64}}}))+"/"+u((h(t,"urlencode")||e&&h(e,"urlencode")||c).call(s,null!=e?h(e,"topic_name"):e,{name:"urlencode",hash:{},data:a,loc:{start:{line:181,column:65},end:{line:181,column:89}}}))+"/"+u((h(t,"urlencode")||e&&h(e,"urlencode")||c).call(s,null!=e?h(e,"channel_name"):e,{name:"urlencode",hash:{},data:a,loc:{start:{line:181,column:90},end:{line:181,column:116}}}))+'">'+u("function"==typeof(i=null!=(i=h(t,"channel_name")||(null!=e?h(e,"channel_name"):e))?i:c)?i.call(s,{name:"channel_name",hash:{},data:a,loc:{start:{line:181,column:118},end:{line:181,column:134}}}):i)+"</a>\n                    "+(null!=(i=h(t,"if").call(s,null!=e?h(e,"paused"):e,{name:"if",hash:{},fn:n.program(47,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:182,column:20},end:{line:182,column:88}}}))?i:"")+"\n                </th>\n                <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"depth"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:184,column:20},end:{line:184,column:37}}}))+"</td>\n                <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"memory_depth"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:185,column:20},end:{line:185,column:44}}}))+" + "+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"backend_depth"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:185,column:47},end:{line:185,column:72}}}))+"</td>\n                <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"in_flight_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:186,column:20},end:{line:186,column:47}}}))+"</td>\n                <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"deferred_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:187,column:20},end:{line:187,column:46}}}))+"</td>\n                <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"requeue_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:188,column:20},end:{line:188,column:45}}}))+"</td>\n                <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"timeout_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:189,column:20},end:{line:189,column:45}}}))+"</td>\n                <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"message_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:190,column:20},end:{line:190,column:45}}}))+"</td>\n                <td>"+u((h(t,"commafy")||e&&h(e,"commafy")||c).call(s,null!=e?h(e,"client_count"):e,{name:"commafy",hash:{},data:a,loc:{start:{line:191,column:20},end:{line:191,column:44}}}))+"</td>\n"+(null!=(i=h(t,"if").call(s,null!=(i=null!=(i=null!=e?h(e,"e2e_processing_latency"):e)?h(i,"percentiles"):i)?h(i,"length"):i,{name:"if",hash:{},fn:n.program(49,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:192,column:16},end:{line:198,column:23}}}))?i:"")+"            </tr>\n"+(null!=(i=h(t,"if").call(s,null!=r[1]?h(r[1],"graph_active"):r[1],{name:"if",hash:{},fn:n.program(52,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:200,column:12},end:{line:217,column:19}}}))?i:"")},47:function(n,e,t,l,a){return'<span class="label label-primary">paused</span>'},49:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return null!=(e=o(t,"each").call(null!=e?e:n.nullContext||{},null!=(e=null!=e?o(e,"e2e_processing_latency"):e)?o(e,"percentiles"):e,{name:"each",hash:{},fn:n.program(50,a,0),inverse:n.noop,data:a,loc:{start:{line:193,column:20},end:{line:197,column:27}}}))?e:""},50:function(n,e,t,l,a){var o=null!=e?e:n.nullContext||{},r=n.hooks.helperMissing,i=n.escapeExpression,n=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'                    <td>\n                        <span title="'+i((n(t,"floatToPercent")||e&&n(e,"floatToPercent")||r).call(o,null!=e?n(e,"quantile"):e,{name:"floatToPercent",hash:{},data:a,loc:{start:{line:195,column:37},end:{line:195,column:64}}}))+": min = "+i((n(t,"nanotohuman")||e&&n(e,"nanotohuman")||r).call(o,null!=e?n(e,"min"):e,{name:"nanotohuman",hash:{},data:a,loc:{start:{line:195,column:72},end:{line:195,column:91}}}))+", max = "+i((n(t,"nanotohuman")||e&&n(e,"nanotohuman")||r).call(o,null!=e?n(e,"max"):e,{name:"nanotohuman",hash:{},data:a,loc:{start:{line:195,column:99},end:{line:195,column:118}}}))+'">'+i((n(t,"nanotohuman")||e&&n(e,"nanotohuman")||r).call(o,null!=e?n(e,"average"):e,{name:"nanotohuman",hash:{},data:a,loc:{start:{line:195,column:120},end:{line:195,column:143}}}))+"</span>\n                    </td>\n"},52:function(n,e,t,l,a){var o=null!=e?e:n.nullContext||{},r=n.hooks.helperMissing,i=n.escapeExpression,s=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'            <tr class="graph-row">\n                <td></td>\n                <td><a href="'+i((s(t,"large_graph")||e&&s(e,"large_graph")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"depth",{name:"large_graph",hash:{},data:a,loc:{start:{line:203,column:29},end:{line:203,column:91}}}))+'"><img width="120" height="20"  src="'+i((s(t,"sparkline")||e&&s(e,"sparkline")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"depth",{name:"sparkline",hash:{},data:a,loc:{start:{line:203,column:128},end:{line:203,column:188}}}))+'"></a></td>\n                <td></td>\n                <td><a href="'+i((s(t,"large_graph")||e&&s(e,"large_graph")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"in_flight_count",{name:"large_graph",hash:{},data:a,loc:{start:{line:205,column:29},end:{line:205,column:101}}}))+'"><img width="120" height="20"  src="'+i((s(t,"sparkline")||e&&s(e,"sparkline")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"in_flight_count",{name:"sparkline",hash:{},data:a,loc:{start:{line:205,column:138},end:{line:205,column:208}}}))+'"></a></td>\n                <td><a href="'+i((s(t,"large_graph")||e&&s(e,"large_graph")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"deferred_count",{name:"large_graph",hash:{},data:a,loc:{start:{line:206,column:29},end:{line:206,column:100}}}))+'"><img width="120" height="20"  src="'+i((s(t,"sparkline")||e&&s(e,"sparkline")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"deferred_count",{name:"sparkline",hash:{},data:a,loc:{start:{line:206,column:137},end:{line:206,column:206}}}))+'"></a></td>\n                <td><a href="'+i((s(t,"large_graph")||e&&s(e,"large_graph")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"requeue_count",{name:"large_graph",hash:{},data:a,loc:{start:{line:207,column:29},end:{line:207,column:99}}}))+'"><img width="120" height="20"  src="'+i((s(t,"sparkline")||e&&s(e,"sparkline")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"requeue_count",{name:"sparkline",hash:{},data:a,loc:{start:{line:207,column:136},end:{line:207,column:204}}}))+'"></a></td>\n                <td><a href="'+i((s(t,"large_graph")||e&&s(e,"large_graph")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"timeout_count",{name:"large_graph",hash:{},data:a,loc:{start:{line:208,column:29},end:{line:208,column:99}}}))+'"><img width="120" height="20"  src="'+i((s(t,"sparkline")||e&&s(e,"sparkline")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"timeout_count",{name:"sparkline",hash:{},data:a,loc:{start:{line:208,column:136},end:{line:208,column:204}}}))+'"></a></td>\n                <td><a href="'+i((s(t,"large_graph")||e&&s(e,"large_graph")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"message_count",{name:"large_graph",hash:{},data:a,loc:{start:{line:209,column:29},end:{line:209,column:99}}}))+'"><img width="120" height="20"  src="'+i((s(t,"sparkline")||e&&s(e,"sparkline")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"message_count",{name:"sparkline",hash:{},data:a,loc:{start:{line:209,column:136},end:{line:209,column:204}}}))+'"></a></td>\n                <td><a href="'+i((s(t,"large_graph")||e&&s(e,"large_graph")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"clients",{name:"large_graph",hash:{},data:a,loc:{start:{line:210,column:29},end:{line:210,column:93}}}))+'"><img width="120" height="20"  src="'+i((s(t,"sparkline")||e&&s(e,"sparkline")||r).call(o,"channel",null!=e?s(e,"node"):e,null!=e?s(e,"topic_name"):e,null!=e?s(e,"channel_name"):e,"clients",{name:"sparkline",hash:{},data:a,loc:{start:{line:210,column:130},end:{line:210,column:192}}}))+'"></a></td>\n'+(null!=(e=s(t,"if").call(o,null!=(e=null!=(e=null!=e?s(e,"e2e_processing_latency"):e)?s(e,"percentiles"):e)?s(e,"length"):e,{name:"if",hash:{},fn:n.program(53,a,0),inverse:n.noop,data:a,loc:{start:{line:211,column:16},end:{line:215,column:23}}}))?e:"")+"            </tr>\n"},53:function(n,e,t,l,a){var o=n.escapeExpression,r=null!=e?e:n.nullContext||{},i=n.hooks.helperMissing,s=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'                    <td colspan="'+o(n.lambda(null!=(n=null!=(n=null!=e?s(e,"e2e_processing_latency"):e)?s(n,"percentiles"):n)?s(n,"length"):n,e))+'">\n                        <a href="'+o((s(t,"large_graph")||e&&s(e,"large_graph")||i).call(r,"e2e",null!=e?s(e,"node"):e,null!=e?s(e,"e2e_processing_latency"):e,"","e2e_processing_latency",{name:"large_graph",hash:{},data:a,loc:{start:{line:213,column:33},end:{line:213,column:110}}}))+'"><img width="120" height="20"  src="'+o((s(t,"sparkline")||e&&s(e,"sparkline")||i).call(r,"e2e",null!=e?s(e,"node"):e,null!=e?s(e,"e2e_processing_latency"):e,"","e2e_processing_latency",{name:"sparkline",hash:{},data:a,loc:{start:{line:213,column:147},end:{line:213,column:222}}}))+'"></a>\n                    </td>\n'},compiler:[8,">= 4.3.0"],main:function(n,e,t,l,a,o,r){var i,s=null!=e?e:n.nullContext||{},c=n.hooks.helperMissing,u=n.escapeExpression,h="function",p=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return(null!=(i=n.invokePartial(p(l,"warning"),e,{name:"warning",data:a,helpers:t,partials:l,decorators:n.decorators}))?i:"")+(null!=(i=n.invokePartial(p(l,"error"),e,{name:"error",data:a,helpers:t,partials:l,decorators:n.decorators}))?i:"")+'\n<ol class="breadcrumb">\n  <li><a class="link" href="'+u((p(t,"basePath")||e&&p(e,"basePath")||c).call(s,"/",{name:"basePath",hash:{},data:a,loc:{start:{line:5,column:28},end:{line:5,column:44}}}))+'">Streams</a>\n  <li class="active">'+u(typeof(l=null!=(l=p(t,"name")||(null!=e?p(e,"name"):e))?l:c)==h?l.call(s,{name:"name",hash:{},data:a,loc:{start:{line:6,column:21},end:{line:6,column:29}}}):l)+'</li>\n</ol>\n\n<div class="row">\n    <div class="col-md-6">\n        <blockquote>\n            <p>Topic: <strong>'+u(typeof(l=null!=(l=p(t,"name")||(null!=e?p(e,"name"):e))?l:c)==h?l.call(s,{name:"name",hash:{},data:a,loc:{start:{line:12,column:30},end:{line:12,column:38}}}):l)+"</strong>\n        </blockquote>\n    </div>\n</div>\n\n"+(null!=(i=p(t,"unless").call(s,null!=(i=null!=e?p(e,"nodes"):e)?p(i,"length"):i,{name:"unless",hash:{},fn:n.program(1,a,0,o,r),inverse:n.program(3,a,0,o,r),data:a,loc:{start:{line:17,column:0},end:{line:142,column:11}}}))?i:"")+'\n\n<div class="row">\n'+(null!=(i=p(t,"unless").call(s,null!=(i=null!=e?p(e,"channels"):e)?p(i,"length"):i,{name:"unless",hash:{},fn:n.program(38,a,0,o,r),inverse:n.program(40,a,0,o,r),data:a,loc:{start:{line:146,column:4},end:{line:220,column:19}}}))?i:"")+"    </div>\n</div>\n"},usePartial:!0,useData:!0,useDepths:!0})},{"hbsfy/runtime":35}],64:[function(t,n,e){var l=t("jquery");window.jQuery=l;t("bootstrap");var a=t("bootbox"),o=t("../lib/pubsub"),r=t("../app_state"),i=t("./base"),s=i.extend({className:"topic container-fluid",template:t("./spinner.hbs"),events:{"click .topic-actions button":"topicAction"},initialize:function(){i.prototype.initialize.apply(this,arguments),this.listenTo(r,"change:graph_interval",this.render);var e=this.model.get("isAdmin");this.model.fetch().done(function(n){this.template=t("./topic.hbs"),this.render({message:n.message,isAdmin:e})}.bind(this)).fail(this.handleViewError.bind(this)).always(o.trigger.bind(o,"view:ready"))},topicAction:function(n){n.preventDefault(),n.stopPropagation();var e=l(n.currentTarget).data("action"),n="Are you sure you want to <strong>"+e+"</strong> <em>"+this.model.get("name")+"</em>?";a.confirm(n,function(n){!0===n&&("delete"===e?l.ajax(this.model.url(),{method:"DELETE"}).done(function(){window.location=r.basePath("/")}):l.post(this.model.url(),JSON.stringify({action:e})).done(function(){window.location.reload(!0)}).fail(this.handleAJAXError.bind(this)))}.bind(this))}});n.exports=s},{"../app_state":36,"../lib/pubsub":41,"./base":48,"./spinner.hbs":62,"./topic.hbs":63,bootbox:void 0,bootstrap:1,jquery:void 0}],65:[function(n,e,t){n=n("hbsfy/runtime");e.exports=n.template({1:function(n,e,t,l,a,o,r){var i,s=null!=e?e:n.nullContext||{},c=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'        <table class="table table-condensed table-bordered">\n            <tr>\n                <th>Topic</th>\n                '+(null!=(i=c(t,"if").call(s,null!=e?c(e,"graph_active"):e,{name:"if",hash:{},fn:n.program(2,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:16,column:16},end:{line:16,column:69}}}))?i:"")+"\n                "+(null!=(i=c(t,"if").call(s,null!=e?c(e,"graph_active"):e,{name:"if",hash:{},fn:n.program(4,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:17,column:16},end:{line:17,column:72}}}))?i:"")+"\n                "+(null!=(i=c(t,"if").call(s,null!=e?c(e,"graph_active"):e,{name:"if",hash:{},fn:n.program(6,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:18,column:16},end:{line:18,column:68}}}))?i:"")+"\n            </tr>\n"+(null!=(i=c(t,"each").call(s,null!=e?c(e,"collection"):e,{name:"each",hash:{},fn:n.program(8,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:20,column:12},end:{line:27,column:21}}}))?i:"")+"        </table>\n"},2:function(n,e,t,l,a){return'<th width="120">Depth</th>'},4:function(n,e,t,l,a){return'<th width="120">Messages</th>'},6:function(n,e,t,l,a){return'<th width="120">Rate</th>'},8:function(n,e,t,l,a,o,r){var i=null!=e?e:n.nullContext||{},s=n.hooks.helperMissing,c=n.escapeExpression,u=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'            <tr>\n                <td><a class="link" href="'+c((u(t,"basePath")||e&&u(e,"basePath")||s).call(i,"/topics",{name:"basePath",hash:{},data:a,loc:{start:{line:22,column:42},end:{line:22,column:64}}}))+"/"+c((u(t,"urlencode")||e&&u(e,"urlencode")||s).call(i,null!=e?u(e,"name"):e,{name:"urlencode",hash:{},data:a,loc:{start:{line:22,column:65},end:{line:22,column:83}}}))+'">'+c("function"==typeof(e=null!=(e=u(t,"name")||(null!=e?u(e,"name"):e))?e:s)?e.call(i,{name:"name",hash:{},data:a,loc:{start:{line:22,column:85},end:{line:22,column:93}}}):e)+"</a></td>\n                "+(null!=(e=u(t,"if").call(i,null!=r[1]?u(r[1],"graph_active"):r[1],{name:"if",hash:{},fn:n.program(9,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:23,column:16},end:{line:23,column:200}}}))?e:"")+"\n                "+(null!=(e=u(t,"if").call(i,null!=r[1]?u(r[1],"graph_active"):r[1],{name:"if",hash:{},fn:n.program(11,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:24,column:16},end:{line:24,column:208}}}))?e:"")+"\n                "+(null!=(e=u(t,"if").call(i,null!=r[1]?u(r[1],"graph_active"):r[1],{name:"if",hash:{},fn:n.program(13,a,0,o,r),inverse:n.noop,data:a,loc:{start:{line:25,column:16},end:{line:25,column:111}}}))?e:"")+"\n            </tr>\n"},9:function(n,e,t,l,a){var o=null!=e?e:n.nullContext||{},r=n.hooks.helperMissing,i=n.escapeExpression,n=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'<td><a class="link" href="'+i((n(t,"basePath")||e&&n(e,"basePath")||r).call(o,"/topics",{name:"basePath",hash:{},data:a,loc:{start:{line:23,column:65},end:{line:23,column:87}}}))+"/"+i((n(t,"urlencode")||e&&n(e,"urlencode")||r).call(o,null!=e?n(e,"name"):e,{name:"urlencode",hash:{},data:a,loc:{start:{line:23,column:88},end:{line:23,column:106}}}))+'"><img width="120" height="20" src="'+i((n(t,"sparkline")||e&&n(e,"sparkline")||r).call(o,"topic","",null!=e?n(e,"name"):e,"","depth",{name:"sparkline",hash:{},data:a,loc:{start:{line:23,column:142},end:{line:23,column:182}}}))+'"></a></td>'},11:function(n,e,t,l,a){var o=null!=e?e:n.nullContext||{},r=n.hooks.helperMissing,i=n.escapeExpression,n=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'<td><a class="link" href="'+i((n(t,"basePath")||e&&n(e,"basePath")||r).call(o,"/topics",{name:"basePath",hash:{},data:a,loc:{start:{line:24,column:65},end:{line:24,column:87}}}))+"/"+i((n(t,"urlencode")||e&&n(e,"urlencode")||r).call(o,null!=e?n(e,"name"):e,{name:"urlencode",hash:{},data:a,loc:{start:{line:24,column:88},end:{line:24,column:106}}}))+'"><img width="120" height="20" src="'+i((n(t,"sparkline")||e&&n(e,"sparkline")||r).call(o,"topic","",null!=e?n(e,"name"):e,"","message_count",{name:"sparkline",hash:{},data:a,loc:{start:{line:24,column:142},end:{line:24,column:190}}}))+'"></a></td>'},13:function(n,e,t,l,a){var o=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'<td class="bold rate" target="'+n.escapeExpression((o(t,"rate")||e&&o(e,"rate")||n.hooks.helperMissing).call(null!=e?e:n.nullContext||{},"topic","*",null!=e?o(e,"name"):e,"",{name:"rate",hash:{},data:a,loc:{start:{line:25,column:69},end:{line:25,column:97}}}))+'"></td>'},15:function(n,e,t,l,a){return'        <div class="alert alert-warning"><h4>Notice</h4>No Topics Found</div>\n'},compiler:[8,">= 4.3.0"],main:function(n,e,t,l,a,o,r){var i,s=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return(null!=(i=n.invokePartial(s(l,"warning"),e,{name:"warning",data:a,helpers:t,partials:l,decorators:n.decorators}))?i:"")+(null!=(i=n.invokePartial(s(l,"error"),e,{name:"error",data:a,helpers:t,partials:l,decorators:n.decorators}))?i:"")+'\n<div class="row">\n    <div class="col-md-12">\n        <h2>Topics</h2>\n    </div>\n</div>\n\n<div class="row">\n    <div class="col-md-6">\n'+(null!=(i=s(t,"if").call(null!=e?e:n.nullContext||{},null!=(i=null!=e?s(e,"collection"):e)?s(i,"length"):i,{name:"if",hash:{},fn:n.program(1,a,0,o,r),inverse:n.program(15,a,0,o,r),data:a,loc:{start:{line:12,column:4},end:{line:31,column:11}}}))?i:"")+"    </div>\n</div>\n"},usePartial:!0,useData:!0,useDepths:!0})},{"hbsfy/runtime":35}],66:[function(e,n,t){var l=e("../lib/pubsub"),a=e("../app_state"),o=e("./base"),r=e("../collections/topics"),i=o.extend({className:"topics container-fluid",template:e("./spinner.hbs"),initialize:function(){o.prototype.initialize.apply(this,arguments),this.listenTo(a,"change:graph_interval",this.render),this.collection=new r,this.collection.fetch().done(function(n){this.template=e("./topics.hbs"),this.render({message:n.message})}.bind(this)).fail(this.handleViewError.bind(this)).always(l.trigger.bind(l,"view:ready"))}});n.exports=i},{"../app_state":36,"../collections/topics":38,"../lib/pubsub":41,"./base":48,"./spinner.hbs":62,"./topics.hbs":65}],67:[function(n,e,t){n=n("hbsfy/runtime");e.exports=n.template({1:function(n,e,t,l,a){return'style="display: none;"'},compiler:[8,">= 4.3.0"],main:function(n,e,t,l,a){var o,r=null!=e?e:n.nullContext||{},i=n.lookupProperty||function(n,e){if(Object.prototype.hasOwnProperty.call(n,e))return n[e]};return'<div class="row" id="warning" '+(null!=(o=i(t,"unless").call(r,null!=e?i(e,"message"):e,{name:"unless",hash:{},fn:n.program(1,a,0),inverse:n.noop,data:a,loc:{start:{line:1,column:30},end:{line:1,column:82}}}))?o:"")+'>\n    <div class="col-md-12">\n        <div class="alert alert-warning">\n            '+n.escapeExpression("function"==typeof(e=null!=(e=i(t,"message")||(null!=e?i(e,"message"):e))?e:n.hooks.helperMissing)?e.call(r,{name:"message",hash:{},data:a,loc:{start:{line:4,column:12},end:{line:4,column:23}}}):e)+"\n        </div>\n    </div>\n</div>\n"},useData:!0})},{"hbsfy/runtime":35}]},{},[42]);
+!function l(a,o,r){function i(e,n){if(!o[e]){if(!a[e]){var t="function"==typeof require&&require;if(!n&&t)return t(e,!0);if(s)return s(e,!0);throw(t=new Error("Cannot find module '"+e+"'")).code="MODULE_NOT_FOUND",t}t=o[e]={exports:{}},a[e][0].call(t.exports,function(n){return i(a[e][1][n]||n)},t,t.exports,l,a,o,r)}return o[e].exports}for(var s="function"==typeof require&&require,n=0;n<r.length;n++)i(r[n]);return i}({1:[function(n,e,t){n("../../js/transition.js"),n("../../js/alert.js"),n("../../js/button.js"),n("../../js/carousel.js"),n("../../js/collapse.js"),n("../../js/dropdown.js"),n("../../js/modal.js"),n("../../js/tooltip.js"),n("../../js/popover.js"),n("../../js/scrollspy.js"),n("../../js/tab.js"),n("../../js/affix.js")},{"../../js/affix.js":2,"../../js/alert.js":3,"../../js/button.js":4,"../../js/carousel.js":5,"../../js/collapse.js":6,"../../js/dropdown.js":7,"../../js/modal.js":8,"../../js/popover.js":9,"../../js/scrollspy.js":10,"../../js/tab.js":11,"../../js/tooltip.js":12,"../../js/transition.js":13}],2:[function(n,e,t){!function(r){"use strict";var i=function(n,e){this.options=r.extend({},i.DEFAULTS,e);e=this.options.target===i.DEFAULTS.target?r(this.options.target):r(document).find(this.options.target);this.$target=e.on("scroll.bs.affix.data-api",r.proxy(this.checkPosition,this)).on("click.bs.affix.data-api",r.proxy(this.checkPositionWithEventLoop,this)),this.$element=r(n),this.affixed=null,this.unpin=null,this.pinnedOffset=null,this.checkPosition()};function t(t){return this.each(function(){var n=r(this),e=n.data("bs.affix");e||n.data("bs.affix",e=new i(this,"object"==typeof t&&t)),"string"==typeof t&&e[t]()})}i.VERSION="3.4.1",i.RESET="affix affix-top affix-bottom",i.DEFAULTS={offset:0,target:window},i.prototype.getState=function(n,e,t,l){var a=this.$target.scrollTop(),o=this.$element.offset(),r=this.$target.height();if(null!=t&&"top"==this.affixed)return a<t&&"top";if("bottom"==this.affixed)return null!=t?!(a+this.unpin<=o.top)&&"bottom":!(a+r<=n-l)&&"bottom";var i=null==this.affixed,o=i?a:o.top;return null!=t&&a<=t?"top":null!=l&&n-l<=o+(i?r:e)&&"bottom"},i.prototype.getPinnedOffset=function(){if(this.pinnedOffset)return this.pinnedOffset;this.$element.removeClass(i.RESET).addClass("affix");var n=this.$target.scrollTop(),e=this.$element.offset();return this.pinnedOffset=e.top-n},i.prototype.checkPositionWithEventLoop=function(){setTimeout(r.proxy(this.checkPosition,this),1)},i.prototype.checkPosition=function(){if(this.$element.is(":visible")){var n=this.$element.height(),e=this.options.offset,t=e.top,l=e.bottom,a=Math.max(r(document).height(),r(document.body).height());"object"!=typeof e&&(l=t=e),"function"==typeof t&&(t=e.top(this.$element)),"function"==typeof l&&(l=e.bottom(this.$element));var o=this.getState(a,n,t,l);if(this.affixed!=o){null!=this.unpin&&this.$element.css("top","");e="affix"+(o?"-"+o:""),t=r.Event(e+".bs.affix");if(this.$element.trigger(t),t.isDefaultPrevented())return;this.affixed=o,this.unpin="bottom"==o?this.getPinnedOffset():null,this.$element.removeClass(i.RESET).addClass(e).trigger(e.replace("affix","affixed")+".bs.affix")}"bottom"==o&&this.$element.offset({top:a-n-l})}};var n=r.fn.affix;r.fn.affix=t,r.fn.affix.Constructor=i,r.fn.affix.noConflict=function(){return r.fn.affix=n,this},r(window).on("load",function(){r('[data-spy="affix"]').each(function(){var n=r(this),e=n.data();e.offset=e.offset||{},null!=e.offsetBottom&&(e.offset.bottom=e.offsetBottom),null!=e.offsetTop&&(e.offset.top=e.offsetTop),t.call(n,e)})})}(jQuery)},{}],3:[function(n,e,t){!function(o){"use strict";function r(n){o(n).on("click",e,this.close)}var e='[data-dismiss="alert"]';r.VERSION="3.4.1",r.TRANSITION_DURATION=150,r.prototype.close=function(n){var e=o(this),t=e.attr("data-target");t="#"===(t=t||(t=e.attr("href"))&&t.replace(/.*(?=#[^\s]*$)/,""))?[]:t;var l=o(document).find(t);function a(){l.detach().trigger("closed.bs.alert").remove()}n&&n.preventDefault(),(l=!l.length?e.closest(".alert"):l).trigger(n=o.Event("close.bs.alert")),n.isDefaultPrevented()||(l.removeClass("in"),o.support.transition&&l.hasClass("fade")?l.one("bsTransitionEnd",a).emulateTransitionEnd(r.TRANSITION_DURATION):a())};var n=o.fn.alert;o.fn.alert=function(t){return this.each(function(){var n=o(this),e=n.data("bs.alert");e||n.data("bs.alert",e=new r(this)),"string"==typeof t&&e[t].call(n)})},o.fn.alert.Constructor=r,o.fn.alert.noConflict=function(){return o.fn.alert=n,this},o(document).on("click.bs.alert.data-api",e,r.prototype.close)}(jQuery)},{}],4:[function(n,e,t){!function(o){"use strict";var l=function(n,e){this.$element=o(n),this.options=o.extend({},l.DEFAULTS,e),this.isLoading=!1};function t(t){return this.each(function(){var n=o(this),e=n.data("bs.button");e||n.data("bs.button",e=new l(this,"object"==typeof t&&t)),"toggle"==t?e.toggle():t&&e.setState(t)})}l.VERSION="3.4.1",l.DEFAULTS={loadingText:"loading..."},l.prototype.setState=function(n){var e="disabled",t=this.$element,l=t.is("input")?"val":"html",a=t.data();n+="Text",null==a.resetText&&t.data("resetText",t[l]()),setTimeout(o.proxy(function(){t[l]((null==a[n]?this.options:a)[n]),"loadingText"==n?(this.isLoading=!0,t.addClass(e).attr(e,e).prop(e,!0)):this.isLoading&&(this.isLoading=!1,t.removeClass(e).removeAttr(e).prop(e,!1))},this),0)},l.prototype.toggle=function(){var n,e=!0,t=this.$element.closest('[data-toggle="buttons"]');t.length?("radio"==(n=this.$element.find("input")).prop("type")?(n.prop("checked")&&(e=!1),t.find(".active").removeClass("active"),this.$element.addClass("active")):"checkbox"==n.prop("type")&&(n.prop("checked")!==this.$element.hasClass("active")&&(e=!1),this.$element.toggleClass("active")),n.prop("checked",this.$element.hasClass("active")),e&&n.trigger("change")):(this.$element.attr("aria-pressed",!this.$element.hasClass("active")),this.$element.toggleClass("active"))};var n=o.fn.button;o.fn.button=t,o.fn.button.Constructor=l,o.fn.button.noConflict=function(){return o.fn.button=n,this},o(document).on("click.bs.button.data-api",'[data-toggle^="button"]',function(n){var e=o(n.target).closest(".btn");t.call(e,"toggle"),o(n.target).is('input[type="radio"], input[type="checkbox"]')||(n.preventDefault(),(e.is("input,button")?e:e.find("input:visible,button:visible").first()).trigger("focus"))}).on("focus.bs.button.data-api blur.bs.button.data-api",'[data-toggle^="button"]',function(n){o(n.target).closest(".btn").toggleClass("focus",/^focus(in)?$/.test(n.type))})}(jQuery)},{}],5:[function(n,e,t){!function(c){"use strict";function u(n,e){this.$element=c(n),this.$indicators=this.$element.find(".carousel-indicators"),this.options=e,this.paused=null,this.sliding=null,this.interval=null,this.$active=null,this.$items=null,this.options.keyboard&&this.$element.on("keydown.bs.carousel",c.proxy(this.keydown,this)),"hover"!=this.options.pause||"ontouchstart"in document.documentElement||this.$element.on("mouseenter.bs.carousel",c.proxy(this.pause,this)).on("mouseleave.bs.carousel",c.proxy(this.cycle,this))}function a(a){return this.each(function(){var n=c(this),e=n.data("bs.carousel"),t=c.extend({},u.DEFAULTS,n.data(),"object"==typeof a&&a),l="string"==typeof a?a:t.slide;e||n.data("bs.carousel",e=new u(this,t)),"number"==typeof a?e.to(a):l?e[l]():t.interval&&e.pause().cycle()})}u.VERSION="3.4.1",u.TRANSITION_DURATION=600,u.DEFAULTS={interval:5e3,pause:"hover",wrap:!0,keyboard:!0},u.prototype.keydown=function(n){if(!/input|textarea/i.test(n.target.tagName)){switch(n.which){case 37:this.prev();break;case 39:this.next();break;default:return}n.preventDefault()}},u.prototype.cycle=function(n){return n||(this.paused=!1),this.interval&&clearInterval(this.interval),this.options.interval&&!this.paused&&(this.interval=setInterval(c.proxy(this.next,this),this.options.interval)),this},u.prototype.getItemIndex=function(n){return this.$items=n.parent().children(".item"),this.$items.index(n||this.$active)},u.prototype.getItemForDirection=function(n,e){var t=this.getItemIndex(e);if(("prev"==n&&0===t||"next"==n&&t==this.$items.length-1)&&!this.options.wrap)return e;n=(t+("prev"==n?-1:1))%this.$items.length;return this.$items.eq(n)},u.prototype.to=function(n){var e=this,t=this.getItemIndex(this.$active=this.$element.find(".item.active"));if(!(n>this.$items.length-1||n<0))return this.sliding?this.$element.one("slid.bs.carousel",function(){e.to(n)}):t==n?this.pause().cycle():this.slide(t<n?"next":"prev",this.$items.eq(n))},u.prototype.pause=function(n){return n||(this.paused=!0),this.$element.find(".next, .prev").length&&c.support.transition&&(this.$element.trigger(c.support.transition.end),this.cycle(!0)),this.interval=clearInterval(this.interval),this},u.prototype.next=function(){if(!this.sliding)return this.slide("next")},u.prototype.prev=function(){if(!this.sliding)return this.slide("prev")},u.prototype.slide=function(n,e){var t=this.$element.find(".item.active"),l=e||this.getItemForDirection(n,t),a=this.interval,o="next"==n?"left":"right",r=this;if(l.hasClass("active"))return this.sliding=!1;var i=l[0],e=c.Event("slide.bs.carousel",{relatedTarget:i,direction:o});if(this.$element.trigger(e),!e.isDefaultPrevented()){this.sliding=!0,a&&this.pause(),this.$indicators.length&&(this.$indicators.find(".active").removeClass("active"),(e=c(this.$indicators.children()[this.getItemIndex(l)]))&&e.addClass("active"));var s=c.Event("slid.bs.carousel",{relatedTarget:i,direction:o});return c.support.transition&&this.$element.hasClass("slide")?(l.addClass(n),"object"==typeof l&&l.length&&l[0].offsetWidth,t.addClass(o),l.addClass(o),t.one("bsTransitionEnd",function(){l.removeClass([n,o].join(" ")).addClass("active"),t.removeClass(["active",o].join(" ")),r.sliding=!1,setTimeout(function(){r.$element.trigger(s)},0)}).emulateTransitionEnd(u.TRANSITION_DURATION)):(t.removeClass("active"),l.addClass("active"),this.sliding=!1,this.$element.trigger(s)),a&&this.cycle(),this}};var n=c.fn.carousel;c.fn.carousel=a,c.fn.carousel.Constructor=u,c.fn.carousel.noConflict=function(){return c.fn.carousel=n,this};function e(n){var e=c(this),t=(t=e.attr("href"))&&t.replace(/.*(?=#[^\s]+$)/,""),l=e.attr("data-target")||t;(t=c(document).find(l)).hasClass("carousel")&&(l=c.extend({},t.data(),e.data()),(e=e.attr("data-slide-to"))&&(l.interval=!1),a.call(t,l),e&&t.data("bs.carousel").to(e),n.preventDefault())}c(document).on("click.bs.carousel.data-api","[data-slide]",e).on("click.bs.carousel.data-api","[data-slide-to]",e),c(window).on("load",function(){c('[data-ride="carousel"]').each(function(){var n=c(this);a.call(n,n.data())})})}(jQuery)},{}],6:[function(n,e,t){!function(a){"use strict";var o=function(n,e){this.$element=a(n),this.options=a.extend({},o.DEFAULTS,e),this.$trigger=a('[data-toggle="collapse"][href="#'+n.id+'"],[data-toggle="collapse"][data-target="#'+n.id+'"]'),this.transitioning=null,this.options.parent?this.$parent=this.getParent():this.addAriaAndCollapsedClass(this.$element,this.$trigger),this.options.toggle&&this.toggle()};function t(n){var e=n.attr("data-target")||(e=n.attr("href"))&&e.replace(/.*(?=#[^\s]+$)/,"");return a(document).find(e)}function r(l){return this.each(function(){var n=a(this),e=n.data("bs.collapse"),t=a.extend({},o.DEFAULTS,n.data(),"object"==typeof l&&l);!e&&t.toggle&&/show|hide/.test(l)&&(t.toggle=!1),e||n.data("bs.collapse",e=new o(this,t)),"string"==typeof l&&e[l]()})}o.VERSION="3.4.1",o.TRANSITION_DURATION=350,o.DEFAULTS={toggle:!0},o.prototype.dimension=function(){return this.$element.hasClass("width")?"width":"height"},o.prototype.show=function(){if(!this.transitioning&&!this.$element.hasClass("in")){var n=this.$parent&&this.$parent.children(".panel").children(".in, .collapsing");if(!(n&&n.length&&(l=n.data("bs.collapse"))&&l.transitioning)){var e=a.Event("show.bs.collapse");if(this.$element.trigger(e),!e.isDefaultPrevented()){n&&n.length&&(r.call(n,"hide"),l||n.data("bs.collapse",null));var t=this.dimension();this.$element.removeClass("collapse").addClass("collapsing")[t](0).attr("aria-expanded",!0),this.$trigger.removeClass("collapsed").attr("aria-expanded",!0),this.transitioning=1;var l=function(){this.$element.removeClass("collapsing").addClass("collapse in")[t](""),this.transitioning=0,this.$element.trigger("shown.bs.collapse")};if(!a.support.transition)return l.call(this);n=a.camelCase(["scroll",t].join("-"));this.$element.one("bsTransitionEnd",a.proxy(l,this)).emulateTransitionEnd(o.TRANSITION_DURATION)[t](this.$element[0][n])}}}},o.prototype.hide=function(){if(!this.transitioning&&this.$element.hasClass("in")){var n=a.Event("hide.bs.collapse");if(this.$element.trigger(n),!n.isDefaultPrevented()){var e=this.dimension();this.$element[e](this.$element[e]())[0].offsetHeight,this.$element.addClass("collapsing").removeClass("collapse in").attr("aria-expanded",!1),this.$trigger.addClass("collapsed").attr("aria-expanded",!1),this.transitioning=1;n=function(){this.transitioning=0,this.$element.removeClass("collapsing").addClass("collapse").trigger("hidden.bs.collapse")};if(!a.support.transition)return n.call(this);this.$element[e](0).one("bsTransitionEnd",a.proxy(n,this)).emulateTransitionEnd(o.TRANSITION_DURATION)}}},o.prototype.toggle=function(){this[this.$element.hasClass("in")?"hide":"show"]()},o.prototype.getParent=function(){return a(document).find(this.options.parent).find('[data-toggle="collapse"][data-parent="'+this.options.parent+'"]').each(a.proxy(function(n,e){e=a(e);this.addAriaAndCollapsedClass(t(e),e)},this)).end()},o.prototype.addAriaAndCollapsedClass=function(n,e){var t=n.hasClass("in");n.attr("aria-expanded",t),e.toggleClass("collapsed",!t).attr("aria-expanded",t)};var n=a.fn.collapse;a.fn.collapse=r,a.fn.collapse.Constructor=o,a.fn.collapse.noConflict=function(){return a.fn.collapse=n,this},a(document).on("click.bs.collapse.data-api",'[data-toggle="collapse"]',function(n){var e=a(this);e.attr("data-target")||n.preventDefault();n=t(e),e=n.data("bs.collapse")?"toggle":e.data();r.call(n,e)})}(jQuery)},{}],7:[function(n,e,t){!function(a){"use strict";function l(n){a(n).on("click.bs.dropdown",this.toggle)}var o='[data-toggle="dropdown"]';function r(n){var e=n.attr("data-target"),e="#"!==(e=e||(e=n.attr("href"))&&/#[A-Za-z]/.test(e)&&e.replace(/.*(?=#[^\s]*$)/,""))?a(document).find(e):null;return e&&e.length?e:n.parent()}function i(l){l&&3===l.which||(a(".dropdown-backdrop").remove(),a(o).each(function(){var n=a(this),e=r(n),t={relatedTarget:this};e.hasClass("open")&&(l&&"click"==l.type&&/input|textarea/i.test(l.target.tagName)&&a.contains(e[0],l.target)||(e.trigger(l=a.Event("hide.bs.dropdown",t)),l.isDefaultPrevented()||(n.attr("aria-expanded","false"),e.removeClass("open").trigger(a.Event("hidden.bs.dropdown",t)))))}))}l.VERSION="3.4.1",l.prototype.toggle=function(n){var e=a(this);if(!e.is(".disabled, :disabled")){var t=r(e),l=t.hasClass("open");if(i(),!l){"ontouchstart"in document.documentElement&&!t.closest(".navbar-nav").length&&a(document.createElement("div")).addClass("dropdown-backdrop").insertAfter(a(this)).on("click",i);l={relatedTarget:this};if(t.trigger(n=a.Event("show.bs.dropdown",l)),n.isDefaultPrevented())return;e.trigger("focus").attr("aria-expanded","true"),t.toggleClass("open").trigger(a.Event("shown.bs.dropdown",l))}return!1}},l.prototype.keydown=function(n){if(/(38|40|27|32)/.test(n.which)&&!/input|textarea/i.test(n.target.tagName)){var e=a(this);if(n.preventDefault(),n.stopPropagation(),!e.is(".disabled, :disabled")){var t=r(e),l=t.hasClass("open");if(!l&&27!=n.which||l&&27==n.which)return 27==n.which&&t.find(o).trigger("focus"),e.trigger("click");e=t.find(".dropdown-menu li:not(.disabled):visible a");e.length&&(t=e.index(n.target),38==n.which&&0<t&&t--,40==n.which&&t<e.length-1&&t++,e.eq(t=!~t?0:t).trigger("focus"))}}};var n=a.fn.dropdown;a.fn.dropdown=function(t){return this.each(function(){var n=a(this),e=n.data("bs.dropdown");e||n.data("bs.dropdown",e=new l(this)),"string"==typeof t&&e[t].call(n)})},a.fn.dropdown.Constructor=l,a.fn.dropdown.noConflict=function(){return a.fn.dropdown=n,this},a(document).on("click.bs.dropdown.data-api",i).on("click.bs.dropdown.data-api",".dropdown form",function(n){n.stopPropagation()}).on("click.bs.dropdown.data-api",o,l.prototype.toggle).on("keydown.bs.dropdown.data-api",o,l.prototype.keydown).on("keydown.bs.dropdown.data-api",".dropdown-menu",l.prototype.keydown)}(jQuery)},{}],8:[function(n,e,t){!function(o){"use strict";function r(n,e){this.options=e,this.$body=o(document.body),this.$element=o(n),this.$dialog=this.$element.find(".modal-dialog"),this.$backdrop=null,this.isShown=null,this.originalBodyPad=null,this.scrollbarWidth=0,this.ignoreBackdropClick=!1,this.fixedContent=".navbar-fixed-top, .navbar-fixed-bottom",this.options.remote&&this.$element.find(".modal-content").load(this.options.remote,o.proxy(function(){this.$element.trigger("loaded.bs.modal")},this))}function i(l,a){return this.each(function(){var n=o(this),e=n.data("bs.modal"),t=o.extend({},r.DEFAULTS,n.data(),"object"==typeof l&&l);e||n.data("bs.modal",e=new r(this,t)),"string"==typeof l?e[l](a):t.show&&e.show(a)})}r.VERSION="3.4.1",r.TRANSITION_DURATION=300,r.BACKDROP_TRANSITION_DURATION=150,r.DEFAULTS={backdrop:!0,keyboard:!0,show:!0},r.prototype.toggle=function(n){return this.isShown?this.hide():this.show(n)},r.prototype.show=function(t){var l=this,n=o.Event("show.bs.modal",{relatedTarget:t});this.$element.trigger(n),this.isShown||n.isDefaultPrevented()||(this.isShown=!0,this.checkScrollbar(),this.setScrollbar(),this.$body.addClass("modal-open"),this.escape(),this.resize(),this.$element.on("click.dismiss.bs.modal",'[data-dismiss="modal"]',o.proxy(this.hide,this)),this.$dialog.on("mousedown.dismiss.bs.modal",function(){l.$element.one("mouseup.dismiss.bs.modal",function(n){o(n.target).is(l.$element)&&(l.ignoreBackdropClick=!0)})}),this.backdrop(function(){var n=o.support.transition&&l.$element.hasClass("fade");l.$element.parent().length||l.$element.appendTo(l.$body),l.$element.show().scrollTop(0),l.adjustDialog(),n&&l.$element[0].offsetWidth,l.$element.addClass("in"),l.enforceFocus();var e=o.Event("shown.bs.modal",{relatedTarget:t});n?l.$dialog.one("bsTransitionEnd",function(){l.$element.trigger("focus").trigger(e)}).emulateTransitionEnd(r.TRANSITION_DURATION):l.$element.trigger("focus").trigger(e)}))},r.prototype.hide=function(n){n&&n.preventDefault(),n=o.Event("hide.bs.modal"),this.$element.trigger(n),this.isShown&&!n.isDefaultPrevented()&&(this.isShown=!1,this.escape(),this.resize(),o(document).off("focusin.bs.modal"),this.$element.removeClass("in").off("click.dismiss.bs.modal").off("mouseup.dismiss.bs.modal"),this.$dialog.off("mousedown.dismiss.bs.modal"),o.support.transition&&this.$element.hasClass("fade")?this.$element.one("bsTransitionEnd",o.proxy(this.hideModal,this)).emulateTransitionEnd(r.TRANSITION_DURATION):this.hideModal())},r.prototype.enforceFocus=function(){o(document).off("focusin.bs.modal").on("focusin.bs.modal",o.proxy(function(n){document===n.target||this.$element[0]===n.target||this.$element.has(n.target).length||this.$element.trigger("focus")},this))},r.prototype.escape=function(){this.isShown&&this.options.keyboard?this.$element.on("keydown.dismiss.bs.modal",o.proxy(function(n){27==n.which&&this.hide()},this)):this.isShown||this.$element.off("keydown.dismiss.bs.modal")},r.prototype.resize=function(){this.isShown?o(window).on("resize.bs.modal",o.proxy(this.handleUpdate,this)):o(window).off("resize.bs.modal")},r.prototype.hideModal=function(){var n=this;this.$element.hide(),this.backdrop(function(){n.$body.removeClass("modal-open"),n.resetAdjustments(),n.resetScrollbar(),n.$element.trigger("hidden.bs.modal")})},r.prototype.removeBackdrop=function(){this.$backdrop&&this.$backdrop.remove(),this.$backdrop=null},r.prototype.backdrop=function(n){var e,t=this,l=this.$element.hasClass("fade")?"fade":"";this.isShown&&this.options.backdrop?(e=o.support.transition&&l,this.$backdrop=o(document.createElement("div")).addClass("modal-backdrop "+l).appendTo(this.$body),this.$element.on("click.dismiss.bs.modal",o.proxy(function(n){this.ignoreBackdropClick?this.ignoreBackdropClick=!1:n.target===n.currentTarget&&("static"==this.options.backdrop?this.$element[0].focus():this.hide())},this)),e&&this.$backdrop[0].offsetWidth,this.$backdrop.addClass("in"),n&&(e?this.$backdrop.one("bsTransitionEnd",n).emulateTransitionEnd(r.BACKDROP_TRANSITION_DURATION):n())):!this.isShown&&this.$backdrop?(this.$backdrop.removeClass("in"),e=function(){t.removeBackdrop(),n&&n()},o.support.transition&&this.$element.hasClass("fade")?this.$backdrop.one("bsTransitionEnd",e).emulateTransitionEnd(r.BACKDROP_TRANSITION_DURATION):e()):n&&n()},r.prototype.handleUpdate=function(){this.adjustDialog()},r.prototype.adjustDialog=function(){var n=this.$element[0].scrollHeight>document.documentElement.clientHeight;this.$element.css({paddingLeft:!this.bodyIsOverflowing&&n?this.scrollbarWidth:"",paddingRight:this.bodyIsOverflowing&&!n?this.scrollbarWidth:""})},r.prototype.resetAdjustments=function(){this.$element.css({paddingLeft:"",paddingRight:""})},r.prototype.checkScrollbar=function(){var n,e=window.innerWidth;e||(e=(n=document.documentElement.getBoundingClientRect()).right-Math.abs(n.left)),this.bodyIsOverflowing=document.body.clientWidth<e,this.scrollbarWidth=this.measureScrollbar()},r.prototype.setScrollbar=function(){var n=parseInt(this.$body.css("padding-right")||0,10);this.originalBodyPad=document.body.style.paddingRight||"";var a=this.scrollbarWidth;this.bodyIsOverflowing&&(this.$body.css("padding-right",n+a),o(this.fixedContent).each(function(n,e){var t=e.style.paddingRight,l=o(e).css("padding-right");o(e).data("padding-right",t).css("padding-right",parseFloat(l)+a+"px")}))},r.prototype.resetScrollbar=function(){this.$body.css("padding-right",this.originalBodyPad),o(this.fixedContent).each(function(n,e){var t=o(e).data("padding-right");o(e).removeData("padding-right"),e.style.paddingRight=t||""})},r.prototype.measureScrollbar=function(){var n=document.createElement("div");n.className="modal-scrollbar-measure",this.$body.append(n);var e=n.offsetWidth-n.clientWidth;return this.$body[0].removeChild(n),e};var n=o.fn.modal;o.fn.modal=i,o.fn.modal.Constructor=r,o.fn.modal.noConflict=function(){return o.fn.modal=n,this},o(document).on("click.bs.modal.data-api",'[data-toggle="modal"]',function(n){var e=o(this),t=e.attr("href"),l=e.attr("data-target")||t&&t.replace(/.*(?=#[^\s]+$)/,""),a=o(document).find(l),t=a.data("bs.modal")?"toggle":o.extend({remote:!/#/.test(t)&&t},a.data(),e.data());e.is("a")&&n.preventDefault(),a.one("show.bs.modal",function(n){n.isDefaultPrevented()||a.one("hidden.bs.modal",function(){e.is(":visible")&&e.trigger("focus")})}),i.call(a,t,this)})}(jQuery)},{}],9:[function(n,e,t){!function(a){"use strict";function o(n,e){this.init("popover",n,e)}if(!a.fn.tooltip)throw new Error("Popover requires tooltip.js");o.VERSION="3.4.1",o.DEFAULTS=a.extend({},a.fn.tooltip.Constructor.DEFAULTS,{placement:"right",trigger:"click",content:"",template:'<div class="popover" role="tooltip"><div class="arrow"></div><h3 class="popover-title"></h3><div class="popover-content"></div></div>'}),((o.prototype=a.extend({},a.fn.tooltip.Constructor.prototype)).constructor=o).prototype.getDefaults=function(){return o.DEFAULTS},o.prototype.setContent=function(){var n,e=this.tip(),t=this.getTitle(),l=this.getContent();this.options.html?(n=typeof l,this.options.sanitize&&(t=this.sanitizeHtml(t),"string"==n&&(l=this.sanitizeHtml(l))),e.find(".popover-title").html(t),e.find(".popover-content").children().detach().end()["string"==n?"html":"append"](l)):(e.find(".popover-title").text(t),e.find(".popover-content").children().detach().end().text(l)),e.removeClass("fade top bottom left right in"),e.find(".popover-title").html()||e.find(".popover-title").hide()},o.prototype.hasContent=function(){return this.getTitle()||this.getContent()},o.prototype.getContent=function(){var n=this.$element,e=this.options;return n.attr("data-content")||("function"==typeof e.content?e.content.call(n[0]):e.content)},o.prototype.arrow=function(){return this.$arrow=this.$arrow||this.tip().find(".arrow")};var n=a.fn.popover;a.fn.popover=function(l){return this.each(function(){var n=a(this),e=n.data("bs.popover"),t="object"==typeof l&&l;!e&&/destroy|hide/.test(l)||(e||n.data("bs.popover",e=new o(this,t)),"string"==typeof l&&e[l]())})},a.fn.popover.Constructor=o,a.fn.popover.noConflict=function(){return a.fn.popover=n,this}}(jQuery)},{}],10:[function(n,e,t){!function(a){"use strict";function l(n,e){this.$body=a(document.body),this.$scrollElement=a(n).is(document.body)?a(window):a(n),this.options=a.extend({},l.DEFAULTS,e),this.selector=(this.options.target||"")+" .nav li > a",this.offsets=[],this.targets=[],this.activeTarget=null,this.scrollHeight=0,this.$scrollElement.on("scroll.bs.scrollspy",a.proxy(this.process,this)),this.refresh(),this.process()}function e(t){return this.each(function(){var n=a(this),e=n.data("bs.scrollspy");e||n.data("bs.scrollspy",e=new l(this,"object"==typeof t&&t)),"string"==typeof t&&e[t]()})}l.VERSION="3.4.1",l.DEFAULTS={offset:10},l.prototype.getScrollHeight=function(){return this.$scrollElement[0].scrollHeight||Math.max(this.$body[0].scrollHeight,document.documentElement.scrollHeight)},l.prototype.refresh=function(){var n=this,t="offset",l=0;this.offsets=[],this.targets=[],this.scrollHeight=this.getScrollHeight(),a.isWindow(this.$scrollElement[0])||(t="position",l=this.$scrollElement.scrollTop()),this.$body.find(this.selector).map(function(){var n=a(this),e=n.data("target")||n.attr("href"),n=/^#./.test(e)&&a(e);return n&&n.length&&n.is(":visible")?[[n[t]().top+l,e]]:null}).sort(function(n,e){return n[0]-e[0]}).each(function(){n.offsets.push(this[0]),n.targets.push(this[1])})},l.prototype.process=function(){var n,e=this.$scrollElement.scrollTop()+this.options.offset,t=this.getScrollHeight(),l=this.options.offset+t-this.$scrollElement.height(),a=this.offsets,o=this.targets,r=this.activeTarget;if(this.scrollHeight!=t&&this.refresh(),l<=e)return r!=(n=o[o.length-1])&&this.activate(n);if(r&&e<a[0])return this.activeTarget=null,this.clear();for(n=a.length;n--;)r!=o[n]&&e>=a[n]&&(void 0===a[n+1]||e<a[n+1])&&this.activate(o[n])},l.prototype.activate=function(n){this.activeTarget=n,this.clear();n=this.selector+'[data-target="'+n+'"],'+this.selector+'[href="'+n+'"]',n=a(n).parents("li").addClass("active");(n=n.parent(".dropdown-menu").length?n.closest("li.dropdown").addClass("active"):n).trigger("activate.bs.scrollspy")},l.prototype.clear=function(){a(this.selector).parentsUntil(this.options.target,".active").removeClass("active")};var n=a.fn.scrollspy;a.fn.scrollspy=e,a.fn.scrollspy.Constructor=l,a.fn.scrollspy.noConflict=function(){return a.fn.scrollspy=n,this},a(window).on("load.bs.scrollspy.data-api",function(){a('[data-spy="scroll"]').each(function(){var n=a(this);e.call(n,n.data())})})}(jQuery)},{}],11:[function(n,e,t){!function(r){"use strict";function i(n){this.element=r(n)}function e(t){return this.each(function(){var n=r(this),e=n.data("bs.tab");e||n.data("bs.tab",e=new i(this)),"string"==typeof t&&e[t]()})}i.VERSION="3.4.1",i.TRANSITION_DURATION=150,i.prototype.show=function(){var n,e,t,l=this.element,a=l.closest("ul:not(.dropdown-menu)"),o=(o=l.data("target"))||(o=l.attr("href"))&&o.replace(/.*(?=#[^\s]*$)/,"");l.parent("li").hasClass("active")||(n=a.find(".active:last a"),e=r.Event("hide.bs.tab",{relatedTarget:l[0]}),t=r.Event("show.bs.tab",{relatedTarget:n[0]}),n.trigger(e),l.trigger(t),t.isDefaultPrevented()||e.isDefaultPrevented()||(o=r(document).find(o),this.activate(l.closest("li"),a),this.activate(o,o.parent(),function(){n.trigger({type:"hidden.bs.tab",relatedTarget:l[0]}),l.trigger({type:"shown.bs.tab",relatedTarget:n[0]})})))},i.prototype.activate=function(n,e,t){var l=e.find("> .active"),a=t&&r.support.transition&&(l.length&&l.hasClass("fade")||!!e.find("> .fade").length);function o(){l.removeClass("active").find("> .dropdown-menu > .active").removeClass("active").end().find('[data-toggle="tab"]').attr("aria-expanded",!1),n.addClass("active").find('[data-toggle="tab"]').attr("aria-expanded",!0),a?(n[0].offsetWidth,n.addClass("in")):n.removeClass("fade"),n.parent(".dropdown-menu").length&&n.closest("li.dropdown").addClass("active").end().find('[data-toggle="tab"]').attr("aria-expanded",!0),t&&t()}l.length&&a?l.one("bsTransitionEnd",o).emulateTransitionEnd(i.TRANSITION_DURATION):o(),l.removeClass("in")};var n=r.fn.tab;r.fn.tab=e,r.fn.tab.Constructor=i,r.fn.tab.noConflict=function(){return r.fn.tab=n,this};function t(n){n.preventDefault(),e.call(r(this),"show")}r(document).on("click.bs.tab.data-api",'[data-toggle="tab"]',t).on("click.bs.tab.data-api",'[data-toggle="pill"]',t)}(jQuery)},{}],12:[function(n,e,t){!function(d){"use strict";var l=["sanitize","whiteList","sanitizeFn"],m=["background","cite","href","itemtype","longdesc","poster","src","xlink:href"],n={"*":["class","dir","id","lang","role",/^aria-[\w-]*$/i],a:["target","href","title","rel"],area:[],b:[],br:[],col:[],code:[],div:[],em:[],hr:[],h1:[],h2:[],h3:[],h4:[],h5:[],h6:[],i:[],img:["src","alt","title","width","height"],li:[],ol:[],p:[],pre:[],s:[],small:[],span:[],sub:[],sup:[],strong:[],u:[],ul:[]},f=/^(?:(?:https?|mailto|ftp|tel|file):|[^&:/?#]*(?:[/?#]|$))/gi,g=/^data:(?:image\/(?:bmp|gif|jpeg|jpg|png|tiff|webp)|video\/(?:mpeg|mp4|ogg|webm)|audio\/(?:mp3|oga|ogg|opus));base64,[a-z0-9+/]+=*$/i;function a(n,e,t){if(0===n.length)return n;if(t&&"function"==typeof t)return t(n);if(!document.implementation||!document.implementation.createHTMLDocument)return n;t=document.implementation.createHTMLDocument("sanitization");t.body.innerHTML=n;for(var l=d.map(e,function(n,e){return e}),a=d(t.body).find("*"),o=0,r=a.length;o<r;o++){var i=a[o],s=i.nodeName.toLowerCase();if(-1!==d.inArray(s,l))for(var c=d.map(i.attributes,function(n){return n}),u=[].concat(e["*"]||[],e[s]||[]),h=0,p=c.length;h<p;h++)!function(n,e){var t=n.nodeName.toLowerCase();if(-1!==d.inArray(t,e))return-1===d.inArray(t,m)||Boolean(n.nodeValue.match(f)||n.nodeValue.match(g));for(var l=d(e).filter(function(n,e){return e instanceof RegExp}),a=0,o=l.length;a<o;a++)if(t.match(l[a]))return 1}(c[h],u)&&i.removeAttribute(c[h].nodeName);else i.parentNode.removeChild(i)}return t.body.innerHTML}function s(n,e){this.type=null,this.options=null,this.enabled=null,this.timeout=null,this.hoverState=null,this.$element=null,this.inState=null,this.init("tooltip",n,e)}s.VERSION="3.4.1",s.TRANSITION_DURATION=150,s.DEFAULTS={animation:!0,placement:"top",selector:!1,template:'<div class="tooltip" role="tooltip"><div class="tooltip-arrow"></div><div class="tooltip-inner"></div></div>',trigger:"hover focus",title:"",delay:0,html:!1,container:!1,viewport:{selector:"body",padding:0},sanitize:!0,sanitizeFn:null,whiteList:n},s.prototype.init=function(n,e,t){if(this.enabled=!0,this.type=n,this.$element=d(e),this.options=this.getOptions(t),this.$viewport=this.options.viewport&&d(document).find(d.isFunction(this.options.viewport)?this.options.viewport.call(this,this.$element):this.options.viewport.selector||this.options.viewport),this.inState={click:!1,hover:!1,focus:!1},this.$element[0]instanceof document.constructor&&!this.options.selector)throw new Error("`selector` option must be specified when initializing "+this.type+" on the window.document object!");for(var l=this.options.trigger.split(" "),a=l.length;a--;){var o,r=l[a];"click"==r?this.$element.on("click."+this.type,this.options.selector,d.proxy(this.toggle,this)):"manual"!=r&&(o="hover"==r?"mouseleave":"focusout",this.$element.on(("hover"==r?"mouseenter":"focusin")+"."+this.type,this.options.selector,d.proxy(this.enter,this)),this.$element.on(o+"."+this.type,this.options.selector,d.proxy(this.leave,this)))}this.options.selector?this._options=d.extend({},this.options,{trigger:"manual",selector:""}):this.fixTitle()},s.prototype.getDefaults=function(){return s.DEFAULTS},s.prototype.getOptions=function(n){var e,t=this.$element.data();for(e in t)t.hasOwnProperty(e)&&-1!==d.inArray(e,l)&&delete t[e];return(n=d.extend({},this.getDefaults(),t,n)).delay&&"number"==typeof n.delay&&(n.delay={show:n.delay,hide:n.delay}),n.sanitize&&(n.template=a(n.template,n.whiteList,n.sanitizeFn)),n},s.prototype.getDelegateOptions=function(){var t={},l=this.getDefaults();return this._options&&d.each(this._options,function(n,e){l[n]!=e&&(t[n]=e)}),t},s.prototype.enter=function(n){var e=n instanceof this.constructor?n:d(n.currentTarget).data("bs."+this.type);if(e||(e=new this.constructor(n.currentTarget,this.getDelegateOptions()),d(n.currentTarget).data("bs."+this.type,e)),n instanceof d.Event&&(e.inState["focusin"==n.type?"focus":"hover"]=!0),e.tip().hasClass("in")||"in"==e.hoverState)e.hoverState="in";else{if(clearTimeout(e.timeout),e.hoverState="in",!e.options.delay||!e.options.delay.show)return e.show();e.timeout=setTimeout(function(){"in"==e.hoverState&&e.show()},e.options.delay.show)}},s.prototype.isInStateTrue=function(){for(var n in this.inState)if(this.inState[n])return!0;return!1},s.prototype.leave=function(n){var e=n instanceof this.constructor?n:d(n.currentTarget).data("bs."+this.type);if(e||(e=new this.constructor(n.currentTarget,this.getDelegateOptions()),d(n.currentTarget).data("bs."+this.type,e)),n instanceof d.Event&&(e.inState["focusout"==n.type?"focus":"hover"]=!1),!e.isInStateTrue()){if(clearTimeout(e.timeout),e.hoverState="out",!e.options.delay||!e.options.delay.hide)return e.hide();e.timeout=setTimeout(function(){"out"==e.hoverState&&e.hide()},e.options.delay.hide)}},s.prototype.show=function(){var e,n,t,l,a,o,r,i=d.Event("show.bs."+this.type);this.hasContent()&&this.enabled&&(this.$element.trigger(i),t=d.contains(this.$element[0].ownerDocument.documentElement,this.$element[0]),!i.isDefaultPrevented()&&t&&(n=(e=this).tip(),o=this.getUID(this.type),this.setContent(),n.attr("id",o),this.$element.attr("aria-describedby",o),this.options.animation&&n.addClass("fade"),r="function"==typeof this.options.placement?this.options.placement.call(this,n[0],this.$element[0]):this.options.placement,(a=(l=/\s?auto?\s?/i).test(r))&&(r=r.replace(l,"")||"top"),n.detach().css({top:0,left:0,display:"block"}).addClass(r).data("bs."+this.type,this),this.options.container?n.appendTo(d(document).find(this.options.container)):n.insertAfter(this.$element),this.$element.trigger("inserted.bs."+this.type),i=this.getPosition(),t=n[0].offsetWidth,o=n[0].offsetHeight,a&&(l=r,a=this.getPosition(this.$viewport),r="bottom"==r&&i.bottom+o>a.bottom?"top":"top"==r&&i.top-o<a.top?"bottom":"right"==r&&i.right+t>a.width?"left":"left"==r&&i.left-t<a.left?"right":r,n.removeClass(l).addClass(r)),o=this.getCalculatedOffset(r,i,t,o),this.applyPlacement(o,r),r=function(){var n=e.hoverState;e.$element.trigger("shown.bs."+e.type),e.hoverState=null,"out"==n&&e.leave(e)},d.support.transition&&this.$tip.hasClass("fade")?n.one("bsTransitionEnd",r).emulateTransitionEnd(s.TRANSITION_DURATION):r()))},s.prototype.applyPlacement=function(n,e){var t=this.tip(),l=t[0].offsetWidth,a=t[0].offsetHeight,o=parseInt(t.css("margin-top"),10),r=parseInt(t.css("margin-left"),10);isNaN(o)&&(o=0),isNaN(r)&&(r=0),n.top+=o,n.left+=r,d.offset.setOffset(t[0],d.extend({using:function(n){t.css({top:Math.round(n.top),left:Math.round(n.left)})}},n),0),t.addClass("in");var i=t[0].offsetWidth,o=t[0].offsetHeight;"top"==e&&o!=a&&(n.top=n.top+a-o);r=this.getViewportAdjustedDelta(e,n,i,o);r.left?n.left+=r.left:n.top+=r.top;e=/top|bottom/.test(e),a=e?2*r.left-l+i:2*r.top-a+o,o=e?"offsetWidth":"offsetHeight";t.offset(n),this.replaceArrow(a,t[0][o],e)},s.prototype.replaceArrow=function(n,e,t){this.arrow().css(t?"left":"top",50*(1-n/e)+"%").css(t?"top":"left","")},s.prototype.setContent=function(){var n=this.tip(),e=this.getTitle();this.options.html?(this.options.sanitize&&(e=a(e,this.options.whiteList,this.options.sanitizeFn)),n.find(".tooltip-inner").html(e)):n.find(".tooltip-inner").text(e),n.removeClass("fade in top bottom left right")},s.prototype.hide=function(n){var e=this,t=d(this.$tip),l=d.Event("hide.bs."+this.type);function a(){"in"!=e.hoverState&&t.detach(),e.$element&&e.$element.removeAttr("aria-describedby").trigger("hidden.bs."+e.type),n&&n()}if(this.$element.trigger(l),!l.isDefaultPrevented())return t.removeClass("in"),d.support.transition&&t.hasClass("fade")?t.one("bsTransitionEnd",a).emulateTransitionEnd(s.TRANSITION_DURATION):a(),this.hoverState=null,this},s.prototype.fixTitle=function(){var n=this.$element;!n.attr("title")&&"string"==typeof n.attr("data-original-title")||n.attr("data-original-title",n.attr("title")||"").attr("title","")},s.prototype.hasContent=function(){return this.getTitle()},s.prototype.getPosition=function(n){var e=(n=n||this.$element)[0],t="BODY"==e.tagName,l=e.getBoundingClientRect();null==l.width&&(l=d.extend({},l,{width:l.right-l.left,height:l.bottom-l.top}));e=window.SVGElement&&e instanceof window.SVGElement,e=t?{top:0,left:0}:e?null:n.offset(),n={scroll:t?document.documentElement.scrollTop||document.body.scrollTop:n.scrollTop()},t=t?{width:d(window).width(),height:d(window).height()}:null;return d.extend({},l,n,t,e)},s.prototype.getCalculatedOffset=function(n,e,t,l){return"bottom"==n?{top:e.top+e.height,left:e.left+e.width/2-t/2}:"top"==n?{top:e.top-l,left:e.left+e.width/2-t/2}:"left"==n?{top:e.top+e.height/2-l/2,left:e.left-t}:{top:e.top+e.height/2-l/2,left:e.left+e.width}},s.prototype.getViewportAdjustedDelta=function(n,e,t,l){var a={top:0,left:0};if(!this.$viewport)return a;var o,r=this.options.viewport&&this.options.viewport.padding||0,i=this.getPosition(this.$viewport);return/right|left/.test(n)?(n=e.top-r-i.scroll,o=e.top+r-i.scroll+l,n<i.top?a.top=i.top-n:o>i.top+i.height&&(a.top=i.top+i.height-o)):(o=e.left-r,t=e.left+r+t,o<i.left?a.left=i.left-o:t>i.right&&(a.left=i.left+i.width-t)),a},s.prototype.getTitle=function(){var n=this.$element,e=this.options;return n.attr("data-original-title")||("function"==typeof e.title?e.title.call(n[0]):e.title)},s.prototype.getUID=function(n){for(;n+=~~(1e6*Math.random()),document.getElementById(n););return n},s.prototype.tip=function(){if(!this.$tip&&(this.$tip=d(this.options.template),1!=this.$tip.length))throw new Error(this.type+" `template` option must consist of exactly 1 top-level element!");return this.$tip},s.prototype.arrow=function(){return this.$arrow=this.$arrow||this.tip().find(".tooltip-arrow")},s.prototype.enable=function(){this.enabled=!0},s.prototype.disable=function(){this.enabled=!1},s.prototype.toggleEnabled=function(){this.enabled=!this.enabled},s.prototype.toggle=function(n){var e=this;n&&((e=d(n.currentTarget).data("bs."+this.type))||(e=new this.constructor(n.currentTarget,this.getDelegateOptions()),d(n.currentTarget).data("bs."+this.type,e))),n?(e.inState.click=!e.inState.click,e.isInStateTrue()?e.enter(e):e.leave(e)):e.tip().hasClass("in")?e.leave(e):e.enter(e)},s.prototype.destroy=function(){var n=this;clearTimeout(this.timeout),this.hide(function(){n.$element.off("."+n.type).removeData("bs."+n.type),n.$tip&&n.$tip.detach(),n.$tip=null,n.$arrow=null,n.$viewport=null,n.$element=null})},s.prototype.sanitizeHtml=function(n){return a(n,this.options.whiteList,this.options.sanitizeFn)};var e=d.fn.tooltip;d.fn.tooltip=function(l){return this.each(function(){var n=d(this),e=n.data("bs.tooltip"),t="object"==typeof l&&l;!e&&/destroy|hide/.test(l)||(e||n.data("bs.tooltip",e=new s(this,t)),"string"==typeof l&&e[l]())})},d.fn.tooltip.Constructor=s,d.fn.tooltip.noConflict=function(){return d.fn.tooltip=e,this}}(jQuery)},{}],13:[function(n,e,t){!function(l){"use strict";l.fn.emulateTransitionEnd=function(n){var e=!1,t=this;l(this).one("bsTransitionEnd",function(){e=!0});return setTimeout(function(){e||l(t).trigger(l.support.transition.end)},n),this},l(function(){l.support.transition=function(){var n,e=document.createElement("bootstrap"),t={WebkitTransition:"webkitTransitionEnd",MozTransition:"transitionend",OTransition:"oTransitionEnd otransitionend",transition:"transitionend"};for(n in t)if(void 0!==e.style[n])return{end:t[n]};return!1}(),l.support.transition&&(l.event.special.bsTransitionEnd={bindType:l.support.transition.end,delegateType:l.support.transition.end,handle:function(n){if(l(n.target).is(this))return n.handleObj.handler.apply(this,arguments)}})})}(jQuery)},{}],14:[function(n,e,t){"use strict";function l(n){return n&&n.__esModule?n:{default:n}}function a(n){if(n&&n.__esModule)return n;var e={};if(null!=n)for(var t in n)Object.prototype.hasOwnProperty.call(n,t)&&(e[t]=n[t]);return e.default=n,e}t.__esModule=!0;var o=a(n("./handlebars/base")),r=l(n("./handlebars/safe-string")),i=l(n("./handlebars/exception")),s=a(n("./handlebars/utils")),c=a(n("./handlebars/runtime")),u=l(n("./handlebars/no-conflict"));function h(){var e=new o.HandlebarsEnvironment;return s.extend(e,o),e.SafeString=r.default,e.Exception=i.default,e.Utils=s,e.escapeExpression=s.escapeExpression,e.VM=c,e.template=function(n){return c.template(n,e)},e}n=h();n.create=h,u.default(n),n.default=n,t.default=n,e.exports=t.default},{"./handlebars/base":15,"./handlebars/exception":18,"./handlebars/no-conflict":31,"./handlebars/runtime":32,"./handlebars/safe-string":33,"./handlebars/utils":34}],15:[function(n,e,t){"use strict";function l(n){return n&&n.__esModule?n:{default:n}}t.__esModule=!0,t.HandlebarsEnvironment=h;var a=n("./utils"),o=l(n("./exception")),r=n("./helpers"),i=n("./decorators"),s=l(n("./logger")),c=n("./internal/proto-access");t.VERSION="4.7.7";t.COMPILER_REVISION=8;t.LAST_COMPATIBLE_COMPILER_REVISION=7;t.REVISION_CHANGES={1:"<= 1.0.rc.2",2:"== 1.0.0-rc.3",3:"== 1.0.0-rc.4",4:"== 1.x.x",5:"== 2.0.0-alpha.x",6:">= 2.0.0-beta.1",7:">= 4.0.0 <4.3.0",8:">= 4.3.0"};var u="[object Object]";function h(n,e,t){this.helpers=n||{},this.partials=e||{},this.decorators=t||{},r.registerDefaultHelpers(this),i.registerDefaultDecorators(this)}h.prototype={constructor:h,logger:s.default,log:s.default.log,registerHelper:function(n,e){if(a.toString.call(n)===u){if(e)throw new o.default("Arg not supported with multiple helpers");a.extend(this.helpers,n)}else this.helpers[n]=e},unregisterHelper:function(n){delete this.helpers[n]},registerPartial:function(n,e){if(a.toString.call(n)===u)a.extend(this.partials,n);else{if(void 0===e)throw new o.default('Attempting to register a partial called "'+n+'" as undefined');this.partials[n]=e}},unregisterPartial:function(n){delete this.partials[n]},registerDecorator:function(n,e){if(a.toString.call(n)===u){if(e)throw new o.default("Arg not supported with multiple decorators");a.extend(this.decorators,n)}else this.decorators[n]=e},unregisterDecorator:function(n){delete this.decorators[n]},resetLoggedPropertyAccesses:function(){c.resetLoggedProperties()}};n=s.default.log;t.log=n,t.createFrame=a.createFrame,t.logger=s.default},{"./decorators":16,"./exception":18,"./helpers":19,"./internal/proto-access":28,"./logger":30,"./utils":34}],16:[function(n,e,t){"use strict";t.__esModule=!0,t.registerDefaultDecorators=function(n){o.default(n)};var l,a=n("./decorators/inline"),o=(l=a)&&l.__esModule?l:{default:l}},{"./decorators/inline":17}],17:[function(n,e,t){"use strict";t.__esModule=!0;var r=n("../utils");t.default=function(n){n.registerDecorator("inline",function(l,a,o,n){var e=l;return a.partials||(a.partials={},e=function(n,e){var t=o.partials;o.partials=r.extend({},t,a.partials);e=l(n,e);return o.partials=t,e}),a.partials[n.args[0]]=n.fn,e})},e.exports=t.default},{"../utils":34}],18:[function(n,e,t){"use strict";t.__esModule=!0;var s=["description","fileName","lineNumber","endLineNumber","message","name","number","stack"];function c(n,e){var t=e&&e.loc,l=void 0,a=void 0,o=void 0,e=void 0;t&&(l=t.start.line,a=t.end.line,o=t.start.column,e=t.end.column,n+=" - "+l+":"+o);for(var r=Error.prototype.constructor.call(this,n),i=0;i<s.length;i++)this[s[i]]=r[s[i]];Error.captureStackTrace&&Error.captureStackTrace(this,c);try{t&&(this.lineNumber=l,this.endLineNumber=a,Object.defineProperty?(Object.defineProperty(this,"column",{value:o,enumerable:!0}),Object.defineProperty(this,"endColumn",{value:e,enumerable:!0})):(this.column=o,this.endColumn=e))}catch(n){}}c.prototype=new Error,t.default=c,e.exports=t.default},{}],19:[function(n,e,t){"use strict";function l(n){return n&&n.__esModule?n:{default:n}}t.__esModule=!0,t.registerDefaultHelpers=function(n){a.default(n),o.default(n),r.default(n),i.default(n),s.default(n),c.default(n),u.default(n)},t.moveHelperToHooks=function(n,e,t){n.helpers[e]&&(n.hooks[e]=n.helpers[e],t||delete n.helpers[e])};var a=l(n("./helpers/block-helper-missing")),o=l(n("./helpers/each")),r=l(n("./helpers/helper-missing")),i=l(n("./helpers/if")),s=l(n("./helpers/log")),c=l(n("./helpers/lookup")),u=l(n("./helpers/with"))},{"./helpers/block-helper-missing":20,"./helpers/each":21,"./helpers/helper-missing":22,"./helpers/if":23,"./helpers/log":24,"./helpers/lookup":25,"./helpers/with":26}],20:[function(n,e,t){"use strict";t.__esModule=!0;var o=n("../utils");t.default=function(a){a.registerHelper("blockHelperMissing",function(n,e){var t=e.inverse,l=e.fn;return!0===n?l(this):!1===n||null==n?t(this):o.isArray(n)?0<n.length?(e.ids&&(e.ids=[e.name]),a.helpers.each(n,e)):t(this):(e.data&&e.ids&&((t=o.createFrame(e.data)).contextPath=o.appendContextPath(e.data.contextPath,e.name),e={data:t}),l(n,e))})},e.exports=t.default},{"../utils":34}],21:[function(t,l,a){!function(g){!function(){"use strict";a.__esModule=!0;var n,m=t("../utils"),e=t("../exception"),f=(n=e)&&n.__esModule?n:{default:n};a.default=function(n){n.registerHelper("each",function(l,n){if(!n)throw new f.default("Must pass iterator to #each");var e,a=n.fn,t=n.inverse,o=0,r="",i=void 0,s=void 0;function c(n,e,t){i&&(i.key=n,i.index=e,i.first=0===e,i.last=!!t,s&&(i.contextPath=s+n)),r+=a(l[n],{data:i,blockParams:m.blockParams([l[n],n],[s+n,null])})}if(n.data&&n.ids&&(s=m.appendContextPath(n.data.contextPath,n.ids[0])+"."),m.isFunction(l)&&(l=l.call(this)),n.data&&(i=m.createFrame(n.data)),l&&"object"==typeof l)if(m.isArray(l))for(var u=l.length;o<u;o++)o in l&&c(o,o,o===l.length-1);else if(g.Symbol&&l[g.Symbol.iterator]){for(var h=[],p=l[g.Symbol.iterator](),d=p.next();!d.done;d=p.next())h.push(d.value);for(u=(l=h).length;o<u;o++)c(o,o,o===l.length-1)}else e=void 0,Object.keys(l).forEach(function(n){void 0!==e&&c(e,o-1),e=n,o++}),void 0!==e&&c(e,o-1,!0);return r=0===o?t(this):r})},l.exports=a.default}.call(this)}.call(this,"undefined"!=typeof global?global:"undefined"!=typeof self?self:"undefined"!=typeof window?window:{})},{"../exception":18,"../utils":34}],22:[function(n,e,t){"use strict";t.__esModule=!0;var l,a=n("../exception"),o=(l=a)&&l.__esModule?l:{default:l};t.default=function(n){n.registerHelper("helperMissing",function(){if(1!==arguments.length)throw new o.default('Missing helper: "'+arguments[arguments.length-1].name+'"')})},e.exports=t.default},{"../exception":18}],23:[function(n,e,t){"use strict";t.__esModule=!0;var l,a=n("../utils"),o=n("../exception"),r=(l=o)&&l.__esModule?l:{default:l};t.default=function(t){t.registerHelper("if",function(n,e){if(2!=arguments.length)throw new r.default("#if requires exactly one argument");return a.isFunction(n)&&(n=n.call(this)),!e.hash.includeZero&&!n||a.isEmpty(n)?e.inverse(this):e.fn(this)}),t.registerHelper("unless",function(n,e){if(2!=arguments.length)throw new r.default("#unless requires exactly one argument");return t.helpers.if.call(this,n,{fn:e.inverse,inverse:e.fn,hash:e.hash})})},e.exports=t.default},{"../exception":18,"../utils":34}],24:[function(n,e,t){"use strict";t.__esModule=!0,t.default=function(a){a.registerHelper("log",function(){for(var n=[void 0],e=arguments[arguments.length-1],t=0;t<arguments.length-1;t++)n.push(arguments[t]);var l=1;null!=e.hash.level?l=e.hash.level:e.data&&null!=e.data.level&&(l=e.data.level),n[0]=l,a.log.apply(a,n)})},e.exports=t.default},{}],25:[function(n,e,t){"use strict";t.__esModule=!0,t.default=function(n){n.registerHelper("lookup",function(n,e,t){return n&&t.lookupProperty(n,e)})},e.exports=t.default},{}],26:[function(n,e,t){"use strict";t.__esModule=!0;var l,a=n("../utils"),o=n("../exception"),r=(l=o)&&l.__esModule?l:{default:l};t.default=function(n){n.registerHelper("with",function(n,e){if(2!=arguments.length)throw new r.default("#with requires exactly one argument");a.isFunction(n)&&(n=n.call(this));var t=e.fn;if(a.isEmpty(n))return e.inverse(this);var l=e.data;return e.data&&e.ids&&((l=a.createFrame(e.data)).contextPath=a.appendContextPath(e.data.contextPath,e.ids[0])),t(n,{data:l,blockParams:a.blockParams([n],[l&&l.contextPath])})})},e.exports=t.default},{"../exception":18,"../utils":34}],27:[function(n,e,t){"use strict";t.__esModule=!0,t.createNewLookupObject=function(){for(var n=arguments.length,e=Array(n),t=0;t<n;t++)e[t]=arguments[t];return l.extend.apply(void 0,[Object.create(null)].concat(e))};var l=n("../utils")},{"../utils":34}],28:[function(n,e,t){"use strict";t.__esModule=!0,t.createProtoAccessControl=function(n){var e=Object.create(null);e.constructor=!1,e.__defineGetter__=!1,e.__defineSetter__=!1,e.__lookupGetter__=!1;var t=Object.create(null);return t.__proto__=!1,{properties:{whitelist:l.createNewLookupObject(t,n.allowedProtoProperties),defaultValue:n.allowProtoPropertiesByDefault},methods:{whitelist:l.createNewLookupObject(e,n.allowedProtoMethods),defaultValue:n.allowProtoMethodsByDefault}}},t.resultIsAllowed=function(n,e,t){return r("function"==typeof n?e.methods:e.properties,t)},t.resetLoggedProperties=function(){Object.keys(o).forEach(function(n){delete o[n]})};var l=n("./create-new-lookup-object"),a=function(n){if(n&&n.__esModule)return n;var e={};if(null!=n)for(var t in n)Object.prototype.hasOwnProperty.call(n,t)&&(e[t]=n[t]);return e.default=n,e}(n("../logger")),o=Object.create(null);function r(n,e){return void 0!==n.whitelist[e]?!0===n.whitelist[e]:void 0!==n.defaultValue?n.defaultValue:(!0!==o[e=e]&&(o[e]=!0,a.log("error",'Handlebars: Access has been denied to resolve the property "'+e+'" because it is not an "own property" of its parent.\nYou can add a runtime option to disable the check or this warning:\nSee https://handlebarsjs.com/api-reference/runtime-options.html#options-to-control-prototype-access for details')),!1)}},{"../logger":30,"./create-new-lookup-object":27}],29:[function(n,e,t){"use strict";t.__esModule=!0,t.wrapHelper=function(e,t){return"function"==typeof e?function(){var n=arguments[arguments.length-1];return arguments[arguments.length-1]=t(n),e.apply(this,arguments)}:e}},{}],30:[function(n,e,t){"use strict";t.__esModule=!0;var l=n("./utils"),a={methodMap:["debug","info","warn","error"],level:"info",lookupLevel:function(n){var e;return n="string"==typeof n?0<=(e=l.indexOf(a.methodMap,n.toLowerCase()))?e:parseInt(n,10):n},log:function(n){if(n=a.lookupLevel(n),"undefined"!=typeof console&&a.lookupLevel(a.level)<=n){n=a.methodMap[n];console[n]||(n="log");for(var e=arguments.length,t=Array(1<e?e-1:0),l=1;l<e;l++)t[l-1]=arguments[l];console[n].apply(console,t)}}};t.default=a,e.exports=t.default},{"./utils":34}],31:[function(n,e,t){!function(l){!function(){"use strict";t.__esModule=!0,t.default=function(n){var e=void 0!==l?l:window,t=e.Handlebars;n.noConflict=function(){return e.Handlebars===n&&(e.Handlebars=t),n}},e.exports=t.default}.call(this)}.call(this,"undefined"!=typeof global?global:"undefined"!=typeof self?self:"undefined"!=typeof window?window:{})},{}],32:[function(n,e,t){"use strict";t.__esModule=!0,t.checkRevision=function(n){var e=n&&n[0]||1,t=p.COMPILER_REVISION;if(!(e>=p.LAST_COMPATIBLE_COMPILER_REVISION&&e<=p.COMPILER_REVISION)){if(e<p.LAST_COMPATIBLE_COMPILER_REVISION){t=p.REVISION_CHANGES[t],e=p.REVISION_CHANGES[e];throw new h.default("Template was precompiled with an older version of Handlebars than the current runtime. Please update your precompiler to a newer version ("+t+") or downgrade your runtime to an older version ("+e+").")}throw new h.default("Template was precompiled with a newer version of Handlebars than the current runtime. Please update your runtime to a newer version ("+n[1]+").")}},t.template=function(s,c){if(!c)throw new h.default("No environment passed to template");if(!s||!s.main)throw new h.default("Unknown template object: "+typeof s);s.main.decorator=s.main_d,c.VM.checkRevision(s.compiler);var t=s.compiler&&7===s.compiler[0];var r={strict:function(n,e,t){if(!(n&&e in n))throw new h.default('"'+e+'" not defined in '+n,{loc:t});return r.lookupProperty(n,e)},lookupProperty:function(n,e){var t=n[e];return null==t||Object.prototype.hasOwnProperty.call(n,e)||m.resultIsAllowed(t,r.protoAccessControl,e)?t:void 0},lookup:function(n,e){for(var t=n.length,l=0;l<t;l++)if(null!=(n[l]&&r.lookupProperty(n[l],e)))return n[l][e]},lambda:function(n,e){return"function"==typeof n?n.call(e):n},escapeExpression:u.escapeExpression,invokePartial:function(n,e,t){t.hash&&(e=u.extend({},e,t.hash),t.ids&&(t.ids[0]=!0)),n=c.VM.resolvePartial.call(this,n,e,t);var l=u.extend({},t,{hooks:this.hooks,protoAccessControl:this.protoAccessControl}),a=c.VM.invokePartial.call(this,n,e,l);if(null==a&&c.compile&&(t.partials[t.name]=c.compile(n,s.compilerOptions,c),a=t.partials[t.name](e,l)),null==a)throw new h.default("The partial "+t.name+" could not be compiled when running in runtime-only mode");if(t.indent){for(var o=a.split("\n"),r=0,i=o.length;r<i&&(o[r]||r+1!==i);r++)o[r]=t.indent+o[r];a=o.join("\n")}return a},fn:function(n){var e=s[n];return e.decorator=s[n+"_d"],e},programs:[],program:function(n,e,t,l,a){var o=this.programs[n],r=this.fn(n);return o=e||a||l||t?f(this,n,r,e,t,l,a):o||(this.programs[n]=f(this,n,r))},data:function(n,e){for(;n&&e--;)n=n._parent;return n},mergeIfNeeded:function(n,e){var t=n||e;return t=n&&e&&n!==e?u.extend({},e,n):t},nullContext:Object.seal({}),noop:c.VM.noop,compilerInfo:s.compiler};function i(n){var e=arguments.length<=1||void 0===arguments[1]?{}:arguments[1],t=e.data;i._setup(e),!e.partial&&s.useData&&(t=function(n,e){e&&"root"in e||((e=e?p.createFrame(e):{}).root=n);return e}(n,t));var l=void 0,a=s.useBlockParams?[]:void 0;function o(n){return""+s.main(r,n,r.helpers,r.partials,t,a,l)}return s.useDepths&&(l=e.depths?n!=e.depths[0]?[n].concat(e.depths):e.depths:[n]),(o=g(s.main,o,r,e.depths||[],t,a))(n,e)}return i.isTop=!0,i._setup=function(n){var e,l,a;n.partial?(r.protoAccessControl=n.protoAccessControl,r.helpers=n.helpers,r.partials=n.partials,r.decorators=n.decorators,r.hooks=n.hooks):(e=u.extend({},c.helpers,n.helpers),l=e,a=r,Object.keys(l).forEach(function(n){var e,t=l[n];l[n]=(e=a.lookupProperty,d.wrapHelper(t,function(n){return u.extend({lookupProperty:e},n)}))}),r.helpers=e,s.usePartial&&(r.partials=r.mergeIfNeeded(n.partials,c.partials)),(s.usePartial||s.useDecorators)&&(r.decorators=u.extend({},c.decorators,n.decorators)),r.hooks={},r.protoAccessControl=m.createProtoAccessControl(n),n=n.allowCallsToHelperMissing||t,o.moveHelperToHooks(r,"helperMissing",n),o.moveHelperToHooks(r,"blockHelperMissing",n))},i._child=function(n,e,t,l){if(s.useBlockParams&&!t)throw new h.default("must pass block params");if(s.useDepths&&!l)throw new h.default("must pass parent depths");return f(r,n,s[n],e,0,t,l)},i},t.wrapProgram=f,t.resolvePartial=function(n,e,t){n?n.call||t.name||(t.name=n,n=t.partials[n]):n="@partial-block"===t.name?t.data["partial-block"]:t.partials[t.name];return n},t.invokePartial=function(n,e,l){var a=l.data&&l.data["partial-block"];l.partial=!0,l.ids&&(l.data.contextPath=l.ids[0]||l.data.contextPath);var o=void 0;l.fn&&l.fn!==r&&function(){l.data=p.createFrame(l.data);var t=l.fn;o=l.data["partial-block"]=function(n){var e=arguments.length<=1||void 0===arguments[1]?{}:arguments[1];return e.data=p.createFrame(e.data),e.data["partial-block"]=a,t(n,e)},t.partials&&(l.partials=u.extend({},l.partials,t.partials))}();void 0===n&&o&&(n=o);{if(void 0===n)throw new h.default("The partial "+l.name+" could not be found");if(n instanceof Function)return n(e,l)}},t.noop=r;var l,u=function(n){if(n&&n.__esModule)return n;var e={};if(null!=n)for(var t in n)Object.prototype.hasOwnProperty.call(n,t)&&(e[t]=n[t]);return e.default=n,e}(n("./utils")),a=n("./exception"),h=(l=a)&&l.__esModule?l:{default:l},p=n("./base"),o=n("./helpers"),d=n("./internal/wrapHelper"),m=n("./internal/proto-access");function f(l,n,a,o,e,r,i){function t(n){var e=arguments.length<=1||void 0===arguments[1]?{}:arguments[1],t=i;return!i||n==i[0]||n===l.nullContext&&null===i[0]||(t=[n].concat(i)),a(l,n,l.helpers,l.partials,e.data||o,r&&[e.blockParams].concat(r),t)}return(t=g(a,t,l,i,o,r)).program=n,t.depth=i?i.length:0,t.blockParams=e||0,t}function r(){return""}function g(n,e,t,l,a,o){return n.decorator&&(e=n.decorator(e,n={},t,l&&l[0],a,o,l),u.extend(e,n)),e}},{"./base":15,"./exception":18,"./helpers":19,"./internal/proto-access":28,"./internal/wrapHelper":29,"./utils":34}],33:[function(n,e,t){"use strict";function l(n){this.string=n}t.__esModule=!0,l.prototype.toString=l.prototype.toHTML=function(){return""+this.string},t.default=l,e.exports=t.default},{}],34:[function(n,e,t){"use strict";t.__esModule=!0,t.extend=i,t.indexOf=function(n,e){for(var t=0,l=n.length;t<l;t++)if(n[t]===e)return t;return-1},t.escapeExpression=function(n){if("string"!=typeof n){if(n&&n.toHTML)return n.toHTML();if(null==n)return"";if(!n)return n+"";n=""+n}return o.test(n)?n.replace(a,r):n},t.isEmpty=function(n){return!n&&0!==n||!(!u(n)||0!==n.length)},t.createFrame=function(n){var e=i({},n);return e._parent=n,e},t.blockParams=function(n,e){return n.path=e,n},t.appendContextPath=function(n,e){return(n?n+".":"")+e};var l={"&":"&amp;","<":"&lt;",">":"&gt;",'"':"&quot;","'":"&#x27;","`":"&#x60;","=":"&#x3D;"},a=/[&<>"'`=]/g,o=/[&<>"'`=]/;function r(n){return l[n]}function i(n){for(var e=1;e<arguments.length;e++)for(var t in arguments[e])Object.prototype.hasOwnProperty.call(arguments[e],t)&&(n[t]=arguments[e][t]);return n}var s=Object.prototype.toString;t.toString=s;var c=function(n){return"function"==typeof n};c(/x/)&&(t.isFunction=c=function(n){return"function"==typeof n&&"[object Function]"===s.call(n)}),t.isFunction=c;var u=Array.isArray||function(n){return!(!n||"object"!=typeof n)&&"[object Array]"===s.call(n)};t.isArray=u},{}],35:[function(n,e,t){e.exports=n("handlebars/runtime").default},{"handlebars/runtime":14}],36:[function(require,module,exports){
+var Backbone = require('backbone');
+var _ = require('underscore');
+
+var AppState = Backbone.Model.extend({
+    defaults: function() {
+        return {
+            'VERSION': VERSION,
+            'GRAPHITE_URL': GRAPHITE_URL,
+            'GRAPH_ENABLED': GRAPH_ENABLED,
+            'HISTORY_ENABLED': HISTORY_ENABLED,
+            'ALERTS_ENABLED': ALERTS_ENABLED,
+            'USER': USER,
+            'LOGOUT_ENABLED': LOGOUT_ENABLED,
+            'STATSD_INTERVAL': STATSD_INTERVAL,
+            'STATSD_COUNTER_FORMAT': STATSD_COUNTER_FORMAT,
+            'STATSD_GAUGE_FORMAT': STATSD_GAUGE_FORMAT,
+            'STATSD_PREFIX': STATSD_PREFIX,
+            'NSQLOOKUPD': NSQLOOKUPD,
+            'CLUSTER_NAME': CLUSTER_NAME,
+            'CLUSTERS': CLUSTERS || [],
+            'graph_interval': '2h',
+            'IS_ADMIN': IS_ADMIN,
+            'BASE_PATH': BASE_PATH
+        };
+    },
+
+    initialize: function() {
+        this.on('change:graph_interval', function(model, v) {
+            localStorage.setItem('graph_interval', v);
+        });
+
+        var qp = _.object(_.compact(_.map(window.location.search.slice(1).split('&'),
+            function(item) { return item ? item.split('=') : false; })));
+
+        this.on('change:cluster', function(model, v) {
+            localStorage.setItem('cluster', v);
+        });
+
+        var cluster = _.findWhere(this.get('CLUSTERS'), {
+            'name': qp['cluster'] || localStorage.getItem('cluster')
+        });
+        if (cluster && cluster['name'] !== this.get('CLUSTER_NAME')) {
+            // history and alerts are of the default cluster only
+            this.set({
+                'NSQLOOKUPD': cluster['nsqlookupd'] || [],
+                'HISTORY_ENABLED': false,
+                'ALERTS_ENABLED': false
+            });
+        }
+        this.set('cluster', cluster ? cluster['name'] : this.get('CLUSTER_NAME'));
+
+        var def = this.get('GRAPH_ENABLED') || this.get('HISTORY_ENABLED') ? '2h' : 'off';
+        var interval = qp['t'] || localStorage.getItem('graph_interval') || def;
+        this.set('graph_interval', interval);
+    },
+
+    basePath: function(p) {
+        // if base path is / then don't prefix
+        var bp = this.get('BASE_PATH') === '/' ? '' : this.get('BASE_PATH');
+        // remove trailing /, but guarantee at least /
+        return (bp + p).replace(/\/$/, '') || '/';
+    },
+
+    apiPath: function(p) {
+        if (this.get('cluster') !== this.get('CLUSTER_NAME')) {
+            p = '/clusters/' + encodeURIComponent(this.get('cluster')) + p;
+        }
+        return this.basePath('/api' + p);
+    },
+
+    // selectCluster shows the streams of another cluster
+    selectCluster: function(name) {
+        this.set('cluster', name);
+        // reload, so that every view and setting is of the new cluster
+        window.location.assign(this.basePath('/'));
+    }
+});
+
+var appState = new AppState();
+
+window.AppState = appState;
+
+module.exports = appState;
+
+},{}],37:[function(require,module,exports){
+var Backbone = require('backbone');
+
+var AppState = require('../app_state');
+
+var Node = require('../models/node'); //eslint-disable-line no-undef
+
+var Nodes = Backbone.Collection.extend({
+    model: Node,
+
+    comparator: 'id',
+
+    constructor: function Nodes() {
+        Backbone.Collection.prototype.constructor.apply(this, arguments);
+    },
+
+    url: function() {
+        return AppState.apiPath('/nodes');
+    },
+
+    parse: function(resp) {
+        resp['nodes'].forEach(function(n) {
+            if (n['http_socket']) {
+                n['broadcast_address_http'] = 'unix://' + n['http_socket'];
+                return;
+            }
+            var jaddr = n['broadcast_address'];
+            if (jaddr.includes(':')) {
+                // ipv6 raw address contains ':'
+                // it must be wrapped in '[ ]' when joined with port
+                jaddr = '[' + jaddr + ']';
+            }
+            n['broadcast_address_http'] = jaddr + ':' + n['http_port'];
+        });
+        return resp['nodes'];
+    }
+});
+
+module.exports = Nodes;
+
+},{"../app_state":36,"../models/node":45}],38:[function(require,module,exports){
+var _ = require('underscore');
+var Backbone = require('backbone');
+
+var AppState = require('../app_state');
+
+var Topic = require('../models/topic');
+
+var Topics = Backbone.Collection.extend({
+    model: Topic,
+
+    comparator: 'id',
+
+    constructor: function Topics() {
+        Backbone.Collection.prototype.constructor.apply(this, arguments);
+    },
+
+    url: function() {
+        return AppState.apiPath('/topics');
+    },
+
+    parse: function(resp) {
+        var topics = _.map(resp['topics'], function(name) {
+            return {'name': name};
+        });
+        return topics;
+    }
+});
+
+module.exports = Topics;
+
+},{"../app_state":36,"../models/topic":46}],39:[function(require,module,exports){
+var $ = require('jquery');
+var _ = require('underscore');
+
+// Set up some headers and options for every request.
+$.ajaxPrefilter(function(options) {
+    options['headers'] = _.defaults(options['headers'] || {}, {
+        'X-UserAgent': USER_AGENT,
+        'Accept': 'application/vnd.nsq; version=1.0'
+    });
+    options['timeout'] = 20 * 1000;
+    options['contentType'] = 'application/json';
+});
+
+},{}],40:[function(require,module,exports){
+var $ = require('jquery');
+var _ = require('underscore');
+var Handlebars = require('hbsfy/runtime');
+
+var AppState = require('../app_state');
+
+var formatStatsdKey = function(metricType, key) {
+    var fullKey = key;
+    var fmt;
+
+    if (metricType === 'counter') {
+        fmt = AppState.get('STATSD_COUNTER_FORMAT');
+        fullKey = fmt.replace(/%s/g, key);
+    } else if (metricType === 'gauge') {
+        fmt = AppState.get('STATSD_GAUGE_FORMAT');
+        fullKey = fmt.replace(/%s/g, key);
+    }
+
+    return fullKey;
+};
+
+var statsdPrefix = function(host) {
+    var prefix = AppState.get('STATSD_PREFIX');
+    var statsdHostKey = host.replace(/[\.:]/g, '_');
+    prefix = prefix.replace(/%s/g, statsdHostKey);
+    if (prefix.substring(prefix.length, 1) !== '.') {
+        prefix += '.';
+    }
+    return prefix;
+};
+
+/* eslint-disable key-spacing */
+var metricType = function(key) {
+    return {
+        'depth':                  'gauge',
+        'in_flight_count':        'gauge',
+        'deferred_count':         'gauge',
+        'requeue_count':          'counter',
+        'timeout_count':          'counter',
+        'message_count':          'counter',
+        'clients':                'gauge',
+        '*_bytes':                'gauge',
+        'gc_pause_*':             'gauge',
+        'gc_runs':                'counter',
+        'heap_objects':           'gauge',
+        'e2e_processing_latency': 'gauge'
+    }[key];
+};
+/* eslint-enable key-spacing */
+
+var genColorList = function(typ, key) {
+    if (typ === 'topic' || typ === 'channel') {
+        if (key === 'depth' || key === 'deferred_count') {
+            return 'red';
+        }
+    } else if (typ === 'node') {
+        return 'red,green,blue,purple';
+    } else if (typ === 'counter') {
+        return 'green';
+    }
+    return 'blue';
+};
+
+// sanitizeGraphiteKey removes special characters from a graphite key
+// this matches behavior of bitly/statsdaemon
+// https://github.com/bitly/statsdaemon/blob/fc46d9cfe29b674a0c8abc723afaa9370430cdcd/statsdaemon.go#L64-L88
+var sanitizeGraphiteKey = function(s) {
+    return s.replaceAll(' ', '_').replaceAll('/', '-').replaceAll(/[^a-zA-Z0-9-_.]/g, '');
+}
+
+var genTargets = function(typ, node, ns1, ns2, key) {
+    var targets = [];
+    var prefix = statsdPrefix(node ? node : '*');
+    var fullKey;
+    var target;
+    if (typ === 'topic') {
+        fullKey = formatStatsdKey(metricType(key), prefix + 'topic.' + sanitizeGraphiteKey(ns1) + '.' + key);
+        targets.push('sumSeries(' + fullKey + ')');
+    } else if (typ === 'channel') {
+        fullKey = formatStatsdKey(metricType(key), prefix + 'topic.' + sanitizeGraphiteKey(ns1) + '.channel.' +
+            sanitizeGraphiteKey(ns2) + '.' + key);
+        targets.push('sumSeries(' + fullKey + ')');
+    } else if (typ === 'node') {
+        target = prefix + 'mem.' + key;
+        if (key === 'gc_runs') {
+            target = 'movingAverage(' + target + ',45)';
+        }
+        targets.push(formatStatsdKey(metricType(key), target));
+    } else if (typ === 'e2e') {
+        targets = _.map(ns1['percentiles'], function(p) {
+            var t;
+            if (ns1['channel'] !== '') {
+                t = prefix + 'topic.' + ns1['topic'] + '.channel.' + ns1['channel'] + '.' +
+                    key + '_' + (p['quantile'] * 100);
+            } else {
+                t = prefix + 'topic.' + ns1['topic'] + '.' + key + '_' + (p['quantile'] * 100);
+            }
+            if (node === '*') {
+                t = 'averageSeries(' + t + ')';
+            }
+            return 'scale(' + formatStatsdKey(metricType(key), t) + ',0.000001)';
+        });
+    } else if (typ === 'counter') {
+        fullKey = formatStatsdKey(metricType(key), prefix + 'topic.*.channel.*.' + key);
+        targets.push('sumSeries(' + fullKey + ')');
+    }
+    return targets;
+};
+
+Handlebars.registerHelper('default', function(x, defaultValue) {
+    return x ? x : defaultValue;
+});
+
+Handlebars.registerHelper('ifeq', function(a, b, options) {
+    return (a === b) ? options.fn(this) : options.inverse(this);
+});
+
+Handlebars.registerHelper('unlesseq', function(a, b, options) {
+    return (a !== b) ? options.fn(this) : options.inverse(this);
+});
+
+Handlebars.registerHelper('ifgteq', function(a, b, options) {
+    return (a >= b) ? options.fn(this) : options.inverse(this);
+});
+
+Handlebars.registerHelper('iflteq', function(a, b, options) {
+    return (a <= b) ? options.fn(this) : options.inverse(this);
+});
+
+Handlebars.registerHelper('length', function(xs) {
+    return xs.length;
+});
+
+Handlebars.registerHelper('lowercase', function(s) {
+    return s.toLowerCase();
+});
+
+Handlebars.registerHelper('uppercase', function(s) {
+    return s.toUpperCase();
+});
+
+// this helper is inclusive of the top number
+Handlebars.registerHelper('for', function(from, to, incr, block) {
+    var accum = '';
+    for (var i = from; i <= to; i += incr) {
+        accum += block.fn(i);
+    }
+    return accum;
+});
+
+// Logical operators as helper functions, which can be useful when used within
+// an `if` or `unless` block via the new helper composition syntax, like so:
+//
+//     {{#if (or step.unlocked step.is_finished)}}
+//       Step is unlocked or finished!
+//     {{/if}}
+//
+// Any number of arguments may be given to either helper. NOTE: _.initial() is
+// used below because every helper takes an options hash as its last argument.
+Handlebars.registerHelper('and', function() {
+    return _.all(_.initial(arguments));
+});
+
+Handlebars.registerHelper('or', function() {
+    return _.any(_.initial(arguments));
+});
+
+Handlebars.registerHelper('eq', function(a, b) {
+    return a === b;
+});
+
+Handlebars.registerHelper('neq', function(a, b) {
+    return a !== b;
+});
+
+Handlebars.registerHelper('urlencode', function(a) {
+    return encodeURIComponent(a);
+});
+
+Handlebars.registerHelper('floatToPercent', function(f) {
+    return Math.floor(f * 100);
+});
+
+Handlebars.registerHelper('percSuffix', function(f) {
+    var v = Math.floor(f * 100) % 10;
+    if (v === 1) {
+        return 'st';
+    } else if (v === 2) {
+        return 'nd';
+    } else if (v === 3) {
+        return 'rd';
+    }
+    return 'th';
+});
+
+Handlebars.registerHelper('commafy', function(n) {
+    n = n || 0;
+    return n.toString().replace(/\B(?=(\d{3})+(?!\d))/g, ',');
+});
+
+function round(num, places) {
+    var multiplier = Math.pow(10, places);
+    return Math.round(num * multiplier) / multiplier;
+}
+
+Handlebars.registerHelper('nanotohuman', function(n) {
+    var s = '';
+    var v;
+    if (n >= 3600000000000) {
+        v = Math.floor(n / 3600000000000);
+        n = n % 3600000000000;
+        s = v + 'h';
+    }
+    if (n >= 60000000000) {
+        v = Math.floor(n / 60000000000);
+        n = n % 60000000000;
+        s += v + 'm';
+    }
+    if (n >= 1000000000) {
+        n = round(n / 1000000000, 2);
+        s += n + 's';
+    } else if (n >= 1000000) {
+        n = round(n / 1000000, 2);
+        s += n + 'ms';
+    } else if (n >= 1000) {
+        n = round(n / 1000, 2);
+        s += n + 'us';
+    } else {
+        s = n + 'ns';
+    }
+    return s;
+});
+
+// drain_eta is -1 while the depth is not going down
+Handlebars.registerHelper('drainETA', function(n) {
+    if (n < 0) {
+        return 'never';
+    }
+    return Handlebars.helpers.nanotohuman(n);
+});
+
+Handlebars.registerHelper('msgRate', function(r) {
+    return round(r, 1) + '/s';
+});
+
+Handlebars.registerHelper('sparkline', function(typ, node, ns1, ns2, key) {
+    var q = {
+        'colorList': genColorList(typ, key),
+        'height': '20',
+        'width': '120',
+        'hideGrid': 'true',
+        'hideLegend': 'true',
+        'hideAxes': 'true',
+        'bgcolor': 'ff000000', // transparent
+        'fgcolor': 'black',
+        'margin': '0',
+        'yMin': '0',
+        'lineMode': 'connected',
+        'drawNullAsZero': 'false',
+        'from': '-' + AppState.get('graph_interval'),
+        'until': '-1min'
+    };
+
+    var interval = AppState.get('STATSD_INTERVAL') + 'sec';
+    q['target'] = _.map(genTargets(typ, node, ns1, ns2, key), function(t) {
+        return 'summarize(' + t + ',"' + interval + '","avg")';
+    });
+
+    return AppState.get('GRAPHITE_URL') + '/render?' + $.param(q);
+});
+
+Handlebars.registerHelper('large_graph', function(typ, node, ns1, ns2, key) {
+    var q = {
+        'colorList': genColorList(typ, key),
+        'height': '450',
+        'width': '800',
+        'bgcolor': 'ff000000', // transparent
+        'fgcolor': '999999',
+        'yMin': '0',
+        'lineMode': 'connected',
+        'drawNullAsZero': 'false',
+        'from': '-' + AppState.get('graph_interval'),
+        'until': '-1min'
+    };
+
+    var interval = AppState.get('STATSD_INTERVAL') + 'sec';
+    q['target'] = _.map(genTargets(typ, node, ns1, ns2, key), function(t) {
+        if (metricType(key) === 'counter') {
+            var scale = 1 / AppState.get('STATSD_INTERVAL');
+            t = 'scale(' + t + ',' + scale + ')';
+        }
+        return 'summarize(' + t + ',"' + interval + '","avg")';
+    });
+
+    return AppState.get('GRAPHITE_URL') + '/render?' + $.param(q);
+});
+
+Handlebars.registerHelper('rate', function(typ, node, ns1, ns2) {
+    return genTargets(typ, node, ns1, ns2, 'message_count')[0];
+});
+
+Handlebars.registerPartial('error', require('../views/error.hbs'));
+Handlebars.registerPartial('warning', require('../views/warning.hbs'));
+Handlebars.registerPartial('depth_limits', require('../views/depth_limits.hbs'));
+Handlebars.registerPartial('history', require('../views/history.hbs'));
+Handlebars.registerPartial('lag', require('../views/lag.hbs'));
+
+Handlebars.registerHelper('basePath', function(p) {
+    return AppState.basePath(p);
+});
+
+},{"../app_state":36,"../views/depth_limits.hbs":57,"../views/error.hbs":58,"../views/history.hbs":61,"../views/lag.hbs":62,"../views/warning.hbs":77,"hbsfy/runtime":35}],41:[function(require,module,exports){
+var $ = require('jquery');
+var _ = require('underscore');
+
+var WIDTH = 360;
+var HEIGHT = 80;
+
+var SERIES = [
+    {'key': 'depth', 'title': 'Depth', 'color': 'red'},
+    {'key': 'message_rate', 'title': 'Messages/s', 'color': 'green'},
+    {'key': 'requeue_rate', 'title': 'Requeued/s', 'color': 'orange', 'channelOnly': true},
+    {'key': 'timeout_rate', 'title': 'Timed Out/s', 'color': 'purple', 'channelOnly': true}
+];
+
+var formatValue = function(v) {
+    return v >= 100 || v === Math.floor(v) ? Math.round(v).toLocaleString() : v.toFixed(2);
+};
+
+// chart returns an SVG line chart of one key of the history points
+var chart = function(points, series, from, until) {
+    var values = _.pluck(points, series['key']);
+    var max = _.max(values.concat([0]));
+    var span = Math.max(until - from, 1);
+    var coords = _.map(points, function(p) {
+        var x = (p['ts'] - from) / span * WIDTH;
+        var y = HEIGHT - (max > 0 ? p[series['key']] / max * HEIGHT : 0);
+        return x.toFixed(1) + ',' + y.toFixed(1);
+    });
+    var last = values.length ? values[values.length - 1] : 0;
+    return '<div class="col-md-3">' +
+        '<h5>' + series['title'] + ' <small>now ' + formatValue(last) +
+        ', max ' + formatValue(max) + '</small></h5>' +
+        '<svg width="' + WIDTH + '" height="' + HEIGHT + '" class="history-chart">' +
+        '<rect width="' + WIDTH + '" height="' + HEIGHT + '" fill="#f8f8f8"></rect>' +
+        '<polyline fill="none" stroke="' + series['color'] + '" stroke-width="1.5" points="' +
+        coords.join(' ') + '"></polyline></svg></div>';
+};
+
+// render fetches the built-in history of a topic or channel and draws its
+// charts into $el
+var render = function($el, url, window, isChannel) {
+    $.getJSON(url, {'window': window})
+        .done(function(data) {
+            var points = data['points'] || [];
+            if (!points.length) {
+                $el.html('<p class="text-muted">No history recorded yet.</p>');
+                return;
+            }
+            var from = points[0]['ts'];
+            var until = points[points.length - 1]['ts'];
+            var html = _.map(_.filter(SERIES, function(s) {
+                return isChannel || !s['channelOnly'];
+            }), function(s) {
+                return chart(points, s, from, until);
+            });
+            $el.html('<div class="row">' + html.join('') + '</div>');
+        })
+        .fail(function() {
+            $el.html('<p class="text-muted">Failed to load history.</p>');
+        });
+};
+
+module.exports = {
+    'render': render
+};
+
+},{}],42:[function(require,module,exports){
+var _ = require('underscore');
+var Backbone = require('backbone');
+
+var Pubsub = _.clone(Backbone.Events);
+
+// making this global to more easily trigger events from the console
+window.Pubsub = Pubsub;
+
+module.exports = Pubsub;
+
+},{}],43:[function(require,module,exports){
+var $ = require('jquery');
+var Backbone = require('backbone');
+
+// var Pubsub = require('./lib/pubsub');
+var Router = require('./router');
+
+var AppView = require('./views/app');
+
+// When using browserify, we need to tell Backbone what jQuery to use.
+Backbone.$ = $;
+
+// Side effects:
+require('./lib/ajax_setup');
+require('./lib/handlebars_helpers');
+
+var start = function() {
+    new AppView();
+    Router.start();
+};
+
+// Pubsub.on('all', function() {
+//     console.log.apply(console, arguments);
+// });
+
+start();
+
+},{"./lib/ajax_setup":39,"./lib/handlebars_helpers":40,"./router":47,"./views/app":50}],44:[function(require,module,exports){
+var _ = require('underscore');
+
+var AppState = require('../app_state');
+var Backbone = require('backbone');
+
+var Channel = Backbone.Model.extend({
+    idAttribute: 'name',
+
+    constructor: function Channel() {
+        Backbone.Model.prototype.constructor.apply(this, arguments);
+    },
+
+    url: function() {
+        return AppState.apiPath('/topics/' +
+            encodeURIComponent(this.get('topic')) + '/' +
+            encodeURIComponent(this.get('name')));
+    },
+
+    parse: function(response) {
+        response['nodes'] = _.map(response['nodes'] || [], function(node) {
+            if (node['node'].indexOf('unix://') === 0) {
+                // an nsqd reached over a unix socket has no port to show
+                node['show_broadcast_address'] = true;
+                node['hostname_port'] = node['hostname'];
+                return node;
+            }
+            var nodeParts = node['node'].split(':');
+            var port = nodeParts.pop();
+            var address = nodeParts.join(':');
+            var hostname = node['hostname'];
+            node['show_broadcast_address'] = hostname.toLowerCase() !== address.toLowerCase();
+            node['hostname_port'] = hostname + ':' + port;
+            return node;
+        });
+
+        response['clients'] = _.map(response['clients'] || [], function(client) {
+            var clientId = client['client_id'];
+            var hostname = client['hostname'];
+            var shortHostname = hostname.split('.')[0];
+
+            // ignore client_id if it's duplicative
+            client['show_client_id'] = (clientId.toLowerCase() !== shortHostname.toLowerCase()
+                                        && clientId.toLowerCase() !== hostname.toLowerCase());
+
+            var port = client['remote_address'].split(':').pop();
+            client['hostname_port'] = hostname + ':' + port;
+
+            return client;
+        });
+
+        return response;
+    }
+});
+
+module.exports = Channel;
+
+},{"../app_state":36}],45:[function(require,module,exports){
+var AppState = require('../app_state');
+var Backbone = require('backbone');
+
+var Node = Backbone.Model.extend({ //eslint-disable-line no-undef
+    idAttribute: 'name',
+
+    constructor: function Node() {
+        Backbone.Model.prototype.constructor.apply(this, arguments);
+    },
+
+    urlRoot: function() {
+        return AppState.apiPath('/nodes');
+    },
+
+    tombstoneTopic: function(topic) {
+        return this.destroy({
+            'data': JSON.stringify({'topic': topic}),
+            'dataType': 'text'
+        });
+    }
+});
+
+module.exports = Node;
+
+},{"../app_state":36}],46:[function(require,module,exports){
+var _ = require('underscore');
+
+var AppState = require('../app_state');
+var Backbone = require('backbone');
+
+var Topic = Backbone.Model.extend({
+    idAttribute: 'name',
+
+    constructor: function Topic() {
+        Backbone.Model.prototype.constructor.apply(this, arguments);
+    },
+
+    url: function() {
+        return AppState.apiPath('/topics/' + encodeURIComponent(this.get('name')));
+    },
+
+    parse: function(response) {
+        response['nodes'] = _.map(response['nodes'] || [], function(node) {
+            if (node['node'].indexOf('unix://') === 0) {
+                // an nsqd reached over a unix socket has no port to show
+                node['show_broadcast_address'] = true;
+                node['hostname_port'] = node['hostname'];
+                return node;
+            }
+            var nodeParts = node['node'].split(':');
+            var port = nodeParts.pop();
+            var address = nodeParts.join(':');
+            var hostname = node['hostname'];
+            node['show_broadcast_address'] = hostname.toLowerCase() !== address.toLowerCase();
+            node['hostname_port'] = hostname + ':' + port;
+            return node;
+        });
+        return response;
+    }
+});
+
+module.exports = Topic;
+
+},{"../app_state":36}],47:[function(require,module,exports){
+var Backbone = require('backbone');
+
+var AppState = require('./app_state');
+var Pubsub = require('./lib/pubsub');
+
+
+var Router = Backbone.Router.extend({
+    initialize: function() {
+        var bp = function(p) {
+            // remove leading slash
+            return AppState.basePath(p).substring(1);
+        };
+        this.route(bp('/'), 'topics');
+        this.route(bp('/topics/(:topic)(/:channel)'), 'topic');
+        this.route(bp('/lookup'), 'lookup');
+        this.route(bp('/nodes(/*node)'), 'nodes');
+        this.route(bp('/counter'), 'counter');
+        this.route(bp('/alerts'), 'alerts');
+        this.route(bp('/overview'), 'overview');
+        // this.listenTo(this, 'route', function(route, params) {
+        //     console.log('Route: %o; params: %o', route, params);
+        // });
+    },
+
+    start: function() {
+        Backbone.history.start({
+            'pushState': true
+        });
+    },
+
+    topics: function() {
+        Pubsub.trigger('topics:show');
+    },
+
+    topic: function(topic, channel) {
+        if (channel !== null) {
+            Pubsub.trigger('channel:show', topic, channel);
+            return;
+        }
+        Pubsub.trigger('topic:show', topic);
+    },
+
+    lookup: function() {
+        Pubsub.trigger('lookup:show');
+    },
+
+    nodes: function(node) {
+        if (node !== null) {
+            Pubsub.trigger('node:show', node);
+            return;
+        }
+        Pubsub.trigger('nodes:show');
+    },
+
+    counter: function() {
+        Pubsub.trigger('counter:show');
+    },
+
+    alerts: function() {
+        Pubsub.trigger('alerts:show');
+    },
+
+    overview: function() {
+        Pubsub.trigger('overview:show');
+    }
+});
+
+
+module.exports = new Router();
+
+},{"./app_state":36,"./lib/pubsub":42}],48:[function(require,module,exports){
+// hbsfy compiled Handlebars template
+var HandlebarsCompiler = require('hbsfy/runtime');
+module.exports = HandlebarsCompiler.template({"1":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "        <table class=\"table table-bordered table-condensed\">\n            <tr>\n                <th>Rule</th>\n                <th>Type</th>\n                <th>Topic/Channel or Node</th>\n                <th>Value</th>\n                <th>Threshold</th>\n                <th>State</th>\n                <th>Since</th>\n            </tr>\n"
+    + ((stack1 = lookupProperty(helpers,"each").call(alias1,(depth0 != null ? lookupProperty(depth0,"alerts") : depth0),{"name":"each","hash":{},"fn":container.program(2, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":23,"column":12}}})) != null ? stack1 : "")
+    + "        </table>\n";
+},"2":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "            <tr "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"firing") : depth0),{"name":"if","hash":{},"fn":container.program(3, data, 0, blockParams, depths),"inverse":container.program(4, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":24,"column":16}}})) != null ? stack1 : "")
+    + ">\n                <td>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"rule") || (depth0 != null ? lookupProperty(depth0,"rule") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"rule","hash":{},"data":data,"loc":{"start":{"line":25,"column":20}}}) : helper)))
+    + "</td>\n                <td>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"type") || (depth0 != null ? lookupProperty(depth0,"type") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"type","hash":{},"data":data,"loc":{"start":{"line":26,"column":20}}}) : helper)))
+    + "</td>\n                <td>"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"node") : depth0),{"name":"if","hash":{},"fn":container.program(5, data, 0, blockParams, depths),"inverse":container.program(6, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":27,"column":20}}})) != null ? stack1 : "")
+    + "</td>\n                <td>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"value") || (depth0 != null ? lookupProperty(depth0,"value") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"value","hash":{},"data":data,"loc":{"start":{"line":28,"column":20}}}) : helper)))
+    + "</td>\n                <td>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"threshold") || (depth0 != null ? lookupProperty(depth0,"threshold") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"threshold","hash":{},"data":data,"loc":{"start":{"line":29,"column":20}}}) : helper)))
+    + "</td>\n                <td>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"state") || (depth0 != null ? lookupProperty(depth0,"state") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"state","hash":{},"data":data,"loc":{"start":{"line":30,"column":20}}}) : helper)))
+    + "</td>\n                <td>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"since") || (depth0 != null ? lookupProperty(depth0,"since") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"since","hash":{},"data":data,"loc":{"start":{"line":31,"column":20}}}) : helper)))
+    + "</td>\n            </tr>\n";
+},"3":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "class=\"danger\"";
+},"4":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "class=\"warning\"";
+},"5":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return container.escapeExpression(((helper = (helper = lookupProperty(helpers,"node") || (depth0 != null ? lookupProperty(depth0,"node") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"node","hash":{},"data":data,"loc":{"start":{"line":27,"column":32}}}) : helper)));
+},"6":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<a class=\"link\" href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/topics",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":27,"column":70}}}))
+    + "/"
+    + container.escapeExpression((lookupProperty(helpers,"urlencode") || (depth0 && lookupProperty(depth0,"urlencode")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"topic") : depth0),{"name":"urlencode","hash":{},"data":data,"loc":{"start":{"line":27,"column":93}}}))
+    + "/"
+    + container.escapeExpression((lookupProperty(helpers,"urlencode") || (depth0 && lookupProperty(depth0,"urlencode")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"channel") : depth0),{"name":"urlencode","hash":{},"data":data,"loc":{"start":{"line":27,"column":113}}}))
+    + "\">"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"subject") || (depth0 != null ? lookupProperty(depth0,"subject") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"subject","hash":{},"data":data,"loc":{"start":{"line":27,"column":136}}}) : helper)))
+    + "</a>";
+},"7":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "        <div class=\"alert alert-success\"><h4>Notice</h4>No alerts are firing or pending</div>\n";
+},"8":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<div class=\"row\">\n    <div class=\"col-md-12\">\n        <h4>Recently Resolved</h4>\n        <table class=\"table table-bordered table-condensed\">\n            <tr>\n                <th>Rule</th>\n                <th>Type</th>\n                <th>Topic/Channel or Node</th>\n                <th>Fired</th>\n                <th>Resolved</th>\n            </tr>\n"
+    + ((stack1 = lookupProperty(helpers,"each").call(alias1,(depth0 != null ? lookupProperty(depth0,"resolved") : depth0),{"name":"each","hash":{},"fn":container.program(9, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":53,"column":12}}})) != null ? stack1 : "")
+    + "        </table>\n    </div>\n</div>\n";
+},"9":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "            <tr>\n                <td>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"rule") || (depth0 != null ? lookupProperty(depth0,"rule") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"rule","hash":{},"data":data,"loc":{"start":{"line":55,"column":20}}}) : helper)))
+    + "</td>\n                <td>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"type") || (depth0 != null ? lookupProperty(depth0,"type") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"type","hash":{},"data":data,"loc":{"start":{"line":56,"column":20}}}) : helper)))
+    + "</td>\n                <td>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"subject") || (depth0 != null ? lookupProperty(depth0,"subject") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"subject","hash":{},"data":data,"loc":{"start":{"line":57,"column":20}}}) : helper)))
+    + "</td>\n                <td>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"fired_at") || (depth0 != null ? lookupProperty(depth0,"fired_at") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"fired_at","hash":{},"data":data,"loc":{"start":{"line":58,"column":20}}}) : helper)))
+    + "</td>\n                <td>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"resolved_at") || (depth0 != null ? lookupProperty(depth0,"resolved_at") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"resolved_at","hash":{},"data":data,"loc":{"start":{"line":59,"column":20}}}) : helper)))
+    + "</td>\n            </tr>\n";
+},"10":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "            <tr>\n                <td>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"name") || (depth0 != null ? lookupProperty(depth0,"name") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"name","hash":{},"data":data,"loc":{"start":{"line":82,"column":20}}}) : helper)))
+    + "</td>\n                <td>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"type") || (depth0 != null ? lookupProperty(depth0,"type") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"type","hash":{},"data":data,"loc":{"start":{"line":83,"column":20}}}) : helper)))
+    + "</td>\n                <td>"
+    + container.escapeExpression((lookupProperty(helpers,"default") || (depth0 && lookupProperty(depth0,"default")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"topic") : depth0),"*",{"name":"default","hash":{},"data":data,"loc":{"start":{"line":84,"column":20}}}))
+    + "</td>\n                <td>"
+    + container.escapeExpression((lookupProperty(helpers,"default") || (depth0 && lookupProperty(depth0,"default")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"channel") : depth0),"*",{"name":"default","hash":{},"data":data,"loc":{"start":{"line":85,"column":20}}}))
+    + "</td>\n                <td>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"node") || (depth0 != null ? lookupProperty(depth0,"node") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"node","hash":{},"data":data,"loc":{"start":{"line":86,"column":20}}}) : helper)))
+    + "</td>\n                <td>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"threshold") || (depth0 != null ? lookupProperty(depth0,"threshold") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"threshold","hash":{},"data":data,"loc":{"start":{"line":87,"column":20}}}) : helper)))
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"quantile") : depth0),{"name":"if","hash":{},"fn":container.program(11, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":87,"column":33}}})) != null ? stack1 : "")
+    + "</td>\n                <td>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"for") || (depth0 != null ? lookupProperty(depth0,"for") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"for","hash":{},"data":data,"loc":{"start":{"line":88,"column":20}}}) : helper)))
+    + "</td>\n            </tr>\n";
+},"11":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return " (p"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"quantile") || (depth0 != null ? lookupProperty(depth0,"quantile") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"quantile","hash":{},"data":data,"loc":{"start":{"line":87,"column":52}}}) : helper)))
+    + ")";
+},"main":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return ((stack1 = container.invokePartial(lookupProperty(partials,"warning"),depth0,{"name":"warning","data":data,"helpers":helpers,"partials":partials,"decorators":container.decorators})) != null ? stack1 : "")
+    + ((stack1 = container.invokePartial(lookupProperty(partials,"error"),depth0,{"name":"error","data":data,"helpers":helpers,"partials":partials,"decorators":container.decorators})) != null ? stack1 : "")
+    + "\n<div class=\"row\">\n    <div class=\"col-md-12\">\n        <h2>Alerts</h2>\n    </div>\n</div>\n\n<div class=\"row\">\n    <div class=\"col-md-12\">\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"alerts") : depth0)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"if","hash":{},"fn":container.program(1, data, 0, blockParams, depths),"inverse":container.program(7, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":12,"column":4}}})) != null ? stack1 : "")
+    + "    </div>\n</div>\n\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"resolved") : depth0)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"if","hash":{},"fn":container.program(8, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":41,"column":0}}})) != null ? stack1 : "")
+    + "\n<div class=\"row\">\n    <div class=\"col-md-12\">\n        <h4>Rules</h4>\n        <table class=\"table table-bordered table-condensed\">\n            <tr>\n                <th>Name</th>\n                <th>Type</th>\n                <th>Topic</th>\n                <th>Channel</th>\n                <th>Node</th>\n                <th>Threshold</th>\n                <th>For</th>\n            </tr>\n"
+    + ((stack1 = lookupProperty(helpers,"each").call(alias1,(depth0 != null ? lookupProperty(depth0,"rules") : depth0),{"name":"each","hash":{},"fn":container.program(10, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":80,"column":12}}})) != null ? stack1 : "")
+    + "        </table>\n    </div>\n</div>\n";
+},"compiler":[8,">= 4.3.0"],"usePartial":true,"useData":true,"useDepths":true});
+
+},{"hbsfy/runtime":35}],49:[function(require,module,exports){
+var _ = require('underscore');
+var $ = require('jquery');
+
+var AppState = require('../app_state');
+var Pubsub = require('../lib/pubsub');
+
+var BaseView = require('./base');
+
+var formatTime = function(ts) {
+    return ts ? new Date(ts * 1000).toLocaleString() : '';
+};
+
+var formatAlert = function(a) {
+    return _.extend({}, a, {
+        'subject': a['node'] || (a['topic'] + '/' + a['channel']),
+        'firing': a['state'] === 'firing',
+        'value': +a['value'].toFixed(2),
+        'since': formatTime(a['since']),
+        'fired_at': formatTime(a['fired_at']),
+        'resolved_at': formatTime(a['resolved_at'])
+    });
+};
+
+var AlertsView = BaseView.extend({
+    className: 'alerts container-fluid',
+
+    template: require('./spinner.hbs'),
+
+    initialize: function() {
+        BaseView.prototype.initialize.apply(this, arguments);
+        this.fetch();
+    },
+
+    remove: function() {
+        clearTimeout(this.poller);
+        BaseView.prototype.remove.apply(this, arguments);
+    },
+
+    fetch: function() {
+        $.ajax(AppState.apiPath('/alerts'))
+            .done(function(data) {
+                this.template = require('./alerts.hbs');
+                this.render({
+                    'rules': data['rules'],
+                    'alerts': _.map(data['alerts'], formatAlert),
+                    'resolved': _.map(data['resolved'], formatAlert)
+                });
+                this.poller = setTimeout(this.fetch.bind(this), data['interval'] * 1000);
+            }.bind(this))
+            .fail(this.handleViewError.bind(this))
+            .always(Pubsub.trigger.bind(Pubsub, 'view:ready'));
+    }
+});
+
+module.exports = AlertsView;
+
+},{"../app_state":36,"../lib/pubsub":42,"./alerts.hbs":48,"./base":51,"./spinner.hbs":72}],50:[function(require,module,exports){
+var $ = require('jquery');
+
+window.jQuery = $;
+var bootstrap = require('bootstrap'); //eslint-disable-line no-unused-vars
+var bootbox = require('bootbox');
+
+var AppState = require('../app_state');
+var Pubsub = require('../lib/pubsub');
+var Router = require('../router');
+
+var BaseView = require('./base');
+var HeaderView = require('./header');
+var TopicsView = require('./topics');
+var TopicView = require('./topic');
+var ChannelView = require('./channel');
+var LookupView = require('./lookup');
+var NodesView = require('./nodes');
+var NodeView = require('./node');
+var CounterView = require('./counter');
+var AlertsView = require('./alerts');
+var OverviewView = require('./overview');
+
+var Node = require('../models/node'); //eslint-disable-line no-undef
+var Topic = require('../models/topic');
+var Channel = require('../models/channel');
+
+var AppView = BaseView.extend({
+    // not a fan of setting a view's el to an existing element on the page
+    // for the top-level AppView, it seems appropriate, however
+    el: '#container',
+
+    events: {
+        'click .link': 'onLinkClick',
+        'click .tombstone-link': 'onTombstoneClick'
+    },
+
+    initialize: function() {
+        BaseView.prototype.initialize.apply(this, arguments);
+
+        this.listenTo(Pubsub, 'topics:show', this.showTopics);
+        this.listenTo(Pubsub, 'topic:show', this.showTopic);
+        this.listenTo(Pubsub, 'channel:show', this.showChannel);
+        this.listenTo(Pubsub, 'lookup:show', this.showLookup);
+        this.listenTo(Pubsub, 'nodes:show', this.showNodes);
+        this.listenTo(Pubsub, 'node:show', this.showNode);
+        this.listenTo(Pubsub, 'counter:show', this.showCounter);
+        this.listenTo(Pubsub, 'alerts:show', this.showAlerts);
+        this.listenTo(Pubsub, 'overview:show', this.showOverview);
+
+        this.listenTo(Pubsub, 'view:ready', function() {
+            $('.rate').each(function(i, el) {
+                var $el = $(el);
+                var interval = AppState.get('STATSD_INTERVAL');
+                var q = {
+                    'target': $el.attr('target'),
+                    'from': '-' + (2 * interval) + 'sec',
+                    'until': '-' + interval + 'sec',
+                    'format': 'json',
+                };
+                var formatRate = function(data) {
+                    if (data[0] === null ||
+                        data[0]['datapoints'][0] === null ||
+                        data[0]['datapoints'][0][0] < 0) {
+                        return 'N/A';
+                    } else {
+                        return (data[0]['datapoints'][0][0] / interval).toFixed(2);
+                    }
+                };
+                $.ajax({
+                    url: AppState.get('GRAPHITE_URL') + '/render',
+                    data: q,
+                    dataType: 'jsonp',
+                    jsonp: 'jsonp'
+                })
+                    .done(function(data) { $el.html(formatRate(data)); })
+                    .fail(function() { $el.html('ERROR'); });
+            });
+        });
+        this.render();
+    },
+
+    postRender: function() {
+        this.appendSubview(new HeaderView());
+    },
+
+    showView: function(f) {
+        window.scrollTo(0, 0);
+        if (this.currentView) {
+            this.currentView.remove();
+        }
+        this.currentView = f();
+        this.appendSubview(this.currentView);
+    },
+
+    showTopics: function() {
+        this.showView(function() {
+            return new TopicsView();
+        });
+    },
+
+    showTopic: function(topic) {
+        this.showView(function() {
+            var model = new Topic({'name': topic, 'isAdmin': AppState.get('IS_ADMIN')});
+            return new TopicView({'model': model});
+        });
+    },
+
+    showChannel: function(topic, channel) {
+        this.showView(function() {
+            var model = new Channel({
+                'topic': topic,
+                'name': channel,
+                'isAdmin': AppState.get('IS_ADMIN')
+            });
+            return new ChannelView({'model': model});
+        });
+    },
+
+    showLookup: function() {
+        this.showView(function() {
+            return new LookupView({'isAdmin': AppState.get('IS_ADMIN')});
+        });
+    },
+
+    showNodes: function() {
+        this.showView(function() {
+            return new NodesView();
+        });
+    },
+
+    showNode: function(node) {
+        this.showView(function() {
+            var model = new Node({'name': node});
+            return new NodeView({'model': model});
+        });
+    },
+
+    showCounter: function() {
+        this.showView(function() {
+            return new CounterView();
+        });
+    },
+
+    showAlerts: function() {
+        this.showView(function() {
+            return new AlertsView();
+        });
+    },
+
+    showOverview: function() {
+        this.showView(function() {
+            return new OverviewView();
+        });
+    },
+
+    onLinkClick: function(e) {
+        if (e.ctrlKey || e.metaKey) {
+            // allow ctrl+click to open in a new tab
+            return;
+        }
+        e.preventDefault();
+        e.stopPropagation();
+        Router.navigate($(e.currentTarget).attr('href'), {'trigger': true});
+    },
+
+    onTombstoneClick: function(e) {
+        e.preventDefault();
+        e.stopPropagation();
+        var nodeName = $(e.target).data('node');
+        var topicName = $(e.target).data('topic');
+        var txt = 'Are you sure you want to <strong>tombstone</strong> <em>' + nodeName + '</em>?';
+        bootbox.confirm(txt, function(result) {
+            if (result !== true) {
+                return;
+            }
+            var node = new Node({
+                'name': nodeName
+            });
+            node.tombstoneTopic(topicName)
+                .done(function() { window.location.reload(true); })
+                .fail(this.handleAJAXError.bind(this));
+        }.bind(this));
+    }
+});
+
+module.exports = AppView;
+
+},{"../app_state":36,"../lib/pubsub":42,"../models/channel":44,"../models/node":45,"../models/topic":46,"../router":47,"./alerts":49,"./base":51,"./channel":54,"./counter":56,"./header":60,"./lookup":64,"./node":66,"./nodes":68,"./overview":70,"./topic":74,"./topics":76,"bootstrap":1}],51:[function(require,module,exports){
+var $ = require('jquery');
+var _ = require('underscore');
+var Backbone = require('backbone');
+
+var AppState = require('../app_state');
+
+var errorTemplate = require('./error.hbs');
+
+
+var BaseView = Backbone.View.extend({
+    constructor: function(options) {
+        // As of 1.10, Backbone no longer automatically attaches options passed
+        // to the constructor as this.options, but that's often useful in some
+        // cases, like a className function, that happen before initialize()
+        // would have a chance to attach the same options.
+        this.options = options || {};
+        return Backbone.View.prototype.constructor.apply(this, arguments);
+    },
+
+    initialize: function() {
+        this.subviews = [];
+        this.rendered = false;
+    },
+
+    template: function() {},
+
+    skippedRender: function() {},
+
+    render: function(data) {
+        if (this.renderOnce && this.rendered) {
+            this.skippedRender();
+            return this;
+        }
+        this.removeSubviews();
+        var ctx = this.getRenderCtx(data);
+        // console.log('render ctx: %o', ctx);
+        var html = this.template(ctx);
+        if (!this.removed) {
+            this.$el.empty();
+            this.$el.append(html);
+            this.postRender(ctx);
+        }
+        this.rendered = true;
+        return this;
+    },
+
+    getRenderCtx: function(data) {
+        var ctx = {
+            'graph_enabled': AppState.get('GRAPH_ENABLED'),
+            'graph_interval': AppState.get('graph_interval'),
+            'graph_active': AppState.get('GRAPH_ENABLED') &&
+                AppState.get('graph_interval') !== 'off',
+            'history_enabled': AppState.get('HISTORY_ENABLED'),
+            'history_active': AppState.get('HISTORY_ENABLED') &&
+                AppState.get('graph_interval') !== 'off',
+            'nsqlookupd': AppState.get('NSQLOOKUPD'),
+            'version': AppState.get('VERSION')
+        };
+        if (this.model) {
+            ctx = _.extend(ctx, this.model.toJSON());
+        } else if (this.collection) {
+            ctx = _.extend(ctx, {'collection': this.collection.toJSON()});
+        }
+        if (data) {
+            ctx = _.extend(ctx, data);
+        }
+        return ctx;
+    },
+
+    postRender: function() {},
+
+    appendSubview: function(subview, selector) {
+        return this.appendSubviews([subview], selector);
+    },
+
+    appendSubviews: function(subviews, selector) {
+        this.subviews.push.apply(this.subviews, subviews);
+        var $el = selector ? this.$(selector) : this.$el;
+        $el.append(subviews.map(function(subview) {
+            return subview.render().delegateEvents().el;
+        }));
+    },
+
+    removeSubviews: function() {
+        while (this.subviews.length) {
+            this.subviews.pop().remove();
+        }
+    },
+
+    remove: function() {
+        this.removed = true;
+        this.removeSubviews();
+        Backbone.View.prototype.remove.apply(this, arguments);
+    },
+
+    parseErrorMessage: function(jqXHR) {
+        var msg = 'ERROR: failed to connect to nsqadmin';
+        if (jqXHR.readyState === 4) {
+            try {
+                var parsed = JSON.parse(jqXHR.responseText);
+                msg = parsed['message'];
+            } catch (err) {
+                msg = 'ERROR: failed to decode JSON - ' + err.message;
+            }
+        }
+        return msg;
+    },
+
+    handleAJAXError: function(jqXHR) {
+        $('#warning, #error').hide();
+        $('#error .alert').text(this.parseErrorMessage(jqXHR));
+        $('#error').show();
+    },
+
+    handleViewError: function(jqXHR) {
+        this.removeSubviews();
+        this.$el.html(errorTemplate({'message': this.parseErrorMessage(jqXHR)}));
+    }
+});
+
+module.exports = BaseView;
+
+},{"../app_state":36,"./error.hbs":58}],52:[function(require,module,exports){
+// hbsfy compiled Handlebars template
+var HandlebarsCompiler = require('hbsfy/runtime');
+module.exports = HandlebarsCompiler.template({"1":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<div class=\"alert alert-warning\"><h4>Notice</h4>Nothing matches</div>\n";
+},"2":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<table class=\"table table-bordered table-condensed\">\n    <tr>\n        <th>Topic</th>\n        <th>Channel</th>\n        <th>NSQd Hosts</th>\n    </tr>\n"
+    + ((stack1 = lookupProperty(helpers,"each").call(alias1,(depth0 != null ? lookupProperty(depth0,"targets") : depth0),{"name":"each","hash":{},"fn":container.program(3, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":10,"column":4}}})) != null ? stack1 : "")
+    + "</table>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"dry_run") : depth0),{"name":"if","hash":{},"fn":container.program(5, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":18,"column":0}}})) != null ? stack1 : "");
+},"3":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "    <tr>\n        <td>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"topic") || (depth0 != null ? lookupProperty(depth0,"topic") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"topic","hash":{},"data":data,"loc":{"start":{"line":12,"column":12}}}) : helper)))
+    + "</td>\n        <td>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"channel") || (depth0 != null ? lookupProperty(depth0,"channel") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"channel","hash":{},"data":data,"loc":{"start":{"line":13,"column":12}}}) : helper)))
+    + "</td>\n        <td>"
+    + ((stack1 = lookupProperty(helpers,"each").call(alias1,(depth0 != null ? lookupProperty(depth0,"nodes") : depth0),{"name":"each","hash":{},"fn":container.program(4, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":14,"column":12}}})) != null ? stack1 : "")
+    + "</td>\n    </tr>\n";
+},"4":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<a class=\"link\" href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/nodes",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":14,"column":49}}}))
+    + "/"
+    + container.escapeExpression(container.lambda(depth0, depth0))
+    + "\">"
+    + container.escapeExpression(container.lambda(depth0, depth0))
+    + "</a> ";
+},"5":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<button class=\"btn btn-medium btn-warning bulk-apply\">"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"action") || (depth0 != null ? lookupProperty(depth0,"action") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"action","hash":{},"data":data,"loc":{"start":{"line":19,"column":54}}}) : helper)))
+    + " "
+    + container.escapeExpression(container.lambda(((stack1 = (depth0 != null ? lookupProperty(depth0,"targets") : depth0)) != null ? lookupProperty(stack1,"length") : stack1), depth0))
+    + " "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"channel") : depth0),{"name":"if","hash":{},"fn":container.program(6, data, 0, blockParams, depths),"inverse":container.program(7, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":19,"column":84}}})) != null ? stack1 : "")
+    + "</button>\n";
+},"6":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "channel(s)";
+},"7":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "topic(s)";
+},"main":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return ((stack1 = lookupProperty(helpers,"unless").call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"targets") : depth0)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"unless","hash":{},"fn":container.program(1, data, 0, blockParams, depths),"inverse":container.program(2, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":1,"column":0}}})) != null ? stack1 : "");
+},"compiler":[8,">= 4.3.0"],"useData":true,"useDepths":true});
+
+},{"hbsfy/runtime":35}],53:[function(require,module,exports){
+// hbsfy compiled Handlebars template
+var HandlebarsCompiler = require('hbsfy/runtime');
+module.exports = HandlebarsCompiler.template({"1":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<div class=\"row\">\n    <div class=\"col-md-6\">\n        <div class=\"alert alert-warning\">\n            <h4>Notice</h4> No producers exist for this topic/channel.\n            <p>See <a class=\"link\" href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/lookup",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":24,"column":41}}}))
+    + "\">Lookup</a> for more information.\n        </div>\n    </div>\n</div>\n";
+},"2":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<div class=\"row\">\n    <div class=\"col-md-8\">\n    <h4>Consumer Lag</h4>\n    <div class=\"channel-lag\">"
+    + ((stack1 = container.invokePartial(lookupProperty(partials,"lag"),(depth0 != null ? lookupProperty(depth0,"lag") : depth0),{"name":"lag","data":data,"helpers":helpers,"partials":partials,"decorators":container.decorators})) != null ? stack1 : "")
+    + "</div>\n    </div>\n</div>\n\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"isAdmin") : depth0),{"name":"if","hash":{},"fn":container.program(3, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":36,"column":0}}})) != null ? stack1 : "")
+    + "\n<div class=\"row\">\n    <div class=\"col-md-12\">\n    <h4>Channel</h4>\n    <table class=\"table table-bordered table-condensed\">\n        <tr>\n            <th>&nbsp;</th>\n            <th colspan=\"4\" class=\"text-center\">Message Queues</th>\n            <th colspan=\""
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"graph_active") : depth0),{"name":"if","hash":{},"fn":container.program(6, data, 0, blockParams, depths),"inverse":container.program(7, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":61,"column":25}}})) != null ? stack1 : "")
+    + "\" class=\"text-center\">Statistics</th>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,((stack1 = ((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"if","hash":{},"fn":container.program(8, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":62,"column":12}}})) != null ? stack1 : "")
+    + "        </tr>\n        <tr>\n            <th>NSQd Host</th>\n            <th>Depth</th>\n            <th>Memory + Disk</th>\n            <th>In-Flight</th>\n            <th>Deferred</th>\n            <th>Requeued</th>\n            <th>Timed Out</th>\n            <th>Messages</th>\n            "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"graph_active") : depth0),{"name":"if","hash":{},"fn":container.program(9, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":75,"column":12}}})) != null ? stack1 : "")
+    + "\n            <th>Connections</th>\n"
+    + ((stack1 = lookupProperty(helpers,"each").call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1),{"name":"each","hash":{},"fn":container.program(10, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":77,"column":12}}})) != null ? stack1 : "")
+    + "        </tr>\n"
+    + ((stack1 = lookupProperty(helpers,"each").call(alias1,(depth0 != null ? lookupProperty(depth0,"nodes") : depth0),{"name":"each","hash":{},"fn":container.program(11, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":81,"column":8}}})) != null ? stack1 : "")
+    + "        <tr class=\"info\">\n            <td>Total:</td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"depth") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":133,"column":16}}}))
+    + "</td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"memory_depth") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":134,"column":16}}}))
+    + " + "
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"backend_depth") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":134,"column":43}}}))
+    + "</td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"in_flight_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":135,"column":16}}}))
+    + "</td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"deferred_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":136,"column":16}}}))
+    + "</td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"requeue_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":137,"column":16}}}))
+    + "</td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"timeout_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":138,"column":16}}}))
+    + "</td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"message_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":139,"column":16}}}))
+    + "</td>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"graph_active") : depth0),{"name":"if","hash":{},"fn":container.program(20, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":140,"column":12}}})) != null ? stack1 : "")
+    + "            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"client_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":143,"column":16}}}))
+    + "</td>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,((stack1 = ((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"if","hash":{},"fn":container.program(21, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":144,"column":12}}})) != null ? stack1 : "")
+    + "        </tr>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"graph_active") : depth0),{"name":"if","hash":{},"fn":container.program(23, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":152,"column":8}}})) != null ? stack1 : "")
+    + "    </table>\n    </div>\n</div>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"isAdmin") : depth0),{"name":"if","hash":{},"fn":container.program(25, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":174,"column":0}}})) != null ? stack1 : "");
+},"3":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<div class=\"row channel-actions\">\n    <div class=\"col-md-2\">\n        <button class=\"btn btn-medium btn-warning\" data-action=\"empty\">Empty Queue</button>\n    </div>\n    <div class=\"col-md-2\">\n        <button class=\"btn btn-medium btn-danger\" data-action=\"delete\">Delete Channel</button>\n    </div>\n    <div class=\"col-md-2\">\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"paused") : depth0),{"name":"if","hash":{},"fn":container.program(4, data, 0, blockParams, depths),"inverse":container.program(5, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":45,"column":8}}})) != null ? stack1 : "")
+    + "    </div>\n</div>\n";
+},"4":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "        <button class=\"btn btn-medium btn-success\" data-action=\"unpause\">UnPause Channel</button>\n";
+},"5":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "        <button class=\"btn btn-medium btn-primary\" data-action=\"pause\">Pause Channel</button>\n";
+},"6":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "5";
+},"7":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "4";
+},"8":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "            <th colspan=\""
+    + container.escapeExpression(container.lambda(((stack1 = ((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1)) != null ? lookupProperty(stack1,"length") : stack1), depth0))
+    + "\">E2E Processing Latency</th>\n";
+},"9":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<th>Rate</th>";
+},"10":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                <th>"
+    + container.escapeExpression((lookupProperty(helpers,"floatToPercent") || (depth0 && lookupProperty(depth0,"floatToPercent")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"quantile") : depth0),{"name":"floatToPercent","hash":{},"data":data,"loc":{"start":{"line":78,"column":20}}}))
+    + "<sup>"
+    + container.escapeExpression((lookupProperty(helpers,"percSuffix") || (depth0 && lookupProperty(depth0,"percSuffix")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"quantile") : depth0),{"name":"percSuffix","hash":{},"data":data,"loc":{"start":{"line":78,"column":52}}}))
+    + "</sup></th>\n";
+},"11":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "        <tr>\n            <td>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"show_broadcast_address") : depth0),{"name":"if","hash":{},"fn":container.program(12, data, 0, blockParams, depths),"inverse":container.program(13, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":84,"column":16}}})) != null ? stack1 : "")
+    + "                "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"paused") : depth0),{"name":"if","hash":{},"fn":container.program(14, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":89,"column":16}}})) != null ? stack1 : "")
+    + "\n"
+    + ((stack1 = container.invokePartial(lookupProperty(partials,"depth_limits"),depth0,{"name":"depth_limits","data":data,"indent":"                ","helpers":helpers,"partials":partials,"decorators":container.decorators})) != null ? stack1 : "")
+    + "            </td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"depth") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":92,"column":16}}}))
+    + "</td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"memory_depth") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":93,"column":16}}}))
+    + " + "
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"backend_depth") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":93,"column":43}}}))
+    + "</td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"in_flight_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":94,"column":16}}}))
+    + "</td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"deferred_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":95,"column":16}}}))
+    + "</td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"requeue_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":96,"column":16}}}))
+    + "</td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"timeout_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":97,"column":16}}}))
+    + "</td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"message_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":98,"column":16}}}))
+    + "</td>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depths[1] != null ? lookupProperty(depths[1],"graph_active") : depths[1]),{"name":"if","hash":{},"fn":container.program(15, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":99,"column":12}}})) != null ? stack1 : "")
+    + "            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"client_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":102,"column":16}}}))
+    + "</td>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,((stack1 = ((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"if","hash":{},"fn":container.program(16, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":103,"column":12}}})) != null ? stack1 : "")
+    + "        </tr>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depths[1] != null ? lookupProperty(depths[1],"graph_active") : depths[1]),{"name":"if","hash":{},"fn":container.program(18, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":111,"column":8}}})) != null ? stack1 : "");
+},"12":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                "
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"hostname_port") || (depth0 != null ? lookupProperty(depth0,"hostname_port") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"hostname_port","hash":{},"data":data,"loc":{"start":{"line":85,"column":16}}}) : helper)))
+    + " (<a class=\"link\" href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/nodes",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":85,"column":57}}}))
+    + "/"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"node") || (depth0 != null ? lookupProperty(depth0,"node") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"node","hash":{},"data":data,"loc":{"start":{"line":85,"column":79}}}) : helper)))
+    + "\">"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"node") || (depth0 != null ? lookupProperty(depth0,"node") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"node","hash":{},"data":data,"loc":{"start":{"line":85,"column":89}}}) : helper)))
+    + "</a>)\n";
+},"13":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                <a class=\"link\" href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/nodes",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":87,"column":38}}}))
+    + "/"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"node") || (depth0 != null ? lookupProperty(depth0,"node") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"node","hash":{},"data":data,"loc":{"start":{"line":87,"column":60}}}) : helper)))
+    + "\">"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"hostname_port") || (depth0 != null ? lookupProperty(depth0,"hostname_port") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"hostname_port","hash":{},"data":data,"loc":{"start":{"line":87,"column":70}}}) : helper)))
+    + "</a>\n";
+},"14":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return " <span class=\"label label-primary\">paused</span>";
+},"15":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                <td class=\"bold rate\" target=\""
+    + container.escapeExpression((lookupProperty(helpers,"rate") || (depth0 && lookupProperty(depth0,"rate")) || container.hooks.helperMissing).call(alias1,"topic",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),"",{"name":"rate","hash":{},"data":data,"loc":{"start":{"line":100,"column":46}}}))
+    + "\"></td>\n";
+},"16":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return ((stack1 = lookupProperty(helpers,"each").call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1),{"name":"each","hash":{},"fn":container.program(17, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":104,"column":16}}})) != null ? stack1 : "");
+},"17":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                <td>\n                    <span title=\""
+    + container.escapeExpression((lookupProperty(helpers,"floatToPercent") || (depth0 && lookupProperty(depth0,"floatToPercent")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"quantile") : depth0),{"name":"floatToPercent","hash":{},"data":data,"loc":{"start":{"line":106,"column":33}}}))
+    + ": min = "
+    + container.escapeExpression((lookupProperty(helpers,"nanotohuman") || (depth0 && lookupProperty(depth0,"nanotohuman")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"min") : depth0),{"name":"nanotohuman","hash":{},"data":data,"loc":{"start":{"line":106,"column":68}}}))
+    + ", max = "
+    + container.escapeExpression((lookupProperty(helpers,"nanotohuman") || (depth0 && lookupProperty(depth0,"nanotohuman")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"max") : depth0),{"name":"nanotohuman","hash":{},"data":data,"loc":{"start":{"line":106,"column":95}}}))
+    + "\">"
+    + container.escapeExpression((lookupProperty(helpers,"nanotohuman") || (depth0 && lookupProperty(depth0,"nanotohuman")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"average") : depth0),{"name":"nanotohuman","hash":{},"data":data,"loc":{"start":{"line":106,"column":116}}}))
+    + "</span>\n                </td>\n";
+},"18":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "        <tr class=\"graph-row\">\n            <td></td>\n            <td><a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"depth",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":114,"column":25}}}))
+    + "\"><img width=\"120\" height=\"20\"  src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"depth",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":114,"column":124}}}))
+    + "\"></a></td>\n            <td></td>\n            <td><a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"in_flight_count",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":116,"column":25}}}))
+    + "\"><img width=\"120\" height=\"20\"  src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"in_flight_count",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":116,"column":134}}}))
+    + "\"></a></td>\n            <td><a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"deferred_count",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":117,"column":25}}}))
+    + "\"><img width=\"120\" height=\"20\"  src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"deferred_count",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":117,"column":133}}}))
+    + "\"></a></td>\n            <td><a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"requeue_count",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":118,"column":25}}}))
+    + "\"><img width=\"120\" height=\"20\"  src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"requeue_count",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":118,"column":132}}}))
+    + "\"></a></td>\n            <td><a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"timeout_count",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":119,"column":25}}}))
+    + "\"><img width=\"120\" height=\"20\"  src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"timeout_count",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":119,"column":132}}}))
+    + "\"></a></td>\n            <td><a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"message_count",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":120,"column":25}}}))
+    + "\"><img width=\"120\" height=\"20\"  src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"message_count",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":120,"column":132}}}))
+    + "\"></a></td>\n            <td></td>\n            <td><a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"clients",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":122,"column":25}}}))
+    + "\"><img width=\"120\" height=\"20\"  src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"clients",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":122,"column":126}}}))
+    + "\"></a></td>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,((stack1 = ((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"if","hash":{},"fn":container.program(19, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":123,"column":12}}})) != null ? stack1 : "")
+    + "        </tr>\n";
+},"19":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "            <td colspan=\""
+    + container.escapeExpression(container.lambda(((stack1 = ((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1)) != null ? lookupProperty(stack1,"length") : stack1), depth0))
+    + "\">\n                <a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"e2e",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0),"","e2e_processing_latency",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":125,"column":25}}}))
+    + "\"><img width=\"120\" height=\"20\" src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"e2e",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0),"","e2e_processing_latency",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":125,"column":138}}}))
+    + "\"></a>\n            </td>\n";
+},"20":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                <td class=\"bold rate\" target=\""
+    + container.escapeExpression((lookupProperty(helpers,"rate") || (depth0 && lookupProperty(depth0,"rate")) || container.hooks.helperMissing).call(alias1,"topic",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),"",{"name":"rate","hash":{},"data":data,"loc":{"start":{"line":141,"column":46}}}))
+    + "\"></td>\n";
+},"21":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return ((stack1 = lookupProperty(helpers,"each").call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1),{"name":"each","hash":{},"fn":container.program(22, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":145,"column":16}}})) != null ? stack1 : "");
+},"22":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                <td>\n                    <span title=\""
+    + container.escapeExpression((lookupProperty(helpers,"floatToPercent") || (depth0 && lookupProperty(depth0,"floatToPercent")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"quantile") : depth0),{"name":"floatToPercent","hash":{},"data":data,"loc":{"start":{"line":147,"column":33}}}))
+    + ": min = "
+    + container.escapeExpression((lookupProperty(helpers,"nanotohuman") || (depth0 && lookupProperty(depth0,"nanotohuman")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"min") : depth0),{"name":"nanotohuman","hash":{},"data":data,"loc":{"start":{"line":147,"column":68}}}))
+    + ", max = "
+    + container.escapeExpression((lookupProperty(helpers,"nanotohuman") || (depth0 && lookupProperty(depth0,"nanotohuman")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"max") : depth0),{"name":"nanotohuman","hash":{},"data":data,"loc":{"start":{"line":147,"column":95}}}))
+    + "\">"
+    + container.escapeExpression((lookupProperty(helpers,"nanotohuman") || (depth0 && lookupProperty(depth0,"nanotohuman")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"average") : depth0),{"name":"nanotohuman","hash":{},"data":data,"loc":{"start":{"line":147,"column":116}}}))
+    + "</span>\n                </td>\n";
+},"23":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "        <tr class=\"graph-row\">\n            <td></td>\n            <td><a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"depth",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":155,"column":25}}}))
+    + "\"><img width=\"120\" height=\"20\"  src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"depth",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":155,"column":124}}}))
+    + "\"></a></td>\n            <td></td>\n            <td><a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"in_flight_count",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":157,"column":25}}}))
+    + "\"><img width=\"120\" height=\"20\"  src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"in_flight_count",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":157,"column":134}}}))
+    + "\"></a></td>\n            <td><a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"deferred_count",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":158,"column":25}}}))
+    + "\"><img width=\"120\" height=\"20\"  src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"deferred_count",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":158,"column":133}}}))
+    + "\"></a></td>\n            <td><a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"requeue_count",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":159,"column":25}}}))
+    + "\"><img width=\"120\" height=\"20\"  src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"requeue_count",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":159,"column":132}}}))
+    + "\"></a></td>\n            <td><a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"timeout_count",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":160,"column":25}}}))
+    + "\"><img width=\"120\" height=\"20\"  src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"timeout_count",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":160,"column":132}}}))
+    + "\"></a></td>\n            <td><a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"message_count",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":161,"column":25}}}))
+    + "\"><img width=\"120\" height=\"20\"  src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"message_count",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":161,"column":132}}}))
+    + "\"></a></td>\n            <td></td>\n            <td><a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"clients",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":163,"column":25}}}))
+    + "\"><img width=\"120\" height=\"20\"  src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"clients",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":163,"column":126}}}))
+    + "\"></a></td>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,((stack1 = ((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"if","hash":{},"fn":container.program(24, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":164,"column":12}}})) != null ? stack1 : "")
+    + "        </tr>\n";
+},"24":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "            <td colspan=\""
+    + container.escapeExpression(container.lambda(((stack1 = ((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1)) != null ? lookupProperty(stack1,"length") : stack1), depth0))
+    + "\">\n                <a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"e2e",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0),"","e2e_processing_latency",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":166,"column":25}}}))
+    + "\"><img width=\"120\" height=\"20\"  src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"e2e",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0),"","e2e_processing_latency",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":166,"column":139}}}))
+    + "\"></a>\n            </td>\n";
+},"25":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<div class=\"row\">\n    <div class=\"col-md-12\">\n    <h4>Messages</h4>\n    <form class=\"form-inline peek-messages\">\n        <select class=\"form-control\" name=\"node\">\n            <option value=\"\">all nodes</option>\n"
+    + ((stack1 = lookupProperty(helpers,"each").call(alias1,(depth0 != null ? lookupProperty(depth0,"nodes") : depth0),{"name":"each","hash":{},"fn":container.program(26, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":181,"column":12}}})) != null ? stack1 : "")
+    + "        </select>\n        <input class=\"form-control\" type=\"number\" name=\"count\" min=\"1\" max=\"100\" value=\"10\">\n        <label class=\"checkbox-inline\"><input type=\"checkbox\" name=\"pretty\" checked> pretty-print JSON</label>\n        <button class=\"btn btn-default\" type=\"submit\">Peek</button>\n        <button class=\"btn btn-default peek-download\" type=\"button\" disabled>Download</button>\n    </form>\n    <p><small>Peeking does not consume messages.</small></p>\n    <div class=\"peeked-messages\"></div>\n    </div>\n</div>\n";
+},"26":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "            <option value=\""
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"node") || (depth0 != null ? lookupProperty(depth0,"node") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"node","hash":{},"data":data,"loc":{"start":{"line":182,"column":27}}}) : helper)))
+    + "\">"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"hostname_port") || (depth0 != null ? lookupProperty(depth0,"hostname_port") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"hostname_port","hash":{},"data":data,"loc":{"start":{"line":182,"column":37}}}) : helper)))
+    + "</option>\n";
+},"27":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "            <div class=\"alert alert-warning\"><h4>Notice</h4>No clients connected to this channel</div>\n";
+},"28":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "        <table class=\"table table-bordered table-condensed\">\n            <tr>\n                <th>Client Host</th>\n                <th>User-Agent</th>\n                <th>Attributes</th>\n                <th>NSQd Host</th>\n                <th>In-Flight</th>\n                <th>Ready Count</th>\n                <th>Finished</th>\n                <th>Requeued</th>\n                <th>Messages</th>\n                <th>Connected</th>\n            </tr>\n"
+    + ((stack1 = lookupProperty(helpers,"each").call(alias1,(depth0 != null ? lookupProperty(depth0,"clients") : depth0),{"name":"each","hash":{},"fn":container.program(29, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":219,"column":12}}})) != null ? stack1 : "")
+    + "        </table>\n";
+},"29":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "            <tr>\n                <td title=\""
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"remote_address") || (depth0 != null ? lookupProperty(depth0,"remote_address") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"remote_address","hash":{},"data":data,"loc":{"start":{"line":221,"column":27}}}) : helper)))
+    + "\">"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"hostname_port") || (depth0 != null ? lookupProperty(depth0,"hostname_port") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"hostname_port","hash":{},"data":data,"loc":{"start":{"line":221,"column":47}}}) : helper)))
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"show_client_id") : depth0),{"name":"if","hash":{},"fn":container.program(30, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":221,"column":64}}})) != null ? stack1 : "")
+    + "</td>\n                <td>"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"user_agent") : depth0)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"if","hash":{},"fn":container.program(31, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":222,"column":20}}})) != null ? stack1 : "")
+    + "</td>\n                <td>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"sample_rate") : depth0),{"name":"if","hash":{},"fn":container.program(32, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":224,"column":20}}})) != null ? stack1 : "")
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"tls") : depth0),{"name":"if","hash":{},"fn":container.program(33, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":227,"column":20}}})) != null ? stack1 : "")
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"deflate") : depth0),{"name":"if","hash":{},"fn":container.program(35, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":230,"column":20}}})) != null ? stack1 : "")
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"snappy") : depth0),{"name":"if","hash":{},"fn":container.program(36, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":233,"column":20}}})) != null ? stack1 : "")
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"zstd") : depth0),{"name":"if","hash":{},"fn":container.program(37, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":236,"column":20}}})) != null ? stack1 : "")
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"authed") : depth0),{"name":"if","hash":{},"fn":container.program(38, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":239,"column":20}}})) != null ? stack1 : "")
+    + "                </td>\n                <td><a class=\"link\" href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/nodes",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":247,"column":42}}}))
+    + "/"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"node") || (depth0 != null ? lookupProperty(depth0,"node") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"node","hash":{},"data":data,"loc":{"start":{"line":247,"column":64}}}) : helper)))
+    + "\">"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"node") || (depth0 != null ? lookupProperty(depth0,"node") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"node","hash":{},"data":data,"loc":{"start":{"line":247,"column":74}}}) : helper)))
+    + "</a></td>\n                <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"in_flight_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":248,"column":20}}}))
+    + "</td>\n                <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"ready_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":249,"column":20}}}))
+    + "</td>\n                <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"finish_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":250,"column":20}}}))
+    + "</td>\n                <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"requeue_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":251,"column":20}}}))
+    + "</td>\n                <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"message_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":252,"column":20}}}))
+    + "</td>\n                <td>"
+    + container.escapeExpression((lookupProperty(helpers,"nanotohuman") || (depth0 && lookupProperty(depth0,"nanotohuman")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"connected") : depth0),{"name":"nanotohuman","hash":{},"data":data,"loc":{"start":{"line":253,"column":20}}}))
+    + "</td>\n            </tr>\n";
+},"30":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return " ("
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"client_id") || (depth0 != null ? lookupProperty(depth0,"client_id") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"client_id","hash":{},"data":data,"loc":{"start":{"line":221,"column":88}}}) : helper)))
+    + ")";
+},"31":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<small>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"user_agent") || (depth0 != null ? lookupProperty(depth0,"user_agent") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"user_agent","hash":{},"data":data,"loc":{"start":{"line":222,"column":52}}}) : helper)))
+    + "</small>";
+},"32":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                        <span class=\"label label-info\">Sampled "
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"sample_rate") || (depth0 != null ? lookupProperty(depth0,"sample_rate") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"sample_rate","hash":{},"data":data,"loc":{"start":{"line":225,"column":63}}}) : helper)))
+    + "%</span>\n";
+},"33":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                        <span class=\"label label-warning\" "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"tls_version") : depth0),{"name":"if","hash":{},"fn":container.program(34, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":228,"column":58}}})) != null ? stack1 : "")
+    + ">TLS</span>\n";
+},"34":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "title=\""
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"tls_version") || (depth0 != null ? lookupProperty(depth0,"tls_version") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"tls_version","hash":{},"data":data,"loc":{"start":{"line":228,"column":84}}}) : helper)))
+    + " "
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"tls_cipher_suite") || (depth0 != null ? lookupProperty(depth0,"tls_cipher_suite") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"tls_cipher_suite","hash":{},"data":data,"loc":{"start":{"line":228,"column":100}}}) : helper)))
+    + " "
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"tls_negotiated_protocol") || (depth0 != null ? lookupProperty(depth0,"tls_negotiated_protocol") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"tls_negotiated_protocol","hash":{},"data":data,"loc":{"start":{"line":228,"column":121}}}) : helper)))
+    + " mutual:"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"tls_negotiated_protocol_is_mutual") || (depth0 != null ? lookupProperty(depth0,"tls_negotiated_protocol_is_mutual") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"tls_negotiated_protocol_is_mutual","hash":{},"data":data,"loc":{"start":{"line":228,"column":156}}}) : helper)))
+    + "\"";
+},"35":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                        <span class=\"label label-default\">Deflate</span>\n";
+},"36":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                        <span class=\"label label-primary\">Snappy</span>\n";
+},"37":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                        <span class=\"label label-primary\">Zstd</span>\n";
+},"38":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                        <span class=\"label label-success\">\n                        "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"auth_identity_url") : depth0),{"name":"if","hash":{},"fn":container.program(39, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":241,"column":24}}})) != null ? stack1 : "")
+    + "\n                        <span class=\"glyphicon glyphicon-user white\" title=\"Authed"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"auth_identity") : depth0),{"name":"if","hash":{},"fn":container.program(40, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":242,"column":82}}})) != null ? stack1 : "")
+    + "\"></span>\n                        "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"auth_identity_url") : depth0),{"name":"if","hash":{},"fn":container.program(41, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":243,"column":24}}})) != null ? stack1 : "")
+    + "\n                        </span>\n";
+},"39":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<a href=\""
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"auth_identity_url") || (depth0 != null ? lookupProperty(depth0,"auth_identity_url") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"auth_identity_url","hash":{},"data":data,"loc":{"start":{"line":241,"column":58}}}) : helper)))
+    + "\">";
+},"40":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return " Identity:"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"auth_identity") || (depth0 != null ? lookupProperty(depth0,"auth_identity") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"auth_identity","hash":{},"data":data,"loc":{"start":{"line":242,"column":113}}}) : helper)));
+},"41":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "</a>";
+},"main":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return ((stack1 = container.invokePartial(lookupProperty(partials,"warning"),depth0,{"name":"warning","data":data,"helpers":helpers,"partials":partials,"decorators":container.decorators})) != null ? stack1 : "")
+    + ((stack1 = container.invokePartial(lookupProperty(partials,"error"),depth0,{"name":"error","data":data,"helpers":helpers,"partials":partials,"decorators":container.decorators})) != null ? stack1 : "")
+    + "\n<ol class=\"breadcrumb\">\n  <li><a class=\"link\" href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":5,"column":28}}}))
+    + "\">Streams</a>\n  <li><a class=\"link\" href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/topics",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":6,"column":28}}}))
+    + "/"
+    + container.escapeExpression((lookupProperty(helpers,"urlencode") || (depth0 && lookupProperty(depth0,"urlencode")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"topic") : depth0),{"name":"urlencode","hash":{},"data":data,"loc":{"start":{"line":6,"column":51}}}))
+    + "\">"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"topic") || (depth0 != null ? lookupProperty(depth0,"topic") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"topic","hash":{},"data":data,"loc":{"start":{"line":6,"column":72}}}) : helper)))
+    + "</a>\n  <li class=\"active\">"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"name") || (depth0 != null ? lookupProperty(depth0,"name") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"name","hash":{},"data":data,"loc":{"start":{"line":7,"column":21}}}) : helper)))
+    + "</li>\n</ol>\n\n<div class=\"row\">\n    <div class=\"col-md-6\">\n        <blockquote>\n            <p>Topic: <strong>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"topic") || (depth0 != null ? lookupProperty(depth0,"topic") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"topic","hash":{},"data":data,"loc":{"start":{"line":13,"column":30}}}) : helper)))
+    + "</strong>\n            <p>Channel: <strong>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"name") || (depth0 != null ? lookupProperty(depth0,"name") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"name","hash":{},"data":data,"loc":{"start":{"line":14,"column":32}}}) : helper)))
+    + "</strong>\n        </blockquote>\n    </div>\n</div>\n\n"
+    + ((stack1 = lookupProperty(helpers,"unless").call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"nodes") : depth0)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"unless","hash":{},"fn":container.program(1, data, 0, blockParams, depths),"inverse":container.program(2, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":19,"column":0}}})) != null ? stack1 : "")
+    + "\n"
+    + ((stack1 = container.invokePartial(lookupProperty(partials,"history"),depth0,{"name":"history","data":data,"helpers":helpers,"partials":partials,"decorators":container.decorators})) != null ? stack1 : "")
+    + "\n<h4>Client Connections</h4>\n\n<div class=\"row\">\n    <div class=\"col-md-12\">\n"
+    + ((stack1 = lookupProperty(helpers,"unless").call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"clients") : depth0)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"unless","hash":{},"fn":container.program(27, data, 0, blockParams, depths),"inverse":container.program(28, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":203,"column":8}}})) != null ? stack1 : "")
+    + "    </div>\n</div>\n";
+},"compiler":[8,">= 4.3.0"],"usePartial":true,"useData":true,"useDepths":true});
+
+},{"hbsfy/runtime":35}],54:[function(require,module,exports){
+var $ = require('jquery');
+
+window.jQuery = $;
+var bootstrap = require('bootstrap'); //eslint-disable-line no-unused-vars
+var bootbox = require('bootbox');
+
+var HistoryChart = require('../lib/history_chart');
+var Pubsub = require('../lib/pubsub');
+var AppState = require('../app_state');
+
+var BaseView = require('./base');
+
+var peekedTemplate = require('./peeked_messages.hbs');
+var lagTemplate = require('./lag.hbs');
+
+// the rates of the lag are computed from successive samples, so it is polled
+var lagPollInterval = 5000;
+
+// decodeBody returns the (base64 encoded) body of a message as UTF-8 text
+var decodeBody = function(body) {
+    var raw = atob(body || '');
+    var bytes = new Uint8Array(raw.length);
+    for (var i = 0; i < raw.length; i++) {
+        bytes[i] = raw.charCodeAt(i);
+    }
+    return new TextDecoder('utf-8').decode(bytes);
+};
+
+var prettyJSON = function(text) {
+    try {
+        return JSON.stringify(JSON.parse(text), null, 2);
+    } catch (err) {
+        return text;
+    }
+};
+
+var ChannelView = BaseView.extend({
+    className: 'channel container-fluid',
+
+    template: require('./spinner.hbs'),
+
+    events: {
+        'click .channel-actions button': 'channelAction',
+        'submit .peek-messages': 'peekMessages',
+        'click .peek-download': 'downloadMessages'
+    },
+
+    initialize: function() {
+        BaseView.prototype.initialize.apply(this, arguments);
+        this.listenTo(AppState, 'change:graph_interval', this.render);
+        var isAdmin = this.model.get('isAdmin');
+        this.model.fetch()
+            .done(function(data) {
+                this.template = require('./channel.hbs');
+                this.render({'message': data['message'], 'isAdmin': isAdmin});
+                this.lagPoller = setTimeout(this.fetchLag.bind(this), lagPollInterval);
+            }.bind(this))
+            .fail(this.handleViewError.bind(this))
+            .always(Pubsub.trigger.bind(Pubsub, 'view:ready'));
+    },
+
+    remove: function() {
+        clearTimeout(this.lagPoller);
+        BaseView.prototype.remove.apply(this, arguments);
+    },
+
+    fetchLag: function() {
+        $.get(AppState.apiPath('/lag'), {'topic': this.model.get('topic'), 'channel': this.model.get('name')})
+            .done(function(data) {
+                if (data['channels'].length) {
+                    this.$('.channel-lag').html(lagTemplate(data['channels'][0]));
+                }
+                this.lagPoller = setTimeout(this.fetchLag.bind(this), lagPollInterval);
+            }.bind(this));
+    },
+
+    postRender: function(ctx) {
+        if (!ctx['history_active'] || !ctx['nodes']) {
+            return;
+        }
+        var url = AppState.apiPath('/history/topics/' +
+            encodeURIComponent(this.model.get('topic')) + '/' +
+            encodeURIComponent(this.model.get('name')));
+        HistoryChart.render(this.$('.history-charts'), url, ctx['graph_interval'], true);
+    },
+
+    channelAction: function(e) {
+        e.preventDefault();
+        e.stopPropagation();
+        var action = $(e.currentTarget).data('action');
+        var txt = 'Are you sure you want to <strong>' +
+            action + '</strong> <em>' + this.model.get('topic') +
+            '/' + this.model.get('name') + '</em>?';
+        bootbox.confirm(txt, function(result) {
+            if (result !== true) {
+                return;
+            }
+            if (action === 'delete') {
+                var topic = this.model.get('topic');
+                $.ajax(this.model.url(), {'method': 'DELETE'})
+                    .done(function() {
+                        window.location = AppState.basePath('/topics/' +
+                            encodeURIComponent(topic));
+                    })
+                    .fail(this.handleAJAXError.bind(this));
+            } else {
+                $.post(this.model.url(), JSON.stringify({'action': action}))
+                    .done(function() { window.location.reload(true); })
+                    .fail(this.handleAJAXError.bind(this));
+            }
+        }.bind(this));
+    },
+
+    peekMessages: function(e) {
+        e.preventDefault();
+        e.stopPropagation();
+        var form = $(e.currentTarget);
+        var pretty = form.find('[name=pretty]').is(':checked');
+        var url = AppState.apiPath('/messages/' +
+            encodeURIComponent(this.model.get('topic')) + '/' +
+            encodeURIComponent(this.model.get('name')));
+        $.get(url, {'node': form.find('[name=node]').val(), 'count': form.find('[name=count]').val()})
+            .done(function(data) {
+                var messages = data['messages'].map(function(m) {
+                    var text = decodeBody(m['body']);
+                    return $.extend({}, m, {
+                        'text': pretty ? prettyJSON(text) : text,
+                        'time': new Date(m['timestamp'] / 1e6).toISOString()
+                    });
+                });
+                this.peeked = messages;
+                this.$('.peeked-messages').html(peekedTemplate({
+                    'messages': messages,
+                    'in_memory': data['in_memory']
+                }));
+                this.$('.peek-download').prop('disabled', messages.length === 0);
+            }.bind(this))
+            .fail(this.handleAJAXError.bind(this));
+    },
+
+    downloadMessages: function(e) {
+        e.preventDefault();
+        var data = (this.peeked || []).map(function(m) {
+            return {
+                'node': m['node'],
+                'id': m['id'],
+                'timestamp': m['timestamp'],
+                'attempts': m['attempts'],
+                'body': decodeBody(m['body'])
+            };
+        });
+        var blob = new Blob([JSON.stringify(data, null, 2)], {'type': 'application/json'});
+        var a = document.createElement('a');
+        a.href = URL.createObjectURL(blob);
+        a.download = this.model.get('topic') + '-' + this.model.get('name') + '-messages.json';
+        document.body.appendChild(a);
+        a.click();
+        document.body.removeChild(a);
+        URL.revokeObjectURL(a.href);
+    }
+});
+
+module.exports = ChannelView;
+
+},{"../app_state":36,"../lib/history_chart":41,"../lib/pubsub":42,"./base":51,"./channel.hbs":53,"./lag.hbs":62,"./peeked_messages.hbs":71,"./spinner.hbs":72,"bootstrap":1}],55:[function(require,module,exports){
+// hbsfy compiled Handlebars template
+var HandlebarsCompiler = require('hbsfy/runtime');
+module.exports = HandlebarsCompiler.template({"1":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "    <img id=\"big_graph\" height=\"500\" src=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"counter","*","","","message_count",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":206,"column":42}}}))
+    + "\"/>\n";
+},"main":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<style>\nbody {\n    background-color:#000;\n}\n/*\n * .numbers : The container for .number\n */\n\n#numbers {\n    text-align:center;\n    margin-top:100px;\n}\n\n.numbers {\n  font-family: 'Francois One', sans-serif;\n  font-size: 70px;\n  color: white;\n  white-space: nowrap;\n  position: relative;\n  direction: ltr;\n  vertical-align: middle;\n  height: 70px;\n}\n\n\n/*\n * .number : The container for each number\n */\n\n.number {\n  width: 50px;\n  height: 70px;\n  position: relative;\n  display: inline-block;\n  margin: 2px;\n  border-radius: 5px;\n  box-shadow: #999 0 -1px 0px 0px, #444 0 1px 0px 0px;\n}\n\n\n/*\n * Little white stuffs that link the top and the bottom\n */\n\n.number:before {\n  content: '';\n  display: block;\n  width: 3px;\n  height: 6px;\n  background: white;\n  position: absolute;\n  left: 0;\n  top: 30px;\n  z-index: 2;\n  box-shadow: inset rgb(130, 130, 130) 0 0 0px 1px;\n  border-right: 1px solid black;\n  border-top: 1px solid black;\n  border-bottom: 1px solid black;\n}\n\n.number:after {\n  content: '';\n  display: block;\n  width: 3px;\n  height: 6px;\n  background: rgb(200, 200, 200);\n  position: absolute;\n  right: 0;\n  top: 30px;\n  z-index: 2;\n  box-shadow: inset rgb(130, 130, 130) 0 0 0px 1px;\n  border-left: 1px solid black;\n  border-top: 1px solid black;\n  border-bottom: 1px solid black;  \n}\n\n/*\n * The panels\n */\n\n.number .top, .number .bottom {\n  display: block;\n  height: 35px;\n  width: 50px;\n  text-align: center;\n  overflow: hidden;\n  border-radius: 3px;\n  background: -webkit-linear-gradient(90deg, rgb(30, 30, 30), rgb(90, 90, 90));\n  background: -o-linear-gradient(90deg, rgb(30, 30, 30), rgb(90, 90, 90));\n  background: linear-gradient(0deg, rgb(30, 30, 30), rgb(90, 90, 90));\n  background-size: 50px 70px;\n}\n\n.number .top {\n  -moz-box-sizing: border-box;\n  -webkit-box-sizing: border-box;\n  -o-box-sizing: border-box;\n  box-sizing: border-box;\n  line-height: 70px;\n  border-top-left-radius: 5px; \n  border-top-right-radius: 5px;\n  background-position: 0px 0px;\n  border-bottom: 1px solid black;\n}\n\n.number .bottom {\n  line-height: 0px;\n  border-bottom-left-radius: 5px; \n  border-bottom-right-radius: 5px;\n  background-position: 0px 35px; \n}\n\n/*\n * Panel animations\n */\n\n/* The new top panel */\n.number .top:nth-last-of-type(4) {\n  position: absolute;\n  z-index: 0;\n}\n\n/* The old top panel */\n.number .top:nth-last-of-type(3) {\n  animation-duration: 0.2s;\n  animation-name: top;\n  animation-fill-mode: forwards;\n  animation-timing-function: ease-in;  \n  z-index: 1;\n\n  -moz-transform-origin: 0 100%;\n  -webkit-transform-origin: 0 100%;\n  -o-transform-origin: 0 100%;\n  transform-origin: 0 100%;\n}\n\n@keyframes top {\n  from {\n    -moz-transform: scaleY(1);\n    -webkit-transform: scaleY(1);\n    -o-transform: scaleY(1);\n    transform: scaleY(1);\n  }\n \n  to {\n    -moz-transform: scaleY(0);\n    -webkit-transform: scaleY(0);\n    -o-transform: scaleY(0);\n    transform: scaleY(0);\n  }\n}\n\n/* The new bottom panel */\n.number .bottom:nth-last-of-type(2) {\n  position: absolute;\n  z-index: 1;\n\n  animation-duration: 0.2s;\n  animation-name: bottom;\n  animation-delay: 0.2s;\n  animation-fill-mode: forwards;\n  animation-timing-function: ease-out;\n\n  -moz-transform: scaleY(0);\n  -moz-transform-origin: 0 0;\n}\n\n@keyframes bottom {\n  from {\n    -moz-transform: scaleY(0);\n  }\n \n  to {\n    -moz-transform: scaleY(1);\n  }\n}\n\n/* The old bottom panel */\n.number .top:nth-last-of-type(1) {\n  z-index: -1;\n}\n\n.processed {\n    font-color:#bbb;\n    margin:50px;\n    font-size:24pt;\n    font-family:Helvetica;\n}\n.messagerate {\n    font-color:#bbb;\n    margin:25px;\n    font-size:20pt;\n    font-family:Helvetica;\n}\n</style>\n\n"
+    + ((stack1 = container.invokePartial(lookupProperty(partials,"warning"),depth0,{"name":"warning","data":data,"helpers":helpers,"partials":partials,"decorators":container.decorators})) != null ? stack1 : "")
+    + ((stack1 = container.invokePartial(lookupProperty(partials,"error"),depth0,{"name":"error","data":data,"helpers":helpers,"partials":partials,"decorators":container.decorators})) != null ? stack1 : "")
+    + "\n<div id=\"numbers\">\n<div class=\"numbers\">\n</div>\n<p class=\"processed\">Messages Processed</p>\n<p class=\"messagerate\"></p>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"graph_active") : depth0),{"name":"if","hash":{},"fn":container.program(1, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":205,"column":0}}})) != null ? stack1 : "")
+    + "</div>\n";
+},"compiler":[8,">= 4.3.0"],"usePartial":true,"useData":true,"useDepths":true});
+
+},{"hbsfy/runtime":35}],56:[function(require,module,exports){
+var _ = require('underscore');
+var $ = require('jquery');
+
+var AppState = require('../app_state');
+
+var BaseView = require('./base');
+
+var CounterView = BaseView.extend({
+    className: 'counter container-fluid',
+
+    template: require('./counter.hbs'),
+
+    initialize: function() {
+        BaseView.prototype.initialize.apply(this, arguments);
+        this.listenTo(AppState, 'change:graph_interval', this.render);
+        this.start();
+    },
+
+    remove: function() {
+        clearTimeout(this.poller);
+        clearTimeout(this.animator);
+        BaseView.prototype.remove.apply(this, arguments);
+    },
+
+    start: function() {
+        this.poller = null;
+        this.animator = null;
+        this.delta = 0;
+        this.looping = false;
+        this.targetPollInterval = 10000;
+        this.currentNum = -1;
+        this.interval = 100;
+        this.graphUrl = null;
+        this.updateStats();
+    },
+
+    startLoop: function(i) {
+        this.interval = i;
+        this.poller = setTimeout(this.updateStats.bind(this), i);
+    },
+
+    updateStats: function() {
+        $.get(AppState.apiPath('/counter')).done(function(data) {
+            if (this.removed) {
+                return;
+            }
+
+            var num = _.reduce(data['stats'], function(n, v) {
+                return n + v['message_count'];
+            }, 0);
+
+            if (this.currentNum === -1) {
+                // seed the display
+                this.currentNum = num;
+                this.writeCounts(this.currentNum);
+            } else if (num > this.lastNum) {
+                var delta = num - this.lastNum;
+                this.delta = (delta / (this.interval / 1000)) / 50;
+                if (!this.animator) {
+                    this.displayFrame();
+                }
+            }
+            this.currentNum = this.lastNum;
+            this.lastNum = num;
+
+            var newInterval = this.interval;
+            if (newInterval < this.targetPollInterval) {
+                newInterval = this.interval + 1000;
+            }
+            this.startLoop(newInterval);
+
+            $('#warning, #error').hide();
+            if (data['message'] !== '') {
+                $('#warning .alert').text(data['message']);
+                $('#warning').show();
+            }
+        }.bind(this)).fail(function(jqXHR) {
+            if (this.removed) {
+                return;
+            }
+
+            clearTimeout(this.animator);
+            this.animator = null;
+
+            this.startLoop(10000);
+
+            this.handleAJAXError(jqXHR);
+        }.bind(this));
+
+        if ($('#big_graph').length) {
+            if (!this.graphUrl) {
+                this.graphUrl = $('#big_graph').attr('src');
+            }
+            var uniq = Math.floor(Math.random() * 1000000);
+            $('#big_graph').attr('src', this.graphUrl + '&_uniq=' + uniq);
+        }
+    },
+
+    displayFrame: function() {
+        this.currentNum += this.delta;
+        this.writeCounts(this.currentNum);
+        this.animator = setTimeout(this.displayFrame.bind(this), 1000 / 60);
+    },
+
+    writeCounts: function(c) {
+        var text = parseInt(c, 10).toString();
+        var node = $('.numbers')[0];
+        var n = $('.numbers .number');
+        for (var i = 0; i < text.length; i++) {
+            var v = text.charAt(i);
+            if (n.length > i) {
+                var el = $(n[i]);
+                el.show();
+                el.find('.top').text(v);
+                el.find('.bottom').text(v);
+            } else {
+                $(node).append('<span class="number"><span class="top">' + v +
+                    '</span><span class="bottom">' + v + '</span></span>\n');
+            }
+        }
+        $('.numbers .number').each(function(ii, vv) {
+            if (ii >= text.length) {
+                $(vv).hide();
+            }
+        });
+    }
+});
+
+module.exports = CounterView;
+
+},{"../app_state":36,"./base":51,"./counter.hbs":55}],57:[function(require,module,exports){
+// hbsfy compiled Handlebars template
+var HandlebarsCompiler = require('hbsfy/runtime');
+module.exports = HandlebarsCompiler.template({"1":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return " <span class=\"label label-default\" title=\"max depth of "
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"max_depth") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":1,"column":72}}}))
+    + " messages ("
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"depth_policy") || (depth0 != null ? lookupProperty(depth0,"depth_policy") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"depth_policy","hash":{},"data":data,"loc":{"start":{"line":1,"column":104}}}) : helper)))
+    + ")\">max "
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"max_depth") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":1,"column":127}}}))
+    + "</span>";
+},"2":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return " <span class=\"label label-default\" title=\"max depth of "
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"max_depth_bytes") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":2,"column":78}}}))
+    + " bytes on disk ("
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"depth_policy") || (depth0 != null ? lookupProperty(depth0,"depth_policy") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"depth_policy","hash":{},"data":data,"loc":{"start":{"line":2,"column":121}}}) : helper)))
+    + ")\">max "
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"max_depth_bytes") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":2,"column":144}}}))
+    + " bytes</span>";
+},"3":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return " <span class=\"label label-danger\" title=\"publishes rejected at max depth\">"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"depth_reject_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":3,"column":100}}}))
+    + " rejected</span>";
+},"4":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return " <span class=\"label label-warning\" title=\"messages dropped at max depth\">"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"depth_drop_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":4,"column":97}}}))
+    + " dropped</span>";
+},"main":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"max_depth") : depth0),{"name":"if","hash":{},"fn":container.program(1, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":1,"column":0}}})) != null ? stack1 : "")
+    + "\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"max_depth_bytes") : depth0),{"name":"if","hash":{},"fn":container.program(2, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":2,"column":0}}})) != null ? stack1 : "")
+    + "\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"depth_reject_count") : depth0),{"name":"if","hash":{},"fn":container.program(3, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":3,"column":0}}})) != null ? stack1 : "")
+    + "\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"depth_drop_count") : depth0),{"name":"if","hash":{},"fn":container.program(4, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":4,"column":0}}})) != null ? stack1 : "")
+    + "\n";
+},"compiler":[8,">= 4.3.0"],"useData":true,"useDepths":true});
+
+},{"hbsfy/runtime":35}],58:[function(require,module,exports){
+// hbsfy compiled Handlebars template
+var HandlebarsCompiler = require('hbsfy/runtime');
+module.exports = HandlebarsCompiler.template({"1":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "style=\"display: none;\"";
+},"main":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<div class=\"row\" id=\"error\" "
+    + ((stack1 = lookupProperty(helpers,"unless").call(alias1,(depth0 != null ? lookupProperty(depth0,"message") : depth0),{"name":"unless","hash":{},"fn":container.program(1, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":1,"column":28}}})) != null ? stack1 : "")
+    + ">\n    <div class=\"col-md-12\">\n        <div class=\"alert alert-danger\">\n            "
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"message") || (depth0 != null ? lookupProperty(depth0,"message") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"message","hash":{},"data":data,"loc":{"start":{"line":4,"column":12}}}) : helper)))
+    + "\n        </div>\n    </div>\n</div>\n";
+},"compiler":[8,">= 4.3.0"],"useData":true,"useDepths":true});
+
+},{"hbsfy/runtime":35}],59:[function(require,module,exports){
+// hbsfy compiled Handlebars template
+var HandlebarsCompiler = require('hbsfy/runtime');
+module.exports = HandlebarsCompiler.template({"1":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                <li class=\"dropdown\">\n                    <a href=\"#\" class=\"dropdown-toggle\" data-toggle=\"dropdown\" role=\"button\" aria-expanded=\"false\"><span class=\"glyphicon glyphicon-cloud white\"></span> "
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"cluster") || (depth0 != null ? lookupProperty(depth0,"cluster") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"cluster","hash":{},"data":data,"loc":{"start":{"line":16,"column":169}}}) : helper)))
+    + " <span class=\"caret\"></span></a>\n                    <ul class=\"dropdown-menu clusters\">\n                      <li class=\"dropdown-header\">Cluster</li>\n"
+    + ((stack1 = lookupProperty(helpers,"each").call(alias1,(depth0 != null ? lookupProperty(depth0,"clusters") : depth0),{"name":"each","hash":{},"fn":container.program(2, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":19,"column":20}}})) != null ? stack1 : "")
+    + "                    </ul>\n                </li>\n                <li><a class=\"link\" href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/overview",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":24,"column":42}}}))
+    + "\">Overview</a></li>\n";
+},"2":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                        <li><a href=\"javascript:;\">"
+    + container.escapeExpression(container.lambda(depth0, depth0))
+    + "</a></li>\n";
+},"3":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                <li><a class=\"link\" href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/alerts",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":31,"column":42}}}))
+    + "\">Alerts</a></li>\n";
+},"4":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                <li class=\"dropdown\">\n                    <a href=\"#\" class=\"dropdown-toggle\" data-toggle=\"dropdown\" role=\"button\" aria-expanded=\"false\"><span class=\"glyphicon glyphicon-picture white\"></span> "
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"graph_interval") || (depth0 != null ? lookupProperty(depth0,"graph_interval") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"graph_interval","hash":{},"data":data,"loc":{"start":{"line":35,"column":171}}}) : helper)))
+    + " <span class=\"caret\"></span></a>\n                    <ul class=\"dropdown-menu graph-intervals\">\n                      <li class=\"dropdown-header\">Graph Timeframe</li>\n"
+    + ((stack1 = lookupProperty(helpers,"each").call(alias1,(depth0 != null ? lookupProperty(depth0,"graph_intervals") : depth0),{"name":"each","hash":{},"fn":container.program(5, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":38,"column":20}}})) != null ? stack1 : "")
+    + "                    </ul>\n                </li>\n";
+},"5":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                        <li><a href=\"javascript:;\">"
+    + container.escapeExpression(container.lambda(depth0, depth0))
+    + "</a></li>\n";
+},"6":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                <li><p class=\"navbar-text\"><span class=\"glyphicon glyphicon-user white\"></span> "
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"user") || (depth0 != null ? lookupProperty(depth0,"user") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"user","hash":{},"data":data,"loc":{"start":{"line":47,"column":96}}}) : helper)))
+    + "</p></li>\n";
+},"7":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                <li><a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/oauth2/logout",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":50,"column":29}}}))
+    + "\">Sign out</a></li>\n";
+},"main":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<nav class=\"navbar navbar-inverse navbar-static-top\">\n    <div class=\"container-fluid\">\n        <div class=\"navbar-header\">\n            <button type=\"button\" class=\"navbar-toggle collapsed\" data-toggle=\"collapse\" data-target=\"#navbar\">\n                <span class=\"sr-only\">Toggle navigation</span>\n                <span class=\"icon-bar\"></span>\n                <span class=\"icon-bar\"></span>\n                <span class=\"icon-bar\"></span>\n            </button>\n            <a class=\"navbar-brand\" href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":10,"column":42}}}))
+    + "\"><img src=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/static/nsq_blue.png",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":10,"column":70}}}))
+    + "\" width=\"30\" height=\"30\">NSQ</a>\n        </div>\n        <div class=\"collapse navbar-collapse\" id=\"navbar\">\n            <ul class=\"nav navbar-nav\">\n"
+    + ((stack1 = (lookupProperty(helpers,"ifgteq") || (depth0 && lookupProperty(depth0,"ifgteq")) || container.hooks.helperMissing).call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"clusters") : depth0)) != null ? lookupProperty(stack1,"length") : stack1),2,{"name":"ifgteq","hash":{},"fn":container.program(1, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":14,"column":16}}})) != null ? stack1 : "")
+    + "                <li><a class=\"link\" href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":26,"column":42}}}))
+    + "\">Streams</a></li>\n                <li><a class=\"link\" href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/nodes",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":27,"column":42}}}))
+    + "\">Nodes</a></li>\n                <li><a class=\"link\" href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/counter",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":28,"column":42}}}))
+    + "\">Counter</a></li>\n                <li><a class=\"link\" href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/lookup",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":29,"column":42}}}))
+    + "\">Lookup</a></li>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"alerts_enabled") : depth0),{"name":"if","hash":{},"fn":container.program(3, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":30,"column":16}}})) != null ? stack1 : "")
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"graph_or_history_enabled") : depth0),{"name":"if","hash":{},"fn":container.program(4, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":33,"column":16}}})) != null ? stack1 : "")
+    + "            </ul>\n            <ul class=\"nav navbar-nav navbar-right\">\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"user") : depth0),{"name":"if","hash":{},"fn":container.program(6, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":46,"column":16}}})) != null ? stack1 : "")
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"logout_enabled") : depth0),{"name":"if","hash":{},"fn":container.program(7, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":49,"column":16}}})) != null ? stack1 : "")
+    + "                <li><a href=\"https://nsq.io/\">Documentation</a></li>\n                <li><a href=\"https://github.com/nsqio/nsq\">GitHub</a></li>\n                <li class=\"hidden-xs\"><p class=\"navbar-text\"><span class=\"label label-success\">v"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"version") || (depth0 != null ? lookupProperty(depth0,"version") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"version","hash":{},"data":data,"loc":{"start":{"line":54,"column":96}}}) : helper)))
+    + "</span></p></li>\n                </ul>\n            </ul>\n        </div>\n    </div>\n</nav>\n";
+},"compiler":[8,">= 4.3.0"],"useData":true,"useDepths":true});
+
+},{"hbsfy/runtime":35}],60:[function(require,module,exports){
+var _ = require('underscore');
+var $ = require('jquery');
+
+var AppState = require('../app_state');
+
+var BaseView = require('./base');
+
+var HeaderView = BaseView.extend({
+    className: 'header',
+
+    template: require('./header.hbs'),
+
+    events: {
+        'click .graph-intervals li': 'onGraphIntervalClick',
+        'click .clusters li': 'onClusterClick'
+    },
+
+    initialize: function() {
+        BaseView.prototype.initialize.apply(this, arguments);
+        this.listenTo(AppState, 'change:graph_interval', this.render);
+    },
+
+    getRenderCtx: function() {
+        return _.extend(BaseView.prototype.getRenderCtx.apply(this, arguments), {
+            'graph_intervals': ['1h', '2h', '12h', '24h', '48h', '168h', 'off'],
+            'graph_or_history_enabled': AppState.get('GRAPH_ENABLED') ||
+                AppState.get('HISTORY_ENABLED'),
+            'alerts_enabled': AppState.get('ALERTS_ENABLED'),
+            'user': AppState.get('USER'),
+            'logout_enabled': AppState.get('LOGOUT_ENABLED'),
+            'graph_interval': AppState.get('graph_interval'),
+            'clusters': _.pluck(AppState.get('CLUSTERS'), 'name'),
+            'cluster': AppState.get('cluster')
+        });
+    },
+
+    onReset: function() {
+        this.render();
+        this.$('.dropdown-toggle').dropdown();
+    },
+
+    onGraphIntervalClick: function(e) {
+        e.stopPropagation();
+        AppState.set('graph_interval', $(e.target).text());
+    },
+
+    onClusterClick: function(e) {
+        e.preventDefault();
+        e.stopPropagation();
+        AppState.selectCluster($(e.target).text());
+    }
+});
+
+module.exports = HeaderView;
+
+},{"../app_state":36,"./base":51,"./header.hbs":59}],61:[function(require,module,exports){
+// hbsfy compiled Handlebars template
+var HandlebarsCompiler = require('hbsfy/runtime');
+module.exports = HandlebarsCompiler.template({"1":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<div class=\"row\">\n    <div class=\"col-md-12\">\n        <h4>History <small>last "
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"graph_interval") || (depth0 != null ? lookupProperty(depth0,"graph_interval") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"graph_interval","hash":{},"data":data,"loc":{"start":{"line":4,"column":32}}}) : helper)))
+    + "</small></h4>\n        <div class=\"history-charts\"></div>\n    </div>\n</div>\n";
+},"main":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"history_active") : depth0),{"name":"if","hash":{},"fn":container.program(1, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":1,"column":0}}})) != null ? stack1 : "");
+},"compiler":[8,">= 4.3.0"],"useData":true,"useDepths":true});
+
+},{"hbsfy/runtime":35}],62:[function(require,module,exports){
+// hbsfy compiled Handlebars template
+var HandlebarsCompiler = require('hbsfy/runtime');
+module.exports = HandlebarsCompiler.template({"1":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "        <td>"
+    + container.escapeExpression((lookupProperty(helpers,"msgRate") || (depth0 && lookupProperty(depth0,"msgRate")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"publish_rate") : depth0),{"name":"msgRate","hash":{},"data":data,"loc":{"start":{"line":12,"column":12}}}))
+    + "</td>\n        <td>"
+    + container.escapeExpression((lookupProperty(helpers,"msgRate") || (depth0 && lookupProperty(depth0,"msgRate")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"consume_rate") : depth0),{"name":"msgRate","hash":{},"data":data,"loc":{"start":{"line":13,"column":12}}}))
+    + "</td>\n";
+},"2":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "        <td colspan=\"2\" class=\"text-muted\">sampling&hellip;</td>\n";
+},"3":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return container.escapeExpression((lookupProperty(helpers,"nanotohuman") || (depth0 && lookupProperty(depth0,"nanotohuman")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"oldest_message_age") : depth0),{"name":"nanotohuman","hash":{},"data":data,"loc":{"start":{"line":17,"column":38}}}));
+},"4":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "-";
+},"5":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return container.escapeExpression((lookupProperty(helpers,"drainETA") || (depth0 && lookupProperty(depth0,"drainETA")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"drain_eta") : depth0),{"name":"drainETA","hash":{},"data":data,"loc":{"start":{"line":18,"column":26}}}));
+},"6":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return ((stack1 = lookupProperty(helpers,"unless").call(alias1,(depth0 != null ? lookupProperty(depth0,"depth") : depth0),{"name":"unless","hash":{},"fn":container.program(7, data, 0, blockParams, depths),"inverse":container.program(8, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":18,"column":56}}})) != null ? stack1 : "");
+},"7":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "0";
+},"8":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "-";
+},"main":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<table class=\"table table-bordered table-condensed\">\n    <tr>\n        <th>Depth</th>\n        <th>Publish Rate</th>\n        <th>Consume Rate</th>\n        <th>Oldest Message</th>\n        <th>Time to Drain</th>\n    </tr>\n    <tr>\n        <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"depth") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":10,"column":12}}}))
+    + "</td>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"window") : depth0),{"name":"if","hash":{},"fn":container.program(1, data, 0, blockParams, depths),"inverse":container.program(2, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":11,"column":8}}})) != null ? stack1 : "")
+    + "        <td>"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"oldest_message_age") : depth0),{"name":"if","hash":{},"fn":container.program(3, data, 0, blockParams, depths),"inverse":container.program(4, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":17,"column":12}}})) != null ? stack1 : "")
+    + "</td>\n        <td>"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"window") : depth0),{"name":"if","hash":{},"fn":container.program(5, data, 0, blockParams, depths),"inverse":container.program(6, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":18,"column":12}}})) != null ? stack1 : "")
+    + "</td>\n    </tr>\n</table>\n";
+},"compiler":[8,">= 4.3.0"],"useData":true,"useDepths":true});
+
+},{"hbsfy/runtime":35}],63:[function(require,module,exports){
+// hbsfy compiled Handlebars template
+var HandlebarsCompiler = require('hbsfy/runtime');
+module.exports = HandlebarsCompiler.template({"1":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<div class=\"alert alert-warning\">\n    <h4>Notice</h4> nsqadmin is not configured with nsqlookupd hosts\n</div>\n";
+},"2":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<div class=\"row\">\n    <div class=\"col-md-4\">\n        <table class=\"table table-bordered table-condensed\">\n            <tr>\n                <th>nsqlookupd Host</th>\n            </tr>\n"
+    + ((stack1 = lookupProperty(helpers,"each").call(alias1,(depth0 != null ? lookupProperty(depth0,"nsqlookupd") : depth0),{"name":"each","hash":{},"fn":container.program(3, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":21,"column":12}}})) != null ? stack1 : "")
+    + "        </table>\n    </div>\n</div>\n\n<div class=\"row\">\n    <div class=\"col-md-4\">\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"topics") : depth0),{"name":"if","hash":{},"fn":container.program(4, data, 0, blockParams, depths),"inverse":container.program(7, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":30,"column":8}}})) != null ? stack1 : "")
+    + "    </div>\n</div>\n\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"isAdmin") : depth0),{"name":"if","hash":{},"fn":container.program(8, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":54,"column":0}}})) != null ? stack1 : "");
+},"3":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "            <tr><td>"
+    + container.escapeExpression(container.lambda(depth0, depth0))
+    + "</td></tr>\n";
+},"4":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "        <div class=\"alert alert-info\">\n            Below is a tree of Topics/Channels that are currently inactive (i.e. not produced on any nsqd in the cluster but are present in the lookup data)\n        </div>\n        <ul>\n"
+    + ((stack1 = lookupProperty(helpers,"each").call(alias1,(depth0 != null ? lookupProperty(depth0,"topics") : depth0),{"name":"each","hash":{},"fn":container.program(5, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":35,"column":12}}})) != null ? stack1 : "")
+    + "        </ul>\n";
+},"5":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "            <li>\n                <button class=\"btn-link red delete-topic-link\" data-topic=\""
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"name") || (depth0 != null ? lookupProperty(depth0,"name") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"name","hash":{},"data":data,"loc":{"start":{"line":37,"column":75}}}) : helper)))
+    + "\" style=\"padding: 0 6px; border: 0;\">✘</button> <a class=\"link\" href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/topics",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":37,"column":153}}}))
+    + "/"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"name") || (depth0 != null ? lookupProperty(depth0,"name") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"name","hash":{},"data":data,"loc":{"start":{"line":37,"column":176}}}) : helper)))
+    + "\">"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"name") || (depth0 != null ? lookupProperty(depth0,"name") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"name","hash":{},"data":data,"loc":{"start":{"line":37,"column":186}}}) : helper)))
+    + "</a>\n                <ul>\n"
+    + ((stack1 = lookupProperty(helpers,"each").call(alias1,(depth0 != null ? lookupProperty(depth0,"channels") : depth0),{"name":"each","hash":{},"fn":container.program(6, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":39,"column":20}}})) != null ? stack1 : "")
+    + "                </ul>\n            </li>\n";
+},"6":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                    <li>\n                        <button class=\"btn-link red delete-channel-link\" data-topic=\""
+    + container.escapeExpression(container.lambda((depths[1] != null ? lookupProperty(depths[1],"name") : depths[1]), depth0))
+    + "\" data-channel=\""
+    + container.escapeExpression(container.lambda(depth0, depth0))
+    + "\" style=\"padding: 0 6px; border: 0;\">✘</button> <a class=\"link\" href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/topics",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":41,"column":190}}}))
+    + "/"
+    + container.escapeExpression(container.lambda((depths[1] != null ? lookupProperty(depths[1],"name") : depths[1]), depth0))
+    + "/"
+    + container.escapeExpression(container.lambda(depth0, depth0))
+    + "\">"
+    + container.escapeExpression(container.lambda(depth0, depth0))
+    + "</a>\n                    </li>\n";
+},"7":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "        <div class=\"alert alert-warning\"><h4>Notice</h4>No inactive Topics</div>\n";
+},"8":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<div class=\"row\">\n    <div class=\"col-md-4\">\n        <form class=\"hierarchy\">\n            <legend>Create Topic/Channel</legend>\n            <div class=\"alert alert-info\">\n                <p>This provides a way to setup a stream hierarchy\n                before services are deployed to production.\n                <p>If <em>Channel Name</em> is empty, just the topic is created.\n            </div>\n            <div class=\"form-group\">\n                <input type=\"text\" name=\"topic\" placeholder=\"Topic Name\">\n                <input type=\"text\" name=\"channel\" placeholder=\"Channel Name\">\n            </div>\n            <button class=\"btn btn-default\" type=\"submit\">Create</button>\n        </form>\n    </div>\n</div>\n";
+},"main":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return ((stack1 = container.invokePartial(lookupProperty(partials,"warning"),depth0,{"name":"warning","data":data,"helpers":helpers,"partials":partials,"decorators":container.decorators})) != null ? stack1 : "")
+    + ((stack1 = container.invokePartial(lookupProperty(partials,"error"),depth0,{"name":"error","data":data,"helpers":helpers,"partials":partials,"decorators":container.decorators})) != null ? stack1 : "")
+    + "\n<div class=\"row\">\n    <div class=\"col-md-12\">\n        <h2>Lookup</h2>\n    </div>\n</div>\n\n"
+    + ((stack1 = lookupProperty(helpers,"unless").call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"nsqlookupd") : depth0)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"unless","hash":{},"fn":container.program(1, data, 0, blockParams, depths),"inverse":container.program(2, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":10,"column":0}}})) != null ? stack1 : "");
+},"compiler":[8,">= 4.3.0"],"usePartial":true,"useData":true,"useDepths":true});
+
+},{"hbsfy/runtime":35}],64:[function(require,module,exports){
+var _ = require('underscore');
+var $ = require('jquery');
+
+var AppState = require('../app_state');
+var Pubsub = require('../lib/pubsub');
+var BaseView = require('./base');
+
+var Topic = require('../models/topic');
+var Channel = require('../models/channel');
+
+var LookupView = BaseView.extend({
+    className: 'lookup container-fluid',
+
+    template: require('./spinner.hbs'),
+
+    events: {
+        'click .hierarchy button': 'onCreateTopicChannel',
+        'click .delete-topic-link': 'onDeleteTopic',
+        'click .delete-channel-link': 'onDeleteChannel'
+    },
+
+    initialize: function() {
+        BaseView.prototype.initialize.apply(this, arguments);
+        var isAdmin = arguments[0]['isAdmin'];
+        $.ajax(AppState.apiPath('/topics?inactive=true'))
+            .done(function(data) {
+                this.template = require('./lookup.hbs');
+                this.render({
+                    'topics': _.map(data['topics'], function(v, k) {
+                        return {'name': k, 'channels': v};
+                    }),
+                    'message': data['message'],
+                    'isAdmin': isAdmin
+                });
+            }.bind(this))
+            .fail(this.handleViewError.bind(this))
+            .always(Pubsub.trigger.bind(Pubsub, 'view:ready'));
+    },
+
+    onCreateTopicChannel: function(e) {
+        e.preventDefault();
+        e.stopPropagation();
+        var topic = $(e.target.form.elements['topic']).val();
+        var channel = $(e.target.form.elements['channel']).val();
+        if (topic === '' && channel === '') {
+            return;
+        }
+        $.post(AppState.apiPath('/topics'), JSON.stringify({
+            'topic': topic,
+            'channel': channel
+        }))
+            .done(function() { window.location.reload(true); })
+            .fail(this.handleAJAXError.bind(this));
+    },
+
+    onDeleteTopic: function(e) {
+        e.preventDefault();
+        e.stopPropagation();
+        var topic = new Topic({
+            'name': $(e.target).data('topic')
+        });
+        topic.destroy({'dataType': 'text'})
+            .done(function() { window.location.reload(true); })
+            .fail(this.handleAJAXError.bind(this));
+    },
+
+    onDeleteChannel: function(e) {
+        e.preventDefault();
+        e.stopPropagation();
+        var channel = new Channel({
+            'topic': $(e.target).data('topic'),
+            'name': $(e.target).data('channel')
+        });
+        channel.destroy({'dataType': 'text'})
+            .done(function() { window.location.reload(true); })
+            .fail(this.handleAJAXError.bind(this));
+    }
+});
+
+module.exports = LookupView;
+
+},{"../app_state":36,"../lib/pubsub":42,"../models/channel":44,"../models/topic":46,"./base":51,"./lookup.hbs":63,"./spinner.hbs":72}],65:[function(require,module,exports){
+// hbsfy compiled Handlebars template
+var HandlebarsCompiler = require('hbsfy/runtime');
+module.exports = HandlebarsCompiler.template({"1":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<div class=\"row\">\n  <div class=\"col-md-8 col-md-offset-2\">\n    <table class=\"table muted\">\n      <tr>\n        <td>\n          <a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"node",(depth0 != null ? lookupProperty(depth0,"node") : depth0),"","","*_bytes",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":15,"column":19}}}))
+    + "\"><img class=\"img-polaroid\" width=\"200\" src=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"node",(depth0 != null ? lookupProperty(depth0,"node") : depth0),"","","*_bytes",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":15,"column":107}}}))
+    + "\"/></a>\n          <h5 style=\"text-align: center;\">GC Pressure</h5>\n        </td>\n        <td>\n          <a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"node",(depth0 != null ? lookupProperty(depth0,"node") : depth0),"","","gc_pause_*",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":19,"column":19}}}))
+    + "\"><img class=\"img-polaroid\" width=\"200\" src=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"node",(depth0 != null ? lookupProperty(depth0,"node") : depth0),"","","gc_pause_*",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":19,"column":110}}}))
+    + "\"/></a>\n          <h5 style=\"text-align: center;\">GC Pause Percentiles</h5>\n        </td>\n        <td>\n          <a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"node",(depth0 != null ? lookupProperty(depth0,"node") : depth0),"","","gc_runs",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":23,"column":19}}}))
+    + "\"><img class=\"img-polaroid\" width=\"200\" src=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"node",(depth0 != null ? lookupProperty(depth0,"node") : depth0),"","","gc_runs",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":23,"column":107}}}))
+    + "\"/></a>\n          <h5 style=\"text-align: center;\">GC Runs</h5>\n        </td>\n        <td>\n          <a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"node",(depth0 != null ? lookupProperty(depth0,"node") : depth0),"","","heap_objects",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":27,"column":19}}}))
+    + "\"><img class=\"img-polaroid\" width=\"200\" src=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"node",(depth0 != null ? lookupProperty(depth0,"node") : depth0),"","","heap_objects",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":27,"column":112}}}))
+    + "\"/></a>\n          <h5 style=\"text-align: center;\">Heap Objects In-Use</h5>\n        </td>\n      </tr>\n    </table>\n  </div>\n</div>\n";
+},"2":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "        <div class=\"alert alert-warning\">\n            <h4>Notice</h4> No topics exist on this node.\n        </div>\n";
+},"3":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "    <table class=\"table table-condensed\">\n        <tr>\n            <td colspan=\"2\"><strong>"
+    + container.escapeExpression(container.lambda(((stack1 = (depths[1] != null ? lookupProperty(depths[1],"topics") : depths[1])) != null ? lookupProperty(stack1,"length") : stack1), depth0))
+    + "</strong> Topics</td>\n            <td colspan=\"7\"></td>\n            <td><strong>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"total_messages") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":47,"column":24}}}))
+    + "</strong> Messages</td>\n            <td><strong>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"total_clients") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":48,"column":24}}}))
+    + "</strong> Clients</td>\n        </tr>\n"
+    + ((stack1 = lookupProperty(helpers,"each").call(alias1,(depth0 != null ? lookupProperty(depth0,"topics") : depth0),{"name":"each","hash":{},"fn":container.program(4, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":50,"column":8}}})) != null ? stack1 : "")
+    + "    </table>\n";
+},"4":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "        <tr>\n            <th colspan=\"3\">Topic</th>\n            <th>Depth</th>\n            <th>Memory + Disk</th>\n            <th colspan=\"4\"></th>\n            <th>Messages</th>\n            <th>Channels</th>\n        </tr>\n        <tr class=\"info\">\n            <td colspan=\"3\">\n                <button class=\"btn-link red tombstone-link\" data-node=\""
+    + container.escapeExpression(container.lambda((depths[1] != null ? lookupProperty(depths[1],"name") : depths[1]), depth0))
+    + "\" data-topic=\""
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"topic_name") || (depth0 != null ? lookupProperty(depth0,"topic_name") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"topic_name","hash":{},"data":data,"loc":{"start":{"line":61,"column":96}}}) : helper)))
+    + "\" style=\"padding: 0 6px; border: 0;\">✘</button> "
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"topic_name") || (depth0 != null ? lookupProperty(depth0,"topic_name") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"topic_name","hash":{},"data":data,"loc":{"start":{"line":61,"column":158}}}) : helper)))
+    + "\n            </td>\n            <td>\n                "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depths[1] != null ? lookupProperty(depths[1],"graph_active") : depths[1]),{"name":"if","hash":{},"fn":container.program(5, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":64,"column":16}}})) != null ? stack1 : "")
+    + "\n                "
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"depth") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":65,"column":16}}}))
+    + "\n            </td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"memory_depth") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":67,"column":16}}}))
+    + " + "
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"backend_depth") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":67,"column":43}}}))
+    + "</td>\n            <td colspan=\"4\"></td>\n            <td>\n                "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depths[1] != null ? lookupProperty(depths[1],"graph_active") : depths[1]),{"name":"if","hash":{},"fn":container.program(6, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":70,"column":16}}})) != null ? stack1 : "")
+    + "\n                "
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"message_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":71,"column":16}}}))
+    + "\n            </td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"channels") : depth0)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":73,"column":16}}}))
+    + "</td>\n        </tr>\n"
+    + ((stack1 = lookupProperty(helpers,"unless").call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"channels") : depth0)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"unless","hash":{},"fn":container.program(7, data, 0, blockParams, depths),"inverse":container.program(8, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":75,"column":8}}})) != null ? stack1 : "");
+},"5":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"topic",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"name") : depth0),"","depth",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":64,"column":48}}}))
+    + "\"><img width=\"120\" src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"topic",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),"","depth",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":64,"column":116}}}))
+    + "\"></a>";
+},"6":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"topic",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),"","message_count",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":70,"column":48}}}))
+    + "\"><img width=\"120\" src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"topic",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),"","message_count",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":70,"column":130}}}))
+    + "\"></a>";
+},"7":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "        <tr>\n            <td colspan=\"11\">\n              <div class=\"alert alert-warning\"><h4>Notice</h4> No channels exist for this topic.</div>\n            </td>\n        </tr>\n";
+},"8":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return ((stack1 = lookupProperty(helpers,"each").call(alias1,(depth0 != null ? lookupProperty(depth0,"channels") : depth0),{"name":"each","hash":{},"fn":container.program(9, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":82,"column":8}}})) != null ? stack1 : "");
+},"9":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "        <tr>\n            <th width=\"25\"></th>\n            <th colspan=\"2\">Channel</th>\n            <th>Depth</th>\n            <th>Memory + Disk</th>\n            <th>In-Flight</th>\n            <th>Deferred</th>\n            <th>Requeued</th>\n            <th>Timed Out</th>\n            <th>Messages</th>\n            <th>Connections</th>\n        </tr>\n        <tr class=\"warning\">\n            <td></td>\n            <td colspan=\"2\">\n                "
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"channel_name") || (depth0 != null ? lookupProperty(depth0,"channel_name") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"channel_name","hash":{},"data":data,"loc":{"start":{"line":98,"column":16}}}) : helper)))
+    + "\n                "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"paused") : depth0),{"name":"if","hash":{},"fn":container.program(10, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":99,"column":16}}})) != null ? stack1 : "")
+    + "\n            </td>\n            <td>\n                "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depths[3] != null ? lookupProperty(depths[3],"graph_active") : depths[3]),{"name":"if","hash":{},"fn":container.program(11, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":102,"column":16}}})) != null ? stack1 : "")
+    + "\n                "
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"depth") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":103,"column":16}}}))
+    + "\n            </td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"memory_depth") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":105,"column":16}}}))
+    + " + "
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"backend_depth") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":105,"column":43}}}))
+    + "</td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"in_flight_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":106,"column":16}}}))
+    + "</td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"deferred_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":107,"column":16}}}))
+    + "</td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"requeue_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":108,"column":16}}}))
+    + "</td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"timeout_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":109,"column":16}}}))
+    + "</td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"message_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":110,"column":16}}}))
+    + "</td>\n            <td>\n                "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depths[3] != null ? lookupProperty(depths[3],"graph_active") : depths[3]),{"name":"if","hash":{},"fn":container.program(12, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":112,"column":16}}})) != null ? stack1 : "")
+    + "\n                "
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"clients") : depth0)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":113,"column":16}}}))
+    + "\n            </td>\n        </tr>\n"
+    + ((stack1 = lookupProperty(helpers,"unless").call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"clients") : depth0)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"unless","hash":{},"fn":container.program(13, data, 0, blockParams, depths),"inverse":container.program(14, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":116,"column":8}}})) != null ? stack1 : "");
+},"10":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<span class=\"label label-primary\">paused</span>";
+},"11":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"depth",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":102,"column":54}}}))
+    + "\"><img width=\"120\" height=\"20\" src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"depth",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":102,"column":152}}}))
+    + "\"></a>";
+},"12":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"clients",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":112,"column":54}}}))
+    + "\"><img width=\"120\" height=\"20\" src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"clients",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":112,"column":154}}}))
+    + "\"></a>";
+},"13":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "        <tr>\n            <td colspan=\"11\">\n                <div class=\"alert alert-warning\"><h4>Notice</h4>No clients connected to this channel.</div>\n            </td>\n        </tr>\n";
+},"14":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "        <tr>\n            <th></th>\n            <th>Client Host</th>\n            <th>User-Agent</th>\n            <th></th>\n            <th>Attributes</th>\n            <th>In-Flight</th>\n            <th>Ready Count</th>\n            <th>Requeued</th>\n            <th>Finished</th>\n            <th>Messages</th>\n            <th>Connected</th>\n        </tr>\n"
+    + ((stack1 = lookupProperty(helpers,"each").call(alias1,(depth0 != null ? lookupProperty(depth0,"clients") : depth0),{"name":"each","hash":{},"fn":container.program(15, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":136,"column":8}}})) != null ? stack1 : "");
+},"15":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "        <tr>\n            <td></td>\n            <td title=\""
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"remote_address") || (depth0 != null ? lookupProperty(depth0,"remote_address") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"remote_address","hash":{},"data":data,"loc":{"start":{"line":139,"column":23}}}) : helper)))
+    + "\">"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"hostname") || (depth0 != null ? lookupProperty(depth0,"hostname") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"hostname","hash":{},"data":data,"loc":{"start":{"line":139,"column":43}}}) : helper)))
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"show_client_id") : depth0),{"name":"if","hash":{},"fn":container.program(16, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":139,"column":55}}})) != null ? stack1 : "")
+    + "</td>\n            <td>"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"user_agent") : depth0)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"if","hash":{},"fn":container.program(17, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":140,"column":16}}})) != null ? stack1 : "")
+    + "</td>\n            <td></td>\n            <td>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"sample_rate") : depth0),{"name":"if","hash":{},"fn":container.program(18, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":143,"column":16}}})) != null ? stack1 : "")
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"tls") : depth0),{"name":"if","hash":{},"fn":container.program(19, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":146,"column":16}}})) != null ? stack1 : "")
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"deflate") : depth0),{"name":"if","hash":{},"fn":container.program(21, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":149,"column":16}}})) != null ? stack1 : "")
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"snappy") : depth0),{"name":"if","hash":{},"fn":container.program(22, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":152,"column":16}}})) != null ? stack1 : "")
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"zstd") : depth0),{"name":"if","hash":{},"fn":container.program(23, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":155,"column":16}}})) != null ? stack1 : "")
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"authed") : depth0),{"name":"if","hash":{},"fn":container.program(24, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":158,"column":16}}})) != null ? stack1 : "")
+    + "            </td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"in_flight_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":166,"column":16}}}))
+    + "</td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"ready_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":167,"column":16}}}))
+    + "</td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"requeue_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":168,"column":16}}}))
+    + "</td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"finish_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":169,"column":16}}}))
+    + "</td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"message_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":170,"column":16}}}))
+    + "</td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"nanotohuman") || (depth0 && lookupProperty(depth0,"nanotohuman")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"connected") : depth0),{"name":"nanotohuman","hash":{},"data":data,"loc":{"start":{"line":171,"column":16}}}))
+    + "</td>\n        </tr>\n";
+},"16":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return " ("
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"client_id") || (depth0 != null ? lookupProperty(depth0,"client_id") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"client_id","hash":{},"data":data,"loc":{"start":{"line":139,"column":79}}}) : helper)))
+    + ")";
+},"17":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<small>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"user_agent") || (depth0 != null ? lookupProperty(depth0,"user_agent") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"user_agent","hash":{},"data":data,"loc":{"start":{"line":140,"column":48}}}) : helper)))
+    + "</small>";
+},"18":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                    <span class=\"label label-info\">Sampled "
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"sample_rate") || (depth0 != null ? lookupProperty(depth0,"sample_rate") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"sample_rate","hash":{},"data":data,"loc":{"start":{"line":144,"column":59}}}) : helper)))
+    + "%</span>\n";
+},"19":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                    <span class=\"label label-warning\" "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"tls_version") : depth0),{"name":"if","hash":{},"fn":container.program(20, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":147,"column":54}}})) != null ? stack1 : "")
+    + ">TLS</span>\n";
+},"20":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "title=\""
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"tls_version") || (depth0 != null ? lookupProperty(depth0,"tls_version") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"tls_version","hash":{},"data":data,"loc":{"start":{"line":147,"column":80}}}) : helper)))
+    + " "
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"tls_cipher_suite") || (depth0 != null ? lookupProperty(depth0,"tls_cipher_suite") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"tls_cipher_suite","hash":{},"data":data,"loc":{"start":{"line":147,"column":96}}}) : helper)))
+    + " "
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"tls_negotiated_protocol") || (depth0 != null ? lookupProperty(depth0,"tls_negotiated_protocol") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"tls_negotiated_protocol","hash":{},"data":data,"loc":{"start":{"line":147,"column":117}}}) : helper)))
+    + " mutual:"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"tls_negotiated_protocol_is_mutual") || (depth0 != null ? lookupProperty(depth0,"tls_negotiated_protocol_is_mutual") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"tls_negotiated_protocol_is_mutual","hash":{},"data":data,"loc":{"start":{"line":147,"column":152}}}) : helper)))
+    + "\"";
+},"21":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                    <span class=\"label label-default\">Deflate</span>\n";
+},"22":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                    <span class=\"label label-primary\">Snappy</span>\n";
+},"23":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                    <span class=\"label label-primary\">Zstd</span>\n";
+},"24":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                    <span class=\"label label-success\">\n                    "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"auth_identity_url") : depth0),{"name":"if","hash":{},"fn":container.program(25, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":160,"column":20}}})) != null ? stack1 : "")
+    + "\n                    <span class=\"glyphicon glyphicon-user white\" title=\"Authed"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"auth_identity") : depth0),{"name":"if","hash":{},"fn":container.program(26, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":161,"column":78}}})) != null ? stack1 : "")
+    + "\"></span>\n                    "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"auth_identity_url") : depth0),{"name":"if","hash":{},"fn":container.program(27, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":162,"column":20}}})) != null ? stack1 : "")
+    + "\n                    </span>\n";
+},"25":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<a href=\""
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"auth_identity_url") || (depth0 != null ? lookupProperty(depth0,"auth_identity_url") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"auth_identity_url","hash":{},"data":data,"loc":{"start":{"line":160,"column":54}}}) : helper)))
+    + "\">";
+},"26":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return " Identity:"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"auth_identity") || (depth0 != null ? lookupProperty(depth0,"auth_identity") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"auth_identity","hash":{},"data":data,"loc":{"start":{"line":161,"column":109}}}) : helper)));
+},"27":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "</a>";
+},"main":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return ((stack1 = container.invokePartial(lookupProperty(partials,"warning"),depth0,{"name":"warning","data":data,"helpers":helpers,"partials":partials,"decorators":container.decorators})) != null ? stack1 : "")
+    + ((stack1 = container.invokePartial(lookupProperty(partials,"error"),depth0,{"name":"error","data":data,"helpers":helpers,"partials":partials,"decorators":container.decorators})) != null ? stack1 : "")
+    + "\n<ol class=\"breadcrumb\">\n    <li><a class=\"link\" href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/nodes",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":5,"column":30}}}))
+    + "\">Nodes</a>\n    <li class=\"active\">"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"name") || (depth0 != null ? lookupProperty(depth0,"name") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"name","hash":{},"data":data,"loc":{"start":{"line":6,"column":23}}}) : helper)))
+    + "</li>\n</ol>\n\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"graph_active") : depth0),{"name":"if","hash":{},"fn":container.program(1, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":9,"column":0}}})) != null ? stack1 : "")
+    + "\n<div class=\"row\">\n    <div class=\"col-md-12\">\n"
+    + ((stack1 = lookupProperty(helpers,"unless").call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"topics") : depth0)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"unless","hash":{},"fn":container.program(2, data, 0, blockParams, depths),"inverse":container.program(3, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":38,"column":4}}})) != null ? stack1 : "")
+    + "</div>\n";
+},"compiler":[8,">= 4.3.0"],"usePartial":true,"useData":true,"useDepths":true});
+
+},{"hbsfy/runtime":35}],66:[function(require,module,exports){
+var Pubsub = require('../lib/pubsub');
+var AppState = require('../app_state');
+
+var BaseView = require('./base');
+
+var NodeView = BaseView.extend({
+    className: 'node container-fluid',
+
+    template: require('./spinner.hbs'),
+
+    initialize: function() {
+        BaseView.prototype.initialize.apply(this, arguments);
+        this.listenTo(AppState, 'change:graph_interval', this.render);
+        this.model.fetch()
+            .done(function(data) {
+                this.template = require('./node.hbs');
+                this.render({'message': data['message']});
+            }.bind(this))
+            .fail(this.handleViewError.bind(this))
+            .always(Pubsub.trigger.bind(Pubsub, 'view:ready'));
+    }
+});
+
+module.exports = NodeView;
+
+},{"../app_state":36,"../lib/pubsub":42,"./base":51,"./node.hbs":65,"./spinner.hbs":72}],67:[function(require,module,exports){
+// hbsfy compiled Handlebars template
+var HandlebarsCompiler = require('hbsfy/runtime');
+module.exports = HandlebarsCompiler.template({"1":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                <th>Lookupd Conns.</th>\n";
+},"2":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "            <tr "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"out_of_date") : depth0),{"name":"if","hash":{},"fn":container.program(3, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":25,"column":16}}})) != null ? stack1 : "")
+    + ">\n                <td>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"hostname") || (depth0 != null ? lookupProperty(depth0,"hostname") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"hostname","hash":{},"data":data,"loc":{"start":{"line":26,"column":20}}}) : helper)))
+    + "</td>\n                <td><a class=\"link\" href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/nodes",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":27,"column":42}}}))
+    + "/"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"broadcast_address_http") || (depth0 != null ? lookupProperty(depth0,"broadcast_address_http") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"broadcast_address_http","hash":{},"data":data,"loc":{"start":{"line":27,"column":64}}}) : helper)))
+    + "\">"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"broadcast_address") || (depth0 != null ? lookupProperty(depth0,"broadcast_address") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"broadcast_address","hash":{},"data":data,"loc":{"start":{"line":27,"column":92}}}) : helper)))
+    + "</a></td>\n                <td>"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"tcp_socket") : depth0),{"name":"if","hash":{},"fn":container.program(4, data, 0, blockParams, depths),"inverse":container.program(5, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":28,"column":20}}})) != null ? stack1 : "")
+    + "</td>\n                <td>"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"http_socket") : depth0),{"name":"if","hash":{},"fn":container.program(6, data, 0, blockParams, depths),"inverse":container.program(7, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":29,"column":20}}})) != null ? stack1 : "")
+    + "</td>\n                <td>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"version") || (depth0 != null ? lookupProperty(depth0,"version") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"version","hash":{},"data":data,"loc":{"start":{"line":30,"column":20}}}) : helper)))
+    + "</td>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,((stack1 = (depths[1] != null ? lookupProperty(depths[1],"nsqlookupd") : depths[1])) != null ? lookupProperty(stack1,"length") : stack1),{"name":"if","hash":{},"fn":container.program(8, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":31,"column":16}}})) != null ? stack1 : "")
+    + "                <td>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"topics") : depth0)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"if","hash":{},"fn":container.program(11, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":40,"column":16}}})) != null ? stack1 : "")
+    + "                </td>\n            </tr>\n";
+},"3":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "class=\"warning\"";
+},"4":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return container.escapeExpression(((helper = (helper = lookupProperty(helpers,"tcp_socket") || (depth0 != null ? lookupProperty(depth0,"tcp_socket") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"tcp_socket","hash":{},"data":data,"loc":{"start":{"line":28,"column":38}}}) : helper)));
+},"5":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return container.escapeExpression(((helper = (helper = lookupProperty(helpers,"tcp_port") || (depth0 != null ? lookupProperty(depth0,"tcp_port") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"tcp_port","hash":{},"data":data,"loc":{"start":{"line":28,"column":60}}}) : helper)));
+},"6":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return container.escapeExpression(((helper = (helper = lookupProperty(helpers,"http_socket") || (depth0 != null ? lookupProperty(depth0,"http_socket") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"http_socket","hash":{},"data":data,"loc":{"start":{"line":29,"column":39}}}) : helper)));
+},"7":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return container.escapeExpression(((helper = (helper = lookupProperty(helpers,"http_port") || (depth0 != null ? lookupProperty(depth0,"http_port") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"http_port","hash":{},"data":data,"loc":{"start":{"line":29,"column":62}}}) : helper)));
+},"8":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                <td>\n                    <a class=\"conn-count btn btn-default btn-xs "
+    + ((stack1 = (lookupProperty(helpers,"unlesseq") || (depth0 && lookupProperty(depth0,"unlesseq")) || container.hooks.helperMissing).call(alias1,((stack1 = (depths[2] != null ? lookupProperty(depths[2],"nsqlookupd") : depths[2])) != null ? lookupProperty(stack1,"length") : stack1),((stack1 = (depth0 != null ? lookupProperty(depth0,"remote_addresses") : depth0)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"unlesseq","hash":{},"fn":container.program(9, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":33,"column":64}}})) != null ? stack1 : "")
+    + "\">"
+    + container.escapeExpression(container.lambda(((stack1 = (depth0 != null ? lookupProperty(depth0,"remote_addresses") : depth0)) != null ? lookupProperty(stack1,"length") : stack1), depth0))
+    + "</a>\n                    <div style=\"display: none;\">\n                        "
+    + ((stack1 = lookupProperty(helpers,"each").call(alias1,(depth0 != null ? lookupProperty(depth0,"remote_addresses") : depth0),{"name":"each","hash":{},"fn":container.program(10, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":35,"column":24}}})) != null ? stack1 : "")
+    + "\n                    </div>\n                </td>\n";
+},"9":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "btn-warning";
+},"10":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return container.escapeExpression(container.lambda(depth0, depth0))
+    + "<br/>";
+},"11":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                    <span class=\"badge\">"
+    + container.escapeExpression(container.lambda(((stack1 = (depth0 != null ? lookupProperty(depth0,"topics") : depth0)) != null ? lookupProperty(stack1,"length") : stack1), depth0))
+    + "</span>\n"
+    + ((stack1 = lookupProperty(helpers,"each").call(alias1,(depth0 != null ? lookupProperty(depth0,"topics") : depth0),{"name":"each","hash":{},"fn":container.program(12, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":42,"column":20}}})) != null ? stack1 : "");
+},"12":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                    <a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/topics",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":43,"column":29}}}))
+    + "/"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"topic") || (depth0 != null ? lookupProperty(depth0,"topic") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"topic","hash":{},"data":data,"loc":{"start":{"line":43,"column":52}}}) : helper)))
+    + "\" class=\"link label "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"tombstoned") : depth0),{"name":"if","hash":{},"fn":container.program(13, data, 0, blockParams, depths),"inverse":container.program(14, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":43,"column":81}}})) != null ? stack1 : "")
+    + "\" "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"tombstoned") : depth0),{"name":"if","hash":{},"fn":container.program(15, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":43,"column":142}}})) != null ? stack1 : "")
+    + ">"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"topic") || (depth0 != null ? lookupProperty(depth0,"topic") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"topic","hash":{},"data":data,"loc":{"start":{"line":43,"column":223}}}) : helper)))
+    + "</a>\n";
+},"13":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "label-warning";
+},"14":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "label-primary";
+},"15":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "title=\"this topic is currently tombstoned on this node\"";
+},"main":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return ((stack1 = container.invokePartial(lookupProperty(partials,"warning"),depth0,{"name":"warning","data":data,"helpers":helpers,"partials":partials,"decorators":container.decorators})) != null ? stack1 : "")
+    + ((stack1 = container.invokePartial(lookupProperty(partials,"error"),depth0,{"name":"error","data":data,"helpers":helpers,"partials":partials,"decorators":container.decorators})) != null ? stack1 : "")
+    + "\n<div class=\"row\">\n    <div class=\"col-md-12\">\n        <h2>NSQd Nodes ("
+    + container.escapeExpression(container.lambda(((stack1 = (depth0 != null ? lookupProperty(depth0,"collection") : depth0)) != null ? lookupProperty(stack1,"length") : stack1), depth0))
+    + ")</h2>\n    </div>\n</div>\n\n<div class=\"row\">\n    <div class=\"col-md-12\">\n        <table class=\"table table-condensed table-bordered\">\n            <tr>\n                <th>Hostname</th>\n                <th>Broadcast Address</th>\n                <th>TCP Port</th>\n                <th>HTTP Port</th>\n                <th>Version</th>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"nsqlookupd") : depth0)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"if","hash":{},"fn":container.program(1, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":19,"column":16}}})) != null ? stack1 : "")
+    + "                <th>Topics</th>\n            </tr>\n"
+    + ((stack1 = lookupProperty(helpers,"each").call(alias1,(depth0 != null ? lookupProperty(depth0,"collection") : depth0),{"name":"each","hash":{},"fn":container.program(2, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":24,"column":12}}})) != null ? stack1 : "")
+    + "        </table>\n    </div>\n</div>\n";
+},"compiler":[8,">= 4.3.0"],"usePartial":true,"useData":true,"useDepths":true});
+
+},{"hbsfy/runtime":35}],68:[function(require,module,exports){
+var $ = require('jquery');
+
+var Pubsub = require('../lib/pubsub');
+var AppState = require('../app_state');
+
+var BaseView = require('./base');
+
+var Nodes = require('../collections/nodes');
+
+var NodesView = BaseView.extend({
+    className: 'nodes container-fluid',
+
+    template: require('./spinner.hbs'),
+
+    events: {
+        'click .conn-count': 'onClickConnCount'
+    },
+
+    initialize: function() {
+        BaseView.prototype.initialize.apply(this, arguments);
+        this.listenTo(AppState, 'change:graph_interval', this.render);
+        this.collection = new Nodes();
+        this.collection.fetch()
+            .done(function(data) {
+                this.template = require('./nodes.hbs');
+                this.render({'message': data['message']});
+            }.bind(this))
+            .fail(this.handleViewError.bind(this))
+            .always(Pubsub.trigger.bind(Pubsub, 'view:ready'));
+    },
+
+    onClickConnCount: function(e) {
+        e.preventDefault();
+        $(e.target).next().toggle();
+    }
+});
+
+module.exports = NodesView;
+
+},{"../app_state":36,"../collections/nodes":37,"../lib/pubsub":42,"./base":51,"./nodes.hbs":67,"./spinner.hbs":72}],69:[function(require,module,exports){
+// hbsfy compiled Handlebars template
+var HandlebarsCompiler = require('hbsfy/runtime');
+module.exports = HandlebarsCompiler.template({"1":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "            <tr class=\""
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"health_class") || (depth0 != null ? lookupProperty(depth0,"health_class") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"health_class","hash":{},"data":data,"loc":{"start":{"line":25,"column":23}}}) : helper)))
+    + "\">\n                <td>\n                    <a class=\"cluster-link\" href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":27,"column":50}}}))
+    + "\" data-cluster=\""
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"name") || (depth0 != null ? lookupProperty(depth0,"name") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"name","hash":{},"data":data,"loc":{"start":{"line":27,"column":82}}}) : helper)))
+    + "\">"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"selected") : depth0),{"name":"if","hash":{},"fn":container.program(2, data, 0, blockParams, depths),"inverse":container.program(3, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":27,"column":92}}})) != null ? stack1 : "")
+    + "</a>\n                    "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"default") : depth0),{"name":"if","hash":{},"fn":container.program(4, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":28,"column":20}}})) != null ? stack1 : "")
+    + "\n                </td>\n                <td>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"health") || (depth0 != null ? lookupProperty(depth0,"health") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"health","hash":{},"data":data,"loc":{"start":{"line":30,"column":20}}}) : helper)))
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"message") : depth0),{"name":"if","hash":{},"fn":container.program(5, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":30,"column":30}}})) != null ? stack1 : "")
+    + "</td>\n                <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"nodes") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":31,"column":20}}}))
+    + "</td>\n                <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"topics") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":32,"column":20}}}))
+    + "</td>\n                <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"channels") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":33,"column":20}}}))
+    + "</td>\n                <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"depth") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":34,"column":20}}}))
+    + "</td>\n                <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"in_flight_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":35,"column":20}}}))
+    + "</td>\n                <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"deferred_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":36,"column":20}}}))
+    + "</td>\n                <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"message_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":37,"column":20}}}))
+    + "</td>\n            </tr>\n";
+},"2":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<strong>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"name") || (depth0 != null ? lookupProperty(depth0,"name") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"name","hash":{},"data":data,"loc":{"start":{"line":27,"column":116}}}) : helper)))
+    + "</strong>";
+},"3":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return container.escapeExpression(((helper = (helper = lookupProperty(helpers,"name") || (depth0 != null ? lookupProperty(depth0,"name") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"name","hash":{},"data":data,"loc":{"start":{"line":27,"column":141}}}) : helper)));
+},"4":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<span class=\"label label-default\">default</span>";
+},"5":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<br/><small>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"message") || (depth0 != null ? lookupProperty(depth0,"message") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"message","hash":{},"data":data,"loc":{"start":{"line":30,"column":57}}}) : helper)))
+    + "</small>";
+},"main":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return ((stack1 = container.invokePartial(lookupProperty(partials,"warning"),depth0,{"name":"warning","data":data,"helpers":helpers,"partials":partials,"decorators":container.decorators})) != null ? stack1 : "")
+    + ((stack1 = container.invokePartial(lookupProperty(partials,"error"),depth0,{"name":"error","data":data,"helpers":helpers,"partials":partials,"decorators":container.decorators})) != null ? stack1 : "")
+    + "\n<div class=\"row\">\n    <div class=\"col-md-12\">\n        <h2>Clusters</h2>\n    </div>\n</div>\n\n<div class=\"row\">\n    <div class=\"col-md-12\">\n        <table class=\"table table-bordered table-condensed\">\n            <tr>\n                <th>Cluster</th>\n                <th>Health</th>\n                <th>Nodes</th>\n                <th>Topics</th>\n                <th>Channels</th>\n                <th>Depth</th>\n                <th>In-Flight</th>\n                <th>Deferred</th>\n                <th>Messages</th>\n            </tr>\n"
+    + ((stack1 = lookupProperty(helpers,"each").call(alias1,(depth0 != null ? lookupProperty(depth0,"clusters") : depth0),{"name":"each","hash":{},"fn":container.program(1, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":24,"column":12}}})) != null ? stack1 : "")
+    + "        </table>\n    </div>\n</div>\n";
+},"compiler":[8,">= 4.3.0"],"usePartial":true,"useData":true,"useDepths":true});
+
+},{"hbsfy/runtime":35}],70:[function(require,module,exports){
+var _ = require('underscore');
+var $ = require('jquery');
+
+var AppState = require('../app_state');
+var Pubsub = require('../lib/pubsub');
+
+var BaseView = require('./base');
+
+var healthClasses = {
+    'ok': 'success',
+    'degraded': 'warning',
+    'unreachable': 'danger'
+};
+
+var OverviewView = BaseView.extend({
+    className: 'overview container-fluid',
+
+    template: require('./spinner.hbs'),
+
+    events: {
+        'click .cluster-link': 'onClusterClick'
+    },
+
+    initialize: function() {
+        BaseView.prototype.initialize.apply(this, arguments);
+        this.fetch();
+    },
+
+    remove: function() {
+        clearTimeout(this.poller);
+        BaseView.prototype.remove.apply(this, arguments);
+    },
+
+    fetch: function() {
+        // not apiPath, the overview is of every cluster
+        $.ajax(AppState.basePath('/api/clusters'))
+            .done(function(data) {
+                this.template = require('./overview.hbs');
+                this.render({
+                    'clusters': _.map(data['clusters'], function(c) {
+                        return _.extend({}, c, {
+                            'health_class': healthClasses[c['health']],
+                            'selected': c['name'] === AppState.get('cluster')
+                        });
+                    })
+                });
+                this.poller = setTimeout(this.fetch.bind(this), 10000);
+            }.bind(this))
+            .fail(this.handleViewError.bind(this))
+            .always(Pubsub.trigger.bind(Pubsub, 'view:ready'));
+    },
+
+    onClusterClick: function(e) {
+        e.preventDefault();
+        e.stopPropagation();
+        AppState.selectCluster($(e.currentTarget).data('cluster'));
+    }
+});
+
+module.exports = OverviewView;
+
+},{"../app_state":36,"../lib/pubsub":42,"./base":51,"./overview.hbs":69,"./spinner.hbs":72}],71:[function(require,module,exports){
+// hbsfy compiled Handlebars template
+var HandlebarsCompiler = require('hbsfy/runtime');
+module.exports = HandlebarsCompiler.template({"1":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<div class=\"alert alert-info\">"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"in_memory") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":2,"column":30}}}))
+    + " messages in memory are not shown, pause the channel to include them</div>\n";
+},"2":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return ((stack1 = lookupProperty(helpers,"unless").call(alias1,(depth0 != null ? lookupProperty(depth0,"in_memory") : depth0),{"name":"unless","hash":{},"fn":container.program(3, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":5,"column":0}}})) != null ? stack1 : "");
+},"3":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<div class=\"alert alert-warning\"><h4>Notice</h4>No messages waiting in this channel</div>\n";
+},"4":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<table class=\"table table-bordered table-condensed\">\n    <tr>\n        <th>NSQd Host</th>\n        <th>ID</th>\n        <th>Timestamp</th>\n        <th>Attempts</th>\n        <th>Body</th>\n    </tr>\n"
+    + ((stack1 = lookupProperty(helpers,"each").call(alias1,(depth0 != null ? lookupProperty(depth0,"messages") : depth0),{"name":"each","hash":{},"fn":container.program(5, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":17,"column":4}}})) != null ? stack1 : "")
+    + "</table>\n";
+},"5":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "    <tr>\n        <td>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"node") || (depth0 != null ? lookupProperty(depth0,"node") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"node","hash":{},"data":data,"loc":{"start":{"line":19,"column":12}}}) : helper)))
+    + "</td>\n        <td><code>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"id") || (depth0 != null ? lookupProperty(depth0,"id") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"id","hash":{},"data":data,"loc":{"start":{"line":20,"column":18}}}) : helper)))
+    + "</code></td>\n        <td>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"time") || (depth0 != null ? lookupProperty(depth0,"time") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"time","hash":{},"data":data,"loc":{"start":{"line":21,"column":12}}}) : helper)))
+    + "</td>\n        <td>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"attempts") || (depth0 != null ? lookupProperty(depth0,"attempts") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"attempts","hash":{},"data":data,"loc":{"start":{"line":22,"column":12}}}) : helper)))
+    + "</td>\n        <td><pre>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"text") || (depth0 != null ? lookupProperty(depth0,"text") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"text","hash":{},"data":data,"loc":{"start":{"line":23,"column":17}}}) : helper)))
+    + "</pre></td>\n    </tr>\n";
+},"main":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"in_memory") : depth0),{"name":"if","hash":{},"fn":container.program(1, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":1,"column":0}}})) != null ? stack1 : "")
+    + ((stack1 = lookupProperty(helpers,"unless").call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"messages") : depth0)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"unless","hash":{},"fn":container.program(2, data, 0, blockParams, depths),"inverse":container.program(4, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":4,"column":0}}})) != null ? stack1 : "");
+},"compiler":[8,">= 4.3.0"],"useData":true,"useDepths":true});
+
+},{"hbsfy/runtime":35}],72:[function(require,module,exports){
+// hbsfy compiled Handlebars template
+var HandlebarsCompiler = require('hbsfy/runtime');
+module.exports = HandlebarsCompiler.template({"main":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<div class=\"bubblingG\">\n    <span id=\"bubblingG_1\"></span>\n    <span id=\"bubblingG_2\"></span>\n    <span id=\"bubblingG_3\"></span>\n</div>\n";
+},"compiler":[8,">= 4.3.0"],"useData":true,"useDepths":true});
+
+},{"hbsfy/runtime":35}],73:[function(require,module,exports){
+// hbsfy compiled Handlebars template
+var HandlebarsCompiler = require('hbsfy/runtime');
+module.exports = HandlebarsCompiler.template({"1":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<div class=\"row\">\n    <div class=\"col-md-6\">\n        <h4>Topic Message Queue</h4>\n        <div class=\"alert alert-warning\">\n            <h4>Notice</h4> No producers exist for this topic.\n            <p>See <a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/lookup",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":23,"column":28}}}))
+    + "\">Lookup</a> for more information.\n        </div>\n    </div>\n</div>\n";
+},"2":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"isAdmin") : depth0),{"name":"if","hash":{},"fn":container.program(3, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":28,"column":0}}})) != null ? stack1 : "")
+    + "\n<div class=\"row\">\n    <div class=\"col-md-12\">\n    <h4>Topic Message Queue</h4>\n    <table class=\"table table-bordered table-condensed\">\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,((stack1 = ((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"if","hash":{},"fn":container.program(6, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":50,"column":8}}})) != null ? stack1 : "")
+    + "        <tr>\n            <th>NSQd Host</th>\n            <th>Depth</th>\n            <th>Memory + Disk</th>\n            <th>Messages</th>\n            "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"graph_active") : depth0),{"name":"if","hash":{},"fn":container.program(9, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":61,"column":12}}})) != null ? stack1 : "")
+    + "\n            <th>Channels</th>\n"
+    + ((stack1 = lookupProperty(helpers,"each").call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1),{"name":"each","hash":{},"fn":container.program(10, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":63,"column":12}}})) != null ? stack1 : "")
+    + "        </tr>\n"
+    + ((stack1 = lookupProperty(helpers,"each").call(alias1,(depth0 != null ? lookupProperty(depth0,"nodes") : depth0),{"name":"each","hash":{},"fn":container.program(11, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":67,"column":8}}})) != null ? stack1 : "")
+    + "        <tr class=\"info\">\n            <td>Total:</td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"depth") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":112,"column":16}}}))
+    + "</td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"memory_depth") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":113,"column":16}}}))
+    + " + "
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"backend_depth") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":113,"column":43}}}))
+    + "</td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"message_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":114,"column":16}}}))
+    + "</td>\n            "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"graph_active") : depth0),{"name":"if","hash":{},"fn":container.program(20, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":115,"column":12}}})) != null ? stack1 : "")
+    + "\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"channels") : depth0)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":116,"column":16}}}))
+    + "</td>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,((stack1 = ((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"if","hash":{},"fn":container.program(21, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":117,"column":12}}})) != null ? stack1 : "")
+    + "        </tr>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"graph_active") : depth0),{"name":"if","hash":{},"fn":container.program(23, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":125,"column":8}}})) != null ? stack1 : "")
+    + "    </table>\n    </div>\n</div>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"isAdmin") : depth0),{"name":"if","hash":{},"fn":container.program(25, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":143,"column":0}}})) != null ? stack1 : "");
+},"3":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<div class=\"row topic-actions\">\n    <div class=\"col-md-2\">\n        <button class=\"btn btn-medium btn-warning\" data-action=\"empty\">Empty Queue</button>\n    </div>\n    <div class=\"col-md-2\">\n        <button class=\"btn btn-medium btn-danger\" data-action=\"delete\">Delete Topic</button>\n    </div>\n    <div class=\"col-md-2\">\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"paused") : depth0),{"name":"if","hash":{},"fn":container.program(4, data, 0, blockParams, depths),"inverse":container.program(5, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":37,"column":8}}})) != null ? stack1 : "")
+    + "    </div>\n</div>\n";
+},"4":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "        <button class=\"btn btn-medium btn-success\" data-action=\"unpause\">UnPause Topic</button>\n";
+},"5":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "        <button class=\"btn btn-medium btn-primary\" data-action=\"pause\">Pause Topic</button>\n";
+},"6":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "        <tr>\n            <th colspan=\""
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"graph_active") : depth0),{"name":"if","hash":{},"fn":container.program(7, data, 0, blockParams, depths),"inverse":container.program(8, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":52,"column":25}}})) != null ? stack1 : "")
+    + "\"></th>\n            <th colspan=\""
+    + container.escapeExpression(container.lambda(((stack1 = ((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1)) != null ? lookupProperty(stack1,"length") : stack1), depth0))
+    + "\">E2E Processing Latency</th>\n        </tr>\n";
+},"7":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "6";
+},"8":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "5";
+},"9":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<th>Rate</th>";
+},"10":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                <th>"
+    + container.escapeExpression((lookupProperty(helpers,"floatToPercent") || (depth0 && lookupProperty(depth0,"floatToPercent")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"quantile") : depth0),{"name":"floatToPercent","hash":{},"data":data,"loc":{"start":{"line":64,"column":20}}}))
+    + "<sup>"
+    + container.escapeExpression((lookupProperty(helpers,"percSuffix") || (depth0 && lookupProperty(depth0,"percSuffix")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"quantile") : depth0),{"name":"percSuffix","hash":{},"data":data,"loc":{"start":{"line":64,"column":52}}}))
+    + "</sup></th>\n";
+},"11":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "        <tr>\n            <td>\n                <button class=\"btn-link red tombstone-link\" data-node=\""
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"node") || (depth0 != null ? lookupProperty(depth0,"node") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"node","hash":{},"data":data,"loc":{"start":{"line":70,"column":71}}}) : helper)))
+    + "\" data-topic=\""
+    + container.escapeExpression(container.lambda((depths[1] != null ? lookupProperty(depths[1],"name") : depths[1]), depth0))
+    + "\" style=\"padding: 0 6px; border: 0;\">✘</button>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"show_broadcast_address") : depth0),{"name":"if","hash":{},"fn":container.program(12, data, 0, blockParams, depths),"inverse":container.program(13, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":71,"column":16}}})) != null ? stack1 : "")
+    + "                "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"paused") : depth0),{"name":"if","hash":{},"fn":container.program(14, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":76,"column":16}}})) != null ? stack1 : "")
+    + "\n"
+    + ((stack1 = container.invokePartial(lookupProperty(partials,"depth_limits"),depth0,{"name":"depth_limits","data":data,"indent":"                ","helpers":helpers,"partials":partials,"decorators":container.decorators})) != null ? stack1 : "")
+    + "            </td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"depth") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":79,"column":16}}}))
+    + "</td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"memory_depth") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":80,"column":16}}}))
+    + " + "
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"backend_depth") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":80,"column":43}}}))
+    + "</td>\n            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"message_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":81,"column":16}}}))
+    + "</td>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depths[1] != null ? lookupProperty(depths[1],"graph_active") : depths[1]),{"name":"if","hash":{},"fn":container.program(15, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":82,"column":12}}})) != null ? stack1 : "")
+    + "            <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"channels") : depth0)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":85,"column":16}}}))
+    + "</td>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,((stack1 = ((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"if","hash":{},"fn":container.program(16, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":86,"column":12}}})) != null ? stack1 : "")
+    + "        </tr>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depths[1] != null ? lookupProperty(depths[1],"graph_active") : depths[1]),{"name":"if","hash":{},"fn":container.program(18, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":94,"column":8}}})) != null ? stack1 : "");
+},"12":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                "
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"hostname_port") || (depth0 != null ? lookupProperty(depth0,"hostname_port") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"hostname_port","hash":{},"data":data,"loc":{"start":{"line":72,"column":16}}}) : helper)))
+    + " (<a class=\"link\" href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/nodes",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":72,"column":57}}}))
+    + "/"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"node") || (depth0 != null ? lookupProperty(depth0,"node") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"node","hash":{},"data":data,"loc":{"start":{"line":72,"column":79}}}) : helper)))
+    + "\">"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"node") || (depth0 != null ? lookupProperty(depth0,"node") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"node","hash":{},"data":data,"loc":{"start":{"line":72,"column":89}}}) : helper)))
+    + "</a>)\n";
+},"13":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                <a class=\"link\" href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/nodes",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":74,"column":38}}}))
+    + "/"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"node") || (depth0 != null ? lookupProperty(depth0,"node") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"node","hash":{},"data":data,"loc":{"start":{"line":74,"column":60}}}) : helper)))
+    + "\">"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"hostname_port") || (depth0 != null ? lookupProperty(depth0,"hostname_port") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"hostname_port","hash":{},"data":data,"loc":{"start":{"line":74,"column":70}}}) : helper)))
+    + "</a>\n";
+},"14":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return " <span class=\"label label-primary\">paused</span>";
+},"15":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                <td class=\"bold rate\" target=\""
+    + container.escapeExpression((lookupProperty(helpers,"rate") || (depth0 && lookupProperty(depth0,"rate")) || container.hooks.helperMissing).call(alias1,"topic",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),"",{"name":"rate","hash":{},"data":data,"loc":{"start":{"line":83,"column":46}}}))
+    + "\"></td>\n";
+},"16":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return ((stack1 = lookupProperty(helpers,"each").call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1),{"name":"each","hash":{},"fn":container.program(17, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":87,"column":16}}})) != null ? stack1 : "");
+},"17":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                <td>\n                    <span title=\""
+    + container.escapeExpression((lookupProperty(helpers,"floatToPercent") || (depth0 && lookupProperty(depth0,"floatToPercent")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"quantile") : depth0),{"name":"floatToPercent","hash":{},"data":data,"loc":{"start":{"line":89,"column":33}}}))
+    + ": min = "
+    + container.escapeExpression((lookupProperty(helpers,"nanotohuman") || (depth0 && lookupProperty(depth0,"nanotohuman")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"min") : depth0),{"name":"nanotohuman","hash":{},"data":data,"loc":{"start":{"line":89,"column":68}}}))
+    + ", max = "
+    + container.escapeExpression((lookupProperty(helpers,"nanotohuman") || (depth0 && lookupProperty(depth0,"nanotohuman")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"max") : depth0),{"name":"nanotohuman","hash":{},"data":data,"loc":{"start":{"line":89,"column":95}}}))
+    + "\">"
+    + container.escapeExpression((lookupProperty(helpers,"nanotohuman") || (depth0 && lookupProperty(depth0,"nanotohuman")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"average") : depth0),{"name":"nanotohuman","hash":{},"data":data,"loc":{"start":{"line":89,"column":116}}}))
+    + "</span>\n                </td>\n";
+},"18":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "            <tr class=\"graph-row\">\n                <td></td>\n                <td><a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"topic",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),"","depth",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":97,"column":29}}}))
+    + "\"><img width=\"120\" src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"topic",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),"","depth",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":97,"column":103}}}))
+    + "\"></a></td>\n                <td></td>\n                <td><a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"topic",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),"","message_count",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":99,"column":29}}}))
+    + "\"><img width=\"120\" src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"topic",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),"","message_count",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":99,"column":111}}}))
+    + "\"></a></td>\n                <td></td>\n                <td></td>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,((stack1 = ((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"if","hash":{},"fn":container.program(19, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":102,"column":16}}})) != null ? stack1 : "")
+    + "            </tr>\n";
+},"19":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                <td colspan=\""
+    + container.escapeExpression(container.lambda(((stack1 = ((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1)) != null ? lookupProperty(stack1,"length") : stack1), depth0))
+    + "\">\n                    <a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"e2e",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0),"","e2e_processing_latency",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":104,"column":29}}}))
+    + "\"><img width=\"120\" height=\"20\"  src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"e2e",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0),"","e2e_processing_latency",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":104,"column":143}}}))
+    + "\"></a>\n                </td>\n";
+},"20":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<td class=\"bold rate\" target=\""
+    + container.escapeExpression((lookupProperty(helpers,"rate") || (depth0 && lookupProperty(depth0,"rate")) || container.hooks.helperMissing).call(alias1,"topic","*",(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),"",{"name":"rate","hash":{},"data":data,"loc":{"start":{"line":115,"column":62}}}))
+    + "\"></td>";
+},"21":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return ((stack1 = lookupProperty(helpers,"each").call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1),{"name":"each","hash":{},"fn":container.program(22, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":118,"column":16}}})) != null ? stack1 : "");
+},"22":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                <td>\n                    <span title=\""
+    + container.escapeExpression((lookupProperty(helpers,"floatToPercent") || (depth0 && lookupProperty(depth0,"floatToPercent")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"quantile") : depth0),{"name":"floatToPercent","hash":{},"data":data,"loc":{"start":{"line":120,"column":33}}}))
+    + ": min = "
+    + container.escapeExpression((lookupProperty(helpers,"nanotohuman") || (depth0 && lookupProperty(depth0,"nanotohuman")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"min") : depth0),{"name":"nanotohuman","hash":{},"data":data,"loc":{"start":{"line":120,"column":68}}}))
+    + ", max = "
+    + container.escapeExpression((lookupProperty(helpers,"nanotohuman") || (depth0 && lookupProperty(depth0,"nanotohuman")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"max") : depth0),{"name":"nanotohuman","hash":{},"data":data,"loc":{"start":{"line":120,"column":95}}}))
+    + "\">"
+    + container.escapeExpression((lookupProperty(helpers,"nanotohuman") || (depth0 && lookupProperty(depth0,"nanotohuman")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"average") : depth0),{"name":"nanotohuman","hash":{},"data":data,"loc":{"start":{"line":120,"column":116}}}))
+    + "</span>\n                </td>\n";
+},"23":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "            <tr class=\"graph-row\">\n                <td></td>\n                <td><a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"topic","*",(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),"","depth",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":128,"column":29}}}))
+    + "\"><img width=\"120\" src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"topic",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),"","depth",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":128,"column":102}}}))
+    + "\"></a></td>\n                <td></td>\n                <td><a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"topic","*",(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),"","message_count",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":130,"column":29}}}))
+    + "\"><img width=\"120\" src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"topic",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),"","message_count",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":130,"column":110}}}))
+    + "\"></a></td>\n                <td></td>\n                <td></td>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,((stack1 = ((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"if","hash":{},"fn":container.program(24, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":133,"column":16}}})) != null ? stack1 : "")
+    + "            </tr>\n";
+},"24":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                <td colspan=\""
+    + container.escapeExpression(container.lambda(((stack1 = ((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1)) != null ? lookupProperty(stack1,"length") : stack1), depth0))
+    + "\">\n                    <a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"e2e","*",(depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0),"","e2e_processing_latency",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":135,"column":29}}}))
+    + "\"><img width=\"120\" height=\"20\"  src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"e2e",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0),"","e2e_processing_latency",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":135,"column":142}}}))
+    + "\"></a>\n                </td>\n";
+},"25":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<div class=\"row\">\n    <div class=\"col-md-6\">\n    <h4>Publish Message</h4>\n    <form class=\"form publish-message\">\n        <div class=\"form-group\">\n            <select class=\"form-control\" name=\"node\">\n"
+    + ((stack1 = lookupProperty(helpers,"each").call(alias1,(depth0 != null ? lookupProperty(depth0,"nodes") : depth0),{"name":"each","hash":{},"fn":container.program(26, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":150,"column":16}}})) != null ? stack1 : "")
+    + "            </select>\n        </div>\n        <div class=\"form-group\">\n            <textarea class=\"form-control\" name=\"body\" rows=\"4\" placeholder=\"message body\"></textarea>\n        </div>\n        <button class=\"btn btn-default\" type=\"submit\">Publish</button>\n        <span class=\"publish-result text-success\"></span>\n    </form>\n    </div>\n</div>\n";
+},"26":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                <option value=\""
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"node") || (depth0 != null ? lookupProperty(depth0,"node") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"node","hash":{},"data":data,"loc":{"start":{"line":151,"column":31}}}) : helper)))
+    + "\">"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"hostname_port") || (depth0 != null ? lookupProperty(depth0,"hostname_port") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"hostname_port","hash":{},"data":data,"loc":{"start":{"line":151,"column":41}}}) : helper)))
+    + "</option>\n";
+},"27":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "    <div class=\"col-md-6\">\n        <h4>Channel Message Queues</h4>\n        <div class=\"alert alert-warning\">\n            <h4>Notice</h4> No channels exist for this topic.\n            <p>Messages will queue at the topic until a channel is created.\n        </div>\n";
+},"28":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "    <div class=\"col-md-12\">\n        <h4>Channel Message Queues</h4>\n        <table class=\"table table-bordered table-condensed\">\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,((stack1 = ((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"if","hash":{},"fn":container.program(29, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":181,"column":12}}})) != null ? stack1 : "")
+    + "            <tr>\n                <th>Channel</th>\n                <th>Depth</th>\n                <th>Memory + Disk</th>\n                <th>In-Flight</th>\n                <th>Deferred</th>\n                <th>Requeued</th>\n                <th>Timed Out</th>\n                <th>Messages</th>\n                <th>Connections</th>\n"
+    + ((stack1 = lookupProperty(helpers,"each").call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1),{"name":"each","hash":{},"fn":container.program(32, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":197,"column":16}}})) != null ? stack1 : "")
+    + "            </tr>\n\n"
+    + ((stack1 = lookupProperty(helpers,"each").call(alias1,(depth0 != null ? lookupProperty(depth0,"channels") : depth0),{"name":"each","hash":{},"fn":container.program(33, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":202,"column":12}}})) != null ? stack1 : "")
+    + "        </table>\n";
+},"29":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "            <tr>\n                <th colspan=\""
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"graph_active") : depth0),{"name":"if","hash":{},"fn":container.program(30, data, 0, blockParams, depths),"inverse":container.program(31, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":183,"column":29}}})) != null ? stack1 : "")
+    + "\"></th>\n                <th colspan=\""
+    + container.escapeExpression(container.lambda(((stack1 = ((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1)) != null ? lookupProperty(stack1,"length") : stack1), depth0))
+    + "\">E2E Processing Latency</th>\n            </tr>\n";
+},"30":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "10";
+},"31":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "9";
+},"32":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                <th>"
+    + container.escapeExpression((lookupProperty(helpers,"floatToPercent") || (depth0 && lookupProperty(depth0,"floatToPercent")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"quantile") : depth0),{"name":"floatToPercent","hash":{},"data":data,"loc":{"start":{"line":198,"column":20}}}))
+    + "<sup>"
+    + container.escapeExpression((lookupProperty(helpers,"percSuffix") || (depth0 && lookupProperty(depth0,"percSuffix")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"quantile") : depth0),{"name":"percSuffix","hash":{},"data":data,"loc":{"start":{"line":198,"column":52}}}))
+    + "</sup></th>\n";
+},"33":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "            <tr>\n                <th>\n                    <a class=\"link\" href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/topics",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":205,"column":42}}}))
+    + "/"
+    + container.escapeExpression((lookupProperty(helpers,"urlencode") || (depth0 && lookupProperty(depth0,"urlencode")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),{"name":"urlencode","hash":{},"data":data,"loc":{"start":{"line":205,"column":65}}}))
+    + "/"
+    + container.escapeExpression((lookupProperty(helpers,"urlencode") || (depth0 && lookupProperty(depth0,"urlencode")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),{"name":"urlencode","hash":{},"data":data,"loc":{"start":{"line":205,"column":90}}}))
+    + "\">"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"channel_name") || (depth0 != null ? lookupProperty(depth0,"channel_name") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"channel_name","hash":{},"data":data,"loc":{"start":{"line":205,"column":118}}}) : helper)))
+    + "</a>\n                    "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"paused") : depth0),{"name":"if","hash":{},"fn":container.program(34, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":206,"column":20}}})) != null ? stack1 : "")
+    + "\n                    "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"depth_reject_count") : depth0),{"name":"if","hash":{},"fn":container.program(35, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":207,"column":20}}})) != null ? stack1 : "")
+    + "\n                    "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"depth_drop_count") : depth0),{"name":"if","hash":{},"fn":container.program(36, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":208,"column":20}}})) != null ? stack1 : "")
+    + "\n                </th>\n                <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"depth") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":210,"column":20}}}))
+    + "</td>\n                <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"memory_depth") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":211,"column":20}}}))
+    + " + "
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"backend_depth") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":211,"column":47}}}))
+    + "</td>\n                <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"in_flight_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":212,"column":20}}}))
+    + "</td>\n                <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"deferred_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":213,"column":20}}}))
+    + "</td>\n                <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"requeue_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":214,"column":20}}}))
+    + "</td>\n                <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"timeout_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":215,"column":20}}}))
+    + "</td>\n                <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"message_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":216,"column":20}}}))
+    + "</td>\n                <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"client_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":217,"column":20}}}))
+    + "</td>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,((stack1 = ((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"if","hash":{},"fn":container.program(37, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":218,"column":16}}})) != null ? stack1 : "")
+    + "            </tr>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depths[1] != null ? lookupProperty(depths[1],"graph_active") : depths[1]),{"name":"if","hash":{},"fn":container.program(39, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":226,"column":12}}})) != null ? stack1 : "");
+},"34":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<span class=\"label label-primary\">paused</span>";
+},"35":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return " <span class=\"label label-danger\" title=\"publishes rejected at max depth\">"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"depth_reject_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":207,"column":120}}}))
+    + " rejected</span>";
+},"36":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return " <span class=\"label label-warning\" title=\"messages dropped at max depth\">"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"depth_drop_count") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":208,"column":117}}}))
+    + " dropped</span>";
+},"37":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return ((stack1 = lookupProperty(helpers,"each").call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1),{"name":"each","hash":{},"fn":container.program(38, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":219,"column":20}}})) != null ? stack1 : "");
+},"38":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                    <td>\n                        <span title=\""
+    + container.escapeExpression((lookupProperty(helpers,"floatToPercent") || (depth0 && lookupProperty(depth0,"floatToPercent")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"quantile") : depth0),{"name":"floatToPercent","hash":{},"data":data,"loc":{"start":{"line":221,"column":37}}}))
+    + ": min = "
+    + container.escapeExpression((lookupProperty(helpers,"nanotohuman") || (depth0 && lookupProperty(depth0,"nanotohuman")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"min") : depth0),{"name":"nanotohuman","hash":{},"data":data,"loc":{"start":{"line":221,"column":72}}}))
+    + ", max = "
+    + container.escapeExpression((lookupProperty(helpers,"nanotohuman") || (depth0 && lookupProperty(depth0,"nanotohuman")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"max") : depth0),{"name":"nanotohuman","hash":{},"data":data,"loc":{"start":{"line":221,"column":99}}}))
+    + "\">"
+    + container.escapeExpression((lookupProperty(helpers,"nanotohuman") || (depth0 && lookupProperty(depth0,"nanotohuman")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"average") : depth0),{"name":"nanotohuman","hash":{},"data":data,"loc":{"start":{"line":221,"column":120}}}))
+    + "</span>\n                    </td>\n";
+},"39":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "            <tr class=\"graph-row\">\n                <td></td>\n                <td><a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"depth",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":229,"column":29}}}))
+    + "\"><img width=\"120\" height=\"20\"  src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"depth",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":229,"column":128}}}))
+    + "\"></a></td>\n                <td></td>\n                <td><a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"in_flight_count",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":231,"column":29}}}))
+    + "\"><img width=\"120\" height=\"20\"  src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"in_flight_count",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":231,"column":138}}}))
+    + "\"></a></td>\n                <td><a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"deferred_count",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":232,"column":29}}}))
+    + "\"><img width=\"120\" height=\"20\"  src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"deferred_count",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":232,"column":137}}}))
+    + "\"></a></td>\n                <td><a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"requeue_count",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":233,"column":29}}}))
+    + "\"><img width=\"120\" height=\"20\"  src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"requeue_count",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":233,"column":136}}}))
+    + "\"></a></td>\n                <td><a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"timeout_count",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":234,"column":29}}}))
+    + "\"><img width=\"120\" height=\"20\"  src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"timeout_count",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":234,"column":136}}}))
+    + "\"></a></td>\n                <td><a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"message_count",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":235,"column":29}}}))
+    + "\"><img width=\"120\" height=\"20\"  src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"message_count",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":235,"column":136}}}))
+    + "\"></a></td>\n                <td><a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"clients",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":236,"column":29}}}))
+    + "\"><img width=\"120\" height=\"20\"  src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"channel",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"topic_name") : depth0),(depth0 != null ? lookupProperty(depth0,"channel_name") : depth0),"clients",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":236,"column":130}}}))
+    + "\"></a></td>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,((stack1 = ((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"if","hash":{},"fn":container.program(40, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":237,"column":16}}})) != null ? stack1 : "")
+    + "            </tr>\n";
+},"40":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                    <td colspan=\""
+    + container.escapeExpression(container.lambda(((stack1 = ((stack1 = (depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0)) != null ? lookupProperty(stack1,"percentiles") : stack1)) != null ? lookupProperty(stack1,"length") : stack1), depth0))
+    + "\">\n                        <a href=\""
+    + container.escapeExpression((lookupProperty(helpers,"large_graph") || (depth0 && lookupProperty(depth0,"large_graph")) || container.hooks.helperMissing).call(alias1,"e2e",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0),"","e2e_processing_latency",{"name":"large_graph","hash":{},"data":data,"loc":{"start":{"line":239,"column":33}}}))
+    + "\"><img width=\"120\" height=\"20\"  src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"e2e",(depth0 != null ? lookupProperty(depth0,"node") : depth0),(depth0 != null ? lookupProperty(depth0,"e2e_processing_latency") : depth0),"","e2e_processing_latency",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":239,"column":147}}}))
+    + "\"></a>\n                    </td>\n";
+},"main":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return ((stack1 = container.invokePartial(lookupProperty(partials,"warning"),depth0,{"name":"warning","data":data,"helpers":helpers,"partials":partials,"decorators":container.decorators})) != null ? stack1 : "")
+    + ((stack1 = container.invokePartial(lookupProperty(partials,"error"),depth0,{"name":"error","data":data,"helpers":helpers,"partials":partials,"decorators":container.decorators})) != null ? stack1 : "")
+    + "\n<ol class=\"breadcrumb\">\n  <li><a class=\"link\" href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":5,"column":28}}}))
+    + "\">Streams</a>\n  <li class=\"active\">"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"name") || (depth0 != null ? lookupProperty(depth0,"name") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"name","hash":{},"data":data,"loc":{"start":{"line":6,"column":21}}}) : helper)))
+    + "</li>\n</ol>\n\n<div class=\"row\">\n    <div class=\"col-md-6\">\n        <blockquote>\n            <p>Topic: <strong>"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"name") || (depth0 != null ? lookupProperty(depth0,"name") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"name","hash":{},"data":data,"loc":{"start":{"line":12,"column":30}}}) : helper)))
+    + "</strong>\n        </blockquote>\n    </div>\n</div>\n\n"
+    + ((stack1 = lookupProperty(helpers,"unless").call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"nodes") : depth0)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"unless","hash":{},"fn":container.program(1, data, 0, blockParams, depths),"inverse":container.program(2, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":17,"column":0}}})) != null ? stack1 : "")
+    + "\n"
+    + ((stack1 = container.invokePartial(lookupProperty(partials,"history"),depth0,{"name":"history","data":data,"helpers":helpers,"partials":partials,"decorators":container.decorators})) != null ? stack1 : "")
+    + "\n\n<div class=\"row\">\n"
+    + ((stack1 = lookupProperty(helpers,"unless").call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"channels") : depth0)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"unless","hash":{},"fn":container.program(27, data, 0, blockParams, depths),"inverse":container.program(28, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":170,"column":4}}})) != null ? stack1 : "")
+    + "    </div>\n</div>\n";
+},"compiler":[8,">= 4.3.0"],"usePartial":true,"useData":true,"useDepths":true});
+
+},{"hbsfy/runtime":35}],74:[function(require,module,exports){
+var $ = require('jquery');
+
+window.jQuery = $;
+var bootstrap = require('bootstrap'); //eslint-disable-line no-unused-vars
+var bootbox = require('bootbox');
+
+var HistoryChart = require('../lib/history_chart');
+var Pubsub = require('../lib/pubsub');
+var AppState = require('../app_state');
+
+var BaseView = require('./base');
+
+var TopicView = BaseView.extend({
+    className: 'topic container-fluid',
+
+    template: require('./spinner.hbs'),
+
+    events: {
+        'click .topic-actions button': 'topicAction',
+        'submit .publish-message': 'publishMessage'
+    },
+
+    initialize: function() {
+        BaseView.prototype.initialize.apply(this, arguments);
+        this.listenTo(AppState, 'change:graph_interval', this.render);
+        var isAdmin = this.model.get('isAdmin');
+        this.model.fetch()
+            .done(function(data) {
+                this.template = require('./topic.hbs');
+                this.render({'message': data['message'], 'isAdmin': isAdmin});
+            }.bind(this))
+            .fail(this.handleViewError.bind(this))
+            .always(Pubsub.trigger.bind(Pubsub, 'view:ready'));
+    },
+
+    postRender: function(ctx) {
+        if (!ctx['history_active'] || !ctx['nodes']) {
+            return;
+        }
+        var url = AppState.apiPath('/history/topics/' + encodeURIComponent(this.model.get('name')));
+        HistoryChart.render(this.$('.history-charts'), url, ctx['graph_interval'], false);
+    },
+
+    topicAction: function(e) {
+        e.preventDefault();
+        e.stopPropagation();
+        var action = $(e.currentTarget).data('action');
+        var txt = 'Are you sure you want to <strong>' +
+            action + '</strong> <em>' + this.model.get('name') + '</em>?';
+        bootbox.confirm(txt, function(result) {
+            if (result !== true) {
+                return;
+            }
+            if (action === 'delete') {
+                $.ajax(this.model.url(), {'method': 'DELETE'})
+                    .done(function() { window.location = AppState.basePath('/'); });
+            } else {
+                $.post(this.model.url(), JSON.stringify({'action': action}))
+                    .done(function() { window.location.reload(true); })
+                    .fail(this.handleAJAXError.bind(this));
+            }
+        }.bind(this));
+    },
+
+    publishMessage: function(e) {
+        e.preventDefault();
+        e.stopPropagation();
+        var form = $(e.currentTarget);
+        var url = AppState.apiPath('/messages/' + encodeURIComponent(this.model.get('name')));
+        $.post(url, JSON.stringify({
+            'node': form.find('[name=node]').val(),
+            'body': form.find('[name=body]').val()
+        }))
+            .done(function(data) {
+                $('#error').hide();
+                form.find('.publish-result').text('published to ' + data['node']);
+            })
+            .fail(this.handleAJAXError.bind(this));
+    }
+});
+
+module.exports = TopicView;
+
+},{"../app_state":36,"../lib/history_chart":41,"../lib/pubsub":42,"./base":51,"./spinner.hbs":72,"./topic.hbs":73,"bootstrap":1}],75:[function(require,module,exports){
+// hbsfy compiled Handlebars template
+var HandlebarsCompiler = require('hbsfy/runtime');
+module.exports = HandlebarsCompiler.template({"1":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "        <table class=\"table table-condensed table-bordered\">\n            <tr>\n                <th><a href=\"#\" class=\"sort-topics\" data-sort=\"name\">Topic</a></th>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"lag_loaded") : depth0),{"name":"if","hash":{},"fn":container.program(2, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":16,"column":16}}})) != null ? stack1 : "")
+    + "                "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"graph_active") : depth0),{"name":"if","hash":{},"fn":container.program(3, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":21,"column":16}}})) != null ? stack1 : "")
+    + "\n                "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"graph_active") : depth0),{"name":"if","hash":{},"fn":container.program(4, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":22,"column":16}}})) != null ? stack1 : "")
+    + "\n                "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"graph_active") : depth0),{"name":"if","hash":{},"fn":container.program(5, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":23,"column":16}}})) != null ? stack1 : "")
+    + "\n            </tr>\n"
+    + ((stack1 = lookupProperty(helpers,"each").call(alias1,(depth0 != null ? lookupProperty(depth0,"collection") : depth0),{"name":"each","hash":{},"fn":container.program(6, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":25,"column":12}}})) != null ? stack1 : "")
+    + "        </table>\n";
+},"2":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                <th><a href=\"#\" class=\"sort-topics\" data-sort=\"depth\">Depth</a></th>\n                <th><a href=\"#\" class=\"sort-topics\" data-sort=\"oldest_message_age\">Oldest Message</a></th>\n                <th><a href=\"#\" class=\"sort-topics\" data-sort=\"drain_eta\">Time to Drain</a></th>\n";
+},"3":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<th width=\"120\">Depth</th>";
+},"4":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<th width=\"120\">Messages</th>";
+},"5":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<th width=\"120\">Rate</th>";
+},"6":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "            <tr>\n                <td><a class=\"link\" href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/topics",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":27,"column":42}}}))
+    + "/"
+    + container.escapeExpression((lookupProperty(helpers,"urlencode") || (depth0 && lookupProperty(depth0,"urlencode")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"name") : depth0),{"name":"urlencode","hash":{},"data":data,"loc":{"start":{"line":27,"column":65}}}))
+    + "\">"
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"name") || (depth0 != null ? lookupProperty(depth0,"name") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"name","hash":{},"data":data,"loc":{"start":{"line":27,"column":85}}}) : helper)))
+    + "</a></td>\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depths[1] != null ? lookupProperty(depths[1],"lag_loaded") : depths[1]),{"name":"if","hash":{},"fn":container.program(7, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":28,"column":16}}})) != null ? stack1 : "")
+    + "                "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depths[1] != null ? lookupProperty(depths[1],"graph_active") : depths[1]),{"name":"if","hash":{},"fn":container.program(12, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":33,"column":16}}})) != null ? stack1 : "")
+    + "\n                "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depths[1] != null ? lookupProperty(depths[1],"graph_active") : depths[1]),{"name":"if","hash":{},"fn":container.program(13, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":34,"column":16}}})) != null ? stack1 : "")
+    + "\n                "
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depths[1] != null ? lookupProperty(depths[1],"graph_active") : depths[1]),{"name":"if","hash":{},"fn":container.program(14, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":35,"column":16}}})) != null ? stack1 : "")
+    + "\n            </tr>\n";
+},"7":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "                <td>"
+    + container.escapeExpression((lookupProperty(helpers,"commafy") || (depth0 && lookupProperty(depth0,"commafy")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"depth") : depth0),{"name":"commafy","hash":{},"data":data,"loc":{"start":{"line":29,"column":20}}}))
+    + "</td>\n                <td>"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"oldest_message_age") : depth0),{"name":"if","hash":{},"fn":container.program(8, data, 0, blockParams, depths),"inverse":container.program(9, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":30,"column":20}}})) != null ? stack1 : "")
+    + "</td>\n                <td>"
+    + ((stack1 = (lookupProperty(helpers,"ifeq") || (depth0 && lookupProperty(depth0,"ifeq")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"drain_eta") : depth0),null,{"name":"ifeq","hash":{},"fn":container.program(10, data, 0, blockParams, depths),"inverse":container.program(11, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":31,"column":20}}})) != null ? stack1 : "")
+    + "</td>\n";
+},"8":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return container.escapeExpression((lookupProperty(helpers,"nanotohuman") || (depth0 && lookupProperty(depth0,"nanotohuman")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"oldest_message_age") : depth0),{"name":"nanotohuman","hash":{},"data":data,"loc":{"start":{"line":30,"column":46}}}));
+},"9":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "-";
+},"10":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "-";
+},"11":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return container.escapeExpression((lookupProperty(helpers,"drainETA") || (depth0 && lookupProperty(depth0,"drainETA")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"drain_eta") : depth0),{"name":"drainETA","hash":{},"data":data,"loc":{"start":{"line":31,"column":53}}}));
+},"12":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<td><a class=\"link\" href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/topics",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":33,"column":65}}}))
+    + "/"
+    + container.escapeExpression((lookupProperty(helpers,"urlencode") || (depth0 && lookupProperty(depth0,"urlencode")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"name") : depth0),{"name":"urlencode","hash":{},"data":data,"loc":{"start":{"line":33,"column":88}}}))
+    + "\"><img width=\"120\" height=\"20\" src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"topic","",(depth0 != null ? lookupProperty(depth0,"name") : depth0),"","depth",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":33,"column":142}}}))
+    + "\"></a></td>";
+},"13":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<td><a class=\"link\" href=\""
+    + container.escapeExpression((lookupProperty(helpers,"basePath") || (depth0 && lookupProperty(depth0,"basePath")) || container.hooks.helperMissing).call(alias1,"/topics",{"name":"basePath","hash":{},"data":data,"loc":{"start":{"line":34,"column":65}}}))
+    + "/"
+    + container.escapeExpression((lookupProperty(helpers,"urlencode") || (depth0 && lookupProperty(depth0,"urlencode")) || container.hooks.helperMissing).call(alias1,(depth0 != null ? lookupProperty(depth0,"name") : depth0),{"name":"urlencode","hash":{},"data":data,"loc":{"start":{"line":34,"column":88}}}))
+    + "\"><img width=\"120\" height=\"20\" src=\""
+    + container.escapeExpression((lookupProperty(helpers,"sparkline") || (depth0 && lookupProperty(depth0,"sparkline")) || container.hooks.helperMissing).call(alias1,"topic","",(depth0 != null ? lookupProperty(depth0,"name") : depth0),"","message_count",{"name":"sparkline","hash":{},"data":data,"loc":{"start":{"line":34,"column":142}}}))
+    + "\"></a></td>";
+},"14":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<td class=\"bold rate\" target=\""
+    + container.escapeExpression((lookupProperty(helpers,"rate") || (depth0 && lookupProperty(depth0,"rate")) || container.hooks.helperMissing).call(alias1,"topic","*",(depth0 != null ? lookupProperty(depth0,"name") : depth0),"",{"name":"rate","hash":{},"data":data,"loc":{"start":{"line":35,"column":69}}}))
+    + "\"></td>";
+},"15":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "        <div class=\"alert alert-warning\"><h4>Notice</h4>No Topics Found</div>\n";
+},"16":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<div class=\"row\">\n    <div class=\"col-md-12\">\n    <h4>Bulk Action</h4>\n    <form class=\"form-inline bulk-action\">\n        <input class=\"form-control\" type=\"text\" name=\"topic\" placeholder=\"topic regex\">\n        <input class=\"form-control\" type=\"text\" name=\"channel\" placeholder=\"channel regex (optional)\">\n        <input class=\"form-control\" type=\"text\" name=\"selector\" placeholder=\"label selector (optional)\">\n        <select class=\"form-control\" name=\"action\">\n            <option value=\"pause\">pause</option>\n            <option value=\"unpause\">unpause</option>\n            <option value=\"empty\">empty</option>\n            <option value=\"delete\">delete</option>\n        </select>\n        <button class=\"btn btn-default\" type=\"submit\">Preview</button>\n    </form>\n    <p><small>Channel regex selects channels instead of topics, label selector (e.g. <code>zone=us-east-1a,!canary</code>) limits the nodes.</small></p>\n    <div class=\"bulk-targets\"></div>\n    </div>\n</div>\n";
+},"main":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return ((stack1 = container.invokePartial(lookupProperty(partials,"warning"),depth0,{"name":"warning","data":data,"helpers":helpers,"partials":partials,"decorators":container.decorators})) != null ? stack1 : "")
+    + ((stack1 = container.invokePartial(lookupProperty(partials,"error"),depth0,{"name":"error","data":data,"helpers":helpers,"partials":partials,"decorators":container.decorators})) != null ? stack1 : "")
+    + "\n<div class=\"row\">\n    <div class=\"col-md-12\">\n        <h2>Topics</h2>\n    </div>\n</div>\n\n<div class=\"row\">\n    <div class=\"col-md-6\">\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,((stack1 = (depth0 != null ? lookupProperty(depth0,"collection") : depth0)) != null ? lookupProperty(stack1,"length") : stack1),{"name":"if","hash":{},"fn":container.program(1, data, 0, blockParams, depths),"inverse":container.program(15, data, 0, blockParams, depths),"data":data,"loc":{"start":{"line":12,"column":4}}})) != null ? stack1 : "")
+    + "    </div>\n</div>\n\n"
+    + ((stack1 = lookupProperty(helpers,"if").call(alias1,(depth0 != null ? lookupProperty(depth0,"isAdmin") : depth0),{"name":"if","hash":{},"fn":container.program(16, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":45,"column":0}}})) != null ? stack1 : "");
+},"compiler":[8,">= 4.3.0"],"usePartial":true,"useData":true,"useDepths":true});
+
+},{"hbsfy/runtime":35}],76:[function(require,module,exports){
+var _ = require('underscore');
+var $ = require('jquery');
+
+window.jQuery = $;
+var bootstrap = require('bootstrap'); //eslint-disable-line no-unused-vars
+var bootbox = require('bootbox');
+
+var Pubsub = require('../lib/pubsub');
+var AppState = require('../app_state');
+
+var BaseView = require('./base');
+var Topics = require('../collections/topics');
+
+var bulkTargetsTemplate = require('./bulk_targets.hbs');
+
+var TopicsView = BaseView.extend({
+    className: 'topics container-fluid',
+
+    template: require('./spinner.hbs'),
+
+    events: {
+        'click .sort-topics': 'sortTopics',
+        'submit .bulk-action': 'previewBulkAction',
+        'click .bulk-apply': 'applyBulkAction'
+    },
+
+    initialize: function() {
+        BaseView.prototype.initialize.apply(this, arguments);
+        this.listenTo(AppState, 'change:graph_interval', this.render);
+        this.collection = new Topics();
+        this.collection.fetch()
+            .done(function(data) {
+                this.template = require('./topics.hbs');
+                this.renderData = {'message': data['message'], 'isAdmin': AppState.get('IS_ADMIN')};
+                this.render(this.renderData);
+                this.fetchLag();
+            }.bind(this))
+            .fail(this.handleViewError.bind(this))
+            .always(Pubsub.trigger.bind(Pubsub, 'view:ready'));
+    },
+
+    // fetchLag adds the total depth, the oldest message and the longest time
+    // to drain of the channels of each topic
+    fetchLag: function() {
+        $.get(AppState.apiPath('/lag'))
+            .done(function(data) {
+                var byTopic = _.groupBy(data['channels'], 'topic_name');
+                this.collection.each(function(topic) {
+                    var lag = {'depth': 0, 'oldest_message_age': 0, 'drain_eta': null};
+                    _.each(byTopic[topic.get('name')], function(c) {
+                        lag['depth'] += c['depth'];
+                        lag['oldest_message_age'] = Math.max(lag['oldest_message_age'], c['oldest_message_age']);
+                        if (!c['window'] || lag['drain_eta'] === -1) {
+                            return;
+                        }
+                        if (c['drain_eta'] === -1 || lag['drain_eta'] === null) {
+                            lag['drain_eta'] = c['drain_eta'];
+                        } else {
+                            lag['drain_eta'] = Math.max(lag['drain_eta'], c['drain_eta']);
+                        }
+                    });
+                    topic.set(lag);
+                });
+                this.renderData['lag_loaded'] = true;
+                this.render(this.renderData);
+            }.bind(this));
+    },
+
+    sortTopics: function(e) {
+        e.preventDefault();
+        var key = $(e.currentTarget).data('sort');
+        if (key === 'name') {
+            this.collection.comparator = 'name';
+        } else {
+            // the most behind first, "never" draining is the most behind
+            this.collection.comparator = function(t) {
+                var v = t.get(key);
+                if (key === 'drain_eta' && v === -1) {
+                    return -Infinity;
+                }
+                return -(v || 0);
+            };
+        }
+        this.collection.sort();
+        this.render(this.renderData);
+    },
+
+    bulkAction: function(req) {
+        return $.post(AppState.apiPath('/bulk'), JSON.stringify(req))
+            .done(function(data) {
+                $('#error').hide();
+                this.$('.bulk-targets').html(bulkTargetsTemplate($.extend({}, data, req)));
+            }.bind(this))
+            .fail(this.handleAJAXError.bind(this));
+    },
+
+    previewBulkAction: function(e) {
+        e.preventDefault();
+        e.stopPropagation();
+        var form = $(e.currentTarget);
+        // applied as previewed, even when the form is edited in between
+        this.bulkRequest = {
+            'action': form.find('[name=action]').val(),
+            'topic': form.find('[name=topic]').val(),
+            'channel': form.find('[name=channel]').val(),
+            'selector': form.find('[name=selector]').val()
+        };
+        this.bulkAction($.extend({'dry_run': true}, this.bulkRequest));
+    },
+
+    applyBulkAction: function(e) {
+        e.preventDefault();
+        e.stopPropagation();
+        var req = this.bulkRequest;
+        var txt = 'Are you sure you want to <strong>' + req['action'] +
+            '</strong> everything listed?';
+        bootbox.confirm(txt, function(result) {
+            if (result !== true) {
+                return;
+            }
+            this.bulkAction($.extend({'dry_run': false}, req));
+        }.bind(this));
+    }
+});
+
+module.exports = TopicsView;
+
+},{"../app_state":36,"../collections/topics":38,"../lib/pubsub":42,"./base":51,"./bulk_targets.hbs":52,"./spinner.hbs":72,"./topics.hbs":75,"bootstrap":1}],77:[function(require,module,exports){
+// hbsfy compiled Handlebars template
+var HandlebarsCompiler = require('hbsfy/runtime');
+module.exports = HandlebarsCompiler.template({"1":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "style=\"display: none;\"";
+},"main":function(container,depth0,helpers,partials,data,blockParams,depths) {
+    var stack1, helper, alias1=depth0 != null ? depth0 : (container.nullContext || {}), lookupProperty = container.lookupProperty || function(parent, propertyName) {
+        if (Object.prototype.hasOwnProperty.call(parent, propertyName)) {
+          return parent[propertyName];
+        }
+        return undefined
+    };
+
+  return "<div class=\"row\" id=\"warning\" "
+    + ((stack1 = lookupProperty(helpers,"unless").call(alias1,(depth0 != null ? lookupProperty(depth0,"message") : depth0),{"name":"unless","hash":{},"fn":container.program(1, data, 0, blockParams, depths),"inverse":container.noop,"data":data,"loc":{"start":{"line":1,"column":30}}})) != null ? stack1 : "")
+    + ">\n    <div class=\"col-md-12\">\n        <div class=\"alert alert-warning\">\n            "
+    + container.escapeExpression(((helper = (helper = lookupProperty(helpers,"message") || (depth0 != null ? lookupProperty(depth0,"message") : depth0)) != null ? helper : container.hooks.helperMissing),(typeof helper === "function" ? helper.call(alias1,{"name":"message","hash":{},"data":data,"loc":{"start":{"line":4,"column":12}}}) : helper)))
+    + "\n        </div>\n    </div>\n</div>\n";
+},"compiler":[8,">= 4.3.0"],"useData":true,"useDepths":true});
+
+},{"hbsfy/runtime":35}]},{},[43]);
 //# sourceMappingURL=main.js.map
@@ -7,11 +7,17 @@ var AppState = Backbone.Model.extend({
             'VERSION': VERSION,
             'GRAPHITE_URL': GRAPHITE_URL,
             'GRAPH_ENABLED': GRAPH_ENABLED,
+            'HISTORY_ENABLED': HISTORY_ENABLED,
+            'ALERTS_ENABLED': ALERTS_ENABLED,
+            'USER': USER,
+            'LOGOUT_ENABLED': LOGOUT_ENABLED,
             'STATSD_INTERVAL': STATSD_INTERVAL,
             'STATSD_COUNTER_FORMAT': STATSD_COUNTER_FORMAT,
             'STATSD_GAUGE_FORMAT': STATSD_GAUGE_FORMAT,
             'STATSD_PREFIX': STATSD_PREFIX,
             'NSQLOOKUPD': NSQLOOKUPD,
+            'CLUSTER_NAME': CLUSTER_NAME,
+            'CLUSTERS': CLUSTERS || [],
             'graph_interval': '2h',
             'IS_ADMIN': IS_ADMIN,
             'BASE_PATH': BASE_PATH
@@ -26,7 +32,24 @@ var AppState = Backbone.Model.extend({
         var qp = _.object(_.compact(_.map(window.location.search.slice(1).split('&'),
             function(item) { return item ? item.split('=') : false; })));
 
-        var def = this.get('GRAPH_ENABLED') ? '2h' : 'off';
+        this.on('change:cluster', function(model, v) {
+            localStorage.setItem('cluster', v);
+        });
+
+        var cluster = _.findWhere(this.get('CLUSTERS'), {
+            'name': qp['cluster'] || localStorage.getItem('cluster')
+        });
+        if (cluster && cluster['name'] !== this.get('CLUSTER_NAME')) {
+            // history and alerts are of the default cluster only
+            this.set({
+                'NSQLOOKUPD': cluster['nsqlookupd'] || [],
+                'HISTORY_ENABLED': false,
+                'ALERTS_ENABLED': false
+            });
+        }
+        this.set('cluster', cluster ? cluster['name'] : this.get('CLUSTER_NAME'));
+
+        var def = this.get('GRAPH_ENABLED') || this.get('HISTORY_ENABLED') ? '2h' : 'off';
         var interval = qp['t'] || localStorage.getItem('graph_interval') || def;
         this.set('graph_interval', interval);
     },
@@ -39,7 +62,17 @@ var AppState = Backbone.Model.extend({
     },
 
     apiPath: function(p) {
+        if (this.get('cluster') !== this.get('CLUSTER_NAME')) {
+            p = '/clusters/' + encodeURIComponent(this.get('cluster')) + p;
+        }
         return this.basePath('/api' + p);
+    },
+
+    // selectCluster shows the streams of another cluster
+    selectCluster: function(name) {
+        this.set('cluster', name);
+        // reload, so that every view and setting is of the new cluster
+        window.location.assign(this.basePath('/'));
     }
 });
 
@@ -19,6 +19,10 @@ var Nodes = Backbone.Collection.extend({
 
     parse: function(resp) {
         resp['nodes'].forEach(function(n) {
+            if (n['http_socket']) {
+                n['broadcast_address_http'] = 'unix://' + n['http_socket'];
+                return;
+            }
             var jaddr = n['broadcast_address'];
             if (jaddr.includes(':')) {
                 // ipv6 raw address contains ':'
@@ -231,6 +231,18 @@ Handlebars.registerHelper('nanotohuman', function(n) {
     return s;
 });
 
+// drain_eta is -1 while the depth is not going down
+Handlebars.registerHelper('drainETA', function(n) {
+    if (n < 0) {
+        return 'never';
+    }
+    return Handlebars.helpers.nanotohuman(n);
+});
+
+Handlebars.registerHelper('msgRate', function(r) {
+    return round(r, 1) + '/s';
+});
+
 Handlebars.registerHelper('sparkline', function(typ, node, ns1, ns2, key) {
     var q = {
         'colorList': genColorList(typ, key),
@@ -289,6 +301,9 @@ Handlebars.registerHelper('rate', function(typ, node, ns1, ns2) {
 
 Handlebars.registerPartial('error', require('../views/error.hbs'));
 Handlebars.registerPartial('warning', require('../views/warning.hbs'));
+Handlebars.registerPartial('depth_limits', require('../views/depth_limits.hbs'));
+Handlebars.registerPartial('history', require('../views/history.hbs'));
+Handlebars.registerPartial('lag', require('../views/lag.hbs'));
 
 Handlebars.registerHelper('basePath', function(p) {
     return AppState.basePath(p);
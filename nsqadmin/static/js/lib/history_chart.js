@@ -0,0 +1,64 @@
+var $ = require('jquery');
+var _ = require('underscore');
+
+var WIDTH = 360;
+var HEIGHT = 80;
+
+var SERIES = [
+    {'key': 'depth', 'title': 'Depth', 'color': 'red'},
+    {'key': 'message_rate', 'title': 'Messages/s', 'color': 'green'},
+    {'key': 'requeue_rate', 'title': 'Requeued/s', 'color': 'orange', 'channelOnly': true},
+    {'key': 'timeout_rate', 'title': 'Timed Out/s', 'color': 'purple', 'channelOnly': true}
+];
+
+var formatValue = function(v) {
+    return v >= 100 || v === Math.floor(v) ? Math.round(v).toLocaleString() : v.toFixed(2);
+};
+
+// chart returns an SVG line chart of one key of the history points
+var chart = function(points, series, from, until) {
+    var values = _.pluck(points, series['key']);
+    var max = _.max(values.concat([0]));
+    var span = Math.max(until - from, 1);
+    var coords = _.map(points, function(p) {
+        var x = (p['ts'] - from) / span * WIDTH;
+        var y = HEIGHT - (max > 0 ? p[series['key']] / max * HEIGHT : 0);
+        return x.toFixed(1) + ',' + y.toFixed(1);
+    });
+    var last = values.length ? values[values.length - 1] : 0;
+    return '<div class="col-md-3">' +
+        '<h5>' + series['title'] + ' <small>now ' + formatValue(last) +
+        ', max ' + formatValue(max) + '</small></h5>' +
+        '<svg width="' + WIDTH + '" height="' + HEIGHT + '" class="history-chart">' +
+        '<rect width="' + WIDTH + '" height="' + HEIGHT + '" fill="#f8f8f8"></rect>' +
+        '<polyline fill="none" stroke="' + series['color'] + '" stroke-width="1.5" points="' +
+        coords.join(' ') + '"></polyline></svg></div>';
+};
+
+// render fetches the built-in history of a topic or channel and draws its
+// charts into $el
+var render = function($el, url, window, isChannel) {
+    $.getJSON(url, {'window': window})
+        .done(function(data) {
+            var points = data['points'] || [];
+            if (!points.length) {
+                $el.html('<p class="text-muted">No history recorded yet.</p>');
+                return;
+            }
+            var from = points[0]['ts'];
+            var until = points[points.length - 1]['ts'];
+            var html = _.map(_.filter(SERIES, function(s) {
+                return isChannel || !s['channelOnly'];
+            }), function(s) {
+                return chart(points, s, from, until);
+            });
+            $el.html('<div class="row">' + html.join('') + '</div>');
+        })
+        .fail(function() {
+            $el.html('<p class="text-muted">Failed to load history.</p>');
+        });
+};
+
+module.exports = {
+    'render': render
+};
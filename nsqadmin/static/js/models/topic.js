@@ -16,6 +16,12 @@ var Topic = Backbone.Model.extend({
 
     parse: function(response) {
         response['nodes'] = _.map(response['nodes'] || [], function(node) {
+            if (node['node'].indexOf('unix://') === 0) {
+                // an nsqd reached over a unix socket has no port to show
+                node['show_broadcast_address'] = true;
+                node['hostname_port'] = node['hostname'];
+                return node;
+            }
             var nodeParts = node['node'].split(':');
             var port = nodeParts.pop();
             var address = nodeParts.join(':');
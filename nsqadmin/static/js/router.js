@@ -13,8 +13,10 @@ var Router = Backbone.Router.extend({
         this.route(bp('/'), 'topics');
         this.route(bp('/topics/(:topic)(/:channel)'), 'topic');
         this.route(bp('/lookup'), 'lookup');
-        this.route(bp('/nodes(/:node)'), 'nodes');
+        this.route(bp('/nodes(/*node)'), 'nodes');
         this.route(bp('/counter'), 'counter');
+        this.route(bp('/alerts'), 'alerts');
+        this.route(bp('/overview'), 'overview');
         // this.listenTo(this, 'route', function(route, params) {
         //     console.log('Route: %o; params: %o', route, params);
         // });
@@ -52,6 +54,14 @@ var Router = Backbone.Router.extend({
 
     counter: function() {
         Pubsub.trigger('counter:show');
+    },
+
+    alerts: function() {
+        Pubsub.trigger('alerts:show');
+    },
+
+    overview: function() {
+        Pubsub.trigger('overview:show');
     }
 });
 
@@ -0,0 +1,55 @@
+var _ = require('underscore');
+var $ = require('jquery');
+
+var AppState = require('../app_state');
+var Pubsub = require('../lib/pubsub');
+
+var BaseView = require('./base');
+
+var formatTime = function(ts) {
+    return ts ? new Date(ts * 1000).toLocaleString() : '';
+};
+
+var formatAlert = function(a) {
+    return _.extend({}, a, {
+        'subject': a['node'] || (a['topic'] + '/' + a['channel']),
+        'firing': a['state'] === 'firing',
+        'value': +a['value'].toFixed(2),
+        'since': formatTime(a['since']),
+        'fired_at': formatTime(a['fired_at']),
+        'resolved_at': formatTime(a['resolved_at'])
+    });
+};
+
+var AlertsView = BaseView.extend({
+    className: 'alerts container-fluid',
+
+    template: require('./spinner.hbs'),
+
+    initialize: function() {
+        BaseView.prototype.initialize.apply(this, arguments);
+        this.fetch();
+    },
+
+    remove: function() {
+        clearTimeout(this.poller);
+        BaseView.prototype.remove.apply(this, arguments);
+    },
+
+    fetch: function() {
+        $.ajax(AppState.apiPath('/alerts'))
+            .done(function(data) {
+                this.template = require('./alerts.hbs');
+                this.render({
+                    'rules': data['rules'],
+                    'alerts': _.map(data['alerts'], formatAlert),
+                    'resolved': _.map(data['resolved'], formatAlert)
+                });
+                this.poller = setTimeout(this.fetch.bind(this), data['interval'] * 1000);
+            }.bind(this))
+            .fail(this.handleViewError.bind(this))
+            .always(Pubsub.trigger.bind(Pubsub, 'view:ready'));
+    }
+});
+
+module.exports = AlertsView;
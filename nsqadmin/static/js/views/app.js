@@ -17,6 +17,8 @@ var LookupView = require('./lookup');
 var NodesView = require('./nodes');
 var NodeView = require('./node');
 var CounterView = require('./counter');
+var AlertsView = require('./alerts');
+var OverviewView = require('./overview');
 
 var Node = require('../models/node'); //eslint-disable-line no-undef
 var Topic = require('../models/topic');
@@ -42,6 +44,8 @@ var AppView = BaseView.extend({
         this.listenTo(Pubsub, 'nodes:show', this.showNodes);
         this.listenTo(Pubsub, 'node:show', this.showNode);
         this.listenTo(Pubsub, 'counter:show', this.showCounter);
+        this.listenTo(Pubsub, 'alerts:show', this.showAlerts);
+        this.listenTo(Pubsub, 'overview:show', this.showOverview);
 
         this.listenTo(Pubsub, 'view:ready', function() {
             $('.rate').each(function(i, el) {
@@ -137,6 +141,18 @@ var AppView = BaseView.extend({
         });
     },
 
+    showAlerts: function() {
+        this.showView(function() {
+            return new AlertsView();
+        });
+    },
+
+    showOverview: function() {
+        this.showView(function() {
+            return new OverviewView();
+        });
+    },
+
     onLinkClick: function(e) {
         if (e.ctrlKey || e.metaKey) {
             // allow ctrl+click to open in a new tab
@@ -50,6 +50,9 @@ var BaseView = Backbone.View.extend({
             'graph_interval': AppState.get('graph_interval'),
             'graph_active': AppState.get('GRAPH_ENABLED') &&
                 AppState.get('graph_interval') !== 'off',
+            'history_enabled': AppState.get('HISTORY_ENABLED'),
+            'history_active': AppState.get('HISTORY_ENABLED') &&
+                AppState.get('graph_interval') !== 'off',
             'nsqlookupd': AppState.get('NSQLOOKUPD'),
             'version': AppState.get('VERSION')
         };
@@ -4,18 +4,45 @@ window.jQuery = $;
 var bootstrap = require('bootstrap'); //eslint-disable-line no-unused-vars
 var bootbox = require('bootbox');
 
+var HistoryChart = require('../lib/history_chart');
 var Pubsub = require('../lib/pubsub');
 var AppState = require('../app_state');
 
 var BaseView = require('./base');
 
+var peekedTemplate = require('./peeked_messages.hbs');
+var lagTemplate = require('./lag.hbs');
+
+// the rates of the lag are computed from successive samples, so it is polled
+var lagPollInterval = 5000;
+
+// decodeBody returns the (base64 encoded) body of a message as UTF-8 text
+var decodeBody = function(body) {
+    var raw = atob(body || '');
+    var bytes = new Uint8Array(raw.length);
+    for (var i = 0; i < raw.length; i++) {
+        bytes[i] = raw.charCodeAt(i);
+    }
+    return new TextDecoder('utf-8').decode(bytes);
+};
+
+var prettyJSON = function(text) {
+    try {
+        return JSON.stringify(JSON.parse(text), null, 2);
+    } catch (err) {
+        return text;
+    }
+};
+
 var ChannelView = BaseView.extend({
     className: 'channel container-fluid',
 
     template: require('./spinner.hbs'),
 
     events: {
-        'click .channel-actions button': 'channelAction'
+        'click .channel-actions button': 'channelAction',
+        'submit .peek-messages': 'peekMessages',
+        'click .peek-download': 'downloadMessages'
     },
 
     initialize: function() {
@@ -26,11 +53,37 @@ var ChannelView = BaseView.extend({
             .done(function(data) {
                 this.template = require('./channel.hbs');
                 this.render({'message': data['message'], 'isAdmin': isAdmin});
+                this.lagPoller = setTimeout(this.fetchLag.bind(this), lagPollInterval);
             }.bind(this))
             .fail(this.handleViewError.bind(this))
             .always(Pubsub.trigger.bind(Pubsub, 'view:ready'));
     },
 
+    remove: function() {
+        clearTimeout(this.lagPoller);
+        BaseView.prototype.remove.apply(this, arguments);
+    },
+
+    fetchLag: function() {
+        $.get(AppState.apiPath('/lag'), {'topic': this.model.get('topic'), 'channel': this.model.get('name')})
+            .done(function(data) {
+                if (data['channels'].length) {
+                    this.$('.channel-lag').html(lagTemplate(data['channels'][0]));
+                }
+                this.lagPoller = setTimeout(this.fetchLag.bind(this), lagPollInterval);
+            }.bind(this));
+    },
+
+    postRender: function(ctx) {
+        if (!ctx['history_active'] || !ctx['nodes']) {
+            return;
+        }
+        var url = AppState.apiPath('/history/topics/' +
+            encodeURIComponent(this.model.get('topic')) + '/' +
+            encodeURIComponent(this.model.get('name')));
+        HistoryChart.render(this.$('.history-charts'), url, ctx['graph_interval'], true);
+    },
+
     channelAction: function(e) {
         e.preventDefault();
         e.stopPropagation();
@@ -56,6 +109,54 @@ var ChannelView = BaseView.extend({
                     .fail(this.handleAJAXError.bind(this));
             }
         }.bind(this));
+    },
+
+    peekMessages: function(e) {
+        e.preventDefault();
+        e.stopPropagation();
+        var form = $(e.currentTarget);
+        var pretty = form.find('[name=pretty]').is(':checked');
+        var url = AppState.apiPath('/messages/' +
+            encodeURIComponent(this.model.get('topic')) + '/' +
+            encodeURIComponent(this.model.get('name')));
+        $.get(url, {'node': form.find('[name=node]').val(), 'count': form.find('[name=count]').val()})
+            .done(function(data) {
+                var messages = data['messages'].map(function(m) {
+                    var text = decodeBody(m['body']);
+                    return $.extend({}, m, {
+                        'text': pretty ? prettyJSON(text) : text,
+                        'time': new Date(m['timestamp'] / 1e6).toISOString()
+                    });
+                });
+                this.peeked = messages;
+                this.$('.peeked-messages').html(peekedTemplate({
+                    'messages': messages,
+                    'in_memory': data['in_memory']
+                }));
+                this.$('.peek-download').prop('disabled', messages.length === 0);
+            }.bind(this))
+            .fail(this.handleAJAXError.bind(this));
+    },
+
+    downloadMessages: function(e) {
+        e.preventDefault();
+        var data = (this.peeked || []).map(function(m) {
+            return {
+                'node': m['node'],
+                'id': m['id'],
+                'timestamp': m['timestamp'],
+                'attempts': m['attempts'],
+                'body': decodeBody(m['body'])
+            };
+        });
+        var blob = new Blob([JSON.stringify(data, null, 2)], {'type': 'application/json'});
+        var a = document.createElement('a');
+        a.href = URL.createObjectURL(blob);
+        a.download = this.model.get('topic') + '-' + this.model.get('name') + '-messages.json';
+        document.body.appendChild(a);
+        a.click();
+        document.body.removeChild(a);
+        URL.revokeObjectURL(a.href);
     }
 });
 
@@ -11,7 +11,8 @@ var HeaderView = BaseView.extend({
     template: require('./header.hbs'),
 
     events: {
-        'click .dropdown-menu li': 'onGraphIntervalClick'
+        'click .graph-intervals li': 'onGraphIntervalClick',
+        'click .clusters li': 'onClusterClick'
     },
 
     initialize: function() {
@@ -22,7 +23,14 @@ var HeaderView = BaseView.extend({
     getRenderCtx: function() {
         return _.extend(BaseView.prototype.getRenderCtx.apply(this, arguments), {
             'graph_intervals': ['1h', '2h', '12h', '24h', '48h', '168h', 'off'],
-            'graph_interval': AppState.get('graph_interval')
+            'graph_or_history_enabled': AppState.get('GRAPH_ENABLED') ||
+                AppState.get('HISTORY_ENABLED'),
+            'alerts_enabled': AppState.get('ALERTS_ENABLED'),
+            'user': AppState.get('USER'),
+            'logout_enabled': AppState.get('LOGOUT_ENABLED'),
+            'graph_interval': AppState.get('graph_interval'),
+            'clusters': _.pluck(AppState.get('CLUSTERS'), 'name'),
+            'cluster': AppState.get('cluster')
         });
     },
 
@@ -34,6 +42,12 @@ var HeaderView = BaseView.extend({
     onGraphIntervalClick: function(e) {
         e.stopPropagation();
         AppState.set('graph_interval', $(e.target).text());
+    },
+
+    onClusterClick: function(e) {
+        e.preventDefault();
+        e.stopPropagation();
+        AppState.selectCluster($(e.target).text());
     }
 });
 
@@ -0,0 +1,60 @@
+var _ = require('underscore');
+var $ = require('jquery');
+
+var AppState = require('../app_state');
+var Pubsub = require('../lib/pubsub');
+
+var BaseView = require('./base');
+
+var healthClasses = {
+    'ok': 'success',
+    'degraded': 'warning',
+    'unreachable': 'danger'
+};
+
+var OverviewView = BaseView.extend({
+    className: 'overview container-fluid',
+
+    template: require('./spinner.hbs'),
+
+    events: {
+        'click .cluster-link': 'onClusterClick'
+    },
+
+    initialize: function() {
+        BaseView.prototype.initialize.apply(this, arguments);
+        this.fetch();
+    },
+
+    remove: function() {
+        clearTimeout(this.poller);
+        BaseView.prototype.remove.apply(this, arguments);
+    },
+
+    fetch: function() {
+        // not apiPath, the overview is of every cluster
+        $.ajax(AppState.basePath('/api/clusters'))
+            .done(function(data) {
+                this.template = require('./overview.hbs');
+                this.render({
+                    'clusters': _.map(data['clusters'], function(c) {
+                        return _.extend({}, c, {
+                            'health_class': healthClasses[c['health']],
+                            'selected': c['name'] === AppState.get('cluster')
+                        });
+                    })
+                });
+                this.poller = setTimeout(this.fetch.bind(this), 10000);
+            }.bind(this))
+            .fail(this.handleViewError.bind(this))
+            .always(Pubsub.trigger.bind(Pubsub, 'view:ready'));
+    },
+
+    onClusterClick: function(e) {
+        e.preventDefault();
+        e.stopPropagation();
+        AppState.selectCluster($(e.currentTarget).data('cluster'));
+    }
+});
+
+module.exports = OverviewView;
@@ -4,6 +4,7 @@ window.jQuery = $;
 var bootstrap = require('bootstrap'); //eslint-disable-line no-unused-vars
 var bootbox = require('bootbox');
 
+var HistoryChart = require('../lib/history_chart');
 var Pubsub = require('../lib/pubsub');
 var AppState = require('../app_state');
 
@@ -15,7 +16,8 @@ var TopicView = BaseView.extend({
     template: require('./spinner.hbs'),
 
     events: {
-        'click .topic-actions button': 'topicAction'
+        'click .topic-actions button': 'topicAction',
+        'submit .publish-message': 'publishMessage'
     },
 
     initialize: function() {
@@ -31,6 +33,14 @@ var TopicView = BaseView.extend({
             .always(Pubsub.trigger.bind(Pubsub, 'view:ready'));
     },
 
+    postRender: function(ctx) {
+        if (!ctx['history_active'] || !ctx['nodes']) {
+            return;
+        }
+        var url = AppState.apiPath('/history/topics/' + encodeURIComponent(this.model.get('name')));
+        HistoryChart.render(this.$('.history-charts'), url, ctx['graph_interval'], false);
+    },
+
     topicAction: function(e) {
         e.preventDefault();
         e.stopPropagation();
@@ -50,6 +60,22 @@ var TopicView = BaseView.extend({
                     .fail(this.handleAJAXError.bind(this));
             }
         }.bind(this));
+    },
+
+    publishMessage: function(e) {
+        e.preventDefault();
+        e.stopPropagation();
+        var form = $(e.currentTarget);
+        var url = AppState.apiPath('/messages/' + encodeURIComponent(this.model.get('name')));
+        $.post(url, JSON.stringify({
+            'node': form.find('[name=node]').val(),
+            'body': form.find('[name=body]').val()
+        }))
+            .done(function(data) {
+                $('#error').hide();
+                form.find('.publish-result').text('published to ' + data['node']);
+            })
+            .fail(this.handleAJAXError.bind(this));
     }
 });
 
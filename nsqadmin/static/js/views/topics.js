@@ -1,14 +1,29 @@
+var _ = require('underscore');
+var $ = require('jquery');
+
+window.jQuery = $;
+var bootstrap = require('bootstrap'); //eslint-disable-line no-unused-vars
+var bootbox = require('bootbox');
+
 var Pubsub = require('../lib/pubsub');
 var AppState = require('../app_state');
 
 var BaseView = require('./base');
 var Topics = require('../collections/topics');
 
+var bulkTargetsTemplate = require('./bulk_targets.hbs');
+
 var TopicsView = BaseView.extend({
     className: 'topics container-fluid',
 
     template: require('./spinner.hbs'),
 
+    events: {
+        'click .sort-topics': 'sortTopics',
+        'submit .bulk-action': 'previewBulkAction',
+        'click .bulk-apply': 'applyBulkAction'
+    },
+
     initialize: function() {
         BaseView.prototype.initialize.apply(this, arguments);
         this.listenTo(AppState, 'change:graph_interval', this.render);
@@ -16,10 +31,95 @@ var TopicsView = BaseView.extend({
         this.collection.fetch()
             .done(function(data) {
                 this.template = require('./topics.hbs');
-                this.render({'message': data['message']});
+                this.renderData = {'message': data['message'], 'isAdmin': AppState.get('IS_ADMIN')};
+                this.render(this.renderData);
+                this.fetchLag();
             }.bind(this))
             .fail(this.handleViewError.bind(this))
             .always(Pubsub.trigger.bind(Pubsub, 'view:ready'));
+    },
+
+    // fetchLag adds the total depth, the oldest message and the longest time
+    // to drain of the channels of each topic
+    fetchLag: function() {
+        $.get(AppState.apiPath('/lag'))
+            .done(function(data) {
+                var byTopic = _.groupBy(data['channels'], 'topic_name');
+                this.collection.each(function(topic) {
+                    var lag = {'depth': 0, 'oldest_message_age': 0, 'drain_eta': null};
+                    _.each(byTopic[topic.get('name')], function(c) {
+                        lag['depth'] += c['depth'];
+                        lag['oldest_message_age'] = Math.max(lag['oldest_message_age'], c['oldest_message_age']);
+                        if (!c['window'] || lag['drain_eta'] === -1) {
+                            return;
+                        }
+                        if (c['drain_eta'] === -1 || lag['drain_eta'] === null) {
+                            lag['drain_eta'] = c['drain_eta'];
+                        } else {
+                            lag['drain_eta'] = Math.max(lag['drain_eta'], c['drain_eta']);
+                        }
+                    });
+                    topic.set(lag);
+                });
+                this.renderData['lag_loaded'] = true;
+                this.render(this.renderData);
+            }.bind(this));
+    },
+
+    sortTopics: function(e) {
+        e.preventDefault();
+        var key = $(e.currentTarget).data('sort');
+        if (key === 'name') {
+            this.collection.comparator = 'name';
+        } else {
+            // the most behind first, "never" draining is the most behind
+            this.collection.comparator = function(t) {
+                var v = t.get(key);
+                if (key === 'drain_eta' && v === -1) {
+                    return -Infinity;
+                }
+                return -(v || 0);
+            };
+        }
+        this.collection.sort();
+        this.render(this.renderData);
+    },
+
+    bulkAction: function(req) {
+        return $.post(AppState.apiPath('/bulk'), JSON.stringify(req))
+            .done(function(data) {
+                $('#error').hide();
+                this.$('.bulk-targets').html(bulkTargetsTemplate($.extend({}, data, req)));
+            }.bind(this))
+            .fail(this.handleAJAXError.bind(this));
+    },
+
+    previewBulkAction: function(e) {
+        e.preventDefault();
+        e.stopPropagation();
+        var form = $(e.currentTarget);
+        // applied as previewed, even when the form is edited in between
+        this.bulkRequest = {
+            'action': form.find('[name=action]').val(),
+            'topic': form.find('[name=topic]').val(),
+            'channel': form.find('[name=channel]').val(),
+            'selector': form.find('[name=selector]').val()
+        };
+        this.bulkAction($.extend({'dry_run': true}, this.bulkRequest));
+    },
+
+    applyBulkAction: function(e) {
+        e.preventDefault();
+        e.stopPropagation();
+        var req = this.bulkRequest;
+        var txt = 'Are you sure you want to <strong>' + req['action'] +
+            '</strong> everything listed?';
+        bootbox.confirm(txt, function(result) {
+            if (result !== true) {
+                return;
+            }
+            this.bulkAction($.extend({'dry_run': false}, req));
+        }.bind(this));
     }
 });
 
@@ -0,0 +1,452 @@
+package nsqd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Records written to the backend are either a plain message (see decodeMessage)
+// or an envelope around it:
+//
+//	[0xff][flags][payload...]
+//
+// A plain message starts with its big-endian nanosecond timestamp so its first
+// byte is never 0xff and both kinds of records can be mixed in a queue.
+//
+// When flags has recordPartitionKey set the message is prefixed with its
+// partition key
+//
+//	[key length (uint16)][key][message...]
+//
+// and when flags has recordReplyHeaders set the message (after the partition
+// key, if any) is prefixed with its reply headers
+//
+//	[reply to length (uint16)][reply to][correlation ID length (uint16)][correlation ID]
+//
+// This is compressed with the codec in bits 1-2 of flags, if any, and
+// then, when flags has recordEncrypted set, the payload is
+//
+//	[key ID (uint32)][nonce (12 bytes)][AES-256-GCM sealed (compressed) message]
+//
+// and the envelope header (marker, flags and key ID) is authenticated as well.
+const (
+	recordEnvelope        = 0xff
+	recordEncrypted       = 1 << 0
+	recordCompressionMask = 3 << 1
+	recordSnappy          = 1 << 1
+	recordZstd            = 2 << 1
+	recordPartitionKey    = 1 << 3
+	recordReplyHeaders    = 1 << 4
+
+	encryptionKeySize = 32
+	recordNonceSize   = 12
+	recordTagSize     = 16
+
+	// maxRecordOverhead is the maximum number of bytes an envelope adds to a
+	// message, it is accounted for in the max message size of the diskqueues
+	maxRecordOverhead = 2 + 4 + recordNonceSize + recordTagSize + 2 + maxPartitionKeyLength +
+		2 + maxReplyToLength + 2 + maxCorrelationIDLength
+)
+
+// keyRing holds the keys used to encrypt backend records, the key with the
+// highest ID is used to encrypt and all of them are used to decrypt
+type keyRing struct {
+	activeID uint32
+	aeads    map[uint32]cipher.AEAD
+}
+
+// loadKeyRing reads an encryption key file, each line of which is
+//
+//	<key ID>:<base64 encoded 32 byte key>
+//
+// blank lines and lines starting with # are ignored. To rotate keys, append a
+// key with a higher ID and keep the old ones for as long as records encrypted
+// with them may remain on disk.
+func loadKeyRing(fn string) (*keyRing, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	kr := &keyRing{aeads: make(map[uint32]cipher.AEAD)}
+	scanner := bufio.NewScanner(f)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected <key ID>:<base64 key>", fn, lineNum)
+		}
+		id, err := strconv.ParseUint(strings.TrimSpace(parts[0]), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid key ID - %s", fn, lineNum, err)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid key - %s", fn, lineNum, err)
+		}
+		if len(key) != encryptionKeySize {
+			return nil, fmt.Errorf("%s:%d: key must be %d bytes", fn, lineNum, encryptionKeySize)
+		}
+		if _, ok := kr.aeads[uint32(id)]; ok {
+			return nil, fmt.Errorf("%s:%d: duplicate key ID %d", fn, lineNum, id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		kr.aeads[uint32(id)] = aead
+		if len(kr.aeads) == 1 || uint32(id) > kr.activeID {
+			kr.activeID = uint32(id)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(kr.aeads) == 0 {
+		return nil, fmt.Errorf("%s: no keys found", fn)
+	}
+	return kr, nil
+}
+
+// zstdEncoder compresses the records of all backends, EncodeAll is safe for
+// concurrent use and runs on a pool of one encoder per CPU
+var zstdEncoder *zstd.Encoder
+
+func init() {
+	var err error
+	zstdEncoder, err = zstd.NewWriter(nil,
+		zstd.WithEncoderConcurrency(runtime.GOMAXPROCS(0)),
+		zstd.WithLowerEncoderMem(true))
+	if err != nil {
+		panic(fmt.Sprintf("failed to create zstd encoder - %s", err))
+	}
+}
+
+// parseBackendCompression returns the record flags for a --backend-compression
+// value
+func parseBackendCompression(s string) (byte, error) {
+	switch s {
+	case "", "none":
+		return 0, nil
+	case "snappy":
+		return recordSnappy, nil
+	case "zstd":
+		return recordZstd, nil
+	}
+	return 0, fmt.Errorf("invalid backend compression %q (none, snappy or zstd)", s)
+}
+
+// parseTopicBackendCompression parses --topic-backend-compression values of the
+// form <topic>:<compression>
+func parseTopicBackendCompression(values []string) (map[string]byte, error) {
+	m := make(map[string]byte)
+	for _, v := range values {
+		i := strings.LastIndex(v, ":")
+		if i == -1 {
+			return nil, fmt.Errorf("invalid topic backend compression %q (<topic>:<compression>)", v)
+		}
+		compression, err := parseBackendCompression(v[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		m[v[:i]] = compression
+	}
+	return m, nil
+}
+
+// backendCodec converts messages to and from backend records
+type backendCodec struct {
+	// 64bit atomic vars need to be first for proper alignment on 32bit platforms
+	rawBytes        uint64
+	compressedBytes uint64
+
+	keys        *keyRing // records are encrypted when set
+	compression byte
+
+	// the max size of a decompressed record, so that a corrupt record cannot
+	// make nsqd allocate unbounded memory
+	maxPlainSize uint64
+	zstdDecoder  *zstd.Decoder
+}
+
+func newBackendCodec(opts *Options) (*backendCodec, error) {
+	maxPlainSize := uint64(opts.MaxMsgSize + minValidMsgLength + maxRecordOverhead)
+	zd, err := zstd.NewReader(nil,
+		zstd.WithDecoderConcurrency(0),
+		zstd.WithDecoderMaxMemory(maxPlainSize))
+	if err != nil {
+		return nil, err
+	}
+	c := &backendCodec{
+		maxPlainSize: maxPlainSize,
+		zstdDecoder:  zd,
+	}
+	if opts.EncryptionKeyFile != "" {
+		keys, err := loadKeyRing(opts.EncryptionKeyFile)
+		if err != nil {
+			zd.Close()
+			return nil, err
+		}
+		c.keys = keys
+	}
+	return c, nil
+}
+
+// close releases the zstd decoder (and its goroutines), shared with the
+// codecs returned by withCompression
+func (c *backendCodec) close() {
+	c.zstdDecoder.Close()
+}
+
+// withCompression returns a new codec that shares the keys of c
+func (c *backendCodec) withCompression(compression byte) *backendCodec {
+	return &backendCodec{
+		keys:         c.keys,
+		compression:  compression,
+		maxPlainSize: c.maxPlainSize,
+		zstdDecoder:  c.zstdDecoder,
+	}
+}
+
+// CompressionRatio returns the ratio of message bytes to the bytes
+// written after compression, or 0 if nothing was compressed yet
+func (c *backendCodec) CompressionRatio() float64 {
+	compressed := atomic.LoadUint64(&c.compressedBytes)
+	if compressed == 0 {
+		return 0
+	}
+	return float64(atomic.LoadUint64(&c.rawBytes)) / float64(compressed)
+}
+
+func (c *backendCodec) compress(b []byte) []byte {
+	var compressed []byte
+	switch c.compression {
+	case recordSnappy:
+		compressed = snappy.Encode(nil, b)
+	case recordZstd:
+		compressed = zstdEncoder.EncodeAll(b, nil)
+	}
+	atomic.AddUint64(&c.rawBytes, uint64(len(b)))
+	if len(compressed) >= len(b) {
+		// incompressible, store the message as is so that a record never
+		// grows more than maxRecordOverhead
+		atomic.AddUint64(&c.compressedBytes, uint64(len(b)))
+		return nil
+	}
+	atomic.AddUint64(&c.compressedBytes, uint64(len(compressed)))
+	return compressed
+}
+
+// encode serializes msg into buf (which is used as scratch space) and returns
+// the record to write to the backend
+func (c *backendCodec) encode(msg *Message, buf *bytes.Buffer) ([]byte, error) {
+	var flags byte
+	if msg.PartitionKey != "" {
+		flags |= recordPartitionKey
+		writeRecordString(buf, msg.PartitionKey)
+	}
+	if msg.ReplyTo != "" || msg.CorrelationID != "" {
+		flags |= recordReplyHeaders
+		writeRecordString(buf, msg.ReplyTo)
+		writeRecordString(buf, msg.CorrelationID)
+	}
+	_, err := msg.WriteTo(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	payload := buf.Bytes()
+	if c == nil {
+		if flags == 0 {
+			return payload, nil
+		}
+		return envelope(flags, payload), nil
+	}
+
+	if c.compression != 0 {
+		if compressed := c.compress(payload); compressed != nil {
+			flags |= c.compression
+			payload = compressed
+		}
+	}
+
+	if c.keys == nil {
+		if flags == 0 {
+			return payload, nil
+		}
+		return envelope(flags, payload), nil
+	}
+
+	aead := c.keys.aeads[c.keys.activeID]
+	record := make([]byte, 6+recordNonceSize, 6+recordNonceSize+len(payload)+aead.Overhead())
+	record[0] = recordEnvelope
+	record[1] = flags | recordEncrypted
+	binary.BigEndian.PutUint32(record[2:6], c.keys.activeID)
+	nonce := record[6 : 6+recordNonceSize]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(record, nonce, payload, record[:6]), nil
+}
+
+func envelope(flags byte, payload []byte) []byte {
+	record := make([]byte, 2, 2+len(payload))
+	record[0] = recordEnvelope
+	record[1] = flags
+	return append(record, payload...)
+}
+
+func writeRecordString(buf *bytes.Buffer, s string) {
+	var l [2]byte
+	binary.BigEndian.PutUint16(l[:], uint16(len(s)))
+	buf.Write(l[:])
+	buf.WriteString(s)
+}
+
+// readRecordString reads a length prefixed string written by writeRecordString
+// and returns it along with the remainder of b
+func readRecordString(b []byte, name string) (string, []byte, error) {
+	if len(b) < 2 {
+		return "", nil, fmt.Errorf("invalid %s length", name)
+	}
+	l := int(binary.BigEndian.Uint16(b[:2]))
+	if len(b) < 2+l {
+		return "", nil, fmt.Errorf("invalid %s length (%d)", name, l)
+	}
+	return string(b[2 : 2+l]), b[2+l:], nil
+}
+
+// decodePlainRecord deserializes a decrypted and decompressed record
+func decodePlainRecord(flags byte, b []byte) (*Message, error) {
+	var partitionKey, replyTo, correlationID string
+	var err error
+	if flags&recordPartitionKey != 0 {
+		partitionKey, b, err = readRecordString(b, "partition key")
+		if err != nil {
+			return nil, err
+		}
+	}
+	if flags&recordReplyHeaders != 0 {
+		replyTo, b, err = readRecordString(b, "reply to")
+		if err != nil {
+			return nil, err
+		}
+		correlationID, b, err = readRecordString(b, "correlation ID")
+		if err != nil {
+			return nil, err
+		}
+	}
+	msg, err := decodeMessage(b)
+	if err != nil {
+		return nil, err
+	}
+	msg.PartitionKey = partitionKey
+	msg.ReplyTo = replyTo
+	msg.CorrelationID = correlationID
+	return msg, nil
+}
+
+func (c *backendCodec) decompress(flags byte, b []byte) ([]byte, error) {
+	compression := flags & recordCompressionMask
+	if compression == 0 {
+		return b, nil
+	}
+	if c == nil || c.zstdDecoder == nil {
+		return nil, errors.New("compressed record without a codec")
+	}
+	switch compression {
+	case recordSnappy:
+		n, err := snappy.DecodedLen(b)
+		if err != nil {
+			return nil, err
+		}
+		if uint64(n) > c.maxPlainSize {
+			return nil, fmt.Errorf("decompressed size %d exceeds %d", n, c.maxPlainSize)
+		}
+		return snappy.Decode(nil, b)
+	case recordZstd:
+		return c.zstdDecoder.DecodeAll(b, nil)
+	}
+	return nil, fmt.Errorf("unknown record compression (%#x)", flags&recordCompressionMask)
+}
+
+// decode deserializes a backend record written by encode
+func (c *backendCodec) decode(b []byte) (*Message, error) {
+	if len(b) == 0 || b[0] != recordEnvelope {
+		return decodeMessage(b)
+	}
+	if len(b) < 2 {
+		return nil, errors.New("invalid record envelope")
+	}
+
+	flags := b[1]
+	if flags&^(recordEncrypted|recordCompressionMask|recordPartitionKey|recordReplyHeaders) != 0 {
+		return nil, fmt.Errorf("unknown record flags (%#x)", flags)
+	}
+	if flags&recordEncrypted == 0 {
+		plain, err := c.decompress(flags, b[2:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress record - %s", err)
+		}
+		return decodePlainRecord(flags, plain)
+	}
+
+	if len(b) < 6+recordNonceSize+recordTagSize {
+		return nil, fmt.Errorf("invalid encrypted record size (%d)", len(b))
+	}
+	keyID := binary.BigEndian.Uint32(b[2:6])
+	if c == nil || c.keys == nil {
+		return nil, fmt.Errorf("record encrypted with key %d but no --encryption-key-file", keyID)
+	}
+	aead, ok := c.keys.aeads[keyID]
+	if !ok {
+		return nil, fmt.Errorf("record encrypted with unknown key %d", keyID)
+	}
+	nonce := b[6 : 6+recordNonceSize]
+	plain, err := aead.Open(nil, nonce, b[6+recordNonceSize:], b[:6])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt record - %s", err)
+	}
+	plain, err = c.decompress(flags, plain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress record - %s", err)
+	}
+	return decodePlainRecord(flags, plain)
+}
+
+// newBackendCodec returns the codec for the backend of a topic (or one of its
+// channels), which compresses with the --topic-backend-compression of the topic
+// or else --backend-compression
+func (n *NSQD) newBackendCodec(topicName string) *backendCodec {
+	compression, ok := n.topicBackendCompression[topicName]
+	if !ok {
+		compression = n.backendCompression
+	}
+	return n.backendCodec.withCompression(compression)
+}
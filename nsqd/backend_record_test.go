@@ -0,0 +1,347 @@
+package nsqd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nsqio/nsq/internal/test"
+)
+
+func writeTestKeyFile(t *testing.T, fn string, ids ...uint32) {
+	var buf bytes.Buffer
+	buf.WriteString("# test keys\n")
+	for _, id := range ids {
+		key := make([]byte, encryptionKeySize)
+		// derive the key from the ID so that a file can be rewritten with
+		// additional keys without changing the existing ones
+		for i := range key {
+			key[i] = byte(id) + byte(i)
+		}
+		fmt.Fprintf(&buf, "%d:%s\n", id, base64.StdEncoding.EncodeToString(key))
+	}
+	err := os.WriteFile(fn, buf.Bytes(), 0600)
+	test.Nil(t, err)
+}
+
+func TestBackendCodecEncryption(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "nsq-test-")
+	test.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	fn := filepath.Join(tmpDir, "keys")
+	writeTestKeyFile(t, fn, 1)
+	opts := NewOptions()
+	opts.EncryptionKeyFile = fn
+	oldCodec, err := newBackendCodec(opts)
+	test.Nil(t, err)
+	defer oldCodec.close()
+
+	body := []byte("secret message body")
+	msg := NewMessage(MessageID{'0', '1'}, body)
+	oldRecord, err := oldCodec.encode(msg, &bytes.Buffer{})
+	test.Nil(t, err)
+	test.Equal(t, false, bytes.Contains(oldRecord, body))
+	test.Equal(t, true, len(oldRecord) <= minValidMsgLength+len(body)+maxRecordOverhead)
+
+	// rotate, records encrypted with the old key remain readable
+	writeTestKeyFile(t, fn, 1, 2)
+	codec, err := newBackendCodec(opts)
+	test.Nil(t, err)
+	defer codec.close()
+	test.Equal(t, uint32(2), codec.keys.activeID)
+
+	record, err := codec.encode(msg, &bytes.Buffer{})
+	test.Nil(t, err)
+	for _, r := range [][]byte{oldRecord, record} {
+		msgOut, err := codec.decode(r)
+		test.Nil(t, err)
+		test.Equal(t, msg.ID, msgOut.ID)
+		test.Equal(t, msg.Timestamp, msgOut.Timestamp)
+		test.Equal(t, body, msgOut.Body)
+	}
+
+	// the old key cannot read records encrypted with the new one
+	_, err = oldCodec.decode(record)
+	test.NotNil(t, err)
+
+	// plain records remain readable
+	plain, err := (*backendCodec)(nil).encode(msg, &bytes.Buffer{})
+	test.Nil(t, err)
+	msgOut, err := codec.decode(plain)
+	test.Nil(t, err)
+	test.Equal(t, body, msgOut.Body)
+
+	// tampering is detected
+	record[len(record)-1] ^= 0xff
+	_, err = codec.decode(record)
+	test.NotNil(t, err)
+}
+
+func TestLoadKeyRingErrors(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "nsq-test-")
+	test.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	key := make([]byte, encryptionKeySize)
+	rand.Read(key)
+	validKey := base64.StdEncoding.EncodeToString(key)
+
+	for _, data := range []string{
+		"",
+		"# only a comment\n",
+		"1\n",
+		"x:" + validKey + "\n",
+		"1:not base64\n",
+		"1:" + base64.StdEncoding.EncodeToString(key[:16]) + "\n",
+		"1:" + validKey + "\n1:" + validKey + "\n",
+	} {
+		fn := filepath.Join(tmpDir, "keys")
+		err := os.WriteFile(fn, []byte(data), 0600)
+		test.Nil(t, err)
+		_, err = loadKeyRing(fn)
+		test.NotNil(t, err)
+	}
+}
+
+func TestEncryptedBackend(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.MemQueueSize = 0
+	tmpDir, err := os.MkdirTemp("", "nsq-test-")
+	test.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+	opts.DataPath = tmpDir
+	opts.EncryptionKeyFile = filepath.Join(tmpDir, "keys")
+	writeTestKeyFile(t, opts.EncryptionKeyFile, 1)
+
+	body := []byte("secret message body")
+	_, _, nsqd := mustStartNSQD(opts)
+	topic := nsqd.GetTopic("encrypted_test")
+	for i := 0; i < 5; i++ {
+		err := topic.PutMessage(NewMessage(topic.GenerateID(), body))
+		test.Nil(t, err)
+	}
+	nsqd.Exit()
+
+	data, err := os.ReadFile(queueFileName(tmpDir, "encrypted_test", 0))
+	test.Nil(t, err)
+	test.Equal(t, false, bytes.Contains(data, body))
+
+	report, err := CheckData(opts, false)
+	test.Nil(t, err)
+	test.Equal(t, true, report.OK())
+	test.Equal(t, int64(5), report.Queues[0].Messages)
+
+	writeTestKeyFile(t, opts.EncryptionKeyFile, 1, 2)
+	_, _, nsqd = mustStartNSQD(opts)
+	defer nsqd.Exit()
+	err = nsqd.LoadMetadata()
+	test.Nil(t, err)
+	topic, err = nsqd.GetExistingTopic("encrypted_test")
+	test.Nil(t, err)
+	channel := topic.GetChannel("ch")
+	for i := 0; i < 5; i++ {
+		b := <-channel.backend.ReadChan()
+		msg, err := nsqd.backendCodec.decode(b)
+		test.Nil(t, err)
+		test.Equal(t, body, msg.Body)
+	}
+}
+
+func TestBackendCodecCompression(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "nsq-test-")
+	test.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	opts := NewOptions()
+	opts.EncryptionKeyFile = filepath.Join(tmpDir, "keys")
+	writeTestKeyFile(t, opts.EncryptionKeyFile, 1)
+	keyed, err := newBackendCodec(opts)
+	test.Nil(t, err)
+	defer keyed.close()
+	unkeyed, err := newBackendCodec(NewOptions())
+	test.Nil(t, err)
+	defer unkeyed.close()
+
+	body := bytes.Repeat([]byte("compressible message body "), 100)
+	msg := NewMessage(MessageID{'0', '1'}, body)
+	plain, err := (*backendCodec)(nil).encode(msg, &bytes.Buffer{})
+	test.Nil(t, err)
+
+	for _, compression := range []string{"snappy", "zstd"} {
+		flags, err := parseBackendCompression(compression)
+		test.Nil(t, err)
+		for _, base := range []*backendCodec{unkeyed, keyed} {
+			codec := base.withCompression(flags)
+			record, err := codec.encode(msg, &bytes.Buffer{})
+			test.Nil(t, err)
+			test.Equal(t, recordEnvelope, int(record[0]))
+			test.Equal(t, flags, record[1]&recordCompressionMask)
+			test.Equal(t, true, len(record) < len(plain)/4)
+			test.Equal(t, true, codec.CompressionRatio() > 4)
+
+			// compressed and plain records can be mixed in a queue
+			for _, r := range [][]byte{record, plain} {
+				msgOut, err := codec.decode(r)
+				test.Nil(t, err)
+				test.Equal(t, msg.ID, msgOut.ID)
+				test.Equal(t, msg.Timestamp, msgOut.Timestamp)
+				test.Equal(t, body, msgOut.Body)
+			}
+		}
+	}
+
+	// records decompressing to more than a max size message are rejected
+	small := NewOptions()
+	small.MaxMsgSize = int64(len(body)) / 2
+	smallCodec, err := newBackendCodec(small)
+	test.Nil(t, err)
+	defer smallCodec.close()
+	for _, flags := range []byte{recordSnappy, recordZstd} {
+		record, err := unkeyed.withCompression(flags).encode(msg, &bytes.Buffer{})
+		test.Nil(t, err)
+		_, err = smallCodec.decode(record)
+		test.NotNil(t, err)
+	}
+
+	// incompressible messages are stored as is
+	codec := unkeyed.withCompression(recordZstd)
+	random := make([]byte, 256)
+	rand.Read(random)
+	record, err := codec.encode(NewMessage(MessageID{'0', '2'}, random), &bytes.Buffer{})
+	test.Nil(t, err)
+	test.NotEqual(t, recordEnvelope, int(record[0]))
+	test.Equal(t, 1.0, codec.CompressionRatio())
+
+	_, err = parseBackendCompression("gzip")
+	test.NotNil(t, err)
+	_, err = parseTopicBackendCompression([]string{"events"})
+	test.NotNil(t, err)
+	m, err := parseTopicBackendCompression([]string{"events:zstd", "metrics:none"})
+	test.Nil(t, err)
+	test.Equal(t, map[string]byte{"events": recordZstd, "metrics": 0}, m)
+}
+
+func TestCompressedBackend(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.MemQueueSize = 0
+	opts.BackendCompression = "snappy"
+	opts.TopicBackendCompression = []string{"zstd_test:zstd", "plain_test:none"}
+	_, _, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+
+	body := bytes.Repeat([]byte("compressible message body "), 100)
+	for _, name := range []string{"snappy_test", "zstd_test", "plain_test"} {
+		topic := nsqd.GetTopic(name)
+		for i := 0; i < 5; i++ {
+			err := topic.PutMessage(NewMessage(topic.GenerateID(), body))
+			test.Nil(t, err)
+		}
+		stats := NewTopicStats(topic, nil)
+		if name == "plain_test" {
+			test.Equal(t, 0.0, stats.BackendCompressionRatio)
+		} else {
+			test.Equal(t, true, stats.BackendCompressionRatio > 4)
+		}
+	}
+	nsqd.Exit()
+
+	report, err := CheckData(opts, false)
+	test.Nil(t, err)
+	test.Equal(t, true, report.OK())
+
+	// compression can be changed, existing records remain readable
+	opts.BackendCompression = "none"
+	opts.TopicBackendCompression = nil
+	_, _, nsqd = mustStartNSQD(opts)
+	defer nsqd.Exit()
+	err = nsqd.LoadMetadata()
+	test.Nil(t, err)
+	for _, name := range []string{"snappy_test", "zstd_test", "plain_test"} {
+		topic, err := nsqd.GetExistingTopic(name)
+		test.Nil(t, err)
+		channel := topic.GetChannel("ch")
+		topic.Start()
+		for i := 0; i < 5; i++ {
+			b := <-channel.backend.ReadChan()
+			msg, err := channel.codec.decode(b)
+			test.Nil(t, err)
+			test.Equal(t, body, msg.Body)
+		}
+	}
+}
+
+func TestBackendCodecPartitionKey(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "nsq-test-")
+	test.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	fn := filepath.Join(tmpDir, "keys")
+	writeTestKeyFile(t, fn, 1)
+	opts := NewOptions()
+	opts.EncryptionKeyFile = fn
+	encrypted, err := newBackendCodec(opts)
+	test.Nil(t, err)
+	defer encrypted.close()
+	unencrypted, err := newBackendCodec(NewOptions())
+	test.Nil(t, err)
+	defer unencrypted.close()
+
+	body := bytes.Repeat([]byte("partitioned message body "), 8)
+	msg := NewMessage(MessageID{'0', '3'}, body)
+	msg.PartitionKey = "customer-42"
+	for _, codec := range []*backendCodec{
+		nil,
+		unencrypted.withCompression(recordSnappy),
+		encrypted.withCompression(recordZstd),
+	} {
+		record, err := codec.encode(msg, &bytes.Buffer{})
+		test.Nil(t, err)
+		test.Equal(t, byte(recordEnvelope), record[0])
+		test.Equal(t, true, len(record) <= minValidMsgLength+len(body)+maxRecordOverhead)
+
+		msgOut, err := encrypted.decode(record)
+		test.Nil(t, err)
+		test.Equal(t, msg.ID, msgOut.ID)
+		test.Equal(t, body, msgOut.Body)
+		test.Equal(t, "customer-42", msgOut.PartitionKey)
+	}
+
+	_, err = encrypted.decode([]byte{recordEnvelope, recordPartitionKey, 0xff, 0xff})
+	test.NotNil(t, err)
+}
+
+func TestBackendCodecReplyHeaders(t *testing.T) {
+	body := []byte("request body")
+	msg := NewMessage(MessageID{'0', '4'}, body)
+	msg.PartitionKey = "customer-42"
+	msg.ReplyTo = "reply-1-2#ephemeral"
+	msg.CorrelationID = "req-1"
+	compressed, err := newBackendCodec(NewOptions())
+	test.Nil(t, err)
+	defer compressed.close()
+	for _, codec := range []*backendCodec{
+		nil,
+		compressed.withCompression(recordSnappy),
+	} {
+		record, err := codec.encode(msg, &bytes.Buffer{})
+		test.Nil(t, err)
+		test.Equal(t, byte(recordPartitionKey|recordReplyHeaders), record[1]&^recordCompressionMask)
+
+		msgOut, err := codec.decode(record)
+		test.Nil(t, err)
+		test.Equal(t, body, msgOut.Body)
+		test.Equal(t, "customer-42", msgOut.PartitionKey)
+		test.Equal(t, "reply-1-2#ephemeral", msgOut.ReplyTo)
+		test.Equal(t, "req-1", msgOut.CorrelationID)
+	}
+
+	_, err = (*backendCodec)(nil).decode([]byte{recordEnvelope, recordReplyHeaders, 0, 1})
+	test.NotNil(t, err)
+}
@@ -40,6 +40,19 @@ type Channel struct {
 	messageCount uint64
 	timeoutCount uint64
 
+	depthRejectCount uint64
+	depthDropCount   uint64
+
+	// the messages in the backend, see backendUsage
+	backendUsage backendUsage
+
+	// the timestamp of the message at the head of the queue, see
+	// OldestMessageTimestamp
+	headTimestamp int64
+
+	// messages held by the partition pump, see partitionPump
+	partitionPending int64
+
 	sync.RWMutex
 
 	topicName string
@@ -47,6 +60,7 @@ type Channel struct {
 	nsqd      *NSQD
 
 	backend BackendQueue
+	codec   *backendCodec
 
 	memoryMsgChan chan *Message
 	exitFlag      int32
@@ -57,9 +71,21 @@ type Channel struct {
 	paused         int32
 	ephemeral      bool
 	memQueueSize   int64
+	depthLimit     depthLimit
 	deleteCallback func(*Channel)
 	deleter        sync.Once
 
+	// partitioned delivery, the client ID each partition is assigned to (0
+	// when there are no clients) and the channel of each client
+	partitionMtx         sync.RWMutex
+	partitionOwners      []int64
+	partitionChans       map[int64]chan *Message
+	rebalanceChan        chan struct{}
+	partitionRequeueChan chan *Message
+	partitionEmptyChan   chan struct{}
+	partitionExitChan    chan struct{}
+	partitionDoneChan    chan struct{}
+
 	// Stats tracking
 	e2eProcessingLatencyStream *quantile.Quantile
 
@@ -84,12 +110,27 @@ func NewChannel(topicName string, channelName string, nsqd *NSQD,
 		deleteCallback: deleteCallback,
 		nsqd:           nsqd,
 		ephemeral:      strings.HasSuffix(channelName, "#ephemeral"),
+		codec:          nsqd.newBackendCodec(topicName),
+		depthLimit:     nsqd.channelDepthLimit(topicName, channelName),
 	}
 	// channels with a _ordered suffix have mem-queue size of 0
 	c.memQueueSize = nsqd.getOpts().MemQueueSize
 	if strings.HasSuffix(topicName, "_ordered") {
 		c.memQueueSize = 0
 	}
+	if partitions, ok := nsqd.channelPartitions[topicName+"/"+channelName]; ok {
+		c.partitionOwners = make([]int64, partitions)
+		c.partitionChans = make(map[int64]chan *Message)
+		c.rebalanceChan = make(chan struct{})
+		c.partitionRequeueChan = make(chan *Message)
+		c.partitionEmptyChan = make(chan struct{})
+		c.partitionExitChan = make(chan struct{})
+		c.partitionDoneChan = make(chan struct{})
+		// a single queue is consumed in order
+		if !c.ephemeral {
+			c.memQueueSize = 0
+		}
+	}
 	// avoid mem-queue if size == 0 for more consistent ordering
 	if c.memQueueSize > 0 || c.ephemeral {
 		c.memoryMsgChan = make(chan *Message, c.memQueueSize)
@@ -118,11 +159,16 @@ func NewChannel(topicName string, channelName string, nsqd *NSQD,
 			nsqd.getOpts().MaxBytesPerQueue,
 			nsqd.getOpts().MaxBytesPerFile,
 			int32(minValidMsgLength),
-			int32(nsqd.getOpts().MaxMsgSize)+minValidMsgLength,
+			int32(nsqd.getOpts().MaxMsgSize)+minValidMsgLength+maxRecordOverhead,
 			nsqd.getOpts().SyncEvery,
 			nsqd.getOpts().SyncTimeout,
 			dqLogf,
 		)
+		c.backendUsage = newBackendUsage(nsqd.getOpts(), backendName, c.backend)
+	}
+
+	if c.partitionOwners != nil {
+		go c.partitionPump()
 	}
 
 	c.nsqd.Notify(c, !c.ephemeral)
@@ -184,6 +230,11 @@ func (c *Channel) exit(deleted bool) error {
 	}
 	c.RUnlock()
 
+	if c.partitionOwners != nil {
+		close(c.partitionExitChan)
+		<-c.partitionDoneChan
+	}
+
 	if deleted {
 		// empty the queue (deletes the backend files, too)
 		c.Empty()
@@ -204,6 +255,13 @@ func (c *Channel) Empty() error {
 		client.Empty()
 	}
 
+	if c.partitionOwners != nil {
+		select {
+		case c.partitionEmptyChan <- struct{}{}:
+		case <-c.partitionDoneChan:
+		}
+	}
+
 	for {
 		select {
 		case <-c.memoryMsgChan:
@@ -213,6 +271,7 @@ func (c *Channel) Empty() error {
 	}
 
 finish:
+	c.backendUsage.reset()
 	return c.backend.Empty()
 }
 
@@ -227,7 +286,7 @@ func (c *Channel) flush() error {
 	for {
 		select {
 		case msg := <-c.memoryMsgChan:
-			err := writeMessageToBackend(msg, c.backend)
+			err := writeMessageToBackend(msg, c.backend, c.codec, &c.backendUsage)
 			if err != nil {
 				c.nsqd.logf(LOG_ERROR, "failed to write message to backend - %s", err)
 			}
@@ -239,7 +298,7 @@ func (c *Channel) flush() error {
 finish:
 	c.inFlightMutex.Lock()
 	for _, msg := range c.inFlightMessages {
-		err := writeMessageToBackend(msg, c.backend)
+		err := writeMessageToBackend(msg, c.backend, c.codec, &c.backendUsage)
 		if err != nil {
 			c.nsqd.logf(LOG_ERROR, "failed to write message to backend - %s", err)
 		}
@@ -249,7 +308,7 @@ finish:
 	c.deferredMutex.Lock()
 	for _, item := range c.deferredMessages {
 		msg := item.Value.(*Message)
-		err := writeMessageToBackend(msg, c.backend)
+		err := writeMessageToBackend(msg, c.backend, c.codec, &c.backendUsage)
 		if err != nil {
 			c.nsqd.logf(LOG_ERROR, "failed to write message to backend - %s", err)
 		}
@@ -260,7 +319,56 @@ finish:
 }
 
 func (c *Channel) Depth() int64 {
-	return int64(len(c.memoryMsgChan)) + c.backend.Depth()
+	return int64(len(c.memoryMsgChan)) + c.backend.Depth() + atomic.LoadInt64(&c.partitionPending)
+}
+
+// OldestMessageTimestamp returns the (approximate) timestamp of the oldest
+// message queued, in flight or deferred in the channel, or 0 when there is none
+//
+// It is called for every /stats request, so it does not scan anything: in
+// flight and deferred messages are represented by the heads of their priority
+// queues, the ones timing out and being delivered next, and the head of the
+// queue by the message that was put into an empty queue or most recently sent
+// to a client, whichever came last. The queue files are only peeked once when
+// nothing was put or sent since the queue was loaded from disk.
+func (c *Channel) OldestMessageTimestamp() int64 {
+	var oldest int64
+	older := func(ts int64) {
+		if ts > 0 && (oldest == 0 || ts < oldest) {
+			oldest = ts
+		}
+	}
+
+	c.inFlightMutex.Lock()
+	if len(c.inFlightPQ) > 0 {
+		older(c.inFlightPQ[0].Timestamp)
+	}
+	c.inFlightMutex.Unlock()
+	c.deferredMutex.Lock()
+	if len(c.deferredPQ) > 0 {
+		older(c.deferredPQ[0].Value.(*Message).Timestamp)
+	}
+	c.deferredMutex.Unlock()
+
+	if c.Depth() > 0 {
+		head := atomic.LoadInt64(&c.headTimestamp)
+		if head == 0 && !c.ephemeral {
+			msgs, _ := peekQueueFiles(c.nsqd.getOpts(), getBackendName(c.topicName, c.name), c.codec, 1)
+			// -1 when there was nothing to peek, so that it is not retried
+			// until a message is put or sent
+			peeked := int64(-1)
+			if len(msgs) > 0 {
+				peeked = msgs[0].Timestamp
+			}
+			if atomic.CompareAndSwapInt64(&c.headTimestamp, 0, peeked) {
+				head = peeked
+			} else {
+				head = atomic.LoadInt64(&c.headTimestamp)
+			}
+		}
+		older(head)
+	}
+	return oldest
 }
 
 func (c *Channel) Pause() error {
@@ -301,6 +409,9 @@ func (c *Channel) PutMessage(m *Message) error {
 	if c.Exiting() {
 		return errors.New("exiting")
 	}
+	if !c.applyDepthLimits() {
+		return nil
+	}
 	err := c.put(m)
 	if err != nil {
 		return err
@@ -311,10 +422,20 @@ func (c *Channel) PutMessage(m *Message) error {
 }
 
 func (c *Channel) put(m *Message) error {
+	// not Depth, the diskqueue's waits for its ioLoop
+	if len(c.memoryMsgChan) == 0 && c.backendUsage.depth() == 0 &&
+		atomic.LoadInt64(&c.partitionPending) == 0 {
+		atomic.StoreInt64(&c.headTimestamp, m.Timestamp)
+	}
 	select {
 	case c.memoryMsgChan <- m:
 	default:
-		err := writeMessageToBackend(m, c.backend)
+		// the message was already accepted by the topic, so when the disk is
+		// full a channel only makes room for it under the drop-oldest policy
+		if !c.ephemeral {
+			c.nsqd.dropOldestOnDiskFull(c.backend, &c.backendUsage, int64(minValidMsgLength+len(m.Body)))
+		}
+		err := writeMessageToBackend(m, c.backend, c.codec, &c.backendUsage)
 		c.nsqd.SetHealth(err)
 		if err != nil {
 			c.nsqd.logf(LOG_ERROR, "CHANNEL(%s): failed to write message to backend - %s",
@@ -325,6 +446,15 @@ func (c *Channel) put(m *Message) error {
 	return nil
 }
 
+// messageChans returns the channels a client receives the messages of the
+// channel on
+func (c *Channel) messageChans(clientID int64) (chan *Message, <-chan []byte) {
+	if c.partitionOwners != nil {
+		return c.partitionChan(clientID), nil
+	}
+	return c.memoryMsgChan, c.backend.ReadChan()
+}
+
 func (c *Channel) PutMessageDeferred(msg *Message, timeout time.Duration) {
 	atomic.AddUint64(&c.messageCount, 1)
 	c.StartDeferredTimeout(msg, timeout)
@@ -388,7 +518,7 @@ func (c *Channel) RequeueMessage(clientID int64, id MessageID, timeout time.Dura
 			c.exitMutex.RUnlock()
 			return errors.New("exiting")
 		}
-		err := c.put(msg)
+		err := c.requeue(msg)
 		c.exitMutex.RUnlock()
 		return err
 	}
@@ -423,6 +553,13 @@ func (c *Channel) AddClient(clientID int64, client Consumer) error {
 	c.Lock()
 	c.clients[clientID] = client
 	c.Unlock()
+
+	if c.partitionOwners != nil {
+		c.partitionMtx.Lock()
+		c.partitionChans[clientID] = make(chan *Message)
+		c.rebalancePartitions()
+		c.partitionMtx.Unlock()
+	}
 	return nil
 }
 
@@ -446,6 +583,13 @@ func (c *Channel) RemoveClient(clientID int64) {
 	delete(c.clients, clientID)
 	c.Unlock()
 
+	if c.partitionOwners != nil {
+		c.partitionMtx.Lock()
+		delete(c.partitionChans, clientID)
+		c.rebalancePartitions()
+		c.partitionMtx.Unlock()
+	}
+
 	if len(c.clients) == 0 && c.ephemeral {
 		go c.deleter.Do(func() { c.deleteCallback(c) })
 	}
@@ -461,6 +605,7 @@ func (c *Channel) StartInFlightTimeout(msg *Message, clientID int64, timeout tim
 		return err
 	}
 	c.addToInFlightPQ(msg)
+	atomic.StoreInt64(&c.headTimestamp, msg.Timestamp)
 	return nil
 }
 
@@ -579,7 +724,7 @@ func (c *Channel) processDeferredQueue(t int64) bool {
 		if err != nil {
 			goto exit
 		}
-		c.put(msg)
+		c.requeue(msg)
 	}
 
 exit:
@@ -616,7 +761,7 @@ func (c *Channel) processInFlightQueue(t int64) bool {
 		if ok {
 			client.TimedOutMessage()
 		}
-		c.put(msg)
+		c.requeue(msg)
 	}
 
 exit:
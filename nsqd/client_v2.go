@@ -12,11 +12,15 @@ import (
 	"time"
 
 	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
 	"github.com/nsqio/nsq/internal/auth"
 )
 
 const defaultBufferSize = 16 * 1024
 
+// zstdMaxWindow is the max window size of the zstd streams of a client
+const zstdMaxWindow = 8 * 1024 * 1024
+
 const (
 	stateInit = iota
 	stateDisconnected
@@ -36,9 +40,12 @@ type identifyDataV2 struct {
 	Deflate             bool   `json:"deflate"`
 	DeflateLevel        int    `json:"deflate_level"`
 	Snappy              bool   `json:"snappy"`
+	Zstd                bool   `json:"zstd"`
+	ZstdLevel           int    `json:"zstd_level"`
 	SampleRate          int32  `json:"sample_rate"`
 	UserAgent           string `json:"user_agent"`
 	MsgTimeout          int    `json:"msg_timeout"`
+	RequestReply        bool   `json:"request_reply"`
 }
 
 type identifyEvent struct {
@@ -54,27 +61,34 @@ type PubCount struct {
 }
 
 type ClientV2Stats struct {
-	ClientID        string `json:"client_id"`
-	Hostname        string `json:"hostname"`
-	Version         string `json:"version"`
-	RemoteAddress   string `json:"remote_address"`
-	State           int32  `json:"state"`
-	ReadyCount      int64  `json:"ready_count"`
-	InFlightCount   int64  `json:"in_flight_count"`
-	MessageCount    uint64 `json:"message_count"`
-	FinishCount     uint64 `json:"finish_count"`
-	RequeueCount    uint64 `json:"requeue_count"`
-	ConnectTime     int64  `json:"connect_ts"`
-	SampleRate      int32  `json:"sample_rate"`
-	Deflate         bool   `json:"deflate"`
-	Snappy          bool   `json:"snappy"`
-	UserAgent       string `json:"user_agent"`
-	Authed          bool   `json:"authed,omitempty"`
-	AuthIdentity    string `json:"auth_identity,omitempty"`
-	AuthIdentityURL string `json:"auth_identity_url,omitempty"`
+	ClientID         string `json:"client_id"`
+	Hostname         string `json:"hostname"`
+	Version          string `json:"version"`
+	RemoteAddress    string `json:"remote_address"`
+	State            int32  `json:"state"`
+	ReadyCount       int64  `json:"ready_count"`
+	InFlightCount    int64  `json:"in_flight_count"`
+	MessageCount     uint64 `json:"message_count"`
+	FinishCount      uint64 `json:"finish_count"`
+	RequeueCount     uint64 `json:"requeue_count"`
+	RateLimitedCount uint64 `json:"rate_limited_count"`
+	ConnectTime      int64  `json:"connect_ts"`
+	SampleRate       int32  `json:"sample_rate"`
+	Deflate          bool   `json:"deflate"`
+	Snappy           bool   `json:"snappy"`
+	Zstd             bool   `json:"zstd"`
+	UserAgent        string `json:"user_agent"`
+	Authed           bool   `json:"authed,omitempty"`
+	AuthIdentity     string `json:"auth_identity,omitempty"`
+	AuthIdentityURL  string `json:"auth_identity_url,omitempty"`
 
 	PubCounts []PubCount `json:"pub_counts,omitempty"`
 
+	// partitions assigned to the client by a partitioned channel
+	Partitions []int `json:"partitions,omitempty"`
+
+	ReplyTopic string `json:"reply_topic,omitempty"`
+
 	TLS                           bool   `json:"tls"`
 	CipherSuite                   string `json:"tls_cipher_suite"`
 	TLSVersion                    string `json:"tls_version"`
@@ -128,6 +142,9 @@ type clientV2 struct {
 	FinishCount   uint64
 	RequeueCount  uint64
 
+	// number of publishes rejected by rate limits
+	RateLimitedCount uint64
+
 	pubCounts map[string]uint64
 
 	writeLock sync.RWMutex
@@ -143,6 +160,8 @@ type clientV2 struct {
 	// connections based on negotiated features
 	tlsConn     *tls.Conn
 	flateWriter *flate.Writer
+	zstdReader  *zstd.Decoder
+	zstdWriter  *zstd.Encoder
 
 	// reading/writing interfaces
 	Reader *bufio.Reader
@@ -164,6 +183,12 @@ type clientV2 struct {
 	ClientID string
 	Hostname string
 
+	// RequestReply is set when the client receives the reply headers of
+	// messages (frameTypeHeaderMessage), ReplyTopic is its ephemeral topic
+	// for replies to its requests
+	RequestReply bool
+	ReplyTopic   string
+
 	SampleRate int32
 
 	IdentifyEventChan chan identifyEvent
@@ -172,6 +197,7 @@ type clientV2 struct {
 	TLS     int32
 	Snappy  int32
 	Deflate int32
+	Zstd    int32
 
 	// re-usable buffer for reading the 4-byte lengths off the wire
 	lenBuf   [4]byte
@@ -244,6 +270,7 @@ func (c *clientV2) Identify(data identifyDataV2) error {
 	c.ClientID = data.ClientID
 	c.Hostname = data.Hostname
 	c.UserAgent = data.UserAgent
+	c.RequestReply = data.RequestReply
 	c.metaLock.Unlock()
 
 	err := c.SetHeartbeatInterval(data.HeartbeatInterval)
@@ -287,6 +314,7 @@ func (c *clientV2) Stats(topicName string) ClientStats {
 	clientID := c.ClientID
 	hostname := c.Hostname
 	userAgent := c.UserAgent
+	replyTopic := c.ReplyTopic
 	var identity string
 	var identityURL string
 	if c.AuthState != nil {
@@ -306,26 +334,32 @@ func (c *clientV2) Stats(topicName string) ClientStats {
 	}
 	c.metaLock.RUnlock()
 	stats := ClientV2Stats{
-		Version:         "V2",
-		RemoteAddress:   c.RemoteAddr().String(),
-		ClientID:        clientID,
-		Hostname:        hostname,
-		UserAgent:       userAgent,
-		State:           atomic.LoadInt32(&c.State),
-		ReadyCount:      atomic.LoadInt64(&c.ReadyCount),
-		InFlightCount:   atomic.LoadInt64(&c.InFlightCount),
-		MessageCount:    atomic.LoadUint64(&c.MessageCount),
-		FinishCount:     atomic.LoadUint64(&c.FinishCount),
-		RequeueCount:    atomic.LoadUint64(&c.RequeueCount),
-		ConnectTime:     c.ConnectTime.Unix(),
-		SampleRate:      atomic.LoadInt32(&c.SampleRate),
-		TLS:             atomic.LoadInt32(&c.TLS) == 1,
-		Deflate:         atomic.LoadInt32(&c.Deflate) == 1,
-		Snappy:          atomic.LoadInt32(&c.Snappy) == 1,
-		Authed:          c.HasAuthorizations(),
-		AuthIdentity:    identity,
-		AuthIdentityURL: identityURL,
-		PubCounts:       pubCounts,
+		Version:          "V2",
+		RemoteAddress:    c.RemoteAddr().String(),
+		ClientID:         clientID,
+		Hostname:         hostname,
+		UserAgent:        userAgent,
+		State:            atomic.LoadInt32(&c.State),
+		ReadyCount:       atomic.LoadInt64(&c.ReadyCount),
+		InFlightCount:    atomic.LoadInt64(&c.InFlightCount),
+		MessageCount:     atomic.LoadUint64(&c.MessageCount),
+		FinishCount:      atomic.LoadUint64(&c.FinishCount),
+		RequeueCount:     atomic.LoadUint64(&c.RequeueCount),
+		RateLimitedCount: atomic.LoadUint64(&c.RateLimitedCount),
+		ConnectTime:      c.ConnectTime.Unix(),
+		SampleRate:       atomic.LoadInt32(&c.SampleRate),
+		TLS:              atomic.LoadInt32(&c.TLS) == 1,
+		Deflate:          atomic.LoadInt32(&c.Deflate) == 1,
+		Snappy:           atomic.LoadInt32(&c.Snappy) == 1,
+		Zstd:             atomic.LoadInt32(&c.Zstd) == 1,
+		Authed:           c.HasAuthorizations(),
+		AuthIdentity:     identity,
+		AuthIdentityURL:  identityURL,
+		PubCounts:        pubCounts,
+		ReplyTopic:       replyTopic,
+	}
+	if c.Channel != nil && c.Channel.partitionOwners != nil {
+		stats.Partitions = c.Channel.assignedPartitions(c.ID)
 	}
 	if stats.TLS {
 		p := prettyConnectionState{c.tlsConn.ConnectionState()}
@@ -446,6 +480,20 @@ func (c *clientV2) PublishedMessage(topic string, count uint64) {
 	c.metaLock.Unlock()
 }
 
+// AllowPublish applies the publish rate limits of all publishers, of the
+// client's auth identity and of the topic to count messages of size bytes in total
+func (c *clientV2) AllowPublish(topic *Topic, count int, size int64) bool {
+	var identity string
+	if c.AuthState != nil {
+		identity = c.AuthState.Identity
+	}
+	if c.nsqd.allowPublish(topic, identity, count, size) {
+		return true
+	}
+	atomic.AddUint64(&c.RateLimitedCount, 1)
+	return false
+}
+
 func (c *clientV2) TimedOutMessage() {
 	atomic.AddInt64(&c.InFlightCount, -1)
 	c.tryUpdateReadyState()
@@ -616,6 +664,60 @@ func (c *clientV2) UpgradeSnappy() error {
 	return nil
 }
 
+func (c *clientV2) UpgradeZstd(level int) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	conn := c.Conn
+	if c.tlsConn != nil {
+		conn = c.tlsConn
+	}
+
+	// a concurrency of 1 decodes and encodes synchronously, and the window
+	// bounds the memory a client can make the decoder allocate
+	zr, err := zstd.NewReader(conn,
+		zstd.WithDecoderConcurrency(1),
+		zstd.WithDecoderMaxWindow(zstdMaxWindow),
+		zstd.WithDecoderMaxMemory(zstdMaxWindow))
+	if err != nil {
+		return err
+	}
+	c.zstdReader = zr
+	c.Reader = bufio.NewReaderSize(zr, defaultBufferSize)
+
+	zw, err := zstd.NewWriter(conn,
+		zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+		zstd.WithEncoderConcurrency(1),
+		zstd.WithWindowSize(zstdMaxWindow))
+	if err != nil {
+		zr.Close()
+		return err
+	}
+	c.zstdWriter = zw
+	c.Writer = bufio.NewWriterSize(zw, c.OutputBufferSize)
+
+	atomic.StoreInt32(&c.Zstd, 1)
+
+	return nil
+}
+
+// closeZstdReader releases the zstd decoder once the IOLoop stopped reading
+func (c *clientV2) closeZstdReader() {
+	if c.zstdReader != nil {
+		c.zstdReader.Close()
+	}
+}
+
+// closeZstdWriter releases the zstd encoder once the messagePump stopped
+// writing
+func (c *clientV2) closeZstdWriter() {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	if c.zstdWriter != nil {
+		c.zstdWriter.Close()
+	}
+}
+
 func (c *clientV2) Flush() error {
 	var zeroTime time.Time
 	if c.HeartbeatInterval > 0 {
@@ -633,6 +735,10 @@ func (c *clientV2) Flush() error {
 		return c.flateWriter.Flush()
 	}
 
+	if c.zstdWriter != nil {
+		return c.zstdWriter.Flush()
+	}
+
 	return nil
 }
 
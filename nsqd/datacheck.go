@@ -0,0 +1,638 @@
+package nsqd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nsqio/nsq/internal/lg"
+	"github.com/nsqio/nsq/internal/protocol"
+	"github.com/nsqio/nsq/internal/version"
+)
+
+// the number of messages diskqueue appends to a file when it is rotated while
+// running with --max-bytes-per-queue
+const numFileMsgBytes = 8
+
+var diskQueueFileRegexp = regexp.MustCompile(`^(.+)\.diskqueue\.(\d+)\.dat$`)
+
+// CorruptRecord describes a record of a diskqueue file that failed validation
+type CorruptRecord struct {
+	File   string `json:"file"`
+	Offset int64  `json:"offset"`
+	Reason string `json:"reason"`
+}
+
+// QueueCheck is the result of checking the files of a single diskqueue
+type QueueCheck struct {
+	Name        string          `json:"name"`
+	MetadataErr string          `json:"metadata_error,omitempty"`
+	Files       int             `json:"files"`
+	StaleFiles  int             `json:"stale_files"`
+	BadFiles    int             `json:"bad_files"`
+	Messages    int64           `json:"messages"`
+	Depth       int64           `json:"depth"`
+	LostBytes   int64           `json:"lost_bytes"`
+	Corrupt     []CorruptRecord `json:"corrupt"`
+	Repaired    bool            `json:"repaired"`
+	RepairErr   string          `json:"repair_error,omitempty"`
+
+	metadataOK   bool
+	readFileNum  int64
+	readPos      int64
+	writeFileNum int64
+	fileNums     []int64
+}
+
+// OK reports whether the queue is consistent
+func (q *QueueCheck) OK() bool {
+	return q.metadataOK && len(q.Corrupt) == 0 && q.StaleFiles == 0 && q.Depth == q.Messages
+}
+
+// DataCheckReport is the result of CheckData
+type DataCheckReport struct {
+	DataPath    string        `json:"data_path"`
+	MetadataErr string        `json:"metadata_error,omitempty"`
+	Queues      []*QueueCheck `json:"queues"`
+	Repaired    bool          `json:"repaired"`
+}
+
+// OK reports whether no problems were found in the data path
+func (r *DataCheckReport) OK() bool {
+	if r.MetadataErr != "" {
+		return false
+	}
+	for _, q := range r.Queues {
+		if !q.OK() {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckData scans the metadata and the diskqueue files in --data-path, reporting
+// corrupted records. When repair is true, every queue with problems is
+// rewritten without its corrupted records and a corrupted nsqd metadata file is
+// rebuilt from the queues found on disk.
+//
+// It must not be run against a data path in use by a running nsqd.
+func CheckData(opts *Options, repair bool) (*DataCheckReport, error) {
+	dataPath := opts.DataPath
+	if dataPath == "" {
+		dataPath = "."
+	}
+	if opts.Logger == nil {
+		opts.Logger = log.New(os.Stderr, opts.LogPrefix, log.Ldate|log.Ltime|log.Lmicroseconds)
+	}
+	logf := func(level lg.LogLevel, f string, args ...interface{}) {
+		lg.Logf(opts.Logger, opts.LogLevel, level, f, args...)
+	}
+
+	codec, err := newBackendCodec(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load --encryption-key-file - %s", err)
+	}
+	defer codec.close()
+
+	report := &DataCheckReport{DataPath: dataPath}
+
+	fn := newMetadataFile(opts)
+	data, err := readOrEmpty(fn)
+	if err != nil {
+		report.MetadataErr = err.Error()
+	} else if data != nil {
+		var m Metadata
+		err = json.Unmarshal(data, &m)
+		if err != nil {
+			report.MetadataErr = fmt.Sprintf("failed to parse metadata in %s - %s", fn, err)
+		}
+	}
+	if report.MetadataErr != "" {
+		logf(LOG_WARN, "DATACHECK: %s", report.MetadataErr)
+	}
+
+	queues, err := findQueues(dataPath)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, q := range queues {
+		checkQueue(q, dataPath, opts, codec)
+		if q.OK() {
+			logf(LOG_DEBUG, "DATACHECK(%s): %d messages, OK", q.Name, q.Messages)
+			continue
+		}
+
+		for _, c := range q.Corrupt {
+			logf(LOG_WARN, "DATACHECK(%s): corrupt record in %s at offset %d - %s",
+				q.Name, c.File, c.Offset, c.Reason)
+		}
+		if q.MetadataErr != "" {
+			logf(LOG_WARN, "DATACHECK(%s): %s", q.Name, q.MetadataErr)
+		}
+		if q.Depth != q.Messages {
+			logf(LOG_WARN, "DATACHECK(%s): metadata depth %d != %d valid messages",
+				q.Name, q.Depth, q.Messages)
+		}
+
+		if !repair {
+			continue
+		}
+		err := repairQueue(q, dataPath, opts, codec)
+		if err != nil {
+			q.RepairErr = err.Error()
+			logf(LOG_ERROR, "DATACHECK(%s): failed to repair - %s", q.Name, err)
+			continue
+		}
+		q.Repaired = true
+		report.Repaired = true
+		logf(LOG_INFO, "DATACHECK(%s): rewrote queue with %d messages", q.Name, q.Messages)
+	}
+
+	if repair && report.MetadataErr != "" {
+		err := rebuildMetadata(fn, queues)
+		if err != nil {
+			logf(LOG_ERROR, "DATACHECK: failed to rebuild metadata - %s", err)
+		} else {
+			report.Repaired = true
+			logf(LOG_INFO, "DATACHECK: rebuilt metadata %s from %d queues", fn, len(queues))
+		}
+	}
+
+	report.Queues = queues
+	return report, nil
+}
+
+// findQueues groups the diskqueue files in dataPath by queue name
+func findQueues(dataPath string) ([]*QueueCheck, error) {
+	entries, err := os.ReadDir(dataPath)
+	if err != nil {
+		return nil, err
+	}
+
+	queueMap := make(map[string]*QueueCheck)
+	getQueue := func(name string) *QueueCheck {
+		q, ok := queueMap[name]
+		if !ok {
+			q = &QueueCheck{Name: name}
+			queueMap[name] = q
+		}
+		return q
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		switch {
+		case strings.HasSuffix(name, ".diskqueue.meta.dat"):
+			getQueue(strings.TrimSuffix(name, ".diskqueue.meta.dat"))
+		case strings.HasSuffix(name, ".dat.bad"):
+			matches := diskQueueFileRegexp.FindStringSubmatch(strings.TrimSuffix(name, ".bad"))
+			if matches != nil {
+				getQueue(matches[1]).BadFiles++
+			}
+		default:
+			matches := diskQueueFileRegexp.FindStringSubmatch(name)
+			if matches == nil {
+				continue
+			}
+			fileNum, err := strconv.ParseInt(matches[2], 10, 64)
+			if err != nil {
+				continue
+			}
+			q := getQueue(matches[1])
+			q.fileNums = append(q.fileNums, fileNum)
+		}
+	}
+
+	queues := make([]*QueueCheck, 0, len(queueMap))
+	for _, q := range queueMap {
+		sort.Slice(q.fileNums, func(i, j int) bool { return q.fileNums[i] < q.fileNums[j] })
+		queues = append(queues, q)
+	}
+	sort.Slice(queues, func(i, j int) bool { return queues[i].Name < queues[j].Name })
+	return queues, nil
+}
+
+func queueMetadataFileName(dataPath string, name string) string {
+	return path.Join(dataPath, name+".diskqueue.meta.dat")
+}
+
+func queueFileName(dataPath string, name string, fileNum int64) string {
+	return path.Join(dataPath, fmt.Sprintf("%s.diskqueue.%06d.dat", name, fileNum))
+}
+
+// readQueueMetadata parses a diskqueue metadata file, the format depends on
+// whether the queue runs with --max-bytes-per-queue
+func readQueueMetadata(fn string, diskLimit bool) (depth int64, readFileNum int64, readPos int64, writeFileNum int64, err error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return 0, 0, 0, 0, err
+	}
+	defer f.Close()
+
+	var readMessages, writeMessages, writePos int64
+	if diskLimit {
+		_, err = fmt.Fscanf(f, "%d\n%d,%d,%d\n%d,%d,%d\n",
+			&depth,
+			&readFileNum, &readMessages, &readPos,
+			&writeFileNum, &writeMessages, &writePos)
+	} else {
+		_, err = fmt.Fscanf(f, "%d\n%d,%d\n%d,%d\n",
+			&depth,
+			&readFileNum, &readPos,
+			&writeFileNum, &writePos)
+	}
+	if err == nil && (depth < 0 || readPos < 0 || readFileNum > writeFileNum) {
+		err = errors.New("inconsistent values")
+	}
+	return depth, readFileNum, readPos, writeFileNum, err
+}
+
+// checkRecord validates a record read from a diskqueue file
+func checkRecord(codec *backendCodec, b []byte) error {
+	msg, err := codec.decode(b)
+	if err != nil {
+		return err
+	}
+	if msg.Timestamp <= 0 || msg.Timestamp > time.Now().Add(24*time.Hour).UnixNano() {
+		return fmt.Errorf("invalid timestamp (%d)", msg.Timestamp)
+	}
+	for _, c := range msg.ID {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return errors.New("invalid message ID")
+		}
+	}
+	return nil
+}
+
+// scanQueueFile calls fn with every valid record of the given file, starting at
+// offset, and reports corrupted records and the number of unrecoverable bytes.
+//
+// A record with an invalid size leaves no way to find where the next one begins
+// so the rest of the file is lost, a record with a valid size but an invalid
+// payload is skipped. Files that were rotated may end with the number of
+// messages they contain.
+func scanQueueFile(fileName string, offset int64, rotated bool, opts *Options,
+	codec *backendCodec, fn func([]byte) error) ([]CorruptRecord, int64, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	size := stat.Size()
+	if offset > size {
+		return []CorruptRecord{{fileName, offset, "read position beyond end of file"}}, 0, nil
+	}
+	_, err = f.Seek(offset, 0)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var corrupt []CorruptRecord
+	maxMsgSize := int32(opts.MaxMsgSize) + minValidMsgLength + maxRecordOverhead
+	r := bufio.NewReader(f)
+	pos := offset
+	for pos < size {
+		remaining := size - pos
+		if rotated && remaining == numFileMsgBytes {
+			break
+		}
+		if remaining < 4 {
+			corrupt = append(corrupt, CorruptRecord{fileName, pos, "truncated record size"})
+			return corrupt, remaining, nil
+		}
+
+		var msgSize int32
+		err = binary.Read(r, binary.BigEndian, &msgSize)
+		if err != nil {
+			return corrupt, 0, err
+		}
+		if msgSize < minValidMsgLength || msgSize > maxMsgSize {
+			corrupt = append(corrupt, CorruptRecord{fileName, pos,
+				fmt.Sprintf("invalid message size (%d)", msgSize)})
+			return corrupt, remaining, nil
+		}
+		if int64(msgSize) > remaining-4 {
+			corrupt = append(corrupt, CorruptRecord{fileName, pos, "truncated record"})
+			return corrupt, remaining, nil
+		}
+
+		buf := make([]byte, msgSize)
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return corrupt, 0, err
+		}
+
+		if err := checkRecord(codec, buf); err != nil {
+			corrupt = append(corrupt, CorruptRecord{fileName, pos, err.Error()})
+		} else if err := fn(buf); err != nil {
+			return corrupt, 0, err
+		}
+		pos += 4 + int64(msgSize)
+	}
+	return corrupt, 0, nil
+}
+
+func checkQueue(q *QueueCheck, dataPath string, opts *Options, codec *backendCodec) {
+	diskLimit := opts.MaxBytesPerQueue > 0
+
+	depth, readFileNum, readPos, writeFileNum, err := readQueueMetadata(
+		queueMetadataFileName(dataPath, q.Name), diskLimit)
+	if err != nil {
+		q.MetadataErr = fmt.Sprintf("failed to read queue metadata - %s", err)
+		// salvage everything found on disk
+		readFileNum, readPos, writeFileNum = 0, 0, 0
+		if len(q.fileNums) > 0 {
+			readFileNum = q.fileNums[0]
+			writeFileNum = q.fileNums[len(q.fileNums)-1]
+		}
+	} else {
+		q.metadataOK = true
+		q.Depth = depth
+	}
+	q.readFileNum = readFileNum
+	q.readPos = readPos
+	q.writeFileNum = writeFileNum
+
+	for _, fileNum := range q.fileNums {
+		if fileNum < readFileNum {
+			// already consumed, diskqueue failed to remove it
+			q.StaleFiles++
+			continue
+		}
+		q.Files++
+
+		var offset int64
+		if fileNum == readFileNum {
+			offset = readPos
+		}
+		fileName := queueFileName(dataPath, q.Name, fileNum)
+		corrupt, lost, err := scanQueueFile(fileName, offset, fileNum < writeFileNum, opts, codec,
+			func([]byte) error {
+				q.Messages++
+				return nil
+			})
+		if err != nil {
+			q.Corrupt = append(q.Corrupt, CorruptRecord{fileName, offset, err.Error()})
+			continue
+		}
+		q.Corrupt = append(q.Corrupt, corrupt...)
+		q.LostBytes += lost
+	}
+	if !q.metadataOK {
+		q.Depth = q.Messages
+	}
+}
+
+// queueWriter writes records in the diskqueue file format, rotating files the
+// same way diskqueue does
+type queueWriter struct {
+	name            string
+	dataPath        string
+	maxBytesPerFile int64
+	diskLimit       bool
+
+	f        *os.File
+	w        *bufio.Writer
+	fileNum  int64
+	pos      int64
+	fileMsgs int64
+	depth    int64
+}
+
+func (qw *queueWriter) open() error {
+	f, err := os.OpenFile(queueFileName(qw.dataPath, qw.name, qw.fileNum), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	qw.f = f
+	qw.w = bufio.NewWriter(f)
+	return nil
+}
+
+func (qw *queueWriter) closeFile() error {
+	err := qw.w.Flush()
+	if err == nil {
+		err = qw.f.Sync()
+	}
+	qw.f.Close()
+	qw.f = nil
+	return err
+}
+
+func (qw *queueWriter) Write(data []byte) error {
+	if qw.f == nil {
+		if err := qw.open(); err != nil {
+			return err
+		}
+	}
+
+	totalBytes := int64(4 + len(data))
+	rotate := qw.pos+totalBytes >= qw.maxBytesPerFile
+	if qw.diskLimit {
+		rotate = qw.pos+totalBytes+numFileMsgBytes >= qw.maxBytesPerFile
+	}
+
+	err := binary.Write(qw.w, binary.BigEndian, int32(len(data)))
+	if err != nil {
+		return err
+	}
+	_, err = qw.w.Write(data)
+	if err != nil {
+		return err
+	}
+	qw.pos += totalBytes
+	qw.fileMsgs++
+	qw.depth++
+
+	if rotate {
+		if qw.diskLimit {
+			err = binary.Write(qw.w, binary.BigEndian, qw.fileMsgs)
+			if err != nil {
+				return err
+			}
+		}
+		err = qw.closeFile()
+		if err != nil {
+			return err
+		}
+		qw.fileNum++
+		qw.pos = 0
+		qw.fileMsgs = 0
+	}
+	return nil
+}
+
+// Close closes the current file (creating it if needed, diskqueue expects the
+// write file to exist) and writes the queue metadata
+func (qw *queueWriter) Close() error {
+	if qw.f == nil {
+		if err := qw.open(); err != nil {
+			return err
+		}
+	}
+	if err := qw.closeFile(); err != nil {
+		return err
+	}
+
+	var data string
+	if qw.diskLimit {
+		data = fmt.Sprintf("%d\n%d,%d,%d\n%d,%d,%d\n",
+			qw.depth, 0, 0, 0, qw.fileNum, qw.fileMsgs, qw.pos)
+	} else {
+		data = fmt.Sprintf("%d\n%d,%d\n%d,%d\n",
+			qw.depth, 0, 0, qw.fileNum, qw.pos)
+	}
+	return writeSyncFile(queueMetadataFileName(qw.dataPath, qw.name), []byte(data))
+}
+
+const repairBadSuffix = ".datacheck.bad"
+
+// repairQueue rewrites the valid records of a queue into a temporary directory
+// and then replaces the original files with the new ones. The originals are
+// renamed aside (*.datacheck.bad, not to clobber the *.bad files of diskqueue)
+// first and only deleted once every new file is in place,
+// and the temporary directory is kept when anything fails so that no copy of
+// the data is lost.
+func repairQueue(q *QueueCheck, dataPath string, opts *Options, codec *backendCodec) error {
+	tmpDir, err := os.MkdirTemp(dataPath, "datacheck-")
+	if err != nil {
+		return err
+	}
+
+	diskLimit := opts.MaxBytesPerQueue > 0
+	qw := &queueWriter{
+		name:            q.Name,
+		dataPath:        tmpDir,
+		maxBytesPerFile: opts.MaxBytesPerFile,
+		diskLimit:       diskLimit,
+	}
+	for _, fileNum := range q.fileNums {
+		if fileNum < q.readFileNum {
+			continue
+		}
+		var offset int64
+		if fileNum == q.readFileNum {
+			offset = q.readPos
+		}
+		_, _, err := scanQueueFile(queueFileName(dataPath, q.Name, fileNum),
+			offset, fileNum < q.writeFileNum, opts, codec, qw.Write)
+		if err != nil {
+			return fmt.Errorf("failed to rewrite %s into %s - %s", q.Name, tmpDir, err)
+		}
+	}
+	err = qw.Close()
+	if err != nil {
+		return fmt.Errorf("failed to rewrite %s into %s - %s", q.Name, tmpDir, err)
+	}
+
+	// the originals, moved aside
+	var old []string
+	for _, fileNum := range q.fileNums {
+		old = append(old, queueFileName(dataPath, q.Name, fileNum))
+	}
+	old = append(old, queueMetadataFileName(dataPath, q.Name))
+	var moved []string
+	restore := func() {
+		for _, fn := range moved {
+			os.Rename(fn+repairBadSuffix, fn)
+		}
+	}
+	for _, fn := range old {
+		err := os.Rename(fn, fn+repairBadSuffix)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			restore()
+			return fmt.Errorf("failed to move %s aside (rewritten queue left in %s) - %s", fn, tmpDir, err)
+		}
+		moved = append(moved, fn)
+	}
+
+	// the new files, metadata last
+	var renames [][2]string
+	for fileNum := int64(0); fileNum <= qw.fileNum; fileNum++ {
+		renames = append(renames, [2]string{
+			queueFileName(tmpDir, q.Name, fileNum), queueFileName(dataPath, q.Name, fileNum)})
+	}
+	renames = append(renames, [2]string{
+		queueMetadataFileName(tmpDir, q.Name), queueMetadataFileName(dataPath, q.Name)})
+	for i, r := range renames {
+		err := os.Rename(r[0], r[1])
+		if err != nil {
+			for _, done := range renames[:i] {
+				os.Rename(done[1], done[0])
+			}
+			restore()
+			return fmt.Errorf("failed to move %s into place (rewritten queue left in %s) - %s", r[0], tmpDir, err)
+		}
+	}
+
+	for _, fn := range moved {
+		os.Remove(fn + repairBadSuffix)
+	}
+	os.RemoveAll(tmpDir)
+	return nil
+}
+
+// rebuildMetadata writes a new nsqd metadata file with every topic and channel
+// that has a diskqueue in the data path
+func rebuildMetadata(fn string, queues []*QueueCheck) error {
+	m := &Metadata{
+		Version: version.Binary,
+	}
+	topics := make(map[string]int)
+	var channels [][2]string
+	for _, q := range queues {
+		topicName := q.Name
+		channelName := ""
+		if i := strings.IndexAny(q.Name, ":;"); i != -1 {
+			topicName, channelName = q.Name[:i], q.Name[i+1:]
+		}
+		if !protocol.IsValidTopicName(topicName) {
+			continue
+		}
+		if _, ok := topics[topicName]; !ok {
+			topics[topicName] = len(m.Topics)
+			m.Topics = append(m.Topics, TopicMetadata{Name: topicName})
+		}
+		if channelName != "" && protocol.IsValidChannelName(channelName) {
+			channels = append(channels, [2]string{topicName, channelName})
+		}
+	}
+	for _, c := range channels {
+		t := &m.Topics[topics[c[0]]]
+		t.Channels = append(t.Channels, ChannelMetadata{Name: c[1]})
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	tmpFileName := fmt.Sprintf("%s.%d.tmp", fn, time.Now().UnixNano())
+	err = writeSyncFile(tmpFileName, data)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, fn)
+}
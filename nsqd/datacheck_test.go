@@ -0,0 +1,120 @@
+package nsqd
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/nsqio/nsq/internal/test"
+)
+
+func writeTestQueue(t *testing.T, opts *Options, topicName string, count int) {
+	_, _, nsqd := mustStartNSQD(opts)
+	topic := nsqd.GetTopic(topicName)
+	for i := 0; i < count; i++ {
+		msg := NewMessage(topic.GenerateID(), []byte("test body"))
+		err := topic.PutMessage(msg)
+		test.Nil(t, err)
+	}
+	nsqd.Exit()
+}
+
+func TestCheckDataRepairsCorruptRecord(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.MemQueueSize = 0
+	tmpDir, err := os.MkdirTemp("", "nsq-test-")
+	test.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+	opts.DataPath = tmpDir
+
+	topicName := "datacheck_test"
+	writeTestQueue(t, opts, topicName, 10)
+
+	report, err := CheckData(opts, false)
+	test.Nil(t, err)
+	test.Equal(t, true, report.OK())
+	test.Equal(t, 1, len(report.Queues))
+	test.Equal(t, int64(10), report.Queues[0].Messages)
+
+	// each record is a 4 byte size followed by the message, corrupt the ID of
+	// the third message
+	recordSize := 4 + minValidMsgLength + len("test body")
+	fn := queueFileName(opts.DataPath, topicName, 0)
+	data, err := os.ReadFile(fn)
+	test.Nil(t, err)
+	data[2*recordSize+4+10] = 'X'
+	err = os.WriteFile(fn, data, 0600)
+	test.Nil(t, err)
+
+	report, err = CheckData(opts, false)
+	test.Nil(t, err)
+	test.Equal(t, false, report.OK())
+	test.Equal(t, 1, len(report.Queues[0].Corrupt))
+	test.Equal(t, int64(2*recordSize), report.Queues[0].Corrupt[0].Offset)
+	test.Equal(t, int64(9), report.Queues[0].Messages)
+
+	report, err = CheckData(opts, true)
+	test.Nil(t, err)
+	test.Equal(t, true, report.Queues[0].Repaired)
+
+	// neither the originals nor the temporary directory are left behind
+	entries, err := os.ReadDir(opts.DataPath)
+	test.Nil(t, err)
+	for _, entry := range entries {
+		test.Equal(t, false, entry.IsDir())
+		test.Equal(t, false, strings.HasSuffix(entry.Name(), repairBadSuffix))
+	}
+
+	report, err = CheckData(opts, false)
+	test.Nil(t, err)
+	test.Equal(t, true, report.OK())
+	test.Equal(t, int64(9), report.Queues[0].Messages)
+
+	_, _, nsqd := mustStartNSQD(opts)
+	defer nsqd.Exit()
+	err = nsqd.LoadMetadata()
+	test.Nil(t, err)
+	topic, err := nsqd.GetExistingTopic(topicName)
+	test.Nil(t, err)
+	test.Equal(t, int64(9), topic.Depth())
+}
+
+func TestCheckDataTruncatedFile(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.MemQueueSize = 0
+	tmpDir, err := os.MkdirTemp("", "nsq-test-")
+	test.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+	opts.DataPath = tmpDir
+
+	topicName := "datacheck_test"
+	writeTestQueue(t, opts, topicName, 5)
+
+	fn := queueFileName(opts.DataPath, topicName, 0)
+	stat, err := os.Stat(fn)
+	test.Nil(t, err)
+	err = os.Truncate(fn, stat.Size()-3)
+	test.Nil(t, err)
+
+	// corrupt the nsqd metadata as well
+	err = os.WriteFile(newMetadataFile(opts), []byte("{"), 0600)
+	test.Nil(t, err)
+
+	report, err := CheckData(opts, true)
+	test.Nil(t, err)
+	test.NotEqual(t, "", report.MetadataErr)
+	test.Equal(t, int64(4), report.Queues[0].Messages)
+	test.Equal(t, 1, len(report.Queues[0].Corrupt))
+	test.Equal(t, true, report.Repaired)
+
+	opts.CheckDataOnStartup = true
+	_, _, nsqd := mustStartNSQD(opts)
+	defer nsqd.Exit()
+	err = nsqd.LoadMetadata()
+	test.Nil(t, err)
+	topic, err := nsqd.GetExistingTopic(topicName)
+	test.Nil(t, err)
+	test.Equal(t, int64(4), topic.Depth())
+}
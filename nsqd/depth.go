@@ -0,0 +1,249 @@
+package nsqd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// policies applied to new messages once a topic or channel reached its max
+// depth
+const (
+	DepthPolicyReject     = "reject"
+	DepthPolicyDropOldest = "drop-oldest"
+	DepthPolicyDropNewest = "drop-newest"
+)
+
+var errDepthExceeded = errors.New("max depth exceeded")
+
+// depthLimit is the max depth of a topic or channel in messages and in bytes
+// of the messages in its diskqueue (0 = no limit)
+type depthLimit struct {
+	messages int64
+	bytes    int64
+	policy   string
+}
+
+func (l depthLimit) enabled() bool {
+	return l.messages > 0 || l.bytes > 0
+}
+
+func validateDepthPolicy(policy string) error {
+	switch policy {
+	case DepthPolicyReject, DepthPolicyDropOldest, DepthPolicyDropNewest:
+		return nil
+	}
+	return fmt.Errorf("--depth-policy must be one of %s, %s or %s",
+		DepthPolicyReject, DepthPolicyDropOldest, DepthPolicyDropNewest)
+}
+
+// parseDepthLimits parses --topic-max-depth and --channel-max-depth values of
+// the form <name>:<messages>:<bytes>[:<policy>]
+func parseDepthLimits(flagName string, values []string, defaultPolicy string) (map[string]depthLimit, error) {
+	m := make(map[string]depthLimit)
+	for _, v := range values {
+		parts := strings.Split(v, ":")
+		if len(parts) != 3 && len(parts) != 4 {
+			return nil, fmt.Errorf("invalid --%s %q (<name>:<messages>:<bytes>[:<policy>])", flagName, v)
+		}
+		if parts[0] == "" {
+			return nil, fmt.Errorf("invalid --%s %q empty name", flagName, v)
+		}
+		l := depthLimit{policy: defaultPolicy}
+		var err error
+		l.messages, err = strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || l.messages < 0 {
+			return nil, fmt.Errorf("invalid --%s %q max messages", flagName, v)
+		}
+		l.bytes, err = strconv.ParseInt(parts[2], 10, 64)
+		if err != nil || l.bytes < 0 {
+			return nil, fmt.Errorf("invalid --%s %q max bytes", flagName, v)
+		}
+		if len(parts) == 4 {
+			l.policy = parts[3]
+			if err := validateDepthPolicy(l.policy); err != nil {
+				return nil, fmt.Errorf("invalid --%s %q policy", flagName, v)
+			}
+		}
+		m[parts[0]] = l
+	}
+	return m, nil
+}
+
+func (n *NSQD) defaultDepthLimit() depthLimit {
+	opts := n.getOpts()
+	return depthLimit{
+		messages: opts.MaxDepth,
+		bytes:    opts.MaxDepthBytes,
+		policy:   opts.DepthPolicy,
+	}
+}
+
+func (n *NSQD) topicDepthLimit(topicName string) depthLimit {
+	if l, ok := n.topicDepthLimits[topicName]; ok {
+		return l
+	}
+	return n.defaultDepthLimit()
+}
+
+func (n *NSQD) channelDepthLimit(topicName string, channelName string) depthLimit {
+	if l, ok := n.channelDepthLimits[topicName+"/"+channelName]; ok {
+		return l
+	}
+	return n.defaultDepthLimit()
+}
+
+// queueBytes returns the size of the diskqueue files of a backend as of the
+// last disk space check, consumed messages included
+func (n *NSQD) queueBytes(backendName string) int64 {
+	sizes, _ := n.queueSizes.Load().(map[string]int64)
+	return sizes[backendName]
+}
+
+// backendUsage counts the messages and bytes (records and their length
+// prefix) written to the backend of a topic or channel and not read from it
+// yet. The diskqueue's own Depth waits for its ioLoop, which may be busy
+// syncing, so the paths that queue messages use this instead.
+type backendUsage struct {
+	messages int64
+	bytes    int64
+}
+
+// newBackendUsage starts counting from what is in a diskqueue, the bytes past
+// its persisted read position
+func newBackendUsage(opts *Options, backendName string, backend BackendQueue) backendUsage {
+	u := backendUsage{messages: backend.Depth()}
+	_, readFileNum, readPos, writeFileNum, err := readQueueMetadata(
+		queueMetadataFileName(opts.DataPath, backendName), opts.MaxBytesPerQueue > 0)
+	if err != nil {
+		return u
+	}
+	for fileNum := readFileNum; fileNum <= writeFileNum; fileNum++ {
+		if fi, err := os.Stat(queueFileName(opts.DataPath, backendName, fileNum)); err == nil {
+			u.bytes += fi.Size()
+		}
+	}
+	if u.bytes -= readPos; u.bytes < 0 {
+		u.bytes = 0
+	}
+	return u
+}
+
+func (u *backendUsage) written(record []byte) {
+	atomic.AddInt64(&u.messages, 1)
+	atomic.AddInt64(&u.bytes, int64(len(record))+4)
+}
+
+func (u *backendUsage) read(record []byte) {
+	atomic.AddInt64(&u.messages, -1)
+	atomic.AddInt64(&u.bytes, -int64(len(record))-4)
+}
+
+func (u *backendUsage) reset() {
+	atomic.StoreInt64(&u.messages, 0)
+	atomic.StoreInt64(&u.bytes, 0)
+}
+
+func (u *backendUsage) depth() int64 {
+	return atomic.LoadInt64(&u.messages)
+}
+
+// depthExceeded returns whether count more messages would not fit within a
+// max depth, the depth being the memory queue and the given backend usage
+func depthExceeded(l depthLimit, memoryDepth int64, u *backendUsage, count int64) bool {
+	if l.messages > 0 && memoryDepth+u.depth()+count > l.messages {
+		return true
+	}
+	return l.bytes > 0 && atomic.LoadInt64(&u.bytes) >= l.bytes
+}
+
+// dropOldestMessage discards the oldest queued message of a topic or channel,
+// preferring the backend which holds the bulk of a backlog.
+//
+// A message read from the backend is consumed like any other, its space is
+// reclaimed with the file it is in. This never waits: the diskqueue only hands
+// out its next message when it is not busy with a write, when it is a memory
+// message (if any) is dropped instead.
+func dropOldestMessage(memoryMsgChan chan *Message, backend BackendQueue, u *backendUsage) bool {
+	if u.depth() > 0 {
+		select {
+		case b := <-backend.ReadChan():
+			u.read(b)
+			return true
+		default:
+		}
+	}
+	select {
+	case <-memoryMsgChan:
+		return true
+	default:
+	}
+	return false
+}
+
+// checkDepthLimits applies the reject policy of the max depth of the topic,
+// and of its channels, to a batch of count messages before any of them is
+// queued, so that a batch is accepted or rejected as a whole
+//
+// this expects the caller to hold the topic read lock
+func (t *Topic) checkDepthLimits(count int64) error {
+	if t.depthLimit.policy == DepthPolicyReject && t.depthLimit.enabled() &&
+		depthExceeded(t.depthLimit, int64(len(t.memoryMsgChan)), &t.backendUsage, count) {
+		atomic.AddUint64(&t.depthRejectCount, uint64(count))
+		return errDepthExceeded
+	}
+	for _, c := range t.channelMap {
+		if c.depthLimit.policy == DepthPolicyReject && c.isDepthExceeded(count) {
+			atomic.AddUint64(&c.depthRejectCount, uint64(count))
+			return errDepthExceeded
+		}
+	}
+	return nil
+}
+
+// applyDepthLimits applies the drop policies of the topic's max depth to a
+// new message, the reject policy is applied by checkDepthLimits. It returns
+// false when the message must be dropped.
+//
+// this expects the caller to hold the topic read lock
+func (t *Topic) applyDepthLimits() bool {
+	if t.depthLimit.policy == DepthPolicyReject || !t.depthLimit.enabled() ||
+		!depthExceeded(t.depthLimit, int64(len(t.memoryMsgChan)), &t.backendUsage, 1) {
+		return true
+	}
+	if t.depthLimit.policy == DepthPolicyDropNewest {
+		atomic.AddUint64(&t.depthDropCount, 1)
+		return false
+	}
+	if dropOldestMessage(t.memoryMsgChan, t.backend, &t.backendUsage) {
+		atomic.AddUint64(&t.depthDropCount, 1)
+	}
+	return true
+}
+
+func (c *Channel) isDepthExceeded(count int64) bool {
+	return c.depthLimit.enabled() &&
+		depthExceeded(c.depthLimit, int64(len(c.memoryMsgChan))+atomic.LoadInt64(&c.partitionPending),
+			&c.backendUsage, count)
+}
+
+// applyDepthLimits applies the drop policies of the channel's max depth to a
+// message delivered by the topic, the reject policy is applied by the topic
+// when the message is published. It returns false when the message must be
+// dropped.
+func (c *Channel) applyDepthLimits() bool {
+	if c.depthLimit.policy == DepthPolicyReject || !c.isDepthExceeded(1) {
+		return true
+	}
+	if c.depthLimit.policy == DepthPolicyDropNewest {
+		atomic.AddUint64(&c.depthDropCount, 1)
+		return false
+	}
+	if dropOldestMessage(c.memoryMsgChan, c.backend, &c.backendUsage) {
+		atomic.AddUint64(&c.depthDropCount, 1)
+	}
+	return true
+}
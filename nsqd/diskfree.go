@@ -0,0 +1,19 @@
+//go:build linux || darwin || freebsd || dragonfly
+// +build linux darwin freebsd dragonfly
+
+package nsqd
+
+import (
+	"syscall"
+)
+
+// diskFree returns the number of bytes available to unprivileged users on the
+// filesystem containing path
+func diskFree(path string) (int64, error) {
+	var st syscall.Statfs_t
+	err := syscall.Statfs(path, &st)
+	if err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
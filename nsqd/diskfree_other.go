@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !freebsd && !dragonfly
+// +build !linux,!darwin,!freebsd,!dragonfly
+
+package nsqd
+
+import (
+	"errors"
+)
+
+func diskFree(path string) (int64, error) {
+	return 0, errors.New("not supported on this platform")
+}
@@ -0,0 +1,201 @@
+package nsqd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// policies applied to new messages once the data path quota or the free disk
+// space watermark has been crossed
+const (
+	DiskFullReject     = "reject"
+	DiskFullDropOldest = "drop-oldest"
+	DiskFullMemoryOnly = "memory-only"
+)
+
+var errDiskFull = errors.New("disk space exhausted")
+
+type diskUsage struct {
+	dataPathBytes int64
+	freeBytes     int64
+	err           error
+}
+
+// DiskStats reports the disk space usage of the data path and the state of
+// the disk space watermarks
+type DiskStats struct {
+	DataPathBytes    int64  `json:"data_path_bytes"`
+	MaxBytesDataPath int64  `json:"max_bytes_data_path"`
+	FreeBytes        int64  `json:"free_bytes"`
+	MinFreeBytes     int64  `json:"min_free_bytes"`
+	Full             bool   `json:"full"`
+	Policy           string `json:"policy"`
+	RejectCount      uint64 `json:"reject_count"`
+	DropCount        uint64 `json:"drop_count"`
+}
+
+func validateDiskFullPolicy(policy string) error {
+	switch policy {
+	case DiskFullReject, DiskFullDropOldest, DiskFullMemoryOnly:
+		return nil
+	}
+	return fmt.Errorf("--disk-full-policy must be one of %s, %s or %s",
+		DiskFullReject, DiskFullDropOldest, DiskFullMemoryOnly)
+}
+
+func (n *NSQD) dataPath() string {
+	dataPath := n.getOpts().DataPath
+	if dataPath == "" {
+		return "."
+	}
+	return dataPath
+}
+
+// dataPathSize returns the number of bytes used by diskqueue files (including
+// files renamed as .bad) in the given directory, in total and by backend name
+func dataPathSize(dataPath string) (int64, map[string]int64, error) {
+	entries, err := os.ReadDir(dataPath)
+	if err != nil {
+		return 0, nil, err
+	}
+	var size int64
+	queueSizes := make(map[string]int64)
+	for _, entry := range entries {
+		i := strings.Index(entry.Name(), ".diskqueue.")
+		if entry.IsDir() || i == -1 {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			// the file was removed while we were walking the directory
+			continue
+		}
+		size += info.Size()
+		queueSizes[entry.Name()[:i]] += info.Size()
+	}
+	return size, queueSizes, nil
+}
+
+func (n *NSQD) isDiskFull() bool {
+	return atomic.LoadInt32(&n.diskFull) == 1
+}
+
+// checkDiskSpace measures the data path and the free space on the underlying
+// filesystem and updates the disk full state accordingly
+func (n *NSQD) checkDiskSpace() {
+	opts := n.getOpts()
+	dataPath := n.dataPath()
+
+	var usage diskUsage
+	var err error
+
+	var queueSizes map[string]int64
+	usage.dataPathBytes, queueSizes, err = dataPathSize(dataPath)
+	if err != nil {
+		n.logf(LOG_ERROR, "DISKSPACE: failed to measure data path %s - %s", dataPath, err)
+	} else {
+		n.queueSizes.Store(queueSizes)
+	}
+	usage.freeBytes, err = diskFree(dataPath)
+	if err != nil {
+		usage.freeBytes = -1
+		if opts.MinFreeDiskBytes > 0 {
+			n.logf(LOG_ERROR, "DISKSPACE: failed to get free disk space of %s - %s", dataPath, err)
+		}
+	}
+
+	if opts.MaxBytesDataPath > 0 && usage.dataPathBytes >= opts.MaxBytesDataPath {
+		usage.err = fmt.Errorf("%s - data path uses %d bytes (max %d)",
+			errDiskFull, usage.dataPathBytes, opts.MaxBytesDataPath)
+	} else if opts.MinFreeDiskBytes > 0 && usage.freeBytes >= 0 && usage.freeBytes < opts.MinFreeDiskBytes {
+		usage.err = fmt.Errorf("%s - %d bytes free (min %d)",
+			errDiskFull, usage.freeBytes, opts.MinFreeDiskBytes)
+	}
+	n.diskUsage.Store(usage)
+
+	if usage.err != nil {
+		if atomic.CompareAndSwapInt32(&n.diskFull, 0, 1) {
+			n.logf(LOG_WARN, "DISKSPACE: %s, applying policy %s", usage.err, opts.DiskFullPolicy)
+		}
+	} else {
+		if atomic.CompareAndSwapInt32(&n.diskFull, 1, 0) {
+			n.logf(LOG_INFO, "DISKSPACE: disk space recovered")
+		}
+	}
+}
+
+func (n *NSQD) getDiskUsage() diskUsage {
+	usage, _ := n.diskUsage.Load().(diskUsage)
+	return usage
+}
+
+// GetDiskStats returns the most recent disk space measurement
+func (n *NSQD) GetDiskStats() DiskStats {
+	opts := n.getOpts()
+	usage := n.getDiskUsage()
+	return DiskStats{
+		DataPathBytes:    usage.dataPathBytes,
+		MaxBytesDataPath: opts.MaxBytesDataPath,
+		FreeBytes:        usage.freeBytes,
+		MinFreeBytes:     opts.MinFreeDiskBytes,
+		Full:             n.isDiskFull(),
+		Policy:           opts.DiskFullPolicy,
+		RejectCount:      atomic.LoadUint64(&n.diskRejectCount),
+		DropCount:        atomic.LoadUint64(&n.diskDropCount),
+	}
+}
+
+// diskSpaceLoop periodically checks the data path against --max-bytes-data-path
+// and --min-free-disk-bytes
+func (n *NSQD) diskSpaceLoop() {
+	n.checkDiskSpace()
+
+	ticker := time.NewTicker(n.getOpts().DiskCheckInterval)
+	for {
+		select {
+		case <-ticker.C:
+			n.checkDiskSpace()
+		case <-n.exitChan:
+			goto exit
+		}
+	}
+
+exit:
+	n.logf(LOG_INFO, "DISKSPACE: closing")
+	ticker.Stop()
+}
+
+// rejectDiskFull accounts for messages refused because the disk is full
+func (n *NSQD) rejectDiskFull(count int) error {
+	atomic.AddUint64(&n.diskRejectCount, uint64(count))
+	return errDiskFull
+}
+
+// dropOldestOnDiskFull makes room for a new message of (about) size bytes in a
+// backend by discarding its oldest messages, as many bytes of them as the new
+// one takes, when the disk is full under the drop-oldest policy.
+//
+// A diskqueue file is removed once all of its messages have been read, so the
+// queue does not grow while the disk is full and the space of its oldest file
+// is reclaimed once that file has been dropped entirely. The backend is read
+// concurrently by the message pumps and the diskqueue only hands out its next
+// message when it is not busy with a write, so this never waits.
+func (n *NSQD) dropOldestOnDiskFull(backend BackendQueue, u *backendUsage, size int64) {
+	if !n.isDiskFull() || n.getOpts().DiskFullPolicy != DiskFullDropOldest {
+		return
+	}
+	for dropped := int64(0); dropped < size && u.depth() > 0; {
+		select {
+		case b := <-backend.ReadChan():
+			u.read(b)
+			dropped += int64(len(b)) + 4
+			atomic.AddUint64(&n.diskDropCount, 1)
+		default:
+			return
+		}
+	}
+}
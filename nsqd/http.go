@@ -60,6 +60,7 @@ func newHTTPServer(nsqd *NSQD, tlsEnabled bool, tlsRequired bool) *httpServer {
 	// v1 negotiate
 	router.Handle("POST", "/pub", http_api.Decorate(s.doPUB, http_api.V1))
 	router.Handle("POST", "/mpub", http_api.Decorate(s.doMPUB, http_api.V1))
+	router.Handle("POST", "/pubr", http_api.Decorate(s.doPUBR, http_api.V1))
 	router.Handle("GET", "/stats", http_api.Decorate(s.doStats, log, http_api.V1))
 
 	// only v1
@@ -73,6 +74,7 @@ func newHTTPServer(nsqd *NSQD, tlsEnabled bool, tlsRequired bool) *httpServer {
 	router.Handle("POST", "/channel/empty", http_api.Decorate(s.doEmptyChannel, log, http_api.V1))
 	router.Handle("POST", "/channel/pause", http_api.Decorate(s.doPauseChannel, log, http_api.V1))
 	router.Handle("POST", "/channel/unpause", http_api.Decorate(s.doPauseChannel, log, http_api.V1))
+	router.Handle("GET", "/channel/peek", http_api.Decorate(s.doPeekChannel, log, http_api.V1))
 	router.Handle("GET", "/config/:opt", http_api.Decorate(s.doConfig, log, http_api.V1))
 	router.Handle("PUT", "/config/:opt", http_api.Decorate(s.doConfig, log, http_api.V1))
 
@@ -131,12 +133,22 @@ func (s *httpServer) doInfo(w http.ResponseWriter, req *http.Request, ps httprou
 	if err != nil {
 		return nil, http_api.Err{500, err.Error()}
 	}
-	tcpAddr, ok := s.nsqd.RealTCPAddr().(*net.TCPAddr)
-	if !ok {
+	var tcpPort, httpPort int
+	var tcpSocket, httpSocket string
+	switch addr := s.nsqd.RealTCPAddr().(type) {
+	case *net.TCPAddr:
+		tcpPort = addr.Port
+	case *net.UnixAddr:
+		tcpSocket = addr.Name
+	default:
 		return nil, http_api.Err{500, "failed to cast to TCPAddr"}
 	}
-	httpAddr, ok := s.nsqd.RealHTTPAddr().(*net.TCPAddr)
-	if !ok {
+	switch addr := s.nsqd.RealHTTPAddr().(type) {
+	case *net.TCPAddr:
+		httpPort = addr.Port
+	case *net.UnixAddr:
+		httpSocket = addr.Name
+	default:
 		return nil, http_api.Err{500, "failed to cast to TCPAddr"}
 	}
 	return struct {
@@ -145,22 +157,30 @@ func (s *httpServer) doInfo(w http.ResponseWriter, req *http.Request, ps httprou
 		Hostname             string        `json:"hostname"`
 		HTTPPort             int           `json:"http_port"`
 		TCPPort              int           `json:"tcp_port"`
+		HTTPSocket           string        `json:"http_socket,omitempty"`
+		TCPSocket            string        `json:"tcp_socket,omitempty"`
 		StartTime            int64         `json:"start_time"`
 		MaxHeartBeatInterval time.Duration `json:"max_heartbeat_interval"`
 		MaxOutBufferSize     int64         `json:"max_output_buffer_size"`
 		MaxOutBufferTimeout  time.Duration `json:"max_output_buffer_timeout"`
 		MaxDeflateLevel      int           `json:"max_deflate_level"`
+		MaxZstdLevel         int           `json:"max_zstd_level"`
+		Disk                 DiskStats     `json:"disk"`
 	}{
 		Version:              version.Binary,
 		BroadcastAddress:     s.nsqd.getOpts().BroadcastAddress,
 		Hostname:             hostname,
-		TCPPort:              tcpAddr.Port,
-		HTTPPort:             httpAddr.Port,
+		TCPPort:              tcpPort,
+		HTTPPort:             httpPort,
+		HTTPSocket:           httpSocket,
+		TCPSocket:            tcpSocket,
 		StartTime:            s.nsqd.GetStartTime().Unix(),
 		MaxHeartBeatInterval: s.nsqd.getOpts().MaxHeartbeatInterval,
 		MaxOutBufferSize:     s.nsqd.getOpts().MaxOutputBufferSize,
 		MaxOutBufferTimeout:  s.nsqd.getOpts().MaxOutputBufferTimeout,
 		MaxDeflateLevel:      s.nsqd.getOpts().MaxDeflateLevel,
+		MaxZstdLevel:         s.nsqd.getOpts().MaxZstdLevel,
+		Disk:                 s.nsqd.GetDiskStats(),
 	}, nil
 }
 
@@ -201,10 +221,47 @@ func (s *httpServer) getTopicFromQuery(req *http.Request) (url.Values, *Topic, e
 		return nil, nil, http_api.Err{400, "INVALID_TOPIC"}
 	}
 
-	return reqParams, s.nsqd.GetTopic(topicName), nil
+	topic, err := s.nsqd.getPublishTopic(topicName)
+	if err != nil {
+		return nil, nil, http_api.Err{404, "REPLY_TOPIC_NOT_FOUND"}
+	}
+
+	return reqParams, topic, nil
 }
 
-func (s *httpServer) doPUB(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+// getPartitionKey returns the optional partition key of a publish
+func getPartitionKey(reqParams url.Values) (string, error) {
+	keys, ok := reqParams["key"]
+	if !ok {
+		return "", nil
+	}
+	if !isValidPartitionKey(keys[0]) {
+		return "", http_api.Err{400, "INVALID_PARTITION_KEY"}
+	}
+	return keys[0], nil
+}
+
+// getReplyHeaders returns the optional reply_to and correlation_id params of
+// a publish, a request (with reply_to) needs a correlation ID
+func getReplyHeaders(reqParams url.Values) (string, string, error) {
+	replyTo := reqParams.Get("reply_to")
+	if replyTo != "" && !protocol.IsValidTopicName(replyTo) {
+		return "", "", http_api.Err{400, "INVALID_REPLY_TO"}
+	}
+	correlationID := reqParams.Get("correlation_id")
+	if correlationID == "" {
+		if replyTo != "" {
+			return "", "", http_api.Err{400, "MISSING_ARG_CORRELATION_ID"}
+		}
+		return "", "", nil
+	}
+	if !isValidCorrelationID(correlationID) {
+		return "", "", http_api.Err{400, "INVALID_CORRELATION_ID"}
+	}
+	return replyTo, correlationID, nil
+}
+
+func (s *httpServer) readMessageBody(req *http.Request) ([]byte, error) {
 	// TODO: one day I'd really like to just error on chunked requests
 	// to be able to fail "too big" requests before we even read
 
@@ -225,6 +282,14 @@ func (s *httpServer) doPUB(w http.ResponseWriter, req *http.Request, ps httprout
 	if len(body) == 0 {
 		return nil, http_api.Err{400, "MSG_EMPTY"}
 	}
+	return body, nil
+}
+
+func (s *httpServer) doPUB(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	body, err := s.readMessageBody(req)
+	if err != nil {
+		return nil, err
+	}
 
 	reqParams, topic, err := s.getTopicFromQuery(req)
 	if err != nil {
@@ -244,9 +309,32 @@ func (s *httpServer) doPUB(w http.ResponseWriter, req *http.Request, ps httprout
 		}
 	}
 
+	partitionKey, err := getPartitionKey(reqParams)
+	if err != nil {
+		return nil, err
+	}
+
+	replyTo, correlationID, err := getReplyHeaders(reqParams)
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.nsqd.allowPublish(topic, "", 1, int64(len(body))) {
+		return nil, http_api.Err{429, "RATE_LIMITED"}
+	}
+
 	msg := NewMessage(topic.GenerateID(), body)
+	msg.PartitionKey = partitionKey
+	msg.ReplyTo = replyTo
+	msg.CorrelationID = correlationID
 	msg.deferred = deferred
 	err = topic.PutMessage(msg)
+	if err == errDiskFull {
+		return nil, http_api.Err{507, "DISK_FULL"}
+	}
+	if err == errDepthExceeded {
+		return nil, http_api.Err{507, "DEPTH_EXCEEDED"}
+	}
 	if err != nil {
 		return nil, http_api.Err{503, "EXITING"}
 	}
@@ -254,6 +342,74 @@ func (s *httpServer) doPUB(w http.ResponseWriter, req *http.Request, ps httprout
 	return "OK", nil
 }
 
+// doPUBR publishes a request and responds with the body of its reply, which
+// responders publish (with the request's correlation ID) to the reply topic of
+// this nsqd given in the request's reply_to; the reply has to be published to
+// this nsqd, other nsqd reject it as REPLY_TOPIC_NOT_FOUND
+func (s *httpServer) doPUBR(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	body, err := s.readMessageBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	reqParams, topic, err := s.getTopicFromQuery(req)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := defaultPubrTimeout
+	if ts, ok := reqParams["timeout"]; ok {
+		ti, err := strconv.ParseInt(ts[0], 10, 64)
+		if err != nil {
+			return nil, http_api.Err{400, "INVALID_TIMEOUT"}
+		}
+		timeout = time.Duration(ti) * time.Millisecond
+		if timeout <= 0 || timeout > s.nsqd.getOpts().MaxMsgTimeout {
+			return nil, http_api.Err{400, "INVALID_TIMEOUT"}
+		}
+	}
+
+	partitionKey, err := getPartitionKey(reqParams)
+	if err != nil {
+		return nil, err
+	}
+
+	if !s.nsqd.allowPublish(topic, "", 1, int64(len(body))) {
+		return nil, http_api.Err{429, "RATE_LIMITED"}
+	}
+
+	replyTopic := s.nsqd.GetTopic(s.nsqd.httpReplyTopicName())
+	msg := NewMessage(topic.GenerateID(), body)
+	msg.PartitionKey = partitionKey
+	msg.ReplyTo = replyTopic.name
+	msg.CorrelationID = string(msg.ID[:])
+
+	replyChan, done := s.nsqd.awaitReply(msg.CorrelationID)
+	defer done()
+
+	err = topic.PutMessage(msg)
+	if err == errDiskFull {
+		return nil, http_api.Err{507, "DISK_FULL"}
+	}
+	if err == errDepthExceeded {
+		return nil, http_api.Err{507, "DEPTH_EXCEEDED"}
+	}
+	if err != nil {
+		return nil, http_api.Err{503, "EXITING"}
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case reply := <-replyChan:
+		return reply.Body, nil
+	case <-timer.C:
+		return nil, http_api.Err{504, "REPLY_TIMEOUT"}
+	case <-s.nsqd.exitChan:
+		return nil, http_api.Err{503, "EXITING"}
+	}
+}
+
 func (s *httpServer) doMPUB(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
 	var msgs []*Message
 	var exit bool
@@ -324,7 +480,25 @@ func (s *httpServer) doMPUB(w http.ResponseWriter, req *http.Request, ps httprou
 		}
 	}
 
+	partitionKey, err := getPartitionKey(reqParams)
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range msgs {
+		msg.PartitionKey = partitionKey
+	}
+
+	if !s.nsqd.allowPublish(topic, "", len(msgs), messagesSize(msgs)) {
+		return nil, http_api.Err{429, "RATE_LIMITED"}
+	}
+
 	err = topic.PutMessages(msgs)
+	if err == errDiskFull {
+		return nil, http_api.Err{507, "DISK_FULL"}
+	}
+	if err == errDepthExceeded {
+		return nil, http_api.Err{507, "DEPTH_EXCEEDED"}
+	}
 	if err != nil {
 		return nil, http_api.Err{503, "EXITING"}
 	}
@@ -449,6 +623,62 @@ func (s *httpServer) doEmptyChannel(w http.ResponseWriter, req *http.Request, ps
 	return nil, nil
 }
 
+// PeekedMessage is a message returned by /channel/peek
+type PeekedMessage struct {
+	ID            string `json:"id"`
+	Body          []byte `json:"body"`
+	Timestamp     int64  `json:"timestamp"`
+	Attempts      uint16 `json:"attempts"`
+	PartitionKey  string `json:"partition_key,omitempty"`
+	ReplyTo       string `json:"reply_to,omitempty"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+func (s *httpServer) doPeekChannel(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	reqParams, topic, channelName, err := s.getExistingTopicFromQuery(req)
+	if err != nil {
+		return nil, err
+	}
+
+	channel, err := topic.GetExistingChannel(channelName)
+	if err != nil {
+		return nil, http_api.Err{404, "CHANNEL_NOT_FOUND"}
+	}
+
+	count := 10
+	if cs, err := reqParams.Get("count"); err == nil {
+		count, err = strconv.Atoi(cs)
+		if err != nil || count <= 0 || count > maxPeekCount {
+			return nil, http_api.Err{400, "INVALID_ARG_COUNT"}
+		}
+	}
+
+	msgs, inMemory, err := channel.PeekMessages(count)
+	if err != nil {
+		s.nsqd.logf(LOG_ERROR, "failed to peek channel %s - %s", channel.name, err)
+		if len(msgs) == 0 {
+			return nil, http_api.Err{500, "INTERNAL_ERROR"}
+		}
+	}
+
+	peeked := make([]PeekedMessage, 0, len(msgs))
+	for _, m := range msgs {
+		peeked = append(peeked, PeekedMessage{
+			ID:            string(m.ID[:]),
+			Body:          m.Body,
+			Timestamp:     m.Timestamp,
+			Attempts:      m.Attempts,
+			PartitionKey:  m.PartitionKey,
+			ReplyTo:       m.ReplyTo,
+			CorrelationID: m.CorrelationID,
+		})
+	}
+	return struct {
+		Messages []PeekedMessage `json:"messages"`
+		InMemory int             `json:"in_memory"`
+	}{peeked, inMemory}, nil
+}
+
 func (s *httpServer) doDeleteChannel(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
 	_, topic, channelName, err := s.getExistingTopicFromQuery(req)
 	if err != nil {
@@ -516,6 +746,7 @@ func (s *httpServer) doStats(w http.ResponseWriter, req *http.Request, ps httpro
 
 	stats := s.nsqd.GetStats(topicName, channelName, includeClients)
 	health := s.nsqd.GetHealth()
+	disk := s.nsqd.GetDiskStats()
 	startTime := s.nsqd.GetStartTime()
 	uptime := time.Since(startTime)
 
@@ -525,7 +756,7 @@ func (s *httpServer) doStats(w http.ResponseWriter, req *http.Request, ps httpro
 		ms = &m
 	}
 	if !jsonFormat {
-		return s.printStats(stats, ms, health, startTime, uptime), nil
+		return s.printStats(stats, ms, health, disk, startTime, uptime), nil
 	}
 
 	// TODO: should producer stats be hung off topics?
@@ -536,10 +767,11 @@ func (s *httpServer) doStats(w http.ResponseWriter, req *http.Request, ps httpro
 		Topics    []TopicStats  `json:"topics"`
 		Memory    *memStats     `json:"memory,omitempty"`
 		Producers []ClientStats `json:"producers"`
-	}{version.Binary, health, startTime.Unix(), stats.Topics, ms, stats.Producers}, nil
+		Disk      DiskStats     `json:"disk"`
+	}{version.Binary, health, startTime.Unix(), stats.Topics, ms, stats.Producers, disk}, nil
 }
 
-func (s *httpServer) printStats(stats Stats, ms *memStats, health string, startTime time.Time, uptime time.Duration) []byte {
+func (s *httpServer) printStats(stats Stats, ms *memStats, health string, disk DiskStats, startTime time.Time, uptime time.Duration) []byte {
 	var buf bytes.Buffer
 	w := &buf
 
@@ -549,6 +781,16 @@ func (s *httpServer) printStats(stats Stats, ms *memStats, health string, startT
 
 	fmt.Fprintf(w, "\nHealth: %s\n", health)
 
+	fmt.Fprintf(w, "\nDisk:\n")
+	fmt.Fprintf(w, "   %-25s\t%t\n", "full", disk.Full)
+	fmt.Fprintf(w, "   %-25s\t%s\n", "policy", disk.Policy)
+	fmt.Fprintf(w, "   %-25s\t%d\n", "data_path_bytes", disk.DataPathBytes)
+	fmt.Fprintf(w, "   %-25s\t%d\n", "max_bytes_data_path", disk.MaxBytesDataPath)
+	fmt.Fprintf(w, "   %-25s\t%d\n", "free_bytes", disk.FreeBytes)
+	fmt.Fprintf(w, "   %-25s\t%d\n", "min_free_bytes", disk.MinFreeBytes)
+	fmt.Fprintf(w, "   %-25s\t%d\n", "reject_count", disk.RejectCount)
+	fmt.Fprintf(w, "   %-25s\t%d\n", "drop_count", disk.DropCount)
+
 	if ms != nil {
 		fmt.Fprintf(w, "\nMemory:\n")
 		fmt.Fprintf(w, "   %-25s\t%d\n", "heap_objects", ms.HeapObjects)
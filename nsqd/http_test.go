@@ -8,7 +8,9 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
@@ -936,3 +938,248 @@ func BenchmarkHTTPpub(b *testing.B) {
 	b.StopTimer()
 	nsqd.Exit()
 }
+
+func TestHTTPpubDiskFull(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.MemQueueSize = 0
+	opts.MaxBytesDataPath = 1
+	opts.DiskCheckInterval = time.Hour
+	_, httpAddr, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	fn := filepath.Join(opts.DataPath, "filler.diskqueue.000000.dat")
+	err := os.WriteFile(fn, []byte("filler"), 0600)
+	test.Nil(t, err)
+	nsqd.checkDiskSpace()
+
+	buf := bytes.NewBuffer([]byte("test message"))
+	url := fmt.Sprintf("http://%s/pub?topic=http_pub_disk_full", httpAddr)
+	resp, err := http.Post(url, "application/octet-stream", buf)
+	test.Nil(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	test.Equal(t, 507, resp.StatusCode)
+	test.Equal(t, `{"message":"DISK_FULL"}`, string(body))
+
+	resp, err = http.Get(fmt.Sprintf("http://%s/ping", httpAddr))
+	test.Nil(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	test.Equal(t, 500, resp.StatusCode)
+	test.Equal(t, true, strings.HasPrefix(string(body), "NOK - disk space exhausted"))
+
+	var stats struct {
+		Disk DiskStats `json:"disk"`
+	}
+	resp, err = http.Get(fmt.Sprintf("http://%s/stats?format=json", httpAddr))
+	test.Nil(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	err = json.Unmarshal(body, &stats)
+	test.Nil(t, err)
+	test.Equal(t, true, stats.Disk.Full)
+	test.Equal(t, DiskFullReject, stats.Disk.Policy)
+	test.Equal(t, uint64(1), stats.Disk.RejectCount)
+}
+
+func TestHTTPpubRateLimit(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.PubByteRateLimit = 10
+	_, httpAddr, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	topicName := "test_http_pub_rate_limit" + strconv.Itoa(int(time.Now().Unix()))
+	url := fmt.Sprintf("http://%s/pub?topic=%s", httpAddr, topicName)
+
+	// invalid requests do not count
+	resp, err := http.Post(url+"&reply_to=%21", "application/octet-stream", bytes.NewBufferString("test message"))
+	test.Nil(t, err)
+	resp.Body.Close()
+	test.Equal(t, 400, resp.StatusCode)
+
+	// a message larger than the burst is admitted and leaves the bucket in debt
+	resp, err = http.Post(url, "application/octet-stream", bytes.NewBufferString("test message"))
+	test.Nil(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	test.Equal(t, 200, resp.StatusCode)
+	test.Equal(t, "OK", string(body))
+
+	resp, err = http.Post(url, "application/octet-stream", bytes.NewBufferString("test message"))
+	test.Nil(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	test.Equal(t, 429, resp.StatusCode)
+	test.Equal(t, `{"message":"RATE_LIMITED"}`, string(body))
+
+	topic, _ := nsqd.GetExistingTopic(topicName)
+	test.Equal(t, int64(1), topic.Depth())
+	test.Equal(t, uint64(1), NewTopicStats(topic, nil).RateLimitedCount)
+}
+
+func TestHTTPpubPartitionKey(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	_, httpAddr, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	topicName := "test_http_pub_partition_key" + strconv.Itoa(int(time.Now().Unix()))
+	url := fmt.Sprintf("http://%s/pub?topic=%s&key=customer-42", httpAddr, topicName)
+	resp, err := http.Post(url, "application/octet-stream", bytes.NewBufferString("test message"))
+	test.Nil(t, err)
+	resp.Body.Close()
+	test.Equal(t, 200, resp.StatusCode)
+
+	topic, _ := nsqd.GetExistingTopic(topicName)
+	msg := <-topic.memoryMsgChan
+	test.Equal(t, "customer-42", msg.PartitionKey)
+
+	url = fmt.Sprintf("http://%s/mpub?topic=%s&key=", httpAddr, topicName)
+	resp, err = http.Post(url, "application/octet-stream", bytes.NewBufferString("test message"))
+	test.Nil(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	test.Equal(t, 400, resp.StatusCode)
+	test.Equal(t, `{"message":"INVALID_PARTITION_KEY"}`, string(body))
+}
+
+func TestHTTPpubr(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	_, httpAddr, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	topicName := "test_http_pubr" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+
+	type reply struct {
+		code int
+		body string
+	}
+	replies := make(chan reply)
+	go func() {
+		endpoint := fmt.Sprintf("http://%s/pubr?topic=%s&timeout=5000", httpAddr, topicName)
+		resp, err := http.Post(endpoint, "application/octet-stream", bytes.NewBufferString("ping"))
+		if err != nil {
+			replies <- reply{body: err.Error()}
+			return
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		replies <- reply{resp.StatusCode, string(body)}
+	}()
+
+	// a responder sees the request's reply headers and publishes its reply to
+	// the reply topic with the request's correlation ID
+	msg := <-topic.memoryMsgChan
+	test.Equal(t, []byte("ping"), msg.Body)
+	test.Equal(t, nsqd.httpReplyTopicName(), msg.ReplyTo)
+	test.Equal(t, string(msg.ID[:]), msg.CorrelationID)
+
+	replyTo := url.QueryEscape(msg.ReplyTo)
+	endpoint := fmt.Sprintf("http://%s/pub?topic=%s&correlation_id=unknown", httpAddr, replyTo)
+	resp, err := http.Post(endpoint, "application/octet-stream", bytes.NewBufferString("ignored"))
+	test.Nil(t, err)
+	resp.Body.Close()
+	test.Equal(t, 200, resp.StatusCode)
+
+	endpoint = fmt.Sprintf("http://%s/pub?topic=%s&correlation_id=%s", httpAddr, replyTo, msg.CorrelationID)
+	resp, err = http.Post(endpoint, "application/octet-stream", bytes.NewBufferString("pong"))
+	test.Nil(t, err)
+	resp.Body.Close()
+	test.Equal(t, 200, resp.StatusCode)
+
+	r := <-replies
+	test.Equal(t, 200, r.code)
+	test.Equal(t, "pong", r.body)
+
+	endpoint = fmt.Sprintf("http://%s/pubr?topic=%s&timeout=50", httpAddr, topicName)
+	resp, err = http.Post(endpoint, "application/octet-stream", bytes.NewBufferString("ping"))
+	test.Nil(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	test.Equal(t, 504, resp.StatusCode)
+	test.Equal(t, `{"message":"REPLY_TIMEOUT"}`, string(body))
+
+	endpoint = fmt.Sprintf("http://%s/pub?topic=%s&reply_to=%s", httpAddr, topicName, replyTo)
+	resp, err = http.Post(endpoint, "application/octet-stream", bytes.NewBufferString("ping"))
+	test.Nil(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	test.Equal(t, 400, resp.StatusCode)
+	test.Equal(t, `{"message":"MISSING_ARG_CORRELATION_ID"}`, string(body))
+
+	// replies to the reply topic of another nsqd are rejected
+	replyTo = url.QueryEscape(fmt.Sprintf("reply-%d-http-%s#ephemeral", opts.ID+1, nsqd.httpReplyToken))
+	endpoint = fmt.Sprintf("http://%s/pub?topic=%s&correlation_id=%s", httpAddr, replyTo, msg.CorrelationID)
+	resp, err = http.Post(endpoint, "application/octet-stream", bytes.NewBufferString("pong"))
+	test.Nil(t, err)
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	test.Equal(t, 404, resp.StatusCode)
+	test.Equal(t, `{"message":"REPLY_TOPIC_NOT_FOUND"}`, string(body))
+}
+
+func TestHTTPPeekChannel(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.MemQueueSize = 2
+	_, httpAddr, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	topicName := "test_http_peek_channel" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	channel := topic.GetChannel("ch")
+	// 2 in memory, 3 on disk
+	for i := 0; i < 5; i++ {
+		err := channel.PutMessage(NewMessage(topic.GenerateID(), []byte("test body "+strconv.Itoa(i))))
+		test.Nil(t, err)
+	}
+
+	peek := func() ([]PeekedMessage, int) {
+		var doc struct {
+			Messages []PeekedMessage `json:"messages"`
+			InMemory int             `json:"in_memory"`
+		}
+		url := fmt.Sprintf("http://%s/channel/peek?topic=%s&channel=ch&count=4", httpAddr, topicName)
+		resp, err := http.Get(url)
+		test.Nil(t, err)
+		test.Equal(t, 200, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		err = json.Unmarshal(body, &doc)
+		test.Nil(t, err)
+		return doc.Messages, doc.InMemory
+	}
+
+	// the messages in memory are left out unless the channel is paused
+	msgs, inMemory := peek()
+	test.Equal(t, 3, len(msgs))
+	test.Equal(t, 2, inMemory)
+	test.Equal(t, "test body 2", string(msgs[0].Body))
+
+	channel.Pause()
+	for i := 0; i < 2; i++ {
+		msgs, inMemory = peek()
+		test.Equal(t, 4, len(msgs))
+		test.Equal(t, 0, inMemory)
+		test.Equal(t, "test body 0", string(msgs[0].Body))
+		test.Equal(t, "test body 1", string(msgs[1].Body))
+		test.Equal(t, "test body 2", string(msgs[2].Body))
+	}
+	test.Equal(t, int64(2), int64(len(channel.memoryMsgChan)))
+	test.Equal(t, int64(5), channel.Depth())
+
+	url := fmt.Sprintf("http://%s/channel/peek?topic=%s&channel=ch&count=1000", httpAddr, topicName)
+	resp, err := http.Get(url)
+	test.Nil(t, err)
+	resp.Body.Close()
+	test.Equal(t, 400, resp.StatusCode)
+}
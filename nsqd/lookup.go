@@ -3,12 +3,15 @@ package nsqd
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/nsqio/go-nsq"
+	"github.com/nsqio/nsq/internal/protocol"
 	"github.com/nsqio/nsq/internal/version"
 )
 
@@ -20,6 +23,18 @@ func connectCallback(n *NSQD, hostname string) func(*lookupPeer) {
 		ci["http_port"] = n.getOpts().BroadcastHTTPPort
 		ci["hostname"] = hostname
 		ci["broadcast_address"] = n.getOpts().BroadcastAddress
+		if addr, ok := n.RealTCPAddr().(*net.UnixAddr); ok {
+			ci["tcp_socket"] = addr.Name
+		}
+		if addr, ok := n.RealHTTPAddr().(*net.UnixAddr); ok {
+			ci["http_socket"] = addr.Name
+		}
+		if len(n.labels) > 0 {
+			ci["labels"] = n.labels
+		}
+		if secret := n.getOpts().LookupdAuthSecret; secret != "" {
+			ci["auth_secret"] = secret
+		}
 
 		cmd, err := nsq.Identify(ci)
 		if err != nil {
@@ -31,7 +46,7 @@ func connectCallback(n *NSQD, hostname string) func(*lookupPeer) {
 		if err != nil {
 			n.logf(LOG_ERROR, "LOOKUPD(%s): %s - %s", lp, cmd, err)
 			return
-		} else if bytes.Equal(resp, []byte("E_INVALID")) {
+		} else if bytes.HasPrefix(resp, []byte("E_")) {
 			n.logf(LOG_INFO, "LOOKUPD(%s): lookupd returned %s", lp, resp)
 			lp.Close()
 			return
@@ -72,6 +87,55 @@ func connectCallback(n *NSQD, hostname string) func(*lookupPeer) {
 				return
 			}
 		}
+
+		if n.getOpts().ProvisionFromLookupd {
+			n.provisionFromLookupd(lp)
+		}
+	}
+}
+
+// provisionFromLookupd creates the topics and channels that were created on
+// lookupd for this nsqd
+func (n *NSQD) provisionFromLookupd(lp *lookupPeer) {
+	if !lp.Info.Provision {
+		return
+	}
+
+	cmd := &nsq.Command{Name: []byte("PROVISION")}
+	resp, err := lp.Command(cmd)
+	if err != nil {
+		n.logf(LOG_ERROR, "LOOKUPD(%s): %s - %s", lp, cmd, err)
+		return
+	}
+
+	var provision struct {
+		Topics map[string][]string `json:"topics"`
+	}
+	err = json.Unmarshal(resp, &provision)
+	if err != nil {
+		n.logf(LOG_ERROR, "LOOKUPD(%s): parsing response - %s", lp, resp)
+		return
+	}
+
+	for topicName, channelNames := range provision.Topics {
+		if !protocol.IsValidTopicName(topicName) || strings.HasSuffix(topicName, "#ephemeral") {
+			continue
+		}
+		topic, err := n.GetExistingTopic(topicName)
+		if err != nil {
+			n.logf(LOG_INFO, "LOOKUPD(%s): provisioning topic(%s)", lp, topicName)
+			topic = n.GetTopic(topicName)
+		}
+		for _, channelName := range channelNames {
+			if !protocol.IsValidChannelName(channelName) || strings.HasSuffix(channelName, "#ephemeral") {
+				continue
+			}
+			_, err := topic.GetExistingChannel(channelName)
+			if err != nil {
+				n.logf(LOG_INFO, "LOOKUPD(%s): provisioning channel(%s) in topic(%s)", lp, channelName, topicName)
+				topic.GetChannel(channelName)
+			}
+		}
 	}
 }
 
@@ -96,7 +160,7 @@ func (n *NSQD) lookupLoop() {
 					continue
 				}
 				n.logf(LOG_INFO, "LOOKUP(%s): adding peer", host)
-				lookupPeer := newLookupPeer(host, n.getOpts().MaxBodySize, n.logf,
+				lookupPeer := newLookupPeer(host, n.getOpts().MaxBodySize, n.lookupdTLSConfig, n.logf,
 					connectCallback(n, hostname))
 				lookupPeer.Command(nil) // start the connection
 				lookupPeers = append(lookupPeers, lookupPeer)
@@ -116,6 +180,9 @@ func (n *NSQD) lookupLoop() {
 				if err != nil {
 					n.logf(LOG_ERROR, "LOOKUPD(%s): %s - %s", lookupPeer, cmd, err)
 				}
+				if n.getOpts().ProvisionFromLookupd {
+					n.provisionFromLookupd(lookupPeer)
+				}
 			}
 		case val := <-n.notifyChan:
 			var cmd *nsq.Command
@@ -173,6 +240,34 @@ exit:
 	n.logf(LOG_INFO, "LOOKUP: closing")
 }
 
+// parseLabels parses --label values of the form <key>=<value>
+func parseLabels(values []string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, v := range values {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 || !isValidLabelKey(kv[0]) {
+			return nil, fmt.Errorf("invalid label %q (<key>=<value>)", v)
+		}
+		labels[kv[0]] = kv[1]
+	}
+	return labels, nil
+}
+
+func isValidLabelKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-', r == '_', r == '.', r == '/':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
 func in(s string, lst []string) bool {
 	for _, v := range lst {
 		if s == v {
@@ -189,7 +284,8 @@ func (n *NSQD) lookupdHTTPAddrs() []string {
 		return nil
 	}
 	for _, lp := range lookupPeers.([]*lookupPeer) {
-		if len(lp.Info.BroadcastAddress) <= 0 {
+		// lookupds listening on a UNIX socket have no HTTP port
+		if len(lp.Info.BroadcastAddress) <= 0 || lp.Info.HTTPPort == 0 {
 			continue
 		}
 		addr := net.JoinHostPort(lp.Info.BroadcastAddress, strconv.Itoa(lp.Info.HTTPPort))
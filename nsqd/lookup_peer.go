@@ -1,10 +1,12 @@
 package nsqd
 
 import (
+	"crypto/tls"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/nsqio/go-nsq"
@@ -19,6 +21,7 @@ import (
 type lookupPeer struct {
 	logf            lg.AppLogFunc
 	addr            string
+	tlsConfig       *tls.Config
 	conn            net.Conn
 	state           int32
 	connectCallback func(*lookupPeer)
@@ -30,17 +33,22 @@ type lookupPeer struct {
 type peerInfo struct {
 	TCPPort          int    `json:"tcp_port"`
 	HTTPPort         int    `json:"http_port"`
+	TCPSocket        string `json:"tcp_socket"`
+	HTTPSocket       string `json:"http_socket"`
 	Version          string `json:"version"`
 	BroadcastAddress string `json:"broadcast_address"`
+	Provision        bool   `json:"provision"`
 }
 
 // newLookupPeer creates a new lookupPeer instance connecting to the supplied address.
 //
 // The supplied connectCallback will be called *every* time the instance connects.
-func newLookupPeer(addr string, maxBodySize int64, l lg.AppLogFunc, connectCallback func(*lookupPeer)) *lookupPeer {
+// TCP connections use TLS when tlsConfig is not nil.
+func newLookupPeer(addr string, maxBodySize int64, tlsConfig *tls.Config, l lg.AppLogFunc, connectCallback func(*lookupPeer)) *lookupPeer {
 	return &lookupPeer{
 		logf:            l,
 		addr:            addr,
+		tlsConfig:       tlsConfig,
 		state:           stateDisconnected,
 		maxBodySize:     maxBodySize,
 		connectCallback: connectCallback,
@@ -48,12 +56,33 @@ func newLookupPeer(addr string, maxBodySize int64, l lg.AppLogFunc, connectCallb
 }
 
 // Connect will Dial the specified address, with timeouts
+//
+// An address of the form unix:///path/to/socket dials a UNIX socket
 func (lp *lookupPeer) Connect() error {
 	lp.logf(lg.INFO, "LOOKUP connecting to %s", lp.addr)
-	conn, err := net.DialTimeout("tcp", lp.addr, time.Second)
+	network, addr := "tcp", lp.addr
+	if strings.HasPrefix(addr, "unix://") {
+		network, addr = "unix", strings.TrimPrefix(addr, "unix://")
+	}
+	conn, err := net.DialTimeout(network, addr, time.Second)
 	if err != nil {
 		return err
 	}
+	if lp.tlsConfig != nil && network == "tcp" {
+		tlsConfig := lp.tlsConfig.Clone()
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		tlsConn.SetDeadline(time.Now().Add(time.Second))
+		err = tlsConn.Handshake()
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		tlsConn.SetDeadline(time.Time{})
+		conn = tlsConn
+	}
 	lp.conn = conn
 	return nil
 }
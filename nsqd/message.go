@@ -20,6 +20,15 @@ type Message struct {
 	Timestamp int64
 	Attempts  uint16
 
+	// PartitionKey routes the message to a partition of partitioned channels,
+	// it is not sent to consumers
+	PartitionKey string
+
+	// ReplyTo is the topic replies to a request are published to and
+	// CorrelationID ties a reply to its request (see RPUB)
+	ReplyTo       string
+	CorrelationID string
+
 	// for in-flight handling
 	deliveryTS time.Time
 	clientID   int64
@@ -89,12 +98,19 @@ func decodeMessage(b []byte) (*Message, error) {
 	return &msg, nil
 }
 
-func writeMessageToBackend(msg *Message, bq BackendQueue) error {
+// writeMessageToBackend encodes and writes a message to a backend, counting it
+// in the usage of the backend
+func writeMessageToBackend(msg *Message, bq BackendQueue, codec *backendCodec, u *backendUsage) error {
 	buf := bufferPoolGet()
 	defer bufferPoolPut(buf)
-	_, err := msg.WriteTo(buf)
+	record, err := codec.encode(msg, buf)
+	if err != nil {
+		return err
+	}
+	err = bq.Put(record)
 	if err != nil {
 		return err
 	}
-	return bq.Put(buf.Bytes())
+	u.written(record)
+	return nil
 }
@@ -39,6 +39,8 @@ type errStore struct {
 type NSQD struct {
 	// 64bit atomic vars need to be first for proper alignment on 32bit platforms
 	clientIDSequence int64
+	diskRejectCount  uint64
+	diskDropCount    uint64
 
 	sync.RWMutex
 	ctx context.Context
@@ -53,9 +55,14 @@ type NSQD struct {
 	errValue  atomic.Value
 	startTime time.Time
 
+	diskFull   int32
+	diskUsage  atomic.Value
+	queueSizes atomic.Value
+
 	topicMap map[string]*Topic
 
-	lookupPeers atomic.Value
+	lookupPeers      atomic.Value
+	lookupdTLSConfig *tls.Config
 
 	tcpServer     *tcpServer
 	tcpListener   net.Listener
@@ -72,6 +79,25 @@ type NSQD struct {
 
 	wakeup WakeUp
 
+	backendCodec            *backendCodec
+	backendCompression      byte
+	topicBackendCompression map[string]byte
+
+	labels map[string]string
+
+	topicDepthLimits   map[string]depthLimit
+	channelDepthLimits map[string]depthLimit
+
+	channelPartitions map[string]int
+
+	pubRateLimiter     *rateLimiter
+	topicRateLimits    map[string]*rateLimiter
+	identityRateLimits map[string]*rateLimiter
+
+	httpReplyToken  string
+	replyWaitersMtx sync.Mutex
+	replyWaiters    map[string]chan *Message
+
 	ci *clusterinfo.ClusterInfo
 }
 
@@ -113,6 +139,17 @@ func New(opts *Options) (*NSQD, error) {
 		return nil, errors.New("--max-deflate-level must be [1,9]")
 	}
 
+	if opts.MaxZstdLevel < 1 || opts.MaxZstdLevel > 22 {
+		return nil, errors.New("--max-zstd-level must be [1,22]")
+	}
+
+	if err := validateDiskFullPolicy(opts.DiskFullPolicy); err != nil {
+		return nil, err
+	}
+	if err := validateDepthPolicy(opts.DepthPolicy); err != nil {
+		return nil, err
+	}
+
 	if opts.ID < 0 || opts.ID >= 1024 {
 		return nil, errors.New("--node-id must be [0,1024)")
 	}
@@ -130,15 +167,71 @@ func New(opts *Options) (*NSQD, error) {
 	}
 	n.tlsConfig = tlsConfig
 
+	n.lookupdTLSConfig, err = buildLookupdTLSConfig(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build lookupd TLS config - %s", err)
+	}
+
 	for _, v := range opts.E2EProcessingLatencyPercentiles {
 		if v <= 0 || v > 1 {
 			return nil, fmt.Errorf("invalid E2E processing latency percentile: %v", v)
 		}
 	}
 
+	n.backendCodec, err = newBackendCodec(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load --encryption-key-file - %s", err)
+	}
+	n.backendCompression, err = parseBackendCompression(opts.BackendCompression)
+	if err != nil {
+		return nil, err
+	}
+	n.topicBackendCompression, err = parseTopicBackendCompression(opts.TopicBackendCompression)
+	if err != nil {
+		return nil, err
+	}
+	n.labels, err = parseLabels(opts.Labels)
+	if err != nil {
+		return nil, err
+	}
+	n.topicDepthLimits, err = parseDepthLimits("topic-max-depth", opts.TopicMaxDepths, opts.DepthPolicy)
+	if err != nil {
+		return nil, err
+	}
+	n.channelDepthLimits, err = parseDepthLimits("channel-max-depth", opts.ChannelMaxDepths, opts.DepthPolicy)
+	if err != nil {
+		return nil, err
+	}
+	n.channelPartitions, err = parseChannelPartitions(opts.ChannelPartitions)
+	if err != nil {
+		return nil, err
+	}
+	n.pubRateLimiter = newRateLimiter(opts.PubRateLimit, opts.PubByteRateLimit)
+	n.topicRateLimits, err = parseRateLimits("topic-pub-rate-limit", opts.TopicPubRateLimits)
+	if err != nil {
+		return nil, err
+	}
+	n.identityRateLimits, err = parseRateLimits("identity-pub-rate-limit", opts.IdentityPubRateLimits)
+	if err != nil {
+		return nil, err
+	}
+	n.httpReplyToken = newReplyToken()
+	n.replyWaiters = make(map[string]chan *Message)
+
 	n.logf(LOG_INFO, version.String("nsqd"))
 	n.logf(LOG_INFO, "ID: %d", opts.ID)
 
+	if opts.CheckDataOnStartup {
+		report, err := CheckData(opts, true)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check data-path - %s", err)
+		}
+		if !report.OK() {
+			n.logf(LOG_WARN, "data-path check found problems in %s (repaired: %t)",
+				report.DataPath, report.Repaired)
+		}
+	}
+
 	socketType := "tcp"
 	if opts.UseUnixSockets {
 		socketType = "unix"
@@ -235,6 +328,11 @@ func (n *NSQD) IsHealthy() bool {
 }
 
 func (n *NSQD) GetError() error {
+	if n.isDiskFull() {
+		if err := n.getDiskUsage().err; err != nil {
+			return err
+		}
+	}
 	errValue := n.errValue.Load()
 	return errValue.(errStore).err
 }
@@ -281,6 +379,7 @@ func (n *NSQD) Main() error {
 
 	n.waitGroup.Wrap(n.queueScanLoop)
 	n.waitGroup.Wrap(n.lookupLoop)
+	n.waitGroup.Wrap(n.diskSpaceLoop)
 	if n.getOpts().StatsdAddress != "" {
 		n.waitGroup.Wrap(n.statsdLoop)
 	}
@@ -472,6 +571,7 @@ func (n *NSQD) Exit() {
 	n.logf(LOG_INFO, "NSQ: stopping subsystems")
 	close(n.exitChan)
 	n.waitGroup.Wait()
+	n.backendCodec.close()
 	n.dl.Unlock()
 	n.logf(LOG_INFO, "NSQ: bye")
 	n.ctxCancel()
@@ -768,6 +868,42 @@ func buildTLSConfig(opts *Options) (*tls.Config, error) {
 	return tlsConfig, nil
 }
 
+// buildLookupdTLSConfig returns the client TLS config of connections to
+// nsqlookupd, or nil when --lookupd-tls is not set
+func buildLookupdTLSConfig(opts *Options) (*tls.Config, error) {
+	if !opts.LookupdTLS {
+		return nil, nil
+	}
+	if (opts.LookupdTLSCert == "") != (opts.LookupdTLSKey == "") {
+		return nil, errors.New("--lookupd-tls-cert and --lookupd-tls-key must be specified together")
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: opts.LookupdTLSInsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if opts.LookupdTLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(opts.LookupdTLSCert, opts.LookupdTLSKey)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if opts.LookupdTLSRootCAFile != "" {
+		tlsCertPool := x509.NewCertPool()
+		caCertFile, err := os.ReadFile(opts.LookupdTLSRootCAFile)
+		if err != nil {
+			return nil, err
+		}
+		if !tlsCertPool.AppendCertsFromPEM(caCertFile) {
+			return nil, errors.New("failed to append certificate to pool")
+		}
+		tlsConfig.RootCAs = tlsCertPool
+	}
+
+	return tlsConfig, nil
+}
+
 func (n *NSQD) IsAuthEnabled() bool {
 	return len(n.getOpts().AuthHTTPAddresses) != 0
 }
@@ -617,3 +617,241 @@ func TestUnixSocketStartup(t *testing.T) {
 	test.Equal(t, isSocket(opts.TCPAddress), true)
 	test.Equal(t, isSocket(opts.HTTPAddress), true)
 }
+
+func TestDiskFullPolicy(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.MemQueueSize = 1
+	opts.MaxBytesDataPath = 1024
+	opts.DiskCheckInterval = time.Hour
+	_, _, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	setDiskFullPolicy := func(policy string) {
+		newOpts := *opts
+		newOpts.DiskFullPolicy = policy
+		nsqd.swapOpts(&newOpts)
+	}
+
+	topic := nsqd.GetTopic("disk_full_test")
+	body := []byte("test body")
+
+	// push the data path over its quota
+	fn := filepath.Join(opts.DataPath, "filler.diskqueue.000000.dat")
+	err := os.WriteFile(fn, make([]byte, opts.MaxBytesDataPath), 0600)
+	test.Nil(t, err)
+	nsqd.checkDiskSpace()
+
+	test.Equal(t, true, nsqd.GetDiskStats().Full)
+	test.Equal(t, false, nsqd.IsHealthy())
+
+	err = topic.PutMessage(NewMessage(topic.GenerateID(), body))
+	test.Equal(t, errDiskFull, err)
+	test.Equal(t, uint64(1), nsqd.GetDiskStats().RejectCount)
+
+	// memory-only accepts messages until the memory queue is full, and refuses
+	// a batch that does not fit as a whole
+	setDiskFullPolicy(DiskFullMemoryOnly)
+	err = topic.PutMessages([]*Message{
+		NewMessage(topic.GenerateID(), body),
+		NewMessage(topic.GenerateID(), body),
+	})
+	test.Equal(t, errDiskFull, err)
+	test.Equal(t, int64(0), topic.Depth())
+	err = topic.PutMessage(NewMessage(topic.GenerateID(), body))
+	test.Nil(t, err)
+	err = topic.PutMessage(NewMessage(topic.GenerateID(), body))
+	test.Equal(t, errDiskFull, err)
+	test.Equal(t, int64(0), topic.backend.Depth())
+
+	// drop-oldest keeps writing to the backend, discarding as many of its
+	// oldest messages
+	setDiskFullPolicy(DiskFullDropOldest)
+	var n int64
+	for ; nsqd.GetDiskStats().DropCount == 0; n++ {
+		if n > 100 {
+			t.Fatal("no message dropped")
+		}
+		err = topic.PutMessage(NewMessage(topic.GenerateID(), body))
+		test.Nil(t, err)
+	}
+	test.Equal(t, uint64(1), nsqd.GetDiskStats().DropCount)
+	test.Equal(t, n-1, topic.backend.Depth())
+
+	os.Remove(fn)
+	nsqd.checkDiskSpace()
+
+	test.Equal(t, false, nsqd.GetDiskStats().Full)
+	test.Equal(t, true, nsqd.IsHealthy())
+
+	setDiskFullPolicy(DiskFullReject)
+	err = topic.PutMessage(NewMessage(topic.GenerateID(), body))
+	test.Nil(t, err)
+}
+
+func TestDiskFullPolicyValidation(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.DiskFullPolicy = "invalid"
+	_, err := New(opts)
+	test.NotNil(t, err)
+}
+
+func TestDepthLimits(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.DiskCheckInterval = time.Hour
+	opts.TopicMaxDepths = []string{
+		"depth_reject:2:0",
+		"depth_reject_batch:3:0",
+		"depth_drop_newest:2:0:drop-newest",
+		"depth_drop_oldest:2:0:drop-oldest",
+		"depth_bytes_ordered:0:1",
+		"depth_bytes_drop_ordered:0:78:drop-oldest",
+	}
+	opts.ChannelMaxDepths = []string{
+		"depth_channel/reject:1:0",
+		"depth_channel/drop:1:0:drop-newest",
+	}
+	_, _, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	// topics without channels retain their messages
+	put := func(topic *Topic) error {
+		return topic.PutMessage(NewMessage(topic.GenerateID(), []byte("test body")))
+	}
+	var ids []MessageID
+	for _, name := range []string{"depth_reject", "depth_drop_newest", "depth_drop_oldest"} {
+		topic := nsqd.GetTopic(name)
+		for i := 0; i < 2; i++ {
+			msg := NewMessage(topic.GenerateID(), []byte("test body"))
+			test.Nil(t, topic.PutMessage(msg))
+			if name == "depth_drop_oldest" {
+				ids = append(ids, msg.ID)
+			}
+		}
+	}
+
+	topic := nsqd.GetTopic("depth_reject")
+	test.Equal(t, errDepthExceeded, put(topic))
+	stats := NewTopicStats(topic, nil)
+	test.Equal(t, int64(2), stats.Depth)
+	test.Equal(t, uint64(1), stats.DepthRejectCount)
+	test.Equal(t, int64(2), stats.MaxDepth)
+	test.Equal(t, DepthPolicyReject, stats.DepthPolicy)
+
+	// a batch that does not fit is rejected as a whole
+	topic = nsqd.GetTopic("depth_reject_batch")
+	batch := func() []*Message {
+		return []*Message{
+			NewMessage(topic.GenerateID(), []byte("test body")),
+			NewMessage(topic.GenerateID(), []byte("test body")),
+		}
+	}
+	test.Nil(t, topic.PutMessages(batch()))
+	test.Equal(t, errDepthExceeded, topic.PutMessages(batch()))
+	test.Equal(t, int64(2), topic.Depth())
+
+	topic = nsqd.GetTopic("depth_drop_newest")
+	test.Nil(t, put(topic))
+	test.Equal(t, int64(2), topic.Depth())
+	test.Equal(t, uint64(1), atomic.LoadUint64(&topic.depthDropCount))
+
+	topic = nsqd.GetTopic("depth_drop_oldest")
+	test.Nil(t, put(topic))
+	test.Equal(t, int64(2), topic.Depth())
+	test.Equal(t, uint64(1), atomic.LoadUint64(&topic.depthDropCount))
+	test.Equal(t, ids[1], (<-topic.memoryMsgChan).ID)
+
+	// a byte limit applies to the messages in the diskqueue
+	topic = nsqd.GetTopic("depth_bytes_ordered")
+	test.Nil(t, put(topic))
+	test.Equal(t, int64(39), atomic.LoadInt64(&topic.backendUsage.bytes))
+	test.Equal(t, errDepthExceeded, put(topic))
+
+	// dropping never waits for the diskqueue, but once it hands out a message
+	// the dropped bytes are accounted right away
+	topic = nsqd.GetTopic("depth_bytes_drop_ordered")
+	var n int64
+	for ; atomic.LoadUint64(&topic.depthDropCount) == 0; n++ {
+		if n > 100 {
+			t.Fatal("no message dropped")
+		}
+		test.Nil(t, put(topic))
+	}
+	test.Equal(t, n-1, topic.backendUsage.depth())
+	test.Equal(t, 39*(n-1), atomic.LoadInt64(&topic.backendUsage.bytes))
+	test.Equal(t, n-1, topic.Depth())
+
+	// a channel at max depth rejects publishes to its topic, or drops the
+	// messages it is delivered
+	topic = nsqd.GetTopic("depth_channel")
+	rejecting := topic.GetChannel("reject")
+	dropping := topic.GetChannel("drop")
+	test.Nil(t, put(topic))
+	for rejecting.Depth() != 1 || dropping.Depth() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	test.Equal(t, errDepthExceeded, put(topic))
+	test.Equal(t, uint64(1), atomic.LoadUint64(&rejecting.depthRejectCount))
+
+	rejecting.Empty()
+	test.Nil(t, put(topic))
+	for atomic.LoadUint64(&dropping.depthDropCount) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	test.Equal(t, int64(1), dropping.Depth())
+}
+
+func TestDepthLimitsValidation(t *testing.T) {
+	limits, err := parseDepthLimits("channel-max-depth", []string{"t/c:10:0", "t:0:100:drop-oldest"}, DepthPolicyReject)
+	test.Nil(t, err)
+	test.Equal(t, depthLimit{10, 0, DepthPolicyReject}, limits["t/c"])
+	test.Equal(t, depthLimit{0, 100, DepthPolicyDropOldest}, limits["t"])
+
+	for _, limit := range []string{"t", "t:10", ":1:1", "t:x:0", "t:1:-1", "t:1:0:invalid", "t:1:0:reject:x"} {
+		opts := NewOptions()
+		opts.Logger = test.NewTestLogger(t)
+		opts.TopicMaxDepths = []string{limit}
+		_, err := New(opts)
+		test.NotNil(t, err)
+	}
+
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.DepthPolicy = "invalid"
+	_, err = New(opts)
+	test.NotNil(t, err)
+}
+
+func TestLabelsValidation(t *testing.T) {
+	labels, err := parseLabels([]string{"zone=us-east-1a", "role=", "k8s.io/node=n1=x"})
+	test.Nil(t, err)
+	test.Equal(t, map[string]string{"zone": "us-east-1a", "role": "", "k8s.io/node": "n1=x"}, labels)
+
+	for _, label := range []string{"zone", "=a", "a b=c"} {
+		opts := NewOptions()
+		opts.Logger = test.NewTestLogger(t)
+		opts.Labels = []string{label}
+		_, err := New(opts)
+		test.NotNil(t, err)
+	}
+}
+
+func TestRateLimitsValidation(t *testing.T) {
+	limits, err := parseRateLimits("topic-pub-rate-limit", []string{"events:10:0", "a:b:0:100", "none:0:0"})
+	test.Nil(t, err)
+	test.Equal(t, 2, len(limits))
+	test.Equal(t, float64(10), limits["events"].messages.rate)
+	test.Equal(t, float64(100), limits["a:b"].bytes.rate)
+
+	for _, limit := range []string{"events", "events:10", ":1:1", "events:x:0", "events:1:-1"} {
+		opts := NewOptions()
+		opts.Logger = test.NewTestLogger(t)
+		opts.TopicPubRateLimits = []string{limit}
+		_, err := New(opts)
+		test.NotNil(t, err)
+	}
+}
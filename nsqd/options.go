@@ -26,6 +26,8 @@ type Options struct {
 	BroadcastTCPPort         int           `flag:"broadcast-tcp-port"`
 	BroadcastHTTPPort        int           `flag:"broadcast-http-port"`
 	NSQLookupdTCPAddresses   []string      `flag:"lookupd-tcp-address" cfg:"nsqlookupd_tcp_addresses"`
+	Labels                   []string      `flag:"label" cfg:"labels"`
+	ProvisionFromLookupd     bool          `flag:"provision-from-lookupd"`
 	AuthHTTPAddresses        []string      `flag:"auth-http-address" cfg:"auth_http_addresses"`
 	HTTPClientConnectTimeout time.Duration `flag:"http-client-connect-timeout" cfg:"http_client_connect_timeout"`
 	HTTPClientRequestTimeout time.Duration `flag:"http-client-request-timeout" cfg:"http_client_request_timeout"`
@@ -41,6 +43,33 @@ type Options struct {
 	SyncEvery        int64         `flag:"sync-every"`
 	SyncTimeout      time.Duration `flag:"sync-timeout"`
 
+	CheckDataOnStartup      bool     `flag:"check-data-on-startup"`
+	EncryptionKeyFile       string   `flag:"encryption-key-file"`
+	BackendCompression      string   `flag:"backend-compression"`
+	TopicBackendCompression []string `flag:"topic-backend-compression" cfg:"topic_backend_compression"`
+
+	// disk space options
+	MaxBytesDataPath  int64         `flag:"max-bytes-data-path"`
+	MinFreeDiskBytes  int64         `flag:"min-free-disk-bytes"`
+	DiskFullPolicy    string        `flag:"disk-full-policy"`
+	DiskCheckInterval time.Duration `flag:"disk-check-interval"`
+
+	// max depth of topics and channels
+	MaxDepth         int64    `flag:"max-depth"`
+	MaxDepthBytes    int64    `flag:"max-depth-bytes"`
+	DepthPolicy      string   `flag:"depth-policy"`
+	TopicMaxDepths   []string `flag:"topic-max-depth" cfg:"topic_max_depths"`
+	ChannelMaxDepths []string `flag:"channel-max-depth" cfg:"channel_max_depths"`
+
+	// partitioned delivery
+	ChannelPartitions []string `flag:"channel-partitions" cfg:"channel_partitions"`
+
+	// publish rate limits
+	PubRateLimit          int64    `flag:"pub-rate-limit"`
+	PubByteRateLimit      int64    `flag:"pub-byte-rate-limit"`
+	TopicPubRateLimits    []string `flag:"topic-pub-rate-limit" cfg:"topic_pub_rate_limits"`
+	IdentityPubRateLimits []string `flag:"identity-pub-rate-limit" cfg:"identity_pub_rate_limits"`
+
 	QueueScanInterval        time.Duration
 	QueueScanRefreshInterval time.Duration
 	QueueScanSelectionCount  int `flag:"queue-scan-selection-count"`
@@ -84,10 +113,20 @@ type Options struct {
 	TLSRequired         int    `flag:"tls-required"`
 	TLSMinVersion       uint16 `flag:"tls-min-version"`
 
+	// nsqlookupd connections
+	LookupdTLS                   bool   `flag:"lookupd-tls"`
+	LookupdTLSRootCAFile         string `flag:"lookupd-tls-root-ca-file"`
+	LookupdTLSCert               string `flag:"lookupd-tls-cert"`
+	LookupdTLSKey                string `flag:"lookupd-tls-key"`
+	LookupdTLSInsecureSkipVerify bool   `flag:"lookupd-tls-insecure-skip-verify"`
+	LookupdAuthSecret            string `flag:"lookupd-auth-secret"`
+
 	// compression
 	DeflateEnabled  bool `flag:"deflate"`
 	MaxDeflateLevel int  `flag:"max-deflate-level"`
 	SnappyEnabled   bool `flag:"snappy"`
+	ZstdEnabled     bool `flag:"zstd"`
+	MaxZstdLevel    int  `flag:"max-zstd-level"`
 }
 
 func NewOptions() *Options {
@@ -114,6 +153,7 @@ func NewOptions() *Options {
 		WakeupSocketDir:   "/var/run/",
 
 		NSQLookupdTCPAddresses: make([]string, 0),
+		Labels:                 make([]string, 0),
 		AuthHTTPAddresses:      make([]string, 0),
 
 		HTTPClientConnectTimeout: 2 * time.Second,
@@ -125,6 +165,27 @@ func NewOptions() *Options {
 		SyncEvery:        2500,
 		SyncTimeout:      2 * time.Second,
 
+		BackendCompression:      "none",
+		TopicBackendCompression: make([]string, 0),
+
+		MaxBytesDataPath:  0, // means no limit on the data path
+		MinFreeDiskBytes:  0,
+		DiskFullPolicy:    DiskFullReject,
+		DiskCheckInterval: 5 * time.Second,
+
+		MaxDepth:         0, // means no limit
+		MaxDepthBytes:    0,
+		DepthPolicy:      DepthPolicyReject,
+		TopicMaxDepths:   make([]string, 0),
+		ChannelMaxDepths: make([]string, 0),
+
+		ChannelPartitions: make([]string, 0),
+
+		PubRateLimit:          0, // means no limit
+		PubByteRateLimit:      0,
+		TopicPubRateLimits:    make([]string, 0),
+		IdentityPubRateLimits: make([]string, 0),
+
 		QueueScanInterval:        100 * time.Millisecond,
 		QueueScanRefreshInterval: 5 * time.Second,
 		QueueScanSelectionCount:  20,
@@ -156,6 +217,8 @@ func NewOptions() *Options {
 		DeflateEnabled:  true,
 		MaxDeflateLevel: 6,
 		SnappyEnabled:   true,
+		ZstdEnabled:     true,
+		MaxZstdLevel:    3,
 
 		TLSMinVersion: tls.VersionTLS10,
 	}
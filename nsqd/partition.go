@@ -0,0 +1,245 @@
+package nsqd
+
+import (
+	"fmt"
+	"hash/fnv"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	maxPartitionKeyLength = 255
+	maxChannelPartitions  = 4096
+
+	// messages a partitioned channel holds for clients that are not ready
+	maxPartitionPending = 1024
+)
+
+func isValidPartitionKey(key string) bool {
+	if len(key) == 0 || len(key) > maxPartitionKeyLength {
+		return false
+	}
+	return !strings.ContainsAny(key, " \t\r\n")
+}
+
+// parseChannelPartitions parses --channel-partitions values of the form
+// <topic>/<channel>:<partitions>
+func parseChannelPartitions(values []string) (map[string]int, error) {
+	m := make(map[string]int)
+	for _, v := range values {
+		i := strings.LastIndex(v, ":")
+		if i == -1 || !strings.Contains(v[:i], "/") {
+			return nil, fmt.Errorf("invalid channel partitions %q (<topic>/<channel>:<partitions>)", v)
+		}
+		partitions, err := strconv.Atoi(v[i+1:])
+		if err != nil || partitions < 1 || partitions > maxChannelPartitions {
+			return nil, fmt.Errorf("invalid channel partitions %q (must be 1-%d)", v, maxChannelPartitions)
+		}
+		m[v[:i]] = partitions
+	}
+	return m, nil
+}
+
+// partition returns the partition of a message, messages without a key are
+// spread by ID
+func (m *Message) partition(partitions int) int {
+	h := fnv.New32a()
+	if m.PartitionKey != "" {
+		h.Write([]byte(m.PartitionKey))
+	} else {
+		h.Write(m.ID[:])
+	}
+	return int(h.Sum32() % uint32(partitions))
+}
+
+// rebalancePartitions assigns the partitions of the channel round-robin to its
+// clients in order of client ID and wakes up the partition pump.
+//
+// this expects the caller to hold partitionMtx
+func (c *Channel) rebalancePartitions() {
+	clientIDs := make([]int64, 0, len(c.partitionChans))
+	for id := range c.partitionChans {
+		clientIDs = append(clientIDs, id)
+	}
+	sort.Slice(clientIDs, func(i, j int) bool { return clientIDs[i] < clientIDs[j] })
+
+	for i := range c.partitionOwners {
+		c.partitionOwners[i] = 0
+		if len(clientIDs) > 0 {
+			c.partitionOwners[i] = clientIDs[i%len(clientIDs)]
+		}
+	}
+
+	close(c.rebalanceChan)
+	c.rebalanceChan = make(chan struct{})
+}
+
+// partitionChan returns the channel a client of a partitioned channel receives
+// its messages on
+func (c *Channel) partitionChan(clientID int64) chan *Message {
+	c.partitionMtx.RLock()
+	defer c.partitionMtx.RUnlock()
+	return c.partitionChans[clientID]
+}
+
+// partitionOwnerChans returns the channel of the client each partition is
+// assigned to (nil when there are no clients) and a channel closed on the next
+// rebalance
+func (c *Channel) partitionOwnerChans() ([]chan *Message, chan struct{}) {
+	c.partitionMtx.RLock()
+	defer c.partitionMtx.RUnlock()
+	ownerChans := make([]chan *Message, len(c.partitionOwners))
+	for i, owner := range c.partitionOwners {
+		ownerChans[i] = c.partitionChans[owner]
+	}
+	return ownerChans, c.rebalanceChan
+}
+
+// assignedPartitions returns the partitions assigned to a client
+func (c *Channel) assignedPartitions(clientID int64) []int {
+	c.partitionMtx.RLock()
+	defer c.partitionMtx.RUnlock()
+	var partitions []int
+	for i, owner := range c.partitionOwners {
+		if owner == clientID {
+			partitions = append(partitions, i)
+		}
+	}
+	return partitions
+}
+
+// requeue puts a message that was delivered before back into the channel, a
+// partitioned channel redelivers it ahead of the later messages of its
+// partition
+//
+// this expects the caller to hold the exit read lock
+func (c *Channel) requeue(msg *Message) error {
+	if c.partitionOwners == nil || msg.Attempts == 0 {
+		return c.put(msg)
+	}
+	c.partitionRequeueChan <- msg
+	return nil
+}
+
+// partitionPump delivers the messages of a partitioned channel to the clients
+// their partition is assigned to, in queue order, so that messages with the
+// same key are delivered in order to a single client.
+//
+// Each partition has its own queue of pending messages so that a client that
+// is not ready only holds up its own partitions, until there are
+// maxPartitionPending of them. Requeued messages go to the front of the
+// queue of their partition.
+func (c *Channel) partitionPump() {
+	pending := make([][]*Message, len(c.partitionOwners))
+	var numPending int64
+	ownerChans, rebalanceChan := c.partitionOwnerChans()
+
+	const (
+		exitCase = iota
+		rebalanceCase
+		requeueCase
+		emptyCase
+		memoryCase
+		backendCase
+		firstSendCase
+	)
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.partitionExitChan)},
+		{Dir: reflect.SelectRecv},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.partitionRequeueChan)},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.partitionEmptyChan)},
+		{Dir: reflect.SelectRecv},
+		{Dir: reflect.SelectRecv},
+	}
+	var sendPartitions []int
+
+	for {
+		cases[rebalanceCase].Chan = reflect.ValueOf(rebalanceChan)
+		cases[memoryCase].Chan = reflect.Value{}
+		cases[backendCase].Chan = reflect.Value{}
+		if numPending < maxPartitionPending {
+			if c.memoryMsgChan != nil {
+				cases[memoryCase].Chan = reflect.ValueOf(c.memoryMsgChan)
+			}
+			cases[backendCase].Chan = reflect.ValueOf(c.backend.ReadChan())
+		}
+		cases = cases[:firstSendCase]
+		sendPartitions = sendPartitions[:0]
+		for partition, msgs := range pending {
+			if len(msgs) == 0 || ownerChans[partition] == nil {
+				continue
+			}
+			cases = append(cases, reflect.SelectCase{
+				Dir:  reflect.SelectSend,
+				Chan: reflect.ValueOf(ownerChans[partition]),
+				Send: reflect.ValueOf(msgs[0]),
+			})
+			sendPartitions = append(sendPartitions, partition)
+		}
+
+		chosen, recv, _ := reflect.Select(cases)
+		switch chosen {
+		case exitCase:
+			goto exit
+		case rebalanceCase:
+			ownerChans, rebalanceChan = c.partitionOwnerChans()
+		case requeueCase:
+			msg := recv.Interface().(*Message)
+			partition := msg.partition(len(pending))
+			// after the requeued messages published before it, ahead of those
+			// that were not delivered yet
+			msgs := pending[partition]
+			i := 0
+			for i < len(msgs) && msgs[i].Attempts > 0 && msgs[i].Timestamp <= msg.Timestamp {
+				i++
+			}
+			msgs = append(msgs, nil)
+			copy(msgs[i+1:], msgs[i:])
+			msgs[i] = msg
+			pending[partition] = msgs
+			numPending++
+		case emptyCase:
+			for partition := range pending {
+				pending[partition] = nil
+			}
+			numPending = 0
+		case memoryCase, backendCase:
+			var msg *Message
+			if chosen == memoryCase {
+				msg = recv.Interface().(*Message)
+			} else {
+				var err error
+				b := recv.Bytes()
+				c.backendUsage.read(b)
+				msg, err = c.codec.decode(b)
+				if err != nil {
+					c.nsqd.logf(LOG_ERROR, "CHANNEL(%s): failed to decode message - %s", c.name, err)
+					continue
+				}
+			}
+			partition := msg.partition(len(pending))
+			pending[partition] = append(pending[partition], msg)
+			numPending++
+		default:
+			partition := sendPartitions[chosen-firstSendCase]
+			pending[partition][0] = nil
+			pending[partition] = pending[partition][1:]
+			numPending--
+		}
+		atomic.StoreInt64(&c.partitionPending, numPending)
+	}
+
+exit:
+	// put the pending messages back so that they are persisted (or emptied)
+	// with the rest of the queue
+	for _, msgs := range pending {
+		for _, msg := range msgs {
+			c.put(msg)
+		}
+	}
+	atomic.StoreInt64(&c.partitionPending, 0)
+	close(c.partitionDoneChan)
+}
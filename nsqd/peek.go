@@ -0,0 +1,152 @@
+package nsqd
+
+import (
+	"errors"
+	"os"
+)
+
+// the most messages /channel/peek returns
+const maxPeekCount = 100
+
+var errPeekDone = errors.New("peek done")
+
+// PeekMessages returns copies of (at most) count messages waiting in the
+// channel without delivering them to a client, and the number of messages in
+// memory that were left out.
+//
+// A message cannot be read from the memory queue without taking it out, so
+// messages in memory are only returned while the channel is paused (and not
+// partitioned, the partition pump keeps reading): new messages are held off
+// while the whole memory queue is taken out and put back in order. Messages on
+// disk are read from the queue files without moving the read position. As
+// that position is only persisted every --sync-every messages or
+// --sync-timeout, recently consumed messages may be returned too.
+func (c *Channel) PeekMessages(count int) ([]*Message, int, error) {
+	peekMemory := c.IsPaused() && len(c.partitionOwners) == 0
+	if peekMemory {
+		c.exitMutex.Lock()
+		defer c.exitMutex.Unlock()
+	} else {
+		c.exitMutex.RLock()
+		defer c.exitMutex.RUnlock()
+	}
+	if c.Exiting() {
+		return nil, 0, errors.New("exiting")
+	}
+
+	var msgs []*Message
+	var inMemory int
+	if c.memoryMsgChan != nil {
+		if peekMemory {
+			var err error
+			msgs, err = c.peekMemoryQueue(count)
+			if err != nil {
+				return msgs, 0, err
+			}
+		} else {
+			inMemory = len(c.memoryMsgChan)
+		}
+	}
+
+	if len(msgs) < count && !c.ephemeral {
+		onDisk, err := peekQueueFiles(c.nsqd.getOpts(), getBackendName(c.topicName, c.name),
+			c.codec, count-len(msgs))
+		if err != nil {
+			return msgs, inMemory, err
+		}
+		msgs = append(msgs, onDisk...)
+	}
+	return msgs, inMemory, nil
+}
+
+// peekMemoryQueue takes every message out of the memory queue, copies the
+// first count of them and puts them all back in order.
+//
+// this expects the caller to hold exitMutex so that no new message is queued
+// meanwhile, a message requeued at the same time may still take a slot in
+// which case the remaining messages are written to the backend
+func (c *Channel) peekMemoryQueue(count int) ([]*Message, error) {
+	var taken []*Message
+drain:
+	for n := len(c.memoryMsgChan); len(taken) < n; {
+		select {
+		case m := <-c.memoryMsgChan:
+			taken = append(taken, m)
+		default:
+			break drain
+		}
+	}
+
+	var msgs []*Message
+	var firstErr error
+	for i, m := range taken {
+		if i < count {
+			msgs = append(msgs, &Message{
+				ID:            m.ID,
+				Body:          m.Body,
+				Timestamp:     m.Timestamp,
+				Attempts:      m.Attempts,
+				PartitionKey:  m.PartitionKey,
+				ReplyTo:       m.ReplyTo,
+				CorrelationID: m.CorrelationID,
+			})
+		}
+		select {
+		case c.memoryMsgChan <- m:
+			continue
+		default:
+		}
+		err := writeMessageToBackend(m, c.backend, c.codec, &c.backendUsage)
+		c.nsqd.SetHealth(err)
+		if err != nil {
+			c.nsqd.logf(LOG_ERROR, "CHANNEL(%s): failed to put back peeked message %s - %s",
+				c.name, m.ID, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return msgs, firstErr
+}
+
+// peekQueueFiles reads (at most) count messages of a diskqueue starting at its
+// persisted read position
+func peekQueueFiles(opts *Options, name string, codec *backendCodec, count int) ([]*Message, error) {
+	_, readFileNum, readPos, _, err := readQueueMetadata(
+		queueMetadataFileName(opts.DataPath, name), opts.MaxBytesPerQueue > 0)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var msgs []*Message
+	for fileNum := readFileNum; len(msgs) < count; fileNum++ {
+		fileName := queueFileName(opts.DataPath, name, fileNum)
+		if _, err := os.Stat(fileName); err != nil {
+			break
+		}
+		var offset int64
+		if fileNum == readFileNum {
+			offset = readPos
+		}
+		_, rotatedErr := os.Stat(queueFileName(opts.DataPath, name, fileNum+1))
+		_, _, err = scanQueueFile(fileName, offset, rotatedErr == nil, opts, codec,
+			func(b []byte) error {
+				msg, err := codec.decode(b)
+				if err != nil {
+					return nil
+				}
+				msgs = append(msgs, msg)
+				if len(msgs) >= count {
+					return errPeekDone
+				}
+				return nil
+			})
+		if err == errPeekDone {
+			break
+		}
+		if err != nil {
+			return msgs, err
+		}
+	}
+	return msgs, nil
+}
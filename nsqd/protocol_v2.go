@@ -21,6 +21,9 @@ const (
 	frameTypeResponse int32 = 0
 	frameTypeError    int32 = 1
 	frameTypeMessage  int32 = 2
+	// a message with reply headers, sent to clients that negotiated
+	// request_reply (see Message.writeWithReplyHeaders)
+	frameTypeHeaderMessage int32 = 3
 )
 
 var separatorBytes = []byte(" ")
@@ -113,10 +116,14 @@ func (p *protocolV2) IOLoop(c protocol.Client) error {
 	}
 
 	p.nsqd.logf(LOG_INFO, "PROTOCOL(V2): [%s] exiting ioloop", client)
+	client.closeZstdReader()
 	close(client.ExitChan)
 	if client.Channel != nil {
 		client.Channel.RemoveClient(client.ID)
 	}
+	if client.ReplyTopic != "" {
+		p.nsqd.DeleteExistingTopic(client.ReplyTopic)
+	}
 
 	return err
 }
@@ -127,12 +134,18 @@ func (p *protocolV2) SendMessage(client *clientV2, msg *Message) error {
 	buf := bufferPoolGet()
 	defer bufferPoolPut(buf)
 
-	_, err := msg.WriteTo(buf)
-	if err != nil {
-		return err
+	frameType := frameTypeMessage
+	if client.RequestReply && msg.hasReplyHeaders() {
+		frameType = frameTypeHeaderMessage
+		msg.writeWithReplyHeaders(buf)
+	} else {
+		_, err := msg.WriteTo(buf)
+		if err != nil {
+			return err
+		}
 	}
 
-	err = p.Send(client, frameTypeMessage, buf.Bytes())
+	err := p.Send(client, frameType, buf.Bytes())
 	if err != nil {
 		return err
 	}
@@ -186,6 +199,8 @@ func (p *protocolV2) Exec(client *clientV2, params [][]byte) ([]byte, error) {
 		return p.MPUB(client, params)
 	case bytes.Equal(params[0], []byte("DPUB")):
 		return p.DPUB(client, params)
+	case bytes.Equal(params[0], []byte("RPUB")):
+		return p.RPUB(client, params)
 	case bytes.Equal(params[0], []byte("NOP")):
 		return p.NOP(client, params)
 	case bytes.Equal(params[0], []byte("TOUCH")):
@@ -247,14 +262,12 @@ func (p *protocolV2) messagePump(client *clientV2, startedChan chan bool) {
 		} else if flushed {
 			// last iteration we flushed...
 			// do not select on the flusher ticker channel
-			memoryMsgChan = subChannel.memoryMsgChan
-			backendMsgChan = subChannel.backend.ReadChan()
+			memoryMsgChan, backendMsgChan = subChannel.messageChans(client.ID)
 			flusherChan = nil
 		} else {
 			// we're buffered (if there isn't any more data we should flush)...
 			// select on the flusher ticker channel, too
-			memoryMsgChan = subChannel.memoryMsgChan
-			backendMsgChan = subChannel.backend.ReadChan()
+			memoryMsgChan, backendMsgChan = subChannel.messageChans(client.ID)
 			flusherChan = outputBufferTicker.C
 		}
 
@@ -301,11 +314,12 @@ func (p *protocolV2) messagePump(client *clientV2, startedChan chan bool) {
 				goto exit
 			}
 		case b := <-backendMsgChan:
+			subChannel.backendUsage.read(b)
 			if sampleRate > 0 && rand.Int31n(100) > sampleRate {
 				continue
 			}
 
-			msg, err := decodeMessage(b)
+			msg, err := subChannel.codec.decode(b)
 			if err != nil {
 				p.nsqd.logf(LOG_ERROR, "failed to decode message - %s", err)
 				continue
@@ -341,6 +355,7 @@ exit:
 	p.nsqd.logf(LOG_INFO, "PROTOCOL(V2): [%s] exiting messagePump", client)
 	heartbeatTicker.Stop()
 	outputBufferTicker.Stop()
+	client.closeZstdWriter()
 	if err != nil {
 		p.nsqd.logf(LOG_ERROR, "PROTOCOL(V2): [%s] messagePump error - %s", client, err)
 	}
@@ -388,6 +403,14 @@ func (p *protocolV2) IDENTIFY(client *clientV2, params [][]byte) ([]byte, error)
 		return nil, protocol.NewFatalClientErr(err, "E_BAD_BODY", "IDENTIFY "+err.Error())
 	}
 
+	if identifyData.RequestReply {
+		// the reply topic is deleted when the client disconnects
+		replyTopic := p.nsqd.GetTopic(p.nsqd.clientReplyTopicName(client.ID))
+		client.metaLock.Lock()
+		client.ReplyTopic = replyTopic.name
+		client.metaLock.Unlock()
+	}
+
 	// bail out early if we're not negotiating features
 	if !identifyData.FeatureNegotiation {
 		return okBytes, nil
@@ -403,10 +426,21 @@ func (p *protocolV2) IDENTIFY(client *clientV2, params [][]byte) ([]byte, error)
 		deflateLevel = max
 	}
 	snappy := p.nsqd.getOpts().SnappyEnabled && identifyData.Snappy
+	zstd := p.nsqd.getOpts().ZstdEnabled && identifyData.Zstd
+	zstdLevel := 3
+	if zstd && identifyData.ZstdLevel > 0 {
+		zstdLevel = identifyData.ZstdLevel
+	}
+	if max := p.nsqd.getOpts().MaxZstdLevel; max < zstdLevel {
+		zstdLevel = max
+	}
 
 	if deflate && snappy {
 		return nil, protocol.NewFatalClientErr(nil, "E_IDENTIFY_FAILED", "cannot enable both deflate and snappy compression")
 	}
+	if zstd && (deflate || snappy) {
+		return nil, protocol.NewFatalClientErr(nil, "E_IDENTIFY_FAILED", "cannot enable zstd with deflate or snappy compression")
+	}
 
 	resp, err := json.Marshal(struct {
 		MaxRdyCount         int64  `json:"max_rdy_count"`
@@ -418,10 +452,14 @@ func (p *protocolV2) IDENTIFY(client *clientV2, params [][]byte) ([]byte, error)
 		DeflateLevel        int    `json:"deflate_level"`
 		MaxDeflateLevel     int    `json:"max_deflate_level"`
 		Snappy              bool   `json:"snappy"`
+		Zstd                bool   `json:"zstd"`
+		ZstdLevel           int    `json:"zstd_level"`
+		MaxZstdLevel        int    `json:"max_zstd_level"`
 		SampleRate          int32  `json:"sample_rate"`
 		AuthRequired        bool   `json:"auth_required"`
 		OutputBufferSize    int    `json:"output_buffer_size"`
 		OutputBufferTimeout int64  `json:"output_buffer_timeout"`
+		ReplyTopic          string `json:"reply_topic,omitempty"`
 	}{
 		MaxRdyCount:         p.nsqd.getOpts().MaxRdyCount,
 		Version:             version.Binary,
@@ -432,10 +470,14 @@ func (p *protocolV2) IDENTIFY(client *clientV2, params [][]byte) ([]byte, error)
 		DeflateLevel:        deflateLevel,
 		MaxDeflateLevel:     p.nsqd.getOpts().MaxDeflateLevel,
 		Snappy:              snappy,
+		Zstd:                zstd,
+		ZstdLevel:           zstdLevel,
+		MaxZstdLevel:        p.nsqd.getOpts().MaxZstdLevel,
 		SampleRate:          client.SampleRate,
 		AuthRequired:        p.nsqd.IsAuthEnabled(),
 		OutputBufferSize:    client.OutputBufferSize,
 		OutputBufferTimeout: int64(client.OutputBufferTimeout / time.Millisecond),
+		ReplyTopic:          client.ReplyTopic,
 	})
 	if err != nil {
 		return nil, protocol.NewFatalClientErr(err, "E_IDENTIFY_FAILED", "IDENTIFY failed "+err.Error())
@@ -485,6 +527,19 @@ func (p *protocolV2) IDENTIFY(client *clientV2, params [][]byte) ([]byte, error)
 		}
 	}
 
+	if zstd {
+		p.nsqd.logf(LOG_INFO, "PROTOCOL(V2): [%s] upgrading connection to zstd (level %d)", client, zstdLevel)
+		err = client.UpgradeZstd(zstdLevel)
+		if err != nil {
+			return nil, protocol.NewFatalClientErr(err, "E_IDENTIFY_FAILED", "IDENTIFY failed "+err.Error())
+		}
+
+		err = p.Send(client, frameTypeResponse, okBytes)
+		if err != nil {
+			return nil, protocol.NewFatalClientErr(err, "E_IDENTIFY_FAILED", "IDENTIFY failed "+err.Error())
+		}
+	}
+
 	return nil, nil
 }
 
@@ -777,6 +832,11 @@ func (p *protocolV2) PUB(client *clientV2, params [][]byte) ([]byte, error) {
 			fmt.Sprintf("PUB topic name %q is not valid", topicName))
 	}
 
+	partitionKey, err := readPartitionKey("PUB", params, 2)
+	if err != nil {
+		return nil, err
+	}
+
 	bodyLen, err := readLen(client.Reader, client.lenSlice)
 	if err != nil {
 		return nil, protocol.NewFatalClientErr(err, "E_BAD_MESSAGE", "PUB failed to read message body size")
@@ -802,9 +862,23 @@ func (p *protocolV2) PUB(client *clientV2, params [][]byte) ([]byte, error) {
 		return nil, err
 	}
 
-	topic := p.nsqd.GetTopic(topicName)
+	topic, err := p.nsqd.getPublishTopic(topicName)
+	if err != nil {
+		return nil, protocol.NewClientErr(err, "E_REPLY_TOPIC_NOT_FOUND",
+			fmt.Sprintf("PUB reply topic %q not found", topicName))
+	}
+	if !client.AllowPublish(topic, 1, int64(bodyLen)) {
+		return nil, protocol.NewClientErr(nil, "E_RATE_LIMITED", "PUB rate limit exceeded")
+	}
 	msg := NewMessage(topic.GenerateID(), messageBody)
+	msg.PartitionKey = partitionKey
 	err = topic.PutMessage(msg)
+	if err == errDiskFull {
+		return nil, protocol.NewClientErr(err, "E_DISK_FULL", "PUB failed "+err.Error())
+	}
+	if err == errDepthExceeded {
+		return nil, protocol.NewClientErr(err, "E_DEPTH_EXCEEDED", "PUB failed "+err.Error())
+	}
 	if err != nil {
 		return nil, protocol.NewFatalClientErr(err, "E_PUB_FAILED", "PUB failed "+err.Error())
 	}
@@ -827,11 +901,20 @@ func (p *protocolV2) MPUB(client *clientV2, params [][]byte) ([]byte, error) {
 			fmt.Sprintf("E_BAD_TOPIC MPUB topic name %q is not valid", topicName))
 	}
 
+	partitionKey, err := readPartitionKey("MPUB", params, 2)
+	if err != nil {
+		return nil, err
+	}
+
 	if err := p.CheckAuth(client, "MPUB", topicName, ""); err != nil {
 		return nil, err
 	}
 
-	topic := p.nsqd.GetTopic(topicName)
+	topic, err := p.nsqd.getPublishTopic(topicName)
+	if err != nil {
+		return nil, protocol.NewClientErr(err, "E_REPLY_TOPIC_NOT_FOUND",
+			fmt.Sprintf("MPUB reply topic %q not found", topicName))
+	}
 
 	bodyLen, err := readLen(client.Reader, client.lenSlice)
 	if err != nil {
@@ -854,10 +937,25 @@ func (p *protocolV2) MPUB(client *clientV2, params [][]byte) ([]byte, error) {
 		return nil, err
 	}
 
+	if !client.AllowPublish(topic, len(messages), messagesSize(messages)) {
+		return nil, protocol.NewClientErr(nil, "E_RATE_LIMITED", "MPUB rate limit exceeded")
+	}
+	for _, msg := range messages {
+		msg.PartitionKey = partitionKey
+	}
+
 	// if we've made it this far we've validated all the input,
-	// the only possible error is that the topic is exiting during
+	// the only possible errors are that the topic is exiting during
 	// this next call (and no messages will be queued in that case)
+	// or that the disk is full or a max depth is reached (the batch is
+	// admitted or refused as a whole, so none of them are queued either)
 	err = topic.PutMessages(messages)
+	if err == errDiskFull {
+		return nil, protocol.NewClientErr(err, "E_DISK_FULL", "MPUB failed "+err.Error())
+	}
+	if err == errDepthExceeded {
+		return nil, protocol.NewClientErr(err, "E_DEPTH_EXCEEDED", "MPUB failed "+err.Error())
+	}
 	if err != nil {
 		return nil, protocol.NewFatalClientErr(err, "E_MPUB_FAILED", "MPUB failed "+err.Error())
 	}
@@ -880,6 +978,11 @@ func (p *protocolV2) DPUB(client *clientV2, params [][]byte) ([]byte, error) {
 			fmt.Sprintf("DPUB topic name %q is not valid", topicName))
 	}
 
+	partitionKey, err := readPartitionKey("DPUB", params, 3)
+	if err != nil {
+		return nil, err
+	}
+
 	timeoutMs, err := protocol.ByteToBase10(params[2])
 	if err != nil {
 		return nil, protocol.NewFatalClientErr(err, "E_INVALID",
@@ -918,10 +1021,24 @@ func (p *protocolV2) DPUB(client *clientV2, params [][]byte) ([]byte, error) {
 		return nil, err
 	}
 
-	topic := p.nsqd.GetTopic(topicName)
+	topic, err := p.nsqd.getPublishTopic(topicName)
+	if err != nil {
+		return nil, protocol.NewClientErr(err, "E_REPLY_TOPIC_NOT_FOUND",
+			fmt.Sprintf("DPUB reply topic %q not found", topicName))
+	}
+	if !client.AllowPublish(topic, 1, int64(bodyLen)) {
+		return nil, protocol.NewClientErr(nil, "E_RATE_LIMITED", "DPUB rate limit exceeded")
+	}
 	msg := NewMessage(topic.GenerateID(), messageBody)
+	msg.PartitionKey = partitionKey
 	msg.deferred = timeoutDuration
 	err = topic.PutMessage(msg)
+	if err == errDiskFull {
+		return nil, protocol.NewClientErr(err, "E_DISK_FULL", "DPUB failed "+err.Error())
+	}
+	if err == errDepthExceeded {
+		return nil, protocol.NewClientErr(err, "E_DEPTH_EXCEEDED", "DPUB failed "+err.Error())
+	}
 	if err != nil {
 		return nil, protocol.NewFatalClientErr(err, "E_DPUB_FAILED", "DPUB failed "+err.Error())
 	}
@@ -931,6 +1048,90 @@ func (p *protocolV2) DPUB(client *clientV2, params [][]byte) ([]byte, error) {
 	return okBytes, nil
 }
 
+// RPUB publishes a request, when reply_to is given, or a reply to the request
+// with the given correlation ID
+//
+//	RPUB <topic> <correlation_id> [<reply_to>]
+func (p *protocolV2) RPUB(client *clientV2, params [][]byte) ([]byte, error) {
+	var err error
+
+	if len(params) < 3 {
+		return nil, protocol.NewFatalClientErr(nil, "E_INVALID", "RPUB insufficient number of parameters")
+	}
+
+	topicName := string(params[1])
+	if !protocol.IsValidTopicName(topicName) {
+		return nil, protocol.NewFatalClientErr(nil, "E_BAD_TOPIC",
+			fmt.Sprintf("RPUB topic name %q is not valid", topicName))
+	}
+
+	correlationID := string(params[2])
+	if !isValidCorrelationID(correlationID) {
+		return nil, protocol.NewFatalClientErr(nil, "E_BAD_CORRELATION_ID",
+			fmt.Sprintf("RPUB correlation ID %q is not valid", correlationID))
+	}
+
+	var replyTo string
+	if len(params) > 3 {
+		replyTo = string(params[3])
+		if !protocol.IsValidTopicName(replyTo) {
+			return nil, protocol.NewFatalClientErr(nil, "E_BAD_TOPIC",
+				fmt.Sprintf("RPUB reply to topic name %q is not valid", replyTo))
+		}
+	}
+
+	bodyLen, err := readLen(client.Reader, client.lenSlice)
+	if err != nil {
+		return nil, protocol.NewFatalClientErr(err, "E_BAD_MESSAGE", "RPUB failed to read message body size")
+	}
+
+	if bodyLen <= 0 {
+		return nil, protocol.NewFatalClientErr(nil, "E_BAD_MESSAGE",
+			fmt.Sprintf("RPUB invalid message body size %d", bodyLen))
+	}
+
+	if int64(bodyLen) > p.nsqd.getOpts().MaxMsgSize {
+		return nil, protocol.NewFatalClientErr(nil, "E_BAD_MESSAGE",
+			fmt.Sprintf("RPUB message too big %d > %d", bodyLen, p.nsqd.getOpts().MaxMsgSize))
+	}
+
+	messageBody := make([]byte, bodyLen)
+	_, err = io.ReadFull(client.Reader, messageBody)
+	if err != nil {
+		return nil, protocol.NewFatalClientErr(err, "E_BAD_MESSAGE", "RPUB failed to read message body")
+	}
+
+	if err := p.CheckAuth(client, "RPUB", topicName, ""); err != nil {
+		return nil, err
+	}
+
+	topic, err := p.nsqd.getPublishTopic(topicName)
+	if err != nil {
+		return nil, protocol.NewClientErr(err, "E_REPLY_TOPIC_NOT_FOUND",
+			fmt.Sprintf("RPUB reply topic %q not found", topicName))
+	}
+	if !client.AllowPublish(topic, 1, int64(bodyLen)) {
+		return nil, protocol.NewClientErr(nil, "E_RATE_LIMITED", "RPUB rate limit exceeded")
+	}
+	msg := NewMessage(topic.GenerateID(), messageBody)
+	msg.ReplyTo = replyTo
+	msg.CorrelationID = correlationID
+	err = topic.PutMessage(msg)
+	if err == errDiskFull {
+		return nil, protocol.NewClientErr(err, "E_DISK_FULL", "RPUB failed "+err.Error())
+	}
+	if err == errDepthExceeded {
+		return nil, protocol.NewClientErr(err, "E_DEPTH_EXCEEDED", "RPUB failed "+err.Error())
+	}
+	if err != nil {
+		return nil, protocol.NewFatalClientErr(err, "E_RPUB_FAILED", "RPUB failed "+err.Error())
+	}
+
+	client.PublishedMessage(topicName, 1)
+
+	return okBytes, nil
+}
+
 func (p *protocolV2) TOUCH(client *clientV2, params [][]byte) ([]byte, error) {
 	state := atomic.LoadInt32(&client.State)
 	if state != stateSubscribed && state != stateClosing {
@@ -1009,6 +1210,20 @@ func getMessageID(p []byte) (*MessageID, error) {
 	return (*MessageID)(unsafe.Pointer(&p[0])), nil
 }
 
+// readPartitionKey returns the optional partition key parameter of a publish
+// command
+func readPartitionKey(cmd string, params [][]byte, i int) (string, error) {
+	if len(params) <= i {
+		return "", nil
+	}
+	key := string(params[i])
+	if !isValidPartitionKey(key) {
+		return "", protocol.NewFatalClientErr(nil, "E_BAD_PARTITION_KEY",
+			fmt.Sprintf("%s partition key %q is not valid", cmd, key))
+	}
+	return key, nil
+}
+
 func readLen(r io.Reader, tmp []byte) (int32, error) {
 	_, err := io.ReadFull(r, tmp)
 	if err != nil {
@@ -18,12 +18,14 @@ import (
 	"os"
 	"runtime"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
 	"github.com/nsqio/go-nsq"
 	"github.com/nsqio/nsq/internal/protocol"
 	"github.com/nsqio/nsq/internal/test"
@@ -443,6 +445,485 @@ func TestEmptyCommand(t *testing.T) {
 	// if we didn't panic here we're good, see issue #120
 }
 
+func TestPubRateLimit(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.PubRateLimit = 2
+	tcpAddr, _, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	conn, err := mustConnectNSQD(tcpAddr)
+	test.Nil(t, err)
+	defer conn.Close()
+
+	identify(t, conn, nil, frameTypeResponse)
+
+	topicName := "test_pub_rate_limit" + strconv.Itoa(int(time.Now().Unix()))
+	for i := 0; i < 2; i++ {
+		_, err = nsq.Publish(topicName, []byte("test body")).WriteTo(conn)
+		test.Nil(t, err)
+		readValidate(t, conn, frameTypeResponse, "OK")
+	}
+
+	// rejections are not fatal, the connection remains usable
+	_, err = nsq.Publish(topicName, []byte("test body")).WriteTo(conn)
+	test.Nil(t, err)
+	readValidate(t, conn, frameTypeError, "E_RATE_LIMITED PUB rate limit exceeded")
+
+	cmd, _ := nsq.MultiPublish(topicName, [][]byte{[]byte("a"), []byte("b")})
+	_, err = cmd.WriteTo(conn)
+	test.Nil(t, err)
+	readValidate(t, conn, frameTypeError, "E_RATE_LIMITED MPUB rate limit exceeded")
+
+	stats := nsqd.GetStats(topicName, "", true)
+	test.Equal(t, 1, len(stats.Producers))
+	test.Equal(t, uint64(2), stats.Producers[0].(ClientV2Stats).RateLimitedCount)
+	test.Equal(t, uint64(2), stats.Topics[0].RateLimitedCount)
+	test.Equal(t, int64(2), stats.Topics[0].Depth)
+
+	// the limit is shared by all publishers
+	conn2, err := mustConnectNSQD(tcpAddr)
+	test.Nil(t, err)
+	defer conn2.Close()
+	identify(t, conn2, nil, frameTypeResponse)
+
+	_, err = nsq.Publish(topicName, []byte("test body")).WriteTo(conn2)
+	test.Nil(t, err)
+	readValidate(t, conn2, frameTypeError, "E_RATE_LIMITED PUB rate limit exceeded")
+}
+
+func TestTopicPubRateLimit(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.TopicPubRateLimits = []string{"test_pub_rate_limit_topic:1:0"}
+	opts.IdentityPubRateLimits = []string{"svc:1:0"}
+	tcpAddr, _, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	conn, err := mustConnectNSQD(tcpAddr)
+	test.Nil(t, err)
+	defer conn.Close()
+	identify(t, conn, nil, frameTypeResponse)
+
+	// topic limits apply to the topic only
+	topicName := "test_pub_rate_limit" + strconv.Itoa(int(time.Now().Unix()))
+	for i := 0; i < 2; i++ {
+		_, err = nsq.Publish(topicName, []byte("test body")).WriteTo(conn)
+		test.Nil(t, err)
+		readValidate(t, conn, frameTypeResponse, "OK")
+	}
+
+	conn2, err := mustConnectNSQD(tcpAddr)
+	test.Nil(t, err)
+	defer conn2.Close()
+	identify(t, conn2, nil, frameTypeResponse)
+
+	_, err = nsq.Publish("test_pub_rate_limit_topic", []byte("test body")).WriteTo(conn2)
+	test.Nil(t, err)
+	readValidate(t, conn2, frameTypeResponse, "OK")
+	_, err = nsq.DeferredPublish("test_pub_rate_limit_topic", time.Second, []byte("test body")).WriteTo(conn2)
+	test.Nil(t, err)
+	readValidate(t, conn2, frameTypeError, "E_RATE_LIMITED DPUB rate limit exceeded")
+
+	// as do identity limits
+	topic := nsqd.GetTopic(topicName + "_identity")
+	test.Equal(t, true, nsqd.allowPublish(topic, "svc", 1, 1))
+	test.Equal(t, false, nsqd.allowPublish(topic, "svc", 1, 1))
+	test.Equal(t, true, nsqd.allowPublish(topic, "other", 1, 1))
+}
+
+func TestPubDepthExceeded(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.MaxDepth = 1
+	tcpAddr, _, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	conn, err := mustConnectNSQD(tcpAddr)
+	test.Nil(t, err)
+	defer conn.Close()
+
+	identify(t, conn, nil, frameTypeResponse)
+
+	topicName := "test_pub_depth_exceeded" + strconv.Itoa(int(time.Now().Unix()))
+	_, err = nsq.Publish(topicName, []byte("test body")).WriteTo(conn)
+	test.Nil(t, err)
+	readValidate(t, conn, frameTypeResponse, "OK")
+
+	// rejections are not fatal, the connection remains usable
+	_, err = nsq.Publish(topicName, []byte("test body")).WriteTo(conn)
+	test.Nil(t, err)
+	readValidate(t, conn, frameTypeError, "E_DEPTH_EXCEEDED PUB failed max depth exceeded")
+
+	nsqd.GetTopic(topicName).Empty()
+	_, err = nsq.Publish(topicName, []byte("test body")).WriteTo(conn)
+	test.Nil(t, err)
+	readValidate(t, conn, frameTypeResponse, "OK")
+}
+
+func TestPartitionedChannel(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.ChannelPartitions = []string{"test_partitioned/ch:16"}
+	tcpAddr, _, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	type delivery struct {
+		consumer int
+		body     string
+	}
+	deliveries := make(chan delivery)
+	var consumers []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := mustConnectNSQD(tcpAddr)
+		test.Nil(t, err)
+		defer conn.Close()
+		identify(t, conn, nil, frameTypeResponse)
+		sub(t, conn, "test_partitioned", "ch")
+		_, err = nsq.Ready(100).WriteTo(conn)
+		test.Nil(t, err)
+		consumers = append(consumers, conn)
+
+		go func(i int, conn net.Conn) {
+			for {
+				resp, err := nsq.ReadResponse(conn)
+				if err != nil {
+					return
+				}
+				frameType, data, _ := nsq.UnpackResponse(resp)
+				if frameType != frameTypeMessage {
+					continue
+				}
+				msg, _ := decodeMessage(data)
+				deliveries <- delivery{i, string(msg.Body)}
+			}
+		}(i, conn)
+	}
+
+	conn, err := mustConnectNSQD(tcpAddr)
+	test.Nil(t, err)
+	defer conn.Close()
+	identify(t, conn, nil, frameTypeResponse)
+
+	publish := func(seq int) {
+		for k := 0; k < 10; k++ {
+			key := fmt.Sprintf("key-%d", k)
+			cmd := &nsq.Command{
+				Name:   []byte("PUB"),
+				Params: [][]byte{[]byte("test_partitioned"), []byte(key)},
+				Body:   []byte(fmt.Sprintf("%s %d", key, seq)),
+			}
+			_, err := cmd.WriteTo(conn)
+			test.Nil(t, err)
+			readValidate(t, conn, frameTypeResponse, "OK")
+		}
+	}
+	receive := func(n int) map[string][]delivery {
+		byKey := make(map[string][]delivery)
+		for i := 0; i < n; i++ {
+			select {
+			case d := <-deliveries:
+				key := strings.Fields(d.body)[0]
+				byKey[key] = append(byKey[key], d)
+			case <-time.After(5 * time.Second):
+				t.Fatalf("received %d of %d messages", i, n)
+			}
+		}
+		return byKey
+	}
+
+	for seq := 0; seq < 5; seq++ {
+		publish(seq)
+	}
+	byConsumer := make(map[int]int)
+	for key, ds := range receive(50) {
+		test.Equal(t, 5, len(ds))
+		for seq, d := range ds {
+			// messages with the same key go to the same client in order
+			test.Equal(t, ds[0].consumer, d.consumer)
+			test.Equal(t, fmt.Sprintf("%s %d", key, seq), d.body)
+		}
+		byConsumer[ds[0].consumer]++
+	}
+	test.Equal(t, 2, len(byConsumer))
+
+	stats := nsqd.GetStats("test_partitioned", "ch", true)
+	test.Equal(t, 16, stats.Topics[0].Channels[0].Partitions)
+	for _, client := range stats.Topics[0].Channels[0].Clients {
+		test.Equal(t, 8, len(client.(ClientV2Stats).Partitions))
+	}
+
+	// the partitions of a client that leaves are reassigned
+	consumers[1].Close()
+	for {
+		clients := nsqd.GetStats("test_partitioned", "ch", true).Topics[0].Channels[0].Clients
+		if len(clients) == 1 && len(clients[0].(ClientV2Stats).Partitions) == 16 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	publish(5)
+	for _, ds := range receive(10) {
+		test.Equal(t, 0, ds[0].consumer)
+	}
+
+	// partition keys are validated
+	cmd := &nsq.Command{
+		Name:   []byte("PUB"),
+		Params: [][]byte{[]byte("test_partitioned"), bytes.Repeat([]byte("k"), maxPartitionKeyLength+1)},
+		Body:   []byte("test body"),
+	}
+	_, err = cmd.WriteTo(conn)
+	test.Nil(t, err)
+	readValidate(t, conn, frameTypeError, fmt.Sprintf("E_BAD_PARTITION_KEY PUB partition key %q is not valid",
+		strings.Repeat("k", maxPartitionKeyLength+1)))
+}
+
+func TestPartitionedChannelPending(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.ChannelPartitions = []string{"test_partitioned_pending/ch:16"}
+	tcpAddr, _, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	topic := nsqd.GetTopic("test_partitioned_pending")
+	channel := topic.GetChannel("ch")
+
+	// the first client never gets ready, it is assigned the even partitions
+	idle, err := mustConnectNSQD(tcpAddr)
+	test.Nil(t, err)
+	defer idle.Close()
+	identify(t, idle, nil, frameTypeResponse)
+	sub(t, idle, "test_partitioned_pending", "ch")
+
+	conn, err := mustConnectNSQD(tcpAddr)
+	test.Nil(t, err)
+	defer conn.Close()
+	identify(t, conn, nil, frameTypeResponse)
+	sub(t, conn, "test_partitioned_pending", "ch")
+	_, err = nsq.Ready(100).WriteTo(conn)
+	test.Nil(t, err)
+
+	readMsg := func() *Message {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		for {
+			resp, err := nsq.ReadResponse(conn)
+			test.Nil(t, err)
+			frameType, data, _ := nsq.UnpackResponse(resp)
+			if frameType != frameTypeMessage {
+				continue
+			}
+			msg, err := decodeMessage(data)
+			test.Nil(t, err)
+			return msg
+		}
+	}
+	publish := func(key string, body string) *Message {
+		msg := NewMessage(topic.GenerateID(), []byte(body))
+		msg.PartitionKey = key
+		test.Nil(t, topic.PutMessage(msg))
+		return msg
+	}
+
+	// the messages of the idle client do not hold up the others
+	var expected, held int
+	for seq := 0; seq < 5; seq++ {
+		for k := 0; k < 10; k++ {
+			key := fmt.Sprintf("key-%d", k)
+			msg := publish(key, fmt.Sprintf("%s %d", key, seq))
+			if msg.partition(16)%2 == 1 {
+				expected++
+			} else {
+				held++
+			}
+		}
+	}
+	next := make(map[string]int)
+	for i := 0; i < expected; i++ {
+		msg := readMsg()
+		fields := strings.Fields(string(msg.Body))
+		test.Equal(t, fmt.Sprintf("%s %d", fields[0], next[fields[0]]), string(msg.Body))
+		next[fields[0]]++
+		_, err = nsq.Finish(nsq.MessageID(msg.ID)).WriteTo(conn)
+		test.Nil(t, err)
+	}
+	test.Equal(t, int64(held), channel.Depth())
+
+	// requeued messages are redelivered ahead of the later ones of their key
+	var key string
+	for k := 0; key == ""; k++ {
+		msg := NewMessage(MessageID{}, nil)
+		msg.PartitionKey = fmt.Sprintf("ordered-%d", k)
+		if msg.partition(16)%2 == 1 {
+			key = msg.PartitionKey
+		}
+	}
+	_, err = nsq.Ready(3).WriteTo(conn)
+	test.Nil(t, err)
+	var inFlight []*Message
+	for seq := 0; seq < 3; seq++ {
+		publish(key, fmt.Sprintf("%s %d", key, seq))
+		inFlight = append(inFlight, readMsg())
+	}
+	publish(key, fmt.Sprintf("%s %d", key, 3))
+	_, err = nsq.Ready(0).WriteTo(conn)
+	test.Nil(t, err)
+	_, err = nsq.Requeue(nsq.MessageID(inFlight[1].ID), 0).WriteTo(conn)
+	test.Nil(t, err)
+	_, err = nsq.Requeue(nsq.MessageID(inFlight[0].ID), 0).WriteTo(conn)
+	test.Nil(t, err)
+	_, err = nsq.Finish(nsq.MessageID(inFlight[2].ID)).WriteTo(conn)
+	test.Nil(t, err)
+	_, err = nsq.Ready(1).WriteTo(conn)
+	test.Nil(t, err)
+	for _, seq := range []int{0, 1, 3} {
+		msg := readMsg()
+		test.Equal(t, fmt.Sprintf("%s %d", key, seq), string(msg.Body))
+		_, err = nsq.Finish(nsq.MessageID(msg.ID)).WriteTo(conn)
+		test.Nil(t, err)
+	}
+}
+
+func readReplyMessage(t *testing.T, conn io.Reader) (*Message, string, string) {
+	resp, err := nsq.ReadResponse(conn)
+	test.Nil(t, err)
+	frameType, data, err := nsq.UnpackResponse(resp)
+	test.Nil(t, err)
+	test.Equal(t, frameTypeHeaderMessage, frameType)
+	replyTo, rest, err := readRecordString(data[minValidMsgLength:], "reply to")
+	test.Nil(t, err)
+	correlationID, rest, err := readRecordString(rest, "correlation ID")
+	test.Nil(t, err)
+	msg, err := decodeMessage(append(data[:minValidMsgLength:minValidMsgLength], rest...))
+	test.Nil(t, err)
+	return msg, replyTo, correlationID
+}
+
+func TestRequestReply(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	tcpAddr, _, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	requester, err := mustConnectNSQD(tcpAddr)
+	test.Nil(t, err)
+	defer requester.Close()
+	data := identify(t, requester, map[string]interface{}{"request_reply": true}, frameTypeResponse)
+	r := struct {
+		ReplyTopic string `json:"reply_topic"`
+	}{}
+	err = json.Unmarshal(data, &r)
+	test.Nil(t, err)
+	test.Equal(t, true, replyTopicNameRegex.MatchString(r.ReplyTopic))
+	test.Equal(t, true, strings.HasPrefix(r.ReplyTopic, fmt.Sprintf("reply-%d-1-", opts.ID)))
+	test.NotEqual(t, nsqd.clientReplyTopicName(1), r.ReplyTopic)
+	sub(t, requester, r.ReplyTopic, "replies#ephemeral")
+	_, err = nsq.Ready(1).WriteTo(requester)
+	test.Nil(t, err)
+
+	responder, err := mustConnectNSQD(tcpAddr)
+	test.Nil(t, err)
+	defer responder.Close()
+	identify(t, responder, map[string]interface{}{"request_reply": true}, frameTypeResponse)
+	sub(t, responder, "test_requests", "ch")
+	_, err = nsq.Ready(1).WriteTo(responder)
+	test.Nil(t, err)
+
+	cmd := &nsq.Command{
+		Name:   []byte("RPUB"),
+		Params: [][]byte{[]byte("test_requests"), []byte("req-1"), []byte(r.ReplyTopic)},
+		Body:   []byte("ping"),
+	}
+	_, err = cmd.WriteTo(requester)
+	test.Nil(t, err)
+	readValidate(t, requester, frameTypeResponse, "OK")
+
+	msg, replyTo, correlationID := readReplyMessage(t, responder)
+	test.Equal(t, []byte("ping"), msg.Body)
+	test.Equal(t, r.ReplyTopic, replyTo)
+	test.Equal(t, "req-1", correlationID)
+
+	// other clients cannot reply without knowing the random part of the name
+	intruder, err := mustConnectNSQD(tcpAddr)
+	test.Nil(t, err)
+	defer intruder.Close()
+	identify(t, intruder, nil, frameTypeResponse)
+	guessed := strings.TrimSuffix(r.ReplyTopic, "#ephemeral")
+	guessed = guessed[:len(guessed)-2*replyTokenSize] + strings.Repeat("0", 2*replyTokenSize) + "#ephemeral"
+	cmd = &nsq.Command{
+		Name:   []byte("RPUB"),
+		Params: [][]byte{[]byte(guessed), []byte("req-1")},
+		Body:   []byte("forged"),
+	}
+	_, err = cmd.WriteTo(intruder)
+	test.Nil(t, err)
+	readValidate(t, intruder, frameTypeError,
+		fmt.Sprintf("E_REPLY_TOPIC_NOT_FOUND RPUB reply topic %q not found", guessed))
+	cmd = &nsq.Command{
+		Name:   []byte("RPUB"),
+		Params: [][]byte{[]byte(fmt.Sprintf("reply-%d-1#ephemeral", opts.ID)), []byte("req-1")},
+		Body:   []byte("forged"),
+	}
+	_, err = cmd.WriteTo(intruder)
+	test.Nil(t, err)
+	readValidate(t, intruder, frameTypeResponse, "OK")
+
+	cmd = &nsq.Command{
+		Name:   []byte("RPUB"),
+		Params: [][]byte{[]byte(replyTo), []byte(correlationID)},
+		Body:   []byte("pong"),
+	}
+	_, err = cmd.WriteTo(responder)
+	test.Nil(t, err)
+	readValidate(t, responder, frameTypeResponse, "OK")
+
+	msg, replyTo, correlationID = readReplyMessage(t, requester)
+	test.Equal(t, []byte("pong"), msg.Body)
+	test.Equal(t, "", replyTo)
+	test.Equal(t, "req-1", correlationID)
+
+	// the reply topic goes away with its client
+	requester.Close()
+	for i := 0; ; i++ {
+		if _, err := nsqd.GetExistingTopic(r.ReplyTopic); err != nil {
+			break
+		}
+		if i > 100 {
+			t.Fatalf("reply topic %s not deleted", r.ReplyTopic)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// and is not re-created by a late reply
+	cmd = &nsq.Command{
+		Name:   []byte("RPUB"),
+		Params: [][]byte{[]byte(r.ReplyTopic), []byte("req-1")},
+		Body:   []byte("pong"),
+	}
+	_, err = cmd.WriteTo(responder)
+	test.Nil(t, err)
+	readValidate(t, responder, frameTypeError,
+		fmt.Sprintf("E_REPLY_TOPIC_NOT_FOUND RPUB reply topic %q not found", r.ReplyTopic))
+	_, err = nsqd.GetExistingTopic(r.ReplyTopic)
+	test.NotNil(t, err)
+
+	// an invalid correlation ID is fatal
+	cmd = &nsq.Command{
+		Name:   []byte("RPUB"),
+		Params: [][]byte{[]byte("test_requests"), []byte("")},
+		Body:   []byte("ping"),
+	}
+	_, err = cmd.WriteTo(responder)
+	test.Nil(t, err)
+	readValidate(t, responder, frameTypeError, `E_BAD_CORRELATION_ID RPUB correlation ID "" is not valid`)
+}
+
 func TestSizeLimits(t *testing.T) {
 	opts := NewOptions()
 	opts.Logger = test.NewTestLogger(t)
@@ -1149,6 +1630,165 @@ func TestSnappy(t *testing.T) {
 	test.Equal(t, msg.Body, msgOut.Body)
 }
 
+func TestZstd(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.LogLevel = LOG_DEBUG
+	opts.ZstdEnabled = true
+	opts.MaxZstdLevel = 6
+	tcpAddr, _, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	conn, err := mustConnectNSQD(tcpAddr)
+	test.Nil(t, err)
+	defer conn.Close()
+
+	data := identify(t, conn, map[string]interface{}{
+		"zstd":       true,
+		"zstd_level": 9,
+	}, frameTypeResponse)
+	r := struct {
+		Zstd         bool `json:"zstd"`
+		ZstdLevel    int  `json:"zstd_level"`
+		MaxZstdLevel int  `json:"max_zstd_level"`
+	}{}
+	err = json.Unmarshal(data, &r)
+	test.Nil(t, err)
+	test.Equal(t, true, r.Zstd)
+	test.Equal(t, 6, r.ZstdLevel)
+	test.Equal(t, 6, r.MaxZstdLevel)
+
+	zr, err := zstd.NewReader(conn, zstd.WithDecoderConcurrency(1))
+	test.Nil(t, err)
+	compressConn := bufio.NewReader(zr)
+	resp, _ := nsq.ReadResponse(compressConn)
+	frameType, data, _ := nsq.UnpackResponse(resp)
+	t.Logf("frameType: %d, data: %s", frameType, data)
+	test.Equal(t, frameTypeResponse, frameType)
+	test.Equal(t, []byte("OK"), data)
+
+	msgBody := make([]byte, 128000)
+	w, err := zstd.NewWriter(conn, zstd.WithEncoderConcurrency(1))
+	test.Nil(t, err)
+
+	rw := readWriter{compressConn, flushWriter{w}}
+
+	topicName := "test_zstd" + strconv.Itoa(int(time.Now().Unix()))
+	sub(t, rw, topicName, "ch")
+
+	_, err = nsq.Ready(1).WriteTo(rw)
+	test.Nil(t, err)
+
+	topic := nsqd.GetTopic(topicName)
+	msg := NewMessage(topic.GenerateID(), msgBody)
+	topic.PutMessage(msg)
+
+	resp, _ = nsq.ReadResponse(compressConn)
+	frameType, data, _ = nsq.UnpackResponse(resp)
+	msgOut, _ := decodeMessage(data)
+	test.Equal(t, frameTypeMessage, frameType)
+	test.Equal(t, msg.ID, msgOut.ID)
+	test.Equal(t, msg.Body, msgOut.Body)
+}
+
+// flushWriter flushes a zstd stream after every write so that each command
+// reaches nsqd without closing the frame
+type flushWriter struct {
+	*zstd.Encoder
+}
+
+func (w flushWriter) Write(p []byte) (int, error) {
+	n, err := w.Encoder.Write(p)
+	if err != nil {
+		return n, err
+	}
+	return n, w.Encoder.Flush()
+}
+
+func TestZstdMaxWindow(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.LogLevel = LOG_DEBUG
+	opts.ZstdEnabled = true
+	tcpAddr, _, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	conn, err := mustConnectNSQD(tcpAddr)
+	test.Nil(t, err)
+	defer conn.Close()
+
+	identify(t, conn, map[string]interface{}{
+		"zstd": true,
+	}, frameTypeResponse)
+
+	zr, err := zstd.NewReader(conn, zstd.WithDecoderConcurrency(1))
+	test.Nil(t, err)
+	compressConn := bufio.NewReader(zr)
+	resp, _ := nsq.ReadResponse(compressConn)
+	_, data, _ := nsq.UnpackResponse(resp)
+	test.Equal(t, []byte("OK"), data)
+
+	// a stream with a window over zstdMaxWindow closes the connection
+	w, err := zstd.NewWriter(conn, zstd.WithEncoderConcurrency(1),
+		zstd.WithWindowSize(4*zstdMaxWindow))
+	test.Nil(t, err)
+	_, err = nsq.Nop().WriteTo(flushWriter{w})
+	test.Nil(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = nsq.ReadResponse(compressConn)
+	test.NotNil(t, err)
+	test.Equal(t, false, strings.Contains(err.Error(), "timeout"))
+}
+
+func TestZstdWithOtherCompression(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.LogLevel = LOG_DEBUG
+	tcpAddr, _, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	conn, err := mustConnectNSQD(tcpAddr)
+	test.Nil(t, err)
+	defer conn.Close()
+
+	data := identify(t, conn, map[string]interface{}{
+		"zstd":   true,
+		"snappy": true,
+	}, frameTypeError)
+	test.Equal(t, "E_IDENTIFY_FAILED cannot enable zstd with deflate or snappy compression", string(data))
+}
+
+func TestZstdDisabled(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.LogLevel = LOG_DEBUG
+	opts.ZstdEnabled = false
+	tcpAddr, _, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	conn, err := mustConnectNSQD(tcpAddr)
+	test.Nil(t, err)
+	defer conn.Close()
+
+	data := identify(t, conn, map[string]interface{}{
+		"zstd": true,
+	}, frameTypeResponse)
+	r := struct {
+		Zstd bool `json:"zstd"`
+	}{}
+	err = json.Unmarshal(data, &r)
+	test.Nil(t, err)
+	test.Equal(t, false, r.Zstd)
+
+	// the connection is not upgraded
+	sub(t, conn, "test_zstd_disabled", "ch")
+}
+
 func TestTLSDeflate(t *testing.T) {
 	opts := NewOptions()
 	opts.Logger = test.NewTestLogger(t)
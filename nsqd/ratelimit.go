@@ -0,0 +1,173 @@
+package nsqd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tokenBucket refills at rate tokens per second up to a burst of one second
+// worth of tokens.
+//
+// Requests are admitted as long as the bucket holds a token and take all of
+// their tokens, possibly leaving the bucket in debt, so that a single message
+// or batch larger than the burst is never rejected forever.
+type tokenBucket struct {
+	rate   float64 // 0 means unlimited
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate int64, now time.Time) tokenBucket {
+	return tokenBucket{
+		rate:   float64(rate),
+		tokens: float64(rate),
+		last:   now,
+	}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if b.rate <= 0 {
+		return
+	}
+	elapsed := now.Sub(b.last).Seconds()
+	b.last = now
+	if elapsed <= 0 {
+		return
+	}
+	b.tokens += elapsed * b.rate
+	if b.tokens > b.rate {
+		b.tokens = b.rate
+	}
+}
+
+func (b *tokenBucket) allowed() bool {
+	return b.rate <= 0 || b.tokens >= 1
+}
+
+func (b *tokenBucket) take(n float64) {
+	if b.rate <= 0 {
+		return
+	}
+	b.tokens -= n
+}
+
+// rateLimiter limits the messages and bytes published per second within one
+// scope (all publishers, an auth identity or a topic)
+type rateLimiter struct {
+	sync.Mutex
+	messages tokenBucket
+	bytes    tokenBucket
+}
+
+// newRateLimiter returns nil when both rates are unlimited
+func newRateLimiter(msgsPerSec int64, bytesPerSec int64) *rateLimiter {
+	if msgsPerSec <= 0 && bytesPerSec <= 0 {
+		return nil
+	}
+	now := time.Now()
+	return &rateLimiter{
+		messages: newTokenBucket(msgsPerSec, now),
+		bytes:    newTokenBucket(bytesPerSec, now),
+	}
+}
+
+// this expects the caller to hold the limiter lock
+func (r *rateLimiter) allowed(now time.Time) bool {
+	r.messages.refill(now)
+	r.bytes.refill(now)
+	return r.messages.allowed() && r.bytes.allowed()
+}
+
+// this expects the caller to hold the limiter lock
+func (r *rateLimiter) take(count int, size int64) {
+	r.messages.take(float64(count))
+	r.bytes.take(float64(size))
+}
+
+// allowPublish returns whether count messages of size bytes in total may be
+// published, deducting them from every limiter (nil limiters are unlimited)
+// only when all of them admit the publish.
+//
+// All of the limiters are locked for the check and the deduction so that
+// concurrent publishes cannot both be admitted by the last tokens. They are
+// always given in the same order (all publishers, identity, topic) so that
+// this cannot deadlock.
+func allowPublish(count int, size int64, limiters ...*rateLimiter) bool {
+	locked := limiters[:0:0]
+	for _, r := range limiters {
+		if r != nil {
+			r.Lock()
+			locked = append(locked, r)
+		}
+	}
+	defer func() {
+		for _, r := range locked {
+			r.Unlock()
+		}
+	}()
+
+	now := time.Now()
+	for _, r := range locked {
+		if !r.allowed(now) {
+			return false
+		}
+	}
+	for _, r := range locked {
+		r.take(count, size)
+	}
+	return true
+}
+
+// parseRateLimits parses --topic-pub-rate-limit and --identity-pub-rate-limit
+// values of the form <name>:<msgs/sec>:<bytes/sec> (0 = unlimited)
+func parseRateLimits(flagName string, values []string) (map[string]*rateLimiter, error) {
+	m := make(map[string]*rateLimiter)
+	for _, v := range values {
+		parts := strings.Split(v, ":")
+		if len(parts) < 3 {
+			return nil, fmt.Errorf("invalid --%s %q (<name>:<msgs/sec>:<bytes/sec>)", flagName, v)
+		}
+		name := strings.Join(parts[:len(parts)-2], ":")
+		msgs, err := strconv.ParseInt(parts[len(parts)-2], 10, 64)
+		if err != nil || msgs < 0 {
+			return nil, fmt.Errorf("invalid --%s %q messages per second", flagName, v)
+		}
+		bytes, err := strconv.ParseInt(parts[len(parts)-1], 10, 64)
+		if err != nil || bytes < 0 {
+			return nil, fmt.Errorf("invalid --%s %q bytes per second", flagName, v)
+		}
+		if name == "" {
+			return nil, fmt.Errorf("invalid --%s %q empty name", flagName, v)
+		}
+		if r := newRateLimiter(msgs, bytes); r != nil {
+			m[name] = r
+		}
+	}
+	return m, nil
+}
+
+// allowPublish applies the rate limits of all publishers, of the auth
+// identity of the publisher and of the topic, counting rejections on the topic
+func (n *NSQD) allowPublish(topic *Topic, identity string, count int, size int64) bool {
+	var identityLimiter *rateLimiter
+	if identity != "" {
+		identityLimiter = n.identityRateLimits[identity]
+	}
+	if allowPublish(count, size, n.pubRateLimiter, identityLimiter, n.topicRateLimits[topic.name]) {
+		return true
+	}
+	atomic.AddUint64(&topic.rateLimitedCount, 1)
+	return false
+}
+
+func messagesSize(msgs []*Message) int64 {
+	var size int64
+	for _, m := range msgs {
+		size += int64(len(m.Body))
+	}
+	return size
+}
@@ -0,0 +1,129 @@
+package nsqd
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	maxReplyToLength       = 64 // the max length of a topic name
+	maxCorrelationIDLength = 255
+
+	// the number of random bytes in reply topic names, so that other clients
+	// cannot guess the reply topic of a requester to subscribe or reply to it
+	replyTokenSize = 8
+
+	// how long /pubr waits for a reply when no timeout is given
+	defaultPubrTimeout = 10 * time.Second
+)
+
+// replyTopicNameRegex matches the names of clientReplyTopicName and
+// httpReplyTopicName of any nsqd
+var replyTopicNameRegex = regexp.MustCompile(`^reply-\d+-(\d+|http)-[0-9a-f]{16}#ephemeral$`)
+
+var errReplyTopicNotFound = errors.New("reply topic not found")
+
+func isValidCorrelationID(id string) bool {
+	if len(id) == 0 || len(id) > maxCorrelationIDLength {
+		return false
+	}
+	return !strings.ContainsAny(id, " \t\r\n")
+}
+
+func (m *Message) hasReplyHeaders() bool {
+	return m.ReplyTo != "" || m.CorrelationID != ""
+}
+
+// writeWithReplyHeaders serializes the message for clients that negotiated
+// request_reply, the reply headers follow the message ID
+//
+//	[timestamp][attempts][ID][reply to length (uint16)][reply to][correlation ID length (uint16)][correlation ID][body]
+func (m *Message) writeWithReplyHeaders(buf *bytes.Buffer) {
+	var b [10]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(m.Timestamp))
+	binary.BigEndian.PutUint16(b[8:10], m.Attempts)
+	buf.Write(b[:])
+	buf.Write(m.ID[:])
+	writeRecordString(buf, m.ReplyTo)
+	writeRecordString(buf, m.CorrelationID)
+	buf.Write(m.Body)
+}
+
+// newReplyToken returns the random part of a reply topic name
+func newReplyToken() string {
+	b := make([]byte, replyTokenSize)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("failed to generate reply token - %s", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+// clientReplyTopicName returns a new name for the ephemeral topic replies to
+// the requests of a client are published to, only known to the client and to
+// the responders of its requests
+//
+// Reply topics only exist on the nsqd the request was published to, the ID of
+// that nsqd is part of the name, so responders have to publish replies to the
+// nsqd the reply topic is registered with (e.g. looking it up in nsqlookupd).
+func (n *NSQD) clientReplyTopicName(clientID int64) string {
+	return fmt.Sprintf("reply-%d-%d-%s#ephemeral", n.getOpts().ID, clientID, newReplyToken())
+}
+
+// httpReplyTopicName returns the name of the ephemeral topic replies to /pubr
+// requests are published to, they are handed to the waiting request instead of
+// being queued
+func (n *NSQD) httpReplyTopicName() string {
+	return fmt.Sprintf("reply-%d-http-%s#ephemeral", n.getOpts().ID, n.httpReplyToken)
+}
+
+// getPublishTopic returns the topic a client publishes to, creating it unless
+// it is a reply topic: those are only created for IDENTIFY and /pubr, so one
+// that does not exist is the reply topic of another nsqd or of a gone client
+// and publishing to it would queue a reply nobody ever receives
+func (n *NSQD) getPublishTopic(topicName string) (*Topic, error) {
+	if replyTopicNameRegex.MatchString(topicName) {
+		topic, err := n.GetExistingTopic(topicName)
+		if err != nil {
+			return nil, errReplyTopicNotFound
+		}
+		return topic, nil
+	}
+	return n.GetTopic(topicName), nil
+}
+
+// awaitReply registers a /pubr request waiting for the reply with the given
+// correlation ID, the returned func must be called once done waiting
+func (n *NSQD) awaitReply(correlationID string) (chan *Message, func()) {
+	replyChan := make(chan *Message, 1)
+	n.replyWaitersMtx.Lock()
+	n.replyWaiters[correlationID] = replyChan
+	n.replyWaitersMtx.Unlock()
+	return replyChan, func() {
+		n.replyWaitersMtx.Lock()
+		delete(n.replyWaiters, correlationID)
+		n.replyWaitersMtx.Unlock()
+	}
+}
+
+// deliverReply hands a message published to the /pubr reply topic to the
+// request waiting for it, replies nobody waits for (anymore) are dropped
+func (n *NSQD) deliverReply(m *Message) {
+	n.replyWaitersMtx.Lock()
+	replyChan, ok := n.replyWaiters[m.CorrelationID]
+	n.replyWaitersMtx.Unlock()
+	if !ok {
+		n.logf(LOG_DEBUG, "dropping reply with unknown correlation ID %q", m.CorrelationID)
+		return
+	}
+	select {
+	case replyChan <- m:
+	default:
+	}
+}
@@ -26,6 +26,22 @@ type TopicStats struct {
 	MessageBytes uint64         `json:"message_bytes"`
 	Paused       bool           `json:"paused"`
 
+	// number of publishes rejected by rate limits
+	RateLimitedCount uint64 `json:"rate_limited_count"`
+
+	// max depth (0 = no limit), the size of the diskqueue files it is
+	// compared to and the messages rejected or dropped by its policy
+	MaxDepth         int64  `json:"max_depth"`
+	MaxDepthBytes    int64  `json:"max_depth_bytes"`
+	DepthPolicy      string `json:"depth_policy"`
+	BackendBytes     int64  `json:"backend_bytes"`
+	DepthRejectCount uint64 `json:"depth_reject_count"`
+	DepthDropCount   uint64 `json:"depth_drop_count"`
+
+	// ratio of message bytes to bytes written to the backend after
+	// compression, 0 until a message was compressed
+	BackendCompressionRatio float64 `json:"backend_compression_ratio"`
+
 	E2eProcessingLatency *quantile.Result `json:"e2e_processing_latency"`
 }
 
@@ -39,6 +55,17 @@ func NewTopicStats(t *Topic, channels []ChannelStats) TopicStats {
 		MessageBytes: atomic.LoadUint64(&t.messageBytes),
 		Paused:       t.IsPaused(),
 
+		RateLimitedCount: atomic.LoadUint64(&t.rateLimitedCount),
+
+		MaxDepth:         t.depthLimit.messages,
+		MaxDepthBytes:    t.depthLimit.bytes,
+		DepthPolicy:      t.depthLimit.policy,
+		BackendBytes:     t.nsqd.queueBytes(t.name),
+		DepthRejectCount: atomic.LoadUint64(&t.depthRejectCount),
+		DepthDropCount:   atomic.LoadUint64(&t.depthDropCount),
+
+		BackendCompressionRatio: t.codec.CompressionRatio(),
+
 		E2eProcessingLatency: t.AggregateChannelE2eProcessingLatency().Result(),
 	}
 }
@@ -56,6 +83,23 @@ type ChannelStats struct {
 	Clients       []ClientStats `json:"clients"`
 	Paused        bool          `json:"paused"`
 
+	// max depth (0 = no limit), the size of the diskqueue files it is
+	// compared to and the messages rejected or dropped by its policy
+	MaxDepth         int64  `json:"max_depth"`
+	MaxDepthBytes    int64  `json:"max_depth_bytes"`
+	DepthPolicy      string `json:"depth_policy"`
+	BackendBytes     int64  `json:"backend_bytes"`
+	DepthRejectCount uint64 `json:"depth_reject_count"`
+	DepthDropCount   uint64 `json:"depth_drop_count"`
+
+	// number of partitions of a partitioned channel
+	Partitions int `json:"partitions,omitempty"`
+
+	BackendCompressionRatio float64 `json:"backend_compression_ratio"`
+
+	// the (approximate) timestamp of the oldest message, 0 when it is empty
+	OldestMessageTimestamp int64 `json:"oldest_message_timestamp"`
+
 	E2eProcessingLatency *quantile.Result `json:"e2e_processing_latency"`
 }
 
@@ -80,6 +124,19 @@ func NewChannelStats(c *Channel, clients []ClientStats, clientCount int) Channel
 		Clients:       clients,
 		Paused:        c.IsPaused(),
 
+		MaxDepth:         c.depthLimit.messages,
+		MaxDepthBytes:    c.depthLimit.bytes,
+		DepthPolicy:      c.depthLimit.policy,
+		BackendBytes:     c.nsqd.queueBytes(getBackendName(c.topicName, c.name)),
+		DepthRejectCount: atomic.LoadUint64(&c.depthRejectCount),
+		DepthDropCount:   atomic.LoadUint64(&c.depthDropCount),
+
+		Partitions: len(c.partitionOwners),
+
+		BackendCompressionRatio: c.codec.CompressionRatio(),
+
+		OldestMessageTimestamp: c.OldestMessageTimestamp(),
+
 		E2eProcessingLatency: c.e2eProcessingLatencyStream.Result(),
 	}
 }
@@ -158,3 +158,42 @@ func TestStatsChannelLocking(t *testing.T) {
 	test.Equal(t, 1, len(stats[0].Channels))
 	test.Equal(t, 25, stats[0].Channels[0].InFlightCount)
 }
+
+func TestStatsOldestMessage(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	_, _, nsqd := mustStartNSQD(opts)
+	defer os.RemoveAll(opts.DataPath)
+	defer nsqd.Exit()
+
+	topicName := "test_stats_oldest" + strconv.Itoa(int(time.Now().Unix()))
+	topic := nsqd.GetTopic(topicName)
+	channel := topic.GetChannel("ch")
+	test.Equal(t, int64(0), channel.OldestMessageTimestamp())
+
+	for _, ts := range []int64{100, 200} {
+		msg := NewMessage(topic.GenerateID(), []byte("test"))
+		msg.Timestamp = ts
+		channel.PutMessage(msg)
+	}
+	test.Equal(t, int64(100), channel.OldestMessageTimestamp())
+
+	// in flight
+	msg1 := <-channel.memoryMsgChan
+	channel.StartInFlightTimeout(msg1, 0, opts.MsgTimeout)
+	msg2 := <-channel.memoryMsgChan
+	channel.StartInFlightTimeout(msg2, 0, opts.MsgTimeout)
+	stats := nsqd.GetStats(topicName, "ch", false).Topics
+	test.Equal(t, int64(100), stats[0].Channels[0].OldestMessageTimestamp)
+
+	channel.FinishMessage(0, msg1.ID)
+	test.Equal(t, int64(200), channel.OldestMessageTimestamp())
+	channel.FinishMessage(0, msg2.ID)
+	test.Equal(t, int64(0), channel.OldestMessageTimestamp())
+
+	// deferred
+	msg := NewMessage(topic.GenerateID(), []byte("test"))
+	msg.Timestamp = 300
+	channel.StartDeferredTimeout(msg, time.Hour)
+	test.Equal(t, int64(300), channel.OldestMessageTimestamp())
+}
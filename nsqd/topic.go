@@ -15,14 +15,21 @@ import (
 
 type Topic struct {
 	// 64bit atomic vars need to be first for proper alignment on 32bit platforms
-	messageCount uint64
-	messageBytes uint64
+	messageCount     uint64
+	messageBytes     uint64
+	rateLimitedCount uint64
+	depthRejectCount uint64
+	depthDropCount   uint64
+
+	// the messages in the backend, see backendUsage
+	backendUsage backendUsage
 
 	sync.RWMutex
 
 	name              string
 	channelMap        map[string]*Channel
 	backend           BackendQueue
+	codec             *backendCodec
 	memoryMsgChan     chan *Message
 	startChan         chan int
 	exitChan          chan int
@@ -32,12 +39,15 @@ type Topic struct {
 	idFactory         *guidFactory
 
 	ephemeral      bool
+	httpReplies    bool // replies to /pubr requests, handed to the waiting requests
 	deleteCallback func(*Topic)
 	deleter        sync.Once
 
 	paused    int32
 	pauseChan chan int
 
+	depthLimit depthLimit
+
 	nsqd *NSQD
 }
 
@@ -60,9 +70,12 @@ func NewTopic(topicName string, nsqd *NSQD, deleteCallback func(*Topic)) *Topic
 		pauseChan:         make(chan int),
 		deleteCallback:    deleteCallback,
 		idFactory:         NewGUIDFactory(nsqd.getOpts().ID),
+		codec:             nsqd.newBackendCodec(topicName),
+		depthLimit:        nsqd.topicDepthLimit(topicName),
 	}
 	if strings.HasSuffix(topicName, "#ephemeral") {
 		t.ephemeral = true
+		t.httpReplies = topicName == nsqd.httpReplyTopicName()
 		t.backend = newDummyBackendQueue()
 	} else {
 		dqLogf := func(level diskqueue.LogLevel, f string, args ...interface{}) {
@@ -75,11 +88,12 @@ func NewTopic(topicName string, nsqd *NSQD, deleteCallback func(*Topic)) *Topic
 			nsqd.getOpts().MaxBytesPerQueue,
 			nsqd.getOpts().MaxBytesPerFile,
 			int32(minValidMsgLength),
-			int32(nsqd.getOpts().MaxMsgSize)+minValidMsgLength,
+			int32(nsqd.getOpts().MaxMsgSize)+minValidMsgLength+maxRecordOverhead,
 			nsqd.getOpts().SyncEvery,
 			nsqd.getOpts().SyncTimeout,
 			dqLogf,
 		)
+		t.backendUsage = newBackendUsage(nsqd.getOpts(), topicName, t.backend)
 	}
 	t.waitGroup.Wrap(t.messagePump)
 
@@ -188,7 +202,11 @@ func (t *Topic) PutMessage(m *Message) error {
 	if atomic.LoadInt32(&t.exitFlag) == 1 {
 		return errors.New("exiting")
 	}
-	err := t.put(m)
+	err := t.admit(1)
+	if err != nil {
+		return err
+	}
+	err = t.put(m)
 	if err != nil {
 		return err
 	}
@@ -204,6 +222,10 @@ func (t *Topic) PutMessages(msgs []*Message) error {
 	if atomic.LoadInt32(&t.exitFlag) == 1 {
 		return errors.New("exiting")
 	}
+	err := t.admit(len(msgs))
+	if err != nil {
+		return err
+	}
 
 	messageTotalBytes := 0
 
@@ -222,7 +244,44 @@ func (t *Topic) PutMessages(msgs []*Message) error {
 	return nil
 }
 
+// admit refuses a batch of count messages, as a whole and before any of them
+// is queued, when the topic or one of its channels is at a max depth with the
+// reject policy or when the disk is full
+//
+// this expects the caller to hold the topic read lock
+func (t *Topic) admit(count int) error {
+	if t.httpReplies {
+		return nil
+	}
+	err := t.checkDepthLimits(int64(count))
+	if err != nil {
+		return err
+	}
+	if t.ephemeral || !t.nsqd.isDiskFull() {
+		return nil
+	}
+	switch t.nsqd.getOpts().DiskFullPolicy {
+	case DiskFullReject:
+		return t.nsqd.rejectDiskFull(count)
+	case DiskFullMemoryOnly:
+		if cap(t.memoryMsgChan)-len(t.memoryMsgChan) < count {
+			return t.nsqd.rejectDiskFull(count)
+		}
+	}
+	return nil
+}
+
+// put queues a message admitted by admit
 func (t *Topic) put(m *Message) error {
+	if t.httpReplies {
+		t.nsqd.deliverReply(m)
+		return nil
+	}
+
+	if !t.applyDepthLimits() {
+		return nil
+	}
+
 	// If mem-queue-size == 0, avoid memory chan, for more consistent ordering,
 	// but try to use memory chan for deferred messages (they lose deferred timer
 	// in backend queue) or if topic is ephemeral (there is no backend queue).
@@ -234,7 +293,13 @@ func (t *Topic) put(m *Message) error {
 			break // write to backend
 		}
 	}
-	err := writeMessageToBackend(m, t.backend)
+	// the disk full watermarks are soft limits, a message of an admitted batch
+	// is written to the backend even if the disk became full (or, with the
+	// memory-only policy, the memory queue filled up) in the meantime
+	if !t.ephemeral {
+		t.nsqd.dropOldestOnDiskFull(t.backend, &t.backendUsage, int64(minValidMsgLength+len(m.Body)))
+	}
+	err := writeMessageToBackend(m, t.backend, t.codec, &t.backendUsage)
 	t.nsqd.SetHealth(err)
 	if err != nil {
 		t.nsqd.logf(LOG_ERROR,
@@ -287,7 +352,8 @@ func (t *Topic) messagePump() {
 		select {
 		case msg = <-memoryMsgChan:
 		case buf = <-backendChan:
-			msg, err = decodeMessage(buf)
+			t.backendUsage.read(buf)
+			msg, err = t.codec.decode(buf)
 			if err != nil {
 				t.nsqd.logf(LOG_ERROR, "failed to decode message - %s", err)
 				continue
@@ -330,6 +396,9 @@ func (t *Topic) messagePump() {
 				chanMsg = NewMessage(msg.ID, msg.Body)
 				chanMsg.Timestamp = msg.Timestamp
 				chanMsg.deferred = msg.deferred
+				chanMsg.PartitionKey = msg.PartitionKey
+				chanMsg.ReplyTo = msg.ReplyTo
+				chanMsg.CorrelationID = msg.CorrelationID
 			}
 			if chanMsg.deferred != 0 {
 				channel.PutMessageDeferred(chanMsg, chanMsg.deferred)
@@ -417,6 +486,7 @@ func (t *Topic) Empty() error {
 	}
 
 finish:
+	t.backendUsage.reset()
 	return t.backend.Empty()
 }
 
@@ -430,7 +500,7 @@ func (t *Topic) flush() error {
 	for {
 		select {
 		case msg := <-t.memoryMsgChan:
-			err := writeMessageToBackend(msg, t.backend)
+			err := writeMessageToBackend(msg, t.backend, t.codec, &t.backendUsage)
 			if err != nil {
 				t.nsqd.logf(LOG_ERROR,
 					"ERROR: failed to write message to backend - %s", err)
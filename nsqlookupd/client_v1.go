@@ -1,20 +1,50 @@
 package nsqlookupd
 
 import (
+	"fmt"
 	"net"
+	"sync/atomic"
+	"time"
 )
 
+// unixClientSequence numbers clients connected over a UNIX socket, which
+// have no remote address to tell them apart
+var unixClientSequence int64
+
 type ClientV1 struct {
 	net.Conn
 	peerInfo *PeerInfo
+	id       string
+
+	// tombstones of the producers this client replaced (see RemoveStaleProducers)
+	tombstones map[Registration]time.Time
 }
 
 func NewClientV1(conn net.Conn) *ClientV1 {
+	var id string
+	switch addr := conn.RemoteAddr().(type) {
+	case *net.UnixAddr, nil:
+		id = fmt.Sprintf("unix:%d", atomic.AddInt64(&unixClientSequence, 1))
+	default:
+		id = addr.String()
+	}
 	return &ClientV1{
 		Conn: conn,
+		id:   id,
 	}
 }
 
 func (c *ClientV1) String() string {
-	return c.RemoteAddr().String()
+	return c.id
+}
+
+// newProducer returns a producer of the client for a registration, tombstoned
+// if the producer it replaced was
+func (c *ClientV1) newProducer(k Registration) *Producer {
+	p := &Producer{peerInfo: c.peerInfo}
+	if tombstonedAt, ok := c.tombstones[k]; ok {
+		p.tombstoned = true
+		p.tombstonedAt = tombstonedAt
+	}
+	return p
 }
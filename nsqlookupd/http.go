@@ -1,9 +1,12 @@
 package nsqlookupd
 
 import (
+	"crypto/subtle"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/pprof"
+	"strings"
 	"sync/atomic"
 
 	"github.com/julienschmidt/httprouter"
@@ -39,13 +42,17 @@ func newHTTPServer(l *NSQLookupd) *httpServer {
 	router.Handle("GET", "/topics", http_api.Decorate(s.doTopics, log, http_api.V1))
 	router.Handle("GET", "/channels", http_api.Decorate(s.doChannels, log, http_api.V1))
 	router.Handle("GET", "/nodes", http_api.Decorate(s.doNodes, log, http_api.V1))
+	// registration streams and snapshots expose every nsqd, like the
+	// endpoints modifying registrations they need the admin token
+	router.Handle("GET", "/snapshot", http_api.Decorate(s.doSnapshot, s.requireAdmin, log, http_api.V1))
+	router.Handle("GET", "/watch", http_api.Decorate(s.doWatch, s.requireAdmin, log))
 
 	// only v1
-	router.Handle("POST", "/topic/create", http_api.Decorate(s.doCreateTopic, log, http_api.V1))
-	router.Handle("POST", "/topic/delete", http_api.Decorate(s.doDeleteTopic, log, http_api.V1))
-	router.Handle("POST", "/channel/create", http_api.Decorate(s.doCreateChannel, log, http_api.V1))
-	router.Handle("POST", "/channel/delete", http_api.Decorate(s.doDeleteChannel, log, http_api.V1))
-	router.Handle("POST", "/topic/tombstone", http_api.Decorate(s.doTombstoneTopicProducer, log, http_api.V1))
+	router.Handle("POST", "/topic/create", http_api.Decorate(s.doCreateTopic, s.requireAdmin, log, http_api.V1))
+	router.Handle("POST", "/topic/delete", http_api.Decorate(s.doDeleteTopic, s.requireAdmin, log, http_api.V1))
+	router.Handle("POST", "/channel/create", http_api.Decorate(s.doCreateChannel, s.requireAdmin, log, http_api.V1))
+	router.Handle("POST", "/channel/delete", http_api.Decorate(s.doDeleteChannel, s.requireAdmin, log, http_api.V1))
+	router.Handle("POST", "/topic/tombstone", http_api.Decorate(s.doTombstoneTopicProducer, s.requireAdmin, log, http_api.V1))
 
 	// debug
 	router.HandlerFunc("GET", "/debug/pprof", pprof.Index)
@@ -65,6 +72,21 @@ func (s *httpServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	s.router.ServeHTTP(w, req)
 }
 
+// requireAdmin authorizes requests with the --http-admin-token bearer token
+func (s *httpServer) requireAdmin(f http_api.APIHandler) http_api.APIHandler {
+	return func(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+		token := s.nsqlookupd.opts.HTTPAdminToken
+		if token != "" {
+			auth := req.Header.Get("Authorization")
+			if !strings.HasPrefix(auth, "Bearer ") ||
+				subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) != 1 {
+				return nil, http_api.Err{401, "UNAUTHORIZED"}
+			}
+		}
+		return f(w, req, ps)
+	}
+}
+
 func (s *httpServer) pingHandler(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
 	return "OK", nil
 }
@@ -117,10 +139,21 @@ func (s *httpServer) doLookup(w http.ResponseWriter, req *http.Request, ps httpr
 		return nil, http_api.Err{404, "TOPIC_NOT_FOUND"}
 	}
 
+	selectorParam, _ := reqParams.Get("selector")
+	selector, err := parseLabelSelector(selectorParam)
+	if err != nil {
+		return nil, http_api.Err{400, "INVALID_ARG_SELECTOR"}
+	}
+	preferHost, _ := reqParams.Get("prefer_host")
+	preferZone, _ := reqParams.Get("prefer_zone")
+	preferredOnly, _ := reqParams.Get("preferred_only")
+
 	channels := s.nsqlookupd.DB.FindRegistrations("channel", topicName, "*").SubKeys()
 	producers := s.nsqlookupd.DB.FindProducers("topic", topicName, "")
 	producers = producers.FilterByActive(s.nsqlookupd.opts.InactiveProducerTimeout,
 		s.nsqlookupd.opts.TombstoneLifetime)
+	producers = producers.FilterByLabels(selector)
+	producers = producers.OrderByPreference(preferHost, preferZone, preferredOnly == "true")
 	return map[string]interface{}{
 		"channels":  channels,
 		"producers": producers.PeerInfo(),
@@ -142,13 +175,29 @@ func (s *httpServer) doCreateTopic(w http.ResponseWriter, req *http.Request, ps
 		return nil, http_api.Err{400, "INVALID_ARG_TOPIC"}
 	}
 
+	selector, err := getSelectorArg(reqParams)
+	if err != nil {
+		return nil, err
+	}
+
 	s.nsqlookupd.logf(LOG_INFO, "DB: adding topic(%s)", topicName)
 	key := Registration{"topic", topicName, ""}
-	s.nsqlookupd.DB.AddRegistration(key)
+	s.nsqlookupd.DB.ProvisionRegistration(key, selector)
 
 	return nil, nil
 }
 
+// getSelectorArg returns the label selector of the nsqds to provision a
+// created topic or channel on
+func getSelectorArg(reqParams *http_api.ReqParams) (string, error) {
+	selector, _ := reqParams.Get("selector")
+	_, err := parseLabelSelector(selector)
+	if err != nil {
+		return "", http_api.Err{400, "INVALID_ARG_SELECTOR"}
+	}
+	return selector, nil
+}
+
 func (s *httpServer) doDeleteTopic(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
 	reqParams, err := http_api.NewReqParams(req)
 	if err != nil {
@@ -192,11 +241,15 @@ func (s *httpServer) doTombstoneTopicProducer(w http.ResponseWriter, req *http.R
 	}
 
 	s.nsqlookupd.logf(LOG_INFO, "DB: setting tombstone for producer@%s of topic(%s)", node, topicName)
-	producers := s.nsqlookupd.DB.FindProducers("topic", topicName, "")
+	key := Registration{"topic", topicName, ""}
+	producers := s.nsqlookupd.DB.FindProducers(key.Category, key.Key, key.SubKey)
 	for _, p := range producers {
 		thisNode := fmt.Sprintf("%s:%d", p.peerInfo.BroadcastAddress, p.peerInfo.HTTPPort)
+		if p.peerInfo.HTTPSocket != "" {
+			thisNode = "unix://" + p.peerInfo.HTTPSocket
+		}
 		if thisNode == node {
-			p.Tombstone()
+			s.nsqlookupd.DB.TombstoneProducer(key, p)
 		}
 	}
 
@@ -214,9 +267,14 @@ func (s *httpServer) doCreateChannel(w http.ResponseWriter, req *http.Request, p
 		return nil, http_api.Err{400, err.Error()}
 	}
 
+	selector, err := getSelectorArg(reqParams)
+	if err != nil {
+		return nil, err
+	}
+
 	s.nsqlookupd.logf(LOG_INFO, "DB: adding channel(%s) in topic(%s)", channelName, topicName)
 	key := Registration{"channel", topicName, channelName}
-	s.nsqlookupd.DB.AddRegistration(key)
+	s.nsqlookupd.DB.ProvisionRegistration(key, selector)
 
 	s.nsqlookupd.logf(LOG_INFO, "DB: adding topic(%s)", topicName)
 	key = Registration{"topic", topicName, ""}
@@ -250,14 +308,17 @@ func (s *httpServer) doDeleteChannel(w http.ResponseWriter, req *http.Request, p
 }
 
 type node struct {
-	RemoteAddress    string   `json:"remote_address"`
-	Hostname         string   `json:"hostname"`
-	BroadcastAddress string   `json:"broadcast_address"`
-	TCPPort          int      `json:"tcp_port"`
-	HTTPPort         int      `json:"http_port"`
-	Version          string   `json:"version"`
-	Tombstones       []bool   `json:"tombstones"`
-	Topics           []string `json:"topics"`
+	RemoteAddress    string            `json:"remote_address"`
+	Hostname         string            `json:"hostname"`
+	BroadcastAddress string            `json:"broadcast_address"`
+	TCPPort          int               `json:"tcp_port"`
+	HTTPPort         int               `json:"http_port"`
+	TCPSocket        string            `json:"tcp_socket,omitempty"`
+	HTTPSocket       string            `json:"http_socket,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	Version          string            `json:"version"`
+	Tombstones       []bool            `json:"tombstones"`
+	Topics           []string          `json:"topics"`
 }
 
 func (s *httpServer) doNodes(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
@@ -292,6 +353,9 @@ func (s *httpServer) doNodes(w http.ResponseWriter, req *http.Request, ps httpro
 			BroadcastAddress: p.peerInfo.BroadcastAddress,
 			TCPPort:          p.peerInfo.TCPPort,
 			HTTPPort:         p.peerInfo.HTTPPort,
+			TCPSocket:        p.peerInfo.TCPSocket,
+			HTTPSocket:       p.peerInfo.HTTPSocket,
+			Labels:           p.peerInfo.Labels,
 			Version:          p.peerInfo.Version,
 			Tombstones:       tombstones,
 			Topics:           topics,
@@ -303,6 +367,74 @@ func (s *httpServer) doNodes(w http.ResponseWriter, req *http.Request, ps httpro
 	}, nil
 }
 
+// doSnapshot returns the registrations and the producers connected to this
+// nsqlookupd, for peers to merge
+func (s *httpServer) doSnapshot(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	return s.nsqlookupd.DB.Snapshot(true), nil
+}
+
+// doWatch streams the registration events of a topic (or of all topics) as
+// newline delimited JSON, or as server-sent events when the client accepts
+// text/event-stream
+func (s *httpServer) doWatch(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
+	reqParams, err := http_api.NewReqParams(req)
+	if err != nil {
+		return nil, respondErr(w, http_api.Err{400, "INVALID_REQUEST"})
+	}
+
+	topicName, _ := reqParams.Get("topic")
+	if topicName != "" && !protocol.IsValidTopicName(topicName) {
+		return nil, respondErr(w, http_api.Err{400, "INVALID_ARG_TOPIC"})
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, respondErr(w, http_api.Err{500, "STREAMING_UNSUPPORTED"})
+	}
+
+	sse := strings.Contains(req.Header.Get("Accept"), "text/event-stream")
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("X-NSQ-Content-Type", "nsq; version=1.0")
+	w.WriteHeader(200)
+	flusher.Flush()
+
+	watcher := s.nsqlookupd.DB.Watch(topicName)
+	defer s.nsqlookupd.DB.Unwatch(watcher)
+
+	err = s.nsqlookupd.streamEvents(watcher, req.Context().Done(), func(e RegistrationEvent) error {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if sse {
+			_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		} else {
+			_, err = fmt.Fprintf(w, "%s\n", data)
+		}
+		if err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+	if err != nil {
+		s.nsqlookupd.logf(LOG_WARN, "WATCH(%s): %s", req.RemoteAddr, err)
+	}
+	return nil, nil
+}
+
+// respondErr writes an error response for handlers not decorated with
+// http_api.V1 and returns it for logging
+func respondErr(w http.ResponseWriter, err http_api.Err) error {
+	http_api.RespondV1(w, err.Code, err)
+	return err
+}
+
 func (s *httpServer) doDebug(w http.ResponseWriter, req *http.Request, ps httprouter.Params) (interface{}, error) {
 	s.nsqlookupd.DB.RLock()
 	defer s.nsqlookupd.DB.RUnlock()
@@ -317,6 +449,9 @@ func (s *httpServer) doDebug(w http.ResponseWriter, req *http.Request, ps httpro
 				"broadcast_address": p.peerInfo.BroadcastAddress,
 				"tcp_port":          p.peerInfo.TCPPort,
 				"http_port":         p.peerInfo.HTTPPort,
+				"tcp_socket":        p.peerInfo.TCPSocket,
+				"http_socket":       p.peerInfo.HTTPSocket,
+				"labels":            p.peerInfo.Labels,
 				"version":           p.peerInfo.Version,
 				"last_update":       atomic.LoadInt64(&p.peerInfo.lastUpdate),
 				"tombstoned":        p.tombstoned,
@@ -0,0 +1,113 @@
+package nsqlookupd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// zoneLabel is the label compared to the prefer_zone parameter of /lookup
+const zoneLabel = "zone"
+
+// labelRequirement is one of the comma separated requirements of a label
+// selector: key=value, key!=value, key (the label is set) or !key (it is not)
+type labelRequirement struct {
+	key    string
+	value  string
+	negate bool
+	exists bool
+}
+
+type labelSelector []labelRequirement
+
+func parseLabelSelector(s string) (labelSelector, error) {
+	var selector labelSelector
+	if strings.TrimSpace(s) == "" {
+		return selector, nil
+	}
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		var req labelRequirement
+		switch {
+		case strings.Contains(term, "!="):
+			kv := strings.SplitN(term, "!=", 2)
+			req = labelRequirement{key: kv[0], value: kv[1], negate: true}
+		case strings.Contains(term, "="):
+			kv := strings.SplitN(term, "=", 2)
+			req = labelRequirement{key: kv[0], value: kv[1]}
+		case strings.HasPrefix(term, "!"):
+			req = labelRequirement{key: term[1:], exists: true, negate: true}
+		default:
+			req = labelRequirement{key: term, exists: true}
+		}
+		if req.key == "" {
+			return nil, fmt.Errorf("invalid label selector term %q", term)
+		}
+		selector = append(selector, req)
+	}
+	return selector, nil
+}
+
+// Matches returns whether labels satisfy all of the requirements
+func (ls labelSelector) Matches(labels map[string]string) bool {
+	for _, req := range ls {
+		value, ok := labels[req.key]
+		var match bool
+		if req.exists {
+			match = ok
+		} else {
+			match = ok && value == req.value
+		}
+		if match == req.negate {
+			return false
+		}
+	}
+	return true
+}
+
+func (pp Producers) FilterByLabels(selector labelSelector) Producers {
+	if len(selector) == 0 {
+		return pp
+	}
+	results := Producers{}
+	for _, p := range pp {
+		if selector.Matches(p.peerInfo.Labels) {
+			results = append(results, p)
+		}
+	}
+	return results
+}
+
+// preference ranks a producer for a consumer on host in zone, lower is better
+func (p *Producer) preference(host string, zone string) int {
+	if host != "" && (p.peerInfo.Hostname == host || p.peerInfo.BroadcastAddress == host) {
+		return 0
+	}
+	if zone != "" && p.peerInfo.Labels[zoneLabel] == zone {
+		return 1
+	}
+	return 2
+}
+
+// OrderByPreference orders the producers on host first, then those in zone,
+// then the others. With preferredOnly only the best of these groups that has
+// producers is returned.
+func (pp Producers) OrderByPreference(host string, zone string, preferredOnly bool) Producers {
+	if (host == "" && zone == "") || len(pp) == 0 {
+		return pp
+	}
+	results := make(Producers, len(pp))
+	copy(results, pp)
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].preference(host, zone) < results[j].preference(host, zone)
+	})
+	if preferredOnly {
+		best := results[0].preference(host, zone)
+		for i, p := range results {
+			if p.preference(host, zone) != best {
+				return results[:i]
+			}
+		}
+	}
+	return results
+}
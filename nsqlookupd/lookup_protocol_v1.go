@@ -2,6 +2,7 @@ package nsqlookupd
 
 import (
 	"bufio"
+	"crypto/subtle"
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
@@ -63,6 +64,11 @@ func (p *LookupProtocolV1) IOLoop(c protocol.Client) error {
 			continue
 		}
 
+		if params[0] == "WATCH" {
+			// the connection was dedicated to streaming events
+			break
+		}
+
 		if response != nil {
 			_, err = protocol.SendResponse(client, response)
 			if err != nil {
@@ -96,6 +102,10 @@ func (p *LookupProtocolV1) Exec(client *ClientV1, reader *bufio.Reader, params [
 		return p.REGISTER(client, reader, params[1:])
 	case "UNREGISTER":
 		return p.UNREGISTER(client, reader, params[1:])
+	case "WATCH":
+		return p.WATCH(client, reader, params[1:])
+	case "PROVISION":
+		return p.PROVISION(client, params[1:])
 	}
 	return nil, protocol.NewFatalClientErr(nil, "E_INVALID", fmt.Sprintf("invalid command %s", params[0]))
 }
@@ -134,13 +144,13 @@ func (p *LookupProtocolV1) REGISTER(client *ClientV1, reader *bufio.Reader, para
 
 	if channel != "" {
 		key := Registration{"channel", topic, channel}
-		if p.nsqlookupd.DB.AddProducer(key, &Producer{peerInfo: client.peerInfo}) {
+		if p.nsqlookupd.DB.AddProducer(key, client.newProducer(key)) {
 			p.nsqlookupd.logf(LOG_INFO, "DB: client(%s) REGISTER category:%s key:%s subkey:%s",
 				client, "channel", topic, channel)
 		}
 	}
 	key := Registration{"topic", topic, ""}
-	if p.nsqlookupd.DB.AddProducer(key, &Producer{peerInfo: client.peerInfo}) {
+	if p.nsqlookupd.DB.AddProducer(key, client.newProducer(key)) {
 		p.nsqlookupd.logf(LOG_INFO, "DB: client(%s) REGISTER category:%s key:%s subkey:%s",
 			client, "topic", topic, "")
 	}
@@ -197,6 +207,82 @@ func (p *LookupProtocolV1) UNREGISTER(client *ClientV1, reader *bufio.Reader, pa
 	return []byte("OK"), nil
 }
 
+// WATCH streams the registration events of a topic (or of all topics when
+// none is given) as JSON responses following the OK, until the client
+// disconnects. Anything else the client sends is ignored. With --auth-secret
+// the client must have IDENTIFYed with it first.
+func (p *LookupProtocolV1) WATCH(client *ClientV1, reader *bufio.Reader, params []string) ([]byte, error) {
+	if p.nsqlookupd.opts.AuthSecret != "" && client.peerInfo == nil {
+		return nil, protocol.NewFatalClientErr(nil, "E_UNAUTHORIZED", "WATCH requires an authenticated IDENTIFY")
+	}
+
+	var topicName string
+	if len(params) > 0 {
+		topicName = params[0]
+	}
+	if topicName != "" && !protocol.IsValidTopicName(topicName) {
+		return nil, protocol.NewFatalClientErr(nil, "E_BAD_TOPIC", fmt.Sprintf("WATCH topic name '%s' is not valid", topicName))
+	}
+
+	watcher := p.nsqlookupd.DB.Watch(topicName)
+	defer p.nsqlookupd.DB.Unwatch(watcher)
+
+	_, err := protocol.SendResponse(client, []byte("OK"))
+	if err != nil {
+		return nil, nil
+	}
+
+	// detect the client disconnecting
+	done := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, reader)
+		close(done)
+	}()
+
+	p.nsqlookupd.logf(LOG_INFO, "CLIENT(%s): WATCH topic:%s", client, topicName)
+	err = p.nsqlookupd.streamEvents(watcher, done, func(e RegistrationEvent) error {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = protocol.SendResponse(client, data)
+		return err
+	})
+	if err != nil {
+		p.nsqlookupd.logf(LOG_WARN, "CLIENT(%s): WATCH - %s", client, err)
+	}
+
+	client.Close()
+	<-done
+	return nil, nil
+}
+
+// PROVISION returns the topics (and their channels) created through the HTTP
+// API whose selector matches the labels of the client
+func (p *LookupProtocolV1) PROVISION(client *ClientV1, params []string) ([]byte, error) {
+	if client.peerInfo == nil {
+		return nil, protocol.NewFatalClientErr(nil, "E_INVALID", "client must IDENTIFY")
+	}
+
+	topics := make(map[string][]string)
+	for _, k := range p.nsqlookupd.DB.FindProvisioned(client.peerInfo.Labels) {
+		if _, ok := topics[k.Key]; !ok {
+			topics[k.Key] = []string{}
+		}
+		if k.Category == "channel" {
+			topics[k.Key] = append(topics[k.Key], k.SubKey)
+		}
+	}
+
+	response, err := json.Marshal(map[string]interface{}{
+		"topics": topics,
+	})
+	if err != nil {
+		return nil, protocol.NewFatalClientErr(err, "E_PROVISION_FAILED", "PROVISION failed to marshal response")
+	}
+	return response, nil
+}
+
 func (p *LookupProtocolV1) IDENTIFY(client *ClientV1, reader *bufio.Reader, params []string) ([]byte, error) {
 	var err error
 
@@ -217,16 +303,28 @@ func (p *LookupProtocolV1) IDENTIFY(client *ClientV1, reader *bufio.Reader, para
 	}
 
 	// body is a json structure with producer information
-	peerInfo := PeerInfo{id: client.RemoteAddr().String()}
+	peerInfo := PeerInfo{id: client.String()}
 	err = json.Unmarshal(body, &peerInfo)
 	if err != nil {
 		return nil, protocol.NewFatalClientErr(err, "E_BAD_BODY", "IDENTIFY failed to decode JSON body")
 	}
 
-	peerInfo.RemoteAddress = client.RemoteAddr().String()
+	if p.nsqlookupd.opts.AuthSecret != "" {
+		var auth struct {
+			AuthSecret string `json:"auth_secret"`
+		}
+		json.Unmarshal(body, &auth)
+		if subtle.ConstantTimeCompare([]byte(auth.AuthSecret), []byte(p.nsqlookupd.opts.AuthSecret)) != 1 {
+			return nil, protocol.NewFatalClientErr(nil, "E_UNAUTHORIZED", "IDENTIFY invalid auth_secret")
+		}
+	}
+
+	peerInfo.RemoteAddress = client.String()
 
-	// require all fields
-	if peerInfo.BroadcastAddress == "" || peerInfo.TCPPort == 0 || peerInfo.HTTPPort == 0 || peerInfo.Version == "" {
+	// require all fields, nsqd listening on UNIX sockets has paths instead of ports
+	if peerInfo.BroadcastAddress == "" || peerInfo.Version == "" ||
+		(peerInfo.TCPPort == 0 && peerInfo.TCPSocket == "") ||
+		(peerInfo.HTTPPort == 0 && peerInfo.HTTPSocket == "") {
 		return nil, protocol.NewFatalClientErr(nil, "E_BAD_BODY", "IDENTIFY missing fields")
 	}
 
@@ -236,15 +334,29 @@ func (p *LookupProtocolV1) IDENTIFY(client *ClientV1, reader *bufio.Reader, para
 		client, peerInfo.BroadcastAddress, peerInfo.TCPPort, peerInfo.HTTPPort, peerInfo.Version)
 
 	client.peerInfo = &peerInfo
+	client.tombstones = p.nsqlookupd.DB.RemoveStaleProducers(client.peerInfo)
 	if p.nsqlookupd.DB.AddProducer(Registration{"client", "", ""}, &Producer{peerInfo: client.peerInfo}) {
 		p.nsqlookupd.logf(LOG_INFO, "DB: client(%s) REGISTER category:%s key:%s subkey:%s", client, "client", "", "")
 	}
 
 	// build a response
 	data := make(map[string]interface{})
-	data["tcp_port"] = p.nsqlookupd.RealTCPAddr().Port
-	data["http_port"] = p.nsqlookupd.RealHTTPAddr().Port
+	data["tcp_port"] = 0
+	data["http_port"] = 0
+	switch addr := p.nsqlookupd.RealTCPAddr().(type) {
+	case *net.TCPAddr:
+		data["tcp_port"] = addr.Port
+	case *net.UnixAddr:
+		data["tcp_socket"] = addr.Name
+	}
+	switch addr := p.nsqlookupd.RealHTTPAddr().(type) {
+	case *net.TCPAddr:
+		data["http_port"] = addr.Port
+	case *net.UnixAddr:
+		data["http_socket"] = addr.Name
+	}
 	data["version"] = version.Binary
+	data["provision"] = true
 	hostname, err := os.Hostname()
 	if err != nil {
 		log.Fatalf("ERROR: unable to get hostname %s", err)
@@ -1,6 +1,9 @@
 package nsqlookupd
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"log"
 	"net"
@@ -19,6 +22,9 @@ type NSQLookupd struct {
 	tcpListener  net.Listener
 	httpListener net.Listener
 	tcpServer    *tcpServer
+	peerClient   *http_api.Client
+	snapshotMtx  sync.Mutex
+	exitChan     chan int
 	waitGroup    util.WaitGroupWrapper
 	DB           *RegistrationDB
 }
@@ -30,18 +36,40 @@ func New(opts *Options) (*NSQLookupd, error) {
 		opts.Logger = log.New(os.Stderr, opts.LogPrefix, log.Ldate|log.Ltime|log.Lmicroseconds)
 	}
 	l := &NSQLookupd{
-		opts: opts,
-		DB:   NewRegistrationDB(),
+		opts:       opts,
+		DB:         NewRegistrationDB(),
+		peerClient: newPeerClient(),
+		exitChan:   make(chan int),
 	}
 
 	l.logf(LOG_INFO, version.String("nsqlookupd"))
 
+	if opts.DataPath != "" {
+		err = l.LoadSnapshot()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	socketType := "tcp"
+	if opts.UseUnixSockets {
+		socketType = "unix"
+	}
+
+	tlsConfig, err := buildTLSConfig(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build TLS config - %s", err)
+	}
+
 	l.tcpServer = &tcpServer{nsqlookupd: l}
-	l.tcpListener, err = net.Listen("tcp", opts.TCPAddress)
+	l.tcpListener, err = net.Listen(socketType, opts.TCPAddress)
 	if err != nil {
 		return nil, fmt.Errorf("listen (%s) failed - %s", opts.TCPAddress, err)
 	}
-	l.httpListener, err = net.Listen("tcp", opts.HTTPAddress)
+	if tlsConfig != nil {
+		l.tcpListener = tls.NewListener(l.tcpListener, tlsConfig)
+	}
+	l.httpListener, err = net.Listen(socketType, opts.HTTPAddress)
 	if err != nil {
 		return nil, fmt.Errorf("listen (%s) failed - %s", opts.HTTPAddress, err)
 	}
@@ -70,20 +98,38 @@ func (l *NSQLookupd) Main() error {
 	l.waitGroup.Wrap(func() {
 		exitFunc(http_api.Serve(l.httpListener, httpServer, "HTTP", l.logf))
 	})
+	if l.opts.DataPath != "" || len(l.opts.PeerHTTPAddresses) > 0 {
+		l.waitGroup.Wrap(l.snapshotLoop)
+	}
 
 	err := <-exitCh
 	return err
 }
 
-func (l *NSQLookupd) RealTCPAddr() *net.TCPAddr {
-	return l.tcpListener.Addr().(*net.TCPAddr)
+// RealTCPAddr returns the address of the TCP listener, a *net.UnixAddr
+// when listening on a UNIX socket
+func (l *NSQLookupd) RealTCPAddr() net.Addr {
+	return l.tcpListener.Addr()
 }
 
-func (l *NSQLookupd) RealHTTPAddr() *net.TCPAddr {
-	return l.httpListener.Addr().(*net.TCPAddr)
+// RealHTTPAddr returns the address of the HTTP listener, a *net.UnixAddr
+// when listening on a UNIX socket
+func (l *NSQLookupd) RealHTTPAddr() net.Addr {
+	return l.httpListener.Addr()
 }
 
 func (l *NSQLookupd) Exit() {
+	// persist before closing connections, which removes their producers
+	l.snapshotMtx.Lock()
+	close(l.exitChan)
+	if l.opts.DataPath != "" {
+		err := l.PersistSnapshot()
+		if err != nil {
+			l.logf(LOG_ERROR, "failed to persist snapshot - %s", err)
+		}
+	}
+	l.snapshotMtx.Unlock()
+
 	if l.tcpListener != nil {
 		l.tcpListener.Close()
 	}
@@ -97,3 +143,49 @@ func (l *NSQLookupd) Exit() {
 	}
 	l.waitGroup.Wait()
 }
+
+func buildTLSConfig(opts *Options) (*tls.Config, error) {
+	if opts.TLSCert == "" && opts.TLSKey == "" {
+		if opts.TLSClientAuthPolicy != "" || opts.TLSRootCAFile != "" {
+			return nil, errors.New("--tls-cert and --tls-key are required for TLS options")
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(opts.TLSCert, opts.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var tlsClientAuthPolicy tls.ClientAuthType
+	switch opts.TLSClientAuthPolicy {
+	case "":
+		tlsClientAuthPolicy = tls.NoClientCert
+	case "require":
+		tlsClientAuthPolicy = tls.RequireAnyClientCert
+	case "require-verify":
+		tlsClientAuthPolicy = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("invalid --tls-client-auth-policy %q", opts.TLSClientAuthPolicy)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tlsClientAuthPolicy,
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if opts.TLSRootCAFile != "" {
+		tlsCertPool := x509.NewCertPool()
+		caCertFile, err := os.ReadFile(opts.TLSRootCAFile)
+		if err != nil {
+			return nil, err
+		}
+		if !tlsCertPool.AppendCertsFromPEM(caCertFile) {
+			return nil, errors.New("failed to append certificate to pool")
+		}
+		tlsConfig.ClientCAs = tlsCertPool
+	}
+
+	return tlsConfig, nil
+}
@@ -1,8 +1,15 @@
 package nsqlookupd
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"net"
+	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -10,6 +17,7 @@ import (
 	"github.com/nsqio/nsq/internal/clusterinfo"
 	"github.com/nsqio/nsq/internal/http_api"
 	"github.com/nsqio/nsq/internal/test"
+	"github.com/nsqio/nsq/nsqd"
 )
 
 const (
@@ -47,7 +55,7 @@ func mustStartLookupd(opts *Options) (*net.TCPAddr, *net.TCPAddr, *NSQLookupd) {
 			panic(err)
 		}
 	}()
-	return nsqlookupd.RealTCPAddr(), nsqlookupd.RealHTTPAddr(), nsqlookupd
+	return nsqlookupd.RealTCPAddr().(*net.TCPAddr), nsqlookupd.RealHTTPAddr().(*net.TCPAddr), nsqlookupd
 }
 
 func mustConnectLookupd(t *testing.T, tcpAddr *net.TCPAddr) net.Conn {
@@ -357,3 +365,569 @@ func TestTombstonedNodes(t *testing.T) {
 	test.Equal(t, topicName, producers[0].Topics[0].Topic)
 	test.Equal(t, true, producers[0].Topics[0].Tombstoned)
 }
+
+func TestUnixSockets(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "nsq-test-")
+	test.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.UseUnixSockets = true
+	opts.TCPAddress = filepath.Join(tmpDir, "nsqlookupd.tcp.sock")
+	opts.HTTPAddress = filepath.Join(tmpDir, "nsqlookupd.http.sock")
+	nsqlookupd, err := New(opts)
+	test.Nil(t, err)
+	go nsqlookupd.Main()
+	defer nsqlookupd.Exit()
+
+	nsqdOpts := nsqd.NewOptions()
+	nsqdOpts.Logger = opts.Logger
+	nsqdOpts.UseUnixSockets = true
+	nsqdOpts.TCPAddress = filepath.Join(tmpDir, "nsqd.tcp.sock")
+	nsqdOpts.HTTPAddress = filepath.Join(tmpDir, "nsqd.http.sock")
+	nsqdOpts.NSQLookupdTCPAddresses = []string{"unix://" + opts.TCPAddress}
+	nsqdOpts.DataPath = tmpDir
+	nsqd1, err := nsqd.New(nsqdOpts)
+	test.Nil(t, err)
+	go nsqd1.Main()
+	defer nsqd1.Exit()
+
+	// a second producer over the same socket must not be confused with nsqd
+	conn, err := net.DialTimeout("unix", opts.TCPAddress, time.Second)
+	test.Nil(t, err)
+	defer conn.Close()
+	conn.Write(nsq.MagicV1)
+	identify(t, conn)
+
+	nsqd1.GetTopic("unix_topic")
+
+	client := http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", opts.HTTPAddress)
+			},
+		},
+	}
+	var lr struct {
+		Producers []*PeerInfo `json:"producers"`
+	}
+	for i := 0; i < 100; i++ {
+		resp, err := client.Get("http://nsqlookupd/lookup?topic=unix_topic")
+		test.Nil(t, err)
+		err = json.NewDecoder(resp.Body).Decode(&lr)
+		resp.Body.Close()
+		test.Nil(t, err)
+		if len(lr.Producers) > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	test.Equal(t, 1, len(lr.Producers))
+	test.Equal(t, 0, lr.Producers[0].TCPPort)
+	test.Equal(t, nsqdOpts.TCPAddress, lr.Producers[0].TCPSocket)
+	test.Equal(t, nsqdOpts.HTTPAddress, lr.Producers[0].HTTPSocket)
+
+	producers := nsqlookupd.DB.FindProducers("client", "", "")
+	test.Equal(t, 2, len(producers))
+	test.NotEqual(t, producers[0].peerInfo.id, producers[1].peerInfo.id)
+}
+
+func TestSnapshotPersistence(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "nsq-test-")
+	test.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.DataPath = tmpDir
+	tcpAddr, httpAddr, nsqlookupd := mustStartLookupd(opts)
+
+	conn := mustConnectLookupd(t, tcpAddr)
+	identify(t, conn)
+	for _, topicName := range []string{"persisted", "tombstoned"} {
+		nsq.Register(topicName, "ch").WriteTo(conn)
+		_, err = nsq.ReadResponse(conn)
+		test.Nil(t, err)
+	}
+	endpoint := fmt.Sprintf("http://%s/topic/tombstone?topic=tombstoned&node=%s:%d",
+		httpAddr, HostAddr, HTTPPort)
+	err = http_api.NewClient(nil, ConnectTimeout, RequestTimeout).POSTV1(endpoint)
+	test.Nil(t, err)
+	endpoint = fmt.Sprintf("http://%s/topic/create?topic=created", httpAddr)
+	err = http_api.NewClient(nil, ConnectTimeout, RequestTimeout).POSTV1(endpoint)
+	test.Nil(t, err)
+
+	// exit before the nsqd disconnects, like a restart would
+	nsqlookupd.Exit()
+	conn.Close()
+
+	opts = NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.DataPath = tmpDir
+	tcpAddr, httpAddr, nsqlookupd = mustStartLookupd(opts)
+	defer nsqlookupd.Exit()
+
+	tr := TopicsDoc{}
+	endpoint = fmt.Sprintf("http://%s/topics", httpAddr)
+	err = http_api.NewClient(nil, ConnectTimeout, RequestTimeout).GETV1(endpoint, &tr)
+	test.Nil(t, err)
+	test.Equal(t, 3, len(tr.Topics))
+
+	lr := LookupDoc{}
+	endpoint = fmt.Sprintf("http://%s/lookup?topic=persisted", httpAddr)
+	err = http_api.NewClient(nil, ConnectTimeout, RequestTimeout).GETV1(endpoint, &lr)
+	test.Nil(t, err)
+	test.Equal(t, 1, len(lr.Producers))
+	test.Equal(t, TCPPort, lr.Producers[0].TCPPort)
+
+	endpoint = fmt.Sprintf("http://%s/lookup?topic=tombstoned", httpAddr)
+	err = http_api.NewClient(nil, ConnectTimeout, RequestTimeout).GETV1(endpoint, &lr)
+	test.Nil(t, err)
+	test.Equal(t, 0, len(lr.Producers))
+
+	// the nsqd reconnects, replacing the restored producer and its tombstone
+	conn = mustConnectLookupd(t, tcpAddr)
+	defer conn.Close()
+	identify(t, conn)
+	for _, topicName := range []string{"persisted", "tombstoned"} {
+		nsq.Register(topicName, "ch").WriteTo(conn)
+		_, err = nsq.ReadResponse(conn)
+		test.Nil(t, err)
+	}
+	producers := nsqlookupd.DB.FindProducers("topic", "persisted", "")
+	test.Equal(t, 1, len(producers))
+	test.Equal(t, "", producers[0].peerInfo.source)
+	producers = nsqlookupd.DB.FindProducers("topic", "tombstoned", "")
+	test.Equal(t, 1, len(producers))
+	test.Equal(t, true, producers[0].IsTombstoned(opts.TombstoneLifetime))
+}
+
+func TestPeerSync(t *testing.T) {
+	opts1 := NewOptions()
+	opts1.Logger = test.NewTestLogger(t)
+	opts1.HTTPAdminToken = "t0ken"
+	tcpAddr1, httpAddr1, nsqlookupd1 := mustStartLookupd(opts1)
+	defer nsqlookupd1.Exit()
+
+	conn := mustConnectLookupd(t, tcpAddr1)
+	defer conn.Close()
+	identify(t, conn)
+	nsq.Register("synced", "ch").WriteTo(conn)
+	_, err := nsq.ReadResponse(conn)
+	test.Nil(t, err)
+
+	opts2 := NewOptions()
+	opts2.Logger = test.NewTestLogger(t)
+	opts2.SnapshotInterval = 10 * time.Millisecond
+	opts2.HTTPAdminToken = "t0ken"
+	opts2.PeerHTTPAddresses = []string{httpAddr1.String()}
+	_, httpAddr2, nsqlookupd2 := mustStartLookupd(opts2)
+	defer nsqlookupd2.Exit()
+
+	lr := LookupDoc{}
+	endpoint := fmt.Sprintf("http://%s/lookup?topic=synced", httpAddr2)
+	for i := 0; i < 100; i++ {
+		err = http_api.NewClient(nil, ConnectTimeout, RequestTimeout).GETV1(endpoint, &lr)
+		if err == nil && len(lr.Producers) == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	test.Nil(t, err)
+	test.Equal(t, 1, len(lr.Producers))
+	test.Equal(t, HostAddr, lr.Producers[0].BroadcastAddress)
+	test.Equal(t, 1, len(lr.Channels))
+
+	// removals propagate as well
+	endpoint = fmt.Sprintf("http://%s/channel/delete?topic=synced&channel=ch", httpAddr1)
+	err = http_api.NewClient(nil, ConnectTimeout, RequestTimeout).POSTV1WithHeader(endpoint,
+		http.Header{"Authorization": []string{"Bearer t0ken"}})
+	test.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		if len(nsqlookupd2.DB.FindRegistrations("channel", "synced", "*")) == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	test.Equal(t, 0, len(nsqlookupd2.DB.FindRegistrations("channel", "synced", "*")))
+}
+
+func TestWatch(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	tcpAddr, httpAddr, nsqlookupd := mustStartLookupd(opts)
+	defer nsqlookupd.Exit()
+
+	conn := mustConnectLookupd(t, tcpAddr)
+	identify(t, conn)
+	nsq.Register("watched", "").WriteTo(conn)
+	_, err := nsq.ReadResponse(conn)
+	test.Nil(t, err)
+
+	// HTTP
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET",
+		fmt.Sprintf("http://%s/watch?topic=watched", httpAddr), nil)
+	resp, err := http.DefaultClient.Do(req)
+	test.Nil(t, err)
+	defer resp.Body.Close()
+	test.Equal(t, 200, resp.StatusCode)
+	scanner := bufio.NewScanner(resp.Body)
+	nextHTTPEvent := func() RegistrationEvent {
+		var e RegistrationEvent
+		test.Equal(t, true, scanner.Scan())
+		test.Nil(t, json.Unmarshal(scanner.Bytes(), &e))
+		return e
+	}
+
+	// TCP
+	watchConn := mustConnectLookupd(t, tcpAddr)
+	defer watchConn.Close()
+	_, err = watchConn.Write([]byte("WATCH watched\n"))
+	test.Nil(t, err)
+	data, err := nsq.ReadResponse(watchConn)
+	test.Nil(t, err)
+	test.Equal(t, []byte("OK"), data)
+	nextTCPEvent := func() RegistrationEvent {
+		var e RegistrationEvent
+		data, err := nsq.ReadResponse(watchConn)
+		test.Nil(t, err)
+		test.Nil(t, json.Unmarshal(data, &e))
+		return e
+	}
+
+	for _, next := range []func() RegistrationEvent{nextHTTPEvent, nextTCPEvent} {
+		e := next()
+		test.Equal(t, EventRegister, e.Type)
+		test.Equal(t, "watched", e.Topic)
+		test.Equal(t, "", e.Channel)
+		test.Equal(t, HostAddr, e.Producer.BroadcastAddress)
+		test.Equal(t, TCPPort, e.Producer.TCPPort)
+	}
+
+	// events of other topics are not sent
+	nsq.Register("other", "").WriteTo(conn)
+	_, err = nsq.ReadResponse(conn)
+	test.Nil(t, err)
+
+	nsq.Register("watched", "ch").WriteTo(conn)
+	_, err = nsq.ReadResponse(conn)
+	test.Nil(t, err)
+	for _, next := range []func() RegistrationEvent{nextHTTPEvent, nextTCPEvent} {
+		e := next()
+		test.Equal(t, EventRegister, e.Type)
+		test.Equal(t, "watched", e.Topic)
+		test.Equal(t, "ch", e.Channel)
+	}
+
+	endpoint := fmt.Sprintf("http://%s/topic/tombstone?topic=watched&node=%s:%d",
+		httpAddr, HostAddr, HTTPPort)
+	err = http_api.NewClient(nil, ConnectTimeout, RequestTimeout).POSTV1(endpoint)
+	test.Nil(t, err)
+	for _, next := range []func() RegistrationEvent{nextHTTPEvent, nextTCPEvent} {
+		e := next()
+		test.Equal(t, EventTombstone, e.Type)
+		test.Equal(t, "watched", e.Topic)
+		test.Equal(t, "", e.Channel)
+	}
+
+	nsq.UnRegister("watched", "ch").WriteTo(conn)
+	_, err = nsq.ReadResponse(conn)
+	test.Nil(t, err)
+	for _, next := range []func() RegistrationEvent{nextHTTPEvent, nextTCPEvent} {
+		e := next()
+		test.Equal(t, EventUnregister, e.Type)
+		test.Equal(t, "watched", e.Topic)
+		test.Equal(t, "ch", e.Channel)
+	}
+
+	// the producer disconnecting unregisters it
+	conn.Close()
+	for _, next := range []func() RegistrationEvent{nextHTTPEvent, nextTCPEvent} {
+		e := next()
+		test.Equal(t, EventUnregister, e.Type)
+		test.Equal(t, "watched", e.Topic)
+	}
+}
+
+func identifyWithLabels(t *testing.T, conn net.Conn, address string, labels map[string]string) {
+	ci := make(map[string]interface{})
+	ci["tcp_port"] = TCPPort
+	ci["http_port"] = HTTPPort
+	ci["broadcast_address"] = address
+	ci["hostname"] = address
+	ci["version"] = NSQDVersion
+	ci["labels"] = labels
+	cmd, _ := nsq.Identify(ci)
+	_, err := cmd.WriteTo(conn)
+	test.Nil(t, err)
+	_, err = nsq.ReadResponse(conn)
+	test.Nil(t, err)
+}
+
+func TestLookupLabels(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	tcpAddr, httpAddr, nsqlookupd := mustStartLookupd(opts)
+	defer nsqlookupd.Exit()
+
+	tmpDir, err := os.MkdirTemp("", "nsq-test-")
+	test.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+	nsqdOpts := nsqd.NewOptions()
+	nsqdOpts.Logger = opts.Logger
+	nsqdOpts.TCPAddress = "127.0.0.1:0"
+	nsqdOpts.HTTPAddress = "127.0.0.1:0"
+	nsqdOpts.BroadcastAddress = "local"
+	nsqdOpts.NSQLookupdTCPAddresses = []string{tcpAddr.String()}
+	nsqdOpts.Labels = []string{"zone=a", "role=edge"}
+	nsqdOpts.DataPath = tmpDir
+	nsqd1, err := nsqd.New(nsqdOpts)
+	test.Nil(t, err)
+	go nsqd1.Main()
+	defer nsqd1.Exit()
+
+	for address, labels := range map[string]map[string]string{
+		"same-zone":  {"zone": "a"},
+		"other-zone": {"zone": "b", "role": "edge"},
+		"unlabeled":  nil,
+	} {
+		conn := mustConnectLookupd(t, tcpAddr)
+		defer conn.Close()
+		identifyWithLabels(t, conn, address, labels)
+		nsq.Register("labeled", "").WriteTo(conn)
+		_, err = nsq.ReadResponse(conn)
+		test.Nil(t, err)
+	}
+
+	nsqd1.GetTopic("labeled")
+
+	lookup := func(query string) []string {
+		lr := LookupDoc{}
+		endpoint := fmt.Sprintf("http://%s/lookup?topic=labeled&%s", httpAddr, query)
+		err := http_api.NewClient(nil, ConnectTimeout, RequestTimeout).GETV1(endpoint, &lr)
+		test.Nil(t, err)
+		var addresses []string
+		for _, p := range lr.Producers {
+			addresses = append(addresses, p.BroadcastAddress)
+		}
+		return addresses
+	}
+
+	for i := 0; i < 100; i++ {
+		if len(lookup("")) == 4 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	test.Equal(t, 4, len(lookup("")))
+
+	addresses := lookup("selector=role%3Dedge")
+	test.Equal(t, 2, len(addresses))
+	addresses = lookup("selector=zone%3Da,role%21%3Dedge")
+	test.Equal(t, []string{"same-zone"}, addresses)
+	addresses = lookup("selector=%21zone")
+	test.Equal(t, []string{"unlabeled"}, addresses)
+	addresses = lookup("selector=role,zone%21%3Db")
+	test.Equal(t, []string{"local"}, addresses)
+
+	addresses = lookup("prefer_host=local&prefer_zone=a")
+	test.Equal(t, 4, len(addresses))
+	test.Equal(t, "local", addresses[0])
+	test.Equal(t, "same-zone", addresses[1])
+
+	addresses = lookup("prefer_host=local&prefer_zone=a&preferred_only=true")
+	test.Equal(t, []string{"local"}, addresses)
+	addresses = lookup("prefer_host=elsewhere&prefer_zone=a&preferred_only=true")
+	test.Equal(t, 2, len(addresses))
+	addresses = lookup("prefer_zone=c&preferred_only=true")
+	test.Equal(t, 4, len(addresses))
+
+	lr := LookupDoc{}
+	endpoint := fmt.Sprintf("http://%s/lookup?topic=labeled&selector=%%3Dx", httpAddr)
+	err = http_api.NewClient(nil, ConnectTimeout, RequestTimeout).GETV1(endpoint, &lr)
+	test.NotNil(t, err)
+}
+
+func TestTLSAndAuthSecret(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.TLSCert = "../nsqd/test/certs/server.pem"
+	opts.TLSKey = "../nsqd/test/certs/server.key"
+	opts.TLSClientAuthPolicy = "require-verify"
+	opts.TLSRootCAFile = "../nsqd/test/certs/ca.pem"
+	opts.AuthSecret = "s3cret"
+	tcpAddr, _, nsqlookupd := mustStartLookupd(opts)
+	defer nsqlookupd.Exit()
+
+	tmpDir, err := os.MkdirTemp("", "nsq-test-")
+	test.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+	nsqdOpts := nsqd.NewOptions()
+	nsqdOpts.Logger = opts.Logger
+	nsqdOpts.TCPAddress = "127.0.0.1:0"
+	nsqdOpts.HTTPAddress = "127.0.0.1:0"
+	nsqdOpts.NSQLookupdTCPAddresses = []string{tcpAddr.String()}
+	nsqdOpts.LookupdTLS = true
+	nsqdOpts.LookupdTLSRootCAFile = "../nsqd/test/certs/ca.pem"
+	nsqdOpts.LookupdTLSCert = "../nsqd/test/certs/client.pem"
+	nsqdOpts.LookupdTLSKey = "../nsqd/test/certs/client.key"
+	nsqdOpts.LookupdAuthSecret = "s3cret"
+	nsqdOpts.DataPath = tmpDir
+	nsqd1, err := nsqd.New(nsqdOpts)
+	test.Nil(t, err)
+	go nsqd1.Main()
+	defer nsqd1.Exit()
+
+	nsqd1.GetTopic("secure_topic")
+	for i := 0; i < 100; i++ {
+		if len(nsqlookupd.DB.FindProducers("topic", "secure_topic", "")) == 1 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	test.Equal(t, 1, len(nsqlookupd.DB.FindProducers("topic", "secure_topic", "")))
+
+	// plain TCP is refused
+	conn := mustConnectLookupd(t, tcpAddr)
+	defer conn.Close()
+	cmd, _ := nsq.Identify(map[string]interface{}{})
+	cmd.WriteTo(conn)
+	_, err = nsq.ReadResponse(conn)
+	test.NotNil(t, err)
+
+	// so is a wrong secret
+	cert, err := tls.LoadX509KeyPair("../nsqd/test/certs/client.pem", "../nsqd/test/certs/client.key")
+	test.Nil(t, err)
+	tlsConn, err := tls.Dial("tcp", tcpAddr.String(), &tls.Config{
+		Certificates:       []tls.Certificate{cert},
+		InsecureSkipVerify: true,
+	})
+	test.Nil(t, err)
+	defer tlsConn.Close()
+	tlsConn.Write(nsq.MagicV1)
+	cmd, _ = nsq.Identify(map[string]interface{}{
+		"tcp_port":          TCPPort,
+		"http_port":         HTTPPort,
+		"broadcast_address": HostAddr,
+		"version":           NSQDVersion,
+		"auth_secret":       "wrong",
+	})
+	cmd.WriteTo(tlsConn)
+	data, err := nsq.ReadResponse(tlsConn)
+	test.Nil(t, err)
+	test.Equal(t, "E_UNAUTHORIZED IDENTIFY invalid auth_secret", string(data))
+
+	// and WATCH without IDENTIFY
+	watchConn, err := tls.Dial("tcp", tcpAddr.String(), &tls.Config{
+		Certificates:       []tls.Certificate{cert},
+		InsecureSkipVerify: true,
+	})
+	test.Nil(t, err)
+	defer watchConn.Close()
+	watchConn.Write(nsq.MagicV1)
+	watchConn.Write([]byte("WATCH secure_topic\n"))
+	data, err = nsq.ReadResponse(watchConn)
+	test.Nil(t, err)
+	test.Equal(t, "E_UNAUTHORIZED WATCH requires an authenticated IDENTIFY", string(data))
+}
+
+func TestHTTPAdminToken(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	opts.HTTPAdminToken = "t0ken"
+	_, httpAddr, nsqlookupd := mustStartLookupd(opts)
+	defer nsqlookupd.Exit()
+
+	client := http_api.NewClient(nil, ConnectTimeout, RequestTimeout)
+	endpoint := fmt.Sprintf("http://%s/topic/create?topic=admin_topic", httpAddr)
+	err := client.POSTV1(endpoint)
+	test.NotNil(t, err)
+	err = client.POSTV1WithHeader(endpoint, http.Header{"Authorization": []string{"Bearer wrong"}})
+	test.NotNil(t, err)
+	test.Equal(t, 0, len(nsqlookupd.DB.FindRegistrations("topic", "admin_topic", "")))
+
+	// reads do not need the token
+	endpoint = fmt.Sprintf("http://%s/topics", httpAddr)
+	err = client.GETV1(endpoint, &TopicsDoc{})
+	test.Nil(t, err)
+
+	// except for the snapshot of every registration
+	endpoint = fmt.Sprintf("http://%s/snapshot", httpAddr)
+	err = client.GETV1(endpoint, &Snapshot{})
+	test.NotNil(t, err)
+	err = client.GETV1WithHeader(endpoint, http.Header{"Authorization": []string{"Bearer t0ken"}}, &Snapshot{})
+	test.Nil(t, err)
+
+	ci := clusterinfo.New(nil, client)
+	ci.SetLookupdAdminToken("t0ken")
+	err = ci.CreateTopicChannel("admin_topic", "ch", []string{httpAddr.String()})
+	test.Nil(t, err)
+	test.Equal(t, 1, len(nsqlookupd.DB.FindRegistrations("topic", "admin_topic", "")))
+	test.Equal(t, 1, len(nsqlookupd.DB.FindRegistrations("channel", "admin_topic", "ch")))
+}
+
+func TestProvisioning(t *testing.T) {
+	opts := NewOptions()
+	opts.Logger = test.NewTestLogger(t)
+	tcpAddr, httpAddr, nsqlookupd := mustStartLookupd(opts)
+	defer nsqlookupd.Exit()
+
+	client := http_api.NewClient(nil, ConnectTimeout, RequestTimeout)
+	for _, uri := range []string{
+		"topic/create?topic=everywhere",
+		"channel/create?topic=zoned&channel=ch&selector=zone%3Da",
+		"topic/create?topic=elsewhere&selector=zone%3Db",
+	} {
+		err := client.POSTV1(fmt.Sprintf("http://%s/%s", httpAddr, uri))
+		test.Nil(t, err)
+	}
+	err := client.POSTV1(fmt.Sprintf("http://%s/topic/create?topic=invalid&selector=%%3Da", httpAddr))
+	test.NotNil(t, err)
+
+	// provisioning is replicated to peers
+	db := NewRegistrationDB()
+	db.Merge(nsqlookupd.DB.Snapshot(true), "peer")
+	test.Equal(t, 2, len(db.FindProvisioned(map[string]string{"zone": "a"})))
+	test.Equal(t, 1, len(db.FindProvisioned(nil)))
+
+	tmpDir, err := os.MkdirTemp("", "nsq-test-")
+	test.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+	nsqdOpts := nsqd.NewOptions()
+	nsqdOpts.Logger = opts.Logger
+	nsqdOpts.TCPAddress = "127.0.0.1:0"
+	nsqdOpts.HTTPAddress = "127.0.0.1:0"
+	nsqdOpts.NSQLookupdTCPAddresses = []string{tcpAddr.String()}
+	nsqdOpts.Labels = []string{"zone=a"}
+	nsqdOpts.ProvisionFromLookupd = true
+	nsqdOpts.DataPath = tmpDir
+	nsqd1, err := nsqd.New(nsqdOpts)
+	test.Nil(t, err)
+	go nsqd1.Main()
+	defer nsqd1.Exit()
+
+	provisioned := func() error {
+		_, err := nsqd1.GetExistingTopic("everywhere")
+		if err != nil {
+			return err
+		}
+		topic, err := nsqd1.GetExistingTopic("zoned")
+		if err != nil {
+			return err
+		}
+		_, err = topic.GetExistingChannel("ch")
+		return err
+	}
+	for i := 0; i < 100; i++ {
+		if provisioned() == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	test.Nil(t, provisioned())
+	_, err = nsqd1.GetExistingTopic("elsewhere")
+	test.NotNil(t, err)
+}
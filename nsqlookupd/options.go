@@ -16,9 +16,23 @@ type Options struct {
 	TCPAddress       string `flag:"tcp-address"`
 	HTTPAddress      string `flag:"http-address"`
 	BroadcastAddress string `flag:"broadcast-address"`
+	UseUnixSockets   bool   `flag:"use-unix-sockets"`
 
 	InactiveProducerTimeout time.Duration `flag:"inactive-producer-timeout"`
 	TombstoneLifetime       time.Duration `flag:"tombstone-lifetime"`
+
+	// TLS config of the TCP listener
+	TLSCert             string `flag:"tls-cert"`
+	TLSKey              string `flag:"tls-key"`
+	TLSClientAuthPolicy string `flag:"tls-client-auth-policy"`
+	TLSRootCAFile       string `flag:"tls-root-ca-file"`
+
+	AuthSecret     string `flag:"auth-secret"`
+	HTTPAdminToken string `flag:"http-admin-token"`
+
+	DataPath          string        `flag:"data-path"`
+	SnapshotInterval  time.Duration `flag:"snapshot-interval"`
+	PeerHTTPAddresses []string      `flag:"peer-http-address" cfg:"peer_http_addresses"`
 }
 
 func NewOptions() *Options {
@@ -36,5 +50,8 @@ func NewOptions() *Options {
 
 		InactiveProducerTimeout: 300 * time.Second,
 		TombstoneLifetime:       45 * time.Second,
+
+		SnapshotInterval:  10 * time.Second,
+		PeerHTTPAddresses: make([]string, 0),
 	}
 }
@@ -10,6 +10,17 @@ import (
 type RegistrationDB struct {
 	sync.RWMutex
 	registrationMap map[Registration]ProducerMap
+
+	// when registrations were added and removed (in nanoseconds), so that
+	// snapshots from peers do not resurrect removed registrations
+	createdAt map[Registration]int64
+	deletedAt map[Registration]int64
+
+	// registrations created through the HTTP API, to be provisioned on the
+	// nsqds whose labels match the selector
+	provisioned map[Registration]string
+
+	watchers map[*Watcher]struct{}
 }
 
 type Registration struct {
@@ -20,14 +31,20 @@ type Registration struct {
 type Registrations []Registration
 
 type PeerInfo struct {
-	lastUpdate       int64
-	id               string
-	RemoteAddress    string `json:"remote_address"`
-	Hostname         string `json:"hostname"`
-	BroadcastAddress string `json:"broadcast_address"`
-	TCPPort          int    `json:"tcp_port"`
-	HTTPPort         int    `json:"http_port"`
-	Version          string `json:"version"`
+	lastUpdate int64
+	id         string
+	// source is empty for producers connected to this nsqlookupd, otherwise
+	// it is the snapshot or peer the producer was learned from
+	source           string
+	RemoteAddress    string            `json:"remote_address"`
+	Hostname         string            `json:"hostname"`
+	BroadcastAddress string            `json:"broadcast_address"`
+	TCPPort          int               `json:"tcp_port"`
+	HTTPPort         int               `json:"http_port"`
+	TCPSocket        string            `json:"tcp_socket,omitempty"`
+	HTTPSocket       string            `json:"http_socket,omitempty"`
+	Labels           map[string]string `json:"labels,omitempty"`
+	Version          string            `json:"version"`
 }
 
 type Producer struct {
@@ -43,6 +60,11 @@ func (p *Producer) String() string {
 	return fmt.Sprintf("%s [%d, %d]", p.peerInfo.BroadcastAddress, p.peerInfo.TCPPort, p.peerInfo.HTTPPort)
 }
 
+// node identifies the nsqd of a producer regardless of its connection
+func (p *PeerInfo) node() string {
+	return fmt.Sprintf("%s:%d:%s", p.BroadcastAddress, p.TCPPort, p.TCPSocket)
+}
+
 func (p *Producer) Tombstone() {
 	p.tombstoned = true
 	p.tombstonedAt = time.Now()
@@ -55,31 +77,65 @@ func (p *Producer) IsTombstoned(lifetime time.Duration) bool {
 func NewRegistrationDB() *RegistrationDB {
 	return &RegistrationDB{
 		registrationMap: make(map[Registration]ProducerMap),
+		createdAt:       make(map[Registration]int64),
+		deletedAt:       make(map[Registration]int64),
+		provisioned:     make(map[Registration]string),
+		watchers:        make(map[*Watcher]struct{}),
+	}
+}
+
+// this expects the caller to handle locking
+func (r *RegistrationDB) addRegistration(k Registration) ProducerMap {
+	producers, ok := r.registrationMap[k]
+	if !ok {
+		producers = make(map[string]*Producer)
+		r.registrationMap[k] = producers
+		r.createdAt[k] = time.Now().UnixNano()
+		delete(r.deletedAt, k)
 	}
+	return producers
 }
 
 // add a registration key
 func (r *RegistrationDB) AddRegistration(k Registration) {
 	r.Lock()
 	defer r.Unlock()
-	_, ok := r.registrationMap[k]
-	if !ok {
-		r.registrationMap[k] = make(map[string]*Producer)
+	r.addRegistration(k)
+}
+
+// ProvisionRegistration adds a registration key to be provisioned on the nsqds
+// matching selector (all of them when it is empty)
+func (r *RegistrationDB) ProvisionRegistration(k Registration, selector string) {
+	r.Lock()
+	defer r.Unlock()
+	r.addRegistration(k)
+	r.provisioned[k] = selector
+}
+
+// FindProvisioned returns the registrations to provision on an nsqd with labels
+func (r *RegistrationDB) FindProvisioned(labels map[string]string) Registrations {
+	r.RLock()
+	defer r.RUnlock()
+	results := Registrations{}
+	for k, s := range r.provisioned {
+		selector, err := parseLabelSelector(s)
+		if err != nil || !selector.Matches(labels) {
+			continue
+		}
+		results = append(results, k)
 	}
+	return results
 }
 
 // add a producer to a registration
 func (r *RegistrationDB) AddProducer(k Registration, p *Producer) bool {
 	r.Lock()
 	defer r.Unlock()
-	_, ok := r.registrationMap[k]
-	if !ok {
-		r.registrationMap[k] = make(map[string]*Producer)
-	}
-	producers := r.registrationMap[k]
+	producers := r.addRegistration(k)
 	_, found := producers[p.peerInfo.id]
 	if !found {
 		producers[p.peerInfo.id] = p
+		r.notify(EventRegister, k, p)
 	}
 	return !found
 }
@@ -93,8 +149,9 @@ func (r *RegistrationDB) RemoveProducer(k Registration, id string) (bool, int) {
 		return false, 0
 	}
 	removed := false
-	if _, exists := producers[id]; exists {
+	if p, exists := producers[id]; exists {
 		removed = true
+		r.notify(EventUnregister, k, p)
 	}
 
 	// Note: this leaves keys in the DB even if they have empty lists
@@ -106,7 +163,77 @@ func (r *RegistrationDB) RemoveProducer(k Registration, id string) (bool, int) {
 func (r *RegistrationDB) RemoveRegistration(k Registration) {
 	r.Lock()
 	defer r.Unlock()
+	r.removeRegistration(k, time.Now().UnixNano())
+}
+
+// this expects the caller to handle locking
+func (r *RegistrationDB) removeRegistration(k Registration, deletedAt int64) {
+	for _, p := range r.registrationMap[k] {
+		r.notify(EventUnregister, k, p)
+	}
 	delete(r.registrationMap, k)
+	delete(r.createdAt, k)
+	delete(r.provisioned, k)
+	if deletedAt > r.deletedAt[k] {
+		r.deletedAt[k] = deletedAt
+	}
+}
+
+// TombstoneProducer tombstones the producer of a registration
+func (r *RegistrationDB) TombstoneProducer(k Registration, p *Producer) {
+	r.Lock()
+	defer r.Unlock()
+	p.Tombstone()
+	r.notify(EventTombstone, k, p)
+}
+
+// RemoveStaleProducers removes the producers learned from a snapshot or a peer
+// for the same nsqd as peerInfo, which is now connected. It returns the
+// registrations in which one of them was tombstoned, so that the tombstones
+// can be carried over.
+func (r *RegistrationDB) RemoveStaleProducers(peerInfo *PeerInfo) map[Registration]time.Time {
+	r.Lock()
+	defer r.Unlock()
+	node := peerInfo.node()
+	tombstones := make(map[Registration]time.Time)
+	for k, producers := range r.registrationMap {
+		for id, p := range producers {
+			if p.peerInfo.source == "" || p.peerInfo.node() != node {
+				continue
+			}
+			if p.tombstoned {
+				tombstones[k] = p.tombstonedAt
+			}
+			delete(producers, id)
+			r.notify(EventUnregister, k, p)
+		}
+	}
+	return tombstones
+}
+
+// RemoveExpiredProducers removes the producers learned from a snapshot or a
+// peer that were not updated for inactivityTimeout, and forgets about removed
+// registrations after the same duration
+func (r *RegistrationDB) RemoveExpiredProducers(inactivityTimeout time.Duration) int {
+	r.Lock()
+	defer r.Unlock()
+	cutoff := time.Now().Add(-inactivityTimeout).UnixNano()
+	count := 0
+	for k, producers := range r.registrationMap {
+		for id, p := range producers {
+			if p.peerInfo.source != "" && atomic.LoadInt64(&p.peerInfo.lastUpdate) < cutoff {
+				delete(producers, id)
+				r.notify(EventUnregister, k, p)
+				count++
+			}
+		}
+	}
+	for k, deletedAt := range r.deletedAt {
+		if deletedAt < cutoff {
+			delete(r.deletedAt, k)
+		}
+	}
+	return count
 }
 
 func (r *RegistrationDB) needFilter(key string, subkey string) bool {
@@ -12,9 +12,9 @@ import (
 func TestRegistrationDB(t *testing.T) {
 	sec30 := 30 * time.Second
 	beginningOfTime := time.Unix(1348797047, 0)
-	pi1 := &PeerInfo{beginningOfTime.UnixNano(), "1", "remote_addr:1", "host", "b_addr", 1, 2, "v1"}
-	pi2 := &PeerInfo{beginningOfTime.UnixNano(), "2", "remote_addr:2", "host", "b_addr", 2, 3, "v1"}
-	pi3 := &PeerInfo{beginningOfTime.UnixNano(), "3", "remote_addr:3", "host", "b_addr", 3, 4, "v1"}
+	pi1 := &PeerInfo{beginningOfTime.UnixNano(), "1", "", "remote_addr:1", "host", "b_addr", 1, 2, "", "", nil, "v1"}
+	pi2 := &PeerInfo{beginningOfTime.UnixNano(), "2", "", "remote_addr:2", "host", "b_addr", 2, 3, "", "", nil, "v1"}
+	pi3 := &PeerInfo{beginningOfTime.UnixNano(), "3", "", "remote_addr:3", "host", "b_addr", 3, 4, "", "", nil, "v1"}
 	p1 := &Producer{pi1, false, beginningOfTime}
 	p2 := &Producer{pi2, false, beginningOfTime}
 	p3 := &Producer{pi3, false, beginningOfTime}
@@ -216,3 +216,110 @@ func BenchmarkDoLookup512x512(b *testing.B) {
 func BenchmarkDoLookup512x2048(b *testing.B) {
 	benchmarkDoLookup(b, 512, 2048)
 }
+
+func TestRegistrationDBMerge(t *testing.T) {
+	now := time.Now().UnixNano()
+	topic := Registration{"topic", "t", ""}
+	deleted := Registration{"topic", "deleted", ""}
+	recreated := Registration{"topic", "recreated", ""}
+
+	peer := NewRegistrationDB()
+	connected := &PeerInfo{id: "1", BroadcastAddress: "a", TCPPort: 1, HTTPPort: 2, lastUpdate: now}
+	peer.AddProducer(topic, &Producer{peerInfo: connected})
+	peer.AddRegistration(deleted)
+	peer.AddRegistration(recreated)
+	peer.AddProducer(topic, &Producer{peerInfo: &PeerInfo{id: "2", source: "other", BroadcastAddress: "b"}})
+
+	db := NewRegistrationDB()
+	db.AddRegistration(deleted)
+	db.RemoveRegistration(deleted)
+	db.AddRegistration(recreated)
+	time.Sleep(time.Millisecond)
+	peer.RemoveRegistration(recreated)
+
+	db.Merge(peer.Snapshot(true), "peer")
+
+	// only producers connected to the peer are exported
+	producers := db.FindProducers("topic", "t", "")
+	test.Equal(t, 1, len(producers))
+	test.Equal(t, "peer", producers[0].peerInfo.source)
+	test.Equal(t, "a", producers[0].peerInfo.BroadcastAddress)
+	test.Equal(t, now, producers[0].peerInfo.lastUpdate)
+
+	// removed here after the peer created it
+	test.Equal(t, 0, len(db.FindRegistrations("topic", "deleted", "")))
+	// removed on the peer after it was created here
+	test.Equal(t, 0, len(db.FindRegistrations("topic", "recreated", "")))
+
+	// the peer's producers are replaced on every merge
+	peer.RemoveProducer(topic, "1")
+	db.Merge(peer.Snapshot(true), "peer")
+	test.Equal(t, 0, len(db.FindProducers("topic", "t", "")))
+
+	// once the nsqd connects the producers learned from the peer are removed
+	db.Merge(peer.Snapshot(false), "peer")
+	test.Equal(t, 1, len(db.FindProducers("topic", "t", "")))
+	db.FindProducers("topic", "t", "")[0].Tombstone()
+	tombstones := db.RemoveStaleProducers(&PeerInfo{id: "3", BroadcastAddress: "b"})
+	test.Equal(t, 0, len(db.FindProducers("topic", "t", "")))
+	test.Equal(t, 1, len(tombstones))
+
+	// and producers of connected nsqds are not imported
+	db.AddProducer(topic, &Producer{peerInfo: &PeerInfo{id: "3", BroadcastAddress: "b"}})
+	db.Merge(peer.Snapshot(false), "peer")
+	test.Equal(t, 1, len(db.FindProducers("topic", "t", "")))
+
+	// expired producers are removed, connected ones are not
+	db.Merge(&Snapshot{Registrations: []RegistrationSnapshot{{
+		Category:  "topic",
+		Key:       "t",
+		Producers: []ProducerSnapshot{{PeerInfo: PeerInfo{BroadcastAddress: "c"}, LastUpdate: 1}},
+	}}}, "peer")
+	test.Equal(t, 2, len(db.FindProducers("topic", "t", "")))
+	test.Equal(t, 1, db.RemoveExpiredProducers(time.Minute))
+	test.Equal(t, 1, len(db.FindProducers("topic", "t", "")))
+
+	// an nsqd learned from several sources is kept once, as last updated
+	snapshot := func(lastUpdate int64) *Snapshot {
+		return &Snapshot{Registrations: []RegistrationSnapshot{{
+			Category:  "topic",
+			Key:       "t",
+			CreatedAt: now,
+			Producers: []ProducerSnapshot{{PeerInfo: PeerInfo{BroadcastAddress: "d"}, LastUpdate: lastUpdate}},
+		}}}
+	}
+	db = NewRegistrationDB()
+	db.Merge(snapshot(now), snapshotSource)
+	db.Merge(snapshot(now), "peer1")
+	producers = db.FindProducers("topic", "t", "")
+	test.Equal(t, 1, len(producers))
+	test.Equal(t, "peer1", producers[0].peerInfo.source)
+	db.Merge(snapshot(now-1), "peer2")
+	db.Merge(snapshot(now+1), "peer3")
+	producers = db.FindProducers("topic", "t", "")
+	test.Equal(t, 1, len(producers))
+	test.Equal(t, "peer3", producers[0].peerInfo.source)
+	// until the nsqd connects
+	db.RemoveStaleProducers(&PeerInfo{id: "4", BroadcastAddress: "d"})
+	db.AddProducer(topic, &Producer{peerInfo: &PeerInfo{id: "4", BroadcastAddress: "d"}})
+	db.Merge(snapshot(now+2), "peer1")
+	producers = db.FindProducers("topic", "t", "")
+	test.Equal(t, 1, len(producers))
+	test.Equal(t, "", producers[0].peerInfo.source)
+}
+
+func TestWatchSlowConsumer(t *testing.T) {
+	db := NewRegistrationDB()
+	w := db.Watch("")
+	k := Registration{"topic", "t", ""}
+	for i := 0; i <= watcherBufferSize; i++ {
+		db.AddProducer(k, &Producer{peerInfo: &PeerInfo{id: strconv.Itoa(i)}})
+	}
+	n := 0
+	for range w.EventChan {
+		n++
+	}
+	test.Equal(t, watcherBufferSize, n)
+	test.Equal(t, 0, len(db.watchers))
+	db.Unwatch(w)
+}
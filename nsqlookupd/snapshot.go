@@ -0,0 +1,358 @@
+package nsqlookupd
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"path"
+	"sync/atomic"
+	"time"
+
+	"github.com/nsqio/nsq/internal/http_api"
+	"github.com/nsqio/nsq/internal/version"
+)
+
+const snapshotSource = "snapshot"
+
+// Snapshot is the state of a RegistrationDB as persisted to --data-path and
+// exchanged between peers
+type Snapshot struct {
+	Version       string                 `json:"version"`
+	Registrations []RegistrationSnapshot `json:"registrations"`
+	Deleted       []DeletedRegistration  `json:"deleted"`
+}
+
+type RegistrationSnapshot struct {
+	Category  string             `json:"category"`
+	Key       string             `json:"key"`
+	SubKey    string             `json:"subkey"`
+	CreatedAt int64              `json:"created_at"`
+	Producers []ProducerSnapshot `json:"producers"`
+
+	Provisioned bool   `json:"provisioned,omitempty"`
+	Selector    string `json:"selector,omitempty"`
+}
+
+type ProducerSnapshot struct {
+	PeerInfo
+	LastUpdate   int64 `json:"last_update"`
+	Tombstoned   bool  `json:"tombstoned"`
+	TombstonedAt int64 `json:"tombstoned_at"`
+}
+
+type DeletedRegistration struct {
+	Category  string `json:"category"`
+	Key       string `json:"key"`
+	SubKey    string `json:"subkey"`
+	DeletedAt int64  `json:"deleted_at"`
+}
+
+// Snapshot returns the registrations and their producers, when localOnly is
+// set only the producers connected to this nsqlookupd are included
+func (r *RegistrationDB) Snapshot(localOnly bool) *Snapshot {
+	r.RLock()
+	defer r.RUnlock()
+	s := &Snapshot{
+		Version:       version.Binary,
+		Registrations: []RegistrationSnapshot{},
+		Deleted:       []DeletedRegistration{},
+	}
+	for k, producers := range r.registrationMap {
+		rs := RegistrationSnapshot{
+			Category:  k.Category,
+			Key:       k.Key,
+			SubKey:    k.SubKey,
+			CreatedAt: r.createdAt[k],
+			Producers: []ProducerSnapshot{},
+		}
+		rs.Selector, rs.Provisioned = r.provisioned[k]
+		for _, p := range producers {
+			if localOnly && p.peerInfo.source != "" {
+				continue
+			}
+			ps := ProducerSnapshot{
+				PeerInfo:   *p.peerInfo,
+				LastUpdate: atomic.LoadInt64(&p.peerInfo.lastUpdate),
+				Tombstoned: p.tombstoned,
+			}
+			if p.tombstoned {
+				ps.TombstonedAt = p.tombstonedAt.UnixNano()
+			}
+			rs.Producers = append(rs.Producers, ps)
+		}
+		s.Registrations = append(s.Registrations, rs)
+	}
+	for k, deletedAt := range r.deletedAt {
+		s.Deleted = append(s.Deleted, DeletedRegistration{
+			Category:  k.Category,
+			Key:       k.Key,
+			SubKey:    k.SubKey,
+			DeletedAt: deletedAt,
+		})
+	}
+	return s
+}
+
+// Merge applies a snapshot learned from source (a peer or the persisted
+// snapshot) to the DB.
+//
+// A registration is added unless it was removed more recently than it was
+// created in the snapshot, and removed if it was removed in the snapshot more
+// recently than it was created here (as long as no connected producer has it).
+// The producers of source are replaced with those of the snapshot, except for
+// nsqds connected to this nsqlookupd. An nsqd learned from more than one source
+// is kept once per registration, as last updated.
+func (r *RegistrationDB) Merge(s *Snapshot, source string) {
+	r.Lock()
+	defer r.Unlock()
+
+	connected := make(map[string]bool)
+	for _, producers := range r.registrationMap {
+		for _, p := range producers {
+			if p.peerInfo.source == "" {
+				connected[p.peerInfo.node()] = true
+			}
+		}
+	}
+
+	for _, d := range s.Deleted {
+		k := Registration{d.Category, d.Key, d.SubKey}
+		producers, ok := r.registrationMap[k]
+		if !ok {
+			if d.DeletedAt > r.deletedAt[k] {
+				r.deletedAt[k] = d.DeletedAt
+			}
+			continue
+		}
+		if r.createdAt[k] >= d.DeletedAt {
+			continue
+		}
+		hasConnected := false
+		for _, p := range producers {
+			if p.peerInfo.source == "" {
+				hasConnected = true
+				break
+			}
+		}
+		if !hasConnected {
+			r.removeRegistration(k, d.DeletedAt)
+		}
+	}
+
+	// drop the producers previously learned from source, they are replaced
+	// (watchers are only notified of the differences)
+	previous := make(map[Registration]ProducerMap)
+	for k, producers := range r.registrationMap {
+		for id, p := range producers {
+			if p.peerInfo.source == source {
+				if previous[k] == nil {
+					previous[k] = make(ProducerMap)
+				}
+				previous[k][id] = p
+				delete(producers, id)
+			}
+		}
+	}
+
+	peerInfos := make(map[string]*PeerInfo)
+	for _, rs := range s.Registrations {
+		k := Registration{rs.Category, rs.Key, rs.SubKey}
+		producers, ok := r.registrationMap[k]
+		if !ok {
+			if r.deletedAt[k] >= rs.CreatedAt {
+				continue
+			}
+			producers = make(map[string]*Producer)
+			r.registrationMap[k] = producers
+			r.createdAt[k] = rs.CreatedAt
+		}
+		if _, ok := r.provisioned[k]; rs.Provisioned && !ok {
+			r.provisioned[k] = rs.Selector
+		}
+		// the producers learned from other sources, by node
+		learned := make(map[string]*Producer)
+		for _, p := range producers {
+			if p.peerInfo.source != "" {
+				learned[p.peerInfo.node()] = p
+			}
+		}
+		for _, ps := range rs.Producers {
+			node := ps.node()
+			if connected[node] {
+				continue
+			}
+			// peers win ties over the persisted snapshot
+			other, known := learned[node]
+			if known {
+				lastUpdate := atomic.LoadInt64(&other.peerInfo.lastUpdate)
+				if lastUpdate > ps.LastUpdate ||
+					(lastUpdate == ps.LastUpdate && other.peerInfo.source != snapshotSource) {
+					continue
+				}
+			}
+			peerInfo, ok := peerInfos[node]
+			if !ok {
+				pi := ps.PeerInfo
+				pi.id = source + "|" + node
+				pi.source = source
+				pi.lastUpdate = ps.LastUpdate
+				peerInfo = &pi
+				peerInfos[node] = peerInfo
+			}
+			// a producer might be known from more than one source
+			if _, exists := producers[peerInfo.id]; exists {
+				continue
+			}
+			p := &Producer{peerInfo: peerInfo}
+			if ps.Tombstoned {
+				p.tombstoned = true
+				p.tombstonedAt = time.Unix(0, ps.TombstonedAt)
+			}
+			producers[peerInfo.id] = p
+			learned[node] = p
+
+			old, existed := previous[k][peerInfo.id]
+			delete(previous[k], peerInfo.id)
+			if known {
+				// the same nsqd, now as learned from source
+				delete(producers, other.peerInfo.id)
+				old, existed = other, true
+			}
+			if !existed {
+				r.notify(EventRegister, k, p)
+			}
+			if p.tombstoned && (!existed || !old.tombstoned) {
+				r.notify(EventTombstone, k, p)
+			}
+		}
+	}
+
+	for k, producers := range previous {
+		for _, p := range producers {
+			r.notify(EventUnregister, k, p)
+		}
+	}
+}
+
+func newSnapshotFile(opts *Options) string {
+	return path.Join(opts.DataPath, "nsqlookupd.dat")
+}
+
+// LoadSnapshot restores the registrations persisted in --data-path
+func (l *NSQLookupd) LoadSnapshot() error {
+	fn := newSnapshotFile(l.opts)
+	data, err := os.ReadFile(fn)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // fresh start
+		}
+		return fmt.Errorf("failed to read snapshot from %s - %s", fn, err)
+	}
+
+	var s Snapshot
+	err = json.Unmarshal(data, &s)
+	if err != nil {
+		return fmt.Errorf("failed to parse snapshot - %s", err)
+	}
+	l.logf(LOG_INFO, "DB: restoring %d registrations from %s", len(s.Registrations), fn)
+	l.DB.Merge(&s, snapshotSource)
+	return nil
+}
+
+// PersistSnapshot writes the registrations to --data-path
+func (l *NSQLookupd) PersistSnapshot() error {
+	fileName := newSnapshotFile(l.opts)
+
+	l.logf(LOG_DEBUG, "DB: persisting snapshot to %s", fileName)
+
+	data, err := json.Marshal(l.DB.Snapshot(false))
+	if err != nil {
+		return err
+	}
+	tmpFileName := fmt.Sprintf("%s.%d.tmp", fileName, rand.Int())
+
+	err = writeSyncFile(tmpFileName, data)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, fileName)
+}
+
+func writeSyncFile(fn string, data []byte) error {
+	f, err := os.OpenFile(fn, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	return err
+}
+
+// syncPeers merges the snapshots of the --peer-http-address nsqlookupds, which
+// share the --http-admin-token
+func (l *NSQLookupd) syncPeers() {
+	var header http.Header
+	if l.opts.HTTPAdminToken != "" {
+		header = http.Header{"Authorization": []string{"Bearer " + l.opts.HTTPAdminToken}}
+	}
+	for _, addr := range l.opts.PeerHTTPAddresses {
+		var s Snapshot
+		endpoint := fmt.Sprintf("http://%s/snapshot", addr)
+		err := l.peerClient.GETV1WithHeader(endpoint, header, &s)
+		if err != nil {
+			l.logf(LOG_WARN, "PEER(%s): failed to sync - %s", addr, err)
+			continue
+		}
+		l.DB.Merge(&s, addr)
+	}
+}
+
+func (l *NSQLookupd) snapshotLoop() {
+	if len(l.opts.PeerHTTPAddresses) > 0 {
+		l.syncPeers()
+	}
+
+	ticker := time.NewTicker(l.opts.SnapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-l.exitChan:
+			goto exit
+		}
+
+		if len(l.opts.PeerHTTPAddresses) > 0 {
+			l.syncPeers()
+		}
+		if n := l.DB.RemoveExpiredProducers(l.opts.InactiveProducerTimeout); n > 0 {
+			l.logf(LOG_INFO, "DB: removed %d expired producers", n)
+		}
+		if l.opts.DataPath != "" {
+			l.snapshotMtx.Lock()
+			select {
+			case <-l.exitChan:
+				// Exit() already persisted the final snapshot
+			default:
+				err := l.PersistSnapshot()
+				if err != nil {
+					l.logf(LOG_ERROR, "failed to persist snapshot - %s", err)
+				}
+			}
+			l.snapshotMtx.Unlock()
+		}
+	}
+
+exit:
+	l.logf(LOG_INFO, "SNAPSHOT: closing")
+}
+
+// newPeerClient returns the client used to fetch snapshots from peers
+func newPeerClient() *http_api.Client {
+	return http_api.NewClient(nil, 2*time.Second, 5*time.Second)
+}
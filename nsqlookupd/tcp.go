@@ -44,14 +44,15 @@ func (p *tcpServer) Handle(conn net.Conn) {
 	}
 
 	client := prot.NewClient(conn)
-	p.conns.Store(conn.RemoteAddr(), client)
+	// UNIX socket clients all share the same (empty) remote address
+	p.conns.Store(conn, client)
 
 	err = prot.IOLoop(client)
 	if err != nil {
-		p.nsqlookupd.logf(LOG_ERROR, "client(%s) - %s", conn.RemoteAddr(), err)
+		p.nsqlookupd.logf(LOG_ERROR, "client(%s) - %s", client, err)
 	}
 
-	p.conns.Delete(conn.RemoteAddr())
+	p.conns.Delete(conn)
 	client.Close()
 }
 
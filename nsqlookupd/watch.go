@@ -0,0 +1,149 @@
+package nsqlookupd
+
+import (
+	"time"
+)
+
+const (
+	EventRegister   = "register"
+	EventUnregister = "unregister"
+	EventTombstone  = "tombstone"
+	EventHeartbeat  = "heartbeat"
+)
+
+// the number of events a watcher can fall behind before it is closed
+const watcherBufferSize = 1024
+
+const watchHeartbeatInterval = 30 * time.Second
+
+// RegistrationEvent is pushed to watchers when a producer registers or
+// unregisters a topic or a channel, or is tombstoned
+type RegistrationEvent struct {
+	Type     string    `json:"type"`
+	Topic    string    `json:"topic,omitempty"`
+	Channel  string    `json:"channel,omitempty"`
+	Producer *PeerInfo `json:"producer,omitempty"`
+}
+
+// Watcher receives the RegistrationEvents of a topic, or of all topics
+type Watcher struct {
+	topic string
+
+	// EventChan is closed when the watcher is removed, or when it fell more
+	// than watcherBufferSize events behind (the client should watch again)
+	EventChan chan RegistrationEvent
+}
+
+// Watch returns a Watcher for the events of topic, or of all topics when it
+// is empty
+func (r *RegistrationDB) Watch(topic string) *Watcher {
+	r.Lock()
+	defer r.Unlock()
+	w := &Watcher{
+		topic:     topic,
+		EventChan: make(chan RegistrationEvent, watcherBufferSize),
+	}
+	r.watchers[w] = struct{}{}
+	return w
+}
+
+// Unwatch removes a Watcher returned by Watch
+func (r *RegistrationDB) Unwatch(w *Watcher) {
+	r.Lock()
+	defer r.Unlock()
+	r.removeWatcher(w)
+}
+
+// this expects the caller to handle locking
+func (r *RegistrationDB) removeWatcher(w *Watcher) {
+	if _, ok := r.watchers[w]; ok {
+		delete(r.watchers, w)
+		close(w.EventChan)
+	}
+}
+
+// notify sends an event to the watchers, this expects the caller to hold the
+// write lock
+func (r *RegistrationDB) notify(eventType string, k Registration, p *Producer) {
+	if len(r.watchers) == 0 || (k.Category != "topic" && k.Category != "channel") {
+		return
+	}
+	e := RegistrationEvent{
+		Type:     eventType,
+		Topic:    k.Key,
+		Channel:  k.SubKey,
+		Producer: p.peerInfo,
+	}
+	for w := range r.watchers {
+		if w.topic != "" && w.topic != k.Key {
+			continue
+		}
+		select {
+		case w.EventChan <- e:
+		default:
+			// never block registrations on a slow watcher
+			r.removeWatcher(w)
+		}
+	}
+}
+
+// currentEvents returns register events for the active producers of topic (or
+// of all topics when it is empty), which watchers receive first
+func (l *NSQLookupd) currentEvents(topic string) []RegistrationEvent {
+	key := topic
+	if key == "" {
+		key = "*"
+	}
+	registrations := l.DB.FindRegistrations("topic", key, "")
+	registrations = append(registrations, l.DB.FindRegistrations("channel", key, "*")...)
+
+	var events []RegistrationEvent
+	for _, k := range registrations {
+		producers := l.DB.FindProducers(k.Category, k.Key, k.SubKey).FilterByActive(
+			l.opts.InactiveProducerTimeout, l.opts.TombstoneLifetime)
+		for _, p := range producers {
+			events = append(events, RegistrationEvent{
+				Type:     EventRegister,
+				Topic:    k.Key,
+				Channel:  k.SubKey,
+				Producer: p.peerInfo,
+			})
+		}
+	}
+	return events
+}
+
+// streamEvents writes the current producers of the watched topic followed by the events
+// of the watcher and periodic heartbeats, until done is closed, nsqlookupd
+// exits, the watcher falls behind, or a write fails
+func (l *NSQLookupd) streamEvents(w *Watcher, done <-chan struct{}, write func(RegistrationEvent) error) error {
+	for _, e := range l.currentEvents(w.topic) {
+		err := write(e)
+		if err != nil {
+			return err
+		}
+	}
+
+	ticker := time.NewTicker(watchHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		var e RegistrationEvent
+		select {
+		case event, ok := <-w.EventChan:
+			if !ok {
+				return nil
+			}
+			e = event
+		case <-ticker.C:
+			e = RegistrationEvent{Type: EventHeartbeat}
+		case <-done:
+			return nil
+		case <-l.exitChan:
+			return nil
+		}
+		err := write(e)
+		if err != nil {
+			return err
+		}
+	}
+}